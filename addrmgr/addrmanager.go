@@ -31,7 +31,8 @@ import (
 // peers on the bitcoin network.
 type AddrManager struct {
 	mtx            sync.RWMutex
-	peersFile      string
+	dataDir        string
+	peersFormat    PeersFormat
 	lookupFunc     func(string) ([]net.IP, error)
 	rand           *rand.Rand
 	key            [32]byte
@@ -46,6 +47,7 @@ type AddrManager struct {
 	nNew           int
 	lamtx          sync.Mutex
 	localAddresses map[string]*localAddress
+	seenLocal      map[string]*reportedAddress
 	version        int
 }
 
@@ -74,6 +76,13 @@ type localAddress struct {
 	score AddressPriority
 }
 
+// reportedAddress tracks the network groups of the peers that reported seeing
+// the node at an address which is not used as a local address yet.
+type reportedAddress struct {
+	groups   map[string]struct{}
+	lastSeen time.Time
+}
+
 // AddressPriority type is used to describe the hierarchy of local address
 // discovery methods.
 type AddressPriority int
@@ -150,13 +159,23 @@ const (
 
 	// getAddrMax is the most addresses that we will send in response
 	// to a getAddr (in practise the most addresses we will return from a
-	// call to AddressCache()).
-	getAddrMax = 2500
+	// call to AddressCache()).  It is limited to the number of addresses
+	// that fit in a single addr message.
+	getAddrMax = wire.MaxAddrPerMsg
 
 	// getAddrPercent is the percentage of total addresses known that we
 	// will share with a call to AddressCache.
 	getAddrPercent = 23
 
+	// maxLocalAddresses is the most local addresses reported by peers that
+	// will be tracked.
+	maxLocalAddresses = 64
+
+	// minLocalAddressReporters is the number of peers in distinct network
+	// groups that must report seeing the node at an address before it is
+	// used as a local address.
+	minLocalAddressReporters = 3
+
 	// serialisationVersion is the current version of the on-disk format.
 	serialisationVersion = 2
 )
@@ -278,13 +297,17 @@ func (a *AddrManager) expireNew(bucket int) {
 }
 
 // pickTried selects an address from the tried bucket to be evicted.
-// We just choose the eldest. Bitcoind selects 4 random entries and throws away
-// the older of them.
+// Addresses which are considered bad are evicted first, otherwise we just
+// choose the eldest. Bitcoind selects 4 random entries and throws away the
+// older of them.
 func (a *AddrManager) pickTried(bucket int) *list.Element {
 	var oldest *KnownAddress
 	var oldestElem *list.Element
 	for e := a.addrTried[bucket].Front(); e != nil; e = e.Next() {
 		ka := e.Value.(*KnownAddress)
+		if ka.isBad() {
+			return e
+		}
 		if oldest == nil || oldest.na.Timestamp.After(ka.na.Timestamp) {
 			oldestElem = e
 			oldest = ka
@@ -345,6 +368,7 @@ out:
 	for {
 		select {
 		case <-dumpAddressTicker.C:
+			a.compact()
 			a.savePeers()
 
 		case <-a.quit:
@@ -356,12 +380,106 @@ out:
 	log.Trace("Address handler done")
 }
 
+// compact removes the addresses in the new and tried buckets that are
+// considered bad.  New addresses are otherwise only expired from a bucket once
+// it is full and tried addresses only once another takes their place, so
+// without compacting the address set periodically the peers file of a long
+// running node accumulates large numbers of addresses that will never be
+// selected.
+//
+// At most one address is removed from each bucket per compaction.  The
+// addresses all appear bad after the node has been offline for a while since
+// they haven't been seen in that time, so evicting all of them at once would
+// empty the address set before the addresses had a chance to be refreshed by
+// connecting to peers again.
+func (a *AddrManager) compact() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	numNew, numTried := a.nNew, a.nTried
+	for i := range a.addrNew {
+		for k, v := range a.addrNew[i] {
+			if !v.isBad() {
+				continue
+			}
+			delete(a.addrNew[i], k)
+			v.refs--
+			if v.refs == 0 {
+				a.nNew--
+				delete(a.addrIndex, k)
+			}
+			break
+		}
+	}
+	for i := range a.addrTried {
+		for e := a.addrTried[i].Front(); e != nil; e = e.Next() {
+			ka := e.Value.(*KnownAddress)
+			if !ka.isBad() {
+				continue
+			}
+			a.addrTried[i].Remove(e)
+			a.nTried--
+			delete(a.addrIndex, NetAddressKey(ka.na))
+			break
+		}
+	}
+	if numNew != a.nNew || numTried != a.nTried {
+		log.Debugf("Compacted address set by removing %d new and %d "+
+			"tried bad addresses", numNew-a.nNew, numTried-a.nTried)
+	}
+}
+
+// peersFilePath returns the path of the file the known addresses are persisted
+// to in the passed format.
+func (a *AddrManager) peersFilePath(format PeersFormat) string {
+	return filepath.Join(a.dataDir, format.fileName())
+}
+
 // savePeers saves all the known addresses to a file so they can be read back
 // in at next run.
 func (a *AddrManager) savePeers() {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
+	peersFile := a.peersFilePath(a.peersFormat)
+	if err := a.writePeers(peersFile); err != nil {
+		log.Errorf("Failed to write file %s: %v", peersFile, err)
+	}
+}
+
+// writePeers saves all the known addresses to the file at the passed path in
+// the configured format.  The addresses are written to a temporary file which
+// then replaces the existing one so an interrupted write does not lose the
+// previously saved addresses.
+//
+// This function MUST be called with the address manager lock held.
+func (a *AddrManager) writePeers(peersFile string) error {
+	tmpFile := peersFile + ".tmp"
+	w, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	if a.peersFormat == PeersFormatBinary {
+		err = a.serializePeersBinary(w)
+	} else {
+		err = a.serializePeersJSON(w)
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return os.Rename(tmpFile, peersFile)
+}
+
+// serializePeersJSON writes all of the known addresses and the buckets they are
+// in to the passed writer as JSON.
+//
+// This function MUST be called with the address manager lock held.
+func (a *AddrManager) serializePeersJSON(w io.Writer) error {
 	// First we make a serialisable datastructure so we can encode it to
 	// json.
 	sam := new(serializedAddrManager)
@@ -405,17 +523,7 @@ func (a *AddrManager) savePeers() {
 		}
 	}
 
-	w, err := os.Create(a.peersFile)
-	if err != nil {
-		log.Errorf("Error opening file %s: %v", a.peersFile, err)
-		return
-	}
-	enc := json.NewEncoder(w)
-	defer w.Close()
-	if err := enc.Encode(&sam); err != nil {
-		log.Errorf("Failed to encode file %s: %v", a.peersFile, err)
-		return
-	}
+	return json.NewEncoder(w).Encode(&sam)
 }
 
 // loadPeers loads the known address from the saved file.  If empty, missing, or
@@ -424,19 +532,54 @@ func (a *AddrManager) loadPeers() {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
-	err := a.deserializePeers(a.peersFile)
+	// Load the addresses from the file for the other format when there is
+	// no file in the configured one so they are migrated to it.
+	format := a.peersFormat
+	peersFile := a.peersFilePath(format)
+	if _, err := os.Stat(peersFile); os.IsNotExist(err) {
+		otherFormat := PeersFormatBinary
+		if format == PeersFormatBinary {
+			otherFormat = PeersFormatJSON
+		}
+		otherFile := a.peersFilePath(otherFormat)
+		if _, err := os.Stat(otherFile); err == nil {
+			format, peersFile = otherFormat, otherFile
+		}
+	}
+
+	var err error
+	if format == PeersFormatBinary {
+		err = a.deserializePeersBinary(peersFile)
+	} else {
+		err = a.deserializePeers(peersFile)
+	}
 	if err != nil {
-		log.Errorf("Failed to parse file %s: %v", a.peersFile, err)
+		log.Errorf("Failed to parse file %s: %v", peersFile, err)
 		// if it is invalid we nuke the old one unconditionally.
-		err = os.Remove(a.peersFile)
+		err = os.Remove(peersFile)
 		if err != nil {
 			log.Warnf("Failed to remove corrupt peers file %s: %v",
-				a.peersFile, err)
+				peersFile, err)
 		}
 		a.reset()
 		return
 	}
-	log.Infof("Loaded %d addresses from file '%s'", a.numAddresses(), a.peersFile)
+	log.Infof("Loaded %d addresses from file '%s'", a.numAddresses(), peersFile)
+
+	if format == a.peersFormat {
+		return
+	}
+	newFile := a.peersFilePath(a.peersFormat)
+	if err := a.writePeers(newFile); err != nil {
+		log.Errorf("Failed to migrate addresses from %s to %s: %v",
+			peersFile, newFile, err)
+		return
+	}
+	if err := os.Remove(peersFile); err != nil {
+		log.Warnf("Failed to remove migrated peers file %s: %v",
+			peersFile, err)
+	}
+	log.Infof("Migrated addresses from '%s' to '%s'", peersFile, newFile)
 }
 
 func (a *AddrManager) deserializePeers(filePath string) error {
@@ -530,7 +673,14 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		}
 	}
 
-	// Sanity checking.
+	return a.checkAddrIndex()
+}
+
+// checkAddrIndex ensures every address loaded from the peers file is in either
+// a new or a tried bucket but not both.
+//
+// This function MUST be called with the address manager lock held.
+func (a *AddrManager) checkAddrIndex() error {
 	for k, v := range a.addrIndex {
 		if v.refs == 0 && !v.tried {
 			return fmt.Errorf("address %s after serialisation "+
@@ -660,26 +810,38 @@ func (a *AddrManager) NeedMoreAddresses() bool {
 	return a.numAddresses() < needAddressThreshold
 }
 
-// AddressCache returns the current address cache.  It must be treated as
+// AddressCache returns a randomized sample of the known addresses that are
+// not considered bad.  The sample is limited to getAddrPercent of all known
+// addresses and no more than getAddrMax addresses.  It must be treated as
 // read-only (but since it is a copy now, this is not as dangerous).
 func (a *AddrManager) AddressCache() []*wire.NetAddress {
-	allAddr := a.getAddresses()
-
-	numAddresses := len(allAddr) * getAddrPercent / 100
+	a.mtx.RLock()
+	numAddresses := len(a.addrIndex) * getAddrPercent / 100
 	if numAddresses > getAddrMax {
 		numAddresses = getAddrMax
 	}
+	goodAddrs := make([]*wire.NetAddress, 0, len(a.addrIndex))
+	for _, ka := range a.addrIndex {
+		if !ka.isBad() {
+			goodAddrs = append(goodAddrs, ka.na)
+		}
+	}
+	a.mtx.RUnlock()
+
+	if numAddresses > len(goodAddrs) {
+		numAddresses = len(goodAddrs)
+	}
 
 	// Fisher-Yates shuffle the array. We only need to do the first
 	// `numAddresses' since we are throwing the rest.
 	for i := 0; i < numAddresses; i++ {
 		// pick a number between current index and the end
-		j := rand.Intn(len(allAddr)-i) + i
-		allAddr[i], allAddr[j] = allAddr[j], allAddr[i]
+		j := rand.Intn(len(goodAddrs)-i) + i
+		goodAddrs[i], goodAddrs[j] = goodAddrs[j], goodAddrs[i]
 	}
 
 	// slice off the limit we are willing to share.
-	return allAddr[0:numAddresses]
+	return goodAddrs[0:numAddresses]
 }
 
 // getAddresses returns all of the addresses currently found within the
@@ -701,6 +863,114 @@ func (a *AddrManager) getAddresses() []*wire.NetAddress {
 	return addrs
 }
 
+// Stats houses statistics about the addresses known to an address manager.
+type Stats struct {
+	// NumNew is the number of addresses in the new buckets.
+	NumNew int
+
+	// NumTried is the number of addresses in the tried buckets.
+	NumTried int
+
+	// NewBucketsUsed is the number of new buckets holding at least one
+	// address out of the total number of new buckets.
+	NewBucketsUsed  int
+	NewBucketsTotal int
+
+	// TriedBucketsUsed is the number of tried buckets holding at least one
+	// address out of the total number of tried buckets.
+	TriedBucketsUsed  int
+	TriedBucketsTotal int
+}
+
+// Stats returns statistics about the addresses currently known to the address
+// manager and how they are spread over the new and tried buckets.
+func (a *AddrManager) Stats() Stats {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	stats := Stats{
+		NumNew:            a.nNew,
+		NumTried:          a.nTried,
+		NewBucketsTotal:   newBucketCount,
+		TriedBucketsTotal: triedBucketCount,
+	}
+	for i := range a.addrNew {
+		if len(a.addrNew[i]) > 0 {
+			stats.NewBucketsUsed++
+		}
+	}
+	for i := range a.addrTried {
+		if a.addrTried[i].Len() > 0 {
+			stats.TriedBucketsUsed++
+		}
+	}
+	return stats
+}
+
+// KnownAddressInfo is a snapshot of a known address along with the details the
+// address manager tracks to judge its quality.
+type KnownAddressInfo struct {
+	// Addr is the known address.  Its timestamp is the last time the
+	// address was seen on the network.
+	Addr wire.NetAddress
+
+	// Source is the address of the peer the address was learned from.
+	Source wire.NetAddress
+
+	// Tried is whether the address is in a tried bucket as opposed to one
+	// or more new buckets.
+	Tried bool
+
+	// Bad is whether the address is considered bad and is due to be
+	// evicted.
+	Bad bool
+
+	// Attempts is the number of connection attempts since the last
+	// successful one.
+	Attempts int
+
+	// LastAttempt and LastSuccess are the times of the last connection
+	// attempt and the last successful connection.  They are the zero time
+	// when there has not been one.
+	LastAttempt time.Time
+	LastSuccess time.Time
+}
+
+// KnownAddresses returns snapshots of up to the passed number of known
+// addresses selected at random.
+func (a *AddrManager) KnownAddresses(max int) []KnownAddressInfo {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	kas := make([]*KnownAddress, 0, len(a.addrIndex))
+	for _, ka := range a.addrIndex {
+		kas = append(kas, ka)
+	}
+	if max > len(kas) {
+		max = len(kas)
+	}
+
+	// Fisher-Yates shuffle the first max addresses since the rest are not
+	// returned.
+	infos := make([]KnownAddressInfo, 0, max)
+	for i := 0; i < max; i++ {
+		j := rand.Intn(len(kas)-i) + i
+		kas[i], kas[j] = kas[j], kas[i]
+
+		ka := kas[i]
+		infos = append(infos, KnownAddressInfo{
+			Addr:        *ka.na,
+			Source:      *ka.srcAddr,
+			Tried:       ka.tried,
+			Bad:         ka.isBad(),
+			Attempts:    ka.attempts,
+			LastAttempt: ka.lastattempt,
+			LastSuccess: ka.lastsuccess,
+		})
+	}
+	return infos
+}
+
 // reset resets the address manager by reinitialising the random source
 // and allocating fresh empty bucket storage.
 func (a *AddrManager) reset() {
@@ -845,7 +1115,10 @@ func (a *AddrManager) find(addr *wire.NetAddress) *KnownAddress {
 }
 
 // Attempt increases the given address' attempt counter and updates
-// the last attempt time.
+// the last attempt time.  The counter is reset by Good, so it tracks the
+// number of failed attempts since the last success which, along with the
+// attempt and success times, determines how likely the address is to be
+// selected and when it is considered bad and evicted.
 func (a *AddrManager) Attempt(addr *wire.NetAddress) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
@@ -1017,6 +1290,77 @@ func (a *AddrManager) AddLocalAddress(na *wire.NetAddress, priority AddressPrior
 	return nil
 }
 
+// SeenLocal records that the peer at the reporter address reported it sees the
+// node at the passed address.  The score of a known local address is increased
+// each time it is reported so the address most peers see the node at is
+// preferred over other equally reachable addresses.  Routable addresses that
+// are not known yet are added with the lowest priority once peers in enough
+// distinct network groups agree on them so the node is still able to advertise
+// itself when it has no other means of discovering its external address,
+// without a single peer being able to make it advertise an arbitrary address.
+func (a *AddrManager) SeenLocal(na, reporter *wire.NetAddress) error {
+	if !IsRoutable(na) {
+		return fmt.Errorf("address %s is not routable", na.IP)
+	}
+
+	a.lamtx.Lock()
+	defer a.lamtx.Unlock()
+
+	key := NetAddressKey(na)
+	if la, ok := a.localAddresses[key]; ok {
+		la.score++
+		return nil
+	}
+
+	// Track the network groups of the peers that reported the address until
+	// enough of them agree on it.  When too many addresses are tracked, the
+	// one with the fewest reporters is evicted, preferring the one reported
+	// least recently, so bogus reports can't prevent genuine ones from
+	// being tracked.
+	reported, ok := a.seenLocal[key]
+	if !ok {
+		if len(a.seenLocal) >= maxLocalAddresses {
+			a.evictReportedAddress()
+		}
+		reported = &reportedAddress{groups: make(map[string]struct{})}
+		a.seenLocal[key] = reported
+	}
+	reported.groups[GroupKey(reporter)] = struct{}{}
+	reported.lastSeen = time.Now()
+	if len(reported.groups) < minLocalAddressReporters {
+		return nil
+	}
+	delete(a.seenLocal, key)
+
+	if len(a.localAddresses) >= maxLocalAddresses {
+		return fmt.Errorf("too many local addresses to add %s", key)
+	}
+	a.localAddresses[key] = &localAddress{
+		na:    na,
+		score: InterfacePrio,
+	}
+	return nil
+}
+
+// evictReportedAddress removes the reported local address with the fewest
+// reporters from the tracked addresses.  Ties are broken by removing the
+// address that was reported least recently.
+//
+// This function MUST be called with the local address lock held.
+func (a *AddrManager) evictReportedAddress() {
+	var evictKey string
+	var evict *reportedAddress
+	for key, reported := range a.seenLocal {
+		if evict == nil || len(reported.groups) < len(evict.groups) ||
+			(len(reported.groups) == len(evict.groups) &&
+				reported.lastSeen.Before(evict.lastSeen)) {
+
+			evictKey, evict = key, reported
+		}
+	}
+	delete(a.seenLocal, evictKey)
+}
+
 // getReachabilityFrom returns the relative reachability of the provided local
 // address to the provided remote address.
 func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
@@ -1135,15 +1479,28 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 	return bestAddress
 }
 
-// New returns a new bitcoin address manager.
-// Use Start to begin processing asynchronous address updates.
+// SetPeersFormat sets the format the known addresses are persisted in.  When
+// the address manager is started and there is no file in the format yet, the
+// addresses are loaded from the file for the other format and migrated.  It
+// must be called before Start.
+func (a *AddrManager) SetPeersFormat(format PeersFormat) {
+	a.mtx.Lock()
+	a.peersFormat = format
+	a.mtx.Unlock()
+}
+
+// New returns a new bitcoin address manager that persists the known addresses
+// as JSON.  Use SetPeersFormat to select a different format and Start to begin
+// processing asynchronous address updates.
 func New(dataDir string, lookupFunc func(string) ([]net.IP, error)) *AddrManager {
 	am := AddrManager{
-		peersFile:      filepath.Join(dataDir, "peers.json"),
+		dataDir:        dataDir,
+		peersFormat:    PeersFormatJSON,
 		lookupFunc:     lookupFunc,
 		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
 		quit:           make(chan struct{}),
 		localAddresses: make(map[string]*localAddress),
+		seenLocal:      make(map[string]*reportedAddress),
 		version:        serialisationVersion,
 	}
 	am.reset()
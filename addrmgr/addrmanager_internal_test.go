@@ -5,7 +5,9 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/btcsuite/btcd/wire"
 )
@@ -37,6 +39,20 @@ func randAddr(t *testing.T) *wire.NetAddress {
 	}
 }
 
+// randRoutableAddr generates a *wire.NetAddress backed by a random routable
+// IPv4/IPv6 address for tests that need the address manager to keep the
+// addresses it is given.
+func randRoutableAddr(t *testing.T) *wire.NetAddress {
+	t.Helper()
+
+	for {
+		na := randAddr(t)
+		if IsRoutable(na) {
+			return na
+		}
+	}
+}
+
 // assertAddr ensures that the two addresses match. The timestamp is not
 // checked as it does not affect uniquely identifying a specific address.
 func assertAddr(t *testing.T, got, expected *wire.NetAddress) {
@@ -192,3 +208,446 @@ func TestAddrManagerV1ToV2(t *testing.T) {
 	addrMgr.loadPeers()
 	assertAddrs(t, addrMgr, expectedAddrs)
 }
+
+// assertBuckets ensures the two managers use the same key and have the same
+// addresses in each of their new and tried buckets.
+func assertBuckets(t *testing.T, got, want *AddrManager) {
+	t.Helper()
+
+	if got.key != want.key {
+		t.Fatalf("expected key %x, got %x", want.key, got.key)
+	}
+	if got.nNew != want.nNew || got.nTried != want.nTried {
+		t.Fatalf("expected %d new and %d tried addresses, got %d and %d",
+			want.nNew, want.nTried, got.nNew, got.nTried)
+	}
+	for i := range want.addrNew {
+		if len(got.addrNew[i]) != len(want.addrNew[i]) {
+			t.Fatalf("expected %d addresses in new bucket %d, got %d",
+				len(want.addrNew[i]), i, len(got.addrNew[i]))
+		}
+		for k := range want.addrNew[i] {
+			if _, ok := got.addrNew[i][k]; !ok {
+				t.Fatalf("expected %s in new bucket %d", k, i)
+			}
+		}
+	}
+	for i := range want.addrTried {
+		if got.addrTried[i].Len() != want.addrTried[i].Len() {
+			t.Fatalf("expected %d addresses in tried bucket %d, "+
+				"got %d", want.addrTried[i].Len(), i,
+				got.addrTried[i].Len())
+		}
+		e := got.addrTried[i].Front()
+		for w := want.addrTried[i].Front(); w != nil; w = w.Next() {
+			gotKey := NetAddressKey(e.Value.(*KnownAddress).na)
+			wantKey := NetAddressKey(w.Value.(*KnownAddress).na)
+			if gotKey != wantKey {
+				t.Fatalf("expected %s in tried bucket %d, got %s",
+					wantKey, i, gotKey)
+			}
+			e = e.Next()
+		}
+	}
+}
+
+// newLargeAddrManager returns an address manager backed by the passed
+// directory with a large number of random addresses, some of which have been
+// moved to the tried buckets.
+func newLargeAddrManager(t *testing.T, dir string, numAddrs int) *AddrManager {
+	t.Helper()
+
+	addrMgr := New(dir, nil)
+	for i := 0; i < numAddrs; i++ {
+		addr := randAddr(t)
+		addr.Timestamp = time.Now()
+		addrMgr.AddAddress(addr, randAddr(t))
+		if i%10 == 0 {
+			addrMgr.Good(addr)
+		}
+	}
+	return addrMgr
+}
+
+// TestAddrManagerBinarySerialization ensures a large address set round trips
+// through the binary peers database with the bucket assignments preserved and
+// that it is smaller than the JSON format.
+func TestAddrManagerBinarySerialization(t *testing.T) {
+	t.Parallel()
+
+	tempDir, err := ioutil.TempDir("", "addrmgr")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	const numAddrs = 20000
+	addrMgr := newLargeAddrManager(t, tempDir, numAddrs)
+	if addrMgr.nNew == 0 || addrMgr.nTried == 0 {
+		t.Fatalf("expected both new and tried addresses, got %d and %d",
+			addrMgr.nNew, addrMgr.nTried)
+	}
+
+	// Persist the addresses in both formats.
+	addrMgr.savePeers()
+	addrMgr.SetPeersFormat(PeersFormatBinary)
+	addrMgr.savePeers()
+
+	// Ensure the addresses round trip through both formats.
+	for _, format := range []PeersFormat{PeersFormatJSON, PeersFormatBinary} {
+		loaded := New(tempDir, nil)
+		loaded.SetPeersFormat(format)
+		loaded.loadPeers()
+		assertBuckets(t, loaded, addrMgr)
+	}
+
+	jsonInfo, err := os.Stat(filepath.Join(tempDir, "peers.json"))
+	if err != nil {
+		t.Fatalf("unable to stat JSON peers file: %v", err)
+	}
+	binaryInfo, err := os.Stat(filepath.Join(tempDir, "peers.dat"))
+	if err != nil {
+		t.Fatalf("unable to stat binary peers file: %v", err)
+	}
+	if binaryInfo.Size() >= jsonInfo.Size() {
+		t.Fatalf("expected binary format to be smaller than JSON, "+
+			"got %d vs %d bytes", binaryInfo.Size(), jsonInfo.Size())
+	}
+
+	// The binary format also preserves the details of each address that
+	// are lost with the JSON format.
+	loaded := New(tempDir, nil)
+	loaded.SetPeersFormat(PeersFormatBinary)
+	loaded.loadPeers()
+	for k, want := range addrMgr.addrIndex {
+		got := loaded.addrIndex[k]
+		if got.na.Timestamp.Unix() != want.na.Timestamp.Unix() ||
+			got.lastsuccess.Unix() != want.lastsuccess.Unix() ||
+			got.attempts != want.attempts {
+
+			t.Fatalf("expected address %s to be preserved", k)
+		}
+		assertAddr(t, got.na, want.na)
+		assertAddr(t, got.srcAddr, want.srcAddr)
+	}
+
+	// Ensure a corrupted file is rejected and removed rather than loaded.
+	peersFile := filepath.Join(tempDir, "peers.dat")
+	serialized, err := ioutil.ReadFile(peersFile)
+	if err != nil {
+		t.Fatalf("unable to read binary peers file: %v", err)
+	}
+	serialized[len(serialized)/2] ^= 0xff
+	if err := ioutil.WriteFile(peersFile, serialized, 0600); err != nil {
+		t.Fatalf("unable to write binary peers file: %v", err)
+	}
+	loaded = New(tempDir, nil)
+	loaded.SetPeersFormat(PeersFormatBinary)
+	loaded.loadPeers()
+	if loaded.numAddresses() != 0 {
+		t.Fatalf("expected no addresses from corrupted file, got %d",
+			loaded.numAddresses())
+	}
+	if _, err := os.Stat(peersFile); !os.IsNotExist(err) {
+		t.Fatalf("expected corrupted peers file to be removed")
+	}
+}
+
+// TestAddrManagerMigration ensures the addresses in a JSON peers file are
+// migrated to the binary peers database when that format is selected.
+func TestAddrManagerMigration(t *testing.T) {
+	t.Parallel()
+
+	tempDir, err := ioutil.TempDir("", "addrmgr")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	addrMgr := newLargeAddrManager(t, tempDir, 500)
+	addrMgr.savePeers()
+
+	migrated := New(tempDir, nil)
+	migrated.SetPeersFormat(PeersFormatBinary)
+	migrated.loadPeers()
+	assertBuckets(t, migrated, addrMgr)
+
+	jsonFile := filepath.Join(tempDir, "peers.json")
+	if _, err := os.Stat(jsonFile); !os.IsNotExist(err) {
+		t.Fatalf("expected migrated JSON peers file to be removed")
+	}
+
+	// The migrated addresses must now load from the binary database.
+	loaded := New(tempDir, nil)
+	loaded.SetPeersFormat(PeersFormatBinary)
+	loaded.loadPeers()
+	assertBuckets(t, loaded, addrMgr)
+}
+
+// TestAddrManagerCompact ensures compacting the address set removes the bad
+// new addresses while keeping the rest.
+func TestAddrManagerCompact(t *testing.T) {
+	t.Parallel()
+
+	addrMgr := New("", nil)
+
+	stale := randRoutableAddr(t)
+	stale.Timestamp = time.Now().Add(-2 * numMissingDays * 24 * time.Hour)
+	addrMgr.AddAddress(stale, randRoutableAddr(t))
+	fresh := randRoutableAddr(t)
+	fresh.Timestamp = time.Now()
+	addrMgr.AddAddress(fresh, randRoutableAddr(t))
+	if addrMgr.numAddresses() != 2 {
+		t.Fatalf("expected 2 addresses, got %d", addrMgr.numAddresses())
+	}
+
+	addrMgr.compact()
+	assertAddrs(t, addrMgr, map[string]*wire.NetAddress{
+		NetAddressKey(fresh): fresh,
+	})
+	if addrMgr.nNew != 1 {
+		t.Fatalf("expected 1 new address, got %d", addrMgr.nNew)
+	}
+}
+
+// TestAddrManagerFailureTracking ensures repeated connection failures make an
+// address less likely to be selected until it is considered bad and evicted,
+// while a successful connection resets its failure count.
+func TestAddrManagerFailureTracking(t *testing.T) {
+	t.Parallel()
+
+	addrMgr := New("", nil)
+	failing := randRoutableAddr(t)
+	failing.Timestamp = time.Now()
+	addrMgr.AddAddress(failing, randRoutableAddr(t))
+	recovered := randRoutableAddr(t)
+	recovered.Timestamp = time.Now()
+	addrMgr.AddAddress(recovered, randRoutableAddr(t))
+
+	// backdateAttempt moves the last attempt of the address to a few
+	// minutes ago as though the failed connection happened then.
+	backdateAttempt := func(addr *wire.NetAddress) {
+		addrMgr.find(addr).lastattempt = time.Now().Add(-15 * time.Minute)
+	}
+
+	// Each failure decreases the chance of the address being selected.
+	ka := addrMgr.find(failing)
+	lastChance := ka.chance()
+	for i := 1; i < numRetries; i++ {
+		addrMgr.Attempt(failing)
+		backdateAttempt(failing)
+		if ka.Attempts() != i {
+			t.Fatalf("expected %d attempts, got %d", i, ka.Attempts())
+		}
+		if ka.chance() >= lastChance {
+			t.Fatalf("expected chance to decrease after %d failures, "+
+				"got %f from %f", i, ka.chance(), lastChance)
+		}
+		lastChance = ka.chance()
+		if ka.isBad() {
+			t.Fatalf("address considered bad after %d failures", i)
+		}
+	}
+
+	// The address is bad once it reaches the retry limit without ever
+	// having succeeded, at which point it is a last resort for selection
+	// and evicted when the address set is compacted.
+	addrMgr.Attempt(failing)
+	backdateAttempt(failing)
+	if !ka.isBad() {
+		t.Fatalf("address not considered bad after %d failures",
+			numRetries)
+	}
+	if ka.chance() >= lastChance/100 {
+		t.Fatalf("expected bad address chance below %f, got %f",
+			lastChance/100, ka.chance())
+	}
+
+	// A success after failing resets the failure count and records the
+	// time of the success.
+	for i := 0; i < numRetries; i++ {
+		addrMgr.Attempt(recovered)
+	}
+	addrMgr.Good(recovered)
+	backdateAttempt(recovered)
+	rka := addrMgr.find(recovered)
+	if rka.Attempts() != 0 {
+		t.Fatalf("expected attempts to be reset, got %d",
+			rka.Attempts())
+	}
+	if time.Since(rka.LastSuccess()) > time.Minute {
+		t.Fatalf("expected recent last success, got %v",
+			rka.LastSuccess())
+	}
+	if rka.isBad() {
+		t.Fatal("address considered bad after a success")
+	}
+
+	addrMgr.compact()
+	if addrMgr.find(failing) != nil {
+		t.Fatal("expected failing address to be evicted")
+	}
+	if addrMgr.find(recovered) == nil {
+		t.Fatal("expected recovered address to be kept")
+	}
+	if addrMgr.nNew != 0 || addrMgr.nTried != 1 {
+		t.Fatalf("expected 0 new and 1 tried addresses, got %d and %d",
+			addrMgr.nNew, addrMgr.nTried)
+	}
+
+	// Tried addresses that keep failing are eventually evicted as well.
+	rka.lastsuccess = time.Now().Add(-2 * minBadDays * 24 * time.Hour)
+	for i := 0; i < maxFailures; i++ {
+		addrMgr.Attempt(recovered)
+	}
+	backdateAttempt(recovered)
+	addrMgr.compact()
+	if addrMgr.numAddresses() != 0 {
+		t.Fatalf("expected all addresses to be evicted, got %d",
+			addrMgr.numAddresses())
+	}
+}
+
+// TestPickTriedPrefersBad ensures a bad address is evicted from a full tried
+// bucket before the eldest one.
+func TestPickTriedPrefersBad(t *testing.T) {
+	t.Parallel()
+
+	addrMgr := New("", nil)
+	now := time.Now()
+	eldest := &KnownAddress{
+		na:          &wire.NetAddress{Timestamp: now.Add(-time.Hour)},
+		lastattempt: now.Add(-time.Hour),
+		lastsuccess: now.Add(-time.Hour),
+		tried:       true,
+	}
+	bad := &KnownAddress{
+		na:          &wire.NetAddress{Timestamp: now},
+		attempts:    maxFailures,
+		lastattempt: now.Add(-time.Hour),
+		lastsuccess: now.Add(-2 * minBadDays * 24 * time.Hour),
+		tried:       true,
+	}
+	addrMgr.addrTried[0].PushBack(eldest)
+	addrMgr.addrTried[0].PushBack(bad)
+
+	if got := addrMgr.pickTried(0).Value.(*KnownAddress); got != bad {
+		t.Fatal("expected the bad address to be picked for eviction")
+	}
+	addrMgr.addrTried[0].Remove(addrMgr.addrTried[0].Back())
+	if got := addrMgr.pickTried(0).Value.(*KnownAddress); got != eldest {
+		t.Fatal("expected the eldest address to be picked for eviction")
+	}
+}
+
+// TestAddressCacheExcludesBad ensures the addresses shared in response to a
+// getaddr request are a bounded, randomized sample that never includes
+// addresses considered bad.
+func TestAddressCacheExcludesBad(t *testing.T) {
+	t.Parallel()
+
+	addrMgr := New("", nil)
+	const numAddrs = 5000
+	now := time.Now()
+	bad := make(map[string]struct{})
+	for i := 0; i < numAddrs; i++ {
+		na := &wire.NetAddress{
+			IP:        net.IPv4(60, byte(i/256), byte(i%256), 1),
+			Port:      8333,
+			Timestamp: now,
+		}
+		ka := &KnownAddress{na: na, srcAddr: na}
+		key := NetAddressKey(na)
+		if i%2 == 0 {
+			ka.attempts = numRetries
+			ka.lastattempt = now.Add(-time.Hour)
+			bad[key] = struct{}{}
+		}
+		addrMgr.addrIndex[key] = ka
+	}
+
+	cache := addrMgr.AddressCache()
+	if len(cache) != getAddrMax {
+		t.Fatalf("unexpected number of addresses - got %d, want %d",
+			len(cache), getAddrMax)
+	}
+	for _, na := range cache {
+		if _, ok := bad[NetAddressKey(na)]; ok {
+			t.Fatalf("bad address %v included in address cache",
+				NetAddressKey(na))
+		}
+	}
+
+	// The sample is randomized, so two consecutive samples are expected to
+	// differ.
+	other := addrMgr.AddressCache()
+	same := true
+	for i := range cache {
+		if NetAddressKey(cache[i]) != NetAddressKey(other[i]) {
+			same = false
+			break
+		}
+	}
+	if same {
+		t.Fatal("address cache samples are not randomized")
+	}
+}
+
+// TestAddrManagerCompactLimit ensures compacting the address set removes at
+// most one bad address from each new and tried bucket so the addresses are not
+// all evicted at once after the node has been offline.
+func TestAddrManagerCompactLimit(t *testing.T) {
+	t.Parallel()
+
+	// Add new and tried addresses that were last seen before the node went
+	// offline over a month ago to the first two new and tried buckets.
+	addrMgr := New("", nil)
+	lastSeen := time.Now().Add(-2 * numMissingDays * 24 * time.Hour)
+	const perBucket = 3
+	for bucket := 0; bucket < 2; bucket++ {
+		for i := 0; i < perBucket; i++ {
+			na := randRoutableAddr(t)
+			na.Timestamp = lastSeen
+			ka := &KnownAddress{na: na, srcAddr: na, refs: 1}
+			addrMgr.addrNew[bucket][NetAddressKey(na)] = ka
+			addrMgr.addrIndex[NetAddressKey(na)] = ka
+			addrMgr.nNew++
+
+			na = randRoutableAddr(t)
+			na.Timestamp = lastSeen
+			ka = &KnownAddress{
+				na:          na,
+				lastattempt: lastSeen,
+				lastsuccess: lastSeen,
+				tried:       true,
+			}
+			addrMgr.addrTried[bucket].PushBack(ka)
+			addrMgr.addrIndex[NetAddressKey(na)] = ka
+			addrMgr.nTried++
+		}
+	}
+
+	for i := 1; i <= perBucket; i++ {
+		addrMgr.compact()
+		want := perBucket - i
+		for bucket := 0; bucket < 2; bucket++ {
+			if got := len(addrMgr.addrNew[bucket]); got != want {
+				t.Fatalf("compaction %d: expected %d addresses in "+
+					"new bucket %d, got %d", i, want, bucket, got)
+			}
+			if got := addrMgr.addrTried[bucket].Len(); got != want {
+				t.Fatalf("compaction %d: expected %d addresses in "+
+					"tried bucket %d, got %d", i, want, bucket,
+					got)
+			}
+		}
+		if addrMgr.nNew != 2*want || addrMgr.nTried != 2*want ||
+			len(addrMgr.addrIndex) != 4*want {
+
+			t.Fatalf("compaction %d: expected %d new and tried "+
+				"addresses, got %d new and %d tried with %d "+
+				"indexed", i, 2*want, addrMgr.nNew, addrMgr.nTried,
+				len(addrMgr.addrIndex))
+		}
+	}
+}
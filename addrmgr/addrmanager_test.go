@@ -314,6 +314,98 @@ func TestGood(t *testing.T) {
 	}
 }
 
+// TestStats ensures the statistics and known address snapshots reported by the
+// address manager reflect the addresses added to it and the ones marked good.
+func TestStats(t *testing.T) {
+	n := addrmgr.New("teststats", lookupFunc)
+	const numAddrs, numGood = 100, 10
+	addrs := make([]*wire.NetAddress, numAddrs)
+	for i := range addrs {
+		s := fmt.Sprintf("60.%d.1.1:8333", i)
+		var err error
+		addrs[i], err = n.DeserializeNetAddress(s, wire.SFNodeNetwork)
+		if err != nil {
+			t.Fatalf("Failed to turn %s into an address: %v", s, err)
+		}
+	}
+	srcAddr := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
+	n.AddAddresses(addrs, srcAddr)
+	for _, addr := range addrs[:numGood] {
+		n.Good(addr)
+	}
+	n.Attempt(addrs[numGood])
+
+	stats := n.Stats()
+	if stats.NumNew != numAddrs-numGood || stats.NumTried != numGood {
+		t.Fatalf("Stats: got %d new and %d tried, want %d and %d",
+			stats.NumNew, stats.NumTried, numAddrs-numGood, numGood)
+	}
+	if stats.NewBucketsUsed == 0 || stats.NewBucketsUsed > stats.NumNew ||
+		stats.NewBucketsUsed > stats.NewBucketsTotal {
+
+		t.Fatalf("Stats: unexpected number of new buckets used %d",
+			stats.NewBucketsUsed)
+	}
+	if stats.TriedBucketsUsed == 0 || stats.TriedBucketsUsed > numGood ||
+		stats.TriedBucketsUsed > stats.TriedBucketsTotal {
+
+		t.Fatalf("Stats: unexpected number of tried buckets used %d",
+			stats.TriedBucketsUsed)
+	}
+
+	// A sample is limited to the requested number of distinct addresses.
+	sample := n.KnownAddresses(5)
+	if len(sample) != 5 {
+		t.Fatalf("KnownAddresses: got %d addresses, want 5", len(sample))
+	}
+	seen := make(map[string]struct{})
+	for _, info := range sample {
+		key := addrmgr.NetAddressKey(&info.Addr)
+		if _, ok := seen[key]; ok {
+			t.Fatalf("KnownAddresses: duplicate address %s", key)
+		}
+		seen[key] = struct{}{}
+	}
+
+	// Requesting more than are known returns all of them along with the
+	// details tracked for each.
+	infos := n.KnownAddresses(numAddrs * 2)
+	if len(infos) != numAddrs {
+		t.Fatalf("KnownAddresses: got %d addresses, want %d", len(infos),
+			numAddrs)
+	}
+	var numTried int
+	for _, info := range infos {
+		if !info.Source.IP.Equal(srcAddr.IP) {
+			t.Fatalf("KnownAddresses: got source %v, want %v",
+				info.Source.IP, srcAddr.IP)
+		}
+		switch {
+		case info.Tried:
+			numTried++
+			if info.LastSuccess.IsZero() || info.Attempts != 0 {
+				t.Fatalf("KnownAddresses: tried address %v has "+
+					"no success", info.Addr.IP)
+			}
+		case info.Addr.IP.Equal(addrs[numGood].IP):
+			if info.Attempts != 1 || info.LastAttempt.IsZero() {
+				t.Fatalf("KnownAddresses: attempted address "+
+					"%v has %d attempts", info.Addr.IP,
+					info.Attempts)
+			}
+		default:
+			if info.Attempts != 0 || !info.LastSuccess.IsZero() {
+				t.Fatalf("KnownAddresses: new address %v has "+
+					"been attempted", info.Addr.IP)
+			}
+		}
+	}
+	if numTried != numGood {
+		t.Fatalf("KnownAddresses: got %d tried addresses, want %d",
+			numTried, numGood)
+	}
+}
+
 func TestGetAddress(t *testing.T) {
 	n := addrmgr.New("testgetaddress", lookupFunc)
 
@@ -457,6 +549,120 @@ func TestGetBestLocalAddress(t *testing.T) {
 	*/
 }
 
+// TestSeenLocal ensures addresses peers report seeing the node at are used as
+// local addresses once peers in enough distinct network groups agree on them
+// and that the address reported most often is preferred.
+func TestSeenLocal(t *testing.T) {
+	amgr := addrmgr.New("testseenlocal", nil)
+	remoteAddr := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.1"), 8333, 0)
+
+	// reporter returns the address of a reporting peer in the passed /16
+	// network group.
+	reporter := func(group, host byte) *wire.NetAddress {
+		ip := net.IPv4(12, group, 0, host)
+		return wire.NewNetAddressIPPort(ip, 8333, 0)
+	}
+
+	// Unroutable addresses are never used.
+	unroutable := wire.NewNetAddressIPPort(net.ParseIP("192.168.0.100"),
+		8333, 0)
+	if err := amgr.SeenLocal(unroutable, reporter(1, 1)); err == nil {
+		t.Fatal("SeenLocal: expected error for unroutable address")
+	}
+
+	// A reported address is not used until peers in enough distinct
+	// network groups agree on it.  Reports from peers in the same group
+	// only count once.
+	first := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.100"), 8333, 0)
+	for i := byte(1); i <= 3; i++ {
+		if err := amgr.SeenLocal(first, reporter(1, i)); err != nil {
+			t.Fatalf("SeenLocal: unexpected error: %v", err)
+		}
+	}
+	if err := amgr.SeenLocal(first, reporter(2, 1)); err != nil {
+		t.Fatalf("SeenLocal: unexpected error: %v", err)
+	}
+	got := amgr.GetBestLocalAddress(remoteAddr)
+	if got.IP.Equal(first.IP) {
+		t.Fatal("local address used before enough peers agreed on it")
+	}
+	if err := amgr.SeenLocal(first, reporter(3, 1)); err != nil {
+		t.Fatalf("SeenLocal: unexpected error: %v", err)
+	}
+	got = amgr.GetBestLocalAddress(remoteAddr)
+	if !got.IP.Equal(first.IP) {
+		t.Fatalf("unexpected local address - got %v, want %v", got.IP,
+			first.IP)
+	}
+
+	// An address reported by more peers is preferred.
+	second := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.200"), 8333, 0)
+	for i := byte(1); i <= 5; i++ {
+		if err := amgr.SeenLocal(second, reporter(i, 1)); err != nil {
+			t.Fatalf("SeenLocal: unexpected error: %v", err)
+		}
+	}
+	got = amgr.GetBestLocalAddress(remoteAddr)
+	if !got.IP.Equal(second.IP) {
+		t.Fatalf("unexpected local address - got %v, want %v", got.IP,
+			second.IP)
+	}
+
+	// A manually configured address is preferred over addresses only
+	// reported by a few peers.
+	manual := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.50"), 8333, 0)
+	if err := amgr.AddLocalAddress(manual, addrmgr.ManualPrio); err != nil {
+		t.Fatalf("AddLocalAddress: unexpected error: %v", err)
+	}
+	got = amgr.GetBestLocalAddress(remoteAddr)
+	if !got.IP.Equal(manual.IP) {
+		t.Fatalf("unexpected local address - got %v, want %v", got.IP,
+			manual.IP)
+	}
+}
+
+// TestSeenLocalEviction ensures reports of many bogus local addresses by a
+// single peer don't prevent addresses reported by more peers from being used.
+func TestSeenLocalEviction(t *testing.T) {
+	amgr := addrmgr.New("testseenlocaleviction", nil)
+	remoteAddr := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.1"), 8333, 0)
+
+	// reporter returns the address of a reporting peer in the passed /16
+	// network group.
+	reporter := func(group byte) *wire.NetAddress {
+		ip := net.IPv4(12, group, 0, 1)
+		return wire.NewNetAddressIPPort(ip, 8333, 0)
+	}
+
+	// Have peers in two groups report an address and then flood the
+	// tracked addresses with bogus addresses reported by a single peer.
+	genuine := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.100"), 8333,
+		0)
+	for group := byte(1); group <= 2; group++ {
+		if err := amgr.SeenLocal(genuine, reporter(group)); err != nil {
+			t.Fatalf("SeenLocal: unexpected error: %v", err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		ip := net.IPv4(204, 125, byte(i>>8), byte(i))
+		bogus := wire.NewNetAddressIPPort(ip, 8333, 0)
+		if err := amgr.SeenLocal(bogus, reporter(9)); err != nil {
+			t.Fatalf("SeenLocal: unexpected error: %v", err)
+		}
+	}
+
+	// The address reported by more peers must still be tracked, so a
+	// report by a peer in a third group makes it a local address.
+	if err := amgr.SeenLocal(genuine, reporter(3)); err != nil {
+		t.Fatalf("SeenLocal: unexpected error: %v", err)
+	}
+	got := amgr.GetBestLocalAddress(remoteAddr)
+	if !got.IP.Equal(genuine.IP) {
+		t.Fatalf("unexpected local address - got %v, want %v", got.IP,
+			genuine.IP)
+	}
+}
+
 func TestNetAddressKey(t *testing.T) {
 	addNaTests()
 
@@ -33,6 +33,18 @@ func (ka *KnownAddress) LastAttempt() time.Time {
 	return ka.lastattempt
 }
 
+// LastSuccess returns the last time a connection to the known address
+// succeeded.  It is the zero time when no connection ever has.
+func (ka *KnownAddress) LastSuccess() time.Time {
+	return ka.lastsuccess
+}
+
+// Attempts returns the number of connection attempts made to the known address
+// since the last successful one.
+func (ka *KnownAddress) Attempts() int {
+	return ka.attempts
+}
+
 // Services returns the services supported by the peer with the known address.
 func (ka *KnownAddress) Services() wire.ServiceFlag {
 	return ka.na.Services
@@ -40,7 +52,8 @@ func (ka *KnownAddress) Services() wire.ServiceFlag {
 
 // chance returns the selection probability for a known address.  The priority
 // depends upon how recently the address has been seen, how recently it was last
-// attempted and how often attempts to connect to it have failed.
+// attempted, how often attempts to connect to it have failed and whether it is
+// considered bad.
 func (ka *KnownAddress) chance() float64 {
 	now := time.Now()
 	lastAttempt := now.Sub(ka.lastattempt)
@@ -61,6 +74,12 @@ func (ka *KnownAddress) chance() float64 {
 		c /= 1.5
 	}
 
+	// Bad addresses are only selected as a last resort since they are
+	// evicted once the address set is compacted.
+	if ka.isBad() {
+		c *= 0.01
+	}
+
 	return c
 }
 
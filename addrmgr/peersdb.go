@@ -0,0 +1,360 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// PeersFormat identifies the format the known addresses are persisted in.
+type PeersFormat int
+
+const (
+	// PeersFormatJSON persists the known addresses as JSON to peers.json.
+	PeersFormatJSON PeersFormat = iota
+
+	// PeersFormatBinary persists the known addresses in a compact, versioned
+	// binary format to peers.dat.  It is both considerably smaller and
+	// faster to load than the JSON format since addresses are stored in
+	// their raw form and bucket entries reference them by index.
+	PeersFormatBinary
+)
+
+// peersFormatStrings is a map of peer database formats back to their constant
+// names for pretty printing.
+var peersFormatStrings = map[PeersFormat]string{
+	PeersFormatJSON:   "json",
+	PeersFormatBinary: "binary",
+}
+
+// String returns the PeersFormat in human-readable form.
+func (f PeersFormat) String() string {
+	if s, ok := peersFormatStrings[f]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown PeersFormat (%d)", int(f))
+}
+
+// ParsePeersFormat returns the peer database format for the passed name as
+// returned by PeersFormat.String.
+func ParsePeersFormat(name string) (PeersFormat, error) {
+	for format, s := range peersFormatStrings {
+		if s == name {
+			return format, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown peer database format %q", name)
+}
+
+// fileName returns the name of the file in the data directory the known
+// addresses are persisted to in the format.
+func (f PeersFormat) fileName() string {
+	if f == PeersFormatBinary {
+		return "peers.dat"
+	}
+	return "peers.json"
+}
+
+const (
+	// peersDBVersion is the current version of the binary peers database.
+	peersDBVersion = 1
+
+	// peersDBChecksumSize is the number of bytes of the double sha256 of
+	// the contents of a binary peers database appended to it.
+	peersDBChecksumSize = 4
+
+	// serializedAddrSize is the number of bytes a network address takes in
+	// the binary peers database: a 16 byte IP, a 2 byte port, and 8 bytes
+	// of services.
+	serializedAddrSize = 16 + 2 + 8
+
+	// serializedKnownAddrSize is the number of bytes a known address takes
+	// in the binary peers database: the address and its timestamp, the
+	// source address, the number of attempts, and the last attempt and
+	// success times.
+	serializedKnownAddrSize = serializedAddrSize + 8 + serializedAddrSize +
+		4 + 8 + 8
+)
+
+// peersDBMagic identifies a binary peers database.
+var peersDBMagic = [4]byte{'p', 'e', 'e', 'r'}
+
+// errPeersDBTruncated is returned when a binary peers database ends before all
+// of the data it describes.
+var errPeersDBTruncated = errors.New("unexpected end of peers database")
+
+// putNetAddress serializes the IP, port and services of the passed address to
+// the buffer.
+func putNetAddress(buf *bytes.Buffer, na *wire.NetAddress) {
+	var scratch [serializedAddrSize]byte
+	copy(scratch[:16], na.IP.To16())
+	binary.LittleEndian.PutUint16(scratch[16:18], na.Port)
+	binary.LittleEndian.PutUint64(scratch[18:], uint64(na.Services))
+	buf.Write(scratch[:])
+}
+
+// readNetAddress deserializes an address written by putNetAddress.
+func readNetAddress(r *bytes.Reader) (*wire.NetAddress, error) {
+	var scratch [serializedAddrSize]byte
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return nil, errPeersDBTruncated
+	}
+	ip := make(net.IP, 16)
+	copy(ip, scratch[:16])
+	return &wire.NetAddress{
+		IP:       ip,
+		Port:     binary.LittleEndian.Uint16(scratch[16:18]),
+		Services: wire.ServiceFlag(binary.LittleEndian.Uint64(scratch[18:])),
+	}, nil
+}
+
+// putUint64 serializes the passed value to the buffer.
+func putUint64(buf *bytes.Buffer, v uint64) {
+	var scratch [8]byte
+	binary.LittleEndian.PutUint64(scratch[:], v)
+	buf.Write(scratch[:])
+}
+
+// readUint64 deserializes a value written by putUint64.
+func readUint64(r *bytes.Reader) (uint64, error) {
+	var scratch [8]byte
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return 0, errPeersDBTruncated
+	}
+	return binary.LittleEndian.Uint64(scratch[:]), nil
+}
+
+// readCount deserializes a count of items that is not allowed to exceed the
+// passed maximum.
+func readCount(r *bytes.Reader, max uint64, what string) (int, error) {
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return 0, errPeersDBTruncated
+	}
+	if count > max {
+		return 0, fmt.Errorf("%s count of %d exceeds maximum of %d", what,
+			count, max)
+	}
+	return int(count), nil
+}
+
+// serializePeersBinary writes all of the known addresses and the buckets they
+// are in to the passed writer in the binary peers database format.
+//
+// The format is the magic bytes, a version, the key used to pick buckets,
+// the known addresses, each of the new buckets, and each of the tried buckets
+// followed by the first bytes of the double sha256 of everything before it.
+// Bucket entries are serialized as the index of the address they refer to so
+// addresses in several new buckets are only stored once.
+//
+// This function MUST be called with the address manager lock held.
+func (a *AddrManager) serializePeersBinary(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.Grow(len(peersDBMagic) + 4 + len(a.key) +
+		len(a.addrIndex)*serializedKnownAddrSize)
+
+	buf.Write(peersDBMagic[:])
+	var version [4]byte
+	binary.LittleEndian.PutUint32(version[:], peersDBVersion)
+	buf.Write(version[:])
+	buf.Write(a.key[:])
+
+	indices := make(map[*KnownAddress]uint64, len(a.addrIndex))
+	wire.WriteVarInt(&buf, 0, uint64(len(a.addrIndex)))
+	for _, ka := range a.addrIndex {
+		indices[ka] = uint64(len(indices))
+
+		putNetAddress(&buf, ka.na)
+		putUint64(&buf, uint64(ka.na.Timestamp.Unix()))
+		putNetAddress(&buf, ka.srcAddr)
+		var attempts [4]byte
+		binary.LittleEndian.PutUint32(attempts[:], uint32(ka.attempts))
+		buf.Write(attempts[:])
+		putUint64(&buf, uint64(ka.lastattempt.Unix()))
+		putUint64(&buf, uint64(ka.lastsuccess.Unix()))
+	}
+	for i := range a.addrNew {
+		wire.WriteVarInt(&buf, 0, uint64(len(a.addrNew[i])))
+		for _, ka := range a.addrNew[i] {
+			wire.WriteVarInt(&buf, 0, indices[ka])
+		}
+	}
+	for i := range a.addrTried {
+		wire.WriteVarInt(&buf, 0, uint64(a.addrTried[i].Len()))
+		for e := a.addrTried[i].Front(); e != nil; e = e.Next() {
+			ka := e.Value.(*KnownAddress)
+			wire.WriteVarInt(&buf, 0, indices[ka])
+		}
+	}
+
+	checksum := chainhash.DoubleHashB(buf.Bytes())
+	buf.Write(checksum[:peersDBChecksumSize])
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// deserializePeersBinary loads the known addresses from the binary peers
+// database at the passed path.  A missing file is not an error and loads
+// nothing.
+//
+// This function MUST be called with the address manager lock held.
+func (a *AddrManager) deserializePeersBinary(filePath string) error {
+	serialized, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s error reading file: %v", filePath, err)
+	}
+
+	// Ensure the file is a peers database of a known version that was not
+	// corrupted before parsing any of it.
+	headerSize := len(peersDBMagic) + 4
+	if len(serialized) < headerSize+peersDBChecksumSize {
+		return errPeersDBTruncated
+	}
+	if !bytes.Equal(serialized[:len(peersDBMagic)], peersDBMagic[:]) {
+		return fmt.Errorf("%s is not a peers database", filePath)
+	}
+	version := binary.LittleEndian.Uint32(serialized[len(peersDBMagic):])
+	if version > peersDBVersion {
+		return fmt.Errorf("unknown version %v in peers database",
+			version)
+	}
+	contents := serialized[:len(serialized)-peersDBChecksumSize]
+	checksum := chainhash.DoubleHashB(contents)
+	if !bytes.Equal(checksum[:peersDBChecksumSize],
+		serialized[len(contents):]) {
+
+		return fmt.Errorf("checksum mismatch in peers database")
+	}
+
+	r := bytes.NewReader(contents[headerSize:])
+	if _, err := io.ReadFull(r, a.key[:]); err != nil {
+		return errPeersDBTruncated
+	}
+
+	// The number of addresses can't be more than the remaining data is able
+	// to hold.
+	numAddrs, err := readCount(r, uint64(r.Len()/serializedKnownAddrSize),
+		"address")
+	if err != nil {
+		return err
+	}
+	addrs := make([]*KnownAddress, 0, numAddrs)
+	keys := make([]string, 0, numAddrs)
+	for i := 0; i < numAddrs; i++ {
+		ka := new(KnownAddress)
+		if ka.na, err = readNetAddress(r); err != nil {
+			return err
+		}
+		timestamp, err := readUint64(r)
+		if err != nil {
+			return err
+		}
+		ka.na.Timestamp = time.Unix(int64(timestamp), 0)
+		if ka.srcAddr, err = readNetAddress(r); err != nil {
+			return err
+		}
+		var attempts [4]byte
+		if _, err := io.ReadFull(r, attempts[:]); err != nil {
+			return errPeersDBTruncated
+		}
+		ka.attempts = int(binary.LittleEndian.Uint32(attempts[:]))
+		lastAttempt, err := readUint64(r)
+		if err != nil {
+			return err
+		}
+		ka.lastattempt = time.Unix(int64(lastAttempt), 0)
+		lastSuccess, err := readUint64(r)
+		if err != nil {
+			return err
+		}
+		ka.lastsuccess = time.Unix(int64(lastSuccess), 0)
+
+		key := NetAddressKey(ka.na)
+		if _, ok := a.addrIndex[key]; ok {
+			return fmt.Errorf("duplicate address %s in peers "+
+				"database", key)
+		}
+		a.addrIndex[key] = ka
+		addrs = append(addrs, ka)
+		keys = append(keys, key)
+	}
+
+	// readEntry returns the index of the known address referenced by the
+	// next bucket entry.
+	readEntry := func() (int, error) {
+		index, err := wire.ReadVarInt(r, 0)
+		if err != nil {
+			return 0, errPeersDBTruncated
+		}
+		if index >= uint64(len(addrs)) {
+			return 0, fmt.Errorf("bucket entry references "+
+				"address %d of %d", index, len(addrs))
+		}
+		return int(index), nil
+	}
+	for i := range a.addrNew {
+		count, err := readCount(r, newBucketSize, "new bucket entry")
+		if err != nil {
+			return err
+		}
+		for j := 0; j < count; j++ {
+			index, err := readEntry()
+			if err != nil {
+				return err
+			}
+			ka, key := addrs[index], keys[index]
+			if _, ok := a.addrNew[i][key]; ok {
+				return fmt.Errorf("new bucket %d contains %s "+
+					"more than once", i, key)
+			}
+			if ka.refs == 0 {
+				a.nNew++
+			}
+			ka.refs++
+			a.addrNew[i][key] = ka
+		}
+	}
+	for i := range a.addrTried {
+		count, err := readCount(r, triedBucketSize, "tried bucket entry")
+		if err != nil {
+			return err
+		}
+		for j := 0; j < count; j++ {
+			index, err := readEntry()
+			if err != nil {
+				return err
+			}
+			ka := addrs[index]
+			if ka.tried {
+				return fmt.Errorf("address %s is in more than "+
+					"one tried bucket", keys[index])
+			}
+			ka.tried = true
+			a.nTried++
+			a.addrTried[i].PushBack(ka)
+		}
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%d unexpected trailing bytes in peers "+
+			"database", r.Len())
+	}
+
+	return a.checkAddrIndex()
+}
@@ -68,7 +68,7 @@ func (b *BlockChain) maybeAcceptBlock(block *btcutil.Block, flags BehaviorFlags)
 	newNode.status = statusDataStored
 
 	b.index.AddNode(newNode)
-	err = b.index.flushToDB()
+	err = b.index.maybeFlushToDB()
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,50 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// ReadBlockFile reads blocks in the format bitcoind writes to its block files
+// from the passed reader.  Each block is prefixed by the network magic and the
+// serialized length of the block.  Reading stops at the end of the data or at
+// the first block which is not prefixed by the magic of the passed network,
+// such as the zero padding at the end of preallocated block files.
+func ReadBlockFile(r io.Reader, net wire.BitcoinNet) ([]*btcutil.Block, error) {
+	var blocks []*btcutil.Block
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return blocks, nil
+			}
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(header[:4]) != uint32(net) {
+			return blocks, nil
+		}
+
+		blockLen := binary.LittleEndian.Uint32(header[4:])
+		if blockLen > wire.MaxBlockPayload {
+			return nil, fmt.Errorf("block length %d exceeds the "+
+				"maximum of %d", blockLen, wire.MaxBlockPayload)
+		}
+		blockBytes := make([]byte, blockLen)
+		if _, err := io.ReadFull(r, blockBytes); err != nil {
+			return nil, err
+		}
+		block, err := btcutil.NewBlockFromBytes(blockBytes)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+}
@@ -16,6 +16,14 @@ import (
 	"github.com/btcsuite/btcd/wire"
 )
 
+// indexFlushInterval is the maximum amount of time dirty block nodes that
+// are not part of a best chain update are kept in memory before being written
+// to the database.  Nodes that change along with the best chain are always
+// written atomically with the best chain state, so an unclean shutdown can
+// only lose side chain nodes accepted within this interval, which are simply
+// accepted again when their blocks are next received.
+const indexFlushInterval = time.Minute
+
 // blockStatus is a bit field representing the validation state of the block.
 type blockStatus byte
 
@@ -230,8 +238,9 @@ type blockIndex struct {
 	chainParams *chaincfg.Params
 
 	sync.RWMutex
-	index map[chainhash.Hash]*blockNode
-	dirty map[*blockNode]struct{}
+	index     map[chainhash.Hash]*blockNode
+	dirty     map[*blockNode]struct{}
+	lastFlush time.Time
 }
 
 // newBlockIndex returns a new empty instance of a block index.  The index will
@@ -341,8 +350,50 @@ func (bi *blockIndex) flushToDB() error {
 	// If write was successful, clear the dirty set.
 	if err == nil {
 		bi.dirty = make(map[*blockNode]struct{})
+		bi.lastFlush = time.Now()
 	}
 
 	bi.Unlock()
 	return err
 }
+
+// maybeFlushToDB writes all dirty block nodes to the database when at least
+// indexFlushInterval has passed since the last flush.  Otherwise the nodes
+// remain dirty so they are batched with later modifications.
+func (bi *blockIndex) maybeFlushToDB() error {
+	bi.RLock()
+	due := time.Since(bi.lastFlush) >= indexFlushInterval
+	bi.RUnlock()
+	if !due {
+		return nil
+	}
+	return bi.flushToDB()
+}
+
+// storeDirty writes all dirty block nodes using the provided database
+// transaction and returns them so the caller can mark them as flushed via
+// markFlushed once the transaction has been committed.
+func (bi *blockIndex) storeDirty(dbTx database.Tx) ([]*blockNode, error) {
+	bi.RLock()
+	defer bi.RUnlock()
+
+	nodes := make([]*blockNode, 0, len(bi.dirty))
+	for node := range bi.dirty {
+		if err := dbStoreBlockNode(dbTx, node); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// markFlushed removes the provided nodes, which were written by storeDirty in
+// a committed database transaction, from the dirty set.
+func (bi *blockIndex) markFlushed(nodes []*blockNode) {
+	bi.Lock()
+	for _, node := range nodes {
+		delete(bi.dirty, node)
+	}
+	bi.lastFlush = time.Now()
+	bi.Unlock()
+}
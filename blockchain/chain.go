@@ -6,8 +6,10 @@
 package blockchain
 
 import (
+	"bytes"
 	"container/list"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -23,6 +25,15 @@ const (
 	// maxOrphanBlocks is the maximum number of orphan blocks that can be
 	// queued.
 	maxOrphanBlocks = 100
+
+	// DefaultMaxOrphanBlockBytes is the default maximum combined serialized
+	// size of the orphan blocks that can be queued.
+	DefaultMaxOrphanBlockBytes = 32 * 1024 * 1024
+
+	// txRateWindow is the number of most recently connected main chain
+	// blocks used to estimate the rate at which transactions are added to
+	// the chain.
+	txRateWindow = 144
 )
 
 // BlockLocator is used to help locate a specific block.  The algorithm for
@@ -46,6 +57,7 @@ type BlockLocator []*chainhash.Hash
 // forever.
 type orphanBlock struct {
 	block      *btcutil.Block
+	size       int
 	expiration time.Time
 }
 
@@ -85,6 +97,82 @@ func newBestState(node *blockNode, blockSize, blockWeight, numTxns,
 	}
 }
 
+// txRateSample records a main chain block along with the rate at which the
+// number of transactions in the chain grew as of that block.
+type txRateSample struct {
+	// timestamp is the latest timestamp of the chain up to and including
+	// the block.  Block timestamps are not required to increase, so this
+	// may be the timestamp of an ancestor.
+	timestamp int64
+
+	// totalTxns is the total number of transactions in the chain up to and
+	// including the block.
+	totalTxns uint64
+
+	// growthRate is the number of transactions added to the chain per
+	// second relative to the total number of transactions in it.  It never
+	// increases along the chain, so the verification progress estimated
+	// from it only ever increases as blocks are connected.
+	growthRate float64
+}
+
+// nextTxRateSample returns the sample for a block with the passed timestamp and
+// total number of transactions that extends the chain the passed samples of its
+// most recent blocks belong to.  The rate is measured over the samples, or
+// since the genesis block when they do not span any time, and is limited to the
+// rate of the previous block.
+func nextTxRateSample(samples []txRateSample, timestamp int64, totalTxns uint64,
+	genesisTime int64) txRateSample {
+
+	sample := txRateSample{
+		timestamp:  timestamp,
+		totalTxns:  totalTxns,
+		growthRate: math.Inf(1),
+	}
+	if len(samples) > 0 {
+		first, last := samples[0], samples[len(samples)-1]
+		if last.timestamp > sample.timestamp {
+			sample.timestamp = last.timestamp
+		}
+		sample.growthRate = last.growthRate
+		if elapsed := sample.timestamp - first.timestamp; elapsed > 0 {
+			rate := float64(totalTxns-first.totalTxns) /
+				float64(elapsed) / float64(totalTxns)
+			sample.growthRate = math.Min(sample.growthRate, rate)
+			return sample
+		}
+	}
+	if elapsed := sample.timestamp - genesisTime; elapsed > 0 {
+		rate := 1 / float64(elapsed)
+		sample.growthRate = math.Min(sample.growthRate, rate)
+	}
+	return sample
+}
+
+// estimateVerificationProgress returns the fraction of the transactions which
+// are expected to exist as of the passed time that the chain ending at the
+// block of the passed sample includes.  The transactions yet to be verified are
+// estimated from the growth rate of the sample and the time elapsed since the
+// block.
+func estimateVerificationProgress(sample txRateSample, now time.Time) float64 {
+	elapsed := now.Unix() - sample.timestamp
+	if elapsed <= 0 {
+		return 1
+	}
+	return 1 / (1 + float64(elapsed)*sample.growthRate)
+}
+
+// ReorgStats houses statistics about the reorganizations of the main chain
+// that have taken place since the chain instance was created.  The depth of a
+// reorganization is the number of blocks that were disconnected from the main
+// chain.
+type ReorgStats struct {
+	Count     uint64    // The number of reorganizations.
+	MaxDepth  int32     // The depth of the deepest reorganization.
+	LastDepth int32     // The depth of the most recent reorganization.
+	LastTime  time.Time // The time of the most recent reorganization.
+}
+
 // BlockChain provides functions for working with the bitcoin block chain.
 // It includes functionality such as rejecting duplicate blocks, ensuring blocks
 // follow all rules, orphan handling, checkpoint handling, and best chain
@@ -101,6 +189,7 @@ type BlockChain struct {
 	sigCache            *txscript.SigCache
 	indexManager        IndexManager
 	hashCache           *txscript.HashCache
+	maxOrphanBytes      int
 
 	// The following fields are calculated based upon the provided chain
 	// parameters.  They are also set when the instance is created and
@@ -132,6 +221,7 @@ type BlockChain struct {
 	orphans      map[chainhash.Hash]*orphanBlock
 	prevOrphans  map[chainhash.Hash][]*orphanBlock
 	oldestOrphan *orphanBlock
+	orphanBytes  int
 
 	// These fields are related to checkpoint handling.  They are protected
 	// by the chain lock.
@@ -152,6 +242,15 @@ type BlockChain struct {
 	stateLock     sync.RWMutex
 	stateSnapshot *BestState
 
+	// reorgStats tracks the reorganizations of the main chain.  It is
+	// protected by the state lock.
+	reorgStats ReorgStats
+
+	// txRateSamples tracks the most recent main chain blocks in order to
+	// estimate the verification progress.  It is seeded from the database
+	// on startup and protected by the state lock.
+	txRateSamples []txRateSample
+
 	// The following caches are used to efficiently keep track of the
 	// current deployment threshold state of each rule change deployment.
 	//
@@ -251,6 +350,7 @@ func (b *BlockChain) removeOrphanBlock(orphan *orphanBlock) {
 	// Remove the orphan block from the orphan pool.
 	orphanHash := orphan.block.Hash()
 	delete(b.orphans, *orphanHash)
+	b.orphanBytes -= orphan.size
 
 	// Remove the reference from the previous orphan index too.  An indexing
 	// for loop is intentionally used over a range here as range does not
@@ -279,10 +379,19 @@ func (b *BlockChain) removeOrphanBlock(orphan *orphanBlock) {
 // addOrphanBlock adds the passed block (which is already determined to be
 // an orphan prior calling this function) to the orphan pool.  It lazily cleans
 // up any expired blocks so a separate cleanup poller doesn't need to be run.
-// It also imposes a maximum limit on the number of outstanding orphan
-// blocks and will remove the oldest received orphan block if the limit is
-// exceeded.
+// It also imposes maximum limits on the number and the combined size of the
+// outstanding orphan blocks and will remove the oldest received orphan blocks
+// if either limit is exceeded.  A block that exceeds the size limit on its own
+// is not added.
 func (b *BlockChain) addOrphanBlock(block *btcutil.Block) {
+	size := block.MsgBlock().SerializeSize()
+	if size > b.maxOrphanBytes {
+		log.Debugf("Not caching orphan block %v of %d bytes which "+
+			"exceeds the %d byte limit", block.Hash(), size,
+			b.maxOrphanBytes)
+		return
+	}
+
 	// Remove expired orphan blocks.
 	for _, oBlock := range b.orphans {
 		if time.Now().After(oBlock.expiration) {
@@ -304,6 +413,20 @@ func (b *BlockChain) addOrphanBlock(block *btcutil.Block) {
 		b.oldestOrphan = nil
 	}
 
+	// Limit the combined size of orphan blocks as well since the count
+	// alone allows large orphan blocks to exhaust memory.  Remove the
+	// oldest orphans until the new one fits.
+	for len(b.orphans) > 0 && b.orphanBytes+size > b.maxOrphanBytes {
+		var oldest *orphanBlock
+		for _, oBlock := range b.orphans {
+			if oldest == nil || oBlock.expiration.Before(oldest.expiration) {
+				oldest = oBlock
+			}
+		}
+		b.removeOrphanBlock(oldest)
+		b.oldestOrphan = nil
+	}
+
 	// Protect concurrent access.  This is intentionally done here instead
 	// of near the top since removeOrphanBlock does its own locking and
 	// the range iterator is not invalidated by removing map entries.
@@ -315,9 +438,11 @@ func (b *BlockChain) addOrphanBlock(block *btcutil.Block) {
 	expiration := time.Now().Add(time.Hour)
 	oBlock := &orphanBlock{
 		block:      block,
+		size:       size,
 		expiration: expiration,
 	}
 	b.orphans[*block.Hash()] = oBlock
+	b.orphanBytes += size
 
 	// Add to previous hash lookup index for faster dependency lookups.
 	prevHash := &block.MsgBlock().Header.PrevBlock
@@ -579,12 +704,6 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block,
 		}
 	}
 
-	// Write any block status changes to DB before updating best state.
-	err := b.index.flushToDB()
-	if err != nil {
-		return err
-	}
-
 	// Generate a new best state snapshot that will be used to update the
 	// database and later memory if all database updates are successful.
 	b.stateLock.RLock()
@@ -597,9 +716,19 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block,
 		curTotalTxns+numTxns, node.CalcPastMedianTime())
 
 	// Atomically insert info into the database.
-	err = b.db.Update(func(dbTx database.Tx) error {
+	var flushed []*blockNode
+	err := b.db.Update(func(dbTx database.Tx) error {
+		// Write any block index changes, including those batched since
+		// the last flush, along with the best state so the stored index
+		// always covers the best chain.
+		var err error
+		flushed, err = b.index.storeDirty(dbTx)
+		if err != nil {
+			return err
+		}
+
 		// Update best block state.
-		err := dbPutBestState(dbTx, state, node.workSum)
+		err = dbPutBestState(dbTx, state, node.workSum)
 		if err != nil {
 			return err
 		}
@@ -643,8 +772,10 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block,
 	}
 
 	// Prune fully spent entries and mark all entries in the view unmodified
-	// now that the modifications have been committed to the database.
+	// and the written block nodes clean now that the modifications have been
+	// committed to the database.
 	view.commit()
+	b.index.markFlushed(flushed)
 
 	// This node is now the end of the best chain.
 	b.bestChain.SetTip(node)
@@ -656,13 +787,14 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block,
 	// comments on the state variable for more details.
 	b.stateLock.Lock()
 	b.stateSnapshot = state
+	b.addTxRateSample(node.timestamp, state.TotalTxns)
 	b.stateLock.Unlock()
 
 	// Notify the caller that the block was connected to the main chain.
 	// The caller would typically want to react with actions such as
 	// updating wallets.
 	b.chainLock.Unlock()
-	b.sendNotification(NTBlockConnected, block)
+	b.sendNotificationWithSpends(NTBlockConnected, block, stxos)
 	b.chainLock.Lock()
 
 	return nil
@@ -691,12 +823,6 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 		return err
 	}
 
-	// Write any block status changes to DB before updating best state.
-	err = b.index.flushToDB()
-	if err != nil {
-		return err
-	}
-
 	// Generate a new best state snapshot that will be used to update the
 	// database and later memory if all database updates are successful.
 	b.stateLock.RLock()
@@ -709,9 +835,20 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 	state := newBestState(prevNode, blockSize, blockWeight, numTxns,
 		newTotalTxns, prevNode.CalcPastMedianTime())
 
+	var stxos []SpentTxOut
+	var flushed []*blockNode
 	err = b.db.Update(func(dbTx database.Tx) error {
+		// Write any block index changes, including those batched since
+		// the last flush, along with the best state so the stored index
+		// always covers the best chain.
+		var err error
+		flushed, err = b.index.storeDirty(dbTx)
+		if err != nil {
+			return err
+		}
+
 		// Update best block state.
-		err := dbPutBestState(dbTx, state, node.workSum)
+		err = dbPutBestState(dbTx, state, node.workSum)
 		if err != nil {
 			return err
 		}
@@ -732,8 +869,9 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 		}
 
 		// Before we delete the spend journal entry for this back,
-		// we'll fetch it as is so the indexers can utilize if needed.
-		stxos, err := dbFetchSpendJournalEntry(dbTx, block)
+		// we'll fetch it as is so the indexers and notification
+		// subscribers can utilize if needed.
+		stxos, err = dbFetchSpendJournalEntry(dbTx, block)
 		if err != nil {
 			return err
 		}
@@ -762,8 +900,10 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 	}
 
 	// Prune fully spent entries and mark all entries in the view unmodified
-	// now that the modifications have been committed to the database.
+	// and the written block nodes clean now that the modifications have been
+	// committed to the database.
 	view.commit()
+	b.index.markFlushed(flushed)
 
 	// This node's parent is now the end of the best chain.
 	b.bestChain.SetTip(node.parent)
@@ -775,13 +915,17 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 	// comments on the state variable for more details.
 	b.stateLock.Lock()
 	b.stateSnapshot = state
+	b.txRateSamples = b.txRateSamples[:len(b.txRateSamples)-1]
+	if len(b.txRateSamples) == 0 {
+		b.addTxRateSample(prevNode.timestamp, state.TotalTxns)
+	}
 	b.stateLock.Unlock()
 
 	// Notify the caller that the block was disconnected from the main
 	// chain.  The caller would typically want to react with actions such as
 	// updating wallets.
 	b.chainLock.Unlock()
-	b.sendNotification(NTBlockDisconnected, block)
+	b.sendNotificationWithSpends(NTBlockDisconnected, block, stxos)
 	b.chainLock.Lock()
 
 	return nil
@@ -987,6 +1131,35 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 	view = NewUtxoViewpoint()
 	view.SetBestHash(&b.bestChain.Tip().hash)
 
+	// Notify the caller that the main chain is being reorganized before any
+	// of the blocks are disconnected so subscribers are able to prepare for
+	// the disconnected and connected notifications that follow.
+	reorgData := &ReorganizationNtfnsData{
+		OldHash:   oldBest.hash,
+		OldHeight: oldBest.height,
+		NewHash:   newBest.hash,
+		NewHeight: newBest.height,
+	}
+	if forkNode != nil {
+		reorgData.ForkHash = forkNode.hash
+		reorgData.ForkHeight = forkNode.height
+	} else {
+		reorgData.ForkHash = newBest.hash
+		reorgData.ForkHeight = newBest.height
+	}
+	b.chainLock.Unlock()
+	b.sendNotification(NTReorganization, reorgData)
+	b.chainLock.Lock()
+
+	// abort notifies the caller that the reorganization failed so
+	// subscribers don't wait for the notifications of the remaining blocks.
+	abort := func(err error) error {
+		b.chainLock.Unlock()
+		b.sendNotification(NTReorganizationAborted, reorgData)
+		b.chainLock.Lock()
+		return err
+	}
+
 	// Disconnect blocks from the main chain.
 	for i, e := 0, detachNodes.Front(); e != nil; i, e = i+1, e.Next() {
 		n := e.Value.(*blockNode)
@@ -996,7 +1169,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		// already in the view.
 		err := view.fetchInputUtxos(b.db, block)
 		if err != nil {
-			return err
+			return abort(err)
 		}
 
 		// Update the view to unspend all of the spent txos and remove
@@ -1004,13 +1177,13 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		err = view.disconnectTransactions(b.db, block,
 			detachSpentTxOuts[i])
 		if err != nil {
-			return err
+			return abort(err)
 		}
 
 		// Update the database and chain state.
 		err = b.disconnectBlock(n, block, view)
 		if err != nil {
-			return err
+			return abort(err)
 		}
 	}
 
@@ -1023,7 +1196,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		// already in the view.
 		err := view.fetchInputUtxos(b.db, block)
 		if err != nil {
-			return err
+			return abort(err)
 		}
 
 		// Update the view to mark all utxos referenced by the block
@@ -1033,14 +1206,27 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		stxos := make([]SpentTxOut, 0, countSpentOutputs(block))
 		err = view.connectTransactions(block, &stxos)
 		if err != nil {
-			return err
+			return abort(err)
 		}
 
 		// Update the database and chain state.
 		err = b.connectBlock(n, block, view, stxos)
 		if err != nil {
-			return err
+			return abort(err)
+		}
+	}
+
+	// Update the reorganization statistics.
+	if detachNodes.Len() != 0 {
+		depth := int32(detachNodes.Len())
+		b.stateLock.Lock()
+		b.reorgStats.Count++
+		b.reorgStats.LastDepth = depth
+		b.reorgStats.LastTime = time.Now()
+		if depth > b.reorgStats.MaxDepth {
+			b.reorgStats.MaxDepth = depth
 		}
+		b.stateLock.Unlock()
 	}
 
 	// Log the point where the chain forked and old and new best chain
@@ -1254,6 +1440,121 @@ func (b *BlockChain) BestSnapshot() *BestState {
 	return snapshot
 }
 
+// FlushIndex writes all block index modifications that have been batched
+// since the last flush to the database.  It should be called before the
+// database is closed on shutdown so side chain blocks accepted since the last
+// flush are still known on the next start.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FlushIndex() error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	return b.index.flushToDB()
+}
+
+// ReorgStats returns statistics about the reorganizations of the main chain
+// that have taken place since the chain instance was created.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ReorgStats() ReorgStats {
+	b.stateLock.RLock()
+	stats := b.reorgStats
+	b.stateLock.RUnlock()
+	return stats
+}
+
+// addTxRateSample adds the sample for a newly connected main chain block with
+// the passed timestamp and total number of transactions.  Only the samples of
+// the txRateWindow most recent blocks are kept.
+//
+// This function MUST be called with the state lock held (for writes).
+func (b *BlockChain) addTxRateSample(timestamp int64, totalTxns uint64) {
+	sample := nextTxRateSample(b.txRateSamples, timestamp, totalTxns,
+		b.chainParams.GenesisBlock.Header.Timestamp.Unix())
+	if len(b.txRateSamples) == txRateWindow {
+		copy(b.txRateSamples, b.txRateSamples[1:])
+		b.txRateSamples = b.txRateSamples[:txRateWindow-1]
+	}
+	b.txRateSamples = append(b.txRateSamples, sample)
+}
+
+// initTxRateSamples seeds the samples used to estimate the verification
+// progress with the most recent blocks of the main chain, so the estimate does
+// not have to be rebuilt from the blocks connected after a restart.  Only the
+// number of transactions of each block is loaded from the database.
+func (b *BlockChain) initTxRateSamples() error {
+	// Collect the most recent main chain blocks, oldest first.
+	tip := b.bestChain.Tip()
+	nodes := make([]*blockNode, 0, txRateWindow)
+	for node := tip; node != nil; node = node.parent {
+		nodes = append(nodes, node)
+		if len(nodes) == txRateWindow {
+			break
+		}
+	}
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+
+	// Load the number of transactions of each block, which directly
+	// follows its header, and work out the total number of transactions
+	// in the chain as of the oldest one.
+	numTxns := make([]uint64, len(nodes))
+	err := b.db.View(func(dbTx database.Tx) error {
+		for i, node := range nodes {
+			region, err := dbTx.FetchBlockRegion(&database.BlockRegion{
+				Hash:   &node.hash,
+				Offset: wire.MaxBlockHeaderPayload,
+				Len:    wire.MaxVarIntPayload,
+			})
+			if err != nil {
+				return err
+			}
+			numTxns[i], err = wire.ReadVarInt(bytes.NewReader(region), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	totalTxns := b.stateSnapshot.TotalTxns
+	for _, n := range numTxns[1:] {
+		totalTxns -= n
+	}
+
+	b.stateLock.Lock()
+	b.txRateSamples = make([]txRateSample, 0, txRateWindow)
+	for i, node := range nodes {
+		if i > 0 {
+			totalTxns += numTxns[i]
+		}
+		b.addTxRateSample(node.timestamp, totalTxns)
+	}
+	b.stateLock.Unlock()
+	return nil
+}
+
+// VerificationProgress returns an estimate of the fraction of the transactions
+// which are expected to exist as of the passed time that have been verified by
+// the main chain.  Rather than comparing heights, the transactions yet to be
+// verified are estimated from the rate at which the number of transactions in
+// the chain grew over its most recent blocks, so the estimate reflects the
+// transaction density of the chain.  The rate never increases along the chain,
+// so the estimate only ever increases as blocks are connected.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) VerificationProgress(now time.Time) float64 {
+	b.stateLock.RLock()
+	sample := b.txRateSamples[len(b.txRateSamples)-1]
+	b.stateLock.RUnlock()
+
+	return estimateVerificationProgress(sample, now)
+}
+
 // HeaderByHash returns the block header identified by the given hash or an
 // error if it doesn't exist. Note that this will return headers from both the
 // main and side chains.
@@ -1700,6 +2001,13 @@ type Config struct {
 	// This field can be nil if the caller is not interested in using a
 	// signature cache.
 	HashCache *txscript.HashCache
+
+	// MaxOrphanBlockBytes defines the maximum combined serialized size of
+	// the orphan blocks held in memory.  The oldest orphan blocks are
+	// evicted when it is exceeded.
+	//
+	// This field can be zero to use DefaultMaxOrphanBlockBytes.
+	MaxOrphanBlockBytes int
 }
 
 // New returns a BlockChain instance using the provided configuration details.
@@ -1733,6 +2041,11 @@ func New(config *Config) (*BlockChain, error) {
 		}
 	}
 
+	maxOrphanBytes := config.MaxOrphanBlockBytes
+	if maxOrphanBytes == 0 {
+		maxOrphanBytes = DefaultMaxOrphanBlockBytes
+	}
+
 	params := config.ChainParams
 	targetTimespan := int64(params.TargetTimespan / time.Second)
 	targetTimePerBlock := int64(params.TargetTimePerBlock / time.Second)
@@ -1750,6 +2063,7 @@ func New(config *Config) (*BlockChain, error) {
 		blocksPerRetarget:   int32(targetTimespan / targetTimePerBlock),
 		index:               newBlockIndex(config.DB, params),
 		hashCache:           config.HashCache,
+		maxOrphanBytes:      maxOrphanBytes,
 		bestChain:           newChainView(nil),
 		orphans:             make(map[chainhash.Hash]*orphanBlock),
 		prevOrphans:         make(map[chainhash.Hash][]*orphanBlock),
@@ -1783,6 +2097,11 @@ func New(config *Config) (*BlockChain, error) {
 		return nil, err
 	}
 
+	// Seed the samples used to estimate the verification progress.
+	if err := b.initTxRateSamples(); err != nil {
+		return nil, err
+	}
+
 	bestNode := b.bestChain.Tip()
 	log.Infof("Chain state (height %d, hash %v, totaltx %d, work %v)",
 		bestNode.height, bestNode.hash, b.stateSnapshot.TotalTxns,
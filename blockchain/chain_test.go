@@ -111,6 +111,72 @@ func TestHaveBlock(t *testing.T) {
 	}
 }
 
+// TestReorgStats ensures the reorganization statistics are updated when the
+// main chain is reorganized.
+func TestReorgStats(t *testing.T) {
+	// Load up blocks such that there is a side chain that ultimately
+	// becomes the main chain.
+	// (genesis block) -> 1 -> 2 -> 3 -> 4
+	//                          \-> 3a -> 4a -> 5a
+	testFiles := []string{
+		"blk_0_to_4.dat.bz2",
+		"blk_3A.dat.bz2",
+		"blk_4A.dat.bz2",
+		"blk_5A.dat.bz2",
+	}
+	var blocks []*btcutil.Block
+	for _, file := range testFiles {
+		blockTmp, err := loadBlocks(file)
+		if err != nil {
+			t.Fatalf("Error loading file: %v\n", err)
+		}
+		blocks = append(blocks, blockTmp...)
+	}
+
+	chain, teardownFunc, err := chainSetup("reorgstats",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Since we're not dealing with the real block chain, set the coinbase
+	// maturity to 1.
+	chain.TstSetCoinbaseMaturity(1)
+
+	// Extending the main chain and accepting side chain blocks that don't
+	// become the main chain must not be counted as reorganizations.
+	for i := 1; i < len(blocks)-1; i++ {
+		_, _, err := chain.ProcessBlock(blocks[i], BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+	}
+	if stats := chain.ReorgStats(); stats != (ReorgStats{}) {
+		t.Fatalf("unexpected reorg stats before reorg: %+v", stats)
+	}
+
+	// Block 5a causes the side chain to become the main chain which
+	// disconnects blocks 4 and 3.
+	before := time.Now()
+	_, _, err = chain.ProcessBlock(blocks[len(blocks)-1], BFNone)
+	if err != nil {
+		t.Fatalf("ProcessBlock fail on block 5a: %v\n", err)
+	}
+	stats := chain.ReorgStats()
+	if stats.Count != 1 {
+		t.Fatalf("unexpected reorg count -- got %d, want 1", stats.Count)
+	}
+	if stats.LastDepth != 2 || stats.MaxDepth != 2 {
+		t.Fatalf("unexpected reorg depth -- got last %d, max %d, want 2",
+			stats.LastDepth, stats.MaxDepth)
+	}
+	if stats.LastTime.Before(before) {
+		t.Fatalf("unexpected last reorg time %v before %v",
+			stats.LastTime, before)
+	}
+}
+
 // TestCalcSequenceLock tests the LockTimeToSequence function, and the
 // CalcSequenceLock method of a Chain instance. The tests exercise several
 // combinations of inputs to the CalcSequenceLock function in order to ensure
@@ -461,6 +527,111 @@ func nodeHeaders(nodes []*blockNode, indexes ...int) []wire.BlockHeader {
 	return headers
 }
 
+// TestSequenceLockInclusion ensures transactions with block and time based
+// relative lock times are only allowed in blocks once their sequence locks
+// are met, as enforced by block validation and the mempool.
+func TestSequenceLockInclusion(t *testing.T) {
+	netParams := &chaincfg.SimNetParams
+
+	// Generate enough synthetic blocks signalling CSV to activate it.
+	csvBit := netParams.Deployments[chaincfg.DeploymentCSV].BitNumber
+	blockVersion := int32(0x20000000 | (uint32(1) << csvBit))
+	chain := newFakeChain(netParams)
+	node := chain.bestChain.Tip()
+	blockTime := node.Header().Timestamp
+	numBlocksToActivate := int32(netParams.MinerConfirmationWindow * 3)
+	for i := int32(0); i < numBlocksToActivate; i++ {
+		blockTime = blockTime.Add(time.Second)
+		node = newFakeNode(node, blockVersion, 0, blockTime)
+		chain.index.AddNode(node)
+		chain.bestChain.SetTip(node)
+	}
+
+	// Create a utxo view with an output that is 4 blocks old.  Relative
+	// time based locks are measured from the median time past of the
+	// block prior to the one including it.
+	prevTx := btcutil.NewTx(&wire.MsgTx{
+		TxOut: []*wire.TxOut{{Value: 10}},
+	})
+	prevHeight := numBlocksToActivate - 4
+	utxoView := NewUtxoViewpoint()
+	utxoView.AddTxOuts(prevTx, prevHeight)
+	utxoView.SetBestHash(&node.hash)
+	prevOut := wire.OutPoint{Hash: *prevTx.Hash(), Index: 0}
+	prevMedianTime := node.RelativeAncestor(5).CalcPastMedianTime()
+	nextHeight := numBlocksToActivate + 1
+	nextMedianTime := node.CalcPastMedianTime()
+
+	tests := []struct {
+		name     string
+		sequence uint32
+
+		// earliestHeight and earliestTime are the earliest height and
+		// median time past of a block that may include the
+		// transaction.
+		earliestHeight int32
+		earliestTime   time.Time
+	}{
+		{
+			name:           "block based",
+			sequence:       LockTimeToSequence(false, 10),
+			earliestHeight: prevHeight + 10,
+			earliestTime:   nextMedianTime,
+		},
+		{
+			name:           "time based",
+			sequence:       LockTimeToSequence(true, 1024),
+			earliestHeight: nextHeight,
+			earliestTime:   prevMedianTime.Add(1024 * time.Second),
+		},
+	}
+
+	for _, test := range tests {
+		tx := btcutil.NewTx(&wire.MsgTx{
+			Version: 2,
+			TxIn: []*wire.TxIn{{
+				PreviousOutPoint: prevOut,
+				Sequence:         test.sequence,
+			}},
+		})
+		seqLock, err := chain.CalcSequenceLock(tx, utxoView, false)
+		if err != nil {
+			t.Fatalf("%s: unable to calc sequence lock: %v",
+				test.name, err)
+		}
+
+		// Ensure the transaction can't be included in the next block
+		// nor in any block before the earliest height and time.
+		if SequenceLockActive(seqLock, nextHeight, nextMedianTime) {
+			t.Fatalf("%s: sequence lock %+v active for the next "+
+				"block", test.name, seqLock)
+		}
+		if test.earliestHeight > nextHeight && SequenceLockActive(
+			seqLock, test.earliestHeight-1, test.earliestTime) {
+
+			t.Fatalf("%s: sequence lock %+v active before height "+
+				"%d", test.name, seqLock, test.earliestHeight)
+		}
+		if test.earliestTime.After(nextMedianTime) && SequenceLockActive(
+			seqLock, test.earliestHeight,
+			test.earliestTime.Add(-time.Second)) {
+
+			t.Fatalf("%s: sequence lock %+v active before time "+
+				"%v", test.name, seqLock, test.earliestTime)
+		}
+
+		// Ensure the transaction can be included once both the
+		// earliest height and time have been reached.
+		if !SequenceLockActive(seqLock, test.earliestHeight,
+			test.earliestTime) {
+
+			t.Fatalf("%s: sequence lock %+v not active at height "+
+				"%d and time %v", test.name, seqLock,
+				test.earliestHeight, test.earliestTime)
+		}
+	}
+}
+
 // TestLocateInventory ensures that locating inventory via the LocateHeaders and
 // LocateBlocks functions behaves as expected.
 func TestLocateInventory(t *testing.T) {
@@ -964,3 +1135,352 @@ func TestIntervalBlockHashes(t *testing.T) {
 		}
 	}
 }
+
+// TestGenesisCoinbaseUnspendable ensures the outputs of the genesis block
+// coinbase are not added to the utxo set when the chain is initialized and that
+// the genesis block is rejected if it is ever connected since its coinbase is
+// not spendable by consensus rules.
+func TestGenesisCoinbaseUnspendable(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	chain, teardownFunc, err := chainSetup("genesiscoinbase", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	genesis := btcutil.NewBlock(params.GenesisBlock)
+	coinbase := genesis.Transactions()[0]
+	prevOut := wire.OutPoint{Hash: *coinbase.Hash()}
+	for txOutIdx := range coinbase.MsgTx().TxOut {
+		prevOut.Index = uint32(txOutIdx)
+		entry, err := chain.FetchUtxoEntry(prevOut)
+		if err != nil {
+			t.Fatalf("FetchUtxoEntry: unexpected error: %v", err)
+		}
+		if entry != nil && !entry.IsSpent() {
+			t.Fatalf("genesis coinbase output %v is in the utxo set",
+				prevOut)
+		}
+	}
+
+	// Ensure connecting the genesis block is rejected.
+	view := NewUtxoViewpoint()
+	err = chain.checkConnectBlock(chain.bestChain.Genesis(), genesis, view,
+		nil)
+	rerr, ok := err.(RuleError)
+	if !ok || rerr.ErrorCode != ErrMissingTxOut {
+		t.Fatalf("checkConnectBlock: got error %v, want %v", err,
+			ErrMissingTxOut)
+	}
+}
+
+// TestOrphanBlockByteLimit ensures the combined size of the orphan blocks held
+// in memory is bounded by evicting the oldest orphans.
+func TestOrphanBlockByteLimit(t *testing.T) {
+	// orphanTestBlock returns an orphan block with a unique previous block
+	// hash and an output script of the given length.
+	orphanTestBlock := func(id byte, scriptLen int) *btcutil.Block {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: wire.MaxPrevOutIndex},
+			nil, nil))
+		tx.AddTxOut(wire.NewTxOut(0, make([]byte, scriptLen)))
+		block := wire.NewMsgBlock(&wire.BlockHeader{
+			PrevBlock: chainhash.Hash{id},
+		})
+		block.AddTransaction(tx)
+		return btcutil.NewBlock(block)
+	}
+
+	// Only allow three orphan blocks of the test size.
+	chain := newFakeChain(&chaincfg.MainNetParams)
+	chain.orphans = make(map[chainhash.Hash]*orphanBlock)
+	chain.prevOrphans = make(map[chainhash.Hash][]*orphanBlock)
+	blockSize := orphanTestBlock(0, 100000).MsgBlock().SerializeSize()
+	chain.maxOrphanBytes = blockSize*3 + blockSize/2
+
+	const numBlocks = 6
+	blocks := make([]*btcutil.Block, 0, numBlocks)
+	for i := 0; i < numBlocks; i++ {
+		block := orphanTestBlock(byte(i+1), 100000)
+		blocks = append(blocks, block)
+		chain.addOrphanBlock(block)
+
+		var orphanBytes int
+		for _, oBlock := range chain.orphans {
+			orphanBytes += oBlock.block.MsgBlock().SerializeSize()
+		}
+		if chain.orphanBytes != orphanBytes {
+			t.Fatalf("block %d: orphan bytes mismatch -- got %d, "+
+				"want %d", i, chain.orphanBytes, orphanBytes)
+		}
+		if orphanBytes > chain.maxOrphanBytes {
+			t.Fatalf("block %d: orphan bytes %d exceed the limit "+
+				"of %d", i, orphanBytes, chain.maxOrphanBytes)
+		}
+	}
+
+	// Only the three most recent orphans should remain.
+	for i, block := range blocks {
+		want := i >= numBlocks-3
+		if got := chain.IsKnownOrphan(block.Hash()); got != want {
+			t.Fatalf("block %d: unexpected orphan status -- got "+
+				"%v, want %v", i, got, want)
+		}
+	}
+
+	// A block which exceeds the limit on its own must not be cached nor
+	// evict any other orphans.
+	block := orphanTestBlock(numBlocks+1, chain.maxOrphanBytes)
+	chain.addOrphanBlock(block)
+	if chain.IsKnownOrphan(block.Hash()) {
+		t.Fatal("oversized orphan block was cached")
+	}
+	if len(chain.orphans) != 3 {
+		t.Fatalf("unexpected number of orphans -- got %d, want 3",
+			len(chain.orphans))
+	}
+}
+
+// TestVerificationProgress ensures the verification progress estimated from
+// the transaction rate of recent blocks only increases along a chain which
+// grows denser over time, is low for blocks far in the past, and is close to
+// one near the current time.
+func TestVerificationProgress(t *testing.T) {
+	chain := &BlockChain{chainParams: &chaincfg.RegressionNetParams}
+	genesisTime := chain.chainParams.GenesisBlock.Header.Timestamp.Unix()
+
+	// Create a chain of blocks a minute apart, with a timestamp that goes
+	// back every hundred blocks, where the number of transactions per
+	// block grows along the chain.
+	const numBlocks = 2000
+	samples := make([]txRateSample, 0, numBlocks)
+	timestamp, totalTxns := genesisTime, uint64(1)
+	chain.addTxRateSample(timestamp, totalTxns)
+	samples = append(samples, chain.txRateSamples[0])
+	for i := 1; i < numBlocks; i++ {
+		timestamp += 60
+		if i%100 == 0 {
+			timestamp -= 90
+		}
+		totalTxns += 1 + uint64(i)/10
+		chain.addTxRateSample(timestamp, totalTxns)
+		samples = append(samples,
+			chain.txRateSamples[len(chain.txRateSamples)-1])
+	}
+	if len(chain.txRateSamples) != txRateWindow {
+		t.Fatalf("got %d samples, want %d", len(chain.txRateSamples),
+			txRateWindow)
+	}
+
+	// Ensure the progress as of a day after the tip never decreases along
+	// the chain.
+	tip := samples[numBlocks-1]
+	now := time.Unix(tip.timestamp, 0).Add(time.Hour * 24)
+	prevProgress := 0.0
+	for i, sample := range samples {
+		progress := estimateVerificationProgress(sample, now)
+		if progress < prevProgress {
+			t.Fatalf("block %d: progress %v decreased from %v", i,
+				progress, prevProgress)
+		}
+		prevProgress = progress
+	}
+
+	tests := []struct {
+		name     string
+		sample   txRateSample
+		now      time.Time
+		min, max float64
+	}{{
+		name:   "early",
+		sample: samples[numBlocks/10],
+		now:    now,
+		min:    0,
+		max:    0.05,
+	}, {
+		name:   "ten minutes behind",
+		sample: tip,
+		now:    time.Unix(tip.timestamp, 0).Add(time.Minute * 10),
+		min:    0.98,
+		max:    1,
+	}, {
+		name:   "tip",
+		sample: tip,
+		now:    time.Unix(tip.timestamp, 0),
+		min:    1,
+		max:    1,
+	}}
+	for _, test := range tests {
+		progress := estimateVerificationProgress(test.sample, test.now)
+		if progress < test.min || progress > test.max {
+			t.Fatalf("%s: progress %v not in range [%v, %v]",
+				test.name, progress, test.min, test.max)
+		}
+	}
+}
+
+// TestVerificationProgressRestart ensures the samples used to estimate the
+// verification progress are restored when the chain is loaded again.
+func TestVerificationProgressRestart(t *testing.T) {
+	blocks, err := loadBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("Error loading file: %v\n", err)
+	}
+	chain, teardownFunc, err := chainSetup("progressrestart",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Since we're not dealing with the real block chain, set the coinbase
+	// maturity to 1.
+	chain.TstSetCoinbaseMaturity(1)
+
+	for i := 1; i < len(blocks); i++ {
+		_, _, err := chain.ProcessBlock(blocks[i], BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+	}
+
+	restarted, err := New(&Config{
+		DB:          chain.db,
+		ChainParams: chain.chainParams,
+		TimeSource:  NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to load chain: %v", err)
+	}
+	if !reflect.DeepEqual(restarted.txRateSamples, chain.txRateSamples) {
+		t.Fatalf("unexpected samples after restart -- got %+v, want %+v",
+			restarted.txRateSamples, chain.txRateSamples)
+	}
+	now := time.Unix(1700000000, 0)
+	got, want := restarted.VerificationProgress(now),
+		chain.VerificationProgress(now)
+	if got != want {
+		t.Fatalf("unexpected progress after restart -- got %v, want %v",
+			got, want)
+	}
+}
+
+// TestIndexFlushRecovery ensures block index modifications which have not yet
+// been flushed are not required to recover a consistent index after an
+// unclean shutdown and that FlushIndex persists them.
+func TestIndexFlushRecovery(t *testing.T) {
+	// Load up blocks such that there is a side chain.
+	// (genesis block) -> 1 -> 2 -> 3 -> 4
+	//                          \-> 3a
+	testFiles := []string{
+		"blk_0_to_4.dat.bz2",
+		"blk_3A.dat.bz2",
+	}
+
+	var blocks []*btcutil.Block
+	for _, file := range testFiles {
+		blockTmp, err := loadBlocks(file)
+		if err != nil {
+			t.Fatalf("Error loading file: %v\n", err)
+		}
+		blocks = append(blocks, blockTmp...)
+	}
+	sideBlock := blocks[len(blocks)-1]
+	mainBlocks := blocks[:len(blocks)-1]
+
+	// Create a new database and chain instance to run tests against.
+	chain, teardownFunc, err := chainSetup("indexflushrecovery",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// newChain returns a new chain instance loaded from the database used
+	// by the original one, simulating a restart.
+	newChain := func() *BlockChain {
+		t.Helper()
+		c, err := New(&Config{
+			DB:          chain.db,
+			ChainParams: chain.chainParams,
+			TimeSource:  NewMedianTime(),
+		})
+		if err != nil {
+			t.Fatalf("failed to create chain instance: %v", err)
+		}
+		c.TstSetCoinbaseMaturity(1)
+		return c
+	}
+
+	// Since we're not dealing with the real block chain, set the coinbase
+	// maturity to 1.
+	chain.TstSetCoinbaseMaturity(1)
+
+	for i := 1; i < len(blocks); i++ {
+		_, isOrphan, err := chain.ProcessBlock(blocks[i], BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+		if isOrphan {
+			t.Fatalf("ProcessBlock incorrectly returned block %v "+
+				"is an orphan\n", i)
+		}
+	}
+
+	// The side chain block was accepted right after the best chain was
+	// updated, so its node must still be waiting to be flushed.
+	if _, ok := chain.index.dirty[chain.index.LookupNode(sideBlock.Hash())]; !ok {
+		t.Fatal("side chain block node was flushed before the interval " +
+			"elapsed")
+	}
+
+	// Simulate a crash by loading a new chain instance without flushing
+	// the index of the original one.
+	recovered := newChain()
+	tip := mainBlocks[len(mainBlocks)-1]
+	best := recovered.BestSnapshot()
+	if best.Hash != *tip.Hash() || best.Height != int32(len(mainBlocks)-1) {
+		t.Fatalf("unexpected best block after recovery -- got %v (%d), "+
+			"want %v (%d)", best.Hash, best.Height, tip.Hash(),
+			len(mainBlocks)-1)
+	}
+	for i, block := range mainBlocks {
+		node := recovered.index.LookupNode(block.Hash())
+		if node == nil {
+			t.Fatalf("block %d missing from recovered index", i)
+		}
+		if i > 0 && !recovered.index.NodeStatus(node).KnownValid() {
+			t.Fatalf("block %d not marked valid in recovered index", i)
+		}
+		if !recovered.MainChainHasBlock(block.Hash()) {
+			t.Fatalf("block %d not in recovered main chain", i)
+		}
+	}
+
+	// The unflushed side chain block must be unknown to the recovered
+	// chain and accepted again when it is next received.
+	have, err := recovered.HaveBlock(sideBlock.Hash())
+	if err != nil {
+		t.Fatalf("HaveBlock: %v", err)
+	}
+	if have {
+		t.Fatal("unflushed side chain block known after recovery")
+	}
+	isMainChain, isOrphan, err := recovered.ProcessBlock(sideBlock, BFNone)
+	if err != nil {
+		t.Fatalf("ProcessBlock fail on side chain block: %v", err)
+	}
+	if isMainChain || isOrphan {
+		t.Fatalf("unexpected side chain block status -- main chain %v, "+
+			"orphan %v", isMainChain, isOrphan)
+	}
+
+	// Flushing the index must persist the side chain block node across a
+	// restart.
+	if err := recovered.FlushIndex(); err != nil {
+		t.Fatalf("FlushIndex: %v", err)
+	}
+	if node := newChain().index.LookupNode(sideBlock.Hash()); node == nil {
+		t.Fatal("flushed side chain block missing after restart")
+	}
+}
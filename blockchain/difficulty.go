@@ -5,6 +5,7 @@
 package blockchain
 
 import (
+	"math"
 	"math/big"
 	"time"
 
@@ -152,6 +153,63 @@ func CalcWork(bits uint32) *big.Int {
 	return new(big.Int).Div(oneLsh256, denominator)
 }
 
+// DifficultyFromBits returns the difficulty the passed difficulty bits
+// represent as a multiple of the minimum difficulty, which is the one
+// represented by the passed proof-of-work limit bits.  The limit is used in
+// its compact form since that is the easiest target a block header is able to
+// commit to.  A difficulty of zero is returned for bits that do not represent
+// a positive target.
+func DifficultyFromBits(bits, powLimitBits uint32) float64 {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	difficulty := new(big.Rat).SetFrac(CompactToBig(powLimitBits), target)
+	diff, _ := difficulty.Float64()
+	return diff
+}
+
+// BitsFromDifficulty returns the difficulty bits that represent the passed
+// difficulty as a multiple of the minimum difficulty, which is the one
+// represented by the passed proof-of-work limit bits.  It is the inverse of
+// DifficultyFromBits, so difficulties at or below the minimum result in the
+// limit bits.
+func BitsFromDifficulty(difficulty float64, powLimitBits uint32) uint32 {
+	if math.IsNaN(difficulty) || difficulty <= 1 {
+		return powLimitBits
+	}
+	if math.IsInf(difficulty, 1) {
+		return BigToCompact(bigOne)
+	}
+
+	// target = powLimit / difficulty
+	quotient := new(big.Rat).SetFrac(CompactToBig(powLimitBits), bigOne)
+	quotient.Quo(quotient, new(big.Rat).SetFloat64(difficulty))
+	target := new(big.Int).Quo(quotient.Num(), quotient.Denom())
+	if target.Sign() == 0 {
+		return BigToCompact(bigOne)
+	}
+
+	// The difficulty is only an approximation of the target, so round the
+	// target to the precision of the compact form as opposed to truncating
+	// it.  This ensures converting the difficulty of bits back results in
+	// the same bits.  Targets whose most significant byte has the high bit
+	// set only retain two bytes of precision since the compact form would
+	// otherwise treat them as negative.
+	numBytes := uint(len(target.Bytes()))
+	if numBytes > 3 {
+		shift := 8 * (numBytes - 3)
+		if target.Bit(int(8*numBytes-1)) == 1 {
+			shift += 8
+		}
+		target.Add(target, new(big.Int).Lsh(bigOne, shift-1))
+		target.Rsh(target, shift)
+		target.Lsh(target, shift)
+	}
+	return BigToCompact(target)
+}
+
 // calcEasiestDifficulty calculates the easiest possible difficulty that a block
 // can have given starting difficulty bits and a duration.  It is mainly used to
 // verify that claimed proof of work by a block is sane as compared to a
@@ -251,6 +309,12 @@ func (b *BlockChain) calcNextRequiredDifficulty(lastNode *blockNode, newBlockTim
 		return lastNode.bits, nil
 	}
 
+	// Networks that don't retarget keep requiring the difficulty of the
+	// previous block.
+	if b.chainParams.NoRetargeting {
+		return lastNode.bits, nil
+	}
+
 	// Get the block node at the previous retarget (targetTimespan days
 	// worth of blocks).
 	firstNode := lastNode.RelativeAncestor(b.blocksPerRetarget - 1)
@@ -5,8 +5,12 @@
 package blockchain
 
 import (
+	"math"
 	"math/big"
 	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
 )
 
 // TestBigToCompact ensures BigToCompact converts big integers to the expected
@@ -73,3 +77,174 @@ func TestCalcWork(t *testing.T) {
 		}
 	}
 }
+
+// TestDifficultyBits ensures difficulty bits round trip through their big
+// integer and difficulty representations and that the difficulties match known
+// values.
+func TestDifficultyBits(t *testing.T) {
+	mainPowLimitBits := chaincfg.MainNetParams.PowLimitBits
+	regPowLimitBits := chaincfg.RegressionNetParams.PowLimitBits
+	tests := []struct {
+		name         string
+		bits         uint32
+		powLimitBits uint32
+		difficulty   float64
+	}{
+		{"mainnet genesis", 0x1d00ffff, mainPowLimitBits, 1},
+		{"mainnet block 32256", 0x1d00d86a, mainPowLimitBits, 1.182899534312841},
+		{"mainnet block 100000", 0x1b04864c, mainPowLimitBits, 14484.162361225399},
+		{"mainnet block 300000", 0x1900896c, mainPowLimitBits, 8000872135.968163},
+		{"regtest genesis", 0x207fffff, regPowLimitBits, 1},
+		{"regtest relative to mainnet", 0x207fffff, mainPowLimitBits, 4.656542373906925e-10},
+	}
+
+	for _, test := range tests {
+		// Ensure the bits round trip through their big integer form.
+		if bits := BigToCompact(CompactToBig(test.bits)); bits != test.bits {
+			t.Errorf("%s: big integer round trip got %08x, want %08x",
+				test.name, bits, test.bits)
+			continue
+		}
+
+		// Ensure the difficulty matches the known value.
+		difficulty := DifficultyFromBits(test.bits, test.powLimitBits)
+		if math.Abs(difficulty-test.difficulty) > test.difficulty*1e-12 {
+			t.Errorf("%s: got difficulty %v, want %v", test.name,
+				difficulty, test.difficulty)
+			continue
+		}
+
+		// Ensure the difficulty converts back to the same bits unless it
+		// is below the minimum, in which case it is limited to the
+		// proof-of-work limit.
+		wantBits := test.bits
+		if difficulty < 1 {
+			wantBits = test.powLimitBits
+		}
+		bits := BitsFromDifficulty(difficulty, test.powLimitBits)
+		if bits != wantBits {
+			t.Errorf("%s: difficulty round trip got %08x, want %08x",
+				test.name, bits, wantBits)
+		}
+	}
+
+	// Ensure invalid values are handled.
+	if difficulty := DifficultyFromBits(0, mainPowLimitBits); difficulty != 0 {
+		t.Errorf("got difficulty %v for zero target, want 0", difficulty)
+	}
+	if bits := BitsFromDifficulty(math.NaN(), mainPowLimitBits); bits != mainPowLimitBits {
+		t.Errorf("got bits %08x for NaN difficulty, want %08x", bits,
+			mainPowLimitBits)
+	}
+	if bits := BitsFromDifficulty(math.Inf(1), mainPowLimitBits); bits != 0x01010000 {
+		t.Errorf("got bits %08x for infinite difficulty, want %08x",
+			bits, 0x01010000)
+	}
+}
+
+// TestNoRetargeting ensures the required difficulty never changes from the
+// proof-of-work limit on networks that don't retarget even when blocks are
+// found much faster than the target time per block.
+func TestNoRetargeting(t *testing.T) {
+	// mineBlocks extends the chain for the passed params with blocks that
+	// are one second apart and each require the calculated difficulty.  It
+	// returns the difficulty bits of every block.
+	mineBlocks := func(params *chaincfg.Params, numBlocks int32) []uint32 {
+		chain := newFakeChain(params)
+		tip := chain.bestChain.Tip()
+		allBits := make([]uint32, 0, numBlocks)
+		for i := int32(0); i < numBlocks; i++ {
+			blockTime := time.Unix(tip.timestamp+1, 0)
+			bits, err := chain.calcNextRequiredDifficulty(tip, blockTime)
+			if err != nil {
+				t.Fatalf("calcNextRequiredDifficulty: unexpected "+
+					"error: %v", err)
+			}
+			tip = newFakeNode(tip, 1, bits, blockTime)
+			chain.index.AddNode(tip)
+			allBits = append(allBits, bits)
+		}
+		return allBits
+	}
+
+	params := chaincfg.RegressionNetParams
+	if !params.NoRetargeting {
+		t.Fatal("regtest is expected to not retarget")
+	}
+	blocksPerRetarget := int32(params.TargetTimespan /
+		params.TargetTimePerBlock)
+	for height, bits := range mineBlocks(&params, blocksPerRetarget*3) {
+		if bits != params.PowLimitBits {
+			t.Fatalf("unexpected bits at height %d - got %08x, "+
+				"want %08x", height+1, bits, params.PowLimitBits)
+		}
+	}
+
+	// Ensure the difficulty would have been adjusted at the first retarget
+	// interval without the flag.
+	params.NoRetargeting = false
+	params.ReduceMinDifficulty = false
+	allBits := mineBlocks(&params, blocksPerRetarget)
+	if bits := allBits[blocksPerRetarget-1]; bits == params.PowLimitBits {
+		t.Fatalf("difficulty not adjusted at retarget - got %08x", bits)
+	}
+}
+
+// TestReduceMinDifficulty ensures blocks on networks that reduce the minimum
+// difficulty are allowed to use the proof-of-work limit once too much time has
+// passed since the previous block and that blocks found in time afterwards go
+// back to the difficulty of the last block without the special rule applied.
+func TestReduceMinDifficulty(t *testing.T) {
+	params := &chaincfg.TestNet3Params
+	if !params.ReduceMinDifficulty {
+		t.Fatal("testnet is expected to reduce the minimum difficulty")
+	}
+	chain := newFakeChain(params)
+
+	// Extend the chain with blocks found in time that use a difficulty
+	// higher than the minimum.
+	const bits = 0x1c0fffff
+	tip := chain.bestChain.Tip()
+	blockTime := time.Unix(tip.timestamp, 0)
+	for i := 0; i < 10; i++ {
+		blockTime = blockTime.Add(params.TargetTimePerBlock)
+		tip = newFakeNode(tip, 1, bits, blockTime)
+		chain.index.AddNode(tip)
+	}
+
+	tests := []struct {
+		name    string
+		gap     time.Duration
+		want    uint32
+		connect bool
+	}{{
+		name: "block in time requires previous difficulty",
+		gap:  params.MinDiffReductionTime,
+		want: bits,
+	}, {
+		name:    "block after gap allows minimum difficulty",
+		gap:     params.MinDiffReductionTime + time.Second,
+		want:    params.PowLimitBits,
+		connect: true,
+	}, {
+		name: "block in time after minimum difficulty block",
+		gap:  params.TargetTimePerBlock,
+		want: bits,
+	}}
+	for _, test := range tests {
+		newBlockTime := blockTime.Add(test.gap)
+		got, err := chain.calcNextRequiredDifficulty(tip, newBlockTime)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if got != test.want {
+			t.Fatalf("%s: unexpected bits - got %08x, want %08x",
+				test.name, got, test.want)
+		}
+		if test.connect {
+			blockTime = newBlockTime
+			tip = newFakeNode(tip, 1, got, blockTime)
+			chain.index.AddNode(tip)
+		}
+	}
+}
@@ -593,6 +593,7 @@ type AddrIndex struct {
 	// separate mutex.
 	db          database.DB
 	chainParams *chaincfg.Params
+	scriptCache *txscript.ScriptClassCache
 
 	// The following fields are used to quickly link transactions and
 	// addresses that have not been included into a block yet when an
@@ -671,7 +672,7 @@ type writeIndexData map[[addrKeySize]byte][]int
 func (idx *AddrIndex) indexPkScript(data writeIndexData, pkScript []byte, txIdx int) {
 	// Nothing to index if the script is non-standard or otherwise doesn't
 	// contain any addresses.
-	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript,
+	_, addrs, _, err := idx.scriptCache.ExtractPkScriptAddrs(pkScript,
 		idx.chainParams)
 	if err != nil || len(addrs) == 0 {
 		return
@@ -843,7 +844,7 @@ func (idx *AddrIndex) indexUnconfirmedAddresses(pkScript []byte, tx *btcutil.Tx)
 	// The error is ignored here since the only reason it can fail is if the
 	// script fails to parse and it was already validated before being
 	// admitted to the mempool.
-	_, addresses, _, _ := txscript.ExtractPkScriptAddrs(pkScript,
+	_, addresses, _, _ := idx.scriptCache.ExtractPkScriptAddrs(pkScript,
 		idx.chainParams)
 	for _, addr := range addresses {
 		// Ignore unsupported address types.
@@ -962,13 +963,31 @@ func (idx *AddrIndex) UnconfirmedTxnsForAddress(addr btcutil.Address) []*btcutil
 // It implements the Indexer interface which plugs into the IndexManager that in
 // turn is used by the blockchain package.  This allows the index to be
 // seamlessly maintained along with the chain.
-func NewAddrIndex(db database.DB, chainParams *chaincfg.Params) *AddrIndex {
-	return &AddrIndex{
+func NewAddrIndex(db database.DB, chainParams *chaincfg.Params,
+	opts ...AddrIndexOption) *AddrIndex {
+
+	idx := &AddrIndex{
 		db:          db,
 		chainParams: chainParams,
 		txnsByAddr:  make(map[[addrKeySize]byte]map[chainhash.Hash]*btcutil.Tx),
 		addrsByTx:   make(map[chainhash.Hash]map[[addrKeySize]byte]struct{}),
 	}
+	for _, opt := range opts {
+		opt(idx)
+	}
+	return idx
+}
+
+// AddrIndexOption is a functional option that modifies the address index
+// created by NewAddrIndex.
+type AddrIndexOption func(*AddrIndex)
+
+// WithScriptCache makes the address index classify the public key scripts of
+// the indexed transactions with the passed cache.
+func WithScriptCache(scriptCache *txscript.ScriptClassCache) AddrIndexOption {
+	return func(idx *AddrIndex) {
+		idx.scriptCache = scriptCache
+	}
 }
 
 // DropAddrIndex drops the address index from the provided database if it
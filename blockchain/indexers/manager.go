@@ -64,6 +64,28 @@ func dbFetchIndexerTip(dbTx database.Tx, idxKey []byte) (*chainhash.Hash, int32,
 	return &hash, height, nil
 }
 
+// IndexerTip returns the hash and height of the block the provided index has
+// been synced to in the passed database.  An error is returned when the index
+// has not been initialized yet.
+//
+// This function is safe for concurrent access.
+func IndexerTip(db database.DB, indexer Indexer) (*chainhash.Hash, int32, error) {
+	var hash *chainhash.Hash
+	var height int32
+	err := db.View(func(dbTx database.Tx) error {
+		indexesBucket := dbTx.Metadata().Bucket(indexTipsBucketName)
+		if indexesBucket == nil || indexesBucket.Get(indexer.Key()) == nil {
+			return fmt.Errorf("%s has not been initialized",
+				indexer.Name())
+		}
+
+		var err error
+		hash, height, err = dbFetchIndexerTip(dbTx, indexer.Key())
+		return err
+	})
+	return hash, height, err
+}
+
 // dbIndexConnectBlock adds all of the index entries associated with the
 // given block using the provided indexer and updates the tip of the indexer
 // accordingly.  An error will be returned if the current tip for the indexer is
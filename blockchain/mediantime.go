@@ -45,6 +45,12 @@ type MedianTimeSource interface {
 	// Offset returns the number of seconds to adjust the local clock based
 	// upon the median of the time samples added by AddTimeData.
 	Offset() time.Duration
+
+	// MedianOffset returns the median offset of the time samples added by
+	// AddTimeSample from the local clock.  Unlike Offset, it is not limited
+	// to the range the local clock is adjusted by, so it reflects how far
+	// the local clock is likely skewed.
+	MedianOffset() time.Duration
 }
 
 // int64Sorter implements sort.Interface to allow a slice of 64-bit integers to
@@ -79,6 +85,7 @@ type medianTime struct {
 	knownIDs           map[string]struct{}
 	offsets            []int64
 	offsetSecs         int64
+	medianOffsetSecs   int64
 	invalidTimeChecked bool
 }
 
@@ -156,6 +163,7 @@ func (m *medianTime) AddTimeSample(sourceID string, timeVal time.Time) {
 	// At this point the number of offsets in the list is odd, so the
 	// middle value of the sorted offsets is the median.
 	median := sortedOffsets[numOffsets/2]
+	m.medianOffsetSecs = median
 
 	// Set the new offset when the median offset is within the allowed
 	// offset range.
@@ -205,6 +213,19 @@ func (m *medianTime) Offset() time.Duration {
 	return time.Duration(m.offsetSecs) * time.Second
 }
 
+// MedianOffset returns the median offset of the time samples added by
+// AddTimeSample from the local clock regardless of whether it is within the
+// range the local clock is adjusted by.
+//
+// This function is safe for concurrent access and is part of the
+// MedianTimeSource interface implementation.
+func (m *medianTime) MedianOffset() time.Duration {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return time.Duration(m.medianOffsetSecs) * time.Second
+}
+
 // NewMedianTime returns a new instance of concurrency-safe implementation of
 // the MedianTimeSource interface.  The returned implementation contains the
 // rules necessary for proper time handling in the chain consensus rules and
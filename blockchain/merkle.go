@@ -154,6 +154,37 @@ func BuildMerkleTreeStore(transactions []*btcutil.Tx, witness bool) []*chainhash
 	return merkles
 }
 
+// CalcMerkleRoot calculates the merkle root of the passed transactions using
+// their regular transaction hashes, which is the root committed to by the
+// MerkleRoot field of a block header.  It produces the same result as the last
+// element of BuildMerkleTreeStore without storing the entire tree.  When a level
+// of the tree has an odd number of nodes, the last one is hashed with itself.
+// The zero hash is returned when there are no transactions.
+func CalcMerkleRoot(transactions []*btcutil.Tx) chainhash.Hash {
+	if len(transactions) == 0 {
+		return chainhash.Hash{}
+	}
+
+	hashes := make([]*chainhash.Hash, 0, len(transactions)+1)
+	for _, tx := range transactions {
+		hashes = append(hashes, tx.Hash())
+	}
+
+	// Replace each level of the tree with its parent level until only the
+	// root remains.
+	for len(hashes) > 1 {
+		if len(hashes)%2 != 0 {
+			hashes = append(hashes, hashes[len(hashes)-1])
+		}
+		for i := 0; i < len(hashes)/2; i++ {
+			hashes[i] = HashMerkleBranches(hashes[i*2], hashes[i*2+1])
+		}
+		hashes = hashes[:len(hashes)/2]
+	}
+
+	return *hashes[0]
+}
+
 // ExtractWitnessCommitment attempts to locate, and return the witness
 // commitment for a block. The witness commitment is of the form:
 // SHA256(witness root || witness nonce). The function additionally returns a
@@ -5,8 +5,11 @@
 package blockchain
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcutil"
 )
 
@@ -21,3 +24,52 @@ func TestMerkle(t *testing.T) {
 			"got %v, want %v", calculatedMerkleRoot, wantMerkle)
 	}
 }
+
+// TestCalcMerkleRoot ensures CalcMerkleRoot matches the merkle root committed
+// to by block headers and the root of the tree built by BuildMerkleTreeStore.
+func TestCalcMerkleRoot(t *testing.T) {
+	genesis := btcutil.NewBlock(chaincfg.MainNetParams.GenesisBlock)
+	block := btcutil.NewBlock(&Block100000)
+	tests := []struct {
+		name string
+		txns []*btcutil.Tx
+		want chainhash.Hash
+	}{{
+		name: "mainnet genesis",
+		txns: genesis.Transactions(),
+		want: chaincfg.MainNetParams.GenesisBlock.Header.MerkleRoot,
+	}, {
+		name: "block 100000",
+		txns: block.Transactions(),
+		want: Block100000.Header.MerkleRoot,
+	}, {
+		name: "no transactions",
+		txns: nil,
+		want: chainhash.Hash{},
+	}}
+
+	// Include odd numbers of transactions, which hash the last node of a
+	// level with itself, by using subsets of the transactions in block
+	// 100000.
+	for n := 2; n < len(block.Transactions()); n++ {
+		txns := block.Transactions()[:n]
+		merkles := BuildMerkleTreeStore(txns, false)
+		tests = append(tests, struct {
+			name string
+			txns []*btcutil.Tx
+			want chainhash.Hash
+		}{
+			name: fmt.Sprintf("%d transactions", n),
+			txns: txns,
+			want: *merkles[len(merkles)-1],
+		})
+	}
+
+	for _, test := range tests {
+		got := CalcMerkleRoot(test.txns)
+		if got != test.want {
+			t.Errorf("%s: merkle root mismatch - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
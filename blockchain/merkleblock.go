@@ -0,0 +1,229 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// partialMerkleTree is used to house intermediate information needed to build
+// the partial merkle tree of a wire.MsgMerkleBlock or to extract the matched
+// transactions from one.
+type partialMerkleTree struct {
+	numTx       uint32
+	allHashes   []*chainhash.Hash
+	finalHashes []*chainhash.Hash
+	matchedBits []byte
+	bits        []byte
+
+	// The following fields track the progress of traversing an existing
+	// partial merkle tree.
+	bitsUsed   int
+	hashesUsed int
+	matches    []*chainhash.Hash
+}
+
+// calcTreeWidth calculates and returns the the number of nodes (width) or a
+// merkle tree at the given depth-first height.
+func (m *partialMerkleTree) calcTreeWidth(height uint32) uint32 {
+	return (m.numTx + (1 << height) - 1) >> height
+}
+
+// calcTreeHeight returns the number of merkle branches (height) in the tree.
+func (m *partialMerkleTree) calcTreeHeight() uint32 {
+	height := uint32(0)
+	for m.calcTreeWidth(height) > 1 {
+		height++
+	}
+	return height
+}
+
+// calcHash returns the hash for a sub-tree given a depth-first height and
+// node position.
+func (m *partialMerkleTree) calcHash(height, pos uint32) *chainhash.Hash {
+	if height == 0 {
+		return m.allHashes[pos]
+	}
+
+	var right *chainhash.Hash
+	left := m.calcHash(height-1, pos*2)
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		right = m.calcHash(height-1, pos*2+1)
+	} else {
+		right = left
+	}
+	return HashMerkleBranches(left, right)
+}
+
+// traverseAndBuild builds a partial merkle tree using a recursive depth-first
+// approach.  As it calculates the hashes, it also saves whether or not each
+// node is a parent node and a list of final hashes to be included in the
+// merkle block.
+func (m *partialMerkleTree) traverseAndBuild(height, pos uint32) {
+	// Determine whether this node is a parent of a matched node.
+	var isParent byte
+	for i := pos << height; i < (pos+1)<<height && i < m.numTx; i++ {
+		isParent |= m.matchedBits[i]
+	}
+	m.bits = append(m.bits, isParent)
+
+	// When the node is a leaf node or not a parent of a matched node,
+	// append the hash to the list that will be part of the final merkle
+	// block.
+	if height == 0 || isParent == 0x00 {
+		m.finalHashes = append(m.finalHashes, m.calcHash(height, pos))
+		return
+	}
+
+	// Descend into the left child and process its sub-tree.
+	m.traverseAndBuild(height-1, pos*2)
+
+	// Descend into the right child and process its sub-tree if
+	// there is one.
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		m.traverseAndBuild(height-1, pos*2+1)
+	}
+}
+
+// traverseAndExtract traverses the partial merkle tree built by
+// traverseAndBuild in the same depth-first order while recording the matched
+// transactions.  It returns the hash of the sub-tree at the given depth-first
+// height and node position.
+func (m *partialMerkleTree) traverseAndExtract(height, pos uint32) (*chainhash.Hash, error) {
+	if m.bitsUsed >= len(m.bits) {
+		return nil, fmt.Errorf("partial merkle tree uses more flag " +
+			"bits than provided")
+	}
+	isParent := m.bits[m.bitsUsed]
+	m.bitsUsed++
+
+	// Leaf nodes and nodes that are not a parent of a matched node are
+	// represented by their hash.
+	if height == 0 || isParent == 0x00 {
+		if m.hashesUsed >= len(m.finalHashes) {
+			return nil, fmt.Errorf("partial merkle tree uses more " +
+				"hashes than provided")
+		}
+		hash := m.finalHashes[m.hashesUsed]
+		m.hashesUsed++
+		if height == 0 && isParent != 0x00 {
+			m.matches = append(m.matches, hash)
+		}
+		return hash, nil
+	}
+
+	// Descend into the left child and the right child when there is one.
+	left, err := m.traverseAndExtract(height-1, pos*2)
+	if err != nil {
+		return nil, err
+	}
+	right := left
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		right, err = m.traverseAndExtract(height-1, pos*2+1)
+		if err != nil {
+			return nil, err
+		}
+
+		// Identical left and right branches would allow the same
+		// merkle root to prove different sets of transactions.  See
+		// CVE-2012-2459.
+		if left.IsEqual(right) {
+			return nil, fmt.Errorf("partial merkle tree contains " +
+				"identical left and right branches")
+		}
+	}
+	return HashMerkleBranches(left, right), nil
+}
+
+// NewMerkleBlock returns a merkle block for the passed block that proves the
+// transactions with the passed hashes are included in it.  Hashes of
+// transactions that are not in the block are ignored.
+func NewMerkleBlock(block *btcutil.Block, txHashes map[chainhash.Hash]struct{}) *wire.MsgMerkleBlock {
+	numTx := uint32(len(block.Transactions()))
+	tree := partialMerkleTree{
+		numTx:       numTx,
+		allHashes:   make([]*chainhash.Hash, 0, numTx),
+		matchedBits: make([]byte, 0, numTx),
+	}
+	for _, tx := range block.Transactions() {
+		var matched byte
+		if _, ok := txHashes[*tx.Hash()]; ok {
+			matched = 0x01
+		}
+		tree.matchedBits = append(tree.matchedBits, matched)
+		tree.allHashes = append(tree.allHashes, tx.Hash())
+	}
+
+	// Build the depth-first partial merkle tree.
+	tree.traverseAndBuild(tree.calcTreeHeight(), 0)
+
+	// Create and return the merkle block.
+	msgMerkleBlock := wire.MsgMerkleBlock{
+		Header:       block.MsgBlock().Header,
+		Transactions: tree.numTx,
+		Hashes:       make([]*chainhash.Hash, 0, len(tree.finalHashes)),
+		Flags:        make([]byte, (len(tree.bits)+7)/8),
+	}
+	for _, hash := range tree.finalHashes {
+		msgMerkleBlock.AddTxHash(hash)
+	}
+	for i := uint32(0); i < uint32(len(tree.bits)); i++ {
+		msgMerkleBlock.Flags[i/8] |= tree.bits[i] << (i % 8)
+	}
+	return &msgMerkleBlock
+}
+
+// ExtractMerkleBlockMatches returns the hashes of the transactions the passed
+// merkle block proves are included in the block it commits to.  An error is
+// returned when the partial merkle tree is malformed or does not hash to the
+// merkle root of the block header.
+func ExtractMerkleBlockMatches(msg *wire.MsgMerkleBlock) ([]*chainhash.Hash, error) {
+	// An empty block is not valid and there can't be more hashes than
+	// transactions nor fewer flag bits than hashes.
+	if msg.Transactions == 0 {
+		return nil, fmt.Errorf("merkle block has no transactions")
+	}
+	if uint32(len(msg.Hashes)) > msg.Transactions {
+		return nil, fmt.Errorf("merkle block has %d hashes for %d "+
+			"transactions", len(msg.Hashes), msg.Transactions)
+	}
+	if len(msg.Flags)*8 < len(msg.Hashes) {
+		return nil, fmt.Errorf("merkle block has %d flag bytes for %d "+
+			"hashes", len(msg.Flags), len(msg.Hashes))
+	}
+
+	tree := partialMerkleTree{
+		numTx:       msg.Transactions,
+		finalHashes: msg.Hashes,
+		bits:        make([]byte, len(msg.Flags)*8),
+	}
+	for i := range tree.bits {
+		tree.bits[i] = (msg.Flags[i/8] >> (uint(i) % 8)) & 0x01
+	}
+	root, err := tree.traverseAndExtract(tree.calcTreeHeight(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure all of the hashes and flag bytes were used.
+	if tree.hashesUsed != len(msg.Hashes) {
+		return nil, fmt.Errorf("merkle block has %d unused hashes",
+			len(msg.Hashes)-tree.hashesUsed)
+	}
+	if (tree.bitsUsed+7)/8 != len(msg.Flags) {
+		return nil, fmt.Errorf("merkle block has unused flag bytes")
+	}
+
+	if !root.IsEqual(&msg.Header.MerkleRoot) {
+		return nil, fmt.Errorf("partial merkle tree root %v does not "+
+			"match the block merkle root %v", root,
+			msg.Header.MerkleRoot)
+	}
+	return tree.matches, nil
+}
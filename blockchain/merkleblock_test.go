@@ -0,0 +1,87 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TestMerkleBlock ensures merkle blocks created for every subset of the
+// transactions in a block prove exactly those transactions and that tampered
+// merkle blocks are rejected.
+func TestMerkleBlock(t *testing.T) {
+	block := btcutil.NewBlock(&Block100000)
+	txns := block.Transactions()
+	for subset := 0; subset < 1<<uint(len(txns)); subset++ {
+		txHashes := make(map[chainhash.Hash]struct{})
+		var want []*chainhash.Hash
+		for i, tx := range txns {
+			if subset&(1<<uint(i)) != 0 {
+				txHashes[*tx.Hash()] = struct{}{}
+				want = append(want, tx.Hash())
+			}
+		}
+
+		msg := NewMerkleBlock(block, txHashes)
+		got, err := ExtractMerkleBlockMatches(msg)
+		if err != nil {
+			t.Fatalf("subset %b: unexpected error: %v", subset, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("subset %b: unexpected matches - got %v, want %v",
+				subset, got, want)
+		}
+	}
+
+	// Ensure tampered merkle blocks are rejected.
+	tests := []struct {
+		name   string
+		tamper func(msg *wire.MsgMerkleBlock)
+	}{{
+		name: "modified hash",
+		tamper: func(msg *wire.MsgMerkleBlock) {
+			msg.Hashes[0] = &chainhash.Hash{0x01}
+		},
+	}, {
+		name: "missing hash",
+		tamper: func(msg *wire.MsgMerkleBlock) {
+			msg.Hashes = msg.Hashes[:len(msg.Hashes)-1]
+		},
+	}, {
+		name: "extra hash",
+		tamper: func(msg *wire.MsgMerkleBlock) {
+			msg.Hashes = append(msg.Hashes, &chainhash.Hash{})
+		},
+	}, {
+		name: "extra flag byte",
+		tamper: func(msg *wire.MsgMerkleBlock) {
+			msg.Flags = append(msg.Flags, 0x00)
+		},
+	}, {
+		name: "modified transaction count",
+		tamper: func(msg *wire.MsgMerkleBlock) {
+			msg.Transactions++
+		},
+	}, {
+		name: "no transactions",
+		tamper: func(msg *wire.MsgMerkleBlock) {
+			msg.Transactions = 0
+		},
+	}}
+	for _, test := range tests {
+		msg := NewMerkleBlock(block, map[chainhash.Hash]struct{}{
+			*txns[1].Hash(): {},
+		})
+		test.tamper(msg)
+		if _, err := ExtractMerkleBlockMatches(msg); err == nil {
+			t.Fatalf("%s: expected error", test.name)
+		}
+	}
+}
@@ -6,6 +6,8 @@ package blockchain
 
 import (
 	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
 // NotificationType represents the type of a notification message.
@@ -29,14 +31,26 @@ const (
 	// NTBlockDisconnected indicates the associated block was disconnected
 	// from the main chain.
 	NTBlockDisconnected
+
+	// NTReorganization indicates the main chain is about to be reorganized
+	// to a side chain with more cumulative work.  It is sent before any of
+	// the blocks involved in the reorganization are disconnected.
+	NTReorganization
+
+	// NTReorganizationAborted indicates a reorganization announced by
+	// NTReorganization failed before all of its blocks were disconnected
+	// and connected, so no further notifications for it will be sent.
+	NTReorganizationAborted
 )
 
 // notificationTypeStrings is a map of notification types back to their constant
 // names for pretty printing.
 var notificationTypeStrings = map[NotificationType]string{
-	NTBlockAccepted:     "NTBlockAccepted",
-	NTBlockConnected:    "NTBlockConnected",
-	NTBlockDisconnected: "NTBlockDisconnected",
+	NTBlockAccepted:         "NTBlockAccepted",
+	NTBlockConnected:        "NTBlockConnected",
+	NTBlockDisconnected:     "NTBlockDisconnected",
+	NTReorganization:        "NTReorganization",
+	NTReorganizationAborted: "NTReorganizationAborted",
 }
 
 // String returns the NotificationType in human-readable form.
@@ -47,20 +61,56 @@ func (n NotificationType) String() string {
 	return fmt.Sprintf("Unknown Notification Type (%d)", int(n))
 }
 
+// ReorganizationNtfnsData is the structure for data indicating information
+// about a reorganization of the main chain.
+type ReorganizationNtfnsData struct {
+	OldHash    chainhash.Hash
+	OldHeight  int32
+	NewHash    chainhash.Hash
+	NewHeight  int32
+	ForkHash   chainhash.Hash
+	ForkHeight int32
+}
+
 // Notification defines notification that is sent to the caller via the callback
 // function provided during the call to New and consists of a notification type
 // as well as associated data that depends on the type as follows:
-// 	- NTBlockAccepted:     *btcutil.Block
-// 	- NTBlockConnected:    *btcutil.Block
-// 	- NTBlockDisconnected: *btcutil.Block
+// 	- NTBlockAccepted:         *btcutil.Block
+// 	- NTBlockConnected:        *btcutil.Block
+// 	- NTBlockDisconnected:     *btcutil.Block
+// 	- NTReorganization:        *ReorganizationNtfnsData
+// 	- NTReorganizationAborted: *ReorganizationNtfnsData
+//
+// The SpentTxOuts field is only populated for NTBlockConnected and
+// NTBlockDisconnected and contains the outputs spent by the block in the same
+// order as the inputs of the block's transactions, excluding the coinbase.
+// It must be treated as read only.
 type Notification struct {
-	Type NotificationType
-	Data interface{}
+	Type        NotificationType
+	Data        interface{}
+	SpentTxOuts []SpentTxOut
 }
 
 // Subscribe to block chain notifications. Registers a callback to be executed
 // when various events take place. See the documentation on Notification and
 // NotificationType for details on the types and contents of notifications.
+//
+// Callbacks are invoked synchronously, one notification at a time, in the order
+// they were registered.  Every callback receives a notification before the
+// next one is delivered.  The chain state, as reported by BestSnapshot, has
+// already been updated to reflect the event by the time NTBlockConnected and
+// NTBlockDisconnected are delivered.
+//
+// During a reorganization the notifications are delivered in the following
+// order:
+// 	1. NTReorganization
+// 	2. NTBlockDisconnected for each block of the old chain starting from the
+// 	   old tip and working back towards the fork point
+// 	3. NTBlockConnected for each block of the new chain starting from the
+// 	   block after the fork point and working towards the new tip
+//
+// NTReorganizationAborted is delivered instead of the remaining notifications
+// when the reorganization fails part way through.
 func (b *BlockChain) Subscribe(callback NotificationCallback) {
 	b.notificationsLock.Lock()
 	b.notifications = append(b.notifications, callback)
@@ -71,8 +121,16 @@ func (b *BlockChain) Subscribe(callback NotificationCallback) {
 // caller requested notifications by providing a callback function in the call
 // to New.
 func (b *BlockChain) sendNotification(typ NotificationType, data interface{}) {
+	b.sendNotificationWithSpends(typ, data, nil)
+}
+
+// sendNotificationWithSpends sends a notification with the passed type, data,
+// and spent transaction outputs to all subscribed callbacks.
+func (b *BlockChain) sendNotificationWithSpends(typ NotificationType,
+	data interface{}, stxos []SpentTxOut) {
+
 	// Generate and send the notification.
-	n := Notification{Type: typ, Data: data}
+	n := Notification{Type: typ, Data: data, SpentTxOuts: stxos}
 	b.notificationsLock.RLock()
 	for _, callback := range b.notifications {
 		callback(&n)
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
 )
 
 // TestNotifications ensures that notification callbacks are fired on events.
@@ -49,3 +51,137 @@ func TestNotifications(t *testing.T) {
 			"times, found %d", numSubscribers, notificationCount)
 	}
 }
+
+// TestReorgNotificationOrder ensures that notification callbacks are fired in
+// registration order and that a reorganization delivers the reorganization,
+// disconnected, and connected notifications in the documented order along
+// with the expected payloads.
+func TestReorgNotificationOrder(t *testing.T) {
+	// Load up blocks such that there is a side chain that ultimately
+	// becomes the main chain.
+	// (genesis block) -> 1 -> 2 -> 3 -> 4
+	//                          \-> 3a -> 4a -> 5a
+	testFiles := []string{
+		"blk_0_to_4.dat.bz2",
+		"blk_3A.dat.bz2",
+		"blk_4A.dat.bz2",
+		"blk_5A.dat.bz2",
+	}
+	var blocks []*btcutil.Block
+	for _, file := range testFiles {
+		blockTmp, err := loadBlocks(file)
+		if err != nil {
+			t.Fatalf("Error loading file: %v\n", err)
+		}
+		blocks = append(blocks, blockTmp...)
+	}
+
+	chain, teardownFunc, err := chainSetup("reorgnotifications",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Since we're not dealing with the real block chain, set the coinbase
+	// maturity to 1.
+	chain.TstSetCoinbaseMaturity(1)
+
+	// Connect the initial main chain before subscribing so only the
+	// notifications caused by the side chain are recorded.
+	for i := 1; i < 5; i++ {
+		_, _, err := chain.ProcessBlock(blocks[i], BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+	}
+
+	// Register two subscribers which record the notifications they
+	// receive into a shared log so the delivery order can be verified.
+	type event struct {
+		subscriber int
+		ntfn       *Notification
+	}
+	var events []event
+	for i := 0; i < 2; i++ {
+		subscriber := i
+		chain.Subscribe(func(n *Notification) {
+			if n.Type == NTBlockAccepted {
+				return
+			}
+			events = append(events, event{subscriber, n})
+		})
+	}
+
+	for i := 5; i < len(blocks); i++ {
+		_, _, err := chain.ProcessBlock(blocks[i], BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+	}
+
+	// Block 5a causes the reorg, so the expected sequence is the reorg
+	// notification followed by the disconnection of blocks 4 and 3 and the
+	// connection of blocks 3a, 4a, and 5a.
+	type expectedNtfn struct {
+		typ  NotificationType
+		hash *chainhash.Hash
+	}
+	expected := []expectedNtfn{
+		{NTReorganization, nil},
+		{NTBlockDisconnected, blocks[4].Hash()},
+		{NTBlockDisconnected, blocks[3].Hash()},
+		{NTBlockConnected, blocks[5].Hash()},
+		{NTBlockConnected, blocks[6].Hash()},
+		{NTBlockConnected, blocks[7].Hash()},
+	}
+	if len(events) != len(expected)*2 {
+		t.Fatalf("unexpected number of notifications -- got %d, want %d",
+			len(events), len(expected)*2)
+	}
+	for i, want := range expected {
+		for subscriber := 0; subscriber < 2; subscriber++ {
+			got := events[i*2+subscriber]
+			if got.subscriber != subscriber {
+				t.Fatalf("notification %d delivered to subscriber "+
+					"%d before subscriber %d", i, got.subscriber,
+					subscriber)
+			}
+			if got.ntfn.Type != want.typ {
+				t.Fatalf("notification %d: unexpected type -- got "+
+					"%v, want %v", i, got.ntfn.Type, want.typ)
+			}
+
+			if want.typ == NTReorganization {
+				data := got.ntfn.Data.(*ReorganizationNtfnsData)
+				if data.OldHash != *blocks[4].Hash() ||
+					data.NewHash != *blocks[7].Hash() ||
+					data.ForkHash != *blocks[2].Hash() {
+
+					t.Fatalf("unexpected reorganization data: "+
+						"%+v", data)
+				}
+				if data.OldHeight != 4 || data.NewHeight != 5 ||
+					data.ForkHeight != 2 {
+
+					t.Fatalf("unexpected reorganization "+
+						"heights: %+v", data)
+				}
+				continue
+			}
+
+			block := got.ntfn.Data.(*btcutil.Block)
+			if !block.Hash().IsEqual(want.hash) {
+				t.Fatalf("notification %d: unexpected block -- "+
+					"got %v, want %v", i, block.Hash(),
+					want.hash)
+			}
+			if len(got.ntfn.SpentTxOuts) != countSpentOutputs(block) {
+				t.Fatalf("notification %d: unexpected number of "+
+					"spent outputs -- got %d, want %d", i,
+					len(got.ntfn.SpentTxOuts),
+					countSpentOutputs(block))
+			}
+		}
+	}
+}
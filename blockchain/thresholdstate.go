@@ -271,6 +271,82 @@ func (b *BlockChain) ThresholdState(deploymentID uint32) (ThresholdState, error)
 	return state, err
 }
 
+// ThresholdStats houses the statistics about the blocks signaling for a rule
+// change within the confirmation window that contains the block after the
+// current best chain tip.
+type ThresholdStats struct {
+	// Period is the number of blocks in each confirmation window.
+	Period uint32
+
+	// Threshold is the number of blocks within a window that must signal
+	// for the rule change to lock in.
+	Threshold uint32
+
+	// Elapsed is the number of blocks of the current window that have
+	// already been mined.
+	Elapsed uint32
+
+	// Count is the number of blocks of the current window that signal for
+	// the rule change.
+	Count uint32
+
+	// Possible indicates whether or not enough blocks of the current
+	// window may still signal for the rule change to lock in.
+	Possible bool
+}
+
+// thresholdStats returns the statistics about the blocks that signal for the
+// rule change of the passed checker within the confirmation window that
+// contains the block after the passed node.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func thresholdStats(prevNode *blockNode, checker thresholdConditionChecker) (ThresholdStats, error) {
+	stats := ThresholdStats{
+		Period:    checker.MinerConfirmationWindow(),
+		Threshold: checker.RuleChangeActivationThreshold(),
+	}
+	if prevNode == nil {
+		stats.Possible = true
+		return stats, nil
+	}
+
+	// Count the signaling blocks back to the final block of the previous
+	// window.
+	period := int32(stats.Period)
+	windowStart := prevNode.height - (prevNode.height+1)%period
+	for node := prevNode; node != nil && node.height > windowStart; node = node.parent {
+		condition, err := checker.Condition(node)
+		if err != nil {
+			return ThresholdStats{}, err
+		}
+		if condition {
+			stats.Count++
+		}
+		stats.Elapsed++
+	}
+	stats.Possible = stats.Period-stats.Threshold >= stats.Elapsed-stats.Count
+	return stats, nil
+}
+
+// DeploymentStats returns the statistics about the blocks signaling for the
+// passed deployment within the confirmation window that contains the block
+// after the current best chain tip.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DeploymentStats(deploymentID uint32) (ThresholdStats, error) {
+	if deploymentID >= uint32(len(b.chainParams.Deployments)) {
+		return ThresholdStats{}, DeploymentError(deploymentID)
+	}
+
+	deployment := &b.chainParams.Deployments[deploymentID]
+	checker := deploymentChecker{deployment: deployment, chain: b}
+
+	b.chainLock.RLock()
+	stats, err := thresholdStats(b.bestChain.Tip(), checker)
+	b.chainLock.RUnlock()
+	return stats, err
+}
+
 // IsDeploymentActive returns true if the target deploymentID is active, and
 // false otherwise.
 //
@@ -6,7 +6,9 @@ package blockchain
 
 import (
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
@@ -132,3 +134,171 @@ nextTest:
 		}
 	}
 }
+
+// TestDeploymentStats ensures the statistics about the blocks signaling for a
+// deployment during the started state match the signaling blocks of the
+// current confirmation window.
+func TestDeploymentStats(t *testing.T) {
+	t.Parallel()
+
+	params := chaincfg.RegressionNetParams
+	chain := newFakeChain(&params)
+	deploymentID := uint32(chaincfg.DeploymentTestDummy)
+	deployment := &params.Deployments[deploymentID]
+	period := params.MinerConfirmationWindow
+	threshold := params.RuleChangeActivationThreshold
+
+	// addNodes extends the best chain with the passed number of blocks
+	// where the passed function determines which of them signal for the
+	// deployment.
+	tip := chain.bestChain.Tip()
+	timestamp := time.Unix(tip.timestamp, 0)
+	addNodes := func(numNodes int, signal func(i int) bool) {
+		for i := 0; i < numNodes; i++ {
+			version := int32(vbTopBits)
+			if signal(i) {
+				version |= 1 << deployment.BitNumber
+			}
+			timestamp = timestamp.Add(time.Minute * 10)
+			tip = newFakeNode(tip, version, 0x207fffff, timestamp)
+			chain.index.AddNode(tip)
+			chain.bestChain.SetTip(tip)
+		}
+	}
+	noSignal := func(int) bool { return false }
+
+	// Complete the first window so the deployment is started for the
+	// second one.
+	addNodes(int(period)-1, noSignal)
+	state, err := chain.ThresholdState(deploymentID)
+	if err != nil {
+		t.Fatalf("ThresholdState: unexpected error: %v", err)
+	}
+	if state != ThresholdStarted {
+		t.Fatalf("ThresholdState: got %v, want %v", state,
+			ThresholdStarted)
+	}
+
+	tests := []struct {
+		name    string
+		numNew  int
+		signal  func(i int) bool
+		elapsed uint32
+		count   uint32
+		want    bool
+	}{{
+		name:    "start of window",
+		numNew:  0,
+		signal:  noSignal,
+		elapsed: 0,
+		count:   0,
+		want:    true,
+	}, {
+		name:    "three quarters signaling",
+		numNew:  100,
+		signal:  func(i int) bool { return i%4 != 0 },
+		elapsed: 100,
+		count:   75,
+		want:    true,
+	}, {
+		name:    "too many blocks without signal",
+		numNew:  20,
+		signal:  noSignal,
+		elapsed: 120,
+		count:   75,
+		want:    false,
+	}, {
+		name:    "next window",
+		numNew:  int(period) - 120 + 3,
+		signal:  func(i int) bool { return true },
+		elapsed: 3,
+		count:   3,
+		want:    true,
+	}}
+	for _, test := range tests {
+		addNodes(test.numNew, test.signal)
+		stats, err := chain.DeploymentStats(deploymentID)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		want := ThresholdStats{
+			Period:    period,
+			Threshold: threshold,
+			Elapsed:   test.elapsed,
+			Count:     test.count,
+			Possible:  test.want,
+		}
+		if stats != want {
+			t.Fatalf("%s: mismatched stats - got %+v, want %+v",
+				test.name, stats, want)
+		}
+	}
+
+	// Ensure unknown deployments are rejected.
+	_, err = chain.DeploymentStats(uint32(len(params.Deployments)))
+	if _, ok := err.(DeploymentError); !ok {
+		t.Fatalf("DeploymentStats: unexpected error for unknown "+
+			"deployment - got %v, want DeploymentError", err)
+	}
+}
+
+// TestThresholdStateMedianTimeTimeout ensures deployment timeouts are based on
+// the median time of the final block of a confirmation window rather than its
+// timestamp, so a deployment that reaches the threshold in the window in which
+// the median time reaches the timeout fails instead of locking in.
+func TestThresholdStateMedianTimeTimeout(t *testing.T) {
+	t.Parallel()
+
+	params := chaincfg.RegressionNetParams
+	chain := newFakeChain(&params)
+	deploymentID := uint32(chaincfg.DeploymentTestDummy)
+	deployment := &params.Deployments[deploymentID]
+	deployment.StartTime = 0
+	period := int(params.MinerConfirmationWindow)
+
+	// Create a window without signaling so the deployment is started for
+	// the second window in which every block signals.
+	tip := chain.bestChain.Tip()
+	timestamp := time.Unix(tip.timestamp, 0)
+	for i := 0; i < 2*period-1; i++ {
+		version := int32(vbTopBits)
+		if i >= period-1 {
+			version |= 1 << deployment.BitNumber
+		}
+		timestamp = timestamp.Add(time.Minute * 10)
+		tip = newFakeNode(tip, version, 0x207fffff, timestamp)
+		chain.index.AddNode(tip)
+	}
+	chain.bestChain.SetTip(tip)
+
+	// The median time of the final block of the signaling window is well
+	// before its timestamp.
+	medianTime := uint64(tip.CalcPastMedianTime().Unix())
+	if uint64(tip.timestamp) <= medianTime+1 {
+		t.Fatalf("timestamp %d is not after median time %d",
+			tip.timestamp, medianTime)
+	}
+
+	tests := []struct {
+		name    string
+		timeout uint64
+		want    ThresholdState
+	}{
+		{"timeout at median time", medianTime, ThresholdFailed},
+		{"timeout after median time", medianTime + 1, ThresholdLockedIn},
+	}
+	for _, test := range tests {
+		deployment.ExpireTime = test.timeout
+		chain.deploymentCaches = newThresholdCaches(
+			chaincfg.DefinedDeployments)
+
+		state, err := chain.ThresholdState(deploymentID)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if state != test.want {
+			t.Fatalf("%s: mismatched state - got %v, want %v",
+				test.name, state, test.want)
+		}
+	}
+}
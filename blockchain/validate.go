@@ -343,6 +343,13 @@ func CheckProofOfWork(block *btcutil.Block, powLimit *big.Int) error {
 	return checkProofOfWork(&block.MsgBlock().Header, powLimit, BFNone)
 }
 
+// CheckHeaderProofOfWork performs the same checks as CheckProofOfWork on a
+// block header alone.  This allows headers to be rejected before the blocks
+// they commit to are known.
+func CheckHeaderProofOfWork(header *wire.BlockHeader, powLimit *big.Int) error {
+	return checkProofOfWork(header, powLimit, BFNone)
+}
+
 // CountSigOps returns the number of signature operations for all transaction
 // input and output scripts in the provided transaction.  This uses the
 // quicker, but imprecise, signature operation counting mechanism from
@@ -527,9 +534,8 @@ func checkBlockSanity(block *btcutil.Block, powLimit *big.Int, timeSource Median
 	// checks.  Bitcoind builds the tree here and checks the merkle root
 	// after the following checks, but there is no reason not to check the
 	// merkle root matches here.
-	merkles := BuildMerkleTreeStore(block.Transactions(), false)
-	calculatedMerkleRoot := merkles[len(merkles)-1]
-	if !header.MerkleRoot.IsEqual(calculatedMerkleRoot) {
+	calculatedMerkleRoot := CalcMerkleRoot(block.Transactions())
+	if !header.MerkleRoot.IsEqual(&calculatedMerkleRoot) {
 		str := fmt.Sprintf("block merkle root is invalid - block "+
 			"header indicates %v, but calculated value is %v",
 			header.MerkleRoot, calculatedMerkleRoot)
@@ -964,6 +970,65 @@ func CheckTransactionInputs(tx *btcutil.Tx, txHeight int32, utxoView *UtxoViewpo
 	return txFeeInSatoshi, nil
 }
 
+// scriptVerifyFlags returns the script verification flags that are enforced by
+// consensus for the passed block node.  The flags are determined by the height
+// of the node in relation to the soft-fork activation heights defined by the
+// chain parameters, the state of the version bits deployments as of its parent,
+// and, for BIP0016, its timestamp.
+//
+// Note that the blocks are only required to have the version that signals the
+// BIP0066 and BIP0065 soft-forks once they are active, which is enforced
+// separately by the contextual header checks.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) scriptVerifyFlags(node *blockNode) (txscript.ScriptFlags, error) {
+	// BIP0016 describes a pay-to-script-hash type that is considered a
+	// "standard" type.  The rules for this BIP only apply to transactions
+	// after the timestamp defined by txscript.Bip16Activation.  See
+	// https://en.bitcoin.it/wiki/BIP_0016 for more details.
+	var scriptFlags txscript.ScriptFlags
+	if node.timestamp >= txscript.Bip16Activation.Unix() {
+		scriptFlags |= txscript.ScriptBip16
+	}
+
+	// Enforce DER signatures once the historical activation height has been
+	// reached.  This is part of BIP0066.
+	if node.height >= b.chainParams.BIP0066Height {
+		scriptFlags |= txscript.ScriptVerifyDERSignatures
+	}
+
+	// Enforce CHECKLOCKTIMEVERIFY once the historical activation height has
+	// been reached.  This is part of BIP0065.
+	if node.height >= b.chainParams.BIP0065Height {
+		scriptFlags |= txscript.ScriptVerifyCheckLockTimeVerify
+	}
+
+	// Enforce CHECKSEQUENCEVERIFY once the soft-fork deployment is fully
+	// active.  This is part of BIP0112.
+	csvState, err := b.deploymentState(node.parent, chaincfg.DeploymentCSV)
+	if err != nil {
+		return 0, err
+	}
+	if csvState == ThresholdActive {
+		scriptFlags |= txscript.ScriptVerifyCheckSequenceVerify
+	}
+
+	// Enforce the segwit soft-fork package, which includes the NULLDUMMY
+	// rule of BIP0147, once the soft-fork has shifted into the "active"
+	// version bits state.
+	segwitState, err := b.deploymentState(node.parent,
+		chaincfg.DeploymentSegwit)
+	if err != nil {
+		return 0, err
+	}
+	if segwitState == ThresholdActive {
+		scriptFlags |= txscript.ScriptVerifyWitness
+		scriptFlags |= txscript.ScriptStrictMultiSig
+	}
+
+	return scriptFlags, nil
+}
+
 // checkConnectBlock performs several checks to confirm connecting the passed
 // block to the chain represented by the passed view does not violate any rules.
 // In addition, the passed view is updated to spend all of the referenced
@@ -1040,20 +1105,15 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		return err
 	}
 
-	// BIP0016 describes a pay-to-script-hash type that is considered a
-	// "standard" type.  The rules for this BIP only apply to transactions
-	// after the timestamp defined by txscript.Bip16Activation.  See
-	// https://en.bitcoin.it/wiki/BIP_0016 for more details.
-	enforceBIP0016 := node.timestamp >= txscript.Bip16Activation.Unix()
-
-	// Query for the Version Bits state for the segwit soft-fork
-	// deployment. If segwit is active, we'll switch over to enforcing all
-	// the new rules.
-	segwitState, err := b.deploymentState(node.parent, chaincfg.DeploymentSegwit)
+	// Determine the script verification flags enforced for the block, which
+	// also determine whether the pay-to-script-hash and segwit rules apply
+	// to the checks below.
+	scriptFlags, err := b.scriptVerifyFlags(node)
 	if err != nil {
 		return err
 	}
-	enforceSegWit := segwitState == ThresholdActive
+	enforceBIP0016 := scriptFlags&txscript.ScriptBip16 != 0
+	enforceSegWit := scriptFlags&txscript.ScriptVerifyWitness != 0
 
 	// The number of signature operations must be less than the maximum
 	// allowed per block.  Note that the preliminary sanity checks on a
@@ -1152,38 +1212,9 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		runScripts = false
 	}
 
-	// Blocks created after the BIP0016 activation time need to have the
-	// pay-to-script-hash checks enabled.
-	var scriptFlags txscript.ScriptFlags
-	if enforceBIP0016 {
-		scriptFlags |= txscript.ScriptBip16
-	}
-
-	// Enforce DER signatures for block versions 3+ once the historical
-	// activation threshold has been reached.  This is part of BIP0066.
-	blockHeader := &block.MsgBlock().Header
-	if blockHeader.Version >= 3 && node.height >= b.chainParams.BIP0066Height {
-		scriptFlags |= txscript.ScriptVerifyDERSignatures
-	}
-
-	// Enforce CHECKLOCKTIMEVERIFY for block versions 4+ once the historical
-	// activation threshold has been reached.  This is part of BIP0065.
-	if blockHeader.Version >= 4 && node.height >= b.chainParams.BIP0065Height {
-		scriptFlags |= txscript.ScriptVerifyCheckLockTimeVerify
-	}
-
-	// Enforce CHECKSEQUENCEVERIFY during all block validation checks once
-	// the soft-fork deployment is fully active.
-	csvState, err := b.deploymentState(node.parent, chaincfg.DeploymentCSV)
-	if err != nil {
-		return err
-	}
-	if csvState == ThresholdActive {
-		// If the CSV soft-fork is now active, then modify the
-		// scriptFlags to ensure that the CSV op code is properly
-		// validated during the script checks bleow.
-		scriptFlags |= txscript.ScriptVerifyCheckSequenceVerify
-
+	// Enforce the relative lock-times of BIP0068 during all block
+	// validation checks once the CSV soft-fork deployment is fully active.
+	if scriptFlags&txscript.ScriptVerifyCheckSequenceVerify != 0 {
 		// We obtain the MTP of the *previous* block in order to
 		// determine if transactions in the current block are final.
 		medianTime := node.parent.CalcPastMedianTime()
@@ -1211,13 +1242,6 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		}
 	}
 
-	// Enforce the segwit soft-fork package once the soft-fork has shifted
-	// into the "active" version bits state.
-	if enforceSegWit {
-		scriptFlags |= txscript.ScriptVerifyWitness
-		scriptFlags |= txscript.ScriptStrictMultiSig
-	}
-
 	// Now that the inexpensive checks are done and have passed, verify the
 	// transactions are actually allowed to spend the coins by running the
 	// expensive ECDSA signature check scripts.  Doing this last helps
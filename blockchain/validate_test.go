@@ -5,6 +5,7 @@
 package blockchain
 
 import (
+	"bytes"
 	"math"
 	"reflect"
 	"testing"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 )
@@ -148,6 +150,69 @@ func TestCheckConnectBlockTemplate(t *testing.T) {
 	}
 }
 
+// TestScriptVerifyFlags ensures the script verification flags enforced for
+// blocks change exactly at the heights the soft-forks activate.
+func TestScriptVerifyFlags(t *testing.T) {
+	// Use regression test parameters with soft-fork activation heights
+	// that are quick to reach.  The version bits deployments activate
+	// after one window in each of the started and locked in states when
+	// all blocks signal for them.
+	params := chaincfg.RegressionNetParams
+	params.BIP0066Height = 100
+	params.BIP0065Height = 200
+	window := int32(params.MinerConfirmationWindow)
+	deploymentHeight := window * 3
+	bip16Height := int32(50)
+
+	const version = vbTopBits | 1<<0 | 1<<1
+	bip16Time := txscript.Bip16Activation.Unix()
+	chain := newFakeChain(&params)
+	nodes := make([]*blockNode, 0, deploymentHeight+1)
+	node := chain.bestChain.Tip()
+	nodes = append(nodes, node)
+	for height := int32(1); height <= deploymentHeight; height++ {
+		timestamp := time.Unix(bip16Time+int64(height-bip16Height), 0)
+		node = newFakeNode(node, version, 0, timestamp)
+		nodes = append(nodes, node)
+	}
+
+	tests := []struct {
+		height int32
+		flags  txscript.ScriptFlags
+	}{
+		{height: bip16Height - 1, flags: 0},
+		{height: bip16Height, flags: txscript.ScriptBip16},
+		{height: 99, flags: txscript.ScriptBip16},
+		{height: 100, flags: txscript.ScriptBip16 |
+			txscript.ScriptVerifyDERSignatures},
+		{height: 199, flags: txscript.ScriptBip16 |
+			txscript.ScriptVerifyDERSignatures},
+		{height: 200, flags: txscript.ScriptBip16 |
+			txscript.ScriptVerifyDERSignatures |
+			txscript.ScriptVerifyCheckLockTimeVerify},
+		{height: deploymentHeight - 1, flags: txscript.ScriptBip16 |
+			txscript.ScriptVerifyDERSignatures |
+			txscript.ScriptVerifyCheckLockTimeVerify},
+		{height: deploymentHeight, flags: txscript.ScriptBip16 |
+			txscript.ScriptVerifyDERSignatures |
+			txscript.ScriptVerifyCheckLockTimeVerify |
+			txscript.ScriptVerifyCheckSequenceVerify |
+			txscript.ScriptVerifyWitness |
+			txscript.ScriptStrictMultiSig},
+	}
+	for _, test := range tests {
+		flags, err := chain.scriptVerifyFlags(nodes[test.height])
+		if err != nil {
+			t.Fatalf("height %d: unexpected error: %v", test.height,
+				err)
+		}
+		if flags != test.flags {
+			t.Errorf("height %d: got flags %#x, want %#x",
+				test.height, flags, test.flags)
+		}
+	}
+}
+
 // TestCheckBlockSanity tests the CheckBlockSanity function to ensure it works
 // as expected.
 func TestCheckBlockSanity(t *testing.T) {
@@ -169,6 +234,102 @@ func TestCheckBlockSanity(t *testing.T) {
 	}
 }
 
+// TestCheckTxOutValues ensures transactions with an output above the max
+// amount of money or outputs that sum beyond it are rejected both on their own
+// and as part of a block.
+func TestCheckTxOutValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []int64
+		wantErr bool
+	}{
+		{"max money", []int64{btcutil.MaxSatoshi}, false},
+		{"sum of max money", []int64{btcutil.MaxSatoshi - 1, 1}, false},
+		{"negative output", []int64{-1}, true},
+		{"output above max money", []int64{btcutil.MaxSatoshi + 1}, true},
+		{"output overflows", []int64{math.MaxInt64}, true},
+		{"sum above max money", []int64{btcutil.MaxSatoshi, 1}, true},
+		{"sum overflows", []int64{btcutil.MaxSatoshi, btcutil.MaxSatoshi,
+			btcutil.MaxSatoshi}, true},
+	}
+	for _, test := range tests {
+		// Replace the outputs of a non-coinbase transaction in a copy of a
+		// valid block.  The transactions are checked before the merkle
+		// root, so it does not need to be updated.
+		var msgBlock wire.MsgBlock
+		var buf bytes.Buffer
+		if err := Block100000.Serialize(&buf); err != nil {
+			t.Fatalf("unable to serialize block: %v", err)
+		}
+		if err := msgBlock.Deserialize(&buf); err != nil {
+			t.Fatalf("unable to deserialize block: %v", err)
+		}
+		msgTx := msgBlock.Transactions[1]
+		msgTx.TxOut = nil
+		for _, value := range test.values {
+			msgTx.AddTxOut(wire.NewTxOut(value, []byte{0x51}))
+		}
+
+		checkErr := func(what string, err error) {
+			t.Helper()
+			if !test.wantErr {
+				if err != nil {
+					t.Fatalf("%s: %s: unexpected error: %v",
+						test.name, what, err)
+				}
+				return
+			}
+			rerr, ok := err.(RuleError)
+			if !ok || rerr.ErrorCode != ErrBadTxOutValue {
+				t.Fatalf("%s: %s: got error %v, want %v", test.name,
+					what, err, ErrBadTxOutValue)
+			}
+		}
+		checkErr("transaction", CheckTransactionSanity(btcutil.NewTx(msgTx)))
+		if test.wantErr {
+			err := CheckBlockSanity(btcutil.NewBlock(&msgBlock),
+				chaincfg.MainNetParams.PowLimit, NewMedianTime())
+			checkErr("block", err)
+		}
+	}
+}
+
+// TestCheckCoinbaseScriptLen ensures coinbase transactions with signature
+// scripts outside of the allowed range of lengths are rejected.
+func TestCheckCoinbaseScriptLen(t *testing.T) {
+	tests := []struct {
+		name      string
+		scriptLen int
+		wantErr   bool
+	}{
+		{"too short", MinCoinbaseScriptLen - 1, true},
+		{"minimum", MinCoinbaseScriptLen, false},
+		{"maximum", MaxCoinbaseScriptLen, false},
+		{"too long", MaxCoinbaseScriptLen + 1, true},
+	}
+	for _, test := range tests {
+		coinbaseOutpoint := wire.NewOutPoint(&chainhash.Hash{},
+			math.MaxUint32)
+		coinbaseTx := wire.NewMsgTx(1)
+		coinbaseTx.AddTxIn(wire.NewTxIn(coinbaseOutpoint,
+			make([]byte, test.scriptLen), nil))
+		coinbaseTx.AddTxOut(wire.NewTxOut(0, []byte{0x51}))
+
+		err := CheckTransactionSanity(btcutil.NewTx(coinbaseTx))
+		if !test.wantErr {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		rerr, ok := err.(RuleError)
+		if !ok || rerr.ErrorCode != ErrBadCoinbaseScriptLen {
+			t.Fatalf("%s: got error %v, want %v", test.name, err,
+				ErrBadCoinbaseScriptLen)
+		}
+	}
+}
+
 // TestCheckSerializedHeight tests the checkSerializedHeight function with
 // various serialized heights and also does negative tests to ensure errors
 // and handled properly.
@@ -0,0 +1,122 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcjson
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// amountDecimals is the number of decimal places of an amount of bitcoin that
+// can be represented in satoshi.
+const amountDecimals = 8
+
+// NewAmountFromString parses a decimal amount of bitcoin such as "12.34567891"
+// into an amount in satoshi.  Unlike btcutil.NewAmount, the amount is parsed
+// exactly instead of going through a float64, so no rounding takes place.
+//
+// An error is returned for amounts with more than 8 decimal places, negative
+// amounts, and amounts larger than the maximum number of satoshi that can
+// ever exist.
+func NewAmountFromString(s string) (btcutil.Amount, error) {
+	if strings.HasPrefix(s, "-") {
+		return 0, fmt.Errorf("amount %q is negative", s)
+	}
+
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+		if fraction == "" {
+			return 0, fmt.Errorf("amount %q has no decimal places "+
+				"after the decimal point", s)
+		}
+	}
+	if whole == "" {
+		return 0, fmt.Errorf("amount %q has no digits before the "+
+			"decimal point", s)
+	}
+	if len(fraction) > amountDecimals {
+		return 0, fmt.Errorf("amount %q has more than %d decimal "+
+			"places", s, amountDecimals)
+	}
+
+	// Parse the digits as a whole number of satoshi by padding the decimal
+	// places.  Ensure the amount does not exceed the maximum amount as soon
+	// as possible so the result can't overflow.
+	var satoshi int64
+	digits := whole + fraction + strings.Repeat("0",
+		amountDecimals-len(fraction))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("amount %q is not a decimal number",
+				s)
+		}
+		satoshi = satoshi*10 + int64(c-'0')
+		if satoshi > btcutil.MaxSatoshi {
+			return 0, fmt.Errorf("amount %q exceeds the maximum "+
+				"amount of %v", s, btcutil.Amount(btcutil.MaxSatoshi))
+		}
+	}
+	return btcutil.Amount(satoshi), nil
+}
+
+// Amount is an amount of bitcoin in satoshi that is marshalled to JSON as a
+// decimal number of bitcoin with 8 decimal places, as bitcoind does, rather
+// than as a number of satoshi.  It is unmarshalled from such a number exactly.
+type Amount btcutil.Amount
+
+// MarshalJSON marshals the amount as a decimal number of bitcoin with 8
+// decimal places.
+//
+// This is part of the json.Marshaler interface.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	sign, satoshi := "", int64(a)
+	if satoshi < 0 {
+		sign, satoshi = "-", -satoshi
+	}
+	return []byte(fmt.Sprintf("%s%d.%08d", sign,
+		satoshi/btcutil.SatoshiPerBitcoin,
+		satoshi%btcutil.SatoshiPerBitcoin)), nil
+}
+
+// UnmarshalJSON unmarshals a decimal number of bitcoin, which may also be
+// provided as a string, into the amount.  Numbers in exponent notation are
+// rounded to the nearest satoshi.
+//
+// This is part of the json.Unmarshaler interface.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	// Leave the amount unchanged for null like the json package does.
+	if string(data) == "null" {
+		return nil
+	}
+
+	s := strings.Trim(string(data), `"`)
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		amount, err := btcutil.NewAmount(f)
+		if err != nil {
+			return err
+		}
+		*a = Amount(amount)
+		return nil
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	amount, err := NewAmountFromString(strings.TrimPrefix(s, "-"))
+	if err != nil {
+		return err
+	}
+	if negative {
+		amount = -amount
+	}
+	*a = Amount(amount)
+	return nil
+}
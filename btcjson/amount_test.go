@@ -0,0 +1,146 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcjson_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TestNewAmountFromString ensures decimal amounts of bitcoin are parsed into
+// the exact amount of satoshi and invalid amounts are rejected.
+func TestNewAmountFromString(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		s       string
+		want    btcutil.Amount
+		wantErr bool
+	}{
+		{name: "zero", s: "0", want: 0},
+		{name: "whole", s: "12", want: 12 * btcutil.SatoshiPerBitcoin},
+		{name: "all decimals", s: "12.34567891", want: 1234567891},
+		{name: "some decimals", s: "0.1", want: 10000000},
+		{name: "one satoshi", s: "0.00000001", want: 1},
+		{name: "leading zeros", s: "007.5", want: 750000000},
+		{name: "max", s: "21000000", want: btcutil.MaxSatoshi},
+		{name: "too many decimals", s: "1.000000001", wantErr: true},
+		{name: "too many zero decimals", s: "1.000000000", wantErr: true},
+		{name: "negative", s: "-1", wantErr: true},
+		{name: "negative zero", s: "-0.0", wantErr: true},
+		{name: "above max", s: "21000000.00000001", wantErr: true},
+		{name: "overflow", s: "92233720368547758080", wantErr: true},
+		{name: "empty", s: "", wantErr: true},
+		{name: "no whole", s: ".5", wantErr: true},
+		{name: "no decimals", s: "5.", wantErr: true},
+		{name: "plus sign", s: "+1", wantErr: true},
+		{name: "exponent", s: "1e8", wantErr: true},
+		{name: "two points", s: "1.2.3", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := btcjson.NewAmountFromString(test.s)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got amount %v",
+					test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %d satoshi, want %d", test.name,
+				int64(got), int64(test.want))
+		}
+	}
+}
+
+// TestAmountJSON ensures amounts are marshalled as decimal numbers of bitcoin
+// with 8 decimal places and round-trip exactly, including as part of results.
+func TestAmountJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		amount btcutil.Amount
+		want   string
+	}{
+		{amount: 123456789, want: "1.23456789"},
+		{amount: 0, want: "0.00000000"},
+		{amount: 1, want: "0.00000001"},
+		{amount: -150000000, want: "-1.50000000"},
+		{amount: btcutil.MaxSatoshi, want: "21000000.00000000"},
+	}
+	for _, test := range tests {
+		marshalled, err := json.Marshal(btcjson.Amount(test.amount))
+		if err != nil {
+			t.Errorf("%v: unexpected marshal error: %v", test.amount,
+				err)
+			continue
+		}
+		if string(marshalled) != test.want {
+			t.Errorf("%v: got %s, want %s", test.amount, marshalled,
+				test.want)
+			continue
+		}
+		var got btcjson.Amount
+		if err := json.Unmarshal(marshalled, &got); err != nil {
+			t.Errorf("%v: unexpected unmarshal error: %v",
+				test.amount, err)
+			continue
+		}
+		if btcutil.Amount(got) != test.amount {
+			t.Errorf("%v: round-tripped to %v", test.amount,
+				btcutil.Amount(got))
+		}
+	}
+
+	// Ensure amounts are also unmarshalled from strings and from numbers
+	// in exponent notation.
+	for s, want := range map[string]btcutil.Amount{
+		`"1.23456789"`: 123456789,
+		`1e-08`:        1,
+	} {
+		var got btcjson.Amount
+		if err := json.Unmarshal([]byte(s), &got); err != nil {
+			t.Errorf("%s: unexpected unmarshal error: %v", s, err)
+			continue
+		}
+		if btcutil.Amount(got) != want {
+			t.Errorf("%s: got %v, want %v", s, btcutil.Amount(got),
+				want)
+		}
+	}
+
+	// Ensure results carrying amounts marshal them as decimal numbers of
+	// bitcoin and round-trip.
+	result := btcjson.FundRawTransactionResult{
+		Transaction:    wire.NewMsgTx(wire.TxVersion),
+		Fee:            123456789,
+		ChangePosition: -1,
+	}
+	marshalled, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("unexpected marshal error: %v", err)
+	}
+	want := `{"hex":"01000000000000000000","fee":1.23456789,"changepos":-1}`
+	if string(marshalled) != want {
+		t.Fatalf("got %s, want %s", marshalled, want)
+	}
+	var got btcjson.FundRawTransactionResult
+	if err := json.Unmarshal(marshalled, &got); err != nil {
+		t.Fatalf("unexpected unmarshal error: %v", err)
+	}
+	if got.Fee != result.Fee || got.ChangePosition != result.ChangePosition {
+		t.Fatalf("round-tripped to %+v, want %+v", got, result)
+	}
+}
@@ -184,6 +184,22 @@ func NewGetAddedNodeInfoCmd(dns bool, node *string) *GetAddedNodeInfoCmd {
 	}
 }
 
+// GetAddrManCmd defines the getaddrman JSON-RPC command.
+type GetAddrManCmd struct {
+	Count *int32 `jsonrpcdefault:"10"`
+}
+
+// NewGetAddrManCmd returns a new instance which can be used to issue a
+// getaddrman JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewGetAddrManCmd(count *int32) *GetAddrManCmd {
+	return &GetAddrManCmd{
+		Count: count,
+	}
+}
+
 // GetBestBlockHashCmd defines the getbestblockhash JSON-RPC command.
 type GetBestBlockHashCmd struct{}
 
@@ -619,6 +635,22 @@ func NewGetNodeAddressesCmd(count *int32) *GetNodeAddressesCmd {
 	}
 }
 
+// GetOrphanTxsCmd defines the getorphantxs JSON-RPC command.
+type GetOrphanTxsCmd struct {
+	Verbose *bool `jsonrpcdefault:"false"`
+}
+
+// NewGetOrphanTxsCmd returns a new instance which can be used to issue a
+// getorphantxs JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewGetOrphanTxsCmd(verbose *bool) *GetOrphanTxsCmd {
+	return &GetOrphanTxsCmd{
+		Verbose: verbose,
+	}
+}
+
 // GetPeerInfoCmd defines the getpeerinfo JSON-RPC command.
 type GetPeerInfoCmd struct{}
 
@@ -712,6 +744,19 @@ func NewGetTxOutSetInfoCmd() *GetTxOutSetInfoCmd {
 	return &GetTxOutSetInfoCmd{}
 }
 
+// GetTxSpendingPrevOutCmd defines the gettxspendingprevout JSON-RPC command.
+type GetTxSpendingPrevOutCmd struct {
+	Outputs []TransactionInput
+}
+
+// NewGetTxSpendingPrevOutCmd returns a new instance which can be used to issue
+// a gettxspendingprevout JSON-RPC command.
+func NewGetTxSpendingPrevOutCmd(outputs []TransactionInput) *GetTxSpendingPrevOutCmd {
+	return &GetTxSpendingPrevOutCmd{
+		Outputs: outputs,
+	}
+}
+
 // GetWorkCmd defines the getwork JSON-RPC command.
 type GetWorkCmd struct {
 	Data *string
@@ -792,6 +837,16 @@ func NewReconsiderBlockCmd(blockHash string) *ReconsiderBlockCmd {
 	}
 }
 
+// ResendWalletTransactionsCmd defines the resendwallettransactions JSON-RPC
+// command.
+type ResendWalletTransactionsCmd struct{}
+
+// NewResendWalletTransactionsCmd returns a new instance which can be used to
+// issue a resendwallettransactions JSON-RPC command.
+func NewResendWalletTransactionsCmd() *ResendWalletTransactionsCmd {
+	return &ResendWalletTransactionsCmd{}
+}
+
 // SearchRawTransactionsCmd defines the searchrawtransactions JSON-RPC command.
 type SearchRawTransactionsCmd struct {
 	Address     string
@@ -1053,6 +1108,7 @@ func init() {
 	MustRegisterCmd("deriveaddresses", (*DeriveAddressesCmd)(nil), flags)
 	MustRegisterCmd("fundrawtransaction", (*FundRawTransactionCmd)(nil), flags)
 	MustRegisterCmd("getaddednodeinfo", (*GetAddedNodeInfoCmd)(nil), flags)
+	MustRegisterCmd("getaddrman", (*GetAddrManCmd)(nil), flags)
 	MustRegisterCmd("getbestblockhash", (*GetBestBlockHashCmd)(nil), flags)
 	MustRegisterCmd("getblock", (*GetBlockCmd)(nil), flags)
 	MustRegisterCmd("getblockchaininfo", (*GetBlockChainInfoCmd)(nil), flags)
@@ -1079,18 +1135,21 @@ func init() {
 	MustRegisterCmd("getnettotals", (*GetNetTotalsCmd)(nil), flags)
 	MustRegisterCmd("getnetworkhashps", (*GetNetworkHashPSCmd)(nil), flags)
 	MustRegisterCmd("getnodeaddresses", (*GetNodeAddressesCmd)(nil), flags)
+	MustRegisterCmd("getorphantxs", (*GetOrphanTxsCmd)(nil), flags)
 	MustRegisterCmd("getpeerinfo", (*GetPeerInfoCmd)(nil), flags)
 	MustRegisterCmd("getrawmempool", (*GetRawMempoolCmd)(nil), flags)
 	MustRegisterCmd("getrawtransaction", (*GetRawTransactionCmd)(nil), flags)
 	MustRegisterCmd("gettxout", (*GetTxOutCmd)(nil), flags)
 	MustRegisterCmd("gettxoutproof", (*GetTxOutProofCmd)(nil), flags)
 	MustRegisterCmd("gettxoutsetinfo", (*GetTxOutSetInfoCmd)(nil), flags)
+	MustRegisterCmd("gettxspendingprevout", (*GetTxSpendingPrevOutCmd)(nil), flags)
 	MustRegisterCmd("getwork", (*GetWorkCmd)(nil), flags)
 	MustRegisterCmd("help", (*HelpCmd)(nil), flags)
 	MustRegisterCmd("invalidateblock", (*InvalidateBlockCmd)(nil), flags)
 	MustRegisterCmd("ping", (*PingCmd)(nil), flags)
 	MustRegisterCmd("preciousblock", (*PreciousBlockCmd)(nil), flags)
 	MustRegisterCmd("reconsiderblock", (*ReconsiderBlockCmd)(nil), flags)
+	MustRegisterCmd("resendwallettransactions", (*ResendWalletTransactionsCmd)(nil), flags)
 	MustRegisterCmd("searchrawtransactions", (*SearchRawTransactionsCmd)(nil), flags)
 	MustRegisterCmd("sendrawtransaction", (*SendRawTransactionCmd)(nil), flags)
 	MustRegisterCmd("setgenerate", (*SetGenerateCmd)(nil), flags)
@@ -290,6 +290,32 @@ func TestChainSvrCmds(t *testing.T) {
 				Node: btcjson.String("127.0.0.1"),
 			},
 		},
+		{
+			name: "getaddrman",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddrman")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddrManCmd(nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddrman","params":[],"id":1}`,
+			unmarshalled: &btcjson.GetAddrManCmd{
+				Count: btcjson.Int32(10),
+			},
+		},
+		{
+			name: "getaddrman optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddrman", 50)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddrManCmd(btcjson.Int32(50))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddrman","params":[50],"id":1}`,
+			unmarshalled: &btcjson.GetAddrManCmd{
+				Count: btcjson.Int32(50),
+			},
+		},
 		{
 			name: "getbestblockhash",
 			newCmd: func() (interface{}, error) {
@@ -824,6 +850,32 @@ func TestChainSvrCmds(t *testing.T) {
 				Count: btcjson.Int32(10),
 			},
 		},
+		{
+			name: "getorphantxs",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getorphantxs")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetOrphanTxsCmd(nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getorphantxs","params":[],"id":1}`,
+			unmarshalled: &btcjson.GetOrphanTxsCmd{
+				Verbose: btcjson.Bool(false),
+			},
+		},
+		{
+			name: "getorphantxs optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getorphantxs", true)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetOrphanTxsCmd(btcjson.Bool(true))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getorphantxs","params":[true],"id":1}`,
+			unmarshalled: &btcjson.GetOrphanTxsCmd{
+				Verbose: btcjson.Bool(true),
+			},
+		},
 		{
 			name: "getpeerinfo",
 			newCmd: func() (interface{}, error) {
@@ -960,6 +1012,27 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"gettxoutsetinfo","params":[],"id":1}`,
 			unmarshalled: &btcjson.GetTxOutSetInfoCmd{},
 		},
+		{
+			name: "gettxspendingprevout",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("gettxspendingprevout",
+					`[{"txid":"123","vout":1},{"txid":"456","vout":0}]`)
+			},
+			staticCmd: func() interface{} {
+				outputs := []btcjson.TransactionInput{
+					{Txid: "123", Vout: 1},
+					{Txid: "456", Vout: 0},
+				}
+				return btcjson.NewGetTxSpendingPrevOutCmd(outputs)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"gettxspendingprevout","params":[[{"txid":"123","vout":1},{"txid":"456","vout":0}]],"id":1}`,
+			unmarshalled: &btcjson.GetTxSpendingPrevOutCmd{
+				Outputs: []btcjson.TransactionInput{
+					{Txid: "123", Vout: 1},
+					{Txid: "456", Vout: 0},
+				},
+			},
+		},
 		{
 			name: "getwork",
 			newCmd: func() (interface{}, error) {
@@ -1062,6 +1135,17 @@ func TestChainSvrCmds(t *testing.T) {
 				BlockHash: "123",
 			},
 		},
+		{
+			name: "resendwallettransactions",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("resendwallettransactions")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewResendWalletTransactionsCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"resendwallettransactions","params":[],"id":1}`,
+			unmarshalled: &btcjson.ResendWalletTransactionsCmd{},
+		},
 		{
 			name: "searchrawtransactions",
 			newCmd: func() (interface{}, error) {
@@ -87,6 +87,7 @@ type GetBlockVerboseResult struct {
 	Nonce         uint32        `json:"nonce"`
 	Bits          string        `json:"bits"`
 	Difficulty    float64       `json:"difficulty"`
+	NTx           int32         `json:"nTx"`
 	PreviousHash  string        `json:"previousblockhash"`
 	NextHash      string        `json:"nextblockhash,omitempty"`
 }
@@ -113,6 +114,7 @@ type GetBlockVerboseTxResult struct {
 	Nonce         uint32        `json:"nonce"`
 	Bits          string        `json:"bits"`
 	Difficulty    float64       `json:"difficulty"`
+	NTx           int32         `json:"nTx"`
 	PreviousHash  string        `json:"previousblockhash"`
 	NextHash      string        `json:"nextblockhash,omitempty"`
 }
@@ -159,6 +161,33 @@ type GetAddedNodeInfoResult struct {
 	Addresses *[]GetAddedNodeInfoResultAddr `json:"addresses,omitempty"`
 }
 
+// GetAddrManAddressResult models the data of a known address returned by the
+// getaddrman command.
+type GetAddrManAddressResult struct {
+	Address     string `json:"address"`
+	Port        uint16 `json:"port"`
+	Services    uint64 `json:"services"`
+	Source      string `json:"source"`
+	Time        int64  `json:"time"`
+	Tried       bool   `json:"tried"`
+	Bad         bool   `json:"bad"`
+	Attempts    int32  `json:"attempts"`
+	LastAttempt int64  `json:"lastattempt"`
+	LastSuccess int64  `json:"lastsuccess"`
+}
+
+// GetAddrManResult models the data returned from the getaddrman command.
+type GetAddrManResult struct {
+	New               int32                     `json:"new"`
+	Tried             int32                     `json:"tried"`
+	Total             int32                     `json:"total"`
+	NewBucketsUsed    int32                     `json:"newbucketsused"`
+	NewBucketsTotal   int32                     `json:"newbucketstotal"`
+	TriedBucketsUsed  int32                     `json:"triedbucketsused"`
+	TriedBucketsTotal int32                     `json:"triedbucketstotal"`
+	Addresses         []GetAddrManAddressResult `json:"addresses"`
+}
+
 // SoftForkDescription describes the current state of a soft-fork which was
 // deployed using a super-majority block signalling.
 type SoftForkDescription struct {
@@ -172,12 +201,24 @@ type SoftForkDescription struct {
 // Bip9SoftForkDescription describes the current state of a defined BIP0009
 // version bits soft-fork.
 type Bip9SoftForkDescription struct {
-	Status     string `json:"status"`
-	Bit        uint8  `json:"bit"`
-	StartTime1 int64  `json:"startTime"`
-	StartTime2 int64  `json:"start_time"`
-	Timeout    int64  `json:"timeout"`
-	Since      int32  `json:"since"`
+	Status     string          `json:"status"`
+	Bit        uint8           `json:"bit"`
+	StartTime1 int64           `json:"startTime"`
+	StartTime2 int64           `json:"start_time"`
+	Timeout    int64           `json:"timeout"`
+	Since      int32           `json:"since"`
+	Statistics *Bip9Statistics `json:"statistics,omitempty"`
+}
+
+// Bip9Statistics describes the signaling for a BIP0009 version bits soft-fork
+// within the current confirmation window.  It is only provided while the
+// soft-fork is in the started state.
+type Bip9Statistics struct {
+	Period    uint32 `json:"period"`
+	Threshold uint32 `json:"threshold"`
+	Elapsed   uint32 `json:"elapsed"`
+	Count     uint32 `json:"count"`
+	Possible  bool   `json:"possible"`
 }
 
 // StartTime returns the starting time of the softfork as a Unix epoch.
@@ -215,22 +256,32 @@ type UnifiedSoftForks struct {
 // GetBlockChainInfoResult models the data returned from the getblockchaininfo
 // command.
 type GetBlockChainInfoResult struct {
-	Chain                string  `json:"chain"`
-	Blocks               int32   `json:"blocks"`
-	Headers              int32   `json:"headers"`
-	BestBlockHash        string  `json:"bestblockhash"`
-	Difficulty           float64 `json:"difficulty"`
-	MedianTime           int64   `json:"mediantime"`
-	VerificationProgress float64 `json:"verificationprogress,omitempty"`
-	InitialBlockDownload bool    `json:"initialblockdownload,omitempty"`
-	Pruned               bool    `json:"pruned"`
-	PruneHeight          int32   `json:"pruneheight,omitempty"`
-	ChainWork            string  `json:"chainwork,omitempty"`
-	SizeOnDisk           int64   `json:"size_on_disk,omitempty"`
+	Chain                string            `json:"chain"`
+	Blocks               int32             `json:"blocks"`
+	Headers              int32             `json:"headers"`
+	BestBlockHash        string            `json:"bestblockhash"`
+	Difficulty           float64           `json:"difficulty"`
+	MedianTime           int64             `json:"mediantime"`
+	VerificationProgress float64           `json:"verificationprogress,omitempty"`
+	InitialBlockDownload bool              `json:"initialblockdownload,omitempty"`
+	Pruned               bool              `json:"pruned"`
+	PruneHeight          int32             `json:"pruneheight,omitempty"`
+	ChainWork            string            `json:"chainwork,omitempty"`
+	SizeOnDisk           int64             `json:"size_on_disk,omitempty"`
+	ReorgStats           *ReorgStatsResult `json:"reorgstats,omitempty"`
 	*SoftForks
 	*UnifiedSoftForks
 }
 
+// ReorgStatsResult models the reorganization statistics returned as part of
+// the getblockchaininfo command.
+type ReorgStatsResult struct {
+	Count     uint64 `json:"count"`
+	MaxDepth  int32  `json:"maxdepth"`
+	LastDepth int32  `json:"lastdepth"`
+	LastTime  int64  `json:"lasttime"`
+}
+
 // GetBlockFilterResult models the data returned from the getblockfilter
 // command.
 type GetBlockFilterResult struct {
@@ -278,6 +329,9 @@ type GetBlockTemplateResult struct {
 	// Witness commitment defined in BIP 0141.
 	DefaultWitnessCommitment string `json:"default_witness_commitment,omitempty"`
 
+	// Rules from BIP 0009.
+	Rules []string `json:"rules"`
+
 	// Optional long polling from BIP 0022.
 	LongPollID  string `json:"longpollid,omitempty"`
 	LongPollURI string `json:"longpolluri,omitempty"`
@@ -332,8 +386,9 @@ type GetMempoolEntryResult struct {
 // GetMempoolInfoResult models the data returned from the getmempoolinfo
 // command.
 type GetMempoolInfoResult struct {
-	Size  int64 `json:"size"`
-	Bytes int64 `json:"bytes"`
+	Size             int64 `json:"size"`
+	Bytes            int64 `json:"bytes"`
+	UnbroadcastCount int64 `json:"unbroadcastcount"`
 }
 
 // NetworksResult models the networks data from the getnetworkinfo command.
@@ -406,6 +461,21 @@ type GetPeerInfoResult struct {
 	SyncNode       bool    `json:"syncnode"`
 }
 
+// GetOrphanTxsResult models the data returned from the getorphantxs command
+// for each orphan transaction when the verbose flag is set.  When the verbose
+// flag is not set, getorphantxs returns an array of transaction hashes.
+type GetOrphanTxsResult struct {
+	TxID           string   `json:"txid"`
+	Hash           string   `json:"hash"`
+	Size           int32    `json:"size"`
+	Vsize          int32    `json:"vsize"`
+	Weight         int32    `json:"weight"`
+	Time           int64    `json:"time"`
+	Expiration     int64    `json:"expiration"`
+	From           uint64   `json:"from"`
+	MissingParents []string `json:"missingparents"`
+}
+
 // GetRawMempoolVerboseResult models the data returned from the getrawmempool
 // command when the verbose flag is set.  When the verbose flag is not set,
 // getrawmempool returns an array of transaction hashes.
@@ -452,6 +522,23 @@ type GetTxOutSetInfoResult struct {
 	TotalAmount    btcutil.Amount `json:"total_amount"`
 }
 
+// MarshalJSON marshals the result of the gettxoutsetinfo JSON-RPC call with the
+// hashes as strings and the total amount as a decimal number of bitcoin.
+func (g GetTxOutSetInfoResult) MarshalJSON() ([]byte, error) {
+	type Alias GetTxOutSetInfoResult
+	return json.Marshal(&struct {
+		BestBlock      string `json:"bestblock"`
+		HashSerialized string `json:"hash_serialized_2"`
+		TotalAmount    Amount `json:"total_amount"`
+		Alias
+	}{
+		BestBlock:      g.BestBlock.String(),
+		HashSerialized: g.HashSerialized.String(),
+		TotalAmount:    Amount(g.TotalAmount),
+		Alias:          Alias(g),
+	})
+}
+
 // UnmarshalJSON unmarshals the result of the gettxoutsetinfo JSON-RPC call
 func (g *GetTxOutSetInfoResult) UnmarshalJSON(data []byte) error {
 	// Step 1: Create type aliases of the original struct.
@@ -460,9 +547,9 @@ func (g *GetTxOutSetInfoResult) UnmarshalJSON(data []byte) error {
 	// Step 2: Create an anonymous struct with raw replacements for the special
 	// fields.
 	aux := &struct {
-		BestBlock      string  `json:"bestblock"`
-		HashSerialized string  `json:"hash_serialized_2"`
-		TotalAmount    float64 `json:"total_amount"`
+		BestBlock      string `json:"bestblock"`
+		HashSerialized string `json:"hash_serialized_2"`
+		TotalAmount    Amount `json:"total_amount"`
 		*Alias
 	}{
 		Alias: (*Alias)(g),
@@ -488,16 +575,20 @@ func (g *GetTxOutSetInfoResult) UnmarshalJSON(data []byte) error {
 
 	g.HashSerialized = *serializedHash
 
-	amount, err := btcutil.NewAmount(aux.TotalAmount)
-	if err != nil {
-		return err
-	}
-
-	g.TotalAmount = amount
+	g.TotalAmount = btcutil.Amount(aux.TotalAmount)
 
 	return nil
 }
 
+// GetTxSpendingPrevOutResult models the data returned from the
+// gettxspendingprevout command for each queried outpoint.  SpendingTxID is only
+// set when the outpoint is spent by a transaction in the memory pool.
+type GetTxSpendingPrevOutResult struct {
+	TxID         string `json:"txid"`
+	Vout         uint32 `json:"vout"`
+	SpendingTxID string `json:"spendingtxid,omitempty"`
+}
+
 // GetNetTotalsResult models the data returned from the getnettotals command.
 type GetNetTotalsResult struct {
 	TotalBytesRecv uint64 `json:"totalbytesrecv"`
@@ -517,12 +608,13 @@ type ScriptSig struct {
 // getrawtransaction, decoderawtransaction, and searchrawtransaction use the
 // same structure.
 type Vin struct {
-	Coinbase  string     `json:"coinbase"`
-	Txid      string     `json:"txid"`
-	Vout      uint32     `json:"vout"`
-	ScriptSig *ScriptSig `json:"scriptSig"`
-	Sequence  uint32     `json:"sequence"`
-	Witness   []string   `json:"txinwitness"`
+	Coinbase  string         `json:"coinbase"`
+	Txid      string         `json:"txid"`
+	Vout      uint32         `json:"vout"`
+	ScriptSig *ScriptSig     `json:"scriptSig"`
+	Sequence  uint32         `json:"sequence"`
+	Witness   []string       `json:"txinwitness"`
+	PrevOut   *PrevOutResult `json:"prevout,omitempty"`
 }
 
 // IsCoinBase returns a bool to show if a Vin is a Coinbase one or not.
@@ -553,35 +645,48 @@ func (v *Vin) MarshalJSON() ([]byte, error) {
 
 	if v.HasWitness() {
 		txStruct := struct {
-			Txid      string     `json:"txid"`
-			Vout      uint32     `json:"vout"`
-			ScriptSig *ScriptSig `json:"scriptSig"`
-			Witness   []string   `json:"txinwitness"`
-			Sequence  uint32     `json:"sequence"`
+			Txid      string         `json:"txid"`
+			Vout      uint32         `json:"vout"`
+			ScriptSig *ScriptSig     `json:"scriptSig"`
+			Witness   []string       `json:"txinwitness"`
+			PrevOut   *PrevOutResult `json:"prevout,omitempty"`
+			Sequence  uint32         `json:"sequence"`
 		}{
 			Txid:      v.Txid,
 			Vout:      v.Vout,
 			ScriptSig: v.ScriptSig,
 			Witness:   v.Witness,
+			PrevOut:   v.PrevOut,
 			Sequence:  v.Sequence,
 		}
 		return json.Marshal(txStruct)
 	}
 
 	txStruct := struct {
-		Txid      string     `json:"txid"`
-		Vout      uint32     `json:"vout"`
-		ScriptSig *ScriptSig `json:"scriptSig"`
-		Sequence  uint32     `json:"sequence"`
+		Txid      string         `json:"txid"`
+		Vout      uint32         `json:"vout"`
+		ScriptSig *ScriptSig     `json:"scriptSig"`
+		PrevOut   *PrevOutResult `json:"prevout,omitempty"`
+		Sequence  uint32         `json:"sequence"`
 	}{
 		Txid:      v.Txid,
 		Vout:      v.Vout,
 		ScriptSig: v.ScriptSig,
+		PrevOut:   v.PrevOut,
 		Sequence:  v.Sequence,
 	}
 	return json.Marshal(txStruct)
 }
 
+// PrevOutResult models the details of the output spent by a transaction input
+// as returned by getblock when the verbosity level is 3.
+type PrevOutResult struct {
+	Generated    bool               `json:"generated"`
+	Height       int64              `json:"height"`
+	Value        float64            `json:"value"`
+	ScriptPubKey ScriptPubKeyResult `json:"scriptPubKey"`
+}
+
 // PrevOut represents previous output for an input Vin.
 type PrevOut struct {
 	Addresses []string `json:"addresses,omitempty"`
@@ -773,12 +878,13 @@ type EstimateSmartFeeResult struct {
 	Blocks  int64    `json:"blocks"`
 }
 
+var _ json.Marshaler = &FundRawTransactionResult{}
 var _ json.Unmarshaler = &FundRawTransactionResult{}
 
 type rawFundRawTransactionResult struct {
-	Transaction    string  `json:"hex"`
-	Fee            float64 `json:"fee"`
-	ChangePosition int     `json:"changepos"`
+	Transaction    string `json:"hex"`
+	Fee            Amount `json:"fee"`
+	ChangePosition int    `json:"changepos"`
 }
 
 // FundRawTransactionResult is the result of the fundrawtransaction JSON-RPC call
@@ -788,6 +894,22 @@ type FundRawTransactionResult struct {
 	ChangePosition int // the position of the added change output, or -1
 }
 
+// MarshalJSON marshals the result of the fundrawtransaction JSON-RPC call with
+// the fee as a decimal number of bitcoin.
+func (f FundRawTransactionResult) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if f.Transaction != nil {
+		if err := f.Transaction.Serialize(&buf); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(&rawFundRawTransactionResult{
+		Transaction:    hex.EncodeToString(buf.Bytes()),
+		Fee:            Amount(f.Fee),
+		ChangePosition: f.ChangePosition,
+	})
+}
+
 // UnmarshalJSON unmarshals the result of the fundrawtransaction JSON-RPC call
 func (f *FundRawTransactionResult) UnmarshalJSON(data []byte) error {
 	var rawRes rawFundRawTransactionResult
@@ -809,13 +931,8 @@ func (f *FundRawTransactionResult) UnmarshalJSON(data []byte) error {
 		}
 	}
 
-	fee, err := btcutil.NewAmount(rawRes.Fee)
-	if err != nil {
-		return err
-	}
-
 	f.Transaction = &msgTx
-	f.Fee = fee
+	f.Fee = btcutil.Amount(rawRes.Fee)
 	f.ChangePosition = rawRes.ChangePosition
 	return nil
 }
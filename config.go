@@ -21,6 +21,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/btcsuite/btcd/addrmgr"
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -28,7 +29,10 @@ import (
 	"github.com/btcsuite/btcd/database"
 	_ "github.com/btcsuite/btcd/database/ffldb"
 	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/netsync"
 	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/go-socks/socks"
 	flags "github.com/jessevdk/go-flags"
@@ -41,13 +45,23 @@ const (
 	defaultLogDirname            = "logs"
 	defaultLogFilename           = "btcd.log"
 	defaultMaxPeers              = 125
+	defaultMaxInbound            = defaultMaxPeers - defaultTargetOutbound
 	defaultBanDuration           = time.Hour * 24
 	defaultBanThreshold          = 100
+	defaultInboundRateLimit      = 30
 	defaultConnectTimeout        = time.Second * 30
+	defaultHandshakeTimeout      = peer.DefaultHandshakeTimeout
+	defaultPeerIdleTimeout       = peer.DefaultIdleTimeout
+	defaultMaxClockSkew          = time.Minute * 5
 	defaultMaxRPCClients         = 10
 	defaultMaxRPCWebsockets      = 25
 	defaultMaxRPCConcurrentReqs  = 20
+	defaultRPCWorkers            = 10
+	defaultRPCWorkQueue          = 16
+	defaultRPCReorgChunkSize     = 10
 	defaultDbType                = "ffldb"
+	defaultPeersDBFormat         = "json"
+	defaultMiningAddrRotation    = "random"
 	defaultFreeTxRelayLimit      = 15.0
 	defaultTrickleInterval       = peer.DefaultTrickleInterval
 	defaultBlockMinSize          = 0
@@ -58,10 +72,17 @@ const (
 	blockMaxSizeMax              = blockchain.MaxBlockBaseSize - 1000
 	blockMaxWeightMin            = 4000
 	blockMaxWeightMax            = blockchain.MaxBlockWeight - 4000
+	maxSignalBit                 = 29
 	defaultGenerate              = false
 	defaultMaxOrphanTransactions = 100
 	defaultMaxOrphanTxSize       = 100000
+	defaultMaxOrphansPerCycle    = 50
+	defaultRebroadcastInterval   = time.Minute * 30
+	defaultBlockDownloadWindow   = netsync.DefaultBlockDownloadWindow
+	defaultMaxBlocksInFlight     = netsync.DefaultMaxBlocksInFlight
+	defaultGetDataBatchSize      = netsync.DefaultGetDataBatchSize
 	defaultSigCacheMaxSize       = 100000
+	defaultScriptCacheMaxSize    = 10000
 	sampleConfigFilename         = "sample-btcd.conf"
 	defaultTxIndex               = false
 	defaultAddrIndex             = false
@@ -77,6 +98,16 @@ var (
 	defaultLogDir      = filepath.Join(defaultHomeDir, defaultLogDirname)
 )
 
+// serviceNames maps the service names accepted by the advertiseservice option
+// to their respective service flags.
+var serviceNames = map[string]wire.ServiceFlag{
+	"network":        wire.SFNodeNetwork,
+	"networklimited": wire.SFNodeNetworkLimited,
+	"bloom":          wire.SFNodeBloom,
+	"witness":        wire.SFNodeWitness,
+	"cf":             wire.SFNodeCF,
+}
+
 // runServiceCommand is only set to a real function on Windows.  It is used
 // to parse and execute service commands specified via the -s flag.
 var runServiceCommand func(string) error
@@ -97,10 +128,12 @@ type config struct {
 	AddCheckpoints       []string      `long:"addcheckpoint" description:"Add a custom checkpoint.  Format: '<height>:<hash>'"`
 	AddPeers             []string      `short:"a" long:"addpeer" description:"Add a peer to connect with at startup"`
 	AddrIndex            bool          `long:"addrindex" description:"Maintain a full address-based transaction index which makes the searchrawtransactions RPC available"`
+	AdvertiseServices    []string      `long:"advertiseservice" description:"Add a service to advertise to peers {network, networklimited, bloom, witness, cf} -- May be specified multiple times (default: all but networklimited)"`
 	AgentBlacklist       []string      `long:"agentblacklist" description:"A comma separated list of user-agent substrings which will cause btcd to reject any peers whose user-agent contains any of the blacklisted substrings."`
 	AgentWhitelist       []string      `long:"agentwhitelist" description:"A comma separated list of user-agent substrings which will cause btcd to require all peers' user-agents to contain one of the whitelisted substrings. The blacklist is applied before the blacklist, and an empty whitelist will allow all agents that do not fail the blacklist."`
 	BanDuration          time.Duration `long:"banduration" description:"How long to ban misbehaving peers.  Valid time units are {s, m, h}.  Minimum 1 second"`
 	BanThreshold         uint32        `long:"banthreshold" description:"Maximum allowed ban score before disconnecting and banning misbehaving peers."`
+	BlockDownloadWindow  int           `long:"blockdownloadwindow" description:"Max number of blocks past the best chain tip to request during the initial block download"`
 	BlockMaxSize         uint32        `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
 	BlockMinSize         uint32        `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
 	BlockMaxWeight       uint32        `long:"blockmaxweight" description:"Maximum block weight to be used when creating a block"`
@@ -113,17 +146,35 @@ type config struct {
 	DataDir              string        `short:"b" long:"datadir" description:"Directory to store data"`
 	DbType               string        `long:"dbtype" description:"Database backend to use for the Block Chain"`
 	DebugLevel           string        `short:"d" long:"debuglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
+	DialTimeout          time.Duration `long:"dialtimeout" description:"Maximum amount of time to wait for an outbound connection to be established.  Valid time units are {s, m, h}.  Minimum 1 second"`
 	DropAddrIndex        bool          `long:"dropaddrindex" description:"Deletes the address-based transaction index from the database on start up and then exits."`
 	DropCfIndex          bool          `long:"dropcfindex" description:"Deletes the index used for committed filtering (CF) support from the database on start up and then exits."`
 	DropTxIndex          bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	ExternalIPs          []string      `long:"externalip" description:"Add an ip to the list of local addresses we claim to listen on to peers"`
 	Generate             bool          `long:"generate" description:"Generate (mine) bitcoins using the CPU"`
+	GetDataBatchSize     int           `long:"getdatabatchsize" description:"Max number of inventory items requested by a single getdata message -- Larger requests are split into multiple messages -- Must be between 1 and 50000"`
+	HandshakeTimeout     time.Duration `long:"handshaketimeout" description:"Maximum amount of time a peer is given to complete the version handshake before it is disconnected.  Valid time units are {s, m, h}.  Minimum 1 second"`
+	InboundRateLimit     uint32        `long:"inboundratelimit" description:"Max number of inbound connections per minute accepted from a single subnet (/16 for IPv4, /32 for IPv6), except for whitelisted and loopback addresses -- 0 to disable"`
+	LimitAncestorCount   int           `long:"limitancestorcount" description:"Do not accept transactions into the mempool if they and their unconfirmed ancestors would consist of more than this number of transactions -- 0 to disable"`
+	LimitAncestorSize    int           `long:"limitancestorsize" description:"Do not accept transactions into the mempool if they and their unconfirmed ancestors would exceed this combined size in kilobytes -- 0 to disable"`
+	LimitDescendantCount int           `long:"limitdescendantcount" description:"Do not accept transactions into the mempool if any of their unconfirmed ancestors and its descendants would consist of more than this number of transactions -- 0 to disable"`
+	LimitDescendantSize  int           `long:"limitdescendantsize" description:"Do not accept transactions into the mempool if any of their unconfirmed ancestors and its descendants would exceed this combined size in kilobytes -- 0 to disable"`
 	FreeTxRelayLimit     float64       `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
 	Listeners            []string      `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 8333, testnet: 18333)"`
 	LogDir               string        `long:"logdir" description:"Directory to log output."`
+	MaxBlocksInFlight    int           `long:"maxblocksinflight" description:"Max number of blocks requested from a single peer that may be outstanding at once during the initial block download"`
+	MaxClockSkew         time.Duration `long:"maxclockskew" description:"Report a warning via the getinfo and getnetworkinfo RPCs when the median time of peers differs from the local clock by more than this amount.  Valid time units are {s, m, h}.  0 to disable"`
+	MaxInbound           int           `long:"maxinbound" description:"Max number of inbound peers -- Limited to maxpeers minus targetoutbound so inbound peers never prevent the outbound target from being maintained"`
+	MaxOrphanBlockBytes  int           `long:"maxorphanblockbytes" description:"Max combined size in bytes of orphan blocks to keep in memory -- The oldest orphan blocks are evicted when it is exceeded"`
 	MaxOrphanTxs         int           `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
+	MaxOrphansPerCycle   int           `long:"maxorphanspercycle" description:"Max number of orphan transactions to reconsider for acceptance each time a transaction is accepted or a block is connected -- 0 to disable"`
 	MaxPeers             int           `long:"maxpeers" description:"Max number of inbound and outbound peers"`
+	MaxScriptSigOps      int           `long:"maxscriptsigops" description:"Max number of signature operations a standard transaction input may execute -- 0 to disable"`
+	MaxScriptStackDepth  int           `long:"maxscriptstackdepth" description:"Max combined stack depth a standard transaction input may reach during script execution -- 0 to disable"`
+	MaxTxFee             float64       `long:"maxtxfee" description:"The maximum total fee in BTC a transaction submitted via RPC may pay unless high fees are allowed or a max fee rate is given for it -- 0 to disable"`
+	MinInboundVersion    uint32        `long:"mininboundversion" description:"Minimum protocol version inbound peers must advertise to be accepted -- 0 to accept all supported versions"`
 	MiningAddrs          []string      `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
+	MiningAddrRotation   string        `long:"miningaddrrotation" description:"How the payment address of each generated block is chosen from the mining addresses {random, roundrobin, height} -- roundrobin moves on to the next one each time a block is connected and height chooses them by the height of the block"`
 	MinRelayTxFee        float64       `long:"minrelaytxfee" description:"The minimum transaction fee in BTC/kB to be considered a non-zero fee."`
 	DisableBanning       bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
 	NoCFilters           bool          `long:"nocfilters" description:"Disable committed filtering (CF) support"`
@@ -132,6 +183,7 @@ type config struct {
 	DisableListen        bool          `long:"nolisten" description:"Disable listening for incoming connections -- NOTE: Listening is automatically disabled if the --connect or --proxy options are used without also specifying listen interfaces via --listen"`
 	NoOnion              bool          `long:"noonion" description:"Disable connecting to tor hidden services"`
 	NoPeerBloomFilters   bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
+	NoRelayPeers         []string      `long:"norelaypeer" description:"Add an IP network or IP of peers that only relay blocks.  Transactions are neither requested from nor announced to them. (eg. 192.168.1.0/24 or ::1)"`
 	NoRelayPriority      bool          `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
 	NoWinService         bool          `long:"nowinservice" description:"Do not start as a background service on Windows -- NOTE: This flag only works on the command line, not in the config file"`
 	DisableRPC           bool          `long:"norpc" description:"Disable built-in RPC server -- NOTE: The RPC server is disabled by default if no rpcuser/rpcpass or rpclimituser/rpclimitpass is specified"`
@@ -139,10 +191,13 @@ type config struct {
 	OnionProxy           string        `long:"onion" description:"Connect to tor hidden services via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
 	OnionProxyPass       string        `long:"onionpass" default-mask:"-" description:"Password for onion proxy server"`
 	OnionProxyUser       string        `long:"onionuser" description:"Username for onion proxy server"`
+	PeerIdleTimeout      time.Duration `long:"peeridletimeout" description:"Duration of inactivity after which a peer is disconnected.  Valid time units are {s, m, h}.  Minimum 1 second"`
+	PeersDBFormat        string        `long:"peersdbformat" description:"Format to persist known peer addresses in -- binary (peers.dat) or json (peers.json).  Switching formats migrates the addresses saved in the other format on startup"`
 	Profile              string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	Proxy                string        `long:"proxy" description:"Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
 	ProxyPass            string        `long:"proxypass" default-mask:"-" description:"Password for proxy server"`
 	ProxyUser            string        `long:"proxyuser" description:"Username for proxy server"`
+	RebroadcastInterval  time.Duration `long:"rebroadcastinterval" description:"Average amount of time between rebroadcasts of locally submitted transactions that have not been requested by any peers yet.  The actual time is randomized to be within half the interval of it.  Valid time units are {s, m, h}.  Minimum 1 minute"`
 	RegressionTest       bool          `long:"regtest" description:"Use the regression test network"`
 	RejectNonStd         bool          `long:"rejectnonstd" description:"Reject non-standard transactions regardless of the default settings for the active network."`
 	RejectReplacement    bool          `long:"rejectreplacement" description:"Reject transactions that attempt to replace existing transactions within the mempool through the Replace-By-Fee (RBF) signaling policy."`
@@ -156,17 +211,26 @@ type config struct {
 	RPCMaxConcurrentReqs int           `long:"rpcmaxconcurrentreqs" description:"Max number of concurrent RPC requests that may be processed concurrently"`
 	RPCMaxWebsockets     int           `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
 	RPCQuirks            bool          `long:"rpcquirks" description:"Mirror some JSON-RPC quirks of Bitcoin Core -- NOTE: Discouraged unless interoperability issues need to be worked around"`
+	RPCReorgChunkSize    int           `long:"rpcreorgchunksize" description:"Max number of block disconnected and connected notifications of a chain reorganization queued for websocket clients at once"`
+	RPCRequestTimeout    time.Duration `long:"rpcrequesttimeout" description:"Maximum amount of time a standard RPC request may wait for and be processed by a worker before an error is returned -- 0 disables the timeout"`
+	RPCVerifyBlocks      bool          `long:"rpcverifyblocks" description:"Verify that the transactions of blocks loaded from the database hash to the merkle root of their header before serving them via RPC"`
+	RPCWorkers           int           `long:"rpcworkers" description:"Number of workers used to process standard RPC requests concurrently"`
+	RPCWorkQueue         int           `long:"rpcworkqueue" description:"Max number of standard RPC requests that may wait for a worker before the server responds as busy"`
 	RPCPass              string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
 	RPCUser              string        `short:"u" long:"rpcuser" description:"Username for RPC connections"`
+	ScriptCacheMaxSize   uint          `long:"scriptcachemaxsize" description:"The maximum number of entries in the cache of classified public key scripts used by the address index and RPC server -- 0 disables the cache"`
+	SignalBits           []uint32      `long:"signalbit" description:"Signal the given version bit (0-28) in the version of generated blocks in addition to the bits of the rule change deployments being voted on -- may be specified multiple times"`
 	SigCacheMaxSize      uint          `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
 	SimNet               bool          `long:"simnet" description:"Use the simulation test network"`
 	SigNet               bool          `long:"signet" description:"Use the signet test network"`
 	SigNetChallenge      string        `long:"signetchallenge" description:"Connect to a custom signet network defined by this challenge instead of using the global default signet test network -- Can be specified multiple times"`
 	SigNetSeedNode       []string      `long:"signetseednode" description:"Specify a seed node for the signet network instead of using the global default signet network seed nodes"`
+	TargetOutbound       int           `long:"targetoutbound" description:"Number of outbound peers to maintain -- Limited to maxpeers"`
 	TestNet3             bool          `long:"testnet" description:"Use the test network"`
 	TorIsolation         bool          `long:"torisolation" description:"Enable Tor stream isolation by randomizing user credentials for each connection."`
 	TrickleInterval      time.Duration `long:"trickleinterval" description:"Minimum time between attempts to send new inventory to a connected peer"`
 	TxIndex              bool          `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
+	TxRelayBloomOnly     bool          `long:"txrelaybloomonly" description:"Only relay transactions to peers that advertise the bloom filter service (NODE_BLOOM)"`
 	UserAgentComments    []string      `long:"uacomment" description:"Comment to add to the user agent -- See BIP 14 for more information."`
 	Upnp                 bool          `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
 	ShowVersion          bool          `short:"V" long:"version" description:"Display version information and exit"`
@@ -177,7 +241,11 @@ type config struct {
 	addCheckpoints       []chaincfg.Checkpoint
 	miningAddrs          []btcutil.Address
 	minRelayTxFee        btcutil.Amount
+	maxTxFee             btcutil.Amount
+	services             wire.ServiceFlag
+	blockVersionBits     uint32
 	whitelists           []*net.IPNet
+	noRelayPeers         []*net.IPNet
 }
 
 // serviceOptions defines the configuration options for the daemon as a service on
@@ -233,6 +301,64 @@ func supportedSubsystems() []string {
 	return subsystems
 }
 
+// supportedServiceNames returns a sorted slice of the service names that may
+// be advertised via the advertiseservice option.
+func supportedServiceNames() []string {
+	names := make([]string, 0, len(serviceNames))
+	for name := range serviceNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseIPNet parses the passed string as either an IP network in CIDR notation
+// or a single IP address, which is treated as a network containing only that
+// address.  It returns nil when the string is neither.
+func parseIPNet(addr string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err == nil {
+		return ipnet
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	var bits int
+	if ip.To4() == nil {
+		// IPv6
+		bits = 128
+	} else {
+		bits = 32
+	}
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(bits, bits),
+	}
+}
+
+// parseServices converts the passed service names to the service flags to
+// advertise to peers.  All of the default services are returned when no names
+// are specified.
+func parseServices(names []string) (wire.ServiceFlag, error) {
+	if len(names) == 0 {
+		return defaultServices, nil
+	}
+
+	var services wire.ServiceFlag
+	for _, name := range names {
+		flag, ok := serviceNames[strings.ToLower(name)]
+		if !ok {
+			str := "The specified service [%v] is invalid -- " +
+				"supported services are %v"
+			return 0, fmt.Errorf(str, name, supportedServiceNames())
+		}
+		services |= flag
+	}
+	return services, nil
+}
+
 // parseAndSetDebugLevels attempts to parse the specified debug level and set
 // the levels accordingly.  An appropriate error is returned if anything is
 // invalid.
@@ -414,17 +540,30 @@ func loadConfig() (*config, []string, error) {
 		ConfigFile:           defaultConfigFile,
 		DebugLevel:           defaultLogLevel,
 		MaxPeers:             defaultMaxPeers,
+		MaxInbound:           defaultMaxInbound,
+		TargetOutbound:       defaultTargetOutbound,
 		BanDuration:          defaultBanDuration,
 		BanThreshold:         defaultBanThreshold,
+		InboundRateLimit:     defaultInboundRateLimit,
+		DialTimeout:          defaultConnectTimeout,
+		HandshakeTimeout:     defaultHandshakeTimeout,
+		PeerIdleTimeout:      defaultPeerIdleTimeout,
+		MaxClockSkew:         defaultMaxClockSkew,
+		PeersDBFormat:        defaultPeersDBFormat,
+		MiningAddrRotation:   defaultMiningAddrRotation,
 		RPCMaxClients:        defaultMaxRPCClients,
 		RPCMaxWebsockets:     defaultMaxRPCWebsockets,
 		RPCMaxConcurrentReqs: defaultMaxRPCConcurrentReqs,
+		RPCWorkers:           defaultRPCWorkers,
+		RPCWorkQueue:         defaultRPCWorkQueue,
+		RPCReorgChunkSize:    defaultRPCReorgChunkSize,
 		DataDir:              defaultDataDir,
 		LogDir:               defaultLogDir,
 		DbType:               defaultDbType,
 		RPCKey:               defaultRPCKeyFile,
 		RPCCert:              defaultRPCCertFile,
 		MinRelayTxFee:        mempool.DefaultMinRelayTxFee.ToBTC(),
+		MaxTxFee:             mempool.DefaultMaxTxFee.ToBTC(),
 		FreeTxRelayLimit:     defaultFreeTxRelayLimit,
 		TrickleInterval:      defaultTrickleInterval,
 		BlockMinSize:         defaultBlockMinSize,
@@ -432,8 +571,21 @@ func loadConfig() (*config, []string, error) {
 		BlockMinWeight:       defaultBlockMinWeight,
 		BlockMaxWeight:       defaultBlockMaxWeight,
 		BlockPrioritySize:    mempool.DefaultBlockPrioritySize,
+		MaxOrphanBlockBytes:  blockchain.DefaultMaxOrphanBlockBytes,
 		MaxOrphanTxs:         defaultMaxOrphanTransactions,
+		MaxOrphansPerCycle:   defaultMaxOrphansPerCycle,
+		LimitAncestorCount:   mempool.DefaultMaxAncestorCount,
+		LimitAncestorSize:    mempool.DefaultMaxAncestorSize / 1000,
+		LimitDescendantCount: mempool.DefaultMaxDescendantCount,
+		LimitDescendantSize:  mempool.DefaultMaxDescendantSize / 1000,
+		RebroadcastInterval:  defaultRebroadcastInterval,
+		BlockDownloadWindow:  defaultBlockDownloadWindow,
+		MaxBlocksInFlight:    defaultMaxBlocksInFlight,
+		GetDataBatchSize:     defaultGetDataBatchSize,
+		MaxScriptSigOps:      mempool.DefaultMaxScriptSigOps,
+		MaxScriptStackDepth:  mempool.DefaultMaxScriptStackDepth,
 		SigCacheMaxSize:      defaultSigCacheMaxSize,
+		ScriptCacheMaxSize:   defaultScriptCacheMaxSize,
 		Generate:             defaultGenerate,
 		TxIndex:              defaultTxIndex,
 		AddrIndex:            defaultAddrIndex,
@@ -665,6 +817,16 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Validate the peer database format.
+	if _, err := addrmgr.ParsePeersFormat(cfg.PeersDBFormat); err != nil {
+		str := "%s: The specified peer database format [%v] is " +
+			"invalid -- supported formats [binary json]"
+		err := fmt.Errorf(str, funcName, cfg.PeersDBFormat)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Validate profile port number
 	if cfg.Profile != "" {
 		profilePort, err := strconv.Atoi(cfg.Profile)
@@ -686,38 +848,68 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	if cfg.MaxClockSkew < 0 {
+		str := "%s: The maxclockskew option may not be negative -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.MaxClockSkew)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Don't allow peer timeouts that are too short.
+	timeouts := []struct {
+		option  string
+		timeout time.Duration
+	}{
+		{"dialtimeout", cfg.DialTimeout},
+		{"handshaketimeout", cfg.HandshakeTimeout},
+		{"peeridletimeout", cfg.PeerIdleTimeout},
+	}
+	for _, t := range timeouts {
+		if t.timeout < time.Second {
+			str := "%s: The %s option may not be less than 1s -- " +
+				"parsed [%v]"
+			err := fmt.Errorf(str, funcName, t.option, t.timeout)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
 	// Validate any given whitelisted IP addresses and networks.
 	if len(cfg.Whitelists) > 0 {
-		var ip net.IP
 		cfg.whitelists = make([]*net.IPNet, 0, len(cfg.Whitelists))
 
 		for _, addr := range cfg.Whitelists {
-			_, ipnet, err := net.ParseCIDR(addr)
-			if err != nil {
-				ip = net.ParseIP(addr)
-				if ip == nil {
-					str := "%s: The whitelist value of '%s' is invalid"
-					err = fmt.Errorf(str, funcName, addr)
-					fmt.Fprintln(os.Stderr, err)
-					fmt.Fprintln(os.Stderr, usageMessage)
-					return nil, nil, err
-				}
-				var bits int
-				if ip.To4() == nil {
-					// IPv6
-					bits = 128
-				} else {
-					bits = 32
-				}
-				ipnet = &net.IPNet{
-					IP:   ip,
-					Mask: net.CIDRMask(bits, bits),
-				}
+			ipnet := parseIPNet(addr)
+			if ipnet == nil {
+				str := "%s: The whitelist value of '%s' is invalid"
+				err = fmt.Errorf(str, funcName, addr)
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
 			}
 			cfg.whitelists = append(cfg.whitelists, ipnet)
 		}
 	}
 
+	// Validate any given block-relay-only IP addresses and networks.
+	if len(cfg.NoRelayPeers) > 0 {
+		cfg.noRelayPeers = make([]*net.IPNet, 0, len(cfg.NoRelayPeers))
+
+		for _, addr := range cfg.NoRelayPeers {
+			ipnet := parseIPNet(addr)
+			if ipnet == nil {
+				str := "%s: The norelaypeer value of '%s' is invalid"
+				err = fmt.Errorf(str, funcName, addr)
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
+			}
+			cfg.noRelayPeers = append(cfg.noRelayPeers, ipnet)
+		}
+	}
+
 	// --addPeer and --connect do not mix.
 	if len(cfg.AddPeers) > 0 && len(cfg.ConnectPeers) > 0 {
 		str := "%s: the --addpeer and --connect options can not be " +
@@ -791,6 +983,42 @@ func loadConfig() (*config, []string, error) {
 		}
 	}
 
+	if cfg.RPCWorkers < 1 {
+		str := "%s: The rpcworkers option may not be less than 1 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.RPCWorkers)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	if cfg.RPCWorkQueue < 0 {
+		str := "%s: The rpcworkqueue option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.RPCWorkQueue)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	if cfg.RPCReorgChunkSize < 1 {
+		str := "%s: The rpcreorgchunksize option may not be less than " +
+			"1 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.RPCReorgChunkSize)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	if cfg.RPCRequestTimeout < 0 {
+		str := "%s: The rpcrequesttimeout option may not be negative " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.RPCRequestTimeout)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	if cfg.RPCMaxConcurrentReqs < 0 {
 		str := "%s: The rpcmaxwebsocketconcurrentrequests option may " +
 			"not be less than 0 -- parsed [%d]"
@@ -810,6 +1038,28 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Validate the maxtxfee.
+	cfg.maxTxFee, err = btcutil.NewAmount(cfg.MaxTxFee)
+	if err == nil && cfg.maxTxFee < 0 {
+		err = fmt.Errorf("may not be negative")
+	}
+	if err != nil {
+		str := "%s: invalid maxtxfee: %v"
+		err := fmt.Errorf(str, funcName, err)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Validate the advertised services.
+	cfg.services, err = parseServices(cfg.AdvertiseServices)
+	if err != nil {
+		err := fmt.Errorf("%s: %v", funcName, err)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Limit the max block size to a sane value.
 	if cfg.BlockMaxSize < blockMaxSizeMin || cfg.BlockMaxSize >
 		blockMaxSizeMax {
@@ -836,6 +1086,18 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// The orphan block size limit must allow at least a single block of
+	// the maximum serialized size to be held.
+	if cfg.MaxOrphanBlockBytes < wire.MaxBlockPayload {
+		str := "%s: The maxorphanblockbytes option may not be less " +
+			"than %d -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, wire.MaxBlockPayload,
+			cfg.MaxOrphanBlockBytes)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Limit the max orphan count to a sane vlue.
 	if cfg.MaxOrphanTxs < 0 {
 		str := "%s: The maxorphantx option may not be less than 0 " +
@@ -846,6 +1108,148 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// The orphan processing limit may not be negative.
+	if cfg.MaxOrphansPerCycle < 0 {
+		str := "%s: The maxorphanspercycle option may not be less " +
+			"than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxOrphansPerCycle)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The mempool ancestor and descendant limits may not be negative.
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{"limitancestorcount", cfg.LimitAncestorCount},
+		{"limitancestorsize", cfg.LimitAncestorSize},
+		{"limitdescendantcount", cfg.LimitDescendantCount},
+		{"limitdescendantsize", cfg.LimitDescendantSize},
+	} {
+		if limit.value < 0 {
+			str := "%s: The %s option may not be less than 0 -- " +
+				"parsed [%d]"
+			err := fmt.Errorf(str, funcName, limit.name, limit.value)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
+	// The peer limits may not be negative.
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{"maxpeers", cfg.MaxPeers},
+		{"maxinbound", cfg.MaxInbound},
+		{"targetoutbound", cfg.TargetOutbound},
+	} {
+		if limit.value < 0 {
+			str := "%s: The %s option may not be less than 0 -- " +
+				"parsed [%d]"
+			err := fmt.Errorf(str, funcName, limit.name, limit.value)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
+	// Reserve room within the max peers for the outbound target so inbound
+	// peers can never prevent it from being maintained.
+	if cfg.TargetOutbound > cfg.MaxPeers {
+		cfg.TargetOutbound = cfg.MaxPeers
+	}
+	if maxInbound := cfg.MaxPeers - cfg.TargetOutbound; cfg.MaxInbound > maxInbound {
+		cfg.MaxInbound = maxInbound
+	}
+
+	// Don't allow rebroadcast intervals that would flood peers.
+	if cfg.RebroadcastInterval < time.Minute {
+		str := "%s: The rebroadcastinterval option may not be less " +
+			"than 1m -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.RebroadcastInterval)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Don't allow a minimum inbound protocol version that rejects peers
+	// running the same version as this node.
+	if cfg.MinInboundVersion > wire.ProtocolVersion {
+		str := "%s: The mininboundversion option may not be more than " +
+			"%d -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, wire.ProtocolVersion,
+			cfg.MinInboundVersion)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Limit the blocks requested during the initial block download.
+	if cfg.BlockDownloadWindow < 1 {
+		str := "%s: The blockdownloadwindow option may not be less " +
+			"than 1 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.BlockDownloadWindow)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.MaxBlocksInFlight < 1 {
+		str := "%s: The maxblocksinflight option may not be less " +
+			"than 1 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxBlocksInFlight)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Limit the number of inventory items requested by a single getdata
+	// message to the maximum allowed by the protocol.
+	if cfg.GetDataBatchSize < 1 || cfg.GetDataBatchSize > wire.MaxInvPerMsg {
+		str := "%s: The getdatabatchsize option must be between 1 " +
+			"and %d -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, wire.MaxInvPerMsg,
+			cfg.GetDataBatchSize)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The script complexity limits may not be negative.
+	if cfg.MaxScriptSigOps < 0 {
+		str := "%s: The maxscriptsigops option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxScriptSigOps)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.MaxScriptStackDepth < 0 {
+		str := "%s: The maxscriptstackdepth option may not be less " +
+			"than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxScriptStackDepth)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Combine the version bits to signal in generated blocks while ensuring
+	// they are available to the version bits scheme.
+	for _, bit := range cfg.SignalBits {
+		if bit >= maxSignalBit {
+			str := "%s: The signalbit option must be less than %d " +
+				"-- parsed [%d]"
+			err := fmt.Errorf(str, funcName, maxSignalBit, bit)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		cfg.blockVersionBits |= 1 << bit
+	}
+
 	// Limit the block priority and minimum block sizes to max block size.
 	cfg.BlockPrioritySize = minUint32(cfg.BlockPrioritySize, cfg.BlockMaxSize)
 	cfg.BlockMinSize = minUint32(cfg.BlockMinSize, cfg.BlockMaxSize)
@@ -933,6 +1337,16 @@ func loadConfig() (*config, []string, error) {
 		cfg.miningAddrs = append(cfg.miningAddrs, addr)
 	}
 
+	// Validate the mining address rotation.
+	if _, err := mining.ParsePayoutRotation(cfg.MiningAddrRotation); err != nil {
+		str := "%s: The specified mining address rotation [%v] is " +
+			"invalid -- supported rotations [random roundrobin height]"
+		err := fmt.Errorf(str, funcName, cfg.MiningAddrRotation)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Ensure there is at least one mining address when the generate flag is
 	// set.
 	if cfg.Generate && len(cfg.MiningAddrs) == 0 {
@@ -1209,9 +1623,9 @@ func createDefaultConfigFile(destinationPath string) error {
 func btcdDial(addr net.Addr) (net.Conn, error) {
 	if strings.Contains(addr.String(), ".onion:") {
 		return cfg.oniondial(addr.Network(), addr.String(),
-			defaultConnectTimeout)
+			cfg.DialTimeout)
 	}
-	return cfg.dial(addr.Network(), addr.String(), defaultConnectTimeout)
+	return cfg.dial(addr.Network(), addr.String(), cfg.DialTimeout)
 }
 
 // btcdLookup resolves the IP of the given host using the correct DNS lookup
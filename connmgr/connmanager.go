@@ -22,6 +22,10 @@ var (
 	//ErrDialNil is used to indicate that Dial cannot be nil in the configuration.
 	ErrDialNil = errors.New("Config: Dial cannot be nil")
 
+	// ErrDialTimeout is used to indicate that a connection attempt was
+	// abandoned because it did not complete within the dial timeout.
+	ErrDialTimeout = errors.New("dial timed out")
+
 	// maxRetryDuration is the max duration of time retrying of a persistent
 	// connection is allowed to grow to.  This is necessary since the retry
 	// logic uses a backoff mechanism which increases the interval base times
@@ -120,6 +124,20 @@ type Config struct {
 	// connections in that case.
 	OnAccept func(net.Conn)
 
+	// InboundRateLimit is the maximum number of inbound connections per
+	// minute accepted from a single subnet, which is the /16 for IPv4 and
+	// the /32 for IPv6 addresses.  Bursts of up to the same number of
+	// connections are accepted.  Connections exceeding the limit are closed
+	// without invoking the OnAccept handler.  Connections from loopback
+	// addresses are never limited.  A value of zero disables the limit.
+	InboundRateLimit uint32
+
+	// IsRateLimitExempt returns whether inbound connections from the
+	// passed address are exempt from InboundRateLimit, such as connections
+	// from whitelisted addresses.  This field can be nil in which case only
+	// loopback addresses are exempt.
+	IsRateLimitExempt func(net.Addr) bool
+
 	// TargetOutbound is the number of outbound network connections to
 	// maintain. Defaults to 8.
 	TargetOutbound uint32
@@ -142,6 +160,12 @@ type Config struct {
 
 	// Dial connects to the address on the named network. It cannot be nil.
 	Dial func(net.Addr) (net.Conn, error)
+
+	// DialTimeout is the maximum amount of time to wait for Dial to
+	// establish a connection before the attempt is considered failed.  Any
+	// connection that is established after the attempt was abandoned is
+	// closed.  A value of zero disables the timeout.
+	DialTimeout time.Duration
 }
 
 // registerPending is used to register a pending connection attempt. By
@@ -181,6 +205,7 @@ type ConnManager struct {
 	cfg            Config
 	wg             sync.WaitGroup
 	failedAttempts uint64
+	inboundLimiter *subnetRateLimiter
 	requests       chan interface{}
 	quit           chan struct{}
 }
@@ -219,6 +244,24 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 	}
 }
 
+// outboundCount returns the number of connection requests that count towards
+// the outbound target, which are the non-permanent requests that are either
+// established or still pending.
+func outboundCount(conns, pending map[uint64]*ConnReq) uint32 {
+	var count uint32
+	for _, connReq := range conns {
+		if !connReq.Permanent {
+			count++
+		}
+	}
+	for _, connReq := range pending {
+		if !connReq.Permanent && connReq.State() == ConnPending {
+			count++
+		}
+	}
+	return count
+}
+
 // connHandler handles all connection related requests.  It must be run as a
 // goroutine.
 //
@@ -311,8 +354,17 @@ out:
 				// All internal state has been cleaned up, if
 				// this connection is being removed, we will
 				// make no further attempts with this request.
+				// A new request is made instead when it was
+				// counted towards the outbound target and the
+				// target is no longer met.
 				if !msg.retry {
 					connReq.updateState(ConnDisconnected)
+					if !connReq.Permanent &&
+						outboundCount(conns, pending) <
+							cm.cfg.TargetOutbound {
+
+						go cm.NewConnReq()
+					}
 					continue
 				}
 
@@ -441,7 +493,7 @@ func (cm *ConnManager) Connect(c *ConnReq) {
 
 	log.Debugf("Attempting to connect to %v", c)
 
-	conn, err := cm.cfg.Dial(c.Addr)
+	conn, err := cm.dial(c.Addr)
 	if err != nil {
 		select {
 		case cm.requests <- handleFailed{c, err}:
@@ -456,6 +508,43 @@ func (cm *ConnManager) Connect(c *ConnReq) {
 	}
 }
 
+// dial connects to the passed address using the configured dial function.  The
+// attempt is abandoned with ErrDialTimeout when it does not complete within the
+// configured dial timeout, in which case the connection is closed should it
+// eventually be established.
+func (cm *ConnManager) dial(addr net.Addr) (net.Conn, error) {
+	if cm.cfg.DialTimeout <= 0 {
+		return cm.cfg.Dial(addr)
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	result := make(chan dialResult, 1)
+	go func() {
+		conn, err := cm.cfg.Dial(addr)
+		result <- dialResult{conn, err}
+	}()
+
+	timer := time.NewTimer(cm.cfg.DialTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		return r.conn, r.err
+	case <-timer.C:
+	case <-cm.quit:
+	}
+
+	// Close the connection if the abandoned attempt eventually succeeds.
+	go func() {
+		if r := <-result; r.conn != nil {
+			r.conn.Close()
+		}
+	}()
+	return nil, ErrDialTimeout
+}
+
 // Disconnect disconnects the connection corresponding to the given connection
 // id. If permanent, the connection will be retried with an increasing backoff
 // duration.
@@ -486,6 +575,14 @@ func (cm *ConnManager) Remove(id uint64) {
 	}
 }
 
+// isRateLimitExempt returns whether the passed inbound connection is exempt from
+// the inbound connection rate limit as determined by the IsRateLimitExempt
+// callback of the configuration.
+func (cm *ConnManager) isRateLimitExempt(conn net.Conn) bool {
+	return cm.cfg.IsRateLimitExempt != nil &&
+		cm.cfg.IsRateLimitExempt(conn.RemoteAddr())
+}
+
 // listenHandler accepts incoming connections on a given listener.  It must be
 // run as a goroutine.
 func (cm *ConnManager) listenHandler(listener net.Listener) {
@@ -499,6 +596,15 @@ func (cm *ConnManager) listenHandler(listener net.Listener) {
 			}
 			continue
 		}
+		if cm.inboundLimiter != nil && !cm.isRateLimitExempt(conn) &&
+			!cm.inboundLimiter.allow(conn.RemoteAddr(), time.Now()) {
+
+			log.Debugf("Refusing connection from %s: inbound "+
+				"connection rate limit of its subnet exceeded",
+				conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
 		go cm.cfg.OnAccept(conn)
 	}
 
@@ -573,5 +679,8 @@ func New(cfg *Config) (*ConnManager, error) {
 		requests: make(chan interface{}),
 		quit:     make(chan struct{}),
 	}
+	if cfg.InboundRateLimit > 0 {
+		cm.inboundLimiter = newSubnetRateLimiter(cfg.InboundRateLimit)
+	}
 	return &cm, nil
 }
@@ -473,6 +473,87 @@ func TestRemovePendingConnection(t *testing.T) {
 	cmgr.Stop()
 }
 
+// closeTrackingConn is a mock connection that signals when it is closed.
+type closeTrackingConn struct {
+	mockConn
+	closed chan struct{}
+}
+
+// Close signals the connection was closed.
+func (c *closeTrackingConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+// TestDialTimeout ensures a connection attempt that does not complete within
+// the dial timeout fails and that the connection is closed when the abandoned
+// dial eventually succeeds.
+func TestDialTimeout(t *testing.T) {
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	slowDialer := func(addr net.Addr) (net.Conn, error) {
+		<-release
+		return &closeTrackingConn{
+			mockConn: mockConn{rAddr: addr},
+			closed:   closed,
+		}, nil
+	}
+	cmgr, err := New(&Config{
+		Dial:        slowDialer,
+		DialTimeout: 10 * time.Millisecond,
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			t.Errorf("dial timeout: got unexpected connection - %v",
+				c.Addr)
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+	defer func() {
+		cmgr.Stop()
+		cmgr.Wait()
+	}()
+
+	// Connect to an address with a dialer that doesn't return until it is
+	// released and ensure the attempt is marked as failed once the dial
+	// timeout expires.
+	cr := &ConnReq{
+		Addr: &net.TCPAddr{
+			IP:   net.ParseIP("127.0.0.1"),
+			Port: 18555,
+		},
+	}
+	connected := make(chan struct{})
+	go func() {
+		cmgr.Connect(cr)
+		close(connected)
+	}()
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("dial timeout: connection attempt was not abandoned")
+	}
+	// The failure is handled asynchronously, so wait for the state change.
+	deadline := time.Now().Add(time.Second)
+	for cr.State() != ConnFailing {
+		if time.Now().After(deadline) {
+			t.Fatalf("dial timeout: unexpected request state - "+
+				"got %v, want %v", cr.State(), ConnFailing)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Release the dialer and ensure the connection it eventually
+	// establishes is closed.
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("dial timeout: late connection was not closed")
+	}
+}
+
 // TestCancelIgnoreDelayedConnection tests that a canceled connection request will
 // not execute the on connection callback, even if an outstanding retry
 // succeeds.
@@ -664,3 +745,70 @@ out:
 	cmgr.Stop()
 	cmgr.Wait()
 }
+
+// TestTargetOutboundMaintained ensures new outbound connections are made to
+// reach the target outbound again after outbound connections are removed, but
+// not after permanent connections are removed.
+func TestTargetOutboundMaintained(t *testing.T) {
+	targetOutbound := uint32(3)
+	connected := make(chan *ConnReq, 10)
+	cmgr, err := New(&Config{
+		TargetOutbound: targetOutbound,
+		Dial:           mockDialer,
+		GetNewAddress: func() (net.Addr, error) {
+			return &net.TCPAddr{
+				IP:   net.ParseIP("127.0.0.1"),
+				Port: 18555,
+			}, nil
+		},
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			connected <- c
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+	defer cmgr.Stop()
+
+	// waitConnected waits for the given number of connections and ensures
+	// no further ones are made.
+	waitConnected := func(n int) []*ConnReq {
+		t.Helper()
+		conns := make([]*ConnReq, 0, n)
+		for i := 0; i < n; i++ {
+			select {
+			case c := <-connected:
+				conns = append(conns, c)
+			case <-time.After(time.Second * 5):
+				t.Fatalf("timeout waiting for connection %d", i)
+			}
+		}
+		select {
+		case c := <-connected:
+			t.Fatalf("got unexpected connection - %v", c)
+		case <-time.After(time.Millisecond * 250):
+		}
+		return conns
+	}
+	conns := waitConnected(int(targetOutbound))
+
+	// Removing an outbound connection, as is done once its peer
+	// disconnects, must result in a replacement.
+	cmgr.Remove(conns[0].ID())
+	waitConnected(1)
+
+	// Removing a permanent connection must not result in any outbound
+	// connections beyond the target.
+	permanent := &ConnReq{
+		Addr: &net.TCPAddr{
+			IP:   net.ParseIP("127.0.0.1"),
+			Port: 18556,
+		},
+		Permanent: true,
+	}
+	go cmgr.Connect(permanent)
+	waitConnected(1)
+	cmgr.Remove(permanent.ID())
+	waitConnected(0)
+}
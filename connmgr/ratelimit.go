@@ -0,0 +1,108 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// subnetBucket is a token bucket tracking the inbound connections accepted
+// from a single subnet.
+type subnetBucket struct {
+	tokens     float64
+	lastUpdate time.Time
+}
+
+// subnetRateLimiter limits the rate of inbound connections accepted from each
+// subnet using a token bucket per subnet.  Each bucket holds up to 'burst'
+// tokens, is refilled at 'rate' tokens per second, and accepting a connection
+// consumes a token.
+type subnetRateLimiter struct {
+	mtx       sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*subnetBucket
+	lastPrune time.Time
+}
+
+// newSubnetRateLimiter returns a new rate limiter which accepts up to the
+// passed number of connections per minute from each subnet, with bursts of up
+// to the same number of connections.
+func newSubnetRateLimiter(perMinute uint32) *subnetRateLimiter {
+	return &subnetRateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: make(map[string]*subnetBucket),
+	}
+}
+
+// subnetKey returns the key of the subnet the passed address belongs to, which
+// is the /16 for IPv4 addresses and the /32 for IPv6 addresses, the same
+// subnets the address manager groups addresses by.  An empty string is
+// returned for addresses that are not IP addresses and for loopback addresses
+// since they are not limited.
+func subnetKey(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
+
+// allow returns whether a connection from the passed address is allowed at the
+// passed time and consumes a token from the bucket of its subnet when it is.
+// Connections from addresses that are not IP addresses and from loopback
+// addresses are always allowed.
+//
+// This function is safe for concurrent access.
+func (l *subnetRateLimiter) allow(addr net.Addr, now time.Time) bool {
+	key := subnetKey(addr)
+	if key == "" {
+		return true
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	// Remove the buckets of subnets that have been refilled completely
+	// since they are equivalent to new buckets.  This is done at most once
+	// per refill period to bound the cost.
+	refillTime := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastPrune) >= refillTime {
+		for key, bucket := range l.buckets {
+			if now.Sub(bucket.lastUpdate) >= refillTime {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &subnetBucket{tokens: l.burst, lastUpdate: now}
+		l.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.lastUpdate); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * l.rate
+		if bucket.tokens > l.burst {
+			bucket.tokens = l.burst
+		}
+		bucket.lastUpdate = now
+	}
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
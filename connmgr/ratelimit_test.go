@@ -0,0 +1,138 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestSubnetRateLimiter ensures the subnet rate limiter allows bursts up to
+// its limit, refills over time, tracks subnets independently, and never limits
+// loopback addresses.
+func TestSubnetRateLimiter(t *testing.T) {
+	tcpAddr := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 8333}
+	}
+
+	const perMinute = 3
+	limiter := newSubnetRateLimiter(perMinute)
+	now := time.Unix(1600000000, 0)
+
+	// Ensure a burst of connections from different addresses of the same
+	// subnet is limited.
+	for i := 0; i < perMinute; i++ {
+		addr := tcpAddr(net.IPv4(10, 1, byte(i), 1).String())
+		if !limiter.allow(addr, now) {
+			t.Fatalf("connection %d from %v refused", i, addr)
+		}
+	}
+	if limiter.allow(tcpAddr("10.1.200.1"), now) {
+		t.Fatal("connection exceeding the limit allowed")
+	}
+
+	// Ensure other subnets are not affected, including IPv6 addresses
+	// sharing their /32.
+	if !limiter.allow(tcpAddr("10.2.0.1"), now) {
+		t.Fatal("connection from other subnet refused")
+	}
+	for i := 0; i < perMinute; i++ {
+		if !limiter.allow(tcpAddr("2001:db8::1"), now) {
+			t.Fatalf("IPv6 connection %d refused", i)
+		}
+	}
+	if limiter.allow(tcpAddr("2001:db8:ffff::1"), now) {
+		t.Fatal("IPv6 connection exceeding the limit allowed")
+	}
+
+	// Ensure loopback addresses are never limited.
+	for _, ip := range []string{"127.0.0.1", "::1"} {
+		for i := 0; i < perMinute*2; i++ {
+			if !limiter.allow(tcpAddr(ip), now) {
+				t.Fatalf("loopback connection %d from %s refused",
+					i, ip)
+			}
+		}
+	}
+
+	// Ensure a connection is allowed again once a token was refilled.
+	now = now.Add(time.Minute / perMinute)
+	if !limiter.allow(tcpAddr("10.1.0.1"), now) {
+		t.Fatal("connection refused after refill")
+	}
+	if limiter.allow(tcpAddr("10.1.0.1"), now) {
+		t.Fatal("connection allowed before refill")
+	}
+
+	// Ensure completely refilled buckets are pruned.
+	now = now.Add(time.Minute)
+	limiter.allow(tcpAddr("10.3.0.1"), now)
+	if len(limiter.buckets) != 1 {
+		t.Fatalf("unexpected number of buckets - got %d, want 1",
+			len(limiter.buckets))
+	}
+}
+
+// TestInboundRateLimit ensures the connection manager refuses rapid inbound
+// connections from a subnet exceeding the configured rate limit while
+// accepting connections from other subnets and from exempt addresses.
+func TestInboundRateLimit(t *testing.T) {
+	receivedConns := make(chan net.Conn)
+	listener := newMockListener("127.0.0.1:8333")
+	cmgr, err := New(&Config{
+		Listeners: []net.Listener{listener},
+		OnAccept: func(conn net.Conn) {
+			receivedConns <- conn
+		},
+		InboundRateLimit: 2,
+		IsRateLimitExempt: func(addr net.Addr) bool {
+			return subnetKey(addr) == "10.3.0.0"
+		},
+		Dial: mockDialer,
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+	defer func() {
+		cmgr.Stop()
+		cmgr.Wait()
+	}()
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			listener.Connect("10.1.0.1", 10000+i)
+		}
+		listener.Connect("10.2.0.1", 10000)
+		for i := 0; i < 5; i++ {
+			listener.Connect("10.3.0.1", 10000+i)
+		}
+	}()
+
+	accepted := make(map[string]int)
+out:
+	for {
+		select {
+		case conn := <-receivedConns:
+			accepted[subnetKey(conn.RemoteAddr())]++
+
+		case <-time.After(time.Millisecond * 100):
+			break out
+		}
+	}
+	if accepted["10.1.0.0"] != 2 {
+		t.Fatalf("unexpected number of connections from the limited "+
+			"subnet - got %d, want 2", accepted["10.1.0.0"])
+	}
+	if accepted["10.2.0.0"] != 1 {
+		t.Fatalf("unexpected number of connections from the other "+
+			"subnet - got %d, want 1", accepted["10.2.0.0"])
+	}
+	if accepted["10.3.0.0"] != 5 {
+		t.Fatalf("unexpected number of connections from the exempt "+
+			"subnet - got %d, want 5", accepted["10.3.0.0"])
+	}
+}
@@ -27,11 +27,18 @@ Application Options:
       --addrindex             Maintain a full address-based transaction index
                               which makes the searchrawtransactions RPC
                               available
+      --advertiseservice=     Add a service to advertise to peers {network,
+                              networklimited, bloom, witness, cf} -- May be
+                              specified multiple times (default: all but
+                              networklimited)
       --banduration=          How long to ban misbehaving peers.  Valid time
                               units are {s, m, h}.  Minimum 1 second (default:
                               24h0m0s)
       --banthreshold=         Maximum allowed ban score before disconnecting
                               and banning misbehaving peers. (default: 100)
+      --blockdownloadwindow=  Max number of blocks past the best chain tip to
+                              request during the initial block download
+                              (default: 1024)
       --blockmaxsize=         Maximum block size in bytes to be used when
                               creating a block (default: 750000)
       --blockminsize=         Mininum block size in bytes to be used when
@@ -57,6 +64,9 @@ Application Options:
                               set the log level for individual subsystems --
                               Use show to list available subsystems (default:
                               info)
+      --dialtimeout=          Maximum amount of time to wait for an outbound
+                              connection to be established.  Valid time units
+                              are {s, m, h}.  Minimum 1 second (default: 30s)
       --dropaddrindex         Deletes the address-based transaction index from
                               the database on start up and then exits.
       --dropcfindex           Deletes the index used for committed filtering
@@ -67,6 +77,35 @@ Application Options:
       --externalip=           Add an ip to the list of local addresses we claim
                               to listen on to peers
       --generate              Generate (mine) bitcoins using the CPU
+      --getdatabatchsize=     Max number of inventory items requested by a
+                              single getdata message -- Larger requests are
+                              split into multiple messages -- Must be between
+                              1 and 50000 (default: 1000)
+      --handshaketimeout=     Maximum amount of time a peer is given to
+                              complete the version handshake before it is
+                              disconnected.  Valid time units are {s, m, h}.
+                              Minimum 1 second (default: 30s)
+      --inboundratelimit=     Max number of inbound connections per minute
+                              accepted from a single subnet (/16 for IPv4, /32
+                              for IPv6), except for whitelisted and loopback
+                              addresses -- 0 to disable (default: 30)
+      --limitancestorcount=   Do not accept transactions into the mempool if
+                              they and their unconfirmed ancestors would
+                              consist of more than this number of
+                              transactions -- 0 to disable (default: 25)
+      --limitancestorsize=    Do not accept transactions into the mempool if
+                              they and their unconfirmed ancestors would exceed
+                              this combined size in kilobytes -- 0 to disable
+                              (default: 101)
+      --limitdescendantcount= Do not accept transactions into the mempool if
+                              any of their unconfirmed ancestors and its
+                              descendants would consist of more than this
+                              number of transactions -- 0 to disable
+                              (default: 25)
+      --limitdescendantsize=  Do not accept transactions into the mempool if
+                              any of their unconfirmed ancestors and its
+                              descendants would exceed this combined size in
+                              kilobytes -- 0 to disable (default: 101)
       --limitfreerelay=       Limit relay of transactions with no transaction
                               fee to the given amount in thousands of bytes per
                               minute (default: 15)
@@ -74,14 +113,52 @@ Application Options:
                               (default all interfaces port: 8333, testnet:
                               18333, signet: 38333)
       --logdir=               Directory to log output
+      --maxblocksinflight=    Max number of blocks requested from a single peer
+                              that may be outstanding at once during the
+                              initial block download (default: 128)
+      --maxclockskew=         Report a warning via the getinfo and
+                              getnetworkinfo RPCs when the median time of
+                              peers differs from the local clock by more than
+                              this amount.  Valid time units are {s, m, h}.  0
+                              to disable (default: 5m0s)
+      --maxinbound=           Max number of inbound peers -- Limited to
+                              maxpeers minus targetoutbound so inbound peers
+                              never prevent the outbound target from being
+                              maintained (default: 117)
+      --maxorphanblockbytes=  Max combined size in bytes of orphan blocks to
+                              keep in memory -- The oldest orphan blocks are
+                              evicted when it is exceeded (default: 33554432)
       --maxorphantx=          Max number of orphan transactions to keep in
                               memory (default: 100)
+      --maxorphanspercycle=   Max number of orphan transactions to reconsider
+                              for acceptance each time a transaction is
+                              accepted or a block is connected -- 0 to disable
+                              (default: 50)
       --maxpeers=             Max number of inbound and outbound peers
                               (default: 125)
+      --maxscriptsigops=      Max number of signature operations a standard
+                              transaction input may execute -- 0 to disable
+                              (default: 100)
+      --maxscriptstackdepth=  Max combined stack depth a standard transaction
+                              input may reach during script execution -- 0 to
+                              disable (default: 200)
+      --maxtxfee=             The maximum total fee in BTC a transaction
+                              submitted via RPC may pay unless high fees are
+                              allowed or a max fee rate is given for it -- 0 to
+                              disable (default: 0.1)
+      --mininboundversion=    Minimum protocol version inbound peers must
+                              advertise to be accepted -- 0 to accept all
+                              supported versions
       --miningaddr=           Add the specified payment address to the list of
                               addresses to use for generated blocks -- At least
                               one address is required if the generate option is
                               set
+      --miningaddrrotation=   How the payment address of each generated block is
+                              chosen from the mining addresses {random,
+                              roundrobin, height} -- roundrobin moves on to the
+                              next one each time a block is connected and height
+                              chooses them by the height of the block (default:
+                              random)
       --minrelaytxfee=        The minimum transaction fee in BTC/kB to be
                               considered a non-zero fee. (default: 1e-05)
       --nobanning             Disable banning of misbehaving peers
@@ -95,6 +172,10 @@ Application Options:
                               also specifying listen interfaces via --listen
       --noonion               Disable connecting to tor hidden services
       --nopeerbloomfilters    Disable bloom filtering support
+      --norelaypeer=          Add an IP network or IP of peers that only relay
+                              blocks.  Transactions are neither requested from
+                              nor announced to them. (eg. 192.168.1.0/24 or
+                              ::1)
       --norelaypriority       Do not require free or low-fee transactions to
                               have high priority for relaying
       --norpc                 Disable built-in RPC server -- NOTE: The RPC
@@ -108,11 +189,24 @@ Application Options:
                               (eg. 127.0.0.1:9050)
       --onionpass=            Password for onion proxy server
       --onionuser=            Username for onion proxy server
+      --peeridletimeout=      Duration of inactivity after which a peer is
+                              disconnected.  Valid time units are {s, m, h}.
+                              Minimum 1 second (default: 5m0s)
+      --peersdbformat=        Format to persist known peer addresses in --
+                              binary (peers.dat) or json (peers.json).
+                              Switching formats migrates the addresses saved in
+                              the other format on startup (default: json)
       --profile=              Enable HTTP profiling on given port -- NOTE port
                               must be between 1024 and 65536
       --proxy=                Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)
       --proxypass=            Password for proxy server
       --proxyuser=            Username for proxy server
+      --rebroadcastinterval=  Average amount of time between rebroadcasts of
+                              locally submitted transactions that have not been
+                              requested by any peers yet.  The actual time is
+                              randomized to be within half the interval of it.
+                              Valid time units are {s, m, h}.  Minimum 1 minute
+                              (default: 30m0s)
       --regtest               Use the regression test network
       --rejectnonstd          Reject non-standard transactions regardless of
                               the default settings for the active network.
@@ -133,11 +227,35 @@ Application Options:
       --rpcquirks             Mirror some JSON-RPC quirks of Bitcoin Core --
                               NOTE: Discouraged unless interoperability issues
                               need to be worked around
+      --rpcreorgchunksize=    Max number of block disconnected and connected
+                              notifications of a chain reorganization queued
+                              for websocket clients at once (default: 10)
+      --rpcrequesttimeout=    Maximum amount of time a standard RPC request may
+                              wait for and be processed by a worker before an
+                              error is returned -- 0 disables the timeout
+      --rpcverifyblocks       Verify that the transactions of blocks loaded from
+                              the database hash to the merkle root of their
+                              header before serving them via RPC
+      --rpcworkers=           Number of workers used to process standard RPC
+                              requests concurrently (default: 10)
+      --rpcworkqueue=         Max number of standard RPC requests that may wait
+                              for a worker before the server responds as busy
+                              (default: 16)
   -P, --rpcpass=              Password for RPC connections
   -u, --rpcuser=              Username for RPC connections
+      --scriptcachemaxsize=   The maximum number of entries in the cache of
+                              classified public key scripts used by the
+                              address index and RPC server -- 0 disables the
+                              cache (default: 10000)
+      --signalbit=            Signal the given version bit (0-28) in the
+                              version of generated blocks in addition to the
+                              bits of the rule change deployments being voted
+                              on -- may be specified multiple times
       --sigcachemaxsize=      The maximum number of entries in the signature
                               verification cache (default: 100000)
       --simnet                Use the simulation test network
+      --targetoutbound=       Number of outbound peers to maintain -- Limited
+                              to maxpeers (default: 8)
       --testnet               Use the test network
       --torisolation          Enable Tor stream isolation by randomizing user
                               credentials for each connection.
@@ -146,6 +264,8 @@ Application Options:
       --txindex               Maintain a full hash-based transaction index
                               which makes all transactions available via the
                               getrawtransaction RPC
+      --txrelaybloomonly      Only relay transactions to peers that advertise
+                              the bloom filter service (NODE_BLOOM)
       --uacomment=            Comment to add to the user agent -- See BIP 14
                               for more information.
       --upnp                  Use UPnP to map our listening port outside of NAT
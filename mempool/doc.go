@@ -40,6 +40,7 @@ be an exhaustive list.
    - Reject non-fully-spent duplicate transactions
    - Reject coinbase transactions
    - Reject double spends (both from the chain and other transactions in pool)
+   - Notification of validly signed double spends of unconfirmed transactions
    - Reject invalid transactions according to the network consensus rules
    - Full script execution and validation with signature cache support
    - Individual transaction query support
@@ -77,5 +78,10 @@ between unexpected errors, such as database errors, versus errors due to rule
 violations through type assertions.  In addition, callers can programmatically
 determine the specific rule violation by type asserting the Err field to one of
 the aforementioned types and examining their underlying ErrorCode field.
+
+Callers that only need to know the broad reason a transaction was rejected can
+use the ErrorKindOf function, which classifies an error as a consensus violation,
+a policy rejection, a transaction with missing inputs (an orphan), or a transient
+failure that is unrelated to the validity of the transaction.
 */
 package mempool
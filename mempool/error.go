@@ -5,10 +5,58 @@
 package mempool
 
 import (
+	"fmt"
+
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/wire"
 )
 
+// ErrorKind classifies the reason a transaction was rejected so callers can
+// programmatically distinguish between transactions that are invalid, those
+// that are valid but not accepted by local policy, those that can't be
+// validated yet because their inputs are unknown, and failures that are
+// unrelated to the transaction itself.
+type ErrorKind int
+
+// These constants are used to identify a specific ErrorKind.
+const (
+	// ErrKindPolicy indicates the transaction does not violate any
+	// consensus rules, but was rejected by the local mempool policy, such
+	// as the standardness, fee, or replacement rules.  It is the zero value
+	// so a TxRuleError that does not specify a kind is treated as a policy
+	// rejection.
+	ErrKindPolicy ErrorKind = iota
+
+	// ErrKindConsensus indicates the transaction violates the consensus
+	// rules and is therefore invalid regardless of policy.
+	ErrKindConsensus
+
+	// ErrKindMissingInputs indicates the transaction references outputs
+	// that are unknown or already spent, which is the case for orphans.
+	ErrKindMissingInputs
+
+	// ErrKindTransient indicates a failure that is unrelated to the
+	// validity of the transaction, such as a database error.  Such
+	// transactions may be accepted when resubmitted.
+	ErrKindTransient
+)
+
+// Map of ErrorKind values back to their constant names for pretty printing.
+var errorKindStrings = map[ErrorKind]string{
+	ErrKindPolicy:        "ErrKindPolicy",
+	ErrKindConsensus:     "ErrKindConsensus",
+	ErrKindMissingInputs: "ErrKindMissingInputs",
+	ErrKindTransient:     "ErrKindTransient",
+}
+
+// String returns the ErrorKind as a human-readable name.
+func (k ErrorKind) String() string {
+	if s := errorKindStrings[k]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorKind (%d)", int(k))
+}
+
 // RuleError identifies a rule violation.  It is used to indicate that
 // processing of a transaction failed due to one of the many validation
 // rules.  The caller can use type assertions to determine if a failure was
@@ -35,6 +83,7 @@ func (e RuleError) Error() string {
 type TxRuleError struct {
 	RejectCode  wire.RejectCode // The code to send with reject messages
 	Description string          // Human readable description of the issue
+	Kind        ErrorKind       // Classification of the violation
 }
 
 // Error satisfies the error interface and prints human-readable errors.
@@ -43,10 +92,23 @@ func (e TxRuleError) Error() string {
 }
 
 // txRuleError creates an underlying TxRuleError with the given a set of
-// arguments and returns a RuleError that encapsulates it.
+// arguments and returns a RuleError that encapsulates it.  Rejections with the
+// invalid or malformed reject codes are classified as consensus violations,
+// while all others are classified as policy violations.
 func txRuleError(c wire.RejectCode, desc string) RuleError {
+	kind := ErrKindPolicy
+	switch c {
+	case wire.RejectInvalid, wire.RejectMalformed:
+		kind = ErrKindConsensus
+	}
+	return txRuleErrorKind(kind, c, desc)
+}
+
+// txRuleErrorKind creates an underlying TxRuleError of the given kind with the
+// given set of arguments and returns a RuleError that encapsulates it.
+func txRuleErrorKind(kind ErrorKind, c wire.RejectCode, desc string) RuleError {
 	return RuleError{
-		Err: TxRuleError{RejectCode: c, Description: desc},
+		Err: TxRuleError{RejectCode: c, Description: desc, Kind: kind},
 	}
 }
 
@@ -58,6 +120,31 @@ func chainRuleError(chainErr blockchain.RuleError) RuleError {
 	}
 }
 
+// ErrorKindOf classifies the passed error returned by the mempool.  Violations
+// of the blockchain (consensus) rules are classified as ErrKindConsensus, while
+// mempool rule violations are classified according to their Kind field.  Any
+// other error, such as a database error, is classified as ErrKindTransient.
+//
+// Note that script validation is performed with the additional standard
+// verification flags, so a script failure might be a policy violation even
+// though it is reported as a consensus violation.
+func ErrorKindOf(err error) ErrorKind {
+	// Pull the underlying error out of a RuleError.
+	if rerr, ok := err.(RuleError); ok {
+		err = rerr.Err
+	}
+
+	switch err := err.(type) {
+	case blockchain.RuleError:
+		return ErrKindConsensus
+
+	case TxRuleError:
+		return err.Kind
+	}
+
+	return ErrKindTransient
+}
+
 // extractRejectCode attempts to return a relevant reject code for a given error
 // by examining the error for known types.  It will return true if a code
 // was successfully extracted.
@@ -8,6 +8,7 @@ import (
 	"container/list"
 	"fmt"
 	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -48,6 +49,24 @@ const (
 	// can be evicted from the mempool when accepting a transaction
 	// replacement.
 	MaxReplacementEvictions = 100
+
+	// DefaultMaxAncestorCount is the default maximum number of
+	// transactions in the mempool, including the transaction itself, a
+	// transaction and its unconfirmed ancestors may consist of.
+	DefaultMaxAncestorCount = 25
+
+	// DefaultMaxAncestorSize is the default maximum combined virtual size
+	// of a transaction and its unconfirmed ancestors.
+	DefaultMaxAncestorSize = 101000
+
+	// DefaultMaxDescendantCount is the default maximum number of
+	// transactions in the mempool, including the transaction itself, a
+	// transaction and its unconfirmed descendants may consist of.
+	DefaultMaxDescendantCount = 25
+
+	// DefaultMaxDescendantSize is the default maximum combined virtual
+	// size of a transaction and its unconfirmed descendants.
+	DefaultMaxDescendantSize = 101000
 )
 
 // Tag represents an identifier to use for tagging orphan transactions.  The
@@ -55,6 +74,45 @@ const (
 // so that orphans can be identified by which peer first relayed them.
 type Tag uint64
 
+// AcceptHook defines the signature of a custom policy callback which is
+// invoked for every transaction that has passed all of the standard mempool
+// checks, immediately before it is added to the pool.  The passed utxo view
+// contains the outputs referenced by the transaction inputs.  Returning a
+// non-nil error rejects the transaction.  A TxRuleError may be returned to
+// control the reject code sent to peers, otherwise RejectNonstandard is used.
+//
+// Hooks are invoked with the mempool lock held, so they MUST NOT call back
+// into the TxPool and must not block on anything that might do so.
+type AcceptHook func(tx *btcutil.Tx, utxoView *blockchain.UtxoViewpoint) error
+
+// SpendConflict describes an outpoint that is spent by both a rejected
+// transaction and a transaction in the pool.
+type SpendConflict struct {
+	// OutPoint is the output spent by both transactions.
+	OutPoint wire.OutPoint
+
+	// Spender is the transaction in the pool that spends the output.
+	Spender *btcutil.Tx
+}
+
+// DoubleSpend describes a transaction that was rejected because it spends
+// outputs already spent by transactions in the pool that can't be replaced.
+// Only transactions with valid signatures are reported, so it signals an
+// attempt by the owner of the coins to double spend an unconfirmed payment.
+type DoubleSpend struct {
+	// Tx is the rejected transaction.
+	Tx *btcutil.Tx
+
+	// Conflicts houses every outpoint the rejected transaction spends that
+	// is also spent by a transaction in the pool.
+	Conflicts []SpendConflict
+}
+
+// DoubleSpendCallback is used to notify subscribers about detected double
+// spends.  Callbacks are invoked with the mempool lock held, so they MUST NOT
+// call back into the TxPool and must not block on anything that might do so.
+type DoubleSpendCallback func(*DoubleSpend)
+
 // Config is a descriptor containing the memory pool configuration.
 type Config struct {
 	// Policy defines the various mempool configuration options related
@@ -144,10 +202,51 @@ type Policy struct {
 	// considered a non-zero fee.
 	MinRelayTxFee btcutil.Amount
 
+	// MaxTxFee is the maximum absolute fee a transaction submitted by the
+	// local node may pay unless it explicitly allows high fees.
+	// Transactions relayed by peers are not limited since a high fee does
+	// not make them any less valid.  A value of zero disables the limit.
+	MaxTxFee btcutil.Amount
+
 	// RejectReplacement, if true, rejects accepting replacement
 	// transactions using the Replace-By-Fee (RBF) signaling policy into
 	// the mempool.
 	RejectReplacement bool
+
+	// MaxScriptSigOps is the maximum number of signature operations a
+	// single input of a standard transaction may execute during script
+	// evaluation.  A value of zero disables the limit.
+	MaxScriptSigOps int
+
+	// MaxScriptStackDepth is the maximum combined depth of the data and
+	// alt stacks a single input of a standard transaction may reach during
+	// script evaluation.  A value of zero disables the limit.
+	MaxScriptStackDepth int
+
+	// MaxOrphansPerCycle is the maximum number of orphan transactions
+	// reconsidered for acceptance each time orphans are processed.  Any
+	// remaining orphans are deferred until the next time orphans are
+	// processed.  A value of zero disables the limit.
+	MaxOrphansPerCycle int
+
+	// MaxAncestorCount and MaxAncestorSize are the maximum number and
+	// combined virtual size of the transactions a transaction and its
+	// unconfirmed ancestors in the mempool may consist of.  A value of
+	// zero disables the respective limit.
+	MaxAncestorCount int
+	MaxAncestorSize  int64
+
+	// MaxDescendantCount and MaxDescendantSize are the maximum number and
+	// combined virtual size of the transactions a transaction in the
+	// mempool and its unconfirmed descendants may consist of, which are
+	// enforced for the ancestors of each newly accepted transaction.  A
+	// value of zero disables the respective limit.
+	//
+	// Together with the ancestor limits, they bound the cost of
+	// evaluating the dependency graph of the mempool, such as when
+	// replacing or mining packages of transactions.
+	MaxDescendantCount int
+	MaxDescendantSize  int64
 }
 
 // TxDesc is a descriptor containing a transaction in the mempool along with
@@ -160,12 +259,36 @@ type TxDesc struct {
 	StartingPriority float64
 }
 
+// OrphanTxDesc is a descriptor containing a transaction in the orphan pool
+// along with additional metadata.
+type OrphanTxDesc struct {
+	// Tx is the orphan transaction.
+	Tx *btcutil.Tx
+
+	// Tag is the identifier the orphan was tagged with when it was added,
+	// typically the peer that first relayed it.
+	Tag Tag
+
+	// Added is the time the orphan was added to the pool.
+	Added time.Time
+
+	// Expiration is the time after which the orphan is evicted from the
+	// pool.
+	Expiration time.Time
+
+	// MissingParents are the hashes of the transactions the orphan spends
+	// outputs of that are neither in the main pool nor unspent in the
+	// main chain.
+	MissingParents []*chainhash.Hash
+}
+
 // orphanTx is normal transaction that references an ancestor transaction
 // that is not yet available.  It also contains additional information related
 // to it such as an expiration time to help prevent caching the orphan forever.
 type orphanTx struct {
 	tx         *btcutil.Tx
 	tag        Tag
+	added      time.Time
 	expiration time.Time
 }
 
@@ -182,6 +305,9 @@ type TxPool struct {
 	orphans       map[chainhash.Hash]*orphanTx
 	orphansByPrev map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx
 	outpoints     map[wire.OutPoint]*btcutil.Tx
+	inputUtxos    map[wire.OutPoint]*blockchain.UtxoEntry
+	acceptHooks   []AcceptHook
+	dsCallbacks   []DoubleSpendCallback
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
 
@@ -190,6 +316,17 @@ type TxPool struct {
 	// the scan will only run when an orphan is added to the pool as opposed
 	// to on an unconditional timer.
 	nextExpireScan time.Time
+
+	// orphanQueue houses orphans that redeem outputs of accepted
+	// transactions and are waiting to be reconsidered for acceptance,
+	// while orphanQueued tracks the queue element of each orphan so they
+	// are only queued once.
+	orphanQueue  *list.List
+	orphanQueued map[chainhash.Hash]*list.Element
+
+	// unbroadcast houses the hashes of transactions in the pool that were
+	// submitted locally and have not been requested by any peers yet.
+	unbroadcast map[chainhash.Hash]struct{}
 }
 
 // Ensure the TxPool type implements the mining.TxSource interface.
@@ -330,10 +467,12 @@ func (mp *TxPool) addOrphan(tx *btcutil.Tx, tag Tag) {
 	// orphan if space is still needed.
 	mp.limitNumOrphans()
 
+	now := time.Now()
 	mp.orphans[*tx.Hash()] = &orphanTx{
 		tx:         tx,
 		tag:        tag,
-		expiration: time.Now().Add(orphanTTL),
+		added:      now,
+		expiration: now.Add(orphanTTL),
 	}
 	for _, txIn := range tx.MsgTx().TxIn {
 		if _, exists := mp.orphansByPrev[txIn.PreviousOutPoint]; !exists {
@@ -489,8 +628,10 @@ func (mp *TxPool) removeTransaction(tx *btcutil.Tx, removeRedeemers bool) {
 		// Mark the referenced outpoints as unspent by the pool.
 		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
+			delete(mp.inputUtxos, txIn.PreviousOutPoint)
 		}
 		delete(mp.pool, *txHash)
+		delete(mp.unbroadcast, *txHash)
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 	}
 }
@@ -542,7 +683,7 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil
 			Added:    time.Now(),
 			Height:   height,
 			Fee:      fee,
-			FeePerKB: fee * 1000 / GetTxVirtualSize(tx),
+			FeePerKB: CalcFeePerKB(tx, fee),
 		},
 		StartingPriority: mining.CalcPriority(tx.MsgTx(), utxoView, height),
 	}
@@ -551,6 +692,25 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil
 	for _, txIn := range tx.MsgTx().TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+
+	// Cache the outputs from the main chain the transaction spends so they
+	// don't need to be looked up again for as long as it is in the pool.
+	// Outputs of the transaction are no longer in the main chain in case
+	// it is added back to the pool after a block that contained it was
+	// disconnected, so remove any stale entries for them.
+	for _, txIn := range tx.MsgTx().TxIn {
+		entry := utxoView.LookupEntry(txIn.PreviousOutPoint)
+		if entry != nil && !entry.IsSpent() &&
+			entry.BlockHeight() != mining.UnminedHeight {
+
+			mp.inputUtxos[txIn.PreviousOutPoint] = entry.Clone()
+		}
+	}
+	prevOut := wire.OutPoint{Hash: *tx.Hash()}
+	for txOutIdx := range tx.MsgTx().TxOut {
+		prevOut.Index = uint32(txOutIdx)
+		delete(mp.inputUtxos, prevOut)
+	}
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 	// Add unconfirmed address index entries associated with the transaction
@@ -765,6 +925,75 @@ func (mp *TxPool) txConflicts(tx *btcutil.Tx) map[chainhash.Hash]*btcutil.Tx {
 	return conflicts
 }
 
+// checkPackageLimits ensures accepting the passed transaction with the passed
+// virtual size does not result in a chain of unconfirmed transactions that
+// exceeds the ancestor and descendant limits of the policy.  Neither the
+// transaction and its ancestors nor any of its ancestors and their
+// descendants may exceed the limits.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkPackageLimits(tx *btcutil.Tx, txSize int64) error {
+	policy := &mp.cfg.Policy
+	ancestors := mp.txAncestors(tx, nil)
+	if policy.MaxAncestorCount > 0 &&
+		len(ancestors)+1 > policy.MaxAncestorCount {
+
+		str := fmt.Sprintf("transaction %v has too many unconfirmed "+
+			"ancestors: %d ancestors exceed the limit of %d "+
+			"including the transaction", tx.Hash(), len(ancestors),
+			policy.MaxAncestorCount)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+	if policy.MaxAncestorSize > 0 {
+		ancestorsSize := txSize
+		for hash := range ancestors {
+			ancestorsSize += GetTxVirtualSize(mp.pool[hash].Tx)
+		}
+		if ancestorsSize > policy.MaxAncestorSize {
+			str := fmt.Sprintf("transaction %v and its unconfirmed "+
+				"ancestors have a size of %d which exceeds the "+
+				"limit of %d", tx.Hash(), ancestorsSize,
+				policy.MaxAncestorSize)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+	}
+
+	if policy.MaxDescendantCount <= 0 && policy.MaxDescendantSize <= 0 {
+		return nil
+	}
+	cache := make(map[chainhash.Hash]map[chainhash.Hash]*btcutil.Tx)
+	for hash, ancestor := range ancestors {
+		// The transaction becomes a descendant of each of its ancestors
+		// in addition to their existing descendants.
+		descendants := mp.txDescendants(ancestor, cache)
+		if policy.MaxDescendantCount > 0 &&
+			len(descendants)+2 > policy.MaxDescendantCount {
+
+			str := fmt.Sprintf("transaction %v would exceed the "+
+				"limit of %d transactions for unconfirmed "+
+				"transaction %v and its descendants", tx.Hash(),
+				policy.MaxDescendantCount, hash)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+		if policy.MaxDescendantSize > 0 {
+			descendantsSize := GetTxVirtualSize(ancestor) + txSize
+			for descendantHash := range descendants {
+				descendantsSize += GetTxVirtualSize(
+					mp.pool[descendantHash].Tx)
+			}
+			if descendantsSize > policy.MaxDescendantSize {
+				str := fmt.Sprintf("transaction %v would exceed "+
+					"the size limit of %d for unconfirmed "+
+					"transaction %v and its descendants",
+					tx.Hash(), policy.MaxDescendantSize, hash)
+				return txRuleError(wire.RejectNonstandard, str)
+			}
+		}
+	}
+
+	return nil
+}
+
 // CheckSpend checks whether the passed outpoint is already spent by a
 // transaction in the mempool. If that's the case the spending transaction will
 // be returned, if not nil will be returned.
@@ -776,16 +1005,47 @@ func (mp *TxPool) CheckSpend(op wire.OutPoint) *btcutil.Tx {
 	return txR
 }
 
+// cachedInputUtxos returns a view containing the cached outputs from the main
+// chain referenced by the inputs of the passed transaction in the pool.  The
+// returned bool is false when the output referenced by any input that does not
+// spend an output of another transaction in the pool is not cached, in which
+// case the view must be fetched from the main chain instead.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) cachedInputUtxos(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, bool) {
+	utxoView := blockchain.NewUtxoViewpoint()
+	for _, txIn := range tx.MsgTx().TxIn {
+		prevOut := txIn.PreviousOutPoint
+		if entry, ok := mp.inputUtxos[prevOut]; ok {
+			utxoView.Entries()[prevOut] = entry.Clone()
+			continue
+		}
+		if _, ok := mp.pool[prevOut.Hash]; !ok {
+			return nil, false
+		}
+	}
+	return utxoView, true
+}
+
 // fetchInputUtxos loads utxo details about the input transactions referenced by
 // the passed transaction.  First, it loads the details form the viewpoint of
 // the main chain, then it adjusts them based upon the contents of the
-// transaction pool.
+// transaction pool.  The details from the main chain are taken from the cache
+// of the outputs spent by transactions in the pool when the passed transaction
+// is in the pool.
 //
 // This function MUST be called with the mempool lock held (for reads).
 func (mp *TxPool) fetchInputUtxos(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
-	utxoView, err := mp.cfg.FetchUtxoView(tx)
-	if err != nil {
-		return nil, err
+	var utxoView *blockchain.UtxoViewpoint
+	if _, ok := mp.pool[*tx.Hash()]; ok {
+		utxoView, _ = mp.cachedInputUtxos(tx)
+	}
+	if utxoView == nil {
+		var err error
+		utxoView, err = mp.cfg.FetchUtxoView(tx)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Attempt to populate any missing inputs from the transaction pool.
@@ -807,6 +1067,37 @@ func (mp *TxPool) fetchInputUtxos(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, er
 	return utxoView, nil
 }
 
+// FetchInputUtxos returns a view containing the unspent outputs from the main
+// chain referenced by the inputs of the passed transaction in the pool.  Outputs
+// of other transactions in the pool are not included.  The outputs are taken
+// from a cache populated when transactions are added to the pool when
+// possible and looked up in the main chain otherwise.
+//
+// This is part of the mining.TxSource interface implementation and is safe for
+// concurrent access as required by the interface contract.
+func (mp *TxPool) FetchInputUtxos(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+	mp.mtx.RLock()
+	utxoView, ok := mp.cachedInputUtxos(tx)
+	mp.mtx.RUnlock()
+	if ok {
+		return utxoView, nil
+	}
+	return mp.cfg.FetchUtxoView(tx)
+}
+
+// ClearInputUtxoCache removes all entries from the cache of outputs from the
+// main chain spent by transactions in the pool.  It must be called when a block
+// is disconnected from the main chain since outputs created by the block are
+// no longer in the main chain and those of transactions that are mined again
+// will be at different heights.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ClearInputUtxoCache() {
+	mp.mtx.Lock()
+	mp.inputUtxos = make(map[wire.OutPoint]*blockchain.UtxoEntry)
+	mp.mtx.Unlock()
+}
+
 // FetchTransaction returns the requested transaction from the transaction pool.
 // This only fetches from the main transaction pool and does not include
 // orphans.
@@ -853,7 +1144,8 @@ func (mp *TxPool) validateReplacement(tx *btcutil.Tx,
 		}
 		str := fmt.Sprintf("replacement transaction %v spends parent "+
 			"transaction %v", tx.Hash(), ancestorHash)
-		return nil, txRuleError(wire.RejectInvalid, str)
+		return nil, txRuleErrorKind(ErrKindPolicy, wire.RejectInvalid,
+			str)
 	}
 
 	// The replacement should have a higher fee rate than each of the
@@ -913,18 +1205,127 @@ func (mp *TxPool) validateReplacement(tx *btcutil.Tx,
 		str := fmt.Sprintf("replacement transaction spends new "+
 			"unconfirmed input %v not found in conflicting "+
 			"transactions", txIn.PreviousOutPoint)
-		return nil, txRuleError(wire.RejectInvalid, str)
+		return nil, txRuleErrorKind(ErrKindPolicy, wire.RejectInvalid,
+			str)
 	}
 
 	return conflicts, nil
 }
 
+// RegisterAcceptHook registers the passed callback to be invoked for each
+// transaction that passes the standard acceptance checks.  Hooks are invoked
+// in the order they were registered and the first one to return an error
+// causes the transaction to be rejected.  See AcceptHook for the restrictions
+// that apply to the callback.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) RegisterAcceptHook(hook AcceptHook) {
+	mp.mtx.Lock()
+	mp.acceptHooks = append(mp.acceptHooks, hook)
+	mp.mtx.Unlock()
+}
+
+// SubscribeDoubleSpends registers the passed callback to be invoked whenever a
+// transaction is rejected because it conflicts with a transaction in the pool
+// that does not signal replacement, or when replacement is disabled.  See
+// DoubleSpendCallback for the restrictions that apply to the callback.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) SubscribeDoubleSpends(callback DoubleSpendCallback) {
+	mp.mtx.Lock()
+	mp.dsCallbacks = append(mp.dsCallbacks, callback)
+	mp.mtx.Unlock()
+}
+
+// notifyDoubleSpend notifies all double spend subscribers about the passed
+// transaction which was rejected for conflicting with transactions in the
+// pool.  The transaction scripts are validated first so that a third party
+// can't trigger false alarms by relaying conflicting transactions that it is
+// unable to sign.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) notifyDoubleSpend(tx *btcutil.Tx) {
+	if len(mp.dsCallbacks) == 0 {
+		return
+	}
+
+	var conflicts []SpendConflict
+	for _, txIn := range tx.MsgTx().TxIn {
+		spender, ok := mp.outpoints[txIn.PreviousOutPoint]
+		if !ok {
+			continue
+		}
+		conflicts = append(conflicts, SpendConflict{
+			OutPoint: txIn.PreviousOutPoint,
+			Spender:  spender,
+		})
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+
+	if err := blockchain.CheckTransactionSanity(tx); err != nil {
+		return
+	}
+	utxoView, err := mp.fetchInputUtxos(tx)
+	if err != nil {
+		return
+	}
+	for _, txIn := range tx.MsgTx().TxIn {
+		entry := utxoView.LookupEntry(txIn.PreviousOutPoint)
+		if entry == nil || entry.IsSpent() {
+			return
+		}
+	}
+	err = blockchain.ValidateTransactionScripts(tx, utxoView,
+		txscript.StandardVerifyFlags, mp.cfg.SigCache,
+		mp.cfg.HashCache)
+	if err != nil {
+		log.Debugf("Not reporting conflicting transaction %v with "+
+			"invalid scripts: %v", tx.Hash(), err)
+		return
+	}
+
+	log.Infof("Detected double spend of unconfirmed outputs by "+
+		"transaction %v", tx.Hash())
+	ds := &DoubleSpend{Tx: tx, Conflicts: conflicts}
+	for _, callback := range mp.dsCallbacks {
+		callback(ds)
+	}
+}
+
+// runAcceptHooks invokes all registered accept hooks for the passed
+// transaction and converts the first rejection into a RuleError.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) runAcceptHooks(tx *btcutil.Tx, utxoView *blockchain.UtxoViewpoint) error {
+	for _, hook := range mp.acceptHooks {
+		err := hook(tx, utxoView)
+		if err == nil {
+			continue
+		}
+
+		rejectCode := wire.RejectNonstandard
+		if terr, ok := err.(TxRuleError); ok {
+			rejectCode = terr.RejectCode
+		}
+		str := fmt.Sprintf("transaction %v rejected by policy hook: %v",
+			tx.Hash(), err)
+		return txRuleErrorKind(ErrKindPolicy, rejectCode, str)
+	}
+
+	return nil
+}
+
 // maybeAcceptTransaction is the internal function which implements the public
 // MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
-// more details.
+// more details.  The allowHighFees flag exempts the transaction from the
+// maximum fee of the policy, which only applies to local transactions.  A
+// non-zero maxFeeRate, in satoshi per 1000 bytes, limits the fee rate of the
+// transaction instead of the maximum fee of the policy.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejectDupOrphans bool) ([]*chainhash.Hash, *TxDesc, error) {
+func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejectDupOrphans, allowHighFees bool, maxFeeRate btcutil.Amount) ([]*chainhash.Hash, *TxDesc, error) {
 	txHash := tx.Hash()
 
 	// If a transaction has witness data, and segwit isn't active yet, If
@@ -986,6 +1387,20 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 
 	medianTimePast := mp.cfg.MedianTimePast()
 
+	// Don't accept transactions that can't be included in the next block
+	// since their lock time has not been reached yet.  Unlike the rest of
+	// the standardness checks, this applies even when non-standard
+	// transactions are accepted so the pool never contains transactions
+	// that can't be mined.  They are accepted once they are final.
+	if !blockchain.IsFinalizedTransaction(tx, nextBlockHeight,
+		medianTimePast) {
+
+		str := fmt.Sprintf("transaction %v is not finalized at height "+
+			"%d and median time %v", txHash, nextBlockHeight,
+			medianTimePast)
+		return nil, nil, txRuleError(wire.RejectNonstandard, str)
+	}
+
 	// Don't allow non-standard transactions if the network parameters
 	// forbid their acceptance.
 	if !mp.cfg.Policy.AcceptNonStd {
@@ -1017,6 +1432,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 	// spend data and prevents double spends.
 	isReplacement, err := mp.checkPoolDoubleSpend(tx)
 	if err != nil {
+		mp.notifyDoubleSpend(tx)
 		return nil, nil, err
 	}
 
@@ -1155,6 +1571,28 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
 	}
 
+	// Don't allow transactions paying an absurdly high fee unless high
+	// fees are explicitly allowed since they are most likely a mistake.
+	// The fee is limited by the passed fee rate when one is provided and
+	// by the maximum fee of the policy otherwise.
+	maxFee := int64(mp.cfg.Policy.MaxTxFee)
+	switch {
+	case allowHighFees:
+	case maxFeeRate > 0:
+		txFeeRate := txFee * 1000 / serializedSize
+		if txFeeRate > int64(maxFeeRate) {
+			str := fmt.Sprintf("transaction %v has a fee rate of %d "+
+				"which is over the maximum allowed rate of %d",
+				txHash, txFeeRate, maxFeeRate)
+			return nil, nil, txRuleError(wire.RejectNonstandard, str)
+		}
+	case maxFee > 0 && txFee > maxFee:
+		str := fmt.Sprintf("transaction %v has %d fees which is over "+
+			"the maximum allowed amount of %d", txHash, txFee,
+			maxFee)
+		return nil, nil, txRuleError(wire.RejectNonstandard, str)
+	}
+
 	// Require that free transactions have sufficient priority to be mined
 	// in the next block.  Transactions which are being added back to the
 	// memory pool from blocks that have been disconnected during a reorg
@@ -1194,6 +1632,12 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 			mp.cfg.Policy.FreeTxRelayLimit*10*1000)
 	}
 
+	// Don't allow transactions that would create chains of unconfirmed
+	// transactions exceeding the ancestor and descendant limits.
+	if err := mp.checkPackageLimits(tx, serializedSize); err != nil {
+		return nil, nil, err
+	}
+
 	// If the transaction has any conflicts and we've made it this far, then
 	// we're processing a potential replacement.
 	var conflicts map[chainhash.Hash]*btcutil.Tx
@@ -1205,10 +1649,22 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 	}
 
 	// Verify crypto signatures for each input and reject the transaction if
-	// any don't verify.
-	err = blockchain.ValidateTransactionScripts(tx, utxoView,
-		txscript.StandardVerifyFlags, mp.cfg.SigCache,
-		mp.cfg.HashCache)
+	// any don't verify.  Don't allow transactions with abnormally complex
+	// scripts either if the network parameters forbid their acceptance.  The
+	// scripts are executed in metered mode in that case so the complexity
+	// limits are enforced by the same run that verifies them.
+	policy := &mp.cfg.Policy
+	if !policy.AcceptNonStd && (policy.MaxScriptSigOps != 0 ||
+		policy.MaxScriptStackDepth != 0) {
+
+		err = checkInputsComplexity(tx, utxoView,
+			policy.MaxScriptSigOps, policy.MaxScriptStackDepth,
+			mp.cfg.SigCache, mp.cfg.HashCache)
+	} else {
+		err = blockchain.ValidateTransactionScripts(tx, utxoView,
+			txscript.StandardVerifyFlags, mp.cfg.SigCache,
+			mp.cfg.HashCache)
+	}
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
 			return nil, nil, chainRuleError(cerr)
@@ -1216,6 +1672,12 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		return nil, nil, err
 	}
 
+	// Give any registered custom policy hooks the final say on whether
+	// or not the otherwise valid transaction is accepted.
+	if err := mp.runAcceptHooks(tx, utxoView); err != nil {
+		return nil, nil, err
+	}
+
 	// Now that we've deemed the transaction as valid, we can add it to the
 	// mempool. If it ended up replacing any transactions, we'll remove them
 	// first.
@@ -1252,12 +1714,49 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 func (mp *TxPool) MaybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit bool) ([]*chainhash.Hash, *TxDesc, error) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
-	hashes, txD, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit, true)
+	hashes, txD, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit, true,
+		true, 0)
 	mp.mtx.Unlock()
 
 	return hashes, txD, err
 }
 
+// queueOrphanRedeemers adds all orphans which redeem an output of the passed
+// transaction, which is now available, to the queue of orphans to reconsider
+// for acceptance.  When front is set, they are queued ahead of all other
+// orphans, including those that were already queued, so they are reconsidered
+// first.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) queueOrphanRedeemers(tx *btcutil.Tx, front bool) {
+	prevOut := wire.OutPoint{Hash: *tx.Hash()}
+	for txOutIdx := range tx.MsgTx().TxOut {
+		// Look up all orphans that redeem the output that is now
+		// available.  This will typically only be one, but it could be
+		// multiple if the orphan pool contains double spends.  While it
+		// may seem odd that the orphan pool would allow this since there
+		// can only possibly ultimately be a single redeemer, it's
+		// important to track it this way to prevent malicious actors
+		// from being able to purposely constructing orphans that would
+		// otherwise make outputs unspendable.
+		prevOut.Index = uint32(txOutIdx)
+		for orphanHash, orphan := range mp.orphansByPrev[prevOut] {
+			if elem, queued := mp.orphanQueued[orphanHash]; queued {
+				if front {
+					mp.orphanQueue.MoveToFront(elem)
+				}
+				continue
+			}
+			if front {
+				mp.orphanQueued[orphanHash] =
+					mp.orphanQueue.PushFront(orphan)
+				continue
+			}
+			mp.orphanQueued[orphanHash] = mp.orphanQueue.PushBack(orphan)
+		}
+	}
+}
+
 // processOrphans is the internal function which implements the public
 // ProcessOrphans.  See the comment for ProcessOrphans for more details.
 //
@@ -1265,77 +1764,66 @@ func (mp *TxPool) MaybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit bool)
 func (mp *TxPool) processOrphans(acceptedTx *btcutil.Tx) []*TxDesc {
 	var acceptedTxns []*TxDesc
 
-	// Start with processing at least the passed transaction.
-	processList := list.New()
-	processList.PushBack(acceptedTx)
-	for processList.Len() > 0 {
-		// Pop the transaction to process from the front of the list.
-		firstElement := processList.Remove(processList.Front())
-		processItem := firstElement.(*btcutil.Tx)
-
-		prevOut := wire.OutPoint{Hash: *processItem.Hash()}
-		for txOutIdx := range processItem.MsgTx().TxOut {
-			// Look up all orphans that redeem the output that is
-			// now available.  This will typically only be one, but
-			// it could be multiple if the orphan pool contains
-			// double spends.  While it may seem odd that the orphan
-			// pool would allow this since there can only possibly
-			// ultimately be a single redeemer, it's important to
-			// track it this way to prevent malicious actors from
-			// being able to purposely constructing orphans that
-			// would otherwise make outputs unspendable.
-			//
-			// Skip to the next available output if there are none.
-			prevOut.Index = uint32(txOutIdx)
-			orphans, exists := mp.orphansByPrev[prevOut]
-			if !exists {
-				continue
-			}
+	// Recursively remove any orphans that also redeem any outputs redeemed
+	// by the accepted transaction since those are now definitive double
+	// spends.
+	mp.removeOrphanDoubleSpends(acceptedTx)
 
-			// Potentially accept an orphan into the tx pool.
-			for _, tx := range orphans {
-				missing, txD, err := mp.maybeAcceptTransaction(
-					tx, true, true, false)
-				if err != nil {
-					// The orphan is now invalid, so there
-					// is no way any other orphans which
-					// redeem any of its outputs can be
-					// accepted.  Remove them.
-					mp.removeOrphan(tx, true)
-					break
-				}
+	// Queue the orphans which redeem the outputs of the passed transaction
+	// ahead of any orphans that were deferred by previous calls so they are
+	// rechecked now that their parent is available.
+	mp.queueOrphanRedeemers(acceptedTx, true)
+
+	limit := mp.cfg.Policy.MaxOrphansPerCycle
+	var numProcessed int
+	for mp.orphanQueue.Len() > 0 {
+		// Defer the remaining orphans until the next call once the
+		// limit is reached.
+		if limit > 0 && numProcessed >= limit {
+			log.Debugf("Deferring %d orphan %s until the next cycle",
+				mp.orphanQueue.Len(), pickNoun(mp.orphanQueue.Len(),
+					"transaction", "transactions"))
+			break
+		}
 
-				// Transaction is still an orphan.  Try the next
-				// orphan which redeems this output.
-				if len(missing) > 0 {
-					continue
-				}
+		// Pop the orphan to process from the front of the queue and
+		// skip it when it was removed from the orphan pool since it was
+		// queued.
+		tx := mp.orphanQueue.Remove(mp.orphanQueue.Front()).(*btcutil.Tx)
+		delete(mp.orphanQueued, *tx.Hash())
+		if _, exists := mp.orphans[*tx.Hash()]; !exists {
+			continue
+		}
+		numProcessed++
 
-				// Transaction was accepted into the main pool.
-				//
-				// Add it to the list of accepted transactions
-				// that are no longer orphans, remove it from
-				// the orphan pool, and add it to the list of
-				// transactions to process so any orphans that
-				// depend on it are handled too.
-				acceptedTxns = append(acceptedTxns, txD)
-				mp.removeOrphan(tx, false)
-				processList.PushBack(tx)
-
-				// Only one transaction for this outpoint can be
-				// accepted, so the rest are now double spends
-				// and are removed later.
-				break
-			}
+		// Potentially accept the orphan into the tx pool.
+		missing, txD, err := mp.maybeAcceptTransaction(tx, true, true,
+			false, true, 0)
+		if err != nil {
+			// The orphan is now invalid, so there is no way any
+			// other orphans which redeem any of its outputs can be
+			// accepted.  Remove them.
+			mp.removeOrphan(tx, true)
+			continue
 		}
-	}
 
-	// Recursively remove any orphans that also redeem any outputs redeemed
-	// by the accepted transactions since those are now definitive double
-	// spends.
-	mp.removeOrphanDoubleSpends(acceptedTx)
-	for _, txD := range acceptedTxns {
-		mp.removeOrphanDoubleSpends(txD.Tx)
+		// Transaction is still an orphan.  It will be reconsidered once
+		// the rest of its parents are available.
+		if len(missing) > 0 {
+			continue
+		}
+
+		// Transaction was accepted into the main pool.
+		//
+		// Add it to the list of accepted transactions that are no
+		// longer orphans, remove it from the orphan pool along with any
+		// orphans that redeem the same outputs since only one of them
+		// can be accepted, and queue any orphans that depend on it so
+		// they are handled too.
+		acceptedTxns = append(acceptedTxns, txD)
+		mp.removeOrphan(tx, false)
+		mp.removeOrphanDoubleSpends(tx)
+		mp.queueOrphanRedeemers(tx, false)
 	}
 
 	return acceptedTxns
@@ -1347,6 +1835,13 @@ func (mp *TxPool) processOrphans(acceptedTx *btcutil.Tx) []*TxDesc {
 // newly accepted transactions (to detect further orphans which may no longer be
 // orphans) until there are no more.
 //
+// At most MaxOrphansPerCycle orphans, as configured by the policy, are
+// reconsidered per call.  The orphans which redeem outputs of the passed
+// transaction are reconsidered first, even when they were deferred by an
+// earlier call, so they are rechecked as soon as a parent is accepted or
+// connected.  The remaining orphans are deferred and processed on the next
+// call to ProcessOrphans or when ProcessTransaction accepts a transaction.
+//
 // It returns a slice of transactions added to the mempool.  A nil slice means
 // no transactions were moved from the orphan pool to the mempool.
 //
@@ -1371,6 +1866,28 @@ func (mp *TxPool) ProcessOrphans(acceptedTx *btcutil.Tx) []*TxDesc {
 //
 // This function is safe for concurrent access.
 func (mp *TxPool) ProcessTransaction(tx *btcutil.Tx, allowOrphan, rateLimit bool, tag Tag) ([]*TxDesc, error) {
+	return mp.processTransaction(tx, allowOrphan, rateLimit, true, 0, tag)
+}
+
+// ProcessLocalTransaction is the same as ProcessTransaction for transactions
+// submitted by the local node, such as via RPC, except orphans are rejected and
+// the transaction is also rejected when it pays too high a fee unless
+// allowHighFees is set.  The fee is too high when its rate, in satoshi per 1000
+// bytes, exceeds a non-zero maxFeeRate or otherwise when it exceeds the maximum
+// fee defined by the policy.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ProcessLocalTransaction(tx *btcutil.Tx, allowHighFees bool, maxFeeRate btcutil.Amount) ([]*TxDesc, error) {
+	return mp.processTransaction(tx, false, false, allowHighFees,
+		maxFeeRate, 0)
+}
+
+// processTransaction is the internal function which implements the public
+// ProcessTransaction and ProcessLocalTransaction.  See their comments for
+// details.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) processTransaction(tx *btcutil.Tx, allowOrphan, rateLimit, allowHighFees bool, maxFeeRate btcutil.Amount, tag Tag) ([]*TxDesc, error) {
 	log.Tracef("Processing transaction %v", tx.Hash())
 
 	// Protect concurrent access.
@@ -1379,7 +1896,7 @@ func (mp *TxPool) ProcessTransaction(tx *btcutil.Tx, allowOrphan, rateLimit bool
 
 	// Potentially accept the transaction to the memory pool.
 	missingParents, txD, err := mp.maybeAcceptTransaction(tx, true, rateLimit,
-		true)
+		true, allowHighFees, maxFeeRate)
 	if err != nil {
 		return nil, err
 	}
@@ -1415,7 +1932,8 @@ func (mp *TxPool) ProcessTransaction(tx *btcutil.Tx, allowOrphan, rateLimit bool
 		str := fmt.Sprintf("orphan transaction %v references "+
 			"outputs of unknown or fully-spent "+
 			"transaction %v", tx.Hash(), missingParents[0])
-		return nil, txRuleError(wire.RejectDuplicate, str)
+		return nil, txRuleErrorKind(ErrKindMissingInputs,
+			wire.RejectDuplicate, str)
 	}
 
 	// Potentially add the orphan transaction to the orphan pool.
@@ -1470,6 +1988,106 @@ func (mp *TxPool) TxDescs() []*TxDesc {
 	return descs
 }
 
+// OrphanTxDescs returns a slice of descriptors for all of the transactions in
+// the orphan pool ordered by the time they were added.  The missing parents of
+// each orphan are determined as of the time of the call.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) OrphanTxDescs() []*OrphanTxDesc {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	descs := make([]*OrphanTxDesc, 0, len(mp.orphans))
+	for _, otx := range mp.orphans {
+		desc := &OrphanTxDesc{
+			Tx:         otx.tx,
+			Tag:        otx.tag,
+			Added:      otx.added,
+			Expiration: otx.expiration,
+		}
+
+		// Consider all parents missing when the inputs can't be
+		// looked up for some reason.
+		utxoView, err := mp.fetchInputUtxos(otx.tx)
+		seen := make(map[chainhash.Hash]struct{})
+		for _, txIn := range otx.tx.MsgTx().TxIn {
+			prevOut := txIn.PreviousOutPoint
+			if err == nil {
+				entry := utxoView.LookupEntry(prevOut)
+				if entry != nil && !entry.IsSpent() {
+					continue
+				}
+			}
+			if _, ok := seen[prevOut.Hash]; ok {
+				continue
+			}
+			seen[prevOut.Hash] = struct{}{}
+			hash := prevOut.Hash
+			desc.MissingParents = append(desc.MissingParents, &hash)
+		}
+
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].Added.Before(descs[j].Added)
+	})
+
+	return descs
+}
+
+// AddUnbroadcastTx marks the transaction with the passed hash, which was
+// submitted locally, as not yet broadcast to the network.  It remains marked
+// until RemoveUnbroadcastTx is called, which should be done once a peer has
+// requested it, or the transaction leaves the pool.  Nothing is done when the
+// transaction is not in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) AddUnbroadcastTx(hash *chainhash.Hash) {
+	mp.mtx.Lock()
+	if _, exists := mp.pool[*hash]; exists {
+		mp.unbroadcast[*hash] = struct{}{}
+	}
+	mp.mtx.Unlock()
+}
+
+// RemoveUnbroadcastTx removes the transaction with the passed hash from the
+// set of transactions that have not yet been broadcast to the network.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) RemoveUnbroadcastTx(hash *chainhash.Hash) {
+	mp.mtx.Lock()
+	delete(mp.unbroadcast, *hash)
+	mp.mtx.Unlock()
+}
+
+// UnbroadcastTxDescs returns a slice of descriptors for the transactions in the
+// pool that were submitted locally and have not yet been requested by any
+// peers.  The descriptors are to be treated as read only.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) UnbroadcastTxDescs() []*TxDesc {
+	mp.mtx.RLock()
+	descs := make([]*TxDesc, 0, len(mp.unbroadcast))
+	for hash := range mp.unbroadcast {
+		descs = append(descs, mp.pool[hash])
+	}
+	mp.mtx.RUnlock()
+
+	return descs
+}
+
+// UnbroadcastCount returns the number of transactions in the pool that were
+// submitted locally and have not yet been requested by any peers.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) UnbroadcastCount() int {
+	mp.mtx.RLock()
+	count := len(mp.unbroadcast)
+	mp.mtx.RUnlock()
+
+	return count
+}
+
 // MiningDescs returns a slice of mining descriptors for all the transactions
 // in the pool.
 //
@@ -1555,5 +2173,9 @@ func New(cfg *Config) *TxPool {
 		orphansByPrev:  make(map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx),
 		nextExpireScan: time.Now().Add(orphanExpireScanInterval),
 		outpoints:      make(map[wire.OutPoint]*btcutil.Tx),
+		inputUtxos:     make(map[wire.OutPoint]*blockchain.UtxoEntry),
+		orphanQueue:    list.New(),
+		orphanQueued:   make(map[chainhash.Hash]*list.Element),
+		unbroadcast:    make(map[chainhash.Hash]struct{}),
 	}
 }
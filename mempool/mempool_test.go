@@ -5,10 +5,13 @@
 package mempool
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -25,6 +28,10 @@ import (
 // a current faked chain height to the pool callbacks.  This, in turn, allows
 // transactions to appear as though they are spending completely valid utxos.
 type fakeChain struct {
+	// numFetches is the number of times the utxos were fetched.  It must
+	// only be used atomically.
+	numFetches int64
+
 	sync.RWMutex
 	utxos          *blockchain.UtxoViewpoint
 	currentHeight  int32
@@ -38,6 +45,8 @@ type fakeChain struct {
 //
 // This function is safe for concurrent access however the returned view is NOT.
 func (s *fakeChain) FetchUtxoView(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+	atomic.AddInt64(&s.numFetches, 1)
+
 	s.RLock()
 	defer s.RUnlock()
 
@@ -324,6 +333,8 @@ func newPoolHarness(chainParams *chaincfg.Params) (*poolHarness, []spendableOutp
 				MaxSigOpCostPerTx:    blockchain.MaxBlockSigOpsCost / 4,
 				MinRelayTxFee:        1000, // 1 Satoshi per byte
 				MaxTxVersion:         1,
+				MaxScriptSigOps:      DefaultMaxScriptSigOps,
+				MaxScriptStackDepth:  DefaultMaxScriptStackDepth,
 			},
 			ChainParams:      chainParams,
 			FetchUtxoView:    chain.FetchUtxoView,
@@ -560,7 +571,7 @@ func TestOrphanReject(t *testing.T) {
 
 		// Ensure no transactions were reported as accepted.
 		if len(acceptedTxns) != 0 {
-			t.Fatal("ProcessTransaction: reported %d accepted "+
+			t.Fatalf("ProcessTransaction: reported %d accepted "+
 				"transactions from failed orphan attempt",
 				len(acceptedTxns))
 		}
@@ -862,6 +873,287 @@ func TestMultiInputOrphanDoubleSpend(t *testing.T) {
 	testPoolMembership(tc, doubleSpendTx, false, false)
 }
 
+// TestOrphanTxDescs ensures the descriptors returned for the orphan pool
+// report the tag each orphan was added with along with the parents it is
+// still missing.
+func TestOrphanTxDescs(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	// Add a transaction with two outputs to the main pool, then create a
+	// chain of transactions rooted with one of them along with a
+	// transaction that spends both the root of that chain and the other
+	// output which is available in the main pool.
+	parent, err := harness.CreateSignedTx(outputs, 2, 0, false)
+	if err != nil {
+		t.Fatalf("unable to create signed tx: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(parent, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid tx %v", err)
+	}
+	chainedTxns, err := harness.CreateTxChain(
+		txOutToSpendableOut(parent, 1), 3)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	mixedTx, err := harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(chainedTxns[0], 0),
+		txOutToSpendableOut(parent, 0),
+	}, 1, 0, false)
+	if err != nil {
+		t.Fatalf("unable to create signed tx: %v", err)
+	}
+
+	// Add everything except the root of the chain as orphans, each with a
+	// distinct tag.
+	orphans := []*btcutil.Tx{chainedTxns[1], chainedTxns[2], mixedTx}
+	for i, tx := range orphans {
+		_, err := harness.txPool.ProcessTransaction(tx, true, false,
+			Tag(i+1))
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept valid "+
+				"orphan %v", err)
+		}
+		testPoolMembership(tc, tx, true, false)
+	}
+
+	// The second orphan in the chain is missing the first orphan since it
+	// is not in the main pool, while the mixed transaction is only missing
+	// the root of the chain.
+	wantMissing := map[chainhash.Hash]*chainhash.Hash{
+		*chainedTxns[1].Hash(): chainedTxns[0].Hash(),
+		*chainedTxns[2].Hash(): chainedTxns[1].Hash(),
+		*mixedTx.Hash():        chainedTxns[0].Hash(),
+	}
+	descs := harness.txPool.OrphanTxDescs()
+	if len(descs) != len(orphans) {
+		t.Fatalf("OrphanTxDescs: unexpected number of descriptors -- "+
+			"got %d, want %d", len(descs), len(orphans))
+	}
+	for i, desc := range descs {
+		if i > 0 && desc.Added.Before(descs[i-1].Added) {
+			t.Fatalf("OrphanTxDescs: descriptors are not ordered by " +
+				"time added")
+		}
+		if !desc.Expiration.After(desc.Added) {
+			t.Fatalf("OrphanTxDescs: expiration %v of orphan %v is "+
+				"not after time added %v", desc.Expiration,
+				desc.Tx.Hash(), desc.Added)
+		}
+
+		var wantTag Tag
+		for j, tx := range orphans {
+			if *tx.Hash() == *desc.Tx.Hash() {
+				wantTag = Tag(j + 1)
+			}
+		}
+		if desc.Tag != wantTag {
+			t.Fatalf("OrphanTxDescs: unexpected tag for orphan %v -- "+
+				"got %d, want %d", desc.Tx.Hash(), desc.Tag, wantTag)
+		}
+
+		want := wantMissing[*desc.Tx.Hash()]
+		if len(desc.MissingParents) != 1 ||
+			*desc.MissingParents[0] != *want {
+
+			t.Fatalf("OrphanTxDescs: unexpected missing parents for "+
+				"orphan %v -- got %v, want [%v]", desc.Tx.Hash(),
+				desc.MissingParents, want)
+		}
+	}
+
+	// Ensure the orphans are no longer reported once the root of the chain
+	// is accepted and they are moved to the main pool.
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[0], false, false,
+		0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid tx %v", err)
+	}
+	if descs := harness.txPool.OrphanTxDescs(); len(descs) != 0 {
+		t.Fatalf("OrphanTxDescs: unexpected number of descriptors -- "+
+			"got %d, want 0", len(descs))
+	}
+}
+
+// TestOrphanProcessingLimit ensures the number of orphans reconsidered each
+// time orphans are processed is bounded by the policy and that the remaining
+// orphans are processed by later calls.
+func TestOrphanProcessingLimit(t *testing.T) {
+	t.Parallel()
+
+	const numOrphans = 10
+	const perCycle = 4
+	harness, spendableOuts, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.Policy.MaxOrphanTxs = numOrphans
+	harness.txPool.cfg.Policy.MaxOrphansPerCycle = perCycle
+	tc := &testContext{t, harness}
+
+	// Create a parent transaction with an output for each orphan along
+	// with the orphans that each spend one of them.
+	parent, err := harness.CreateSignedTx(spendableOuts, numOrphans, 0,
+		false)
+	if err != nil {
+		t.Fatalf("unable to create parent transaction: %v", err)
+	}
+	orphans := make([]*btcutil.Tx, 0, numOrphans)
+	for i := uint32(0); i < numOrphans; i++ {
+		input := txOutToSpendableOut(parent, i)
+		orphan, err := harness.CreateSignedTx([]spendableOutput{input},
+			1, 0, false)
+		if err != nil {
+			t.Fatalf("unable to create orphan: %v", err)
+		}
+		acceptedTxns, err := harness.txPool.ProcessTransaction(orphan,
+			true, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept valid "+
+				"orphan %v", err)
+		}
+		if len(acceptedTxns) != 0 {
+			t.Fatalf("ProcessTransaction: reported %d accepted "+
+				"transactions from what should be an orphan",
+				len(acceptedTxns))
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	// Add the parent and ensure only the configured number of orphans are
+	// accepted along with it.
+	acceptedTxns, err := harness.txPool.ProcessTransaction(parent, false,
+		false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid parent %v",
+			err)
+	}
+	if len(acceptedTxns) != perCycle+1 {
+		t.Fatalf("ProcessTransaction: reported accepted transactions "+
+			"length does not match expected -- got %d, want %d",
+			len(acceptedTxns), perCycle+1)
+	}
+	numAccepted := perCycle
+
+	// Ensure the remaining orphans are processed in bounded batches by
+	// subsequent calls until none are left.
+	for numAccepted < numOrphans {
+		want := numOrphans - numAccepted
+		if want > perCycle {
+			want = perCycle
+		}
+		acceptedTxns := harness.txPool.ProcessOrphans(parent)
+		if len(acceptedTxns) != want {
+			t.Fatalf("ProcessOrphans: reported accepted transactions "+
+				"length does not match expected -- got %d, "+
+				"want %d", len(acceptedTxns), want)
+		}
+		numAccepted += len(acceptedTxns)
+	}
+	acceptedTxns = harness.txPool.ProcessOrphans(parent)
+	if len(acceptedTxns) != 0 {
+		t.Fatalf("ProcessOrphans: reported %d accepted transactions "+
+			"with no orphans left", len(acceptedTxns))
+	}
+	for _, orphan := range orphans {
+		testPoolMembership(tc, orphan, false, true)
+	}
+}
+
+// TestDeferredOrphanParentAccepted ensures orphans deferred by the per cycle
+// limit are rechecked ahead of other deferred orphans as soon as one of their
+// parents becomes available.
+func TestDeferredOrphanParentAccepted(t *testing.T) {
+	t.Parallel()
+
+	harness, spendableOuts, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.Policy.MaxOrphansPerCycle = 1
+	tc := &testContext{t, harness}
+
+	// Create two parents that both spend an output of an accepted root
+	// transaction.  The first one has an orphan for each of its three
+	// outputs while the second one has a single orphan.
+	root := tc.addSignedTx(spendableOuts, 2, 0, false, false)
+	parent1, err := harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(root, 0)}, 3, 0, false)
+	if err != nil {
+		t.Fatalf("unable to create parent transaction: %v", err)
+	}
+	parent2, err := harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(root, 1)}, 1, 0, false)
+	if err != nil {
+		t.Fatalf("unable to create parent transaction: %v", err)
+	}
+	var orphans []*btcutil.Tx
+	for _, out := range []spendableOutput{
+		txOutToSpendableOut(parent1, 0),
+		txOutToSpendableOut(parent1, 1),
+		txOutToSpendableOut(parent1, 2),
+		txOutToSpendableOut(parent2, 0),
+	} {
+		orphan, err := harness.CreateSignedTx([]spendableOutput{out},
+			1, 0, false)
+		if err != nil {
+			t.Fatalf("unable to create orphan: %v", err)
+		}
+		_, err = harness.txPool.ProcessTransaction(orphan, true, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept valid "+
+				"orphan %v", err)
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	// Accepting the first parent only accepts one of its orphans and
+	// defers the others.
+	acceptedTxns, err := harness.txPool.ProcessTransaction(parent1, false,
+		false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid parent %v",
+			err)
+	}
+	if len(acceptedTxns) != 2 {
+		t.Fatalf("ProcessTransaction: reported accepted transactions "+
+			"length does not match expected -- got %d, want 2",
+			len(acceptedTxns))
+	}
+
+	// Accepting the second parent must recheck its orphan ahead of the
+	// orphans deferred for the first parent.
+	acceptedTxns, err = harness.txPool.ProcessTransaction(parent2, false,
+		false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid parent %v",
+			err)
+	}
+	if len(acceptedTxns) != 2 || acceptedTxns[1].Tx != orphans[3] {
+		t.Fatalf("ProcessTransaction: orphan of the second parent was " +
+			"not rechecked when the parent was accepted")
+	}
+	testPoolMembership(tc, orphans[3], false, true)
+
+	// The orphans deferred for the first parent are rechecked once it is
+	// processed again, such as when it is connected in a block.
+	for i := 0; i < 2; i++ {
+		if len(harness.txPool.ProcessOrphans(parent1)) != 1 {
+			t.Fatalf("ProcessOrphans: deferred orphan was not " +
+				"rechecked")
+		}
+	}
+	for _, orphan := range orphans {
+		testPoolMembership(tc, orphan, false, true)
+	}
+}
+
 // TestCheckSpend tests that CheckSpend returns the expected spends found in
 // the mempool.
 func TestCheckSpend(t *testing.T) {
@@ -1851,3 +2143,1054 @@ func TestRBF(t *testing.T) {
 		}
 	}
 }
+
+// TestNewReplacementTx ensures replacement transactions constructed to bump the
+// fee of a transaction in the pool satisfy the RBF policy and replace it along
+// with its descendants.
+func TestNewReplacementTx(t *testing.T) {
+	t.Parallel()
+
+	const (
+		defaultFee = btcutil.Amount(1000)
+		highRate   = 60 * btcutil.SatoshiPerBitcoin
+	)
+
+	testCases := []struct {
+		name        string
+		signals     bool
+		feeRate     btcutil.Amount
+		extraInputs bool
+		wantInputs  int
+		err         string
+	}{
+		{
+			// The fee of a replacement for a transaction with
+			// enough change is only paid from the change.
+			name:       "reduce change",
+			signals:    true,
+			wantInputs: 1,
+		},
+		{
+			// A fee rate the change can't cover requires an extra
+			// input to be added.
+			name:        "add input",
+			signals:     true,
+			feeRate:     highRate,
+			extraInputs: true,
+			wantInputs:  2,
+		},
+		{
+			// A fee rate the change can't cover without any extra
+			// inputs can't be paid.
+			name:    "insufficient funds",
+			signals: true,
+			feeRate: highRate,
+			err:     "insufficient funds",
+		},
+		{
+			// A transaction that doesn't signal replacement can't
+			// be replaced.
+			name: "non-replaceable",
+			err:  "does not signal replacement",
+		},
+	}
+
+	for _, testCase := range testCases {
+		success := t.Run(testCase.name, func(t *testing.T) {
+			harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatalf("unable to create test pool: %v", err)
+			}
+			ctx := &testContext{t, harness}
+
+			// Create a transaction with a payment and a change
+			// output along with a child spending the payment,
+			// which is evicted along with it when it's replaced.
+			coinbase := ctx.addCoinbaseTx(2)
+			outs := []spendableOutput{txOutToSpendableOut(coinbase, 0)}
+			tx := ctx.addSignedTx(
+				outs, 2, defaultFee, testCase.signals, false,
+			)
+			childOuts := []spendableOutput{txOutToSpendableOut(tx, 0)}
+			child := ctx.addSignedTx(
+				childOuts, 1, defaultFee, false, false,
+			)
+
+			var extraInputs []ReplacementInput
+			if testCase.extraInputs {
+				extraOut := txOutToSpendableOut(coinbase, 1)
+				extraInputs = append(extraInputs, ReplacementInput{
+					OutPoint: extraOut.outPoint,
+					Amount:   extraOut.amount,
+				})
+			}
+			msgTx, err := harness.txPool.NewReplacementTx(
+				tx.Hash(), 1, testCase.feeRate, extraInputs,
+			)
+			if testCase.err != "" {
+				if err == nil || !strings.Contains(err.Error(),
+					testCase.err) {
+
+					t.Fatalf("expected error: %v\ngot: %v",
+						testCase.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to create replacement: %v", err)
+			}
+			if len(msgTx.TxIn) != testCase.wantInputs {
+				t.Fatalf("replacement has %d inputs, want %d",
+					len(msgTx.TxIn), testCase.wantInputs)
+			}
+			payment := tx.MsgTx().TxOut[0]
+			if !reflect.DeepEqual(msgTx.TxOut[0], payment) {
+				t.Fatalf("replacement pays %v, want %v",
+					msgTx.TxOut[0], payment)
+			}
+
+			// Sign the replacement and ensure it replaces the
+			// original transaction and its child.
+			for i := range msgTx.TxIn {
+				sigScript, err := txscript.SignatureScript(msgTx,
+					i, harness.payScript, txscript.SigHashAll,
+					harness.signKey, true)
+				if err != nil {
+					t.Fatalf("unable to sign replacement: %v",
+						err)
+				}
+				msgTx.TxIn[i].SignatureScript = sigScript
+			}
+			replacement := btcutil.NewTx(msgTx)
+			conflicts := harness.txPool.txConflicts(replacement)
+			if _, ok := conflicts[*tx.Hash()]; !ok {
+				t.Fatalf("replacement does not conflict with %v",
+					tx.Hash())
+			}
+			_, err = harness.txPool.ProcessTransaction(
+				replacement, false, false, 0,
+			)
+			if err != nil {
+				t.Fatalf("unable to process replacement: %v", err)
+			}
+			testPoolMembership(ctx, tx, false, false)
+			testPoolMembership(ctx, child, false, false)
+			testPoolMembership(ctx, replacement, false, true)
+		})
+		if !success {
+			break
+		}
+	}
+}
+
+// TestNonFinalTx ensures transactions with a lock time that has not been
+// reached at the next block are rejected regardless of whether non-standard
+// transactions are accepted and that they are accepted once final.
+func TestNonFinalTx(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+
+		// lockTime returns the lock time of the transaction given the
+		// height and median time past of the harness chain.
+		lockTime func(height int32, medianTimePast time.Time) uint32
+
+		// advance moves the harness chain to the point where the
+		// transaction is final.
+		advance func(chain *fakeChain)
+	}{
+		{
+			name: "height",
+			lockTime: func(height int32, _ time.Time) uint32 {
+				return uint32(height + 1)
+			},
+			advance: func(chain *fakeChain) {
+				chain.SetHeight(chain.BestHeight() + 1)
+			},
+		},
+		{
+			name: "time",
+			lockTime: func(_ int32, medianTimePast time.Time) uint32 {
+				return uint32(medianTimePast.Unix() + 3600)
+			},
+			advance: func(chain *fakeChain) {
+				chain.SetMedianTimePast(chain.MedianTimePast().
+					Add(time.Hour + time.Second))
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		for _, acceptNonStd := range []bool{false, true} {
+			harness, outputs, err := newPoolHarness(
+				&chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatalf("unable to create test pool: %v", err)
+			}
+			harness.txPool.cfg.Policy.AcceptNonStd = acceptNonStd
+			chain := harness.chain
+			chain.SetMedianTimePast(time.Unix(1600000000, 0))
+
+			// Create a transaction that is locked until the block
+			// after the next one.  The sequence of its input must
+			// not be final for the lock time to be enforced.
+			tx := wire.NewMsgTx(wire.TxVersion)
+			tx.AddTxIn(&wire.TxIn{
+				PreviousOutPoint: outputs[0].outPoint,
+				Sequence:         wire.MaxTxInSequenceNum - 1,
+			})
+			tx.AddTxOut(&wire.TxOut{
+				PkScript: harness.payScript,
+				Value:    int64(outputs[0].amount) - 1000,
+			})
+			tx.LockTime = testCase.lockTime(chain.BestHeight(),
+				chain.MedianTimePast())
+			sigScript, err := txscript.SignatureScript(tx, 0,
+				harness.payScript, txscript.SigHashAll,
+				harness.signKey, true)
+			if err != nil {
+				t.Fatalf("unable to sign transaction: %v", err)
+			}
+			tx.TxIn[0].SignatureScript = sigScript
+			lockedTx := btcutil.NewTx(tx)
+
+			// Ensure the transaction is rejected while it is not
+			// final at the next block.
+			_, err = harness.txPool.ProcessTransaction(lockedTx,
+				false, false, 0)
+			if err == nil || !strings.Contains(err.Error(),
+				"not finalized") {
+
+				t.Fatalf("%s (acceptnonstd %v): expected "+
+					"non-final rejection, got %v",
+					testCase.name, acceptNonStd, err)
+			}
+			if ErrorKindOf(err) != ErrKindPolicy {
+				t.Fatalf("%s (acceptnonstd %v): unexpected "+
+					"error kind %v", testCase.name,
+					acceptNonStd, ErrorKindOf(err))
+			}
+
+			// Ensure the transaction is accepted once it's final.
+			testCase.advance(chain)
+			_, err = harness.txPool.ProcessTransaction(lockedTx,
+				false, false, 0)
+			if err != nil {
+				t.Fatalf("%s (acceptnonstd %v): unable to "+
+					"process final transaction: %v",
+					testCase.name, acceptNonStd, err)
+			}
+			if !harness.txPool.IsTransactionInPool(lockedTx.Hash()) {
+				t.Fatalf("%s (acceptnonstd %v): final "+
+					"transaction not in pool",
+					testCase.name, acceptNonStd)
+			}
+		}
+	}
+}
+
+// TestPackageLimits ensures transactions that would exceed the configured
+// limits on the number of unconfirmed ancestors and descendants are rejected
+// while those within the limits are accepted.
+func TestPackageLimits(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.Policy.MaxAncestorCount = DefaultMaxAncestorCount
+	harness.txPool.cfg.Policy.MaxAncestorSize = DefaultMaxAncestorSize
+	ctx := &testContext{t, harness}
+
+	// Create a chain of one more transaction than the ancestor limit
+	// allows.  All but the last transaction form a chain of 25
+	// transactions and should be accepted.
+	chainedTxns, err := harness.CreateTxChain(outputs[0],
+		DefaultMaxAncestorCount+1)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	for _, tx := range chainedTxns[:DefaultMaxAncestorCount] {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+		testPoolMembership(ctx, tx, false, true)
+	}
+
+	// The last transaction would create a chain of 26 transactions and
+	// should be rejected.
+	lastTx := chainedTxns[DefaultMaxAncestorCount]
+	_, err = harness.txPool.ProcessTransaction(lastTx, false, false, 0)
+	if err == nil || !strings.Contains(err.Error(), "too many unconfirmed "+
+		"ancestors") {
+
+		t.Fatalf("expected too many ancestors rejection, got %v", err)
+	}
+	if ErrorKindOf(err) != ErrKindPolicy {
+		t.Fatalf("unexpected error kind %v", ErrorKindOf(err))
+	}
+	testPoolMembership(ctx, lastTx, false, false)
+
+	// Ensure the descendant limit of an unconfirmed transaction counts
+	// the transaction itself by allowing it only two spends.
+	harness.txPool.cfg.Policy.MaxDescendantCount = 3
+	coinbase := ctx.addCoinbaseTx(1)
+	parent := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0),
+	}, 3, 0, false, false)
+	for i := uint32(0); i < 2; i++ {
+		ctx.addSignedTx([]spendableOutput{
+			txOutToSpendableOut(parent, i),
+		}, 1, 0, false, false)
+	}
+	tx, err := harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(parent, 2),
+	}, 1, 0, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err == nil || !strings.Contains(err.Error(), "its descendants") {
+		t.Fatalf("expected descendant limit rejection, got %v", err)
+	}
+	testPoolMembership(ctx, tx, false, false)
+}
+
+// TestInputUtxoCache ensures the outputs from the main chain spent by
+// transactions in the pool are cached when they are accepted, are not looked
+// up again while cached, and that the cache is invalidated when transactions
+// are removed or blocks are disconnected.
+func TestInputUtxoCache(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	chain := harness.chain
+	txPool := harness.txPool
+
+	parent := ctx.addSignedTx(outputs, 2, 1000, false, false)
+	child := ctx.addSignedTx([]spendableOutput{
+		txOutToSpendableOut(parent, 0),
+	}, 1, 1000, false, false)
+
+	// fetchInputUtxos fetches the inputs of the passed transaction and
+	// ensures the main chain is looked up the expected number of times and
+	// the returned view contains the expected outputs.
+	fetchInputUtxos := func(desc string, tx *btcutil.Tx, wantFetches int64,
+		wantOutputs []spendableOutput) {
+
+		t.Helper()
+
+		numFetches := atomic.LoadInt64(&chain.numFetches)
+		utxos, err := txPool.FetchInputUtxos(tx)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", desc, err)
+		}
+		gotFetches := atomic.LoadInt64(&chain.numFetches) - numFetches
+		if gotFetches != wantFetches {
+			t.Fatalf("%s: got %d main chain lookups, want %d", desc,
+				gotFetches, wantFetches)
+		}
+		for _, output := range wantOutputs {
+			entry := utxos.LookupEntry(output.outPoint)
+			if entry == nil || entry.IsSpent() ||
+				entry.Amount() != int64(output.amount) {
+
+				t.Fatalf("%s: missing output %v", desc,
+					output.outPoint)
+			}
+		}
+		for _, txIn := range tx.MsgTx().TxIn {
+			if !txPool.HaveTransaction(&txIn.PreviousOutPoint.Hash) {
+				continue
+			}
+			entry := utxos.LookupEntry(txIn.PreviousOutPoint)
+			if entry != nil && !entry.IsSpent() {
+				t.Fatalf("%s: view contains output %v of a pool "+
+					"transaction", desc, txIn.PreviousOutPoint)
+			}
+		}
+	}
+
+	// Ensure the outputs are served from the cache, including for the
+	// child that only spends an output of a transaction in the pool, and
+	// that modifying the returned view does not affect the cache.
+	fetchInputUtxos("parent", parent, 0, outputs)
+	utxos, _ := txPool.FetchInputUtxos(parent)
+	utxos.LookupEntry(outputs[0].outPoint).Spend()
+	fetchInputUtxos("parent after spend", parent, 0, outputs)
+	fetchInputUtxos("child", child, 0, nil)
+
+	// Ensure outputs are looked up in the main chain again once the cache
+	// was cleared and after a transaction is removed and added back.
+	txPool.ClearInputUtxoCache()
+	fetchInputUtxos("cleared", parent, 1, outputs)
+	txPool.RemoveTransaction(child, false)
+	txPool.RemoveTransaction(parent, false)
+	fetchInputUtxos("removed", parent, 1, outputs)
+	_, err = txPool.ProcessTransaction(parent, false, false, 0)
+	if err != nil {
+		t.Fatalf("unable to process transaction: %v", err)
+	}
+	fetchInputUtxos("added back", parent, 0, outputs)
+	if len(txPool.inputUtxos) != len(outputs) {
+		t.Fatalf("got %d cached outputs, want %d",
+			len(txPool.inputUtxos), len(outputs))
+	}
+}
+
+// TestMaxTxFee ensures local transactions paying more than the maximum fee of
+// the policy, or more than the maximum fee rate when one is provided, are
+// rejected unless high fees are allowed for them, while relayed transactions
+// are not limited.
+func TestMaxTxFee(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	txPool := harness.txPool
+	txPool.cfg.Policy.MaxTxFee = 10000
+
+	// Ensure a local transaction paying exactly the maximum fee is
+	// accepted.
+	coinbase := ctx.addCoinbaseTx(3)
+	tx, err := harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0),
+	}, 1, 10000, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = txPool.ProcessLocalTransaction(tx, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessLocalTransaction: unexpected error: %v", err)
+	}
+
+	// Ensure a local transaction paying more than the maximum fee is
+	// rejected by default and accepted when high fees are allowed.
+	tx, err = harness.CreateSignedTx(outputs, 1, 10001, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = txPool.ProcessLocalTransaction(tx, false, 0)
+	if _, ok := err.(RuleError); !ok {
+		t.Fatalf("ProcessLocalTransaction: got error %v, want rule "+
+			"error", err)
+	}
+	testPoolMembership(ctx, tx, false, false)
+	_, err = txPool.ProcessLocalTransaction(tx, true, 0)
+	if err != nil {
+		t.Fatalf("ProcessLocalTransaction: unexpected error with high "+
+			"fees allowed: %v", err)
+	}
+	testPoolMembership(ctx, tx, false, true)
+
+	// Ensure relayed transactions are not limited.
+	tx, err = harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 1),
+	}, 1, btcutil.SatoshiPerBitcoin, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = txPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+
+	// Ensure the limit is disabled by a zero maximum fee.
+	txPool.cfg.Policy.MaxTxFee = 0
+	tx, err = harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 2),
+	}, 1, btcutil.SatoshiPerBitcoin, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = txPool.ProcessLocalTransaction(tx, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessLocalTransaction: unexpected error without "+
+			"maximum: %v", err)
+	}
+
+	// Ensure a maximum fee rate limits the fee rate of local transactions
+	// instead of the maximum fee of the policy.
+	txPool.cfg.Policy.MaxTxFee = 10000
+	coinbase = ctx.addCoinbaseTx(2)
+	tx, err = harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 0),
+	}, 1, 20000, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	feeRate := btcutil.Amount(20000 * 1000 / GetTxVirtualSize(tx))
+	_, err = txPool.ProcessLocalTransaction(tx, false, feeRate-1)
+	if _, ok := err.(RuleError); !ok {
+		t.Fatalf("ProcessLocalTransaction: got error %v, want rule "+
+			"error for fee rate over maximum", err)
+	}
+	testPoolMembership(ctx, tx, false, false)
+	_, err = txPool.ProcessLocalTransaction(tx, false, feeRate)
+	if err != nil {
+		t.Fatalf("ProcessLocalTransaction: unexpected error with fee "+
+			"rate at maximum: %v", err)
+	}
+	testPoolMembership(ctx, tx, false, true)
+
+	tx, err = harness.CreateSignedTx([]spendableOutput{
+		txOutToSpendableOut(coinbase, 1),
+	}, 1, 5000, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = txPool.ProcessLocalTransaction(tx, false, 1000)
+	if _, ok := err.(RuleError); !ok {
+		t.Fatalf("ProcessLocalTransaction: got error %v, want rule "+
+			"error for fee under maximum but fee rate over maximum",
+			err)
+	}
+}
+
+// TestAcceptHook ensures that a registered accept hook is able to reject
+// otherwise valid transactions and that transactions it allows are accepted.
+func TestAcceptHook(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	// Register a hook that rejects any transaction paying to a
+	// blacklisted address.
+	blacklistAddr, err := btcutil.NewAddressPubKeyHash(
+		make([]byte, 20), harness.chainParams)
+	if err != nil {
+		t.Fatalf("unable to create blacklisted address: %v", err)
+	}
+	blacklistScript, err := txscript.PayToAddrScript(blacklistAddr)
+	if err != nil {
+		t.Fatalf("unable to create blacklisted script: %v", err)
+	}
+	var hookCalls int
+	harness.txPool.RegisterAcceptHook(func(tx *btcutil.Tx,
+		_ *blockchain.UtxoViewpoint) error {
+
+		hookCalls++
+		for _, txOut := range tx.MsgTx().TxOut {
+			if bytes.Equal(txOut.PkScript, blacklistScript) {
+				return TxRuleError{
+					RejectCode:  wire.RejectInvalid,
+					Description: "pays to blacklisted address",
+				}
+			}
+		}
+		return nil
+	})
+
+	// Create a transaction that sends part of the spendable output to the
+	// blacklisted address.
+	spendable := outputs[0]
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: spendable.outPoint,
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	tx.AddTxOut(&wire.TxOut{
+		PkScript: blacklistScript,
+		Value:    int64(spendable.amount / 2),
+	})
+	tx.AddTxOut(&wire.TxOut{
+		PkScript: harness.payScript,
+		Value:    int64(spendable.amount/2) - 10000,
+	})
+	sigScript, err := txscript.SignatureScript(tx, 0, harness.payScript,
+		txscript.SigHashAll, harness.signKey, true)
+	if err != nil {
+		t.Fatalf("unable to sign transaction: %v", err)
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+	blacklistTx := btcutil.NewTx(tx)
+
+	// The transaction must be rejected with the reject code provided by
+	// the hook.
+	_, err = harness.txPool.ProcessTransaction(blacklistTx, false, false, 0)
+	if err == nil {
+		t.Fatal("ProcessTransaction: accepted transaction paying to " +
+			"blacklisted address")
+	}
+	code, extracted := extractRejectCode(err)
+	if !extracted {
+		t.Fatalf("ProcessTransaction: failed to extract reject code "+
+			"from error %q", err)
+	}
+	if code != wire.RejectInvalid {
+		t.Fatalf("ProcessTransaction: unexpected reject code -- got "+
+			"%v, want %v", code, wire.RejectInvalid)
+	}
+	testPoolMembership(tc, blacklistTx, false, false)
+
+	// A transaction spending the same output to the harness address must
+	// be allowed through by the hook.
+	allowedTx, err := harness.CreateSignedTx(outputs, 1, 10000, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(allowedTx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid "+
+			"transaction: %v", err)
+	}
+	testPoolMembership(tc, allowedTx, false, true)
+
+	if hookCalls != 2 {
+		t.Fatalf("unexpected number of hook invocations -- got %d, "+
+			"want 2", hookCalls)
+	}
+}
+
+// TestErrorKind ensures transactions rejected by the mempool are classified
+// with the expected error kind.
+func TestErrorKind(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	coinbase := tc.addCoinbaseTx(4)
+	outputs := make([]spendableOutput, 0, 4)
+	for i := uint32(0); i < 4; i++ {
+		outputs = append(outputs, txOutToSpendableOut(coinbase, i))
+	}
+
+	// Prevent free transactions from being relayed so a transaction that
+	// doesn't pay the minimum fee is rejected.
+	harness.txPool.cfg.Policy.FreeTxRelayLimit = 0
+
+	// A transaction that spends the same output twice violates consensus.
+	doubleSpend, err := harness.CreateSignedTx(
+		[]spendableOutput{outputs[0], outputs[0]}, 1, 1000, false,
+	)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	// A transaction that does not pay any fee violates policy.
+	underpaying, err := harness.CreateSignedTx(
+		[]spendableOutput{outputs[1]}, 1, 0, false,
+	)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	// A transaction that spends an output of another transaction which
+	// is not known to the mempool is missing inputs.
+	chainedTxns, err := harness.CreateTxChain(outputs[2], 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	orphan := chainedTxns[1]
+
+	// A transaction that conflicts with one in the mempool violates
+	// policy since it doesn't signal replacement.
+	tc.addSignedTx([]spendableOutput{outputs[3]}, 1, 1000, false, false)
+	conflict, err := harness.CreateSignedTx(
+		[]spendableOutput{outputs[3]}, 1, 2000, false,
+	)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		tx   *btcutil.Tx
+		want ErrorKind
+	}{
+		{name: "double spend", tx: doubleSpend, want: ErrKindConsensus},
+		{name: "underpaying", tx: underpaying, want: ErrKindPolicy},
+		{name: "missing input", tx: orphan, want: ErrKindMissingInputs},
+		{name: "mempool conflict", tx: conflict, want: ErrKindPolicy},
+	}
+
+	for _, test := range tests {
+		_, err := harness.txPool.ProcessTransaction(test.tx, false, true, 0)
+		if err == nil {
+			t.Errorf("%s: transaction was not rejected", test.name)
+			continue
+		}
+		if _, ok := err.(RuleError); !ok {
+			t.Errorf("%s: unexpected error type -- got %T, want "+
+				"RuleError", test.name, err)
+			continue
+		}
+		if kind := ErrorKindOf(err); kind != test.want {
+			t.Errorf("%s: unexpected error kind for %q -- got %v, "+
+				"want %v", test.name, err, kind, test.want)
+		}
+	}
+
+	// Errors that are not rule violations are transient.
+	if kind := ErrorKindOf(errors.New("db error")); kind != ErrKindTransient {
+		t.Errorf("unexpected error kind for non-rule error -- got %v, "+
+			"want %v", kind, ErrKindTransient)
+	}
+}
+
+// TestDoubleSpendNotification ensures subscribers are notified when a
+// transaction conflicting with a non-replaceable transaction in the pool is
+// rejected, and that conflicting transactions with invalid signatures are not
+// reported.
+func TestDoubleSpendNotification(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	var notifications []*DoubleSpend
+	harness.txPool.SubscribeDoubleSpends(func(ds *DoubleSpend) {
+		notifications = append(notifications, ds)
+	})
+
+	// Add a transaction that doesn't signal replacement to the pool along
+	// with a conflicting transaction that pays a higher fee.
+	coinbase := tc.addCoinbaseTx(1)
+	coinbaseOut := txOutToSpendableOut(coinbase, 0)
+	original := tc.addSignedTx(
+		[]spendableOutput{coinbaseOut}, 1, 1000, false, false,
+	)
+	conflict, err := harness.CreateSignedTx(
+		[]spendableOutput{coinbaseOut}, 1, 2000, false,
+	)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	// A conflicting transaction with an invalid signature must not be
+	// reported.
+	badSig := conflict.MsgTx().Copy()
+	badSig.TxIn[0].SignatureScript = []byte{txscript.OP_TRUE}
+	_, err = harness.txPool.ProcessTransaction(
+		btcutil.NewTx(badSig), false, false, 0,
+	)
+	if err == nil {
+		t.Fatal("conflicting transaction with invalid signature was " +
+			"accepted")
+	}
+	if len(notifications) != 0 {
+		t.Fatalf("unexpected notification for transaction with " +
+			"invalid signature")
+	}
+
+	// The validly signed conflicting transaction must be rejected and
+	// reported along with the transaction it conflicts with.
+	_, err = harness.txPool.ProcessTransaction(conflict, false, false, 0)
+	if err == nil {
+		t.Fatal("conflicting transaction was accepted")
+	}
+	if len(notifications) != 1 {
+		t.Fatalf("unexpected number of notifications -- got %d, want 1",
+			len(notifications))
+	}
+	ds := notifications[0]
+	if *ds.Tx.Hash() != *conflict.Hash() {
+		t.Fatalf("unexpected rejected transaction -- got %v, want %v",
+			ds.Tx.Hash(), conflict.Hash())
+	}
+	if len(ds.Conflicts) != 1 {
+		t.Fatalf("unexpected number of conflicts -- got %d, want 1",
+			len(ds.Conflicts))
+	}
+	if ds.Conflicts[0].OutPoint != coinbaseOut.outPoint {
+		t.Fatalf("unexpected conflicting outpoint -- got %v, want %v",
+			ds.Conflicts[0].OutPoint, coinbaseOut.outPoint)
+	}
+	if *ds.Conflicts[0].Spender.Hash() != *original.Hash() {
+		t.Fatalf("unexpected conflicting transaction -- got %v, want %v",
+			ds.Conflicts[0].Spender.Hash(), original.Hash())
+	}
+	testPoolMembership(tc, original, false, true)
+	testPoolMembership(tc, conflict, false, false)
+}
+
+// TestScriptComplexity ensures transactions with scripts that execute within
+// the configured complexity limits are accepted while those that exceed them
+// are rejected as non-standard.
+func TestScriptComplexity(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	// Create a redeem script that pushes more items onto the stack than
+	// the default limit allows before dropping all but one of them.
+	const numPushes = DefaultMaxScriptStackDepth + 51
+	builder := txscript.NewScriptBuilder()
+	for i := 0; i < numPushes; i++ {
+		builder.AddOp(txscript.OP_1)
+	}
+	for i := 0; i < numPushes/2; i++ {
+		builder.AddOp(txscript.OP_2DROP)
+	}
+	redeemScript, err := builder.Script()
+	if err != nil {
+		t.Fatalf("unable to build redeem script: %v", err)
+	}
+	p2shAddr, err := btcutil.NewAddressScriptHash(redeemScript,
+		harness.chainParams)
+	if err != nil {
+		t.Fatalf("unable to create p2sh address: %v", err)
+	}
+	p2shScript, err := txscript.PayToAddrScript(p2shAddr)
+	if err != nil {
+		t.Fatalf("unable to create p2sh script: %v", err)
+	}
+	sigScript, err := txscript.NewScriptBuilder().AddData(redeemScript).
+		Script()
+	if err != nil {
+		t.Fatalf("unable to build signature script: %v", err)
+	}
+
+	// Confirm a transaction with two outputs paying to the p2sh script.
+	coinbase := tc.addCoinbaseTx(1)
+	fundingTx := wire.NewMsgTx(wire.TxVersion)
+	fundingTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: *coinbase.Hash()},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	outputValue := coinbase.MsgTx().TxOut[0].Value/2 - 1000
+	fundingTx.AddTxOut(wire.NewTxOut(outputValue, p2shScript))
+	fundingTx.AddTxOut(wire.NewTxOut(outputValue, p2shScript))
+	fundingTx.TxIn[0].SignatureScript, err = txscript.SignatureScript(
+		fundingTx, 0, harness.payScript, txscript.SigHashAll,
+		harness.signKey, true,
+	)
+	if err != nil {
+		t.Fatalf("unable to sign transaction: %v", err)
+	}
+	funding := btcutil.NewTx(fundingTx)
+	newHeight := harness.chain.BestHeight() + 1
+	harness.chain.utxos.AddTxOuts(funding, newHeight)
+	harness.chain.SetHeight(newHeight)
+
+	// spendP2SH returns a transaction that spends the given output of the
+	// funding transaction.
+	spendP2SH := func(index uint32) *btcutil.Tx {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{
+				Hash:  *funding.Hash(),
+				Index: index,
+			},
+			SignatureScript: sigScript,
+			Sequence:        wire.MaxTxInSequenceNum,
+		})
+		tx.AddTxOut(wire.NewTxOut(outputValue-10000, harness.payScript))
+		return btcutil.NewTx(tx)
+	}
+
+	// The overly complex script must be rejected as non-standard.
+	complexTx := spendP2SH(0)
+	_, err = harness.txPool.ProcessTransaction(complexTx, false, false, 0)
+	if err == nil {
+		t.Fatal("transaction with overly complex script was accepted")
+	}
+	code, _ := extractRejectCode(err)
+	if code != wire.RejectNonstandard {
+		t.Fatalf("unexpected reject code -- got %v, want %v", code,
+			wire.RejectNonstandard)
+	}
+	testPoolMembership(tc, complexTx, false, false)
+
+	// Scripts which fail to validate must still be rejected as consensus
+	// violations when they are executed in metered mode.
+	coinbase = tc.addCoinbaseTx(1)
+	invalidTx := wire.NewMsgTx(wire.TxVersion)
+	invalidTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: *coinbase.Hash()},
+		SignatureScript:  []byte{txscript.OP_0, txscript.OP_0},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	invalidTx.AddTxOut(wire.NewTxOut(outputValue, harness.payScript))
+	_, err = harness.txPool.ProcessTransaction(btcutil.NewTx(invalidTx),
+		false, false, 0)
+	if ErrorKindOf(err) != ErrKindConsensus {
+		t.Fatalf("unexpected error kind for invalid script -- got %v, "+
+			"want %v (err: %v)", ErrorKindOf(err), ErrKindConsensus, err)
+	}
+	rerr, ok := err.(RuleError)
+	if !ok {
+		t.Fatalf("unexpected error type %T", err)
+	}
+	cerr, ok := rerr.Err.(blockchain.RuleError)
+	if !ok || cerr.ErrorCode != blockchain.ErrScriptValidation {
+		t.Fatalf("unexpected error for invalid script: %v", err)
+	}
+
+	// The same script is accepted once the limit is raised, which shows it
+	// is otherwise standard.
+	harness.txPool.cfg.Policy.MaxScriptStackDepth = numPushes
+	complexTx = spendP2SH(1)
+	_, err = harness.txPool.ProcessTransaction(complexTx, false, false, 0)
+	if err != nil {
+		t.Fatalf("unable to process transaction within raised "+
+			"limit: %v", err)
+	}
+	testPoolMembership(tc, complexTx, false, true)
+
+	// A standard pay-to-pubkey-hash spend is accepted with the default
+	// limits.
+	harness.txPool.cfg.Policy.MaxScriptStackDepth = DefaultMaxScriptStackDepth
+	coinbase = tc.addCoinbaseTx(1)
+	tc.addSignedTx([]spendableOutput{txOutToSpendableOut(coinbase, 0)},
+		1, 1000, false, false)
+}
+
+// TestUnbroadcastSet ensures a locally submitted transaction remains in the
+// unbroadcast set until a peer requests it or it leaves the pool and that
+// transactions which are not in the pool are never added to it.
+func TestUnbroadcastSet(t *testing.T) {
+	t.Parallel()
+
+	harness, spendableOuts, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	tx, err := harness.CreateSignedTx(spendableOuts, 1, 0, false)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	// Ensure a transaction that is not in the pool is not tracked.
+	harness.txPool.AddUnbroadcastTx(tx.Hash())
+	if count := harness.txPool.UnbroadcastCount(); count != 0 {
+		t.Fatalf("UnbroadcastCount: tracked transaction not in pool -- "+
+			"got %d, want 0", count)
+	}
+
+	// Submit the transaction and mark it unbroadcast as the RPC server
+	// does for originated transactions.
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid "+
+			"transaction: %v", err)
+	}
+	testPoolMembership(tc, tx, false, true)
+	harness.txPool.AddUnbroadcastTx(tx.Hash())
+	descs := harness.txPool.UnbroadcastTxDescs()
+	if len(descs) != 1 || *descs[0].Tx.Hash() != *tx.Hash() {
+		t.Fatalf("UnbroadcastTxDescs: unexpected descriptors -- got %d, "+
+			"want 1 for %v", len(descs), tx.Hash())
+	}
+	if count := harness.txPool.UnbroadcastCount(); count != 1 {
+		t.Fatalf("UnbroadcastCount: got %d, want 1", count)
+	}
+
+	// Ensure the transaction is removed from the set once a peer requests
+	// it.
+	harness.txPool.RemoveUnbroadcastTx(tx.Hash())
+	if count := harness.txPool.UnbroadcastCount(); count != 0 {
+		t.Fatalf("UnbroadcastCount: transaction requested by peer is "+
+			"still tracked -- got %d, want 0", count)
+	}
+
+	// Ensure the transaction is removed from the set when it leaves the
+	// pool, such as when it is included in a block.
+	harness.txPool.AddUnbroadcastTx(tx.Hash())
+	harness.txPool.RemoveTransaction(tx, true)
+	if count := harness.txPool.UnbroadcastCount(); count != 0 {
+		t.Fatalf("UnbroadcastCount: transaction removed from pool is "+
+			"still tracked -- got %d, want 0", count)
+	}
+}
+
+// benchmarkFetchInputUtxos benchmarks fetching the outputs spent by every
+// transaction in a full pool, as is done when generating a block template,
+// with the passed fetch function and reports the number of main chain lookups
+// needed per template.
+func benchmarkFetchInputUtxos(b *testing.B, fetch func(*TxPool,
+	*btcutil.Tx) (*blockchain.UtxoViewpoint, error)) {
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		b.Fatalf("unable to create test pool: %v", err)
+	}
+
+	// Fill the pool with transactions spending the outputs of a mature
+	// coinbase.
+	const numTxns = 1000
+	curHeight := harness.chain.BestHeight()
+	coinbase, err := harness.CreateCoinbaseTx(curHeight+1, numTxns)
+	if err != nil {
+		b.Fatalf("unable to create coinbase: %v", err)
+	}
+	harness.chain.utxos.AddTxOuts(coinbase, curHeight+1)
+	harness.chain.SetHeight(curHeight + 1 +
+		int32(harness.chainParams.CoinbaseMaturity))
+	for i := uint32(0); i < numTxns; i++ {
+		tx, err := harness.CreateSignedTx([]spendableOutput{
+			txOutToSpendableOut(coinbase, i),
+		}, 1, 1000, false)
+		if err != nil {
+			b.Fatalf("unable to create transaction: %v", err)
+		}
+		_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			b.Fatalf("unable to process transaction: %v", err)
+		}
+	}
+	txDescs := harness.txPool.MiningDescs()
+
+	numFetches := atomic.LoadInt64(&harness.chain.numFetches)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, txDesc := range txDescs {
+			_, err := fetch(harness.txPool, txDesc.Tx)
+			if err != nil {
+				b.Fatalf("unable to fetch utxos: %v", err)
+			}
+		}
+	}
+	b.StopTimer()
+	numFetches = atomic.LoadInt64(&harness.chain.numFetches) - numFetches
+	b.ReportMetric(float64(numFetches)/float64(b.N), "lookups/op")
+}
+
+// BenchmarkFetchInputUtxosChain benchmarks fetching the outputs spent by the
+// transactions in a full pool from the main chain.
+func BenchmarkFetchInputUtxosChain(b *testing.B) {
+	benchmarkFetchInputUtxos(b, func(txPool *TxPool,
+		tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+
+		return txPool.cfg.FetchUtxoView(tx)
+	})
+}
+
+// BenchmarkFetchInputUtxosCached benchmarks fetching the outputs spent by the
+// transactions in a full pool from the cache of the pool.
+func BenchmarkFetchInputUtxosCached(b *testing.B) {
+	benchmarkFetchInputUtxos(b, func(txPool *TxPool,
+		tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+
+		return txPool.FetchInputUtxos(tx)
+	})
+}
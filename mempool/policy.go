@@ -49,6 +49,22 @@ const (
 	// for larger transactions.  This value is in Satoshi/1000 bytes.
 	DefaultMinRelayTxFee = btcutil.Amount(1000)
 
+	// DefaultMaxTxFee is the default maximum absolute fee a transaction
+	// submitted by the local node may pay.  It protects against accidentally
+	// paying an absurdly high fee, such as by mistaking the fee for an
+	// output amount.
+	DefaultMaxTxFee = btcutil.Amount(btcutil.SatoshiPerBitcoin / 10)
+
+	// DefaultMaxScriptSigOps is the default maximum number of signature
+	// operations a standard transaction input may execute during script
+	// evaluation.
+	DefaultMaxScriptSigOps = 100
+
+	// DefaultMaxScriptStackDepth is the default maximum combined depth of
+	// the data and alt stacks a standard transaction input may reach
+	// during script evaluation.
+	DefaultMaxScriptStackDepth = 200
+
 	// maxStandardMultiSigKeys is the maximum number of public keys allowed
 	// in a multi-signature transaction output script for it to be
 	// considered standard.
@@ -122,6 +138,73 @@ func checkInputsStandard(tx *btcutil.Tx, utxoView *blockchain.UtxoViewpoint) err
 	return nil
 }
 
+// checkInputsComplexity validates the scripts of every input of the passed
+// transaction using the standard verification flags while executing them in
+// metered mode to ensure none of them execute more than the provided maximum
+// number of signature operations or grow the combined stack deeper than the
+// provided maximum.  A limit of zero is not enforced.  This allows scripts
+// that are valid according to the consensus rules, but are abnormally
+// expensive to evaluate, to be rejected as non-standard without executing
+// them a second time.
+//
+// Scripts which fail to validate are reported the same way as by
+// blockchain.ValidateTransactionScripts.
+func checkInputsComplexity(tx *btcutil.Tx, utxoView *blockchain.UtxoViewpoint,
+	maxSigOps, maxStackDepth int, sigCache *txscript.SigCache,
+	hashCache *txscript.HashCache) error {
+
+	msgTx := tx.MsgTx()
+	var sigHashes *txscript.TxSigHashes
+	if msgTx.HasWitness() {
+		if hashCache != nil {
+			if !hashCache.ContainsHashes(tx.Hash()) {
+				hashCache.AddSigHashes(msgTx)
+			}
+			sigHashes, _ = hashCache.GetSigHashes(tx.Hash())
+		}
+		if sigHashes == nil {
+			sigHashes = txscript.NewTxSigHashes(msgTx)
+		}
+	}
+
+	for i, txIn := range msgTx.TxIn {
+		// It is safe to elide existence and index checks here since
+		// they have already been checked prior to calling this
+		// function.
+		entry := utxoView.LookupEntry(txIn.PreviousOutPoint)
+		vm, err := txscript.NewEngine(entry.PkScript(), msgTx, i,
+			txscript.StandardVerifyFlags, sigCache, sigHashes,
+			entry.Amount())
+		if err != nil {
+			str := fmt.Sprintf("failed to parse input %s:%d which "+
+				"references output %v - %v", tx.Hash(), i,
+				txIn.PreviousOutPoint, err)
+			return blockchain.RuleError{
+				ErrorCode:   blockchain.ErrScriptMalformed,
+				Description: str,
+			}
+		}
+		vm.SetExecutionLimits(maxSigOps, maxStackDepth)
+		err = vm.Execute()
+		if txscript.IsErrorCode(err, txscript.ErrExecutionLimit) {
+			str := fmt.Sprintf("transaction input #%d script is too "+
+				"complex: %v", i, err)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+		if err != nil {
+			str := fmt.Sprintf("failed to validate input %s:%d "+
+				"which references output %v - %v", tx.Hash(), i,
+				txIn.PreviousOutPoint, err)
+			return blockchain.RuleError{
+				ErrorCode:   blockchain.ErrScriptValidation,
+				Description: str,
+			}
+		}
+	}
+
+	return nil
+}
+
 // checkPkScriptStandard performs a series of checks on a transaction output
 // script (public key script) to ensure it is a "standard" public key script.
 // A standard public key script is one that is a recognized form, and for
@@ -387,3 +470,11 @@ func GetTxVirtualSize(tx *btcutil.Tx) int64 {
 	return (blockchain.GetTransactionWeight(tx) + (blockchain.WitnessScaleFactor - 1)) /
 		blockchain.WitnessScaleFactor
 }
+
+// CalcFeePerKB returns the fee rate in satoshi per 1000 bytes of virtual size
+// the passed fee represents for the given transaction.  This is the fee rate
+// recorded for transactions in the memory pool, so it should be used whenever
+// fee rates are compared against them.
+func CalcFeePerKB(tx *btcutil.Tx, fee int64) int64 {
+	return fee * 1000 / GetTxVirtualSize(tx)
+}
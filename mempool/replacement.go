@@ -0,0 +1,136 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// replacementSigScriptSize is the estimated size of the signature script that
+// will be created for an input added to a replacement transaction.  It is the
+// size of a signature script redeeming a pay-to-pubkey-hash output with a
+// compressed public key: OP_DATA_73 <sig> OP_DATA_33 <pubkey>.
+const replacementSigScriptSize = 1 + 73 + 1 + 33
+
+// ReplacementInput is an additional output that may be spent by a replacement
+// transaction in order to fund a higher fee than the change of the transaction
+// being replaced can cover.
+type ReplacementInput struct {
+	OutPoint wire.OutPoint
+	Amount   btcutil.Amount
+}
+
+// NewReplacementTx constructs a transaction that replaces the transaction with
+// the passed hash in the pool by paying a higher fee, as is done by the
+// bumpfee wallet RPC.  The fee is the minimum that satisfies the RBF policy of
+// the pool or the passed fee rate in satoshi per 1000 bytes when that results
+// in a higher fee.
+//
+// The additional fee is taken from the output at the passed change index.
+// When the change output can't cover it without becoming dust, the passed
+// extra inputs are added in order until it can and their amounts are added to
+// the change.  Added inputs are assumed to redeem pay-to-pubkey-hash outputs
+// when estimating the size of the replacement and must not spend outputs of
+// unconfirmed transactions other than the parents of the replaced
+// transaction.
+//
+// All inputs of the replacement signal replacement so it can be bumped again.
+// The signature scripts and witnesses of the replacement are cleared and the
+// caller is responsible for signing it.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) NewReplacementTx(txHash *chainhash.Hash, changeIndex int,
+	feeRate btcutil.Amount, extraInputs []ReplacementInput) (*wire.MsgTx, error) {
+
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txDesc, ok := mp.pool[*txHash]
+	if !ok {
+		return nil, fmt.Errorf("transaction %v is not in the pool",
+			txHash)
+	}
+	if mp.cfg.Policy.RejectReplacement {
+		return nil, fmt.Errorf("transaction replacement is disabled")
+	}
+	if !mp.signalsReplacement(txDesc.Tx, nil) {
+		return nil, fmt.Errorf("transaction %v does not signal "+
+			"replacement", txHash)
+	}
+	msgTx := txDesc.Tx.MsgTx().Copy()
+	if changeIndex < 0 || changeIndex >= len(msgTx.TxOut) {
+		return nil, fmt.Errorf("change index %d is out of range for "+
+			"transaction %v with %d outputs", changeIndex, txHash,
+			len(msgTx.TxOut))
+	}
+
+	// The replacement evicts the replaced transaction and all of its
+	// descendants, so it must pay a higher fee rate than each of them and
+	// more than their combined fees.
+	conflicts := mp.txConflicts(txDesc.Tx)
+	var conflictsFee, maxFeePerKB int64
+	for hash := range conflicts {
+		conflictsFee += mp.pool[hash].Fee
+		if mp.pool[hash].FeePerKB > maxFeePerKB {
+			maxFeePerKB = mp.pool[hash].FeePerKB
+		}
+	}
+
+	for _, txIn := range msgTx.TxIn {
+		if txIn.Sequence > MaxRBFSequence {
+			txIn.Sequence = MaxRBFSequence
+		}
+	}
+
+	change := msgTx.TxOut[changeIndex]
+	changeAmount := change.Value
+	numAdded := 0
+	for {
+		// The new signatures of the replaced inputs may be up to a couple
+		// of bytes larger than the existing ones, so account for that to
+		// avoid falling short of the required fee once signed.
+		size := GetTxVirtualSize(btcutil.NewTx(msgTx)) +
+			2*int64(len(txDesc.Tx.MsgTx().TxIn))
+		fee := conflictsFee + calcMinRequiredTxRelayFee(size,
+			mp.cfg.Policy.MinRelayTxFee)
+		if rateFee := ((maxFeePerKB+1)*size + 999) / 1000; rateFee > fee {
+			fee = rateFee
+		}
+		if rateFee := int64(feeRate) * size / 1000; rateFee > fee {
+			fee = rateFee
+		}
+
+		change.Value = changeAmount - (fee - txDesc.Fee)
+		if change.Value >= 0 && !IsDust(change, mp.cfg.Policy.MinRelayTxFee) {
+			break
+		}
+		if numAdded == len(extraInputs) {
+			return nil, fmt.Errorf("insufficient funds to pay a "+
+				"replacement fee of %v for transaction %v",
+				btcutil.Amount(fee), txHash)
+		}
+
+		// Add the next input with a placeholder signature script so
+		// the size of the replacement is estimated correctly.
+		input := extraInputs[numAdded]
+		msgTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: input.OutPoint,
+			SignatureScript:  make([]byte, replacementSigScriptSize),
+			Sequence:         MaxRBFSequence,
+		})
+		changeAmount += int64(input.Amount)
+		numAdded++
+	}
+
+	for _, txIn := range msgTx.TxIn {
+		txIn.SignatureScript = nil
+		txIn.Witness = nil
+	}
+	return msgTx, nil
+}
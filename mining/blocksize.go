@@ -0,0 +1,81 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// BlockSizeAccounting tracks the serialized size, weight, and signature
+// operation cost of a block as transactions are added to it.  This allows the
+// block template generator to enforce the block limits for each candidate
+// transaction without serializing the entire block again.
+//
+// The zero value represents a block with only a header and no transactions.
+type BlockSizeAccounting struct {
+	numTxns      int
+	txnsSize     int64
+	strippedSize int64
+	sigOpCost    int64
+}
+
+// headerSize returns the serialized size of the block header and transaction
+// count of a block with the passed number of transactions.
+func headerSize(numTxns int) int64 {
+	return int64(wire.MaxBlockHeaderPayload +
+		wire.VarIntSerializeSize(uint64(numTxns)))
+}
+
+// AddTx accounts for the passed transaction with the passed signature
+// operation cost being added to the block.
+func (a *BlockSizeAccounting) AddTx(tx *btcutil.Tx, sigOpCost int64) {
+	msgTx := tx.MsgTx()
+	a.numTxns++
+	a.txnsSize += int64(msgTx.SerializeSize())
+	a.strippedSize += int64(msgTx.SerializeSizeStripped())
+	a.sigOpCost += sigOpCost
+}
+
+// ReplaceTx accounts for the passed old transaction of the block being
+// replaced by the passed new one with the same signature operation cost, such
+// as when a witness commitment is added to the coinbase transaction.
+func (a *BlockSizeAccounting) ReplaceTx(oldTx, newTx *btcutil.Tx) {
+	a.txnsSize += int64(newTx.MsgTx().SerializeSize() -
+		oldTx.MsgTx().SerializeSize())
+	a.strippedSize += int64(newTx.MsgTx().SerializeSizeStripped() -
+		oldTx.MsgTx().SerializeSizeStripped())
+}
+
+// NumTxns returns the number of transactions accounted for.
+func (a *BlockSizeAccounting) NumTxns() int {
+	return a.numTxns
+}
+
+// Size returns the serialized size of the block including witness data.
+func (a *BlockSizeAccounting) Size() int64 {
+	return headerSize(a.numTxns) + a.txnsSize
+}
+
+// Weight returns the weight of the block as defined by BIP0141.
+func (a *BlockSizeAccounting) Weight() int64 {
+	baseSize := headerSize(a.numTxns) + a.strippedSize
+	return baseSize*(blockchain.WitnessScaleFactor-1) + a.Size()
+}
+
+// WeightWithTx returns the weight the block would have if the passed
+// transaction was added to it.
+func (a *BlockSizeAccounting) WeightWithTx(tx *btcutil.Tx) int64 {
+	headerDiff := headerSize(a.numTxns+1) - headerSize(a.numTxns)
+	return a.Weight() + headerDiff*blockchain.WitnessScaleFactor +
+		blockchain.GetTransactionWeight(tx)
+}
+
+// SigOpCost returns the total signature operation cost of the transactions
+// accounted for.
+func (a *BlockSizeAccounting) SigOpCost() int64 {
+	return a.sigOpCost
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TestBlockSizeAccounting ensures the size and weight tracked incrementally by
+// the block size accounting match those of the fully serialized block after
+// each added transaction, including when the number of transactions requires
+// a larger transaction count encoding.
+func TestBlockSizeAccounting(t *testing.T) {
+	var msgBlock wire.MsgBlock
+	var blockSize BlockSizeAccounting
+
+	// checkBlock ensures the accounting matches the serialized block.
+	checkBlock := func(desc string) {
+		t.Helper()
+
+		block := btcutil.NewBlock(&msgBlock)
+		got, want := blockSize.Size(), int64(msgBlock.SerializeSize())
+		if got != want {
+			t.Fatalf("%s: got size %d, want %d", desc, got, want)
+		}
+		got, want = blockSize.Weight(), blockchain.GetBlockWeight(block)
+		if got != want {
+			t.Fatalf("%s: got weight %d, want %d", desc, got, want)
+		}
+		gotN, wantN := blockSize.NumTxns(), len(msgBlock.Transactions)
+		if gotN != wantN {
+			t.Fatalf("%s: got %d transactions, want %d", desc,
+				gotN, wantN)
+		}
+	}
+	checkBlock("empty block")
+
+	// Add enough transactions, every third of which has witness data, for
+	// the transaction count to require more than one byte.
+	var wantSigOpCost int64
+	for i := 0; i < 300; i++ {
+		msgTx := wire.NewMsgTx(wire.TxVersion)
+		msgTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{
+				Hash:  chainhash.Hash{byte(i), byte(i >> 8)},
+				Index: uint32(i),
+			},
+			SignatureScript: bytes.Repeat([]byte{0x51}, i%120),
+		})
+		if i%3 == 0 {
+			msgTx.TxIn[0].Witness = wire.TxWitness{
+				bytes.Repeat([]byte{0x01}, 72),
+				bytes.Repeat([]byte{0x02}, 33),
+			}
+		}
+		msgTx.AddTxOut(wire.NewTxOut(int64(i), []byte{0x51}))
+		tx := btcutil.NewTx(msgTx)
+
+		wantWeight := blockSize.WeightWithTx(tx)
+		blockSize.AddTx(tx, int64(i))
+		wantSigOpCost += int64(i)
+		msgBlock.AddTransaction(msgTx)
+		checkBlock("after adding transaction")
+		if blockSize.Weight() != wantWeight {
+			t.Fatalf("transaction %d: got weight %d, predicted %d",
+				i, blockSize.Weight(), wantWeight)
+		}
+	}
+	if blockSize.SigOpCost() != wantSigOpCost {
+		t.Fatalf("got signature operation cost %d, want %d",
+			blockSize.SigOpCost(), wantSigOpCost)
+	}
+
+	// Ensure replacing a transaction with a larger one is accounted for.
+	oldTx := btcutil.NewTx(msgBlock.Transactions[1])
+	newMsgTx := oldTx.MsgTx().Copy()
+	newMsgTx.TxIn[0].Witness = wire.TxWitness{
+		bytes.Repeat([]byte{0x03}, 32),
+	}
+	newMsgTx.AddTxOut(wire.NewTxOut(0, bytes.Repeat([]byte{0x6a}, 38)))
+	blockSize.ReplaceTx(oldTx, btcutil.NewTx(newMsgTx))
+	msgBlock.Transactions[1] = newMsgTx
+	checkBlock("after replacing transaction")
+	if blockSize.SigOpCost() != wantSigOpCost {
+		t.Fatalf("got signature operation cost %d after replacement, "+
+			"want %d", blockSize.SigOpCost(), wantSigOpCost)
+	}
+}
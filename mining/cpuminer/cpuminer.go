@@ -7,7 +7,6 @@ package cpuminer
 import (
 	"errors"
 	"fmt"
-	"math/rand"
 	"runtime"
 	"sync"
 	"time"
@@ -58,9 +57,16 @@ type Config struct {
 	BlockTemplateGenerator *mining.BlkTmplGenerator
 
 	// MiningAddrs is a list of payment addresses to use for the generated
-	// blocks.  Each generated block will randomly choose one of them.
+	// blocks.  Each generated block pays to one of them as chosen by
+	// Payouts.
 	MiningAddrs []btcutil.Address
 
+	// Payouts chooses the payment address of each generated block from
+	// MiningAddrs.  It should be shared with anything else that generates
+	// block templates for the same chain.  This field can be omitted in
+	// which case the address is chosen at random.
+	Payouts *mining.PayoutAddrs
+
 	// ProcessBlock defines the function to call with any solved blocks.
 	// It typically must run the provided block through the same set of
 	// rules and handling as any other block coming from the network.
@@ -93,6 +99,7 @@ type CPUMiner struct {
 	sync.Mutex
 	g                 *mining.BlkTmplGenerator
 	cfg               Config
+	payouts           *mining.PayoutAddrs
 	numWorkers        uint32
 	started           bool
 	discreteMining    bool
@@ -334,14 +341,10 @@ out:
 			continue
 		}
 
-		// Choose a payment address at random.
-		rand.Seed(time.Now().UnixNano())
-		payToAddr := m.cfg.MiningAddrs[rand.Intn(len(m.cfg.MiningAddrs))]
-
 		// Create a new block template using the available transactions
 		// in the memory pool as a source of transactions to potentially
 		// include in the block.
-		template, err := m.g.NewBlockTemplate(payToAddr)
+		template, err := m.g.NewBlockTemplateForPayouts(m.payouts)
 		m.submitBlockLock.Unlock()
 		if err != nil {
 			errStr := fmt.Sprintf("Failed to create new block "+
@@ -588,14 +591,10 @@ func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*chainhash.Hash, error) {
 		m.submitBlockLock.Lock()
 		curHeight := m.g.BestSnapshot().Height
 
-		// Choose a payment address at random.
-		rand.Seed(time.Now().UnixNano())
-		payToAddr := m.cfg.MiningAddrs[rand.Intn(len(m.cfg.MiningAddrs))]
-
 		// Create a new block template using the available transactions
 		// in the memory pool as a source of transactions to potentially
 		// include in the block.
-		template, err := m.g.NewBlockTemplate(payToAddr)
+		template, err := m.g.NewBlockTemplateForPayouts(m.payouts)
 		m.submitBlockLock.Unlock()
 		if err != nil {
 			errStr := fmt.Sprintf("Failed to create new block "+
@@ -631,9 +630,15 @@ func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*chainhash.Hash, error) {
 // Use Start to begin the mining process.  See the documentation for CPUMiner
 // type for more details.
 func New(cfg *Config) *CPUMiner {
+	payouts := cfg.Payouts
+	if payouts == nil {
+		payouts = mining.NewPayoutAddrs(cfg.MiningAddrs,
+			mining.PayoutRandom)
+	}
 	return &CPUMiner{
 		g:                 cfg.BlockTemplateGenerator,
 		cfg:               *cfg,
+		payouts:           payouts,
 		numWorkers:        defaultNumWorkers,
 		updateNumWorkers:  make(chan struct{}),
 		queryHashesPerSec: make(chan float64),
@@ -0,0 +1,200 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package cpuminer
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+)
+
+// emptyTxSource is a mining.TxSource without any transactions.
+type emptyTxSource struct{}
+
+func (emptyTxSource) LastUpdated() time.Time               { return time.Time{} }
+func (emptyTxSource) MiningDescs() []*mining.TxDesc        { return nil }
+func (emptyTxSource) HaveTransaction(*chainhash.Hash) bool { return false }
+
+// newTestChain returns a chain instance for the passed network parameters that
+// is backed by a new database in a temporary directory.  The database is closed
+// once the test finishes.
+func newTestChain(t *testing.T, params *chaincfg.Params) *blockchain.BlockChain {
+	t.Helper()
+
+	db, err := database.Create("ffldb", filepath.Join(t.TempDir(), "db"),
+		params.Net)
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: params,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("unable to create chain: %v", err)
+	}
+	return chain
+}
+
+func (emptyTxSource) FetchInputUtxos(*btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+	return blockchain.NewUtxoViewpoint(), nil
+}
+
+// TestGenerateNBlocksPayouts ensures the coinbases of generated blocks cycle
+// through the mining addresses according to the payout rotation.
+func TestGenerateNBlocksPayouts(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	addrs := make([]btcutil.Address, 3)
+	pkScripts := make([][]byte, len(addrs))
+	for i := range addrs {
+		addr, err := btcutil.NewAddressPubKeyHash(
+			bytes.Repeat([]byte{byte(i + 1)}, 20), params)
+		if err != nil {
+			t.Fatalf("unable to create address: %v", err)
+		}
+		addrs[i] = addr
+		pkScripts[i], err = txscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Fatalf("unable to create script: %v", err)
+		}
+	}
+
+	tests := []struct {
+		rotation mining.PayoutRotation
+		want     []int
+	}{{
+		rotation: mining.PayoutRoundRobin,
+		want:     []int{0, 1, 2, 0, 1, 2},
+	}, {
+		// Block heights start at 1.
+		rotation: mining.PayoutByHeight,
+		want:     []int{1, 2, 0, 1, 2, 0},
+	}}
+
+	for _, test := range tests {
+		chain := newTestChain(t, params)
+
+		policy := mining.Policy{
+			BlockMaxWeight: blockchain.MaxBlockWeight - 4000,
+			BlockMaxSize:   blockchain.MaxBlockBaseSize - 1000,
+		}
+		generator := mining.NewBlkTmplGenerator(&policy, params,
+			emptyTxSource{}, chain, blockchain.NewMedianTime(), nil,
+			nil)
+		payouts := mining.NewPayoutAddrs(addrs, test.rotation)
+		chain.Subscribe(payouts.HandleBlockchainNotification)
+		miner := New(&Config{
+			ChainParams:            params,
+			BlockTemplateGenerator: generator,
+			MiningAddrs:            addrs,
+			Payouts:                payouts,
+			ProcessBlock: func(block *btcutil.Block, flags blockchain.BehaviorFlags) (bool, error) {
+				_, isOrphan, err := chain.ProcessBlock(block, flags)
+				return isOrphan, err
+			},
+			ConnectedCount: func() int32 { return 1 },
+			IsCurrent:      func() bool { return true },
+		})
+
+		hashes, err := miner.GenerateNBlocks(uint32(len(test.want)))
+		if err != nil {
+			t.Fatalf("%v: unable to generate blocks: %v", test.rotation,
+				err)
+		}
+		if height := chain.BestSnapshot().Height; height != int32(len(test.want)) {
+			t.Fatalf("%v: unexpected best height -- got %d, want %d",
+				test.rotation, height, len(test.want))
+		}
+		for i, hash := range hashes {
+			block, err := chain.BlockByHash(hash)
+			if err != nil {
+				t.Fatalf("%v: unable to fetch block: %v",
+					test.rotation, err)
+			}
+			pkScript := block.MsgBlock().Transactions[0].TxOut[0].PkScript
+			if !bytes.Equal(pkScript, pkScripts[test.want[i]]) {
+				t.Fatalf("%v: block %d pays to %x, want address %d",
+					test.rotation, i+1, pkScript, test.want[i])
+			}
+		}
+	}
+}
+
+// TestGenerateEmptyMempool ensures a block template built while the mempool
+// is empty yields a coinbase-only block which the chain accepts.
+func TestGenerateEmptyMempool(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	addr, err := btcutil.NewAddressPubKeyHash(make([]byte, 20), params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+
+	chain := newTestChain(t, params)
+
+	txPool := mempool.New(&mempool.Config{
+		ChainParams:   params,
+		FetchUtxoView: chain.FetchUtxoView,
+		BestHeight:    func() int32 { return chain.BestSnapshot().Height },
+		MedianTimePast: func() time.Time {
+			return chain.BestSnapshot().MedianTime
+		},
+	})
+	if count := txPool.Count(); count != 0 {
+		t.Fatalf("unexpected mempool size -- got %d, want 0", count)
+	}
+
+	policy := mining.Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight - 4000,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize - 1000,
+	}
+	generator := mining.NewBlkTmplGenerator(&policy, params, txPool, chain,
+		blockchain.NewMedianTime(), nil, nil)
+	miner := New(&Config{
+		ChainParams:            params,
+		BlockTemplateGenerator: generator,
+		MiningAddrs:            []btcutil.Address{addr},
+		ProcessBlock: func(block *btcutil.Block, flags blockchain.BehaviorFlags) (bool, error) {
+			_, isOrphan, err := chain.ProcessBlock(block, flags)
+			return isOrphan, err
+		},
+		ConnectedCount: func() int32 { return 1 },
+		IsCurrent:      func() bool { return true },
+	})
+
+	hashes, err := miner.GenerateNBlocks(1)
+	if err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	best := chain.BestSnapshot()
+	if best.Height != 1 || best.Hash != *hashes[0] {
+		t.Fatalf("generated block not connected -- best %v (height "+
+			"%d), want %v (height 1)", best.Hash, best.Height,
+			hashes[0])
+	}
+	block, err := chain.BlockByHash(hashes[0])
+	if err != nil {
+		t.Fatalf("unable to fetch block: %v", err)
+	}
+	txns := block.MsgBlock().Transactions
+	if len(txns) != 1 || !blockchain.IsCoinBaseTx(txns[0]) {
+		t.Fatalf("expected a coinbase-only block, got %d transactions",
+			len(txns))
+	}
+}
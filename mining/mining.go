@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"container/heap"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/btcsuite/btcd/blockchain"
@@ -23,9 +24,14 @@ const (
 	// transaction to be considered high priority.
 	MinHighPriority = btcutil.SatoshiPerBitcoin * 144.0 / 250
 
-	// blockHeaderOverhead is the max number of bytes it takes to serialize
-	// a block header and max possible transaction count.
-	blockHeaderOverhead = wire.MaxBlockHeaderPayload + wire.MaxVarIntPayload
+	// vbTopBits defines the bits set in the block version to signal that
+	// the version bits scheme is being used.
+	vbTopBits = 0x20000000
+
+	// vbTopMask is the bitmask of the top bits of the block version which
+	// are reserved to indicate whether or not the version bits scheme is in
+	// use.  The remaining bits are available for signalling.
+	vbTopMask = 0xe0000000
 
 	// CoinbaseFlags is added to the coinbase script of a generated block
 	// and is used to monitor BIP16 support as well as blocks that are
@@ -70,6 +76,12 @@ type TxSource interface {
 	// HaveTransaction returns whether or not the passed transaction hash
 	// exists in the source pool.
 	HaveTransaction(hash *chainhash.Hash) bool
+
+	// FetchInputUtxos returns a view containing the unspent outputs from
+	// the main chain referenced by the inputs of the passed transaction in
+	// the source pool.  Outputs of other transactions in the source pool
+	// must not be included.
+	FetchInputUtxos(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error)
 }
 
 // txPrioItem houses a transaction along with extra information that allows the
@@ -78,6 +90,7 @@ type TxSource interface {
 type txPrioItem struct {
 	tx       *btcutil.Tx
 	fee      int64
+	vsize    int64
 	priority float64
 	feePerKB int64
 
@@ -237,11 +250,25 @@ func mergeUtxoView(viewA *blockchain.UtxoViewpoint, viewB *blockchain.UtxoViewpo
 // standardCoinbaseScript returns a standard script suitable for use as the
 // signature script of the coinbase transaction of a new block.  In particular,
 // it starts with the block height that is required by version 2 blocks and adds
-// the extra nonce as well as additional coinbase flags.
+// the extra nonce as well as additional coinbase flags.  An error is returned
+// when the resulting script would violate the consensus limits on the length
+// of coinbase scripts.
 func standardCoinbaseScript(nextBlockHeight int32, extraNonce uint64) ([]byte, error) {
-	return txscript.NewScriptBuilder().AddInt64(int64(nextBlockHeight)).
-		AddInt64(int64(extraNonce)).AddData([]byte(CoinbaseFlags)).
-		Script()
+	coinbaseScript, err := txscript.NewScriptBuilder().
+		AddInt64(int64(nextBlockHeight)).AddInt64(int64(extraNonce)).
+		AddData([]byte(CoinbaseFlags)).Script()
+	if err != nil {
+		return nil, err
+	}
+	if len(coinbaseScript) < blockchain.MinCoinbaseScriptLen ||
+		len(coinbaseScript) > blockchain.MaxCoinbaseScriptLen {
+
+		return nil, fmt.Errorf("coinbase transaction script length "+
+			"of %d is out of range (min: %d, max: %d)",
+			len(coinbaseScript), blockchain.MinCoinbaseScriptLen,
+			blockchain.MaxCoinbaseScriptLen)
+	}
+	return coinbaseScript, nil
 }
 
 // createCoinbaseTx returns a coinbase transaction paying an appropriate subsidy
@@ -301,6 +328,18 @@ func spendTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil.Tx, height
 	return nil
 }
 
+// composeBlockVersion returns the passed block version with the provided
+// version bits also set.  The top bits of the version, which identify the
+// version bits scheme, are left untouched and any of the provided bits that
+// overlap them are ignored.  The version is returned unmodified when it does not
+// use the version bits scheme since there is no way to signal anything then.
+func composeBlockVersion(version int32, signalBits uint32) int32 {
+	if uint32(version)&vbTopMask != vbTopBits {
+		return version
+	}
+	return int32(uint32(version) | signalBits&^vbTopMask)
+}
+
 // logSkippedDeps logs any dependencies which are also skipped as a result of
 // skipping a transaction while generating a block template at the trace level.
 func logSkippedDeps(tx *btcutil.Tx, deps map[chainhash.Hash]*txPrioItem) {
@@ -314,6 +353,89 @@ func logSkippedDeps(tx *btcutil.Tx, deps map[chainhash.Hash]*txPrioItem) {
 	}
 }
 
+// txPackage houses the combined fee and virtual size of a transaction along
+// with the descendants that are selected together with it.
+type txPackage struct {
+	fee   int64
+	vsize int64
+}
+
+// feePerKB returns the fee per kilobyte of the package.
+func (p txPackage) feePerKB() int64 {
+	if p.vsize == 0 {
+		return 0
+	}
+	return p.fee * 1000 / p.vsize
+}
+
+// calcPackage returns the package with the highest fee per kilobyte that is
+// rooted at the passed item and made up of the item along with descendants that
+// only depend on it.  The packages of the descendants are memoized in the
+// passed map.
+//
+// Descendants that depend on more than one transaction in the source pool are
+// never included in a package since they can't be selected until all of their
+// dependencies are, and including them would count their fees toward multiple
+// competing packages.
+func calcPackage(item *txPrioItem, dependers map[chainhash.Hash]map[chainhash.Hash]*txPrioItem,
+	packages map[chainhash.Hash]txPackage) txPackage {
+
+	if pkg, ok := packages[*item.tx.Hash()]; ok {
+		return pkg
+	}
+
+	// Gather the packages of the children which only depend on this item.
+	var children []txPackage
+	for _, child := range dependers[*item.tx.Hash()] {
+		if len(child.dependsOn) != 1 {
+			continue
+		}
+		children = append(children, calcPackage(child, dependers,
+			packages))
+	}
+
+	// Add the child packages in order of decreasing fee per kilobyte for
+	// as long as they increase the fee per kilobyte of the package.
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].feePerKB() > children[j].feePerKB()
+	})
+	pkg := txPackage{fee: item.fee, vsize: item.vsize}
+	for _, child := range children {
+		if child.feePerKB() <= pkg.feePerKB() {
+			break
+		}
+		pkg.fee += child.fee
+		pkg.vsize += child.vsize
+	}
+
+	packages[*item.tx.Hash()] = pkg
+	return pkg
+}
+
+// applyPackageFeeRates raises the fee per kilobyte of each of the passed items
+// to that of the highest fee per kilobyte package rooted at it when that is
+// higher.  This allows transactions that pay high fees to be mined along with
+// the lower fee transactions they depend on (child pays for parent) since the
+// dependencies are otherwise selected based on their own fees alone.
+//
+// The selection order still ensures transactions are added after the
+// transactions they depend on since a boosted package rate never exceeds the
+// rate of the descendants that raised it.
+func applyPackageFeeRates(items []*txPrioItem,
+	dependers map[chainhash.Hash]map[chainhash.Hash]*txPrioItem) {
+
+	packages := make(map[chainhash.Hash]txPackage, len(items))
+	for _, item := range items {
+		pkgFeePerKB := calcPackage(item, dependers, packages).feePerKB()
+		if pkgFeePerKB > item.feePerKB {
+			log.Tracef("Raising fee per kilobyte of tx %s from %d to "+
+				"%d for its descendants", item.tx.Hash(),
+				item.feePerKB, pkgFeePerKB)
+			item.feePerKB = pkgFeePerKB
+		}
+	}
+}
+
 // MinimumMedianTime returns the minimum allowed timestamp for a block building
 // on the end of the provided best chain.  In particular, it is one second after
 // the median timestamp of the last several blocks per the chain consensus
@@ -392,8 +514,11 @@ func NewBlkTmplGenerator(policy *Policy, params *chaincfg.Params,
 // value, age of inputs, and size.  Transactions which consist of larger
 // amounts, older inputs, and small sizes have the highest priority.  Second, a
 // fee per kilobyte is calculated for each transaction.  Transactions with a
-// higher fee per kilobyte are preferred.  Finally, the block generation related
-// policy settings are all taken into account.
+// higher fee per kilobyte are preferred.  The fee per kilobyte of a transaction
+// with descendants in the source pool that only depend on it is raised to that
+// of the package made up of the transaction and those descendants when that is
+// higher, so a child is able to pay for its parent.  Finally, the block
+// generation related policy settings are all taken into account.
 //
 // Transactions which only spend outputs from other transactions already in the
 // block chain are immediately added to a priority queue which either
@@ -489,6 +614,7 @@ func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress btcutil.Address) (*Bloc
 	// determine which dependent transactions are now eligible for inclusion
 	// in the block once each transaction has been included.
 	dependers := make(map[chainhash.Hash]map[chainhash.Hash]*txPrioItem)
+	prioItems := make([]*txPrioItem, 0, len(sourceTxns))
 
 	// Create slices to hold the fees and number of signature operations
 	// for each of the selected transactions and add an entry for the
@@ -525,7 +651,7 @@ mempoolLoop:
 		// mempool since a transaction which depends on other
 		// transactions in the mempool must come after those
 		// dependencies in the final generated block.
-		utxos, err := g.chain.FetchUtxoView(tx)
+		utxos, err := g.txSource.FetchInputUtxos(tx)
 		if err != nil {
 			log.Warnf("Unable to fetch utxo view for tx %s: %v",
 				tx.Hash(), err)
@@ -578,12 +704,10 @@ mempoolLoop:
 		// Calculate the fee in Satoshi/kB.
 		prioItem.feePerKB = txDesc.FeePerKB
 		prioItem.fee = txDesc.Fee
-
-		// Add the transaction to the priority queue to mark it ready
-		// for inclusion in the block unless it has dependencies.
-		if prioItem.dependsOn == nil {
-			heap.Push(priorityQueue, prioItem)
-		}
+		prioItem.vsize = (blockchain.GetTransactionWeight(tx) +
+			blockchain.WitnessScaleFactor - 1) /
+			blockchain.WitnessScaleFactor
+		prioItems = append(prioItems, prioItem)
 
 		// Merge the referenced outputs from the input transactions to
 		// this transaction into the block utxo view.  This allows the
@@ -591,15 +715,23 @@ mempoolLoop:
 		mergeUtxoView(blockUtxos, utxos)
 	}
 
+	// Account for the fees of descendants when prioritizing transactions
+	// by fee and add the transactions to the priority queue to mark them
+	// ready for inclusion in the block unless they have dependencies.
+	applyPackageFeeRates(prioItems, dependers)
+	for _, prioItem := range prioItems {
+		if prioItem.dependsOn == nil {
+			heap.Push(priorityQueue, prioItem)
+		}
+	}
+
 	log.Tracef("Priority queue len %d, dependers len %d",
 		priorityQueue.Len(), len(dependers))
 
-	// The starting block size is the size of the block header plus the max
-	// possible transaction count size, plus the size of the coinbase
-	// transaction.
-	blockWeight := uint32((blockHeaderOverhead * blockchain.WitnessScaleFactor) +
-		blockchain.GetTransactionWeight(coinbaseTx))
-	blockSigOpCost := coinbaseSigOpCost
+	// The starting block size is the size of the block header plus the
+	// size of the coinbase transaction.
+	var blockSize BlockSizeAccounting
+	blockSize.AddTx(coinbaseTx, coinbaseSigOpCost)
 	totalFees := int64(0)
 
 	// Query the version bits state to see if segwit has been activated, if
@@ -649,13 +781,10 @@ mempoolLoop:
 			})
 
 			// In order to accurately account for the weight
-			// addition due to this coinbase transaction, we'll add
-			// the difference of the transaction before and after
-			// the addition of the commitment to the block weight.
-			weightDiff := blockchain.GetTransactionWeight(coinbaseCopy) -
-				blockchain.GetTransactionWeight(coinbaseTx)
-
-			blockWeight += uint32(weightDiff)
+			// addition due to this coinbase transaction, we'll
+			// account for the model coinbase instead of the
+			// current one.
+			blockSize.ReplaceTx(coinbaseTx, coinbaseCopy)
 
 			witnessIncluded = true
 		}
@@ -663,11 +792,9 @@ mempoolLoop:
 		// Grab any transactions which depend on this one.
 		deps := dependers[*tx.Hash()]
 
-		// Enforce maximum block size.  Also check for overflow.
-		txWeight := uint32(blockchain.GetTransactionWeight(tx))
-		blockPlusTxWeight := blockWeight + txWeight
-		if blockPlusTxWeight < blockWeight ||
-			blockPlusTxWeight >= g.policy.BlockMaxWeight {
+		// Enforce maximum block size.
+		blockPlusTxWeight := blockSize.WeightWithTx(tx)
+		if blockPlusTxWeight >= int64(g.policy.BlockMaxWeight) {
 
 			log.Tracef("Skipping tx %s because it would exceed "+
 				"the max block weight", tx.Hash())
@@ -685,8 +812,9 @@ mempoolLoop:
 			logSkippedDeps(tx, deps)
 			continue
 		}
-		if blockSigOpCost+int64(sigOpCost) < blockSigOpCost ||
-			blockSigOpCost+int64(sigOpCost) > blockchain.MaxBlockSigOpsCost {
+		if blockSize.SigOpCost()+int64(sigOpCost) >
+			blockchain.MaxBlockSigOpsCost {
+
 			log.Tracef("Skipping tx %s because it would "+
 				"exceed the maximum sigops per block", tx.Hash())
 			logSkippedDeps(tx, deps)
@@ -697,7 +825,7 @@ mempoolLoop:
 		// minimum block size.
 		if sortedByFee &&
 			prioItem.feePerKB < int64(g.policy.TxMinFreeFee) &&
-			blockPlusTxWeight >= g.policy.BlockMinWeight {
+			blockPlusTxWeight >= int64(g.policy.BlockMinWeight) {
 
 			log.Tracef("Skipping tx %s with feePerKB %d "+
 				"< TxMinFreeFee %d and block weight %d >= "+
@@ -711,7 +839,7 @@ mempoolLoop:
 		// Prioritize by fee per kilobyte once the block is larger than
 		// the priority size or there are no more high-priority
 		// transactions.
-		if !sortedByFee && (blockPlusTxWeight >= g.policy.BlockPrioritySize ||
+		if !sortedByFee && (blockPlusTxWeight >= int64(g.policy.BlockPrioritySize) ||
 			prioItem.priority <= MinHighPriority) {
 
 			log.Tracef("Switching to sort by fees per "+
@@ -729,7 +857,7 @@ mempoolLoop:
 			// is too low.  Otherwise this transaction will be the
 			// final one in the high-priority section, so just fall
 			// though to the code below so it is added now.
-			if blockPlusTxWeight > g.policy.BlockPrioritySize ||
+			if blockPlusTxWeight > int64(g.policy.BlockPrioritySize) ||
 				prioItem.priority < MinHighPriority {
 
 				heap.Push(priorityQueue, prioItem)
@@ -767,8 +895,7 @@ mempoolLoop:
 		// save the fees and signature operation counts to the block
 		// template.
 		blockTxns = append(blockTxns, tx)
-		blockWeight += txWeight
-		blockSigOpCost += int64(sigOpCost)
+		blockSize.AddTx(tx, int64(sigOpCost))
 		totalFees += prioItem.fee
 		txFees = append(txFees, prioItem.fee)
 		txSigOpCosts = append(txSigOpCosts, int64(sigOpCost))
@@ -790,11 +917,7 @@ mempoolLoop:
 	}
 
 	// Now that the actual transactions have been selected, update the
-	// block weight for the real transaction count and coinbase value with
-	// the total fees accordingly.
-	blockWeight -= wire.MaxVarIntPayload -
-		(uint32(wire.VarIntSerializeSize(uint64(len(blockTxns)))) *
-			blockchain.WitnessScaleFactor)
+	// coinbase value with the total fees accordingly.
 	coinbaseTx.MsgTx().TxOut[0].Value += totalFees
 	txFees[0] = -totalFees
 
@@ -821,14 +944,15 @@ mempoolLoop:
 	if err != nil {
 		return nil, err
 	}
+	nextBlockVersion = composeBlockVersion(nextBlockVersion,
+		g.policy.BlockVersionBits)
 
 	// Create a new block ready to be solved.
-	merkles := blockchain.BuildMerkleTreeStore(blockTxns, false)
 	var msgBlock wire.MsgBlock
 	msgBlock.Header = wire.BlockHeader{
 		Version:    nextBlockVersion,
 		PrevBlock:  best.Hash,
-		MerkleRoot: *merkles[len(merkles)-1],
+		MerkleRoot: blockchain.CalcMerkleRoot(blockTxns),
 		Timestamp:  ts,
 		Bits:       reqDifficulty,
 	}
@@ -849,8 +973,9 @@ mempoolLoop:
 
 	log.Debugf("Created new block template (%d transactions, %d in "+
 		"fees, %d signature operations cost, %d weight, target difficulty "+
-		"%064x)", len(msgBlock.Transactions), totalFees, blockSigOpCost,
-		blockWeight, blockchain.CompactToBig(msgBlock.Header.Bits))
+		"%064x)", len(msgBlock.Transactions), totalFees,
+		blockSize.SigOpCost(), blockSize.Weight(),
+		blockchain.CompactToBig(msgBlock.Header.Bits))
 
 	return &BlockTemplate{
 		Block:             &msgBlock,
@@ -905,6 +1030,14 @@ func AddWitnessCommitment(coinbaseTx *btcutil.Tx,
 	return witnessCommitment
 }
 
+// NewBlockTemplateForPayouts returns a new block template like NewBlockTemplate
+// with a coinbase that pays to the address the passed payout addresses choose
+// for the height of the block.
+func (g *BlkTmplGenerator) NewBlockTemplateForPayouts(payouts *PayoutAddrs) (*BlockTemplate, error) {
+	nextBlockHeight := g.chain.BestSnapshot().Height + 1
+	return g.NewBlockTemplate(payouts.Addr(nextBlockHeight))
+}
+
 // UpdateBlockTime updates the timestamp in the header of the passed block to
 // the current time while taking into account the median time of the last
 // several blocks to ensure the new time is after that time per the chain
@@ -939,12 +1072,6 @@ func (g *BlkTmplGenerator) UpdateExtraNonce(msgBlock *wire.MsgBlock, blockHeight
 	if err != nil {
 		return err
 	}
-	if len(coinbaseScript) > blockchain.MaxCoinbaseScriptLen {
-		return fmt.Errorf("coinbase transaction script length "+
-			"of %d is out of range (min: %d, max: %d)",
-			len(coinbaseScript), blockchain.MinCoinbaseScriptLen,
-			blockchain.MaxCoinbaseScriptLen)
-	}
 	msgBlock.Transactions[0].TxIn[0].SignatureScript = coinbaseScript
 
 	// TODO(davec): A btcutil.Block should use saved in the state to avoid
@@ -953,8 +1080,7 @@ func (g *BlkTmplGenerator) UpdateExtraNonce(msgBlock *wire.MsgBlock, blockHeight
 
 	// Recalculate the merkle root with the updated extra nonce.
 	block := btcutil.NewBlock(msgBlock)
-	merkles := blockchain.BuildMerkleTreeStore(block.Transactions(), false)
-	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	msgBlock.Header.MerkleRoot = blockchain.CalcMerkleRoot(block.Transactions())
 	return nil
 }
 
@@ -974,3 +1100,10 @@ func (g *BlkTmplGenerator) BestSnapshot() *blockchain.BestState {
 func (g *BlkTmplGenerator) TxSource() TxSource {
 	return g.txSource
 }
+
+// Policy returns the policy used to generate block templates.
+//
+// This function is safe for concurrent access.
+func (g *BlkTmplGenerator) Policy() *Policy {
+	return g.policy
+}
@@ -5,10 +5,21 @@
 package mining
 
 import (
+	"bytes"
 	"container/heap"
+	"crypto/sha256"
 	"math/rand"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 )
 
@@ -108,3 +119,318 @@ func TestTxFeePrioHeap(t *testing.T) {
 		highest = prioItem
 	}
 }
+
+// TestPackageFeeRates ensures transactions with descendants that pay higher
+// fees are selected ahead of competing transactions with a lower combined fee
+// rate, that descendants with multiple dependencies don't boost any of them,
+// and that transactions are still selected after their dependencies.
+func TestPackageFeeRates(t *testing.T) {
+	// Create items and the dependency map in the same way as the block
+	// template generator.
+	dependers := make(map[chainhash.Hash]map[chainhash.Hash]*txPrioItem)
+	var nextPrevOut byte
+	newItem := func(fee, vsize int64, parents ...*txPrioItem) *txPrioItem {
+		nextPrevOut++
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{
+			Hash: chainhash.Hash{nextPrevOut},
+		}, nil, nil))
+		for _, parent := range parents {
+			tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(
+				parent.tx.Hash(), 0), nil, nil))
+		}
+		tx.AddTxOut(wire.NewTxOut(1000, nil))
+
+		item := &txPrioItem{
+			tx:       btcutil.NewTx(tx),
+			fee:      fee,
+			vsize:    vsize,
+			feePerKB: fee * 1000 / vsize,
+		}
+		for _, parent := range parents {
+			parentHash := *parent.tx.Hash()
+			if dependers[parentHash] == nil {
+				dependers[parentHash] = make(
+					map[chainhash.Hash]*txPrioItem)
+			}
+			dependers[parentHash][*item.tx.Hash()] = item
+			if item.dependsOn == nil {
+				item.dependsOn = make(map[chainhash.Hash]struct{})
+			}
+			item.dependsOn[parentHash] = struct{}{}
+		}
+		return item
+	}
+
+	// The parent pays no fee, but its child pays enough for the package
+	// to beat the competing transaction.  The child of both the parent and
+	// the competing transaction pays a huge fee, but must not be counted
+	// toward either of them.
+	parent := newItem(0, 200)
+	child := newItem(6000, 200, parent)
+	competitor := newItem(2000, 200)
+	lowChild := newItem(100, 200, parent)
+	shared := newItem(1000000, 200, parent, competitor)
+	items := []*txPrioItem{shared, lowChild, competitor, child, parent}
+
+	applyPackageFeeRates(items, dependers)
+
+	tests := []struct {
+		name string
+		item *txPrioItem
+		want int64
+	}{
+		{name: "parent", item: parent, want: 6000 * 1000 / 400},
+		{name: "child", item: child, want: 6000 * 1000 / 200},
+		{name: "competitor", item: competitor, want: 2000 * 1000 / 200},
+		{name: "low fee child", item: lowChild, want: 100 * 1000 / 200},
+		{name: "shared child", item: shared, want: 1000000 * 1000 / 200},
+	}
+	for _, test := range tests {
+		if test.item.feePerKB != test.want {
+			t.Errorf("%s: unexpected fee per kilobyte - got %d, "+
+				"want %d", test.name, test.item.feePerKB,
+				test.want)
+		}
+	}
+
+	// Select the transactions in the same way as the block template
+	// generator and ensure the package is selected ahead of the competing
+	// transaction and that every transaction follows its dependencies.
+	priorityQueue := newTxPriorityQueue(len(items), true)
+	for _, item := range items {
+		if item.dependsOn == nil {
+			heap.Push(priorityQueue, item)
+		}
+	}
+	var selected []*txPrioItem
+	for priorityQueue.Len() > 0 {
+		item := heap.Pop(priorityQueue).(*txPrioItem)
+		selected = append(selected, item)
+		for _, dep := range dependers[*item.tx.Hash()] {
+			delete(dep.dependsOn, *item.tx.Hash())
+			if len(dep.dependsOn) == 0 {
+				heap.Push(priorityQueue, dep)
+			}
+		}
+	}
+	want := []*txPrioItem{parent, child, competitor, shared, lowChild}
+	if len(selected) != len(want) {
+		t.Fatalf("unexpected number of selected transactions - got %d, "+
+			"want %d", len(selected), len(want))
+	}
+	for i := range want {
+		if selected[i] != want[i] {
+			t.Fatalf("unexpected transaction selected at index %d - "+
+				"got %v, want %v", i, selected[i].tx.Hash(),
+				want[i].tx.Hash())
+		}
+	}
+}
+
+// TestComposeBlockVersion ensures the configured version bits are signalled in
+// the block version while the bits identifying the version bits scheme are
+// preserved.
+func TestComposeBlockVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    int32
+		signalBits uint32
+		want       int32
+	}{{
+		name:       "no bits to signal",
+		version:    0x20000001,
+		signalBits: 0,
+		want:       0x20000001,
+	}, {
+		name:       "signal deployment bit",
+		version:    0x20000000,
+		signalBits: 1 << 1,
+		want:       0x20000002,
+	}, {
+		name:       "signal alongside deployment being voted on",
+		version:    0x20000001,
+		signalBits: 1<<1 | 1<<28,
+		want:       0x30000003,
+	}, {
+		name:       "top bits are preserved",
+		version:    0x20000000,
+		signalBits: 0xe0000004,
+		want:       0x20000004,
+	}, {
+		name:       "version without version bits is unmodified",
+		version:    4,
+		signalBits: 1 << 1,
+		want:       4,
+	}}
+
+	for _, test := range tests {
+		got := composeBlockVersion(test.version, test.signalBits)
+		if got != test.want {
+			t.Errorf("%s: unexpected version - got %08x, want %08x",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestStandardCoinbaseScript ensures the coinbase flags advertised to miners
+// via the coinbaseaux field of getblocktemplate are placed right after the
+// block height and extra nonce in generated coinbase scripts and that the
+// resulting coinbase transactions are valid.
+func TestStandardCoinbaseScript(t *testing.T) {
+	flags, err := txscript.NewScriptBuilder().
+		AddData([]byte(CoinbaseFlags)).Script()
+	if err != nil {
+		t.Fatalf("unable to build coinbase flags: %v", err)
+	}
+
+	params := &chaincfg.MainNetParams
+	tests := []struct {
+		height     int32
+		extraNonce uint64
+	}{
+		{height: 1, extraNonce: 0},
+		{height: 227836, extraNonce: 1},
+		{height: 700000, extraNonce: 0xffffffffffffffff},
+	}
+	for _, test := range tests {
+		script, err := standardCoinbaseScript(test.height, test.extraNonce)
+		if err != nil {
+			t.Fatalf("height %d: unexpected error: %v", test.height, err)
+		}
+		prefix, err := txscript.NewScriptBuilder().
+			AddInt64(int64(test.height)).
+			AddInt64(int64(test.extraNonce)).Script()
+		if err != nil {
+			t.Fatalf("height %d: unable to build prefix: %v",
+				test.height, err)
+		}
+		if !bytes.HasPrefix(script, prefix) ||
+			!bytes.Equal(script[len(prefix):], flags) {
+
+			t.Fatalf("height %d: coinbase flags not at offset %d of "+
+				"script %x", test.height, len(prefix), script)
+		}
+
+		coinbaseTx, err := createCoinbaseTx(params, script, test.height,
+			nil)
+		if err != nil {
+			t.Fatalf("height %d: unable to create coinbase: %v",
+				test.height, err)
+		}
+		if err := blockchain.CheckTransactionSanity(coinbaseTx); err != nil {
+			t.Fatalf("height %d: invalid coinbase: %v", test.height,
+				err)
+		}
+		height, err := blockchain.ExtractCoinbaseHeight(coinbaseTx)
+		if err != nil {
+			t.Fatalf("height %d: unable to extract height: %v",
+				test.height, err)
+		}
+		if height != test.height {
+			t.Fatalf("unexpected coinbase height - got %d, want %d",
+				height, test.height)
+		}
+	}
+}
+
+// testTxSource is a TxSource that provides a fixed set of transactions which
+// spend outputs from a fixed utxo view.
+type testTxSource struct {
+	descs []*TxDesc
+	view  *blockchain.UtxoViewpoint
+}
+
+func (s *testTxSource) LastUpdated() time.Time               { return time.Time{} }
+func (s *testTxSource) MiningDescs() []*TxDesc               { return s.descs }
+func (s *testTxSource) HaveTransaction(*chainhash.Hash) bool { return false }
+
+func (s *testTxSource) FetchInputUtxos(*btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+	return s.view, nil
+}
+
+// TestNewBlockTemplateNoWitnessCommitment ensures a block template generated on
+// a chain where segwit is not active leaves out transactions with witness data
+// and has no witness commitment in its coinbase.
+func TestNewBlockTemplateNoWitnessCommitment(t *testing.T) {
+	// Segwit is not active for the block after the regression test
+	// network genesis block.
+	params := &chaincfg.RegressionNetParams
+	db, err := database.Create("ffldb", filepath.Join(t.TempDir(), "db"),
+		params.Net)
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+	defer db.Close()
+	timeSource := blockchain.NewMedianTime()
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: params,
+		TimeSource:  timeSource,
+	})
+	if err != nil {
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	// Create a transaction with a pay-to-witness-script-hash output and a
+	// transaction with witness data that spends it.  The funding
+	// transaction is only known to the transaction source, so the template
+	// would not connect to the chain if the witness transaction were
+	// included.
+	trueScript := []byte{txscript.OP_TRUE}
+	witnessScriptHash := sha256.Sum256(trueScript)
+	witnessPkScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).
+		AddData(witnessScriptHash[:]).Script()
+	if err != nil {
+		t.Fatalf("unable to create witness script: %v", err)
+	}
+	fundingTx := wire.NewMsgTx(wire.TxVersion)
+	fundingTx.AddTxIn(&wire.TxIn{})
+	fundingTx.AddTxOut(wire.NewTxOut(btcutil.SatoshiPerBitcoin,
+		witnessPkScript))
+	view := blockchain.NewUtxoViewpoint()
+	view.AddTxOuts(btcutil.NewTx(fundingTx), 0)
+
+	fundingHash := fundingTx.TxHash()
+	witnessTx := wire.NewMsgTx(wire.TxVersion)
+	witnessTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&fundingHash, 0),
+		Witness:          wire.TxWitness{trueScript},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	witnessTx.AddTxOut(wire.NewTxOut(btcutil.SatoshiPerBitcoin-10000,
+		trueScript))
+
+	policy := Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight - 4000,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize - 1000,
+	}
+	txSource := &testTxSource{
+		descs: []*TxDesc{{Tx: btcutil.NewTx(witnessTx), Fee: 10000}},
+		view:  view,
+	}
+	generator := NewBlkTmplGenerator(&policy, params, txSource, chain,
+		timeSource, txscript.NewSigCache(100), txscript.NewHashCache(100))
+	template, err := generator.NewBlockTemplate(nil)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate: unexpected error: %v", err)
+	}
+
+	// Ensure the transaction with witness data is not included and that
+	// the coinbase has no witness commitment.
+	txns := template.Block.Transactions
+	if len(txns) != 1 {
+		t.Fatalf("unexpected number of template transactions -- got "+
+			"%d, want 1", len(txns))
+	}
+	if template.WitnessCommitment != nil {
+		t.Fatalf("unexpected witness commitment %x",
+			template.WitnessCommitment)
+	}
+	for _, txOut := range txns[0].TxOut {
+		if bytes.HasPrefix(txOut.PkScript, blockchain.WitnessMagicBytes) {
+			t.Fatalf("unexpected witness commitment output %x",
+				txOut.PkScript)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcutil"
+)
+
+// PayoutRotation identifies how the address the coinbase of a generated block
+// pays to is chosen from a list of payout addresses.
+type PayoutRotation int
+
+const (
+	// PayoutRandom chooses one of the addresses at random for each block
+	// template.
+	PayoutRandom PayoutRotation = iota
+
+	// PayoutRoundRobin cycles through the addresses in order, moving on to
+	// the next address each time a block is connected to the main chain.
+	PayoutRoundRobin
+
+	// PayoutByHeight chooses the address at the height of the block modulo
+	// the number of addresses, so all templates for a block pay to the same
+	// address and consecutive blocks cycle through the addresses.
+	PayoutByHeight
+)
+
+// payoutRotationStrings is a map of payout rotations back to their names for
+// pretty printing.
+var payoutRotationStrings = map[PayoutRotation]string{
+	PayoutRandom:     "random",
+	PayoutRoundRobin: "roundrobin",
+	PayoutByHeight:   "height",
+}
+
+// String returns the PayoutRotation in human-readable form.
+func (r PayoutRotation) String() string {
+	if s, ok := payoutRotationStrings[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown PayoutRotation (%d)", int(r))
+}
+
+// ParsePayoutRotation returns the payout rotation for the passed name as
+// returned by PayoutRotation.String.
+func ParsePayoutRotation(name string) (PayoutRotation, error) {
+	for rotation, s := range payoutRotationStrings {
+		if s == name {
+			return rotation, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown payout rotation %q", name)
+}
+
+// PayoutAddrs chooses the address the coinbase of generated blocks pays to
+// from a list of addresses according to a payout rotation.  It allows the
+// rewards of generated blocks to be split between several addresses.
+//
+// The same instance should be shared by everything that generates block
+// templates so they agree on the address for the round robin rotation.
+//
+// It is safe for concurrent access.
+type PayoutAddrs struct {
+	mtx      sync.Mutex
+	addrs    []btcutil.Address
+	rotation PayoutRotation
+	next     int
+}
+
+// NewPayoutAddrs returns a new payout address chooser for the passed addresses
+// and rotation.
+func NewPayoutAddrs(addrs []btcutil.Address, rotation PayoutRotation) *PayoutAddrs {
+	return &PayoutAddrs{
+		addrs:    addrs,
+		rotation: rotation,
+	}
+}
+
+// Addr returns the address the coinbase of a block at the passed height pays
+// to.  Nil is returned when there are no addresses.
+func (p *PayoutAddrs) Addr(height int32) btcutil.Address {
+	if len(p.addrs) == 0 {
+		return nil
+	}
+
+	switch p.rotation {
+	case PayoutRoundRobin:
+		p.mtx.Lock()
+		addr := p.addrs[p.next]
+		p.mtx.Unlock()
+		return addr
+
+	case PayoutByHeight:
+		return p.addrs[int(height)%len(p.addrs)]
+	}
+
+	return p.addrs[rand.Intn(len(p.addrs))]
+}
+
+// HandleBlockchainNotification moves the round robin rotation on to the next
+// address when a block is connected to the main chain.  It is intended to be
+// subscribed to the notifications of the chain the blocks are generated for.
+func (p *PayoutAddrs) HandleBlockchainNotification(notification *blockchain.Notification) {
+	if notification.Type != blockchain.NTBlockConnected ||
+		p.rotation != PayoutRoundRobin || len(p.addrs) == 0 {
+
+		return
+	}
+
+	p.mtx.Lock()
+	p.next = (p.next + 1) % len(p.addrs)
+	p.mtx.Unlock()
+}
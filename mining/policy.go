@@ -45,6 +45,12 @@ type Policy struct {
 	// required for a transaction to be treated as free for mining purposes
 	// (block template generation).
 	TxMinFreeFee btcutil.Amount
+
+	// BlockVersionBits houses the version bits to signal in the version of
+	// generated block templates in addition to the bits set for the rule
+	// change deployments the chain is voting on.  Only the bits that are
+	// available to the version bits scheme are used.
+	BlockVersionBits uint32
 }
 
 // minInt is a helper function to return the minimum of two ints.  This avoids
@@ -0,0 +1,125 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// maxBlockArrivals is the maximum number of blocks arrival times are retained
+// for.  The arrival times of the oldest tracked blocks are evicted first.
+const maxBlockArrivals = 1000
+
+// BlockArrival houses the times a block was first seen by the sync manager.
+type BlockArrival struct {
+	// AnnounceTime is the time the block was first announced by a peer via
+	// either a block inventory vector or its header.  It is the zero time
+	// when the block was received without being announced first.
+	AnnounceTime time.Time
+
+	// ReceiveTime is the time the full block was first received.  It is the
+	// zero time when the block has only been announced so far.
+	ReceiveTime time.Time
+}
+
+// PropagationDelay returns the time that elapsed between the block first being
+// announced and the full block being received.  The boolean is false when
+// either time is unknown.
+func (a *BlockArrival) PropagationDelay() (time.Duration, bool) {
+	if a.AnnounceTime.IsZero() || a.ReceiveTime.IsZero() {
+		return 0, false
+	}
+	return a.ReceiveTime.Sub(a.AnnounceTime), true
+}
+
+// blockArrivalTracker records the time blocks are first announced and received
+// for a bounded number of recent blocks.
+type blockArrivalTracker struct {
+	mtx      sync.Mutex
+	arrivals map[chainhash.Hash]*BlockArrival
+
+	// order houses the hashes of the tracked blocks in the order they were
+	// first seen in a ring of maxBlockArrivals entries.  next is the index
+	// of the entry to evict and replace when the next block is tracked.
+	order []chainhash.Hash
+	next  int
+}
+
+// newBlockArrivalTracker returns a new, empty block arrival tracker.
+func newBlockArrivalTracker() *blockArrivalTracker {
+	return &blockArrivalTracker{
+		arrivals: make(map[chainhash.Hash]*BlockArrival),
+		order:    make([]chainhash.Hash, 0, maxBlockArrivals),
+	}
+}
+
+// arrival returns the arrival record for the passed block hash, creating it
+// and evicting the oldest record as needed when it is not already tracked.
+//
+// This function MUST be called with the tracker lock held.
+func (t *blockArrivalTracker) arrival(hash *chainhash.Hash) *BlockArrival {
+	if arrival, ok := t.arrivals[*hash]; ok {
+		return arrival
+	}
+
+	if len(t.order) < maxBlockArrivals {
+		t.order = append(t.order, *hash)
+	} else {
+		delete(t.arrivals, t.order[t.next])
+		t.order[t.next] = *hash
+		t.next = (t.next + 1) % maxBlockArrivals
+	}
+	arrival := &BlockArrival{}
+	t.arrivals[*hash] = arrival
+	return arrival
+}
+
+// Announced records the passed time as the time the block with the given hash
+// was announced unless an earlier announcement or the block itself was already
+// seen.
+//
+// This function is safe for concurrent access.
+func (t *blockArrivalTracker) Announced(hash *chainhash.Hash, now time.Time) {
+	t.mtx.Lock()
+	arrival := t.arrival(hash)
+	if arrival.AnnounceTime.IsZero() && arrival.ReceiveTime.IsZero() {
+		arrival.AnnounceTime = now
+	}
+	t.mtx.Unlock()
+}
+
+// Received records the passed time as the time the block with the given hash
+// was received unless it was already received before.  It returns the updated
+// arrival record.
+//
+// This function is safe for concurrent access.
+func (t *blockArrivalTracker) Received(hash *chainhash.Hash, now time.Time) BlockArrival {
+	t.mtx.Lock()
+	arrival := t.arrival(hash)
+	if arrival.ReceiveTime.IsZero() {
+		arrival.ReceiveTime = now
+	}
+	result := *arrival
+	t.mtx.Unlock()
+	return result
+}
+
+// Arrival returns the arrival record for the block with the given hash.  The
+// boolean is false when the block is not tracked.
+//
+// This function is safe for concurrent access.
+func (t *blockArrivalTracker) Arrival(hash *chainhash.Hash) (BlockArrival, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	arrival, ok := t.arrivals[*hash]
+	if !ok {
+		return BlockArrival{}, false
+	}
+	return *arrival, true
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// TestBlockArrivalTracker ensures the times blocks are first announced and
+// received are recorded, the propagation delay is computed from them, and the
+// number of tracked blocks is bounded.
+func TestBlockArrivalTracker(t *testing.T) {
+	tracker := newBlockArrivalTracker()
+	start := time.Unix(1600000000, 0)
+
+	// Announce a block twice and ensure only the first announcement is
+	// recorded and no delay is reported before the block is received.
+	announced := chainhash.Hash{0x01}
+	tracker.Announced(&announced, start)
+	tracker.Announced(&announced, start.Add(time.Second))
+	arrival, ok := tracker.Arrival(&announced)
+	if !ok {
+		t.Fatal("Arrival: announced block is not tracked")
+	}
+	if !arrival.AnnounceTime.Equal(start) {
+		t.Fatalf("unexpected announce time - got %v, want %v",
+			arrival.AnnounceTime, start)
+	}
+	if _, ok := arrival.PropagationDelay(); ok {
+		t.Fatal("PropagationDelay: reported delay for block that has " +
+			"not been received")
+	}
+
+	// Receive the block twice and ensure the delay is measured from the
+	// first announcement to the first time the block was received.
+	tracker.Received(&announced, start.Add(time.Millisecond*1500))
+	arrival = tracker.Received(&announced, start.Add(time.Second*5))
+	delay, ok := arrival.PropagationDelay()
+	if !ok || delay != time.Millisecond*1500 {
+		t.Fatalf("PropagationDelay: unexpected delay - got %v (%v), "+
+			"want %v", delay, ok, time.Millisecond*1500)
+	}
+
+	// Ensure a block that is received before being announced reports no
+	// delay and is not considered announced afterwards.
+	unannounced := chainhash.Hash{0x02}
+	tracker.Received(&unannounced, start)
+	tracker.Announced(&unannounced, start.Add(time.Second))
+	arrival, _ = tracker.Arrival(&unannounced)
+	if !arrival.AnnounceTime.IsZero() || !arrival.ReceiveTime.Equal(start) {
+		t.Fatalf("unexpected arrival for unannounced block - got %+v",
+			arrival)
+	}
+	if _, ok := arrival.PropagationDelay(); ok {
+		t.Fatal("PropagationDelay: reported delay for block that was " +
+			"not announced")
+	}
+
+	// Track enough additional blocks to fill the tracker and ensure the
+	// oldest blocks are evicted while the newest are retained.
+	hashN := func(i int) chainhash.Hash {
+		hash := chainhash.Hash{0x03}
+		binary.LittleEndian.PutUint32(hash[1:], uint32(i))
+		return hash
+	}
+	for i := 0; i < maxBlockArrivals; i++ {
+		hash := hashN(i)
+		tracker.Announced(&hash, start)
+	}
+	if _, ok := tracker.Arrival(&announced); ok {
+		t.Fatal("Arrival: oldest block was not evicted")
+	}
+	if _, ok := tracker.Arrival(&unannounced); ok {
+		t.Fatal("Arrival: second oldest block was not evicted")
+	}
+	newest := hashN(maxBlockArrivals - 1)
+	if _, ok := tracker.Arrival(&newest); !ok {
+		t.Fatal("Arrival: newest block is not tracked")
+	}
+	if len(tracker.arrivals) != maxBlockArrivals {
+		t.Fatalf("unexpected number of tracked blocks - got %d, want %d",
+			len(tracker.arrivals), maxBlockArrivals)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	peerpkg "github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// maxHighBandwidthPeers is the maximum number of peers that are asked to push
+// new blocks as compact blocks directly (high-bandwidth mode) per BIP0152.
+const maxHighBandwidthPeers = 3
+
+// cmpctBlockMsg packages a bitcoin cmpctblock message and the peer it came from
+// together so the block handler has access to that information.
+type cmpctBlockMsg struct {
+	cmpctBlock *wire.MsgCmpctBlock
+	peer       *peerpkg.Peer
+}
+
+// blockTxnMsg packages a bitcoin blocktxn message and the peer it came from
+// together so the block handler has access to that information.
+type blockTxnMsg struct {
+	blockTxn *wire.MsgBlockTxn
+	peer     *peerpkg.Peer
+}
+
+// partialBlock houses a block that is being reconstructed from a compact block
+// along with the indexes of the transactions that are still missing from it.
+type partialBlock struct {
+	header       wire.BlockHeader
+	txns         []*wire.MsgTx
+	missing      []uint32
+	numAmbiguous int
+}
+
+// shortTxIDFunc returns the short id of the passed transaction for a compact
+// block.
+type shortTxIDFunc func(tx *wire.MsgTx) uint64
+
+// newPartialBlock returns a partial block for the passed compact block.  The
+// prefilled transactions of the compact block are put in place and the
+// remaining transactions are looked up by their short ids among the passed
+// candidate transactions, which typically are those in the memory pool.  The
+// indexes of transactions that are not found are tracked as missing.
+func newPartialBlock(cmpctBlock *wire.MsgCmpctBlock, candidates []*wire.MsgTx) *partialBlock {
+	key := cmpctBlock.ShortIDKey()
+	return buildPartialBlock(cmpctBlock, candidates, func(tx *wire.MsgTx) uint64 {
+		hash := tx.WitnessHash()
+		return wire.ShortTxID(&key, &hash)
+	})
+}
+
+// buildPartialBlock returns a partial block for the passed compact block using
+// the passed function to compute the short ids of the candidate transactions.
+// See newPartialBlock for details.
+//
+// Short ids are only 48 bits, so distinct transactions may map to the same
+// short id.  Rather than guessing which transaction is the right one, any slot
+// whose short id is shared by multiple candidates or by multiple transactions
+// of the compact block is considered ambiguous and tracked as missing so it is
+// requested from the peer.
+func buildPartialBlock(cmpctBlock *wire.MsgCmpctBlock, candidates []*wire.MsgTx, shortTxID shortTxIDFunc) *partialBlock {
+	pb := &partialBlock{
+		header: cmpctBlock.Header,
+		txns:   make([]*wire.MsgTx, cmpctBlock.TxCount()),
+	}
+	for _, ptx := range cmpctBlock.PrefilledTxs {
+		pb.txns[ptx.Index] = ptx.Tx
+	}
+
+	// Map the short ids to the indexes of the transactions they represent
+	// which are the slots not taken by prefilled transactions.  Short ids
+	// that appear multiple times are ambiguous, so they are not mapped.
+	shortIDs := make(map[uint64]uint32, len(cmpctBlock.ShortIDs))
+	ambiguous := make(map[uint64]struct{})
+	var index uint32
+	for _, shortID := range cmpctBlock.ShortIDs {
+		for pb.txns[index] != nil {
+			index++
+		}
+		if _, exists := shortIDs[shortID]; exists {
+			ambiguous[shortID] = struct{}{}
+		}
+		shortIDs[shortID] = index
+		index++
+	}
+	for shortID := range ambiguous {
+		delete(shortIDs, shortID)
+	}
+
+	// Fill the slots from the candidates while ensuring slots that are
+	// matched by multiple distinct candidates are left empty.
+	var numCollisions int
+	for _, tx := range candidates {
+		shortID := shortTxID(tx)
+		index, ok := shortIDs[shortID]
+		if !ok {
+			continue
+		}
+		if pb.txns[index] != nil {
+			if pb.txns[index].WitnessHash() == tx.WitnessHash() {
+				continue
+			}
+			pb.txns[index] = nil
+			delete(shortIDs, shortID)
+			numCollisions++
+			continue
+		}
+		pb.txns[index] = tx
+	}
+	pb.numAmbiguous = numCollisions + len(ambiguous)
+
+	for i, tx := range pb.txns {
+		if tx == nil {
+			pb.missing = append(pb.missing, uint32(i))
+		}
+	}
+	return pb
+}
+
+// blockHash returns the hash of the block being reconstructed.
+func (pb *partialBlock) blockHash() chainhash.Hash {
+	return pb.header.BlockHash()
+}
+
+// getBlockTxnMsg returns a getblocktxn message that requests the transactions
+// still missing from the block.
+func (pb *partialBlock) getBlockTxnMsg() *wire.MsgGetBlockTxn {
+	blockHash := pb.blockHash()
+	return wire.NewMsgGetBlockTxn(&blockHash, pb.missing)
+}
+
+// fillMissing puts the transactions of the passed blocktxn message, which must
+// be the response to the message returned by getBlockTxnMsg, in place of the
+// missing transactions.
+func (pb *partialBlock) fillMissing(msg *wire.MsgBlockTxn) error {
+	if len(msg.Transactions) != len(pb.missing) {
+		return fmt.Errorf("blocktxn for block %v has %d transactions "+
+			"instead of the %d requested ones", msg.BlockHash,
+			len(msg.Transactions), len(pb.missing))
+	}
+	for i, index := range pb.missing {
+		pb.txns[index] = msg.Transactions[i]
+	}
+	pb.missing = nil
+	return nil
+}
+
+// block returns the reconstructed block once no transactions are missing.  An
+// error is returned when the transactions do not match the merkle root of the
+// block header, which happens when a short id matched the wrong transaction.
+func (pb *partialBlock) block() (*btcutil.Block, error) {
+	if len(pb.missing) != 0 {
+		return nil, fmt.Errorf("block %v is missing %d transactions",
+			pb.blockHash(), len(pb.missing))
+	}
+
+	msgBlock := wire.NewMsgBlock(&pb.header)
+	for _, tx := range pb.txns {
+		msgBlock.AddTransaction(tx)
+	}
+	block := btcutil.NewBlock(msgBlock)
+	merkleRoot := blockchain.CalcMerkleRoot(block.Transactions())
+	if !pb.header.MerkleRoot.IsEqual(&merkleRoot) {
+		return nil, fmt.Errorf("reconstructed transactions of block %v "+
+			"do not match its merkle root", block.Hash())
+	}
+	return block, nil
+}
+
+// supportsCmpctBlocks returns whether or not compact blocks may be exchanged
+// with the passed peer.  Only compact blocks that commit to witness data are
+// supported, so the peer must also support segregated witness.
+func supportsCmpctBlocks(peer *peerpkg.Peer) bool {
+	return peer.ProtocolVersion() >= wire.ShortIDsBlocksVersion &&
+		peer.IsWitnessEnabled()
+}
+
+// announceCmpctBlockSupport signals support for compact blocks to the passed
+// peer in low-bandwidth mode when the peer is capable of them.  Peers are only
+// switched to high-bandwidth mode once they deliver new blocks.
+func (sm *SyncManager) announceCmpctBlockSupport(peer *peerpkg.Peer) {
+	if !supportsCmpctBlocks(peer) {
+		return
+	}
+	peer.QueueMessage(wire.NewMsgSendCmpct(false, wire.CmpctBlockVersion),
+		nil)
+}
+
+// updateHighBandwidthPeers selects the passed peer, which just delivered a new
+// block, as one of the peers that push new blocks as compact blocks directly.
+// The peer that least recently delivered a new block is switched back to
+// low-bandwidth mode when the maximum number of high-bandwidth peers is
+// exceeded.
+func (sm *SyncManager) updateHighBandwidthPeers(peer *peerpkg.Peer) {
+	if !supportsCmpctBlocks(peer) || !peer.SupportsCmpctBlocks() {
+		return
+	}
+
+	// Move the peer to the back of the list when it is already a
+	// high-bandwidth peer so it is the last one to be evicted.
+	for i, hbPeer := range sm.cmpctHBPeers {
+		if hbPeer == peer {
+			copy(sm.cmpctHBPeers[i:], sm.cmpctHBPeers[i+1:])
+			sm.cmpctHBPeers[len(sm.cmpctHBPeers)-1] = peer
+			return
+		}
+	}
+
+	if len(sm.cmpctHBPeers) >= maxHighBandwidthPeers {
+		evicted := sm.cmpctHBPeers[0]
+		sm.cmpctHBPeers = sm.cmpctHBPeers[1:]
+		evicted.QueueMessage(wire.NewMsgSendCmpct(false,
+			wire.CmpctBlockVersion), nil)
+		log.Debugf("Switched peer %s to low-bandwidth compact block "+
+			"relay", evicted)
+	}
+
+	sm.cmpctHBPeers = append(sm.cmpctHBPeers, peer)
+	peer.QueueMessage(wire.NewMsgSendCmpct(true, wire.CmpctBlockVersion), nil)
+	log.Debugf("Switched peer %s to high-bandwidth compact block relay", peer)
+}
+
+// removeHighBandwidthPeer removes the passed peer from the high-bandwidth
+// compact block peers if needed.
+func (sm *SyncManager) removeHighBandwidthPeer(peer *peerpkg.Peer) {
+	for i, hbPeer := range sm.cmpctHBPeers {
+		if hbPeer == peer {
+			sm.cmpctHBPeers = append(sm.cmpctHBPeers[:i],
+				sm.cmpctHBPeers[i+1:]...)
+			return
+		}
+	}
+}
+
+// handleCmpctBlockMsg handles cmpctblock messages from all peers.  Blocks that
+// are not already known are reconstructed from the compact block and the
+// transactions in the memory pool.  Any transactions that are not available
+// are requested from the peer with a getblocktxn message.
+func (sm *SyncManager) handleCmpctBlockMsg(cmsg *cmpctBlockMsg) {
+	peer := cmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received cmpctblock message from unknown peer %s", peer)
+		return
+	}
+
+	blockHash := cmsg.cmpctBlock.Header.BlockHash()
+	iv := wire.NewInvVect(wire.InvTypeBlock, &blockHash)
+	peer.AddKnownInventory(iv)
+	if peer != sm.syncPeer || sm.current() {
+		peer.UpdateLastAnnouncedBlock(&blockHash)
+	}
+
+	// Ignore compact blocks from peers that aren't the sync peer if we are
+	// not current as well as while in headers-first mode.
+	if sm.headersFirstMode || (peer != sm.syncPeer && !sm.current()) {
+		return
+	}
+
+	haveInv, err := sm.haveInventory(iv)
+	if err != nil {
+		log.Warnf("Unexpected failure when checking for existing "+
+			"inventory during cmpctblock message processing: %v", err)
+		return
+	}
+	if haveInv {
+		return
+	}
+
+	// Ignore the block if there is already a pending request for it.
+	if _, exists := sm.requestedBlocks[blockHash]; exists {
+		return
+	}
+
+	// Abandon any block that is still being reconstructed from the peer so
+	// it can be requested again.
+	if state.partialBlock != nil {
+		prevHash := state.partialBlock.blockHash()
+		delete(state.requestedBlocks, prevHash)
+		sm.untrackBlockRequest(&prevHash)
+		state.partialBlock = nil
+	}
+	sm.trackBlockRequest(peer, state, &blockHash)
+
+	txDescs := sm.txMemPool.TxDescs()
+	candidates := make([]*wire.MsgTx, 0, len(txDescs))
+	for _, txDesc := range txDescs {
+		candidates = append(candidates, txDesc.Tx.MsgTx())
+	}
+	pb := newPartialBlock(cmsg.cmpctBlock, candidates)
+	if len(pb.missing) == 0 {
+		sm.processPartialBlock(peer, pb)
+		return
+	}
+
+	log.Debugf("Requesting %d of %d transactions of block %v from %s "+
+		"(%d ambiguous short ids)", len(pb.missing), len(pb.txns),
+		blockHash, peer, pb.numAmbiguous)
+	state.partialBlock = pb
+	peer.QueueMessage(pb.getBlockTxnMsg(), nil)
+}
+
+// handleBlockTxnMsg handles blocktxn messages from all peers.  The transactions
+// complete the block that is being reconstructed from the compact block the
+// peer sent previously.
+func (sm *SyncManager) handleBlockTxnMsg(bmsg *blockTxnMsg) {
+	peer := bmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received blocktxn message from unknown peer %s", peer)
+		return
+	}
+
+	pb := state.partialBlock
+	if pb == nil || pb.blockHash() != bmsg.blockTxn.BlockHash {
+		log.Debugf("Ignoring unrequested blocktxn for block %v from %s",
+			bmsg.blockTxn.BlockHash, peer)
+		return
+	}
+	state.partialBlock = nil
+
+	if err := pb.fillMissing(bmsg.blockTxn); err != nil {
+		log.Warnf("Invalid blocktxn from %s: %v -- disconnecting", peer,
+			err)
+		peer.Disconnect()
+		return
+	}
+	sm.processPartialBlock(peer, pb)
+}
+
+// processPartialBlock processes the block reconstructed from a compact block
+// received from the passed peer.  The full block is requested from the peer
+// instead when the reconstructed transactions turn out to be wrong.
+func (sm *SyncManager) processPartialBlock(peer *peerpkg.Peer, pb *partialBlock) {
+	block, err := pb.block()
+	if err != nil {
+		log.Debugf("Unable to reconstruct compact block from %s: %v -- "+
+			"requesting full block", peer, err)
+		blockHash := pb.blockHash()
+		gdmsg := wire.NewMsgGetData()
+		gdmsg.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock,
+			&blockHash))
+		peer.QueueMessage(gdmsg, nil)
+		return
+	}
+
+	sm.blockArrivals.Received(block.Hash(), time.Now())
+	sm.handleBlockMsg(&blockMsg{block: block, peer: peer})
+}
+
+// QueueCmpctBlock adds the passed cmpctblock message and peer to the block
+// handling queue.
+func (sm *SyncManager) QueueCmpctBlock(cmpctBlock *wire.MsgCmpctBlock, peer *peerpkg.Peer) {
+	// No channel handling here because peers do not need to block on
+	// cmpctblock messages.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	blockHash := cmpctBlock.Header.BlockHash()
+	sm.blockArrivals.Announced(&blockHash, time.Now())
+
+	sm.msgChan <- &cmpctBlockMsg{cmpctBlock: cmpctBlock, peer: peer}
+}
+
+// QueueBlockTxn adds the passed blocktxn message and peer to the block handling
+// queue.
+func (sm *SyncManager) QueueBlockTxn(blockTxn *wire.MsgBlockTxn, peer *peerpkg.Peer) {
+	// No channel handling here because peers do not need to block on
+	// blocktxn messages.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &blockTxnMsg{blockTxn: blockTxn, peer: peer}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// testCmpctBlockTx returns a transaction that spends the output of the passed
+// index of a fake previous transaction so each index results in a distinct
+// transaction.
+func testCmpctBlockTx(index uint32) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	prevOut := wire.NewOutPoint(&chainhash.Hash{0x01}, index)
+	tx.AddTxIn(wire.NewTxIn(prevOut, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(int64(index)+1000, []byte{0x51}))
+	return tx
+}
+
+// testCmpctBlock returns a block with a valid merkle root that consists of a
+// coinbase and the passed number of additional transactions.
+func testCmpctBlock(numTxns uint32) *wire.MsgBlock {
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	prevOut := wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex)
+	coinbase.AddTxIn(wire.NewTxIn(prevOut, []byte{0x51, 0x51}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, []byte{0x51}))
+
+	block := wire.NewMsgBlock(&wire.BlockHeader{Version: 1, Nonce: 1})
+	block.AddTransaction(coinbase)
+	for i := uint32(0); i < numTxns; i++ {
+		block.AddTransaction(testCmpctBlockTx(i))
+	}
+	merkles := blockchain.BuildMerkleTreeStore(
+		btcutil.NewBlock(block).Transactions(), false)
+	block.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return block
+}
+
+// TestPartialBlockReconstruction ensures blocks are reconstructed from compact
+// blocks using the available transactions, the missing transactions are
+// requested by their indexes, and the block is completed from the response.
+func TestPartialBlockReconstruction(t *testing.T) {
+	block := testCmpctBlock(4)
+	txns := block.Transactions
+	cmpctBlock := wire.NewMsgCmpctBlockFromBlock(block, 0x0102030405060708)
+
+	// Ensure the block is reconstructed right away when all of its
+	// transactions are available.
+	pb := newPartialBlock(cmpctBlock, txns[1:])
+	if len(pb.missing) != 0 {
+		t.Fatalf("unexpected missing transactions %v", pb.missing)
+	}
+	reconstructed, err := pb.block()
+	if err != nil {
+		t.Fatalf("unable to reconstruct block: %v", err)
+	}
+	if !reflect.DeepEqual(reconstructed.MsgBlock(), block) {
+		t.Fatal("reconstructed block does not match original block")
+	}
+
+	// Only make some of the transactions available along with an unrelated
+	// one and ensure the others are requested.
+	candidates := []*wire.MsgTx{txns[1], txns[3], testCmpctBlockTx(100)}
+	pb = newPartialBlock(cmpctBlock, candidates)
+	wantMissing := []uint32{2, 4}
+	if !reflect.DeepEqual(pb.missing, wantMissing) {
+		t.Fatalf("unexpected missing transactions - got %v, want %v",
+			pb.missing, wantMissing)
+	}
+	if _, err := pb.block(); err == nil {
+		t.Fatal("reconstructed block with missing transactions")
+	}
+	getBlockTxn := pb.getBlockTxnMsg()
+	if getBlockTxn.BlockHash != block.BlockHash() {
+		t.Fatalf("unexpected getblocktxn block hash - got %v, want %v",
+			getBlockTxn.BlockHash, block.BlockHash())
+	}
+	if !reflect.DeepEqual(getBlockTxn.Indexes, wantMissing) {
+		t.Fatalf("unexpected getblocktxn indexes - got %v, want %v",
+			getBlockTxn.Indexes, wantMissing)
+	}
+
+	// Ensure a response with the wrong number of transactions is rejected.
+	blockHash := block.BlockHash()
+	blockTxn := wire.NewMsgBlockTxn(&blockHash)
+	blockTxn.AddTransaction(txns[2])
+	if err := pb.fillMissing(blockTxn); err == nil {
+		t.Fatal("accepted blocktxn with too few transactions")
+	}
+
+	// Complete the block from the response and ensure it matches.
+	blockTxn.AddTransaction(txns[4])
+	if err := pb.fillMissing(blockTxn); err != nil {
+		t.Fatalf("unable to fill missing transactions: %v", err)
+	}
+	reconstructed, err = pb.block()
+	if err != nil {
+		t.Fatalf("unable to reconstruct block: %v", err)
+	}
+	if !reflect.DeepEqual(reconstructed.MsgBlock(), block) {
+		t.Fatal("reconstructed block does not match original block")
+	}
+
+	// Ensure a response with the wrong transactions is detected by the
+	// merkle root mismatch.
+	pb = newPartialBlock(cmpctBlock, candidates)
+	blockTxn = wire.NewMsgBlockTxn(&blockHash)
+	blockTxn.AddTransaction(txns[4])
+	blockTxn.AddTransaction(txns[2])
+	if err := pb.fillMissing(blockTxn); err != nil {
+		t.Fatalf("unable to fill missing transactions: %v", err)
+	}
+	if _, err := pb.block(); err == nil {
+		t.Fatal("reconstructed block with wrong transactions")
+	}
+}
+
+// TestPartialBlockShortIDCollisions ensures slots whose short ids are matched
+// by multiple candidate transactions or that appear multiple times in the
+// compact block are requested instead of guessing the transaction.
+func TestPartialBlockShortIDCollisions(t *testing.T) {
+	block := testCmpctBlock(4)
+	txns := block.Transactions
+	blockHash := block.BlockHash()
+
+	// Use a short id function that maps the transactions to fixed short
+	// ids so collisions can be forced.
+	decoys := []*wire.MsgTx{testCmpctBlockTx(100), testCmpctBlockTx(101)}
+	testShortIDs := map[chainhash.Hash]uint64{
+		txns[1].WitnessHash():   1,
+		txns[2].WitnessHash():   2,
+		txns[3].WitnessHash():   3,
+		txns[4].WitnessHash():   4,
+		decoys[0].WitnessHash(): 2,
+		decoys[1].WitnessHash(): 2,
+	}
+	shortTxID := func(tx *wire.MsgTx) uint64 {
+		return testShortIDs[tx.WitnessHash()]
+	}
+	newCmpctBlock := func(shortIDs ...uint64) *wire.MsgCmpctBlock {
+		cmpctBlock := wire.NewMsgCmpctBlock(&block.Header, 0)
+		cmpctBlock.PrefilledTxs = []wire.PrefilledTx{
+			{Index: 0, Tx: txns[0]},
+		}
+		cmpctBlock.ShortIDs = shortIDs
+		return cmpctBlock
+	}
+
+	tests := []struct {
+		name        string
+		cmpctBlock  *wire.MsgCmpctBlock
+		candidates  []*wire.MsgTx
+		wantMissing []uint32
+	}{{
+		name:       "candidate collides after match",
+		cmpctBlock: newCmpctBlock(1, 2, 3, 4),
+		candidates: []*wire.MsgTx{txns[1], txns[2], txns[3],
+			txns[4], decoys[0]},
+		wantMissing: []uint32{2},
+	}, {
+		name:       "candidate collides before match",
+		cmpctBlock: newCmpctBlock(1, 2, 3, 4),
+		candidates: []*wire.MsgTx{decoys[0], txns[1], txns[2],
+			txns[3], txns[4]},
+		wantMissing: []uint32{2},
+	}, {
+		name:        "only colliding candidates",
+		cmpctBlock:  newCmpctBlock(1, 2, 3, 4),
+		candidates:  []*wire.MsgTx{txns[1], decoys[0], decoys[1]},
+		wantMissing: []uint32{2, 3, 4},
+	}, {
+		name:       "duplicate candidate",
+		cmpctBlock: newCmpctBlock(1, 2, 3, 4),
+		candidates: []*wire.MsgTx{txns[1], txns[2], txns[3],
+			txns[4], txns[2]},
+		wantMissing: nil,
+	}, {
+		name:        "duplicate short ids in compact block",
+		cmpctBlock:  newCmpctBlock(1, 2, 3, 3),
+		candidates:  txns[1:],
+		wantMissing: []uint32{3, 4},
+	}}
+
+	for _, test := range tests {
+		pb := buildPartialBlock(test.cmpctBlock, test.candidates, shortTxID)
+		if !reflect.DeepEqual(pb.missing, test.wantMissing) {
+			t.Errorf("%s: unexpected missing transactions - got %v, "+
+				"want %v", test.name, pb.missing, test.wantMissing)
+			continue
+		}
+
+		// Ensure the block is reconstructed once the ambiguous
+		// transactions are provided by the peer.
+		blockTxn := wire.NewMsgBlockTxn(&blockHash)
+		for _, index := range pb.missing {
+			blockTxn.AddTransaction(txns[index])
+		}
+		if err := pb.fillMissing(blockTxn); err != nil {
+			t.Errorf("%s: unable to fill missing transactions: %v",
+				test.name, err)
+			continue
+		}
+		reconstructed, err := pb.block()
+		if err != nil {
+			t.Errorf("%s: unable to reconstruct block: %v", test.name,
+				err)
+			continue
+		}
+		if !reflect.DeepEqual(reconstructed.MsgBlock(), block) {
+			t.Errorf("%s: reconstructed block does not match "+
+				"original block", test.name)
+		}
+	}
+}
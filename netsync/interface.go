@@ -37,5 +37,23 @@ type Config struct {
 	DisableCheckpoints bool
 	MaxPeers           int
 
+	// BlockDownloadWindow is the maximum number of blocks past the best
+	// chain tip that are requested during the initial block download.
+	// This field can be omitted in which case DefaultBlockDownloadWindow
+	// will be used.
+	BlockDownloadWindow int
+
+	// MaxBlocksInFlight is the maximum number of blocks requested from a
+	// single peer that may be outstanding at once during the initial block
+	// download.  This field can be omitted in which case
+	// DefaultMaxBlocksInFlight will be used.
+	MaxBlocksInFlight int
+
+	// GetDataBatchSize is the maximum number of inventory items requested
+	// by a single getdata message.  Larger requests are split into
+	// multiple messages.  This field can be omitted in which case
+	// DefaultGetDataBatchSize will be used.
+	GetDataBatchSize int
+
 	FeeEstimator *mempool.FeeEstimator
 }
@@ -23,6 +23,23 @@ import (
 )
 
 const (
+	// DefaultBlockDownloadWindow is the default maximum number of blocks
+	// past the best chain tip that are requested during the initial block
+	// download.  It bounds the memory used by blocks that are downloaded
+	// out of order while still allowing requests to be spread far enough
+	// ahead to keep the connection busy.
+	DefaultBlockDownloadWindow = 1024
+
+	// DefaultMaxBlocksInFlight is the default maximum number of blocks
+	// requested from a single peer that may be outstanding at once during
+	// the initial block download.
+	DefaultMaxBlocksInFlight = 128
+
+	// DefaultGetDataBatchSize is the default maximum number of inventory
+	// items requested by a single getdata message.  Larger requests are
+	// split into multiple messages.
+	DefaultGetDataBatchSize = 1000
+
 	// minInFlightBlocks is the minimum number of blocks that should be
 	// in the request queue for headers-first mode before requesting
 	// more.
@@ -47,6 +64,22 @@ const (
 	// stallSampleInterval the interval at which we will check to see if our
 	// sync has stalled.
 	stallSampleInterval = 30 * time.Second
+
+	// minHeadersThroughput is the minimum number of headers per second the
+	// sync peer is expected to deliver in response to a request for headers
+	// during headers-first mode.
+	minHeadersThroughput = 100
+
+	// headersThroughputGrace is the time a sync peer is given to respond to
+	// a request for headers on top of the time the headers take to deliver
+	// at the minimum throughput.  It prevents latency from penalizing small
+	// batches.
+	headersThroughputGrace = 10 * time.Second
+
+	// blockRequestTimeout is the time after which an outstanding request
+	// for an announced block is reassigned to another peer that announced
+	// the block.
+	blockRequestTimeout = 2 * time.Minute
 )
 
 // zeroHash is the zero value hash (all zeros).  It is defined as a convenience.
@@ -145,6 +178,13 @@ type headerNode struct {
 	hash   *chainhash.Hash
 }
 
+// blockRequest houses the peer an in-flight block request is assigned to along
+// with the time the block was requested.
+type blockRequest struct {
+	peer      *peerpkg.Peer
+	requested time.Time
+}
+
 // peerSyncState stores additional information that the SyncManager tracks
 // about a peer.
 type peerSyncState struct {
@@ -152,6 +192,7 @@ type peerSyncState struct {
 	requestQueue    []*wire.InvVect
 	requestedTxns   map[chainhash.Hash]struct{}
 	requestedBlocks map[chainhash.Hash]struct{}
+	partialBlock    *partialBlock
 }
 
 // limitAdd is a helper function for maps that require a maximum limit by
@@ -198,14 +239,41 @@ type SyncManager struct {
 	peerStates       map[*peerpkg.Peer]*peerSyncState
 	lastProgressTime time.Time
 
+	// blockRequests houses the peers announced blocks were requested from
+	// so the requests can be reassigned on timeout or disconnect.
+	blockRequests map[chainhash.Hash]blockRequest
+
+	// cmpctHBPeers houses the peers that were asked to push new blocks as
+	// compact blocks directly ordered by when they last delivered a new
+	// block.
+	cmpctHBPeers []*peerpkg.Peer
+
 	// The following fields are used for headers-first mode.
 	headersFirstMode bool
 	headerList       *list.List
 	startHeader      *list.Element
 	nextCheckpoint   *chaincfg.Checkpoint
 
+	// headersRequested is the time the outstanding batch of headers was
+	// requested from the sync peer during headers-first mode.  It is the
+	// zero time when there is no outstanding batch.
+	headersRequested time.Time
+
+	// The following fields limit the blocks requested during the initial
+	// block download.
+	blockDownloadWindow int
+	maxBlocksInFlight   int
+
+	// getDataBatchSize is the maximum number of inventory items requested
+	// by a single getdata message.
+	getDataBatchSize int
+
 	// An optional fee estimator.
 	feeEstimator *mempool.FeeEstimator
+
+	// blockArrivals tracks when recent blocks were first announced and
+	// received.  It is safe for concurrent access.
+	blockArrivals *blockArrivalTracker
 }
 
 // resetHeaderState sets the headers-first mode state to values appropriate for
@@ -214,6 +282,7 @@ func (sm *SyncManager) resetHeaderState(newestHash *chainhash.Hash, newestHeight
 	sm.headersFirstMode = false
 	sm.headerList.Init()
 	sm.startHeader = nil
+	sm.headersRequested = time.Time{}
 
 	// When there is a next checkpoint, add an entry for the latest known
 	// block into the header pool.  This allows the next downloaded header
@@ -329,6 +398,7 @@ func (sm *SyncManager) startSync() {
 		// we may ignore blocks we need that the last sync peer failed
 		// to send.
 		sm.requestedBlocks = make(map[chainhash.Hash]struct{})
+		sm.blockRequests = make(map[chainhash.Hash]blockRequest)
 
 		locator, err := sm.chain.LatestBlockLocator()
 		if err != nil {
@@ -363,6 +433,7 @@ func (sm *SyncManager) startSync() {
 
 			bestPeer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
 			sm.headersFirstMode = true
+			sm.headersRequested = time.Now()
 			log.Infof("Downloading headers for blocks %d to "+
 				"%d from peer %s", best.Height+1,
 				sm.nextCheckpoint.Height, bestPeer.Addr())
@@ -436,6 +507,9 @@ func (sm *SyncManager) handleNewPeerMsg(peer *peerpkg.Peer) {
 		requestedBlocks: make(map[chainhash.Hash]struct{}),
 	}
 
+	// Signal support for compact block relay to the peer.
+	sm.announceCmpctBlockSupport(peer)
+
 	// Start syncing by choosing the best candidate if needed.
 	if isSyncCandidate && sm.syncPeer == nil {
 		sm.startSync()
@@ -456,6 +530,20 @@ func (sm *SyncManager) handleStallSample() {
 		return
 	}
 
+	// Switch to another sync peer when the outstanding batch of headers can
+	// no longer be delivered at the minimum throughput.
+	if !sm.headersRequested.IsZero() &&
+		headersBatchTooSlow(wire.MaxBlockHeadersPerMsg,
+			time.Since(sm.headersRequested)) &&
+		sm.hasAlternateSyncCandidate() {
+
+		log.Infof("Headers requested from peer %s %v ago have not "+
+			"been received -- switching sync peer", sm.syncPeer,
+			time.Since(sm.headersRequested))
+		sm.dropSlowHeadersPeer()
+		return
+	}
+
 	// If the stall timeout has not elapsed, exit early.
 	if time.Since(sm.lastProgressTime) <= maxStallDuration {
 		return
@@ -495,6 +583,50 @@ func (sm *SyncManager) shouldDCStalledSyncPeer() bool {
 	return peerHeight > best.Height
 }
 
+// headersBatchTooSlow returns whether a batch of the passed number of headers
+// that took the passed duration to be delivered fell below the minimum headers
+// throughput.
+func headersBatchTooSlow(numHeaders int, elapsed time.Duration) bool {
+	allowed := headersThroughputGrace +
+		time.Duration(numHeaders)*time.Second/minHeadersThroughput
+	return elapsed > allowed
+}
+
+// hasAlternateSyncCandidate returns whether there is a sync candidate other
+// than the current sync peer that startSync would be able to choose.
+func (sm *SyncManager) hasAlternateSyncCandidate() bool {
+	segwitActive, err := sm.chain.IsDeploymentActive(chaincfg.DeploymentSegwit)
+	if err != nil {
+		log.Errorf("Unable to query for segwit soft-fork state: %v", err)
+		return false
+	}
+
+	best := sm.chain.BestSnapshot()
+	for peer, state := range sm.peerStates {
+		if peer == sm.syncPeer || !state.syncCandidate ||
+			(segwitActive && !peer.IsWitnessEnabled()) {
+
+			continue
+		}
+		if peer.LastBlock() >= best.Height {
+			return true
+		}
+	}
+	return false
+}
+
+// dropSlowHeadersPeer disconnects the current sync peer because it delivered
+// headers too slowly and switches to another sync peer.  The peer is no longer
+// considered a sync candidate so it is not chosen again before the disconnect
+// is processed.
+func (sm *SyncManager) dropSlowHeadersPeer() {
+	if state, exists := sm.peerStates[sm.syncPeer]; exists {
+		state.syncCandidate = false
+		sm.clearRequestedState(state)
+	}
+	sm.updateSyncPeer(true)
+}
+
 // handleDonePeerMsg deals with peers that have signalled they are done.  It
 // removes the peer as a candidate for syncing and in the case where it was
 // the current sync peer, attempts to select a new best peer to sync from.  It
@@ -508,10 +640,25 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 
 	// Remove the peer from the list of candidate peers.
 	delete(sm.peerStates, peer)
+	sm.removeHighBandwidthPeer(peer)
 
 	log.Infof("Lost peer %s", peer)
 
+	// Request the announced blocks that were in flight from the peer from
+	// other peers that announced them.
+	var reassign []chainhash.Hash
+	for blockHash := range state.requestedBlocks {
+		request, exists := sm.blockRequests[blockHash]
+		if exists && request.peer == peer {
+			reassign = append(reassign, blockHash)
+		}
+	}
 	sm.clearRequestedState(state)
+	for _, blockHash := range reassign {
+		if announcer := sm.announcingPeer(&blockHash, nil); announcer != nil {
+			sm.requestBlock(announcer, &blockHash)
+		}
+	}
 
 	if peer == sm.syncPeer {
 		// Update the sync peer. The server has already disconnected the
@@ -531,11 +678,119 @@ func (sm *SyncManager) clearRequestedState(state *peerSyncState) {
 	}
 
 	// Remove requested blocks from the global map so that they will be
-	// fetched from elsewhere next time we get an inv.
+	// fetched from elsewhere next time we get an inv.  Blocks whose request
+	// was reassigned to another peer remain requested from that peer.
 	// TODO: we could possibly here check which peers have these blocks
 	// and request them now to speed things up a little.
 	for blockHash := range state.requestedBlocks {
+		if sm.reassignedBlockRequest(state, &blockHash) {
+			continue
+		}
 		delete(sm.requestedBlocks, blockHash)
+		delete(sm.blockRequests, blockHash)
+	}
+}
+
+// trackBlockRequest records that the block with the passed hash was requested
+// from the passed peer.
+func (sm *SyncManager) trackBlockRequest(peer *peerpkg.Peer, state *peerSyncState, blockHash *chainhash.Hash) {
+	limitAdd(sm.requestedBlocks, *blockHash, maxRequestedBlocks)
+	limitAdd(state.requestedBlocks, *blockHash, maxRequestedBlocks)
+	sm.blockRequests[*blockHash] = blockRequest{
+		peer:      peer,
+		requested: time.Now(),
+	}
+}
+
+// reassignedBlockRequest returns whether the request for the block with the
+// passed hash, which was made under the passed peer sync state, has since been
+// reassigned to another connected peer.
+func (sm *SyncManager) reassignedBlockRequest(state *peerSyncState, blockHash *chainhash.Hash) bool {
+	request, exists := sm.blockRequests[*blockHash]
+	if !exists {
+		return false
+	}
+	assignedState, exists := sm.peerStates[request.peer]
+	return exists && assignedState != state
+}
+
+// untrackBlockRequest removes the block with the passed hash from the global
+// request maps as well as from the state of the peer the request is assigned
+// to.
+func (sm *SyncManager) untrackBlockRequest(blockHash *chainhash.Hash) {
+	if request, exists := sm.blockRequests[*blockHash]; exists {
+		if state, exists := sm.peerStates[request.peer]; exists {
+			delete(state.requestedBlocks, *blockHash)
+		}
+		delete(sm.blockRequests, *blockHash)
+	}
+	delete(sm.requestedBlocks, *blockHash)
+}
+
+// requestBlock requests the block with the passed hash from the passed peer
+// which must be witness enabled and tracks the request.
+func (sm *SyncManager) requestBlock(peer *peerpkg.Peer, blockHash *chainhash.Hash) {
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		return
+	}
+	sm.trackBlockRequest(peer, state, blockHash)
+
+	gdmsg := wire.NewMsgGetData()
+	gdmsg.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock, blockHash))
+	peer.QueueMessage(gdmsg, nil)
+}
+
+// announcingPeer returns a witness enabled peer other than the passed one that
+// announced the block with the passed hash.  Peers that advertise full block
+// service (NODE_NETWORK) are preferred over those that only serve recent blocks.
+// Nil is returned when there is no such peer.
+func (sm *SyncManager) announcingPeer(blockHash *chainhash.Hash, exclude *peerpkg.Peer) *peerpkg.Peer {
+	var fallback *peerpkg.Peer
+	iv := wire.NewInvVect(wire.InvTypeBlock, blockHash)
+	for peer := range sm.peerStates {
+		if peer == exclude || !peer.IsWitnessEnabled() ||
+			!peer.IsKnownInventory(iv) {
+
+			continue
+		}
+		if peer.Services()&wire.SFNodeNetwork == wire.SFNodeNetwork {
+			return peer
+		}
+		if fallback == nil {
+			fallback = peer
+		}
+	}
+	return fallback
+}
+
+// handleBlockRequestTimeouts reassigns requests for announced blocks that have
+// been outstanding for longer than blockRequestTimeout as of the passed time to
+// another peer that announced the block.  Requests are left assigned to the
+// original peer when no other peer announced the block.  The block remains
+// requested from the original peer as well so it is still accepted should the
+// original peer deliver it after all.
+func (sm *SyncManager) handleBlockRequestTimeouts(now time.Time) {
+	for blockHash, request := range sm.blockRequests {
+		// Forget requests that were evicted from the request maps.
+		if _, exists := sm.requestedBlocks[blockHash]; !exists {
+			delete(sm.blockRequests, blockHash)
+			continue
+		}
+
+		if now.Sub(request.requested) < blockRequestTimeout {
+			continue
+		}
+		announcer := sm.announcingPeer(&blockHash, request.peer)
+		if announcer == nil {
+			continue
+		}
+
+		log.Debugf("Request for block %v from %s timed out -- "+
+			"requesting it from %s", blockHash, request.peer,
+			announcer)
+		hash := blockHash
+		sm.requestBlock(announcer, &hash)
 	}
 }
 
@@ -703,7 +958,10 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	// so we shouldn't have any more instances of trying to fetch it, or we
 	// will fail the insert and thus we'll retry next time we get an inv.
 	delete(state.requestedBlocks, *blockHash)
-	delete(sm.requestedBlocks, *blockHash)
+	sm.untrackBlockRequest(blockHash)
+	if state.partialBlock != nil && state.partialBlock.blockHash() == *blockHash {
+		state.partialBlock = nil
+	}
 
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
@@ -791,6 +1049,12 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 
 		// Clear the rejected transactions.
 		sm.rejectedTxns = make(map[chainhash.Hash]struct{})
+
+		// Ask the peer to push future blocks as compact blocks since
+		// it was able to deliver this one.
+		if sm.current() {
+			sm.updateHighBandwidthPeers(peer)
+		}
 	}
 
 	// Update the block height for this peer. But only send a message to
@@ -814,8 +1078,12 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	// request more blocks using the header list when the request queue is
 	// getting short.
 	if !isCheckpointBlock {
+		refillThreshold := minInFlightBlocks
+		if sm.maxBlocksInFlight < refillThreshold {
+			refillThreshold = sm.maxBlocksInFlight
+		}
 		if sm.startHeader != nil &&
-			len(state.requestedBlocks) < minInFlightBlocks {
+			len(state.requestedBlocks) < refillThreshold {
 			sm.fetchHeaderBlocks()
 		}
 		return
@@ -836,6 +1104,7 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 				"peer %s: %v", peer.Addr(), err)
 			return
 		}
+		sm.headersRequested = time.Now()
 		log.Infof("Downloading headers for blocks %d to %d from "+
 			"peer %s", prevHeight+1, sm.nextCheckpoint.Height,
 			sm.syncPeer.Addr())
@@ -859,6 +1128,10 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 
 // fetchHeaderBlocks creates and sends a request to the syncPeer for the next
 // list of blocks to be downloaded based on the current list of headers.
+//
+// Blocks are only requested while they are within the download window past the
+// best chain tip and the sync peer has fewer than the maximum number of blocks
+// in flight.  The remaining blocks are requested as earlier ones arrive.
 func (sm *SyncManager) fetchHeaderBlocks() {
 	// Nothing to do if there is no start header.
 	if sm.startHeader == nil {
@@ -868,7 +1141,10 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 
 	// Build up a getdata request for the list of blocks the headers
 	// describe.  The size hint will be limited to wire.MaxInvPerMsg by
-	// the function, so no need to double check it here.
+	// the function, so no need to double check it here.  The request is
+	// split into batches of the configured size when it is queued.
+	syncPeerState := sm.peerStates[sm.syncPeer]
+	maxHeight := sm.chain.BestSnapshot().Height + int32(sm.blockDownloadWindow)
 	gdmsg := wire.NewMsgGetDataSizeHint(uint(sm.headerList.Len()))
 	numRequested := 0
 	for e := sm.startHeader; e != nil; e = e.Next() {
@@ -877,6 +1153,11 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 			log.Warn("Header list node type is not a headerNode")
 			continue
 		}
+		if node.height > maxHeight ||
+			len(syncPeerState.requestedBlocks) >= sm.maxBlocksInFlight {
+
+			break
+		}
 
 		iv := wire.NewInvVect(wire.InvTypeBlock, node.hash)
 		haveInv, err := sm.haveInventory(iv)
@@ -886,8 +1167,6 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 				"fetch: %v", err)
 		}
 		if !haveInv {
-			syncPeerState := sm.peerStates[sm.syncPeer]
-
 			sm.requestedBlocks[*node.hash] = struct{}{}
 			syncPeerState.requestedBlocks[*node.hash] = struct{}{}
 
@@ -906,8 +1185,24 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 			break
 		}
 	}
-	if len(gdmsg.InvList) > 0 {
-		sm.syncPeer.QueueMessage(gdmsg, nil)
+	sm.queueGetData(sm.syncPeer, gdmsg.InvList)
+}
+
+// queueGetData queues getdata messages requesting the passed inventory from
+// the passed peer.  The inventory is split into multiple messages as needed so
+// that none of them contains more than the configured getdata batch size.
+func (sm *SyncManager) queueGetData(peer *peerpkg.Peer, invList []*wire.InvVect) {
+	for len(invList) > 0 {
+		batchSize := len(invList)
+		if batchSize > sm.getDataBatchSize {
+			batchSize = sm.getDataBatchSize
+		}
+		gdmsg := wire.NewMsgGetDataSizeHint(uint(batchSize))
+		for _, iv := range invList[:batchSize] {
+			gdmsg.AddInvVect(iv)
+		}
+		peer.QueueMessage(gdmsg, nil)
+		invList = invList[batchSize:]
 	}
 }
 
@@ -936,6 +1231,23 @@ func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 		return
 	}
 
+	// Switch to another sync peer, discarding the headers, when the sync
+	// peer delivered them below the minimum throughput and there is an
+	// alternative.  Otherwise, receiving the headers counts as progress.
+	if peer == sm.syncPeer && !sm.headersRequested.IsZero() {
+		elapsed := time.Since(sm.headersRequested)
+		sm.headersRequested = time.Time{}
+		if headersBatchTooSlow(numHeaders, elapsed) &&
+			sm.hasAlternateSyncCandidate() {
+
+			log.Infof("Peer %s took %v to deliver %d headers -- "+
+				"switching sync peer", peer, elapsed, numHeaders)
+			sm.dropSlowHeadersPeer()
+			return
+		}
+		sm.lastProgressTime = time.Now()
+	}
+
 	// Process all of the received headers ensuring each one connects to the
 	// previous and that checkpoints match.
 	receivedCheckpoint := false
@@ -1017,6 +1329,7 @@ func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 			"peer %s: %v", peer.Addr(), err)
 		return
 	}
+	sm.headersRequested = time.Now()
 }
 
 // handleNotFoundMsg handles notfound messages from all peers.
@@ -1036,7 +1349,9 @@ func (sm *SyncManager) handleNotFoundMsg(nfmsg *notFoundMsg) {
 		case wire.InvTypeBlock:
 			if _, exists := state.requestedBlocks[inv.Hash]; exists {
 				delete(state.requestedBlocks, inv.Hash)
-				delete(sm.requestedBlocks, inv.Hash)
+				if !sm.reassignedBlockRequest(state, &inv.Hash) {
+					sm.untrackBlockRequest(&inv.Hash)
+				}
 			}
 
 		case wire.InvTypeWitnessTx:
@@ -1258,8 +1573,7 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 			// Request the block if there is not already a pending
 			// request.
 			if _, exists := sm.requestedBlocks[iv.Hash]; !exists {
-				limitAdd(sm.requestedBlocks, iv.Hash, maxRequestedBlocks)
-				limitAdd(state.requestedBlocks, iv.Hash, maxRequestedBlocks)
+				sm.trackBlockRequest(peer, state, &iv.Hash)
 
 				if peer.IsWitnessEnabled() {
 					iv.Type = wire.InvTypeWitnessBlock
@@ -1294,9 +1608,7 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 		}
 	}
 	state.requestQueue = requestQueue
-	if len(gdmsg.InvList) > 0 {
-		peer.QueueMessage(gdmsg, nil)
-	}
+	sm.queueGetData(peer, gdmsg.InvList)
 }
 
 // blockHandler is the main handler for the sync manager.  It must be run as a
@@ -1331,6 +1643,12 @@ out:
 			case *headersMsg:
 				sm.handleHeadersMsg(msg)
 
+			case *cmpctBlockMsg:
+				sm.handleCmpctBlockMsg(msg)
+
+			case *blockTxnMsg:
+				sm.handleBlockTxnMsg(msg)
+
 			case *notFoundMsg:
 				sm.handleNotFoundMsg(msg)
 
@@ -1373,6 +1691,7 @@ out:
 
 		case <-stallTicker.C:
 			sm.handleStallSample()
+			sm.handleBlockRequestTimeouts(time.Now())
 
 		case <-sm.quit:
 			break out
@@ -1403,9 +1722,10 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 			break
 		}
 
-		// Generate the inventory vector and relay it.
+		// Generate the inventory vector and relay it along with the
+		// block so it can be pushed to peers that want compact blocks.
 		iv := wire.NewInvVect(wire.InvTypeBlock, block.Hash())
-		sm.peerNotifier.RelayInventory(iv, block.MsgBlock().Header)
+		sm.peerNotifier.RelayInventory(iv, block)
 
 	// A block has been connected to the main block chain.
 	case blockchain.NTBlockConnected:
@@ -1453,6 +1773,10 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 			break
 		}
 
+		// Outputs created by the block are no longer in the main chain,
+		// so drop the outputs cached for the transactions in the pool.
+		sm.txMemPool.ClearInputUtxoCache()
+
 		// Reinsert all of the transactions (except the coinbase) into
 		// the transaction pool.
 		for _, tx := range block.Transactions()[1:] {
@@ -1505,6 +1829,12 @@ func (sm *SyncManager) QueueBlock(block *btcutil.Block, peer *peerpkg.Peer, done
 		return
 	}
 
+	arrival := sm.blockArrivals.Received(block.Hash(), time.Now())
+	if delay, ok := arrival.PropagationDelay(); ok {
+		log.Debugf("Received block %v from %s %v after it was first "+
+			"announced", block.Hash(), peer, delay)
+	}
+
 	sm.msgChan <- &blockMsg{block: block, peer: peer, reply: done}
 }
 
@@ -1516,6 +1846,15 @@ func (sm *SyncManager) QueueInv(inv *wire.MsgInv, peer *peerpkg.Peer) {
 		return
 	}
 
+	now := time.Now()
+	for _, iv := range inv.InvList {
+		if iv.Type == wire.InvTypeBlock ||
+			iv.Type == wire.InvTypeWitnessBlock {
+
+			sm.blockArrivals.Announced(&iv.Hash, now)
+		}
+	}
+
 	sm.msgChan <- &invMsg{inv: inv, peer: peer}
 }
 
@@ -1528,6 +1867,17 @@ func (sm *SyncManager) QueueHeaders(headers *wire.MsgHeaders, peer *peerpkg.Peer
 		return
 	}
 
+	// Headers are requested in large batches during the initial block
+	// download, so they are not announcements of new blocks and would
+	// only churn the arrival tracker.
+	if sm.chain.IsCurrent() {
+		now := time.Now()
+		for _, header := range headers.Headers {
+			blockHash := header.BlockHash()
+			sm.blockArrivals.Announced(&blockHash, now)
+		}
+	}
+
 	sm.msgChan <- &headersMsg{headers: headers, peer: peer}
 }
 
@@ -1604,6 +1954,16 @@ func (sm *SyncManager) IsCurrent() bool {
 	return <-reply
 }
 
+// BlockArrival returns the times the block with the passed hash was first
+// announced and received by the sync manager.  Only a limited number of recent
+// blocks are tracked, so the boolean is false when the block is unknown or its
+// arrival times have since been evicted.
+//
+// This function is safe for concurrent access.
+func (sm *SyncManager) BlockArrival(hash *chainhash.Hash) (BlockArrival, bool) {
+	return sm.blockArrivals.Arrival(hash)
+}
+
 // Pause pauses the sync manager until the returned channel is closed.
 //
 // Note that while paused, all peer and block processing is halted.  The
@@ -1625,12 +1985,27 @@ func New(config *Config) (*SyncManager, error) {
 		rejectedTxns:    make(map[chainhash.Hash]struct{}),
 		requestedTxns:   make(map[chainhash.Hash]struct{}),
 		requestedBlocks: make(map[chainhash.Hash]struct{}),
+		blockRequests:   make(map[chainhash.Hash]blockRequest),
 		peerStates:      make(map[*peerpkg.Peer]*peerSyncState),
 		progressLogger:  newBlockProgressLogger("Processed", log),
 		msgChan:         make(chan interface{}, config.MaxPeers*3),
 		headerList:      list.New(),
 		quit:            make(chan struct{}),
 		feeEstimator:    config.FeeEstimator,
+		blockArrivals:   newBlockArrivalTracker(),
+
+		blockDownloadWindow: config.BlockDownloadWindow,
+		maxBlocksInFlight:   config.MaxBlocksInFlight,
+		getDataBatchSize:    config.GetDataBatchSize,
+	}
+	if sm.blockDownloadWindow <= 0 {
+		sm.blockDownloadWindow = DefaultBlockDownloadWindow
+	}
+	if sm.maxBlocksInFlight <= 0 {
+		sm.maxBlocksInFlight = DefaultMaxBlocksInFlight
+	}
+	if sm.getDataBatchSize <= 0 || sm.getDataBatchSize > wire.MaxInvPerMsg {
+		sm.getDataBatchSize = DefaultGetDataBatchSize
 	}
 
 	best := sm.chain.BestSnapshot()
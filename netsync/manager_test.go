@@ -0,0 +1,787 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"container/list"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/mempool"
+	peerpkg "github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+	"github.com/btcsuite/btcutil"
+)
+
+// newTestSyncManager returns a sync manager backed by a new chain that only
+// consists of a genesis block with a recent timestamp so the chain is
+// considered current.
+func newTestSyncManager(t *testing.T) *SyncManager {
+	t.Helper()
+	return newTestSyncManagerAt(t, time.Now())
+}
+
+// newTestSyncManagerAt returns a sync manager backed by a new chain that only
+// consists of a genesis block with the passed timestamp.
+func newTestSyncManagerAt(t *testing.T, genesisTime time.Time) *SyncManager {
+	t.Helper()
+
+	UseLogger(btclog.Disabled)
+	blockchain.UseLogger(btclog.Disabled)
+
+	params := chaincfg.RegressionNetParams
+	genesis := *params.GenesisBlock
+	genesis.Header.Timestamp = time.Unix(genesisTime.Unix(), 0)
+	genesisHash := genesis.BlockHash()
+	params.GenesisBlock = &genesis
+	params.GenesisHash = &genesisHash
+
+	dbPath := filepath.Join(t.TempDir(), "ffldb")
+	db, err := database.Create("ffldb", dbPath, params.Net)
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("unable to create chain: %v", err)
+	}
+	sm, err := New(&Config{
+		Chain:       chain,
+		ChainParams: &params,
+		MaxPeers:    8,
+	})
+	if err != nil {
+		t.Fatalf("unable to create sync manager: %v", err)
+	}
+	return sm
+}
+
+// connectPeers creates an inbound peer with the local config and an outbound
+// peer with the remote config, connects them to each other over the loopback
+// interface, and waits for both to complete the version handshake.  The
+// inbound peer is returned first.
+func connectPeers(localCfg, remoteCfg *peerpkg.Config) (*peerpkg.Peer, *peerpkg.Peer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer listener.Close()
+
+	remote, err := peerpkg.NewOutboundPeer(remoteCfg, listener.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	localConn, err := listener.Accept()
+	if err != nil {
+		remoteConn.Close()
+		return nil, nil, err
+	}
+
+	local := peerpkg.NewInboundPeer(localCfg)
+	local.AssociateConnection(localConn)
+	remote.AssociateConnection(remoteConn)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !local.VerAckReceived() || !remote.VerAckReceived() {
+		if time.Now().After(deadline) {
+			local.Disconnect()
+			remote.Disconnect()
+			return nil, nil, errors.New("timeout waiting for version " +
+				"handshake")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return local, remote, nil
+}
+
+// connectTestPeer returns an inbound peer connected over the loopback interface
+// to a remote outbound peer that uses the passed listeners and advertises the
+// passed services.  The remote peer has completed the version handshake when
+// it is returned.
+func connectTestPeer(t *testing.T, listeners peerpkg.MessageListeners, services wire.ServiceFlag) (*peerpkg.Peer, *peerpkg.Peer) {
+	t.Helper()
+
+	local, remote, err := connectPeers(&peerpkg.Config{
+		ChainParams:    &chaincfg.RegressionNetParams,
+		Services:       wire.SFNodeNetwork | wire.SFNodeWitness,
+		AllowSelfConns: true,
+	}, &peerpkg.Config{
+		Listeners:      listeners,
+		ChainParams:    &chaincfg.RegressionNetParams,
+		Services:       services,
+		AllowSelfConns: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to connect test peer: %v", err)
+	}
+	return local, remote
+}
+
+// testHeaders returns the passed number of headers that connect to each other
+// starting with the header that connects to the passed previous block hash.
+func testHeaders(prevHash chainhash.Hash, numHeaders int) *wire.MsgHeaders {
+	msg := wire.NewMsgHeaders()
+	for i := 0; i < numHeaders; i++ {
+		header := wire.NewBlockHeader(1, &prevHash, &chainhash.Hash{},
+			0x207fffff, uint32(i))
+		msg.AddBlockHeader(header)
+		prevHash = header.BlockHash()
+	}
+	return msg
+}
+
+// TestHeadersContinuation ensures that when the sync manager receives a batch
+// of headers that is capped by the serving peer before the next checkpoint is
+// reached, it requests the next batch using the last received header as the
+// locator so the serving peer continues right after it.
+func TestHeadersContinuation(t *testing.T) {
+	// Create a serving chain that extends beyond a single capped batch of
+	// headers with a checkpoint at its tip.
+	const numBlocks = wire.MaxBlockHeadersPerMsg + 10
+	server := newTestSyncManager(t)
+	server.peerNotifier = testPeerNotifier{}
+	blocks := testChainBlocks(server, numBlocks)
+	for i, block := range blocks {
+		_, _, err := server.chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("unable to process block %d: %v", i, err)
+		}
+	}
+	checkpoint := &chaincfg.Checkpoint{
+		Height: numBlocks,
+		Hash:   blocks[numBlocks-1].Hash(),
+	}
+
+	getHeaders := make(chan *wire.MsgGetHeaders, 1)
+	peer, remote := connectTestPeer(t, peerpkg.MessageListeners{
+		OnGetHeaders: func(_ *peerpkg.Peer, msg *wire.MsgGetHeaders) {
+			getHeaders <- msg
+		},
+	}, wire.SFNodeNetwork|wire.SFNodeWitness)
+	defer remote.Disconnect()
+
+	genesisHash := server.chainParams.GenesisHash
+	sm := &SyncManager{
+		chainParams:      server.chainParams,
+		peerStates:       make(map[*peerpkg.Peer]*peerSyncState),
+		headersFirstMode: true,
+		headerList:       list.New(),
+		nextCheckpoint:   checkpoint,
+	}
+	sm.peerStates[peer] = &peerSyncState{
+		requestedTxns:   make(map[chainhash.Hash]struct{}),
+		requestedBlocks: make(map[chainhash.Hash]struct{}),
+	}
+	sm.headerList.PushBack(&headerNode{height: 0, hash: genesisHash})
+
+	// serveHeaders returns the headers the serving chain responds with to a
+	// getheaders request with the passed locator and stop hash.
+	serveHeaders := func(locator blockchain.BlockLocator, hashStop *chainhash.Hash) *wire.MsgHeaders {
+		headers := server.chain.LocateHeaders(locator, hashStop)
+		msg := wire.NewMsgHeaders()
+		for i := range headers {
+			msg.AddBlockHeader(&headers[i])
+		}
+		return msg
+	}
+
+	// The first batch must be capped at the max number of headers per
+	// message before the checkpoint is reached.
+	headers := serveHeaders(blockchain.BlockLocator{genesisHash},
+		checkpoint.Hash)
+	if len(headers.Headers) != wire.MaxBlockHeadersPerMsg {
+		t.Fatalf("unexpected number of served headers -- got %d, want %d",
+			len(headers.Headers), wire.MaxBlockHeadersPerMsg)
+	}
+	sm.handleHeadersMsg(&headersMsg{headers: headers, peer: peer})
+
+	// Ensure the next request continues from the last header of the batch.
+	lastHash := headers.Headers[len(headers.Headers)-1].BlockHash()
+	var msg *wire.MsgGetHeaders
+	select {
+	case msg = <-getHeaders:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for getheaders")
+	}
+	if len(msg.BlockLocatorHashes) != 1 ||
+		*msg.BlockLocatorHashes[0] != lastHash {
+
+		t.Fatalf("unexpected locator -- got %v, want [%v]",
+			msg.BlockLocatorHashes, lastHash)
+	}
+	if msg.HashStop != *checkpoint.Hash {
+		t.Fatalf("unexpected stop hash -- got %v, want %v",
+			msg.HashStop, checkpoint.Hash)
+	}
+	if sm.headerList.Len() != wire.MaxBlockHeadersPerMsg+1 {
+		t.Fatalf("unexpected number of headers -- got %d, want %d",
+			sm.headerList.Len(), wire.MaxBlockHeadersPerMsg+1)
+	}
+
+	// Ensure serving the continuation request returns the remaining
+	// headers up to the checkpoint starting right after the last header of
+	// the previous batch.
+	headers = serveHeaders(msg.BlockLocatorHashes, &msg.HashStop)
+	wantLen := numBlocks - wire.MaxBlockHeadersPerMsg
+	if len(headers.Headers) != wantLen {
+		t.Fatalf("unexpected number of continued headers -- got %d, "+
+			"want %d", len(headers.Headers), wantLen)
+	}
+	if headers.Headers[0].PrevBlock != lastHash {
+		t.Fatalf("continued headers start after %v, want %v",
+			headers.Headers[0].PrevBlock, lastHash)
+	}
+	finalHash := headers.Headers[wantLen-1].BlockHash()
+	if finalHash != *checkpoint.Hash {
+		t.Fatalf("continued headers end at %v, want %v", finalHash,
+			checkpoint.Hash)
+	}
+}
+
+// TestSlowHeadersPeer ensures a sync peer that delivers headers below the
+// minimum throughput during headers-first mode is dropped in favor of another
+// sync candidate while one that delivers them in time is kept.
+func TestSlowHeadersPeer(t *testing.T) {
+	sm := newTestSyncManager(t)
+	best := sm.chain.BestSnapshot()
+	sm.nextCheckpoint = &chaincfg.Checkpoint{
+		Height: wire.MaxBlockHeadersPerMsg * 3,
+		Hash:   &chainhash.Hash{0x01},
+	}
+	sm.resetHeaderState(&best.Hash, best.Height)
+
+	// Connect two sync candidates that deliver the getheaders messages
+	// they receive on their own channels.
+	testPeer := func() (*peerpkg.Peer, chan *wire.MsgGetHeaders) {
+		getHeaders := make(chan *wire.MsgGetHeaders, 2)
+		peer, remote := connectTestPeer(t, peerpkg.MessageListeners{
+			OnGetHeaders: func(_ *peerpkg.Peer, msg *wire.MsgGetHeaders) {
+				getHeaders <- msg
+			},
+		}, wire.SFNodeNetwork|wire.SFNodeWitness)
+		t.Cleanup(remote.Disconnect)
+		sm.peerStates[peer] = &peerSyncState{
+			syncCandidate:   true,
+			requestedTxns:   make(map[chainhash.Hash]struct{}),
+			requestedBlocks: make(map[chainhash.Hash]struct{}),
+		}
+		return peer, getHeaders
+	}
+	assertGetHeaders := func(name string, getHeaders chan *wire.MsgGetHeaders) {
+		t.Helper()
+		select {
+		case <-getHeaders:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s: timeout waiting for getheaders", name)
+		}
+	}
+
+	// Start syncing from the first peer before the second one connects so
+	// it is chosen as the sync peer.
+	slowPeer, slowGetHeaders := testPeer()
+	sm.startSync()
+	if sm.syncPeer != slowPeer || !sm.headersFirstMode {
+		t.Fatal("startSync: headers-first sync not started")
+	}
+	assertGetHeaders("start", slowGetHeaders)
+	fastPeer, fastGetHeaders := testPeer()
+
+	// Ensure a batch of headers delivered in time keeps the sync peer.
+	headers := testHeaders(best.Hash, wire.MaxBlockHeadersPerMsg)
+	sm.handleHeadersMsg(&headersMsg{headers: headers, peer: slowPeer})
+	if sm.syncPeer != slowPeer {
+		t.Fatal("sync peer switched after headers delivered in time")
+	}
+	assertGetHeaders("in time", slowGetHeaders)
+
+	// Ensure a batch of headers delivered below the minimum throughput
+	// results in the peer being dropped in favor of the other candidate.
+	sm.headersRequested = time.Now().Add(-headersThroughputGrace -
+		wire.MaxBlockHeadersPerMsg*time.Second/minHeadersThroughput -
+		time.Second)
+	lastHash := headers.Headers[len(headers.Headers)-1].BlockHash()
+	headers = testHeaders(lastHash, wire.MaxBlockHeadersPerMsg)
+	sm.handleHeadersMsg(&headersMsg{headers: headers, peer: slowPeer})
+	if sm.syncPeer != fastPeer {
+		t.Fatal("sync peer not switched after slow headers")
+	}
+	if sm.peerStates[slowPeer].syncCandidate {
+		t.Fatal("slow peer is still a sync candidate")
+	}
+	if slowPeer.Connected() {
+		t.Fatal("slow peer is still connected")
+	}
+	assertGetHeaders("slow", fastGetHeaders)
+
+	// Ensure the new sync peer restarts from the best chain tip.
+	if sm.headerList.Len() != 1 {
+		t.Fatalf("unexpected number of headers after switching -- got "+
+			"%d, want 1", sm.headerList.Len())
+	}
+}
+
+// TestBlockRequestReassignment ensures a block announced by multiple peers is
+// only requested from one of them and that the request is reassigned to
+// another peer that announced the block when it times out or the peer it is
+// assigned to disconnects.
+func TestBlockRequestReassignment(t *testing.T) {
+	sm := newTestSyncManager(t)
+
+	// Connect two peers that deliver the getdata messages they receive on
+	// their own channels.
+	testPeer := func() (*peerpkg.Peer, chan *wire.MsgGetData) {
+		getData := make(chan *wire.MsgGetData, 2)
+		peer, remote := connectTestPeer(t, peerpkg.MessageListeners{
+			OnGetData: func(_ *peerpkg.Peer, msg *wire.MsgGetData) {
+				getData <- msg
+			},
+		}, wire.SFNodeNetwork|wire.SFNodeWitness)
+		t.Cleanup(remote.Disconnect)
+		sm.peerStates[peer] = &peerSyncState{
+			requestedTxns:   make(map[chainhash.Hash]struct{}),
+			requestedBlocks: make(map[chainhash.Hash]struct{}),
+		}
+		return peer, getData
+	}
+	peer1, getData1 := testPeer()
+	peer2, getData2 := testPeer()
+
+	// assertGetData ensures the passed channel receives a getdata message
+	// for the block while the other one does not.
+	blockHash := chainhash.Hash{0x01}
+	assertGetData := func(name string, want, other chan *wire.MsgGetData) {
+		t.Helper()
+		select {
+		case msg := <-want:
+			if len(msg.InvList) != 1 || msg.InvList[0].Hash != blockHash ||
+				msg.InvList[0].Type != wire.InvTypeWitnessBlock {
+
+				t.Fatalf("%s: unexpected getdata %v", name,
+					msg.InvList)
+			}
+		case <-other:
+			t.Fatalf("%s: getdata sent to wrong peer", name)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s: timeout waiting for getdata", name)
+		}
+		select {
+		case <-other:
+			t.Fatalf("%s: getdata sent to both peers", name)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+	assertAssigned := func(name string, want *peerpkg.Peer) {
+		t.Helper()
+		request, exists := sm.blockRequests[blockHash]
+		if !exists || request.peer != want {
+			t.Fatalf("%s: request not assigned to %s", name, want)
+		}
+		if _, exists := sm.peerStates[want].requestedBlocks[blockHash]; !exists {
+			t.Fatalf("%s: request not tracked by %s", name, want)
+		}
+	}
+
+	// Announce the block from both peers and ensure it is only requested
+	// from the first one.
+	for _, peer := range []*peerpkg.Peer{peer1, peer2} {
+		inv := wire.NewMsgInv()
+		inv.AddInvVect(wire.NewInvVect(wire.InvTypeBlock, &blockHash))
+		sm.handleInvMsg(&invMsg{inv: inv, peer: peer})
+	}
+	assertGetData("announce", getData1, getData2)
+	assertAssigned("announce", peer1)
+
+	// Ensure the request is not reassigned before it times out.
+	sm.handleBlockRequestTimeouts(time.Now())
+	if request := sm.blockRequests[blockHash]; request.peer != peer1 {
+		t.Fatal("request reassigned before timeout")
+	}
+
+	// Ensure the request is reassigned to the other peer once it times out
+	// while the block remains requested from the first peer.
+	sm.handleBlockRequestTimeouts(time.Now().Add(blockRequestTimeout))
+	assertGetData("timeout", getData2, getData1)
+	assertAssigned("timeout", peer2)
+	if _, exists := sm.peerStates[peer1].requestedBlocks[blockHash]; !exists {
+		t.Fatal("timeout: request no longer tracked by original peer")
+	}
+
+	// Ensure the request is reassigned back to the first peer when the peer
+	// it is assigned to disconnects.
+	sm.handleDonePeerMsg(peer2)
+	assertGetData("disconnect", getData1, getData2)
+	assertAssigned("disconnect", peer1)
+}
+
+// TestReassignedBlockDelivery ensures a block whose request was reassigned
+// after timing out is still accepted from the peer it was originally requested
+// from while peers it was never requested from are disconnected for sending it.
+func TestReassignedBlockDelivery(t *testing.T) {
+	sm := newTestSyncManager(t)
+	sm.peerNotifier = testPeerNotifier{}
+	block := testChainBlocks(sm, 1)[0]
+
+	testPeer := func() *peerpkg.Peer {
+		peer, remote := connectTestPeer(t, peerpkg.MessageListeners{},
+			wire.SFNodeNetwork|wire.SFNodeWitness)
+		t.Cleanup(remote.Disconnect)
+		sm.peerStates[peer] = &peerSyncState{
+			requestedTxns:   make(map[chainhash.Hash]struct{}),
+			requestedBlocks: make(map[chainhash.Hash]struct{}),
+		}
+		return peer
+	}
+	peer1, peer2, unrequested := testPeer(), testPeer(), testPeer()
+
+	// Announce the block from the first two peers and reassign the request
+	// from the first one to the second one.
+	for _, peer := range []*peerpkg.Peer{peer1, peer2} {
+		inv := wire.NewMsgInv()
+		inv.AddInvVect(wire.NewInvVect(wire.InvTypeBlock, block.Hash()))
+		sm.handleInvMsg(&invMsg{inv: inv, peer: peer})
+	}
+	sm.handleBlockRequestTimeouts(time.Now().Add(blockRequestTimeout))
+	if request := sm.blockRequests[*block.Hash()]; request.peer != peer2 {
+		t.Fatal("request not reassigned after timeout")
+	}
+
+	// A peer the block was never requested from must be disconnected even
+	// though the block is in flight.
+	sm.handleBlockMsg(&blockMsg{block: block, peer: unrequested})
+	select {
+	case <-waitForDisconnect(unrequested):
+	case <-time.After(time.Second * 5):
+		t.Fatal("peer that sent an unrequested block not disconnected")
+	}
+	if sm.chain.BestSnapshot().Height != 0 {
+		t.Fatal("unrequested block was processed")
+	}
+
+	// The original peer delivering the block late must not be punished and
+	// the block must be processed.
+	sm.handleBlockMsg(&blockMsg{block: block, peer: peer1})
+	if !peer1.Connected() {
+		t.Fatal("original peer disconnected for delivering the block")
+	}
+	if sm.chain.BestSnapshot().Hash != *block.Hash() {
+		t.Fatal("block from original peer was not processed")
+	}
+	if _, exists := sm.blockRequests[*block.Hash()]; exists {
+		t.Fatal("request still tracked after the block was delivered")
+	}
+}
+
+// waitForDisconnect returns a channel that is closed once the passed peer has
+// disconnected.
+func waitForDisconnect(peer *peerpkg.Peer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		peer.WaitForDisconnect()
+		close(done)
+	}()
+	return done
+}
+
+// TestAnnouncingPeerServices ensures peers that advertise full block service
+// are preferred when choosing a peer to request an announced block from and
+// that peers lacking it are only used when there is no other choice.
+func TestAnnouncingPeerServices(t *testing.T) {
+	sm := newTestSyncManager(t)
+
+	blockHash := chainhash.Hash{0x01}
+	testPeer := func(services wire.ServiceFlag) *peerpkg.Peer {
+		peer, remote := connectTestPeer(t, peerpkg.MessageListeners{},
+			services)
+		t.Cleanup(remote.Disconnect)
+		sm.peerStates[peer] = &peerSyncState{
+			requestedTxns:   make(map[chainhash.Hash]struct{}),
+			requestedBlocks: make(map[chainhash.Hash]struct{}),
+		}
+		peer.AddKnownInventory(wire.NewInvVect(wire.InvTypeBlock,
+			&blockHash))
+		return peer
+	}
+	witnessPeer := testPeer(wire.SFNodeWitness)
+	fullPeer := testPeer(wire.SFNodeNetwork | wire.SFNodeWitness)
+
+	if peer := sm.announcingPeer(&blockHash, nil); peer != fullPeer {
+		t.Fatalf("announcingPeer: got %v, want full node peer %v", peer,
+			fullPeer)
+	}
+	if peer := sm.announcingPeer(&blockHash, fullPeer); peer != witnessPeer {
+		t.Fatalf("announcingPeer: got %v, want witness only peer %v", peer,
+			witnessPeer)
+	}
+}
+
+// testPeerNotifier is a PeerNotifier that ignores all notifications.
+type testPeerNotifier struct{}
+
+func (testPeerNotifier) AnnounceNewTransactions([]*mempool.TxDesc)               {}
+func (testPeerNotifier) UpdatePeerHeights(*chainhash.Hash, int32, *peerpkg.Peer) {}
+func (testPeerNotifier) RelayInventory(*wire.InvVect, interface{})               {}
+func (testPeerNotifier) TransactionConfirmed(*btcutil.Tx)                        {}
+
+// testChainBlocks returns the passed number of valid blocks that extend the
+// best chain of the sync manager.
+func testChainBlocks(sm *SyncManager, numBlocks int) []*btcutil.Block {
+	best := sm.chain.BestSnapshot()
+	prevHash := best.Hash
+	timestamp := time.Unix(best.MedianTime.Unix(), 0)
+	blocks := make([]*btcutil.Block, 0, numBlocks)
+	for i := 0; i < numBlocks; i++ {
+		coinbase := wire.NewMsgTx(1)
+		coinbase.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+				wire.MaxPrevOutIndex),
+			SignatureScript: []byte{0x02, byte(i), byte(i >> 8)},
+			Sequence:        wire.MaxTxInSequenceNum,
+		})
+		coinbase.AddTxOut(wire.NewTxOut(0, []byte{0x51}))
+		merkles := blockchain.BuildMerkleTreeStore(
+			[]*btcutil.Tx{btcutil.NewTx(coinbase)}, false)
+
+		timestamp = timestamp.Add(time.Second)
+		header := wire.NewBlockHeader(4, &prevHash,
+			merkles[len(merkles)-1], sm.chainParams.PowLimitBits, 0)
+		header.Timestamp = timestamp
+		for {
+			hash := header.BlockHash()
+			if blockchain.HashToBig(&hash).Cmp(sm.chainParams.PowLimit) <= 0 {
+				break
+			}
+			header.Nonce++
+		}
+
+		block := wire.NewMsgBlock(header)
+		block.AddTransaction(coinbase)
+		blocks = append(blocks, btcutil.NewBlock(block))
+		prevHash = header.BlockHash()
+	}
+	return blocks
+}
+
+// TestBlockDownloadWindow ensures the blocks requested during a headers-first
+// sync never exceed the block download window or the maximum number of blocks
+// in flight while still keeping as many blocks in flight as they allow.
+func TestBlockDownloadWindow(t *testing.T) {
+	const numBlocks = 40
+	tests := []struct {
+		name        string
+		window      int
+		maxInFlight int
+	}{{
+		name:        "limited by window",
+		window:      8,
+		maxInFlight: 16,
+	}, {
+		name:        "limited by blocks in flight",
+		window:      16,
+		maxInFlight: 4,
+	}}
+
+	for _, test := range tests {
+		sm := newTestSyncManager(t)
+		sm.peerNotifier = testPeerNotifier{}
+		sm.blockDownloadWindow = test.window
+		sm.maxBlocksInFlight = test.maxInFlight
+
+		getData := make(chan *wire.MsgGetData, numBlocks)
+		peer, remote := connectTestPeer(t, peerpkg.MessageListeners{
+			OnGetData: func(_ *peerpkg.Peer, msg *wire.MsgGetData) {
+				getData <- msg
+			},
+		}, wire.SFNodeNetwork|wire.SFNodeWitness)
+		t.Cleanup(remote.Disconnect)
+		state := &peerSyncState{
+			requestedTxns:   make(map[chainhash.Hash]struct{}),
+			requestedBlocks: make(map[chainhash.Hash]struct{}),
+		}
+		sm.peerStates[peer] = state
+		sm.syncPeer = peer
+
+		// Start a headers-first sync of the blocks up to a checkpoint
+		// at the final one.
+		blocks := testChainBlocks(sm, numBlocks)
+		heights := make(map[chainhash.Hash]int32, numBlocks)
+		for i, block := range blocks {
+			height := int32(i + 1)
+			heights[*block.Hash()] = height
+			sm.headerList.PushBack(&headerNode{
+				height: height,
+				hash:   block.Hash(),
+			})
+		}
+		sm.headersFirstMode = true
+		sm.startHeader = sm.headerList.Front()
+		sm.nextCheckpoint = &chaincfg.Checkpoint{
+			Height: numBlocks,
+			Hash:   blocks[numBlocks-1].Hash(),
+		}
+		sm.fetchHeaderBlocks()
+
+		// Serve the requested blocks in order while ensuring the limits
+		// are never exceeded.
+		var pending []*chainhash.Hash
+		maxObserved := 0
+		for sm.chain.BestSnapshot().Height < numBlocks {
+			if len(pending) == 0 {
+				select {
+				case msg := <-getData:
+					for _, iv := range msg.InvList {
+						pending = append(pending, &iv.Hash)
+					}
+				case <-time.After(time.Second * 5):
+					t.Fatalf("%s: timeout waiting for getdata at "+
+						"height %d", test.name,
+						sm.chain.BestSnapshot().Height)
+				}
+				continue
+			}
+
+			bestHeight := sm.chain.BestSnapshot().Height
+			for hash := range state.requestedBlocks {
+				if heights[hash] > bestHeight+int32(test.window) {
+					t.Fatalf("%s: requested block at height %d "+
+						"with best height %d", test.name,
+						heights[hash], bestHeight)
+				}
+			}
+			inFlight := len(state.requestedBlocks)
+			if inFlight > test.maxInFlight {
+				t.Fatalf("%s: %d blocks in flight", test.name,
+					inFlight)
+			}
+			if inFlight > maxObserved {
+				maxObserved = inFlight
+			}
+
+			hash := pending[0]
+			pending = pending[1:]
+			block := blocks[heights[*hash]-1]
+			sm.handleBlockMsg(&blockMsg{block: block, peer: peer})
+		}
+
+		want := test.window
+		if test.maxInFlight < want {
+			want = test.maxInFlight
+		}
+		if maxObserved != want {
+			t.Fatalf("%s: got at most %d blocks in flight, want %d",
+				test.name, maxObserved, want)
+		}
+	}
+}
+
+// TestGetDataBatchSize ensures the blocks needed during a headers-first sync
+// are requested with getdata messages that do not exceed the configured batch
+// size.
+func TestGetDataBatchSize(t *testing.T) {
+	const (
+		numBlocks = 10
+		batchSize = 4
+	)
+	sm := newTestSyncManager(t)
+	sm.getDataBatchSize = batchSize
+
+	getData := make(chan *wire.MsgGetData, numBlocks)
+	peer, remote := connectTestPeer(t, peerpkg.MessageListeners{
+		OnGetData: func(_ *peerpkg.Peer, msg *wire.MsgGetData) {
+			getData <- msg
+		},
+	}, wire.SFNodeNetwork|wire.SFNodeWitness)
+	t.Cleanup(remote.Disconnect)
+	sm.peerStates[peer] = &peerSyncState{
+		requestedTxns:   make(map[chainhash.Hash]struct{}),
+		requestedBlocks: make(map[chainhash.Hash]struct{}),
+	}
+	sm.syncPeer = peer
+
+	// Request all of the blocks at once.
+	var hashes []chainhash.Hash
+	for i := 0; i < numBlocks; i++ {
+		hash := chainhash.Hash{byte(i + 1)}
+		hashes = append(hashes, hash)
+		sm.headerList.PushBack(&headerNode{
+			height: int32(i + 1),
+			hash:   &hash,
+		})
+	}
+	sm.startHeader = sm.headerList.Front()
+	sm.fetchHeaderBlocks()
+
+	// Ensure the blocks are requested in order in messages of the batch
+	// size with the remainder in the final one.
+	wantSizes := []int{batchSize, batchSize, numBlocks % batchSize}
+	var requested []chainhash.Hash
+	for i, wantSize := range wantSizes {
+		select {
+		case msg := <-getData:
+			if len(msg.InvList) != wantSize {
+				t.Fatalf("getdata %d: got %d items, want %d", i,
+					len(msg.InvList), wantSize)
+			}
+			for _, iv := range msg.InvList {
+				requested = append(requested, iv.Hash)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timeout waiting for getdata %d", i)
+		}
+	}
+	if !reflect.DeepEqual(requested, hashes) {
+		t.Fatalf("got requested blocks %v, want %v", requested, hashes)
+	}
+	select {
+	case msg := <-getData:
+		t.Fatalf("unexpected getdata with %d items", len(msg.InvList))
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+// TestHeaderArrivalsSkippedDuringIBD ensures headers received during the
+// initial block download are not recorded as block announcements while those
+// received once the chain is current are.
+func TestHeaderArrivalsSkippedDuringIBD(t *testing.T) {
+	tests := []struct {
+		name        string
+		genesisTime time.Time
+		want        bool
+	}{
+		{"initial block download", time.Now().Add(-time.Hour * 48), false},
+		{"current", time.Now(), true},
+	}
+
+	for _, test := range tests {
+		sm := newTestSyncManagerAt(t, test.genesisTime)
+		headers := testHeaders(sm.chain.BestSnapshot().Hash, 1)
+		sm.QueueHeaders(headers, nil)
+
+		blockHash := headers.Headers[0].BlockHash()
+		if _, ok := sm.BlockArrival(&blockHash); ok != test.want {
+			t.Errorf("%s: unexpected announcement tracking -- got "+
+				"%v, want %v", test.name, ok, test.want)
+		}
+	}
+}
@@ -29,12 +29,20 @@ import (
 
 const (
 	// MaxProtocolVersion is the max protocol version the peer supports.
-	MaxProtocolVersion = wire.FeeFilterVersion
+	MaxProtocolVersion = wire.ShortIDsBlocksVersion
 
 	// DefaultTrickleInterval is the min time between attempts to send an
 	// inv message to a peer.
 	DefaultTrickleInterval = 10 * time.Second
 
+	// DefaultHandshakeTimeout is the default maximum amount of time a peer
+	// is given to complete the initial version negotiation.
+	DefaultHandshakeTimeout = 30 * time.Second
+
+	// DefaultIdleTimeout is the default duration of inactivity before a
+	// peer is timed out.
+	DefaultIdleTimeout = 5 * time.Minute
+
 	// MinAcceptableProtocolVersion is the lowest protocol version that a
 	// connected peer may support.
 	MinAcceptableProtocolVersion = wire.MultipleAddressVersion
@@ -54,13 +62,6 @@ const (
 	// messages.
 	pingInterval = 2 * time.Minute
 
-	// negotiateTimeout is the duration of inactivity before we timeout a
-	// peer that hasn't completed the initial version negotiation.
-	negotiateTimeout = 30 * time.Second
-
-	// idleTimeout is the duration of inactivity before we time out a peer.
-	idleTimeout = 5 * time.Minute
-
 	// stallTickInterval is the interval of time between each check for
 	// stalled peers.
 	stallTickInterval = 15 * time.Second
@@ -197,6 +198,22 @@ type MessageListeners struct {
 	// message.
 	OnSendHeaders func(p *Peer, msg *wire.MsgSendHeaders)
 
+	// OnSendCmpct is invoked when a peer receives a sendcmpct bitcoin
+	// message.
+	OnSendCmpct func(p *Peer, msg *wire.MsgSendCmpct)
+
+	// OnCmpctBlock is invoked when a peer receives a cmpctblock bitcoin
+	// message.
+	OnCmpctBlock func(p *Peer, msg *wire.MsgCmpctBlock)
+
+	// OnGetBlockTxn is invoked when a peer receives a getblocktxn bitcoin
+	// message.
+	OnGetBlockTxn func(p *Peer, msg *wire.MsgGetBlockTxn)
+
+	// OnBlockTxn is invoked when a peer receives a blocktxn bitcoin
+	// message.
+	OnBlockTxn func(p *Peer, msg *wire.MsgBlockTxn)
+
 	// OnRead is invoked when a peer receives a bitcoin message.  It
 	// consists of the number of bytes read, the message, and whether or not
 	// an error in the read occurred.  Typically, callers will opt to use
@@ -272,6 +289,17 @@ type Config struct {
 	// inventory to a peer.
 	TrickleInterval time.Duration
 
+	// HandshakeTimeout is the maximum amount of time the remote peer is
+	// given to complete the initial version and verack exchange before it
+	// is disconnected.  This field can be omitted in which case
+	// DefaultHandshakeTimeout will be used.
+	HandshakeTimeout time.Duration
+
+	// IdleTimeout is the duration of inactivity after which the remote peer
+	// is disconnected.  This field can be omitted in which case
+	// DefaultIdleTimeout will be used.
+	IdleTimeout time.Duration
+
 	// AllowSelfConns is only used to allow the tests to bypass the self
 	// connection detecting and disconnect logic since they intentionally
 	// do so for testing purposes.
@@ -449,6 +477,11 @@ type Peer struct {
 	verAckReceived       bool
 	witnessEnabled       bool
 
+	// These flags track the compact block relay (BIP0152) preferences the
+	// remote peer signaled via sendcmpct messages of a supported version.
+	cmpctBlocksSupported bool // peer supports compact blocks
+	cmpctBlocksAnnounce  bool // peer wants compact blocks pushed directly
+
 	wireEncoding wire.MessageEncoding
 
 	knownInventory     lru.Cache
@@ -522,7 +555,15 @@ func (p *Peer) UpdateLastAnnouncedBlock(blkHash *chainhash.Hash) {
 //
 // This function is safe for concurrent access.
 func (p *Peer) AddKnownInventory(invVect *wire.InvVect) {
-	p.knownInventory.Add(invVect)
+	p.knownInventory.Add(*invVect)
+}
+
+// IsKnownInventory returns whether or not the passed inventory is in the cache
+// of known inventory for the peer.
+//
+// This function is safe for concurrent access.
+func (p *Peer) IsKnownInventory(invVect *wire.InvVect) bool {
+	return p.knownInventory.Contains(*invVect)
 }
 
 // StatsSnapshot returns a snapshot of the current peer flags and statistics.
@@ -801,6 +842,31 @@ func (p *Peer) WantsHeaders() bool {
 	return sendHeadersPreferred
 }
 
+// SupportsCmpctBlocks returns whether or not the peer has signaled support for
+// the compact block version supported by the wire package.
+//
+// This function is safe for concurrent access.
+func (p *Peer) SupportsCmpctBlocks() bool {
+	p.flagsMtx.Lock()
+	cmpctBlocksSupported := p.cmpctBlocksSupported
+	p.flagsMtx.Unlock()
+
+	return cmpctBlocksSupported
+}
+
+// WantsCmpctBlocks returns whether or not the peer has requested new blocks be
+// announced by sending them as compact blocks directly instead of announcing
+// them via inventory vectors or headers (high-bandwidth mode).
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsCmpctBlocks() bool {
+	p.flagsMtx.Lock()
+	cmpctBlocksAnnounce := p.cmpctBlocksAnnounce
+	p.flagsMtx.Unlock()
+
+	return cmpctBlocksAnnounce
+}
+
 // IsWitnessEnabled returns true if the peer has signalled that it supports
 // segregated witness.
 //
@@ -1093,7 +1159,14 @@ func (p *Peer) isAllowedReadError(err error) bool {
 	}
 
 	// Don't allow the error if it's not specifically a malformed message error.
-	if _, ok := err.(*wire.MessageError); !ok {
+	msgErr, ok := err.(*wire.MessageError)
+	if !ok {
+		return false
+	}
+
+	// Don't allow the error if the payload of the message was left unread
+	// since the following messages can't be read from the connection.
+	if msgErr.PayloadUnread {
 		return false
 	}
 
@@ -1326,6 +1399,7 @@ cleanup:
 func (p *Peer) inHandler() {
 	// The timer is stopped when a new message is received and reset after it
 	// is processed.
+	idleTimeout := p.cfg.IdleTimeout
 	idleTimer := time.AfterFunc(idleTimeout, func() {
 		log.Warnf("Peer %s no answer for %s -- disconnecting", p, idleTimeout)
 		p.Disconnect()
@@ -1525,6 +1599,36 @@ out:
 				p.cfg.Listeners.OnSendHeaders(p, msg)
 			}
 
+		case *wire.MsgSendCmpct:
+			// Only track the preferences for the supported compact
+			// block version.  Other versions are ignored as required
+			// by BIP0152.
+			if msg.CmpctBlockVersion == wire.CmpctBlockVersion {
+				p.flagsMtx.Lock()
+				p.cmpctBlocksSupported = true
+				p.cmpctBlocksAnnounce = msg.AnnounceUsingCmpctBlock
+				p.flagsMtx.Unlock()
+			}
+
+			if p.cfg.Listeners.OnSendCmpct != nil {
+				p.cfg.Listeners.OnSendCmpct(p, msg)
+			}
+
+		case *wire.MsgCmpctBlock:
+			if p.cfg.Listeners.OnCmpctBlock != nil {
+				p.cfg.Listeners.OnCmpctBlock(p, msg)
+			}
+
+		case *wire.MsgGetBlockTxn:
+			if p.cfg.Listeners.OnGetBlockTxn != nil {
+				p.cfg.Listeners.OnGetBlockTxn(p, msg)
+			}
+
+		case *wire.MsgBlockTxn:
+			if p.cfg.Listeners.OnBlockTxn != nil {
+				p.cfg.Listeners.OnBlockTxn(p, msg)
+			}
+
 		default:
 			log.Debugf("Received unhandled message of type %v "+
 				"from %v", rmsg.Command(), p)
@@ -1631,7 +1735,7 @@ out:
 
 				// Don't send inventory that became known after
 				// the initial check.
-				if p.knownInventory.Contains(iv) {
+				if p.knownInventory.Contains(*iv) {
 					continue
 				}
 
@@ -1837,7 +1941,7 @@ func (p *Peer) QueueMessageWithEncoding(msg wire.Message, doneChan chan<- struct
 func (p *Peer) QueueInventory(invVect *wire.InvVect) {
 	// Don't add the inventory to the send queue if the peer is already
 	// known to have it.
-	if p.knownInventory.Contains(invVect) {
+	if p.knownInventory.Contains(*invVect) {
 		return
 	}
 
@@ -2139,14 +2243,14 @@ func (p *Peer) start() error {
 		}
 	}()
 
-	// Negotiate the protocol within the specified negotiateTimeout.
+	// Negotiate the protocol within the configured handshake timeout.
 	select {
 	case err := <-negotiateErr:
 		if err != nil {
 			p.Disconnect()
 			return err
 		}
-	case <-time.After(negotiateTimeout):
+	case <-time.After(p.cfg.HandshakeTimeout):
 		p.Disconnect()
 		return errors.New("protocol negotiation timeout")
 	}
@@ -2226,6 +2330,15 @@ func newPeerBase(origCfg *Config, inbound bool) *Peer {
 		cfg.TrickleInterval = DefaultTrickleInterval
 	}
 
+	// Set the handshake and idle timeouts if non-positive values are
+	// specified.
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+
 	p := Peer{
 		inbound:         inbound,
 		wireEncoding:    wire.BaseEncoding,
@@ -6,9 +6,13 @@
 package peer_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net"
+	"runtime"
 	"strconv"
 	"testing"
 	"time"
@@ -447,6 +451,18 @@ func TestPeerListeners(t *testing.T) {
 			OnSendHeaders: func(p *peer.Peer, msg *wire.MsgSendHeaders) {
 				ok <- msg
 			},
+			OnSendCmpct: func(p *peer.Peer, msg *wire.MsgSendCmpct) {
+				ok <- msg
+			},
+			OnCmpctBlock: func(p *peer.Peer, msg *wire.MsgCmpctBlock) {
+				ok <- msg
+			},
+			OnGetBlockTxn: func(p *peer.Peer, msg *wire.MsgGetBlockTxn) {
+				ok <- msg
+			},
+			OnBlockTxn: func(p *peer.Peer, msg *wire.MsgBlockTxn) {
+				ok <- msg
+			},
 		},
 		UserAgentName:     "peer",
 		UserAgentVersion:  "1.0",
@@ -597,6 +613,23 @@ func TestPeerListeners(t *testing.T) {
 			"OnSendHeaders",
 			wire.NewMsgSendHeaders(),
 		},
+		{
+			"OnSendCmpct",
+			wire.NewMsgSendCmpct(true, wire.CmpctBlockVersion),
+		},
+		{
+			"OnCmpctBlock",
+			wire.NewMsgCmpctBlock(wire.NewBlockHeader(1,
+				&chainhash.Hash{}, &chainhash.Hash{}, 1, 1), 1),
+		},
+		{
+			"OnGetBlockTxn",
+			wire.NewMsgGetBlockTxn(&chainhash.Hash{}, []uint32{1}),
+		},
+		{
+			"OnBlockTxn",
+			wire.NewMsgBlockTxn(&chainhash.Hash{}),
+		},
 	}
 	t.Logf("Running %d tests", len(tests))
 	for _, test := range tests {
@@ -668,6 +701,14 @@ func TestOutboundPeer(t *testing.T) {
 	p.AddKnownInventory(fakeInv)
 	p.QueueInventory(fakeInv)
 
+	// Known inventory is tracked by value rather than by pointer.
+	if !p.IsKnownInventory(wire.NewInvVect(wire.InvTypeBlock, fakeBlockHash)) {
+		t.Fatal("IsKnownInventory: added inventory is not known")
+	}
+	if p.IsKnownInventory(wire.NewInvVect(wire.InvTypeTx, fakeBlockHash)) {
+		t.Fatal("IsKnownInventory: unexpected known inventory")
+	}
+
 	fakeMsg := wire.NewMsgVerAck()
 	p.QueueMessage(fakeMsg, nil)
 	done := make(chan struct{})
@@ -863,6 +904,73 @@ func TestUnsupportedVersionPeer(t *testing.T) {
 	}
 }
 
+// TestHandshakeTimeout ensures a peer that sends its version but never
+// completes the handshake with a verack is disconnected once the handshake
+// timeout expires.
+func TestHandshakeTimeout(t *testing.T) {
+	peerCfg := &peer.Config{
+		UserAgentName:    "peer",
+		UserAgentVersion: "1.0",
+		ChainParams:      &chaincfg.MainNetParams,
+		Services:         0,
+		HandshakeTimeout: 100 * time.Millisecond,
+		AllowSelfConns:   true,
+	}
+
+	localNA := wire.NewNetAddressIPPort(
+		net.ParseIP("10.0.0.1"),
+		uint16(8333),
+		wire.SFNodeNetwork,
+	)
+	remoteNA := wire.NewNetAddressIPPort(
+		net.ParseIP("10.0.0.2"),
+		uint16(8333),
+		wire.SFNodeNetwork,
+	)
+	localConn, remoteConn := pipe(
+		&conn{laddr: "10.0.0.1:8333", raddr: "10.0.0.2:8333"},
+		&conn{laddr: "10.0.0.2:8333", raddr: "10.0.0.1:8333"},
+	)
+
+	p, err := peer.NewOutboundPeer(peerCfg, "10.0.0.1:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err - %v\n", err)
+	}
+	p.AssociateConnection(localConn)
+
+	// Read the version message sent by the peer and reply with a version
+	// message, but never send the verack.
+	_, msg, _, err := wire.ReadMessageN(remoteConn, p.ProtocolVersion(),
+		peerCfg.ChainParams.Net)
+	if err != nil {
+		t.Fatalf("wire.ReadMessageN: unexpected err - %v\n", err)
+	}
+	if _, ok := msg.(*wire.MsgVersion); !ok {
+		t.Fatalf("Expected version message, got [%s]", msg.Command())
+	}
+	versionMsg := wire.NewMsgVersion(remoteNA, localNA, 0, 0)
+	_, err = wire.WriteMessageN(remoteConn.Writer, versionMsg,
+		uint32(versionMsg.ProtocolVersion), peerCfg.ChainParams.Net)
+	if err != nil {
+		t.Fatalf("wire.WriteMessageN: unexpected err - %v\n", err)
+	}
+
+	// Drain anything else the peer sends so it doesn't block on writes.
+	go io.Copy(ioutil.Discard, remoteConn)
+
+	// Expect the peer to disconnect once the handshake timeout expires.
+	disconnected := make(chan struct{})
+	go func() {
+		p.WaitForDisconnect()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("Peer did not disconnect after the handshake timeout")
+	}
+}
+
 // TestDuplicateVersionMsg ensures that receiving a version message after one
 // has already been received results in the peer being disconnected.
 func TestDuplicateVersionMsg(t *testing.T) {
@@ -923,6 +1031,170 @@ func TestDuplicateVersionMsg(t *testing.T) {
 	}
 }
 
+// connectedPeers returns an inbound and an outbound peer for the passed network
+// that are connected to each other over a fake loopback connection and have
+// completed the initial protocol version negotiation along with the connection
+// of the outbound peer.
+func connectedPeers(t *testing.T, params *chaincfg.Params) (*peer.Peer, *peer.Peer, *conn) {
+	t.Helper()
+
+	verack := make(chan struct{})
+	peerCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+		},
+		UserAgentName:    "peer",
+		UserAgentVersion: "1.0",
+		ChainParams:      params,
+		Services:         0,
+		AllowSelfConns:   true,
+	}
+	inConn, outConn := pipe(
+		&conn{laddr: "127.0.0.1:9108", raddr: "127.0.0.1:9109"},
+		&conn{laddr: "127.0.0.1:9109", raddr: "127.0.0.1:9108"},
+	)
+	outPeer, err := peer.NewOutboundPeer(peerCfg, inConn.laddr)
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err: %v\n", err)
+	}
+	outPeer.AssociateConnection(outConn)
+	inPeer := peer.NewInboundPeer(peerCfg)
+	inPeer.AssociateConnection(inConn)
+
+	// Wait for the veracks from the initial protocol version negotiation.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-verack:
+		case <-time.After(time.Second):
+			t.Fatal("verack timeout")
+		}
+	}
+	return inPeer, outPeer, outConn
+}
+
+// messageHeader returns the wire encoding of a message header for the passed
+// network with the passed command, payload length, and checksum.
+func messageHeader(btcnet wire.BitcoinNet, command string, length uint32, checksum []byte) []byte {
+	var hdr [wire.MessageHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(btcnet))
+	copy(hdr[4:16], command)
+	binary.LittleEndian.PutUint32(hdr[16:20], length)
+	copy(hdr[20:24], checksum)
+	return hdr[:]
+}
+
+// TestOversizedMessageDisconnect ensures a peer that sends a message whose
+// header indicates a payload larger than the max payload for the message type
+// is disconnected immediately without waiting for or allocating the payload.
+// This includes local peers in regression test mode, which are otherwise
+// allowed to send malformed messages, since the unread payload leaves the
+// connection unusable.
+func TestOversizedMessageDisconnect(t *testing.T) {
+	for _, params := range []*chaincfg.Params{
+		&chaincfg.MainNetParams,
+		&chaincfg.RegressionNetParams,
+	} {
+		inPeer, outPeer, outConn := connectedPeers(t, params)
+
+		// Send the header of a block message that exceeds the max block
+		// payload from the outbound peer's connection without sending
+		// the payload.
+		hdr := messageHeader(params.Net, wire.CmdBlock,
+			wire.MaxBlockPayload+1, nil)
+		go outConn.Write(hdr)
+
+		// Ensure the peer that receives the message disconnects without
+		// a large allocation.
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		disconnected := make(chan struct{}, 1)
+		go func() {
+			inPeer.WaitForDisconnect()
+			disconnected <- struct{}{}
+		}()
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: peer did not disconnect", params.Name)
+		}
+		runtime.ReadMemStats(&after)
+		const maxAlloc = 1 << 20
+		allocated := after.TotalAlloc - before.TotalAlloc
+		if allocated > maxAlloc {
+			t.Fatalf("%s: allocated %d bytes, want at most %d",
+				params.Name, allocated, maxAlloc)
+		}
+		outPeer.Disconnect()
+	}
+}
+
+// TestBadChecksumDisconnect ensures a peer that sends a message whose payload
+// does not match the checksum in its header is disconnected before the message
+// is dispatched.
+func TestBadChecksumDisconnect(t *testing.T) {
+	inPeer, outPeer, outConn := connectedPeers(t, &chaincfg.MainNetParams)
+	defer outPeer.Disconnect()
+
+	// Send a valid ping message from the outbound peer's connection with a
+	// checksum that doesn't match its payload.
+	var payload bytes.Buffer
+	if err := wire.NewMsgPing(1).BtcEncode(&payload, wire.ProtocolVersion,
+		wire.BaseEncoding); err != nil {
+
+		t.Fatalf("BtcEncode: unexpected err: %v", err)
+	}
+	checksum := chainhash.DoubleHashB(payload.Bytes())[:4]
+	checksum[0] ^= 0xff
+	msg := messageHeader(wire.MainNet, wire.CmdPing, uint32(payload.Len()),
+		checksum)
+	msg = append(msg, payload.Bytes()...)
+	go outConn.Write(msg)
+
+	// Ensure the peer that receives the message disconnects.
+	disconnected := make(chan struct{}, 1)
+	go func() {
+		inPeer.WaitForDisconnect()
+		disconnected <- struct{}{}
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("peer did not disconnect")
+	}
+}
+
+// TestWrongNetworkDisconnect ensures a peer that sends a message with the magic
+// of another network is disconnected, including local peers in regression test
+// mode.
+func TestWrongNetworkDisconnect(t *testing.T) {
+	for _, params := range []*chaincfg.Params{
+		&chaincfg.MainNetParams,
+		&chaincfg.RegressionNetParams,
+	} {
+		inPeer, outPeer, outConn := connectedPeers(t, params)
+
+		// Send a verack message with the testnet magic to the peer.
+		checksum := chainhash.DoubleHashB(nil)[:4]
+		hdr := messageHeader(wire.TestNet3, wire.CmdVerAck, 0, checksum)
+		go outConn.Write(hdr)
+
+		// Ensure the peer that receives the message disconnects.
+		disconnected := make(chan struct{}, 1)
+		go func() {
+			inPeer.WaitForDisconnect()
+			disconnected <- struct{}{}
+		}()
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: peer did not disconnect", params.Name)
+		}
+		outPeer.Disconnect()
+	}
+}
+
 // TestUpdateLastBlockHeight ensures the last block height is set properly
 // during the initial version negotiation and is only allowed to advance to
 // higher values via the associated update function.
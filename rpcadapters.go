@@ -7,6 +7,7 @@ package main
 import (
 	"sync/atomic"
 
+	"github.com/btcsuite/btcd/addrmgr"
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/mempool"
@@ -217,6 +218,16 @@ func (cm *rpcConnManager) AddRebroadcastInventory(iv *wire.InvVect, data interfa
 	cm.server.AddRebroadcastInventory(iv, data)
 }
 
+// RebroadcastTransactions relays inventory vectors for all of the locally
+// submitted transactions that have not been requested by any peers yet and
+// returns their descriptors.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) RebroadcastTransactions() []*mempool.TxDesc {
+	return cm.server.RebroadcastTransactions()
+}
+
 // RelayTransactions generates and relays inventory vectors for all of the
 // passed transactions to all connected peers.
 func (cm *rpcConnManager) RelayTransactions(txns []*mempool.TxDesc) {
@@ -232,6 +243,24 @@ func (cm *rpcConnManager) NodeAddresses() []*wire.NetAddress {
 	return cm.server.addrManager.AddressCache()
 }
 
+// AddrManagerStats returns statistics about the addresses known to the address
+// manager.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) AddrManagerStats() addrmgr.Stats {
+	return cm.server.addrManager.Stats()
+}
+
+// KnownAddresses returns details about up to the passed number of addresses
+// known to the address manager selected at random.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverConnManager interface implementation.
+func (cm *rpcConnManager) KnownAddresses(max int) []addrmgr.KnownAddressInfo {
+	return cm.server.addrManager.KnownAddresses(max)
+}
+
 // rpcSyncMgr provides a block manager for use with the RPC server and
 // implements the rpcserverSyncManager interface.
 type rpcSyncMgr struct {
@@ -977,6 +977,65 @@ func (c *Client) VerifyChainBlocks(checkLevel, numBlocks int32) (bool, error) {
 	return c.VerifyChainBlocksAsync(checkLevel, numBlocks).Receive()
 }
 
+// FutureVerifyTxOutProofResult is a future promise to deliver the result of a
+// VerifyTxOutProofAsync RPC invocation (or an applicable error).
+type FutureVerifyTxOutProofResult chan *Response
+
+// Receive waits for the Response promised by the future and returns the hashes
+// of the transactions the proof commits to.
+func (r FutureVerifyTxOutProofResult) Receive() ([]chainhash.Hash, error) {
+	res, err := ReceiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as an array of strings.
+	var txIDs []string
+	err = json.Unmarshal(res, &txIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	txHashes := make([]chainhash.Hash, 0, len(txIDs))
+	for _, txID := range txIDs {
+		txHash, err := chainhash.NewHashFromStr(txID)
+		if err != nil {
+			return nil, err
+		}
+		txHashes = append(txHashes, *txHash)
+	}
+	return txHashes, nil
+}
+
+// VerifyTxOutProofAsync returns an instance of a type that can be used to get
+// the result of the RPC at some future time by invoking the Receive function on
+// the returned instance.
+//
+// See VerifyTxOutProof for the blocking version and more details.
+func (c *Client) VerifyTxOutProofAsync(proof *wire.MsgMerkleBlock) FutureVerifyTxOutProofResult {
+	proofHex := ""
+	if proof != nil {
+		// Serialize the merkle block.
+		var buf bytes.Buffer
+		err := proof.BtcEncode(&buf, wire.ProtocolVersion,
+			wire.BaseEncoding)
+		if err != nil {
+			return newFutureError(err)
+		}
+		proofHex = hex.EncodeToString(buf.Bytes())
+	}
+
+	cmd := btcjson.NewVerifyTxOutProofCmd(proofHex)
+	return c.SendCmd(cmd)
+}
+
+// VerifyTxOutProof verifies the passed merkle block returned by GetTxOutProof
+// and returns the hashes of the transactions it commits to.  No hashes are
+// returned for proofs that are invalid.
+func (c *Client) VerifyTxOutProof(proof *wire.MsgMerkleBlock) ([]chainhash.Hash, error) {
+	return c.VerifyTxOutProofAsync(proof).Receive()
+}
+
 // FutureGetTxOutResult is a future promise to deliver the result of a
 // GetTxOutAsync RPC invocation (or an applicable error).
 type FutureGetTxOutResult chan *Response
@@ -1026,6 +1085,68 @@ func (c *Client) GetTxOut(txHash *chainhash.Hash, index uint32, mempool bool) (*
 	return c.GetTxOutAsync(txHash, index, mempool).Receive()
 }
 
+// FutureGetTxOutProofResult is a future promise to deliver the result of a
+// GetTxOutProofAsync RPC invocation (or an applicable error).
+type FutureGetTxOutProofResult chan *Response
+
+// Receive waits for the Response promised by the future and returns the merkle
+// block proving the requested transactions are included in a block.
+func (r FutureGetTxOutProofResult) Receive() (*wire.MsgMerkleBlock, error) {
+	res, err := ReceiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as a string.
+	var proofHex string
+	err = json.Unmarshal(res, &proofHex)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the serialized merkle block hex to raw bytes.
+	serializedProof, err := hex.DecodeString(proofHex)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deserialize the merkle block and return it.
+	var merkleBlock wire.MsgMerkleBlock
+	err = merkleBlock.BtcDecode(bytes.NewReader(serializedProof),
+		wire.ProtocolVersion, wire.BaseEncoding)
+	if err != nil {
+		return nil, err
+	}
+	return &merkleBlock, nil
+}
+
+// GetTxOutProofAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See GetTxOutProof for the blocking version and more details.
+func (c *Client) GetTxOutProofAsync(txHashes []chainhash.Hash, blockHash *chainhash.Hash) FutureGetTxOutProofResult {
+	txIDs := make([]string, 0, len(txHashes))
+	for i := range txHashes {
+		txIDs = append(txIDs, txHashes[i].String())
+	}
+	var hash *string
+	if blockHash != nil {
+		hash = btcjson.String(blockHash.String())
+	}
+
+	cmd := btcjson.NewGetTxOutProofCmd(txIDs, hash)
+	return c.SendCmd(cmd)
+}
+
+// GetTxOutProof returns a merkle block proving the passed transactions are
+// included in the block with the passed hash.  When the block hash is nil, the
+// server looks up the block containing the first transaction, which requires
+// it to have the transaction index enabled.
+func (c *Client) GetTxOutProof(txHashes []chainhash.Hash, blockHash *chainhash.Hash) (*wire.MsgMerkleBlock, error) {
+	return c.GetTxOutProofAsync(txHashes, blockHash).Receive()
+}
+
 // FutureGetTxOutSetInfoResult is a future promise to deliver the result of a
 // GetTxOutSetInfoAsync RPC invocation (or an applicable error).
 type FutureGetTxOutSetInfoResult chan *Response
@@ -1064,6 +1185,53 @@ func (c *Client) GetTxOutSetInfo() (*btcjson.GetTxOutSetInfoResult, error) {
 	return c.GetTxOutSetInfoAsync().Receive()
 }
 
+// FutureGetTxSpendingPrevOutResult is a future promise to deliver the result of
+// a GetTxSpendingPrevOutAsync RPC invocation (or an applicable error).
+type FutureGetTxSpendingPrevOutResult chan *Response
+
+// Receive waits for the Response promised by the future and returns the
+// memory pool transactions spending the outpoints that were queried.
+func (r FutureGetTxSpendingPrevOutResult) Receive() ([]btcjson.GetTxSpendingPrevOutResult, error) {
+	res, err := ReceiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as an array of gettxspendingprevout result objects.
+	var spendingResults []btcjson.GetTxSpendingPrevOutResult
+	err = json.Unmarshal(res, &spendingResults)
+	if err != nil {
+		return nil, err
+	}
+
+	return spendingResults, nil
+}
+
+// GetTxSpendingPrevOutAsync returns an instance of a type that can be used to
+// get the result of the RPC at some future time by invoking the Receive
+// function on the returned instance.
+//
+// See GetTxSpendingPrevOut for the blocking version and more details.
+func (c *Client) GetTxSpendingPrevOutAsync(outPoints []wire.OutPoint) FutureGetTxSpendingPrevOutResult {
+	outputs := make([]btcjson.TransactionInput, 0, len(outPoints))
+	for _, outPoint := range outPoints {
+		outputs = append(outputs, btcjson.TransactionInput{
+			Txid: outPoint.Hash.String(),
+			Vout: outPoint.Index,
+		})
+	}
+
+	cmd := btcjson.NewGetTxSpendingPrevOutCmd(outputs)
+	return c.SendCmd(cmd)
+}
+
+// GetTxSpendingPrevOut returns, for each of the passed outpoints, the hash of
+// the memory pool transaction spending it.  The spending transaction hash is
+// empty for outpoints that are not spent by a transaction in the memory pool.
+func (c *Client) GetTxSpendingPrevOut(outPoints []wire.OutPoint) ([]btcjson.GetTxSpendingPrevOutResult, error) {
+	return c.GetTxSpendingPrevOutAsync(outPoints).Receive()
+}
+
 // FutureRescanBlocksResult is a future promise to deliver the result of a
 // RescanBlocksAsync RPC invocation (or an applicable error).
 //
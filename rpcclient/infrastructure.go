@@ -83,11 +83,45 @@ const (
 	// channel can queue before blocking.
 	sendPostBufferSize = 100
 
+	// defaultHTTPPostPoolSize is the default maximum number of idle
+	// connections to the RPC server that are kept open for reuse when
+	// running in HTTP POST mode.
+	defaultHTTPPostPoolSize = 2
+
+	// httpPostIdleTimeout is the amount of time an idle connection to the
+	// RPC server is kept open for reuse when running in HTTP POST mode.
+	httpPostIdleTimeout = time.Second * 90
+
 	// connectionRetryInterval is the amount of time to wait in between
 	// retries when automatically reconnecting to an RPC server.
 	connectionRetryInterval = time.Second * 5
+
+	// maxConnectionRetryInterval is the maximum amount of time to wait in
+	// between retries when automatically reconnecting to an RPC server.
+	maxConnectionRetryInterval = time.Minute
 )
 
+// retryBackoff returns the amount of time to wait before the next connection
+// attempt given the number of consecutive failed attempts.  The interval
+// doubles with each failure, starting at the passed base interval, up to the
+// passed maximum.
+func retryBackoff(retryCount int64, base, max time.Duration) time.Duration {
+	if retryCount <= 0 {
+		return 0
+	}
+	backoff := base
+	for i := int64(1); i < retryCount; i++ {
+		backoff *= 2
+		if backoff >= max {
+			return max
+		}
+	}
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
 // sendPostDetails houses an HTTP POST request to send to an RPC server as well
 // as the original JSON-RPC command and a channel to reply on when the server
 // responds with the result.
@@ -679,7 +713,7 @@ func (c *Client) resendRequests() {
 }
 
 // wsReconnectHandler listens for client disconnects and automatically tries
-// to reconnect with retry interval that scales based on the number of retries.
+// to reconnect with a retry interval that doubles with each failed attempt.
 // It also resends any commands that had not completed when the client
 // disconnected so the disconnect/reconnect process is largely transparent to
 // the caller.  This function is not run when the DisableAutoReconnect config
@@ -693,6 +727,11 @@ out:
 		case <-c.disconnect:
 			// On disconnect, fallthrough to reestablish the
 			// connection.
+			if c.ntfnHandlers != nil &&
+				c.ntfnHandlers.OnClientDisconnected != nil {
+
+				c.ntfnHandlers.OnClientDisconnected()
+			}
 
 		case <-c.shutdown:
 			break out
@@ -712,17 +751,26 @@ out:
 				log.Infof("Failed to connect to %s: %v",
 					c.config.Host, err)
 
-				// Scale the retry interval by the number of
-				// retries so there is a backoff up to a max
-				// of 1 minute.
-				scaledInterval := connectionRetryInterval.Nanoseconds() * c.retryCount
-				scaledDuration := time.Duration(scaledInterval)
-				if scaledDuration > time.Minute {
-					scaledDuration = time.Minute
-				}
+				// Double the retry interval with each failed
+				// attempt so there is an exponential backoff
+				// up to the configured maximum.
+				backoff := retryBackoff(c.retryCount,
+					c.config.reconnectInterval(),
+					c.config.maxReconnectInterval())
 				log.Infof("Retrying connection to %s in "+
-					"%s", c.config.Host, scaledDuration)
-				time.Sleep(scaledDuration)
+					"%s", c.config.Host, backoff)
+				if c.ntfnHandlers != nil &&
+					c.ntfnHandlers.OnClientReconnecting != nil {
+
+					c.ntfnHandlers.OnClientReconnecting(
+						c.retryCount, backoff)
+				}
+
+				select {
+				case <-time.After(backoff):
+				case <-c.shutdown:
+					break out
+				}
 				continue reconnect
 			}
 
@@ -737,10 +785,10 @@ out:
 
 			// Reset the connection state and signal the reconnect
 			// has happened.
-			c.wsConn = wsConn
 			c.retryCount = 0
 
 			c.mtx.Lock()
+			c.wsConn = wsConn
 			c.disconnect = make(chan struct{})
 			c.disconnected = false
 			c.mtx.Unlock()
@@ -844,6 +892,10 @@ cleanup:
 			break cleanup
 		}
 	}
+
+	// Close any idle connections that were being kept open for reuse.
+	c.httpClient.CloseIdleConnections()
+
 	c.wg.Done()
 	log.Tracef("RPC client send handler done for %s", c.config.Host)
 
@@ -886,10 +938,9 @@ func ReceiveFuture(f chan *Response) ([]byte, error) {
 }
 
 // sendPost sends the passed request to the server by issuing an HTTP POST
-// request using the provided response channel for the reply.  Typically a new
-// connection is opened and closed for each command when using this method,
-// however, the underlying HTTP client might coalesce multiple commands
-// depending on several factors including the remote server configuration.
+// request using the provided response channel for the reply.  Connections to
+// the server are kept alive and reused for subsequent commands unless the
+// DisableKeepAlive option is set or the remote server closes them.
 func (c *Client) sendPost(jReq *jsonRequest) {
 	// Generate a request to the configured RPC server.
 	protocol := "http"
@@ -903,7 +954,7 @@ func (c *Client) sendPost(jReq *jsonRequest) {
 		jReq.responseChan <- &Response{result: nil, err: err}
 		return
 	}
-	httpReq.Close = true
+	httpReq.Close = c.config.DisableKeepAlive
 	httpReq.Header.Set("Content-Type", "application/json")
 	for key, value := range c.config.ExtraHeaders {
 		httpReq.Header.Set(key, value)
@@ -1230,6 +1281,34 @@ type ConnConfig struct {
 	// flag can be set to true to use basic HTTP POST requests instead.
 	HTTPPostMode bool
 
+	// DisableCompression instructs the client to not request compressed
+	// responses when running in HTTP POST mode.  By default the client
+	// advertises gzip support via the Accept-Encoding header and
+	// transparently decompresses replies the server chooses to compress.
+	DisableCompression bool
+
+	// DisableKeepAlive instructs the client to open a new connection for
+	// every request and close it once the reply has been read when running
+	// in HTTP POST mode.  By default connections are kept alive and reused.
+	DisableKeepAlive bool
+
+	// ReconnectInterval is the amount of time to wait before the first
+	// retry when a connection attempt to the RPC server fails.  The
+	// interval doubles with each subsequent failed attempt.  A value of
+	// zero uses the default of 5 seconds.
+	ReconnectInterval time.Duration
+
+	// MaxReconnectInterval is the maximum amount of time to wait in between
+	// connection attempts to the RPC server.  A value of zero uses the
+	// default of 1 minute.
+	MaxReconnectInterval time.Duration
+
+	// HTTPPostPoolSize is the maximum number of idle connections to the
+	// RPC server that are kept open for reuse when running in HTTP POST
+	// mode.  A value of zero uses the default of 2.  Idle connections are
+	// closed when the client is shutdown.
+	HTTPPostPoolSize int
+
 	// ExtraHeaders specifies the extra headers when perform request. It's
 	// useful when RPC provider need customized headers.
 	ExtraHeaders map[string]string
@@ -1239,6 +1318,24 @@ type ConnConfig struct {
 	EnableBCInfoHacks bool
 }
 
+// reconnectInterval returns the initial interval to wait in between connection
+// attempts, taking the default into account.
+func (config *ConnConfig) reconnectInterval() time.Duration {
+	if config.ReconnectInterval <= 0 {
+		return connectionRetryInterval
+	}
+	return config.ReconnectInterval
+}
+
+// maxReconnectInterval returns the maximum interval to wait in between
+// connection attempts, taking the default into account.
+func (config *ConnConfig) maxReconnectInterval() time.Duration {
+	if config.MaxReconnectInterval <= 0 {
+		return maxConnectionRetryInterval
+	}
+	return config.MaxReconnectInterval
+}
+
 // getAuth returns the username and passphrase that will actually be used for
 // this connection.  This will be the result of checking the cookie if a cookie
 // path is configured; if not, it will be the user-configured username and
@@ -1301,10 +1398,20 @@ func newHTTPClient(config *ConnConfig) (*http.Client, error) {
 		}
 	}
 
+	poolSize := config.HTTPPostPoolSize
+	if poolSize <= 0 {
+		poolSize = defaultHTTPPostPoolSize
+	}
+
 	client := http.Client{
 		Transport: &http.Transport{
-			Proxy:           proxyFunc,
-			TLSClientConfig: tlsConfig,
+			Proxy:               proxyFunc,
+			TLSClientConfig:     tlsConfig,
+			DisableCompression:  config.DisableCompression,
+			DisableKeepAlives:   config.DisableKeepAlive,
+			MaxIdleConns:        poolSize,
+			MaxIdleConnsPerHost: poolSize,
+			IdleConnTimeout:     httpPostIdleTimeout,
 		},
 	}
 
@@ -1505,18 +1612,17 @@ func (c *Client) Connect(tries int) error {
 		return ErrClientAlreadyConnected
 	}
 
-	// Begin connection attempts.  Increase the backoff after each failed
-	// attempt, up to a maximum of one minute.
+	// Begin connection attempts.  Double the backoff after each failed
+	// attempt, up to the configured maximum.
 	var err error
 	var backoff time.Duration
 	for i := 0; tries == 0 || i < tries; i++ {
 		var wsConn *websocket.Conn
 		wsConn, err = dial(c.config)
 		if err != nil {
-			backoff = connectionRetryInterval * time.Duration(i+1)
-			if backoff > time.Minute {
-				backoff = time.Minute
-			}
+			backoff = retryBackoff(int64(i+1),
+				c.config.reconnectInterval(),
+				c.config.maxReconnectInterval())
 			time.Sleep(backoff)
 			continue
 		}
@@ -0,0 +1,281 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpcclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/websocket"
+)
+
+// newCountingServer returns a test HTTP server that replies to every request
+// with a block count of one along with a pointer to the number of connections
+// that have been opened to it.
+func newCountingServer() (*httptest.Server, *int32) {
+	var numConns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(ioutil.Discard, r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"result":1,"error":null,"id":1}`)
+		}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&numConns, 1)
+		}
+	}
+	server.Start()
+	return server, &numConns
+}
+
+// TestHTTPPostConnReuse ensures connections are reused across sequential
+// requests in HTTP POST mode and that a new connection is opened for each
+// request when keep-alive is disabled.
+func TestHTTPPostConnReuse(t *testing.T) {
+	const numCalls = 20
+
+	tests := []struct {
+		name      string
+		keepAlive bool
+		wantConns int32
+	}{
+		{name: "keep-alive", keepAlive: true, wantConns: 1},
+		{name: "no keep-alive", keepAlive: false, wantConns: numCalls},
+	}
+
+	for _, test := range tests {
+		server, numConns := newCountingServer()
+
+		client, err := New(&ConnConfig{
+			Host:             strings.TrimPrefix(server.URL, "http://"),
+			User:             "user",
+			Pass:             "pass",
+			DisableTLS:       true,
+			HTTPPostMode:     true,
+			DisableKeepAlive: !test.keepAlive,
+		}, nil)
+		if err != nil {
+			t.Fatalf("%s: unable to create client: %v", test.name, err)
+		}
+
+		for i := 0; i < numCalls; i++ {
+			if _, err := client.GetBlockCount(); err != nil {
+				t.Fatalf("%s: call #%d failed: %v", test.name, i, err)
+			}
+		}
+
+		client.Shutdown()
+		client.WaitForShutdown()
+		server.Close()
+
+		if got := atomic.LoadInt32(numConns); got != test.wantConns {
+			t.Errorf("%s: unexpected number of connections - got %d, "+
+				"want %d", test.name, got, test.wantConns)
+		}
+	}
+}
+
+// BenchmarkHTTPPostSequentialCalls benchmarks issuing sequential calls in HTTP
+// POST mode and reports the number of connections opened per call.
+func BenchmarkHTTPPostSequentialCalls(b *testing.B) {
+	server, numConns := newCountingServer()
+	defer server.Close()
+
+	client, err := New(&ConnConfig{
+		Host:         strings.TrimPrefix(server.URL, "http://"),
+		User:         "user",
+		Pass:         "pass",
+		DisableTLS:   true,
+		HTTPPostMode: true,
+	}, nil)
+	if err != nil {
+		b.Fatalf("unable to create client: %v", err)
+	}
+	defer func() {
+		client.Shutdown()
+		client.WaitForShutdown()
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.GetBlockCount(); err != nil {
+			b.Fatalf("call #%d failed: %v", i, err)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt32(numConns))/float64(b.N),
+		"conns/op")
+}
+
+// TestRetryBackoff ensures the interval between connection attempts doubles
+// with each failed attempt and is capped at the maximum.
+func TestRetryBackoff(t *testing.T) {
+	const base, max = time.Second * 5, time.Minute
+
+	tests := []struct {
+		retryCount int64
+		want       time.Duration
+	}{
+		{retryCount: 0, want: 0},
+		{retryCount: 1, want: time.Second * 5},
+		{retryCount: 2, want: time.Second * 10},
+		{retryCount: 3, want: time.Second * 20},
+		{retryCount: 4, want: time.Second * 40},
+		{retryCount: 5, want: time.Minute},
+		{retryCount: 1000, want: time.Minute},
+	}
+
+	for _, test := range tests {
+		got := retryBackoff(test.retryCount, base, max)
+		if got != test.want {
+			t.Errorf("retryBackoff(%d): got %v, want %v",
+				test.retryCount, got, test.want)
+		}
+	}
+}
+
+// wsTestConn houses a websocket connection accepted by the test server along
+// with the methods of the requests received over it.
+type wsTestConn struct {
+	conn    *websocket.Conn
+	methods chan string
+}
+
+// newWebsocketServer returns a test websocket RPC server that replies to every
+// request with a null result.  Each accepted connection is delivered on the
+// returned channel.
+func newWebsocketServer(t *testing.T) (*httptest.Server, chan *wsTestConn) {
+	conns := make(chan *wsTestConn, 10)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("unable to upgrade connection: %v", err)
+				return
+			}
+			wsConn := &wsTestConn{
+				conn:    conn,
+				methods: make(chan string, 10),
+			}
+			conns <- wsConn
+
+			for {
+				_, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				var req struct {
+					Method string          `json:"method"`
+					ID     json.RawMessage `json:"id"`
+				}
+				if err := json.Unmarshal(msg, &req); err != nil {
+					t.Errorf("unable to unmarshal request: %v",
+						err)
+					return
+				}
+				wsConn.methods <- req.Method
+
+				reply := fmt.Sprintf(`{"result":null,"error":null,`+
+					`"id":%s}`, req.ID)
+				err = conn.WriteMessage(websocket.TextMessage,
+					[]byte(reply))
+				if err != nil {
+					return
+				}
+			}
+		}))
+	return server, conns
+}
+
+// TestWebsocketReconnect ensures the client reconnects after the connection to
+// the server is dropped and re-establishes its notification registrations.
+func TestWebsocketReconnect(t *testing.T) {
+	server, conns := newWebsocketServer(t)
+	defer server.Close()
+
+	connected := make(chan struct{}, 10)
+	disconnected := make(chan struct{}, 10)
+	client, err := New(&ConnConfig{
+		Host:       strings.TrimPrefix(server.URL, "http://"),
+		Endpoint:   "ws",
+		User:       "user",
+		Pass:       "pass",
+		DisableTLS: true,
+	}, &NotificationHandlers{
+		OnClientConnected: func() {
+			connected <- struct{}{}
+		},
+		OnClientDisconnected: func() {
+			disconnected <- struct{}{}
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to create client: %v", err)
+	}
+	defer func() {
+		client.Shutdown()
+		client.WaitForShutdown()
+	}()
+
+	// wait blocks until a value is received on the passed channel or fails
+	// the test after a timeout.
+	wait := func(c chan struct{}, what string) {
+		select {
+		case <-c:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+	nextConn := func() *wsTestConn {
+		select {
+		case conn := <-conns:
+			return conn
+		case <-time.After(time.Second * 5):
+			t.Fatal("timeout waiting for connection")
+		}
+		return nil
+	}
+	nextMethod := func(conn *wsTestConn) string {
+		select {
+		case method := <-conn.methods:
+			return method
+		case <-time.After(time.Second * 5):
+			t.Fatal("timeout waiting for request")
+		}
+		return ""
+	}
+
+	// Register for block notifications over the initial connection.
+	first := nextConn()
+	wait(connected, "initial connection")
+	if err := client.NotifyBlocks(); err != nil {
+		t.Fatalf("NotifyBlocks: unexpected error: %v", err)
+	}
+	if method := nextMethod(first); method != "notifyblocks" {
+		t.Fatalf("unexpected method on initial connection - got %q, "+
+			"want %q", method, "notifyblocks")
+	}
+
+	// Drop the connection from the server side and ensure the client
+	// reconnects and registers for block notifications again.
+	first.conn.Close()
+	wait(disconnected, "disconnect")
+	second := nextConn()
+	wait(connected, "reconnection")
+	if method := nextMethod(second); method != "notifyblocks" {
+		t.Fatalf("unexpected method after reconnect - got %q, want %q",
+			method, "notifyblocks")
+	}
+}
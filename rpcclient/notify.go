@@ -90,6 +90,18 @@ type NotificationHandlers struct {
 	// notification handlers, and is safe for blocking client requests.
 	OnClientConnected func()
 
+	// OnClientDisconnected is invoked when the client loses its connection
+	// to the RPC server and automatic reconnect is enabled.  The client
+	// does not attempt to reconnect until the callback returns, so it must
+	// NOT issue blocking client requests.
+	OnClientDisconnected func()
+
+	// OnClientReconnecting is invoked after a failed attempt to reconnect
+	// to the RPC server with the number of consecutive failed attempts and
+	// the amount of time the client will wait before trying again.  The
+	// same restrictions as OnClientDisconnected apply.
+	OnClientReconnecting func(retryCount int64, retryIn time.Duration)
+
 	// OnBlockConnected is invoked when a block is connected to the longest
 	// (best) chain.  It will only be invoked if a preceding call to
 	// NotifyBlocks has been made to register for the notification and the
@@ -0,0 +1,105 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"strconv"
+	"strings"
+)
+
+const (
+	// rpcCompressionMinSize is the minimum size in bytes a reply must be
+	// before it is compressed.  Compressing smaller replies typically saves
+	// little to nothing on the wire and only costs CPU time on both ends.
+	rpcCompressionMinSize = 1024
+
+	// encodingGzip and encodingDeflate are the HTTP content codings the RPC
+	// server is able to produce.
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// acceptedEncoding parses the value of an Accept-Encoding request header and
+// returns the preferred content coding supported by the server, or an empty
+// string when the client does not accept any of them.  Codings with a quality
+// value of zero are treated as unacceptable per RFC 7231.  The wildcard coding
+// only applies to codings that are not listed explicitly, so an explicitly
+// refused coding stays refused.  Gzip is preferred over deflate when both are
+// acceptable.
+func acceptedEncoding(header string) string {
+	// acceptable maps the codings listed in the header to whether they are
+	// acceptable to the client.
+	acceptable := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		// Codings with a quality value of zero are refused.
+		ok := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			q, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil || q <= 0 {
+				ok = false
+			}
+		}
+		acceptable[coding] = ok
+	}
+
+	for _, encoding := range []string{encodingGzip, encodingDeflate} {
+		ok, listed := acceptable[encoding]
+		if !listed {
+			ok = acceptable["*"]
+		}
+		if ok {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressRPCReply compresses the passed reply using the preferred content
+// coding listed in the provided Accept-Encoding header value.  The returned
+// encoding is the value to use for the Content-Encoding response header and is
+// empty when the reply is returned unmodified, which is the case when the
+// reply is smaller than rpcCompressionMinSize, the client does not accept a
+// supported coding, or compression fails.
+func compressRPCReply(acceptEncoding string, reply []byte) ([]byte, string) {
+	if len(reply) < rpcCompressionMinSize {
+		return reply, ""
+	}
+	encoding := acceptedEncoding(acceptEncoding)
+	if encoding == "" {
+		return reply, ""
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch encoding {
+	case encodingGzip:
+		w := gzip.NewWriter(&buf)
+		if _, err = w.Write(reply); err == nil {
+			err = w.Close()
+		}
+	case encodingDeflate:
+		w := zlib.NewWriter(&buf)
+		if _, err = w.Write(reply); err == nil {
+			err = w.Close()
+		}
+	}
+	if err != nil {
+		rpcsLog.Errorf("Failed to compress reply using %s: %v",
+			encoding, err)
+		return reply, ""
+	}
+
+	return buf.Bytes(), encoding
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// TestAcceptedEncoding ensures the Accept-Encoding header is parsed into the
+// expected content coding.
+func TestAcceptedEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "identity", want: ""},
+		{header: "gzip", want: encodingGzip},
+		{header: "GZIP", want: encodingGzip},
+		{header: "deflate", want: encodingDeflate},
+		{header: "deflate, gzip", want: encodingGzip},
+		{header: "br;q=1.0, deflate;q=0.5", want: encodingDeflate},
+		{header: "gzip;q=0, deflate", want: encodingDeflate},
+		{header: "gzip;q=0", want: ""},
+		{header: "*", want: encodingGzip},
+		{header: "*;q=0", want: ""},
+		{header: "gzip;q=0, *", want: encodingDeflate},
+		{header: "*, gzip;q=0", want: encodingDeflate},
+		{header: "gzip;q=0, deflate;q=0, *", want: ""},
+	}
+
+	for i, test := range tests {
+		got := acceptedEncoding(test.header)
+		if got != test.want {
+			t.Errorf("acceptedEncoding #%d (%q): got %q, want %q", i,
+				test.header, got, test.want)
+		}
+	}
+}
+
+// TestCompressRPCReply ensures large replies are compressed with the coding
+// advertised by the client while small replies are left untouched.
+func TestCompressRPCReply(t *testing.T) {
+	large := bytes.Repeat([]byte(`{"result":"00000000","error":null}`), 100)
+	small := []byte(`{"result":1,"error":null,"id":1}`)
+
+	tests := []struct {
+		name     string
+		header   string
+		reply    []byte
+		encoding string
+		newRdr   func(io.Reader) (io.Reader, error)
+	}{{
+		name:     "large reply with gzip",
+		header:   "gzip",
+		reply:    large,
+		encoding: encodingGzip,
+		newRdr: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+	}, {
+		name:     "large reply with deflate",
+		header:   "deflate",
+		reply:    large,
+		encoding: encodingDeflate,
+		newRdr: func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+	}, {
+		name:     "large reply without compression support",
+		header:   "",
+		reply:    large,
+		encoding: "",
+	}, {
+		name:     "small reply with gzip",
+		header:   "gzip",
+		reply:    small,
+		encoding: "",
+	}}
+
+	for _, test := range tests {
+		got, encoding := compressRPCReply(test.header, test.reply)
+		if encoding != test.encoding {
+			t.Errorf("%s: unexpected encoding - got %q, want %q",
+				test.name, encoding, test.encoding)
+			continue
+		}
+
+		if encoding == "" {
+			if !bytes.Equal(got, test.reply) {
+				t.Errorf("%s: reply was modified", test.name)
+			}
+			continue
+		}
+
+		if len(got) >= len(test.reply) {
+			t.Errorf("%s: compressed reply is not smaller - got "+
+				"%d bytes, original %d bytes", test.name,
+				len(got), len(test.reply))
+		}
+		r, err := test.newRdr(bytes.NewReader(got))
+		if err != nil {
+			t.Errorf("%s: unable to create reader: %v", test.name,
+				err)
+			continue
+		}
+		decoded, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: unable to decompress reply: %v",
+				test.name, err)
+			continue
+		}
+		if !bytes.Equal(decoded, test.reply) {
+			t.Errorf("%s: decompressed reply does not match",
+				test.name)
+		}
+	}
+}
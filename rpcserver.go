@@ -21,12 +21,14 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/btcsuite/btcd/addrmgr"
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/blockchain/indexers"
 	"github.com/btcsuite/btcd/btcec"
@@ -73,6 +75,35 @@ const (
 	// in the memory pool.
 	gbtRegenerateSeconds = 60
 
+	// gbtTipDebounce is the amount of time changes to the best chain tip
+	// are coalesced before long poll clients are notified that their block
+	// templates are stale.  This prevents bursts of blocks from causing a
+	// full template rebuild for every block in the burst.
+	gbtTipDebounce = time.Millisecond * 100
+
+	// gbtMempoolDebounce is the minimum amount of time between block
+	// templates generated for the same best chain tip due to transactions
+	// that materially alter the template.  It matches gbtTipDebounce so
+	// changes to the memory pool can't cause templates to be rebuilt more
+	// often than changes to the best chain tip.
+	gbtMempoolDebounce = gbtTipDebounce
+
+	// gbtMinFeeIncrease is the minimum increase, in satoshi, of the fees
+	// collected by a block template that is considered to materially alter
+	// it.
+	gbtMinFeeIncrease = 10000
+
+	// gbtFeeIncreasePercent is the increase of the fees collected by a
+	// block template, as a percentage of its current fees, that is
+	// considered to materially alter it when that exceeds
+	// gbtMinFeeIncrease.
+	gbtFeeIncreasePercent = 1
+
+	// gbtFullTemplateMargin is the weight that may remain unused by a
+	// block template which is still considered full.  New transactions
+	// then have to displace transactions in the template to be included.
+	gbtFullTemplateMargin = 4000
+
 	// maxProtocolVersion is the max protocol version the server supports.
 	maxProtocolVersion = 70002
 )
@@ -130,54 +161,61 @@ type commandHandler func(*rpcServer, interface{}, <-chan struct{}) (interface{},
 // a dependency loop.
 var rpcHandlers map[string]commandHandler
 var rpcHandlersBeforeInit = map[string]commandHandler{
-	"addnode":                handleAddNode,
-	"createrawtransaction":   handleCreateRawTransaction,
-	"debuglevel":             handleDebugLevel,
-	"decoderawtransaction":   handleDecodeRawTransaction,
-	"decodescript":           handleDecodeScript,
-	"estimatefee":            handleEstimateFee,
-	"generate":               handleGenerate,
-	"getaddednodeinfo":       handleGetAddedNodeInfo,
-	"getbestblock":           handleGetBestBlock,
-	"getbestblockhash":       handleGetBestBlockHash,
-	"getblock":               handleGetBlock,
-	"getblockchaininfo":      handleGetBlockChainInfo,
-	"getblockcount":          handleGetBlockCount,
-	"getblockhash":           handleGetBlockHash,
-	"getblockheader":         handleGetBlockHeader,
-	"getblocktemplate":       handleGetBlockTemplate,
-	"getcfilter":             handleGetCFilter,
-	"getcfilterheader":       handleGetCFilterHeader,
-	"getconnectioncount":     handleGetConnectionCount,
-	"getcurrentnet":          handleGetCurrentNet,
-	"getdifficulty":          handleGetDifficulty,
-	"getgenerate":            handleGetGenerate,
-	"gethashespersec":        handleGetHashesPerSec,
-	"getheaders":             handleGetHeaders,
-	"getinfo":                handleGetInfo,
-	"getmempoolinfo":         handleGetMempoolInfo,
-	"getmininginfo":          handleGetMiningInfo,
-	"getnettotals":           handleGetNetTotals,
-	"getnetworkhashps":       handleGetNetworkHashPS,
-	"getnodeaddresses":       handleGetNodeAddresses,
-	"getpeerinfo":            handleGetPeerInfo,
-	"getrawmempool":          handleGetRawMempool,
-	"getrawtransaction":      handleGetRawTransaction,
-	"gettxout":               handleGetTxOut,
-	"help":                   handleHelp,
-	"node":                   handleNode,
-	"ping":                   handlePing,
-	"searchrawtransactions":  handleSearchRawTransactions,
-	"sendrawtransaction":     handleSendRawTransaction,
-	"setgenerate":            handleSetGenerate,
-	"signmessagewithprivkey": handleSignMessageWithPrivKey,
-	"stop":                   handleStop,
-	"submitblock":            handleSubmitBlock,
-	"uptime":                 handleUptime,
-	"validateaddress":        handleValidateAddress,
-	"verifychain":            handleVerifyChain,
-	"verifymessage":          handleVerifyMessage,
-	"version":                handleVersion,
+	"addnode":                  handleAddNode,
+	"createrawtransaction":     handleCreateRawTransaction,
+	"debuglevel":               handleDebugLevel,
+	"decoderawtransaction":     handleDecodeRawTransaction,
+	"decodescript":             handleDecodeScript,
+	"estimatefee":              handleEstimateFee,
+	"generate":                 handleGenerate,
+	"getaddednodeinfo":         handleGetAddedNodeInfo,
+	"getaddrman":               handleGetAddrMan,
+	"getbestblock":             handleGetBestBlock,
+	"getbestblockhash":         handleGetBestBlockHash,
+	"getblock":                 handleGetBlock,
+	"getblockchaininfo":        handleGetBlockChainInfo,
+	"getblockcount":            handleGetBlockCount,
+	"getblockhash":             handleGetBlockHash,
+	"getblockheader":           handleGetBlockHeader,
+	"getblocktemplate":         handleGetBlockTemplate,
+	"getcfilter":               handleGetCFilter,
+	"getcfilterheader":         handleGetCFilterHeader,
+	"getconnectioncount":       handleGetConnectionCount,
+	"getcurrentnet":            handleGetCurrentNet,
+	"getdifficulty":            handleGetDifficulty,
+	"getgenerate":              handleGetGenerate,
+	"gethashespersec":          handleGetHashesPerSec,
+	"getheaders":               handleGetHeaders,
+	"getinfo":                  handleGetInfo,
+	"getmempoolinfo":           handleGetMempoolInfo,
+	"getmininginfo":            handleGetMiningInfo,
+	"getnettotals":             handleGetNetTotals,
+	"getnetworkhashps":         handleGetNetworkHashPS,
+	"getnetworkinfo":           handleGetNetworkInfo,
+	"getnodeaddresses":         handleGetNodeAddresses,
+	"getorphantxs":             handleGetOrphanTxs,
+	"getpeerinfo":              handleGetPeerInfo,
+	"getrawmempool":            handleGetRawMempool,
+	"getrawtransaction":        handleGetRawTransaction,
+	"gettxout":                 handleGetTxOut,
+	"gettxoutproof":            handleGetTxOutProof,
+	"gettxspendingprevout":     handleGetTxSpendingPrevOut,
+	"help":                     handleHelp,
+	"node":                     handleNode,
+	"ping":                     handlePing,
+	"resendwallettransactions": handleResendWalletTransactions,
+	"searchrawtransactions":    handleSearchRawTransactions,
+	"sendrawtransaction":       handleSendRawTransaction,
+	"setgenerate":              handleSetGenerate,
+	"signmessagewithprivkey":   handleSignMessageWithPrivKey,
+	"stop":                     handleStop,
+	"submitblock":              handleSubmitBlock,
+	"uptime":                   handleUptime,
+	"validateaddress":          handleValidateAddress,
+	"verifychain":              handleVerifyChain,
+	"verifymessage":            handleVerifyMessage,
+	"verifytxoutproof":         handleVerifyTxOutProof,
+	"version":                  handleVersion,
 }
 
 // list of commands that we recognize, but for which btcd has no support because
@@ -233,7 +271,6 @@ var rpcUnimplemented = map[string]struct{}{
 	"estimatepriority": {},
 	"getchaintips":     {},
 	"getmempoolentry":  {},
-	"getnetworkinfo":   {},
 	"getwork":          {},
 	"invalidateblock":  {},
 	"preciousblock":    {},
@@ -274,15 +311,19 @@ var rpcLimited = map[string]struct{}{
 	"getinfo":               {},
 	"getnettotals":          {},
 	"getnetworkhashps":      {},
+	"getnetworkinfo":        {},
 	"getrawmempool":         {},
 	"getrawtransaction":     {},
 	"gettxout":              {},
+	"gettxoutproof":         {},
+	"gettxspendingprevout":  {},
 	"searchrawtransactions": {},
 	"sendrawtransaction":    {},
 	"submitblock":           {},
 	"uptime":                {},
 	"validateaddress":       {},
 	"verifymessage":         {},
+	"verifytxoutproof":      {},
 	"version":               {},
 }
 
@@ -329,6 +370,35 @@ func rpcNoTxInfoError(txHash *chainhash.Hash) *btcjson.RPCError {
 			txHash))
 }
 
+// checkIndexReady returns an RPC error with the passed code when the provided
+// index has not been synced to the current best chain, which is the case while
+// it is being built, so callers don't serve incomplete results from it.
+func checkIndexReady(s *rpcServer, indexer indexers.Indexer,
+	code btcjson.RPCErrorCode) error {
+
+	// Take the best chain snapshot before looking up the tip of the index
+	// since a block that connects in between would otherwise make a synced
+	// index appear to be behind.
+	best := s.cfg.Chain.BestSnapshot()
+	_, height, err := indexers.IndexerTip(s.cfg.DB, indexer)
+	if err != nil {
+		return &btcjson.RPCError{
+			Code: code,
+			Message: fmt.Sprintf("The %s is not available: %v",
+				indexer.Name(), err),
+		}
+	}
+	if height < best.Height {
+		return &btcjson.RPCError{
+			Code: code,
+			Message: fmt.Sprintf("The %s is still syncing (synced "+
+				"to height %d of %d)", indexer.Name(), height,
+				best.Height),
+		}
+	}
+	return nil
+}
+
 // gbtWorkState houses state that is used in between multiple RPC invocations to
 // getblocktemplate.
 type gbtWorkState struct {
@@ -338,15 +408,49 @@ type gbtWorkState struct {
 	prevHash      *chainhash.Hash
 	minTimestamp  time.Time
 	template      *mining.BlockTemplate
-	notifyMap     map[chainhash.Hash]map[int64]chan struct{}
+	rules         []string
+	notifyMap     map[chainhash.Hash]map[uint64]chan struct{}
 	timeSource    blockchain.MedianTimeSource
+
+	// mempoolUpdates counts the changes to the memory pool and
+	// templateUpdates is its value when the current block template was
+	// generated.  Together with the previous block hash, the latter forms
+	// the long poll ID of the template.
+	mempoolUpdates  uint64
+	templateUpdates uint64
+
+	// minFeeRate is the lowest fee rate, in satoshi per 1000 bytes, of the
+	// transactions in the current block template, templateFees is the
+	// total fees they pay and templateFull is whether there is room left
+	// for more transactions.  Together they determine by how much a new
+	// transaction would increase the fees collected by the template, which
+	// is accumulated in feeIncrease.  Once that exceeds the threshold
+	// returned by materialFeeIncrease, the template is considered to be
+	// materially altered, which is tracked by materialUpdate.
+	minFeeRate     int64
+	templateFees   int64
+	templateFull   bool
+	feeIncrease    int64
+	materialUpdate bool
+
+	// mempoolTimer delays notifying long poll clients about material
+	// changes to the memory pool until gbtMempoolDebounce has passed since
+	// the current block template was generated.
+	mempoolTimer *time.Timer
+
+	// tipMtx protects the fields used to debounce changes to the best
+	// chain tip.  It is separate from the main mutex so block connected
+	// notifications never block on template generation.
+	tipMtx     sync.Mutex
+	pendingTip *chainhash.Hash
+	tipTimer   *time.Timer
 }
 
 // newGbtWorkState returns a new instance of a gbtWorkState with all internal
 // fields initialized and ready to use.
 func newGbtWorkState(timeSource blockchain.MedianTimeSource) *gbtWorkState {
 	return &gbtWorkState{
-		notifyMap:  make(map[chainhash.Hash]map[int64]chan struct{}),
+		notifyMap:  make(map[chainhash.Hash]map[uint64]chan struct{}),
 		timeSource: timeSource,
 	}
 }
@@ -693,8 +797,9 @@ func createVinList(mtx *wire.MsgTx) []btcjson.Vin {
 }
 
 // createVoutList returns a slice of JSON objects for the outputs of the passed
-// transaction.
-func createVoutList(mtx *wire.MsgTx, chainParams *chaincfg.Params, filterAddrMap map[string]struct{}) []btcjson.Vout {
+// transaction.  The optional script cache is used to classify the output
+// scripts.
+func createVoutList(mtx *wire.MsgTx, chainParams *chaincfg.Params, scriptCache *txscript.ScriptClassCache, filterAddrMap map[string]struct{}) []btcjson.Vout {
 	voutList := make([]btcjson.Vout, 0, len(mtx.TxOut))
 	for i, v := range mtx.TxOut {
 		// The disassembled string will contain [error] inline if the
@@ -704,7 +809,7 @@ func createVoutList(mtx *wire.MsgTx, chainParams *chaincfg.Params, filterAddrMap
 		// Ignore the error here since an error means the script
 		// couldn't parse and there is no additional information about
 		// it anyways.
-		scriptClass, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(
+		scriptClass, addrs, reqSigs, _ := scriptCache.ExtractPkScriptAddrs(
 			v.PkScript, chainParams)
 
 		// Encode the addresses while checking if the address passes the
@@ -746,9 +851,10 @@ func createVoutList(mtx *wire.MsgTx, chainParams *chaincfg.Params, filterAddrMap
 
 // createTxRawResult converts the passed transaction and associated parameters
 // to a raw transaction JSON object.
-func createTxRawResult(chainParams *chaincfg.Params, mtx *wire.MsgTx,
-	txHash string, blkHeader *wire.BlockHeader, blkHash string,
-	blkHeight int32, chainHeight int32) (*btcjson.TxRawResult, error) {
+func createTxRawResult(chainParams *chaincfg.Params,
+	scriptCache *txscript.ScriptClassCache, mtx *wire.MsgTx, txHash string,
+	blkHeader *wire.BlockHeader, blkHash string, blkHeight int32,
+	chainHeight int32) (*btcjson.TxRawResult, error) {
 
 	mtxHex, err := messageToHex(mtx)
 	if err != nil {
@@ -763,7 +869,7 @@ func createTxRawResult(chainParams *chaincfg.Params, mtx *wire.MsgTx,
 		Vsize:    int32(mempool.GetTxVirtualSize(btcutil.NewTx(mtx))),
 		Weight:   int32(blockchain.GetTransactionWeight(btcutil.NewTx(mtx))),
 		Vin:      createVinList(mtx),
-		Vout:     createVoutList(mtx, chainParams, nil),
+		Vout:     createVoutList(mtx, chainParams, scriptCache, nil),
 		Version:  uint32(mtx.Version),
 		LockTime: mtx.LockTime,
 	}
@@ -807,7 +913,7 @@ func handleDecodeRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan
 		Version:  mtx.Version,
 		Locktime: mtx.LockTime,
 		Vin:      createVinList(&mtx),
-		Vout:     createVoutList(&mtx, s.cfg.ChainParams, nil),
+		Vout:     createVoutList(&mtx, s.cfg.ChainParams, s.cfg.ScriptCache, nil),
 	}
 	return txReply, nil
 }
@@ -1026,6 +1132,60 @@ func handleGetAddedNodeInfo(s *rpcServer, cmd interface{}, closeChan <-chan stru
 	return results, nil
 }
 
+// unixOrZero returns the passed time as a unix timestamp or zero when it is the
+// zero time.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// handleGetAddrMan implements the getaddrman command.
+func handleGetAddrMan(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetAddrManCmd)
+
+	count := int32(10)
+	if c.Count != nil {
+		count = *c.Count
+		if count < 0 {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Address count out of range",
+			}
+		}
+	}
+
+	stats := s.cfg.ConnMgr.AddrManagerStats()
+	infos := s.cfg.ConnMgr.KnownAddresses(int(count))
+	addresses := make([]btcjson.GetAddrManAddressResult, 0, len(infos))
+	for _, info := range infos {
+		addresses = append(addresses, btcjson.GetAddrManAddressResult{
+			Address:     info.Addr.IP.String(),
+			Port:        info.Addr.Port,
+			Services:    uint64(info.Addr.Services),
+			Source:      info.Source.IP.String(),
+			Time:        unixOrZero(info.Addr.Timestamp),
+			Tried:       info.Tried,
+			Bad:         info.Bad,
+			Attempts:    int32(info.Attempts),
+			LastAttempt: unixOrZero(info.LastAttempt),
+			LastSuccess: unixOrZero(info.LastSuccess),
+		})
+	}
+
+	return &btcjson.GetAddrManResult{
+		New:               int32(stats.NumNew),
+		Tried:             int32(stats.NumTried),
+		Total:             int32(stats.NumNew + stats.NumTried),
+		NewBucketsUsed:    int32(stats.NewBucketsUsed),
+		NewBucketsTotal:   int32(stats.NewBucketsTotal),
+		TriedBucketsUsed:  int32(stats.TriedBucketsUsed),
+		TriedBucketsTotal: int32(stats.TriedBucketsTotal),
+		Addresses:         addresses,
+	}, nil
+}
+
 // handleGetBestBlock implements the getbestblock command.
 func handleGetBestBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// All other "get block" commands give either the height, the
@@ -1052,11 +1212,10 @@ func getDifficultyRatio(bits uint32, params *chaincfg.Params) float64 {
 	// converted back to a number.  Note this is not the same as the proof of
 	// work limit directly because the block difficulty is encoded in a block
 	// with the compact form which loses precision.
-	max := blockchain.CompactToBig(params.PowLimitBits)
-	target := blockchain.CompactToBig(bits)
+	difficulty := blockchain.DifficultyFromBits(bits, params.PowLimitBits)
 
-	difficulty := new(big.Rat).SetFrac(max, target)
-	outString := difficulty.FloatString(8)
+	// Round the difficulty to 8 decimal places as it is reported with.
+	outString := strconv.FormatFloat(difficulty, 'f', 8, 64)
 	diff, err := strconv.ParseFloat(outString, 64)
 	if err != nil {
 		rpcsLog.Errorf("Cannot get difficulty: %v", err)
@@ -1086,18 +1245,48 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 			Message: "Block not found",
 		}
 	}
+
+	// Ensure the transactions of the block hash to the merkle root of its
+	// header when verification is enabled so corrupted block data is not
+	// served.  The block is only deserialized up front in that case since
+	// the serialized form is returned as is when the verbosity is 0.
+	var blk *btcutil.Block
+	if s.cfg.VerifyBlocks {
+		blk, err = btcutil.NewBlockFromBytes(blkBytes)
+		if err != nil {
+			context := "Failed to deserialize block"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		if err := verifyMerkleRoot(blk); err != nil {
+			rpcsLog.Errorf("Block %v failed verification: %v", hash,
+				err)
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCDatabase,
+				Message: err.Error(),
+			}
+		}
+	}
+
+	// The verbosity defaults to 1 when it is not specified.
+	verbosity := 1
+	if c.Verbosity != nil {
+		verbosity = *c.Verbosity
+	}
+
 	// If verbosity is 0, return the serialized block as a hex encoded string.
-	if c.Verbosity != nil && *c.Verbosity == 0 {
+	if verbosity == 0 {
 		return hex.EncodeToString(blkBytes), nil
 	}
 
 	// Otherwise, generate the JSON object and return it.
 
-	// Deserialize the block.
-	blk, err := btcutil.NewBlockFromBytes(blkBytes)
-	if err != nil {
-		context := "Failed to deserialize block"
-		return nil, internalRPCError(err.Error(), context)
+	// Deserialize the block unless it already was for verification.
+	if blk == nil {
+		blk, err = btcutil.NewBlockFromBytes(blkBytes)
+		if err != nil {
+			context := "Failed to deserialize block"
+			return nil, internalRPCError(err.Error(), context)
+		}
 	}
 
 	// Get the block height from chain.
@@ -1137,10 +1326,13 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		Weight:        int32(blockchain.GetBlockWeight(blk)),
 		Bits:          strconv.FormatInt(int64(blockHeader.Bits), 16),
 		Difficulty:    getDifficultyRatio(blockHeader.Bits, params),
+		NTx:           int32(len(blk.Transactions())),
 		NextHash:      nextHashString,
 	}
 
-	if *c.Verbosity == 1 {
+	// The transactions are reported in block order in both the txid list
+	// and the decoded transactions, so the coinbase is always first.
+	if verbosity == 1 {
 		transactions := blk.Transactions()
 		txNames := make([]string, len(transactions))
 		for i, tx := range transactions {
@@ -1152,20 +1344,113 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		txns := blk.Transactions()
 		rawTxns := make([]btcjson.TxRawResult, len(txns))
 		for i, tx := range txns {
-			rawTxn, err := createTxRawResult(params, tx.MsgTx(),
-				tx.Hash().String(), blockHeader, hash.String(),
-				blockHeight, best.Height)
+			rawTxn, err := createTxRawResult(params,
+				s.cfg.ScriptCache, tx.MsgTx(), tx.Hash().String(),
+				blockHeader, hash.String(), blockHeight,
+				best.Height)
 			if err != nil {
 				return nil, err
 			}
 			rawTxns[i] = *rawTxn
 		}
+
+		// Include the outputs spent by each input, as recorded in the
+		// spend journal, when the highest verbosity is requested.
+		if verbosity == 3 {
+			stxos, err := s.cfg.Chain.FetchSpendJournal(blk)
+			if err != nil {
+				context := "Failed to fetch spent outputs"
+				return nil, internalRPCError(err.Error(), context)
+			}
+			err = addVinPrevOuts(rawTxns, stxos, params)
+			if err != nil {
+				context := "Failed to add spent outputs"
+				return nil, internalRPCError(err.Error(), context)
+			}
+		}
 		blockReply.RawTx = rawTxns
 	}
 
 	return blockReply, nil
 }
 
+// verifyMerkleRoot returns an error when the transactions of the passed block do
+// not hash to the merkle root in its header, which indicates the block data was
+// corrupted after it was stored.
+func verifyMerkleRoot(blk *btcutil.Block) error {
+	header := &blk.MsgBlock().Header
+	if len(blk.Transactions()) == 0 {
+		return errors.New("block does not contain any transactions")
+	}
+	calculatedMerkleRoot := blockchain.CalcMerkleRoot(blk.Transactions())
+	if !header.MerkleRoot.IsEqual(&calculatedMerkleRoot) {
+		return fmt.Errorf("block merkle root is invalid - block "+
+			"header indicates %v, but calculated value is %v",
+			header.MerkleRoot, calculatedMerkleRoot)
+	}
+	return nil
+}
+
+// addVinPrevOuts populates the details of the spent outputs for the inputs of
+// the passed raw transactions of a block.  The spent outputs must be in the
+// same order as the block's spend journal, which is the order they are spent
+// by the transactions of the block, excluding the coinbase.
+func addVinPrevOuts(rawTxns []btcjson.TxRawResult,
+	stxos []blockchain.SpentTxOut, chainParams *chaincfg.Params) error {
+
+	var stxoIdx int
+	for i := range rawTxns {
+		// The coinbase does not spend any outputs.
+		if i == 0 {
+			continue
+		}
+
+		for j := range rawTxns[i].Vin {
+			if stxoIdx >= len(stxos) {
+				return fmt.Errorf("spend journal has %d entries "+
+					"which is less than the number of inputs",
+					len(stxos))
+			}
+			stxo := &stxos[stxoIdx]
+			stxoIdx++
+
+			// The disassembled string will contain [error] inline if
+			// the script doesn't fully parse, so ignore the error
+			// here.
+			disbuf, _ := txscript.DisasmString(stxo.PkScript)
+
+			// Ignore the error here since an error means the script
+			// couldn't parse and there is no additional information
+			// about it anyways.
+			scriptClass, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(
+				stxo.PkScript, chainParams)
+			encodedAddrs := make([]string, len(addrs))
+			for k, addr := range addrs {
+				encodedAddrs[k] = addr.EncodeAddress()
+			}
+
+			rawTxns[i].Vin[j].PrevOut = &btcjson.PrevOutResult{
+				Generated: stxo.IsCoinBase,
+				Height:    int64(stxo.Height),
+				Value:     btcutil.Amount(stxo.Amount).ToBTC(),
+				ScriptPubKey: btcjson.ScriptPubKeyResult{
+					Asm:       disbuf,
+					Hex:       hex.EncodeToString(stxo.PkScript),
+					ReqSigs:   int32(reqSigs),
+					Type:      scriptClass.String(),
+					Addresses: encodedAddrs,
+				},
+			}
+		}
+	}
+	if stxoIdx != len(stxos) {
+		return fmt.Errorf("spend journal has %d entries which is more "+
+			"than the %d inputs", len(stxos), stxoIdx)
+	}
+
+	return nil
+}
+
 // softForkStatus converts a ThresholdState state into a human readable string
 // corresponding to the particular state.
 func softForkStatus(state blockchain.ThresholdState) (string, error) {
@@ -1206,6 +1491,22 @@ func handleGetBlockChainInfo(s *rpcServer, cmd interface{}, closeChan <-chan str
 		},
 	}
 
+	// Estimate how much of the chain has been verified based on the rate
+	// transactions have recently been added to it.
+	chainInfo.VerificationProgress = chain.VerificationProgress(time.Now())
+
+	// Include the statistics about reorganizations of the main chain so
+	// operators can monitor chain stability.
+	reorgStats := chain.ReorgStats()
+	chainInfo.ReorgStats = &btcjson.ReorgStatsResult{
+		Count:     reorgStats.Count,
+		MaxDepth:  reorgStats.MaxDepth,
+		LastDepth: reorgStats.LastDepth,
+	}
+	if !reorgStats.LastTime.IsZero() {
+		chainInfo.ReorgStats.LastTime = reorgStats.LastTime.Unix()
+	}
+
 	// Next, populate the response with information describing the current
 	// status of soft-forks deployed via the super-majority block
 	// signalling mechanism.
@@ -1289,12 +1590,30 @@ func handleGetBlockChainInfo(s *rpcServer, cmd interface{}, closeChan <-chan str
 
 		// Finally, populate the soft-fork description with all the
 		// information gathered above.
-		chainInfo.SoftForks.Bip9SoftForks[forkName] = &btcjson.Bip9SoftForkDescription{
+		desc := &btcjson.Bip9SoftForkDescription{
 			Status:     strings.ToLower(statusString),
 			Bit:        deploymentDetails.BitNumber,
 			StartTime2: int64(deploymentDetails.StartTime),
 			Timeout:    int64(deploymentDetails.ExpireTime),
 		}
+
+		// Include the signaling statistics of the current confirmation
+		// window while the deployment is being voted on.
+		if deploymentStatus == blockchain.ThresholdStarted {
+			stats, err := chain.DeploymentStats(uint32(deployment))
+			if err != nil {
+				context := "Failed to obtain deployment statistics"
+				return nil, internalRPCError(err.Error(), context)
+			}
+			desc.Statistics = &btcjson.Bip9Statistics{
+				Period:    stats.Period,
+				Threshold: stats.Threshold,
+				Elapsed:   stats.Elapsed,
+				Count:     stats.Count,
+				Possible:  stats.Possible,
+			}
+		}
+		chainInfo.SoftForks.Bip9SoftForks[forkName] = desc
 	}
 
 	return chainInfo, nil
@@ -1370,6 +1689,12 @@ func handleGetBlockHeader(s *rpcServer, cmd interface{}, closeChan <-chan struct
 		nextHashString = nextHash.String()
 	}
 
+	// Get previous block hash unless this is the genesis block.
+	var prevHashString string
+	if blockHeader.PrevBlock != zeroHash {
+		prevHashString = blockHeader.PrevBlock.String()
+	}
+
 	params := s.cfg.ChainParams
 	blockHeaderReply := btcjson.GetBlockHeaderVerboseResult{
 		Hash:          c.Hash,
@@ -1379,7 +1704,7 @@ func handleGetBlockHeader(s *rpcServer, cmd interface{}, closeChan <-chan struct
 		VersionHex:    fmt.Sprintf("%08x", blockHeader.Version),
 		MerkleRoot:    blockHeader.MerkleRoot.String(),
 		NextHash:      nextHashString,
-		PreviousHash:  blockHeader.PrevBlock.String(),
+		PreviousHash:  prevHashString,
 		Nonce:         uint64(blockHeader.Nonce),
 		Time:          blockHeader.Timestamp.Unix(),
 		Bits:          strconv.FormatInt(int64(blockHeader.Bits), 16),
@@ -1390,16 +1715,16 @@ func handleGetBlockHeader(s *rpcServer, cmd interface{}, closeChan <-chan struct
 
 // encodeTemplateID encodes the passed details into an ID that can be used to
 // uniquely identify a block template.
-func encodeTemplateID(prevHash *chainhash.Hash, lastGenerated time.Time) string {
-	return fmt.Sprintf("%s-%d", prevHash.String(), lastGenerated.Unix())
+func encodeTemplateID(prevHash *chainhash.Hash, mempoolUpdates uint64) string {
+	return fmt.Sprintf("%s-%d", prevHash.String(), mempoolUpdates)
 }
 
 // decodeTemplateID decodes an ID that is used to uniquely identify a block
 // template.  This is mainly used as a mechanism to track when to update clients
 // that are using long polling for block templates.  The ID consists of the
-// previous block hash for the associated template and the time the associated
-// template was generated.
-func decodeTemplateID(templateID string) (*chainhash.Hash, int64, error) {
+// previous block hash for the associated template and the number of memory
+// pool changes seen when the associated template was generated.
+func decodeTemplateID(templateID string) (*chainhash.Hash, uint64, error) {
 	fields := strings.Split(templateID, "-")
 	if len(fields) != 2 {
 		return nil, 0, errors.New("invalid longpollid format")
@@ -1409,19 +1734,19 @@ func decodeTemplateID(templateID string) (*chainhash.Hash, int64, error) {
 	if err != nil {
 		return nil, 0, errors.New("invalid longpollid format")
 	}
-	lastGenerated, err := strconv.ParseInt(fields[1], 10, 64)
+	mempoolUpdates, err := strconv.ParseUint(fields[1], 10, 64)
 	if err != nil {
 		return nil, 0, errors.New("invalid longpollid format")
 	}
 
-	return prevHash, lastGenerated, nil
+	return prevHash, mempoolUpdates, nil
 }
 
 // notifyLongPollers notifies any channels that have been registered to be
 // notified when block templates are stale.
 //
 // This function MUST be called with the state locked.
-func (state *gbtWorkState) notifyLongPollers(latestHash *chainhash.Hash, lastGenerated time.Time) {
+func (state *gbtWorkState) notifyLongPollers(latestHash *chainhash.Hash, mempoolUpdates uint64) {
 	// Notify anything that is waiting for a block template update from a
 	// hash which is not the hash of the tip of the best chain since their
 	// work is now invalid.
@@ -1434,12 +1759,6 @@ func (state *gbtWorkState) notifyLongPollers(latestHash *chainhash.Hash, lastGen
 		}
 	}
 
-	// Return now if the provided last generated timestamp has not been
-	// initialized.
-	if lastGenerated.IsZero() {
-		return
-	}
-
 	// Return now if there is nothing registered for updates to the current
 	// best block hash.
 	channels, ok := state.notifyMap[*latestHash]
@@ -1448,13 +1767,11 @@ func (state *gbtWorkState) notifyLongPollers(latestHash *chainhash.Hash, lastGen
 	}
 
 	// Notify anything that is waiting for a block template update from a
-	// block template generated before the most recently generated block
-	// template.
-	lastGeneratedUnix := lastGenerated.Unix()
-	for lastGen, c := range channels {
-		if lastGen < lastGeneratedUnix {
+	// block template generated before the most recent memory pool changes.
+	for updates, c := range channels {
+		if updates < mempoolUpdates {
 			close(c)
-			delete(channels, lastGen)
+			delete(channels, updates)
 		}
 	}
 
@@ -1467,62 +1784,139 @@ func (state *gbtWorkState) notifyLongPollers(latestHash *chainhash.Hash, lastGen
 
 // NotifyBlockConnected uses the newly-connected block to notify any long poll
 // clients with a new block template when their existing block template is
-// stale due to the newly connected block.
+// stale due to the newly connected block.  Blocks connected within
+// gbtTipDebounce of each other are coalesced into a single notification for
+// the most recent one so a burst of blocks only results in a single template
+// rebuild.
 func (state *gbtWorkState) NotifyBlockConnected(blockHash *chainhash.Hash) {
-	go func() {
+	state.tipMtx.Lock()
+	defer state.tipMtx.Unlock()
+
+	state.pendingTip = blockHash
+	if state.tipTimer != nil {
+		return
+	}
+	state.tipTimer = time.AfterFunc(gbtTipDebounce, func() {
+		state.tipMtx.Lock()
+		latestHash := state.pendingTip
+		state.pendingTip = nil
+		state.tipTimer = nil
+		state.tipMtx.Unlock()
+
 		state.Lock()
 		defer state.Unlock()
 
-		state.notifyLongPollers(blockHash, state.lastTxUpdate)
-	}()
+		state.notifyLongPollers(latestHash, state.templateUpdates)
+	})
 }
 
-// NotifyMempoolTx uses the new last updated time for the transaction memory
-// pool to notify any long poll clients with a new block template when their
-// existing block template is stale due to enough time passing and the contents
-// of the memory pool changing.
-func (state *gbtWorkState) NotifyMempoolTx(lastUpdated time.Time) {
+// NotifyMempoolTx uses the fee and fee rate of a transaction newly added to
+// the memory pool to notify any long poll clients with a new block template
+// when their existing block template is stale.  This is the case once the
+// transactions added since the template was generated would increase the fees
+// it collects by more than the threshold returned by materialFeeIncrease, but
+// no sooner than gbtMempoolDebounce after the template was generated, and
+// otherwise once enough time has passed since the template was generated.
+func (state *gbtWorkState) NotifyMempoolTx(fee, feePerKB int64) {
 	go func() {
 		state.Lock()
 		defer state.Unlock()
 
+		state.mempoolUpdates++
+
 		// No need to notify anything if no block templates have been generated
 		// yet.
 		if state.prevHash == nil || state.lastGenerated.IsZero() {
 			return
 		}
 
-		if time.Now().After(state.lastGenerated.Add(time.Second *
-			gbtRegenerateSeconds)) {
+		state.feeIncrease += templateFeeIncrease(fee, feePerKB,
+			state.minFeeRate, state.templateFull)
+		threshold := materialFeeIncrease(state.templateFees)
+		if state.feeIncrease >= threshold {
+			state.materialUpdate = true
+		}
+		if !state.materialUpdate && !time.Now().After(
+			state.lastGenerated.Add(time.Second*gbtRegenerateSeconds)) {
 
-			state.notifyLongPollers(state.prevHash, lastUpdated)
+			return
 		}
+
+		// Delay the notification until gbtMempoolDebounce has passed
+		// since the template was generated.  The pending notification
+		// covers any further changes until then.
+		wait := time.Until(state.lastGenerated.Add(gbtMempoolDebounce))
+		if wait <= 0 {
+			state.notifyLongPollers(state.prevHash, state.mempoolUpdates)
+			return
+		}
+		if state.mempoolTimer != nil {
+			return
+		}
+		state.mempoolTimer = time.AfterFunc(wait, func() {
+			state.Lock()
+			defer state.Unlock()
+
+			state.mempoolTimer = nil
+			if state.prevHash != nil {
+				state.notifyLongPollers(state.prevHash,
+					state.mempoolUpdates)
+			}
+		})
 	}()
 }
 
+// templateFeeIncrease returns by how much a transaction paying the passed fee
+// and fee rate would increase the fees collected by a block template with the
+// passed lowest fee rate.  Transactions which fit in a template that is not
+// full add their entire fee, while those added to a full template displace
+// transactions paying the lowest fee rate, so only the part of their fee above
+// that rate counts.
+func templateFeeIncrease(fee, feePerKB, minFeeRate int64, full bool) int64 {
+	if !full {
+		return fee
+	}
+	if feePerKB <= minFeeRate {
+		return 0
+	}
+	return int64(float64(fee) * float64(feePerKB-minFeeRate) /
+		float64(feePerKB))
+}
+
+// materialFeeIncrease returns the increase of the fees collected by a block
+// template paying the passed total fees which is considered to materially
+// alter it.
+func materialFeeIncrease(templateFees int64) int64 {
+	threshold := templateFees * gbtFeeIncreasePercent / 100
+	if threshold < gbtMinFeeIncrease {
+		threshold = gbtMinFeeIncrease
+	}
+	return threshold
+}
+
 // templateUpdateChan returns a channel that will be closed once the block
-// template associated with the passed previous hash and last generated time
-// is stale.  The function will return existing channels for duplicate
+// template associated with the passed previous hash and memory pool change
+// count is stale.  The function will return existing channels for duplicate
 // parameters which allows multiple clients to wait for the same block template
 // without requiring a different channel for each client.
 //
 // This function MUST be called with the state locked.
-func (state *gbtWorkState) templateUpdateChan(prevHash *chainhash.Hash, lastGenerated int64) chan struct{} {
+func (state *gbtWorkState) templateUpdateChan(prevHash *chainhash.Hash, mempoolUpdates uint64) chan struct{} {
 	// Either get the current list of channels waiting for updates about
 	// changes to block template for the previous hash or create a new one.
 	channels, ok := state.notifyMap[*prevHash]
 	if !ok {
-		m := make(map[int64]chan struct{})
+		m := make(map[uint64]chan struct{})
 		state.notifyMap[*prevHash] = m
 		channels = m
 	}
 
-	// Get the current channel associated with the time the block template
-	// was last generated or create a new one.
-	c, ok := channels[lastGenerated]
+	// Get the current channel associated with the memory pool change count
+	// the block template was generated with or create a new one.
+	c, ok := channels[mempoolUpdates]
 	if !ok {
 		c = make(chan struct{})
-		channels[lastGenerated] = c
+		channels[mempoolUpdates] = c
 	}
 
 	return c
@@ -1530,8 +1924,9 @@ func (state *gbtWorkState) templateUpdateChan(prevHash *chainhash.Hash, lastGene
 
 // updateBlockTemplate creates or updates a block template for the work state.
 // A new block template will be generated when the current best block has
-// changed or the transactions in the memory pool have been updated and it has
-// been long enough since the last template was generated.  Otherwise, the
+// changed, a transaction that materially alters the template has been added to
+// the memory pool, or the transactions in the memory pool have been updated and
+// it has been long enough since the last template was generated.  Otherwise, the
 // timestamp for the existing block template is updated (and possibly the
 // difficulty on testnet per the consesus rules).  Finally, if the
 // useCoinbaseValue flag is false and the existing block template does not
@@ -1548,8 +1943,11 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 	}
 
 	// Generate a new block template when the current best block has
-	// changed or the transactions in the memory pool have been updated and
-	// it has been at least gbtRegenerateSecond since the last template was
+	// changed, transactions that materially increase the fees of the
+	// existing template have been added to the memory pool and it has been
+	// at least gbtMempoolDebounce since the last template was generated, or
+	// the transactions in the memory pool have been updated and it has
+	// been at least gbtRegenerateSecond since the last template was
 	// generated.
 	var msgBlock *wire.MsgBlock
 	var targetDifficulty string
@@ -1557,29 +1955,43 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 	template := state.template
 	if template == nil || state.prevHash == nil ||
 		!state.prevHash.IsEqual(latestHash) ||
-		(state.lastTxUpdate != lastTxUpdate &&
+		(state.materialUpdate && time.Now().After(
+			state.lastGenerated.Add(gbtMempoolDebounce))) ||
+		((state.lastTxUpdate != lastTxUpdate ||
+			state.templateUpdates != state.mempoolUpdates) &&
 			time.Now().After(state.lastGenerated.Add(time.Second*
 				gbtRegenerateSeconds))) {
 
+		// Count changes to the memory pool that were not notified, such
+		// as removed transactions, so a new template for the same block
+		// always has a new long poll ID.
+		if state.prevHash != nil && state.prevHash.IsEqual(latestHash) &&
+			state.templateUpdates == state.mempoolUpdates {
+
+			state.mempoolUpdates++
+		}
+
 		// Reset the previous best hash the block template was generated
 		// against so any errors below cause the next invocation to try
 		// again.
 		state.prevHash = nil
 
-		// Choose a payment address at random if the caller requests a
-		// full coinbase as opposed to only the pertinent details needed
-		// to create their own coinbase.
-		var payAddr btcutil.Address
+		// Choose a payment address from the mining addresses if the
+		// caller requests a full coinbase as opposed to only the
+		// pertinent details needed to create their own coinbase.
+		// Otherwise, create a new block template that has a coinbase
+		// which anyone can redeem.  This is only acceptable because the
+		// returned block template doesn't include the coinbase, so the
+		// caller will ultimately create their own coinbase which pays to
+		// the appropriate address(es).
+		var blkTemplate *mining.BlockTemplate
+		var err error
 		if !useCoinbaseValue {
-			payAddr = cfg.miningAddrs[rand.Intn(len(cfg.miningAddrs))]
+			blkTemplate, err = generator.NewBlockTemplateForPayouts(
+				s.cfg.MiningPayouts)
+		} else {
+			blkTemplate, err = generator.NewBlockTemplate(nil)
 		}
-
-		// Create a new block template that has a coinbase which anyone
-		// can redeem.  This is only acceptable because the returned
-		// block template doesn't include the coinbase, so the caller
-		// will ultimately create their own coinbase which pays to the
-		// appropriate address(es).
-		blkTemplate, err := generator.NewBlockTemplate(payAddr)
 		if err != nil {
 			return internalRPCError("Failed to create new block "+
 				"template: "+err.Error(), "")
@@ -1595,13 +2007,43 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 		best := s.cfg.Chain.BestSnapshot()
 		minTimestamp := mining.MinimumMedianTime(best)
 
+		// Determine the rule changes that are active for the block.
+		rules, err := gbtActiveRules(s, template.Height)
+		if err != nil {
+			context := "Failed to determine active rules"
+			return internalRPCError(err.Error(), context)
+		}
+
+		// Find the lowest fee rate and total fees paid by the
+		// transactions in the template and whether it is full so only
+		// transactions that would improve the fees of the template
+		// release long polls early.
+		minFeeRate := templateMinFeeRate(template)
+		var templateFees int64
+		for _, fee := range template.Fees[1:] {
+			templateFees += fee
+		}
+		weight := blockchain.GetBlockWeight(btcutil.NewBlock(msgBlock))
+		templateFull := weight+gbtFullTemplateMargin >=
+			int64(generator.Policy().BlockMaxWeight)
+
 		// Update work state to ensure another block template isn't
-		// generated until needed.
+		// generated until needed.  The previous hash is taken from the
+		// template itself since the best chain tip might have changed
+		// while it was being generated.
+		latestHash = &template.Block.Header.PrevBlock
 		state.template = template
 		state.lastGenerated = time.Now()
 		state.lastTxUpdate = lastTxUpdate
 		state.prevHash = latestHash
 		state.minTimestamp = minTimestamp
+		state.rules = rules
+		state.templateUpdates = state.mempoolUpdates
+		state.minFeeRate = minFeeRate
+		state.templateFees = templateFees
+		state.templateFull = templateFull
+		state.feeIncrease = 0
+		state.materialUpdate = false
 
 		rpcsLog.Debugf("Generated block template (timestamp %v, "+
 			"target %s, merkle root %s)",
@@ -1610,7 +2052,7 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 
 		// Notify any clients that are long polling about the new
 		// template.
-		state.notifyLongPollers(latestHash, lastTxUpdate)
+		state.notifyLongPollers(latestHash, state.templateUpdates)
 	} else {
 		// At this point, there is a saved block template and another
 		// request for a template was made, but either the available
@@ -1625,11 +2067,11 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 		// mining addresses to be specified via the config, an error is
 		// returned if none have been specified.
 		if !useCoinbaseValue && !template.ValidPayAddress {
-			// Choose a payment address at random.
-			payToAddr := cfg.miningAddrs[rand.Intn(len(cfg.miningAddrs))]
+			// Choose a payment address from the mining addresses.
+			payToAddr := s.cfg.MiningPayouts.Addr(template.Height)
 
 			// Update the block coinbase output of the template to
-			// pay to the randomly selected payment address.
+			// pay to the selected payment address.
 			pkScript, err := txscript.PayToAddrScript(payToAddr)
 			if err != nil {
 				context := "Failed to create pay-to-addr script"
@@ -1640,8 +2082,8 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 
 			// Update the merkle root.
 			block := btcutil.NewBlock(template.Block)
-			merkles := blockchain.BuildMerkleTreeStore(block.Transactions(), false)
-			template.Block.Header.MerkleRoot = *merkles[len(merkles)-1]
+			template.Block.Header.MerkleRoot =
+				blockchain.CalcMerkleRoot(block.Transactions())
 		}
 
 		// Set locals for convenience.
@@ -1663,6 +2105,111 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 	return nil
 }
 
+// templateMinFeeRate returns the lowest fee rate, in satoshi per 1000 bytes, paid
+// by the transactions of the passed block template.  The coinbase and
+// transactions that do not pay a fee, which are only included due to their
+// priority, are skipped.  Zero is returned when no transaction pays a fee.  The
+// fee rates are calculated the same way as those of the memory pool so they
+// can be compared against the fee rates of transactions added to it.
+func templateMinFeeRate(template *mining.BlockTemplate) int64 {
+	var minFeeRate int64
+	var haveFeeRate bool
+	for i, tx := range template.Block.Transactions[1:] {
+		fee := template.Fees[i+1]
+		if fee == 0 {
+			continue
+		}
+		feeRate := mempool.CalcFeePerKB(btcutil.NewTx(tx), fee)
+		if !haveFeeRate || feeRate < minFeeRate {
+			minFeeRate = feeRate
+			haveFeeRate = true
+		}
+	}
+	return minFeeRate
+}
+
+// gbtActiveRules returns the names of the rule changes that are active for a
+// block template at the passed height for the rules field of the
+// getblocktemplate result.  Only the csv and cltv rules are reported unless
+// segwit is active, in which case it is reported as !segwit since the template
+// may then include transactions with witness data and a witness commitment
+// that clients must understand in order to use it.
+func gbtActiveRules(s *rpcServer, height int32) ([]string, error) {
+	rules := make([]string, 0, 3)
+	csvActive, err := s.cfg.Chain.IsDeploymentActive(chaincfg.DeploymentCSV)
+	if err != nil {
+		return nil, err
+	}
+	if csvActive {
+		rules = append(rules, "csv")
+	}
+	if height >= s.cfg.ChainParams.BIP0065Height {
+		rules = append(rules, "cltv")
+	}
+	segwitActive, err := s.cfg.Chain.IsDeploymentActive(
+		chaincfg.DeploymentSegwit)
+	if err != nil {
+		return nil, err
+	}
+	if segwitActive {
+		rules = append(rules, "!segwit")
+	}
+	return rules, nil
+}
+
+// blockTemplateDepends returns the 1-based indices of the transactions each of
+// the passed block template transactions depends on, which is the index format
+// of the depends field of the getblocktemplate result since the coinbase is not
+// included in the transactions list.  Each list of indices is sorted in
+// ascending order and the entry for the coinbase is empty.
+//
+// An error is returned when a transaction depends on a transaction that does
+// not come before it since mining software relies on dependencies preceding
+// their dependents to assemble valid blocks.
+func blockTemplateDepends(txns []*wire.MsgTx) ([][]int64, error) {
+	txIndex := make(map[chainhash.Hash]int64, len(txns))
+	for i, tx := range txns {
+		txIndex[tx.TxHash()] = int64(i)
+	}
+
+	depends := make([][]int64, len(txns))
+	for i, tx := range txns {
+		// Skip the coinbase transaction.
+		if i == 0 {
+			depends[i] = []int64{}
+			continue
+		}
+
+		// Create an array of indices to transactions in the template
+		// which this one depends on.  A map is used before creating
+		// the final array to prevent duplicate entries when multiple
+		// inputs reference the same transaction.
+		dependsMap := make(map[int64]struct{})
+		for _, txIn := range tx.TxIn {
+			idx, ok := txIndex[txIn.PreviousOutPoint.Hash]
+			if !ok {
+				continue
+			}
+			if idx >= int64(i) {
+				return nil, fmt.Errorf("transaction %v depends on "+
+					"transaction %v which does not precede it",
+					tx.TxHash(), txIn.PreviousOutPoint.Hash)
+			}
+			dependsMap[idx] = struct{}{}
+		}
+		txDepends := make([]int64, 0, len(dependsMap))
+		for idx := range dependsMap {
+			txDepends = append(txDepends, idx)
+		}
+		sort.Slice(txDepends, func(i, j int) bool {
+			return txDepends[i] < txDepends[j]
+		})
+		depends[i] = txDepends
+	}
+
+	return depends, nil
+}
+
 // blockTemplateResult returns the current block template associated with the
 // state as a btcjson.GetBlockTemplateResult that is ready to be encoded to JSON
 // and returned to the caller.
@@ -1688,38 +2235,25 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		}
 	}
 
+	// Determine the transactions each transaction in the template depends
+	// on.
+	depends, err := blockTemplateDepends(msgBlock.Transactions)
+	if err != nil {
+		context := "Invalid block template transaction ordering"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
 	// Convert each transaction in the block template to a template result
 	// transaction.  The result does not include the coinbase, so notice
 	// the adjustments to the various lengths and indices.
 	numTx := len(msgBlock.Transactions)
 	transactions := make([]btcjson.GetBlockTemplateResultTx, 0, numTx-1)
-	txIndex := make(map[chainhash.Hash]int64, numTx)
 	for i, tx := range msgBlock.Transactions {
-		txID := tx.TxHash()
-		txIndex[txID] = int64(i)
-
 		// Skip the coinbase transaction.
 		if i == 0 {
 			continue
 		}
 
-		// Create an array of 1-based indices to transactions that come
-		// before this one in the transactions list which this one
-		// depends on.  This is necessary since the created block must
-		// ensure proper ordering of the dependencies.  A map is used
-		// before creating the final array to prevent duplicate entries
-		// when multiple inputs reference the same transaction.
-		dependsMap := make(map[int64]struct{})
-		for _, txIn := range tx.TxIn {
-			if idx, ok := txIndex[txIn.PreviousOutPoint.Hash]; ok {
-				dependsMap[idx] = struct{}{}
-			}
-		}
-		depends := make([]int64, 0, len(dependsMap))
-		for idx := range dependsMap {
-			depends = append(depends, idx)
-		}
-
 		// Serialize the transaction for later conversion to hex.
 		txBuf := bytes.NewBuffer(make([]byte, 0, tx.SerializeSize()))
 		if err := tx.Serialize(txBuf); err != nil {
@@ -1730,9 +2264,9 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		bTx := btcutil.NewTx(tx)
 		resultTx := btcjson.GetBlockTemplateResultTx{
 			Data:    hex.EncodeToString(txBuf.Bytes()),
-			TxID:    txID.String(),
+			TxID:    tx.TxHash().String(),
 			Hash:    tx.WitnessHash().String(),
-			Depends: depends,
+			Depends: depends[i],
 			Fee:     template.Fees[i],
 			SigOps:  template.SigOpCosts[i],
 			Weight:  blockchain.GetTransactionWeight(bTx),
@@ -1745,9 +2279,9 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 	//  Including MinTime -> time/decrement
 	//  Omitting CoinbaseTxn -> coinbase, generation
 	targetDifficulty := fmt.Sprintf("%064x", blockchain.CompactToBig(header.Bits))
-	templateID := encodeTemplateID(state.prevHash, state.lastGenerated)
+	templateID := encodeTemplateID(state.prevHash, state.templateUpdates)
 	reply := btcjson.GetBlockTemplateResult{
-		Bits:         strconv.FormatInt(int64(header.Bits), 16),
+		Bits:         fmt.Sprintf("%08x", header.Bits),
 		CurTime:      header.Timestamp.Unix(),
 		Height:       int64(template.Height),
 		PreviousHash: header.PrevBlock.String(),
@@ -1764,6 +2298,7 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		Mutable:      gbtMutableFields,
 		NonceRange:   gbtNonceRange,
 		Capabilities: gbtCapabilities,
+		Rules:        state.rules,
 	}
 	// If the generated block template includes transactions with witness
 	// data, then include the witness commitment in the GBT result.
@@ -1771,8 +2306,12 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		reply.DefaultWitnessCommitment = hex.EncodeToString(template.WitnessCommitment)
 	}
 
+	// Always include the coinbase flags so miners that build their own
+	// coinbase as well as those that append to the provided one are able
+	// to include them in the signature script.
+	reply.CoinbaseAux = gbtCoinbaseAux
+
 	if useCoinbaseValue {
-		reply.CoinbaseAux = gbtCoinbaseAux
 		reply.CoinbaseValue = &msgBlock.Transactions[0].TxOut[0].Value
 	} else {
 		// Ensure the template has a valid payment address associated
@@ -1833,7 +2372,7 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 
 	// Just return the current block template if the long poll ID provided by
 	// the caller is invalid.
-	prevHash, lastGenerated, err := decodeTemplateID(longPollID)
+	prevHash, mempoolUpdates, err := decodeTemplateID(longPollID)
 	if err != nil {
 		result, err := state.blockTemplateResult(useCoinbaseValue, nil)
 		if err != nil {
@@ -1850,7 +2389,7 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 	// template as this means the provided template is stale.
 	prevTemplateHash := &state.template.Block.Header.PrevBlock
 	if !prevHash.IsEqual(prevTemplateHash) ||
-		lastGenerated != state.lastGenerated.Unix() {
+		mempoolUpdates != state.templateUpdates {
 
 		// Include whether or not it is valid to submit work against the
 		// old block template depending on whether or not a solution has
@@ -1867,11 +2406,11 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 		return result, nil
 	}
 
-	// Register the previous hash and last generated time for notifications
+	// Register the previous hash and memory pool change count for notifications
 	// Get a channel that will be notified when the template associated with
 	// the provided ID is stale and a new block template should be returned to
 	// the caller.
-	longPollChan := state.templateUpdateChan(prevHash, lastGenerated)
+	longPollChan := state.templateUpdateChan(prevHash, mempoolUpdates)
 	state.Unlock()
 
 	select {
@@ -1931,6 +2470,10 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 		if hasCoinbaseTxn && !hasCoinbaseValue {
 			useCoinbaseValue = false
 		}
+
+		// The rules supported by the caller are ignored since the
+		// template never depends on them.  Notably, requesting the
+		// segwit rule does not add a witness commitment.
 	}
 
 	// When a coinbase transaction has been requested, respond with an error
@@ -2322,11 +2865,32 @@ func handleGetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 		Difficulty:      getDifficultyRatio(best.Bits, s.cfg.ChainParams),
 		TestNet:         cfg.TestNet3,
 		RelayFee:        cfg.minRelayTxFee.ToBTC(),
+		Errors:          clockSkewWarning(s.cfg.TimeSource),
 	}
 
 	return ret, nil
 }
 
+// clockSkewWarning returns a warning when the median time of peers differs
+// from the local clock by more than the configured maximum clock skew, or an
+// empty string otherwise.
+func clockSkewWarning(timeSource blockchain.MedianTimeSource) string {
+	if cfg.MaxClockSkew == 0 {
+		return ""
+	}
+
+	skew := timeSource.MedianOffset()
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew <= cfg.MaxClockSkew {
+		return ""
+	}
+	return fmt.Sprintf("The median time of peers differs from the local "+
+		"clock by %v.  Please check your date and time are correct!",
+		skew)
+}
+
 // handleGetMempoolInfo implements the getmempoolinfo command.
 func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	mempoolTxns := s.cfg.TxMemPool.TxDescs()
@@ -2337,8 +2901,9 @@ func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct
 	}
 
 	ret := &btcjson.GetMempoolInfoResult{
-		Size:  int64(len(mempoolTxns)),
-		Bytes: numBytes,
+		Size:             int64(len(mempoolTxns)),
+		Bytes:            numBytes,
+		UnbroadcastCount: int64(s.cfg.TxMemPool.UnbroadcastCount()),
 	}
 
 	return ret, nil
@@ -2391,6 +2956,55 @@ func handleGetNetTotals(s *rpcServer, cmd interface{}, closeChan <-chan struct{}
 	return reply, nil
 }
 
+// handleGetNetworkInfo implements the getnetworkinfo command.
+func handleGetNetworkInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	userAgent := &wire.MsgVersion{UserAgent: wire.DefaultUserAgent}
+	err := userAgent.AddUserAgent(userAgentName, userAgentVersion,
+		cfg.UserAgentComments...)
+	if err != nil {
+		context := "Failed to create user agent"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	// Onion addresses are only reachable through a proxy.
+	onionProxy := cfg.OnionProxy
+	if onionProxy == "" {
+		onionProxy = cfg.Proxy
+	}
+	networks := []btcjson.NetworksResult{{
+		Name:      "ipv4",
+		Reachable: true,
+		Proxy:     cfg.Proxy,
+	}, {
+		Name:      "ipv6",
+		Reachable: true,
+		Proxy:     cfg.Proxy,
+	}, {
+		Name:      "onion",
+		Limited:   cfg.NoOnion,
+		Reachable: !cfg.NoOnion && onionProxy != "",
+		Proxy:     onionProxy,
+	}}
+
+	relayFee := cfg.minRelayTxFee.ToBTC()
+	reply := &btcjson.GetNetworkInfoResult{
+		Version:         int32(1000000*appMajor + 10000*appMinor + 100*appPatch),
+		SubVersion:      userAgent.UserAgent,
+		ProtocolVersion: int32(maxProtocolVersion),
+		LocalServices:   fmt.Sprintf("%016x", uint64(advertisedServices())),
+		LocalRelay:      !cfg.BlocksOnly,
+		TimeOffset:      int64(s.cfg.TimeSource.Offset().Seconds()),
+		Connections:     s.cfg.ConnMgr.ConnectedCount(),
+		NetworkActive:   true,
+		Networks:        networks,
+		RelayFee:        relayFee,
+		IncrementalFee:  relayFee,
+		LocalAddresses:  []btcjson.LocalAddressesResult{},
+		Warnings:        clockSkewWarning(s.cfg.TimeSource),
+	}
+	return reply, nil
+}
+
 // handleGetNetworkHashPS implements the getnetworkhashps command.
 func handleGetNetworkHashPS(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Note: All valid error return paths should return an int64.
@@ -2558,6 +3172,44 @@ func handleGetPeerInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	return infos, nil
 }
 
+// handleGetOrphanTxs implements the getorphantxs command.
+func handleGetOrphanTxs(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetOrphanTxsCmd)
+	descs := s.cfg.TxMemPool.OrphanTxDescs()
+
+	// The response is simply an array of the transaction hashes if the
+	// verbose flag is not set.
+	if c.Verbose == nil || !*c.Verbose {
+		hashStrings := make([]string, len(descs))
+		for i := range hashStrings {
+			hashStrings[i] = descs[i].Tx.Hash().String()
+		}
+		return hashStrings, nil
+	}
+
+	results := make([]btcjson.GetOrphanTxsResult, 0, len(descs))
+	for _, desc := range descs {
+		tx := desc.Tx
+		missingParents := make([]string, 0, len(desc.MissingParents))
+		for _, hash := range desc.MissingParents {
+			missingParents = append(missingParents, hash.String())
+		}
+		results = append(results, btcjson.GetOrphanTxsResult{
+			TxID:           tx.Hash().String(),
+			Hash:           tx.WitnessHash().String(),
+			Size:           int32(tx.MsgTx().SerializeSize()),
+			Vsize:          int32(mempool.GetTxVirtualSize(tx)),
+			Weight:         int32(blockchain.GetTransactionWeight(tx)),
+			Time:           desc.Added.Unix(),
+			Expiration:     desc.Expiration.Unix(),
+			From:           uint64(desc.Tag),
+			MissingParents: missingParents,
+		})
+	}
+
+	return results, nil
+}
+
 // handleGetRawMempool implements the getrawmempool command.
 func handleGetRawMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.GetRawMempoolCmd)
@@ -2608,7 +3260,6 @@ func handleGetRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan str
 					"(specify --txindex)",
 			}
 		}
-
 		// Look up the location of the transaction.
 		blockRegion, err := s.cfg.TxIndex.TxBlockRegion(txHash)
 		if err != nil {
@@ -2616,6 +3267,12 @@ func handleGetRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan str
 			return nil, internalRPCError(err.Error(), context)
 		}
 		if blockRegion == nil {
+			// The transaction might not have been indexed yet.
+			err := checkIndexReady(s, s.cfg.TxIndex,
+				btcjson.ErrRPCNoTxInfo)
+			if err != nil {
+				return nil, err
+			}
 			return nil, rpcNoTxInfoError(txHash)
 		}
 
@@ -2689,8 +3346,9 @@ func handleGetRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan str
 		chainHeight = s.cfg.Chain.BestSnapshot().Height
 	}
 
-	rawTxn, err := createTxRawResult(s.cfg.ChainParams, mtx, txHash.String(),
-		blkHeader, blkHashStr, blkHeight, chainHeight)
+	rawTxn, err := createTxRawResult(s.cfg.ChainParams, s.cfg.ScriptCache,
+		mtx, txHash.String(), blkHeader, blkHashStr, blkHeight,
+		chainHeight)
 	if err != nil {
 		return nil, err
 	}
@@ -2780,8 +3438,8 @@ func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 	// Get further info about the script.
 	// Ignore the error here since an error means the script couldn't parse
 	// and there is no additional information about it anyways.
-	scriptClass, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(pkScript,
-		s.cfg.ChainParams)
+	scriptClass, addrs, reqSigs, _ := s.cfg.ScriptCache.ExtractPkScriptAddrs(
+		pkScript, s.cfg.ChainParams)
 	addresses := make([]string, len(addrs))
 	for i, addr := range addrs {
 		addresses[i] = addr.EncodeAddress()
@@ -2803,6 +3461,139 @@ func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 	return txOutReply, nil
 }
 
+// handleGetTxOutProof implements the gettxoutproof command.
+func handleGetTxOutProof(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetTxOutProofCmd)
+
+	if len(c.TxIDs) == 0 {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Invalid parameter, txids are missing",
+		}
+	}
+	txHashes := make(map[chainhash.Hash]struct{}, len(c.TxIDs))
+	var firstTxHash *chainhash.Hash
+	for _, txid := range c.TxIDs {
+		txHash, err := chainhash.NewHashFromStr(txid)
+		if err != nil {
+			return nil, rpcDecodeHexError(txid)
+		}
+		if _, ok := txHashes[*txHash]; ok {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Invalid parameter, duplicated txid: " + txid,
+			}
+		}
+		txHashes[*txHash] = struct{}{}
+		if firstTxHash == nil {
+			firstTxHash = txHash
+		}
+	}
+
+	// Use the provided block or look up the block that contains the first
+	// transaction with the transaction index otherwise.
+	var blockHash *chainhash.Hash
+	if c.BlockHash != nil {
+		var err error
+		blockHash, err = chainhash.NewHashFromStr(*c.BlockHash)
+		if err != nil {
+			return nil, rpcDecodeHexError(*c.BlockHash)
+		}
+	} else {
+		if s.cfg.TxIndex == nil {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCNoTxInfo,
+				Message: "The transaction index must be " +
+					"enabled to find the block of the " +
+					"transactions (specify --txindex or " +
+					"provide the block hash)",
+			}
+		}
+		blockRegion, err := s.cfg.TxIndex.TxBlockRegion(firstTxHash)
+		if err != nil {
+			context := "Failed to retrieve transaction location"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		if blockRegion == nil {
+			// The transaction might not have been indexed yet.
+			err := checkIndexReady(s, s.cfg.TxIndex,
+				btcjson.ErrRPCNoTxInfo)
+			if err != nil {
+				return nil, err
+			}
+			return nil, rpcNoTxInfoError(firstTxHash)
+		}
+		blockHash = blockRegion.Hash
+	}
+	block, err := s.cfg.Chain.BlockByHash(blockHash)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+
+	// Ensure all of the transactions are in the block.
+	numFound := 0
+	for _, tx := range block.Transactions() {
+		if _, ok := txHashes[*tx.Hash()]; ok {
+			numFound++
+		}
+	}
+	if numFound != len(txHashes) {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Not all transactions found in specified or " +
+				"retrieved block",
+		}
+	}
+
+	// Serialize the merkle block that proves the transactions are in the
+	// block.
+	var buf bytes.Buffer
+	merkleBlock := blockchain.NewMerkleBlock(block, txHashes)
+	err = merkleBlock.BtcEncode(&buf, wire.ProtocolVersion, wire.BaseEncoding)
+	if err != nil {
+		context := "Failed to serialize merkle block"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// handleGetTxSpendingPrevOut implements the gettxspendingprevout command.
+func handleGetTxSpendingPrevOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetTxSpendingPrevOutCmd)
+
+	if len(c.Outputs) == 0 {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Invalid parameter, outputs are missing",
+		}
+	}
+
+	results := make([]btcjson.GetTxSpendingPrevOutResult, 0, len(c.Outputs))
+	for _, output := range c.Outputs {
+		txHash, err := chainhash.NewHashFromStr(output.Txid)
+		if err != nil {
+			return nil, rpcDecodeHexError(output.Txid)
+		}
+
+		// The spending transaction is only set when the outpoint is
+		// spent by a transaction in the memory pool.
+		result := btcjson.GetTxSpendingPrevOutResult{
+			TxID: output.Txid,
+			Vout: output.Vout,
+		}
+		prevOut := wire.OutPoint{Hash: *txHash, Index: output.Vout}
+		if spender := s.cfg.TxMemPool.CheckSpend(prevOut); spender != nil {
+			result.SpendingTxID = spender.Hash().String()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
 // handleHelp implements the help command.
 func handleHelp(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.HelpCmd)
@@ -2855,6 +3646,18 @@ func handlePing(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (inter
 	return nil, nil
 }
 
+// handleResendWalletTransactions implements the resendwallettransactions
+// command.
+func handleResendWalletTransactions(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	txns := s.cfg.ConnMgr.RebroadcastTransactions()
+	hashes := make([]string, 0, len(txns))
+	for _, txD := range txns {
+		hashes = append(hashes, txD.Tx.Hash().String())
+	}
+
+	return hashes, nil
+}
+
 // retrievedTx represents a transaction that was either loaded from the
 // transaction memory pool or from the database.  When a transaction is loaded
 // from the database, it is loaded with the raw serialized bytes while the
@@ -3011,7 +3814,7 @@ func createVinListPrevOut(s *rpcServer, mtx *wire.MsgTx, chainParams *chaincfg.P
 		// Ignore the error here since an error means the script
 		// couldn't parse and there is no additional information about
 		// it anyways.
-		_, addrs, _, _ := txscript.ExtractPkScriptAddrs(
+		_, addrs, _, _ := s.cfg.ScriptCache.ExtractPkScriptAddrs(
 			originTxOut.PkScript, chainParams)
 
 		// Encode the addresses while checking if the address passes the
@@ -3086,6 +3889,9 @@ func handleSearchRawTransactions(s *rpcServer, cmd interface{}, closeChan <-chan
 			Message: "Address index must be enabled (--addrindex)",
 		}
 	}
+	if err := checkIndexReady(s, addrIndex, btcjson.ErrRPCMisc); err != nil {
+		return nil, err
+	}
 
 	// Override the flag for including extra previous output information in
 	// each input if needed.
@@ -3293,7 +4099,8 @@ func handleSearchRawTransactions(s *rpcServer, cmd interface{}, closeChan <-chan
 		if err != nil {
 			return nil, err
 		}
-		result.Vout = createVoutList(mtx, params, filterAddrMap)
+		result.Vout = createVoutList(mtx, params, s.cfg.ScriptCache,
+			filterAddrMap)
 		result.Version = mtx.Version
 		result.LockTime = mtx.LockTime
 
@@ -3340,6 +4147,34 @@ func handleSearchRawTransactions(s *rpcServer, cmd interface{}, closeChan <-chan
 	return srtList, nil
 }
 
+// sendRawTxFeeLimit returns how the passed fee setting of a sendrawtransaction
+// command limits the fee of the transaction.  High fees are allowed when the
+// legacy allowhighfees parameter is true or the maxfeerate parameter is zero,
+// which disables the limit in bitcoind.  Otherwise, the maxfeerate parameter,
+// in satoshi per 1000 bytes, is returned as the maximum fee rate, or zero when
+// it is not provided so the maximum fee of the mempool policy applies.
+func sendRawTxFeeLimit(feeSetting *btcjson.AllowHighFeesOrMaxFeeRate) (bool, btcutil.Amount, error) {
+	if feeSetting == nil {
+		return false, 0, nil
+	}
+	switch v := feeSetting.Value.(type) {
+	case *bool:
+		return v != nil && *v, 0, nil
+	case *int32:
+		if v == nil {
+			return false, 0, nil
+		}
+		if *v < 0 {
+			return false, 0, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "maxfeerate may not be negative",
+			}
+		}
+		return *v == 0, btcutil.Amount(*v), nil
+	}
+	return false, 0, nil
+}
+
 // handleSendRawTransaction implements the sendrawtransaction command.
 func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.SendRawTransactionCmd)
@@ -3361,9 +4196,14 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 		}
 	}
 
-	// Use 0 for the tag to represent local node.
+	allowHighFees, maxFeeRate, err := sendRawTxFeeLimit(c.FeeSetting)
+	if err != nil {
+		return nil, err
+	}
+
 	tx := btcutil.NewTx(&msgTx)
-	acceptedTxs, err := s.cfg.TxMemPool.ProcessTransaction(tx, false, false, 0)
+	acceptedTxs, err := s.cfg.TxMemPool.ProcessLocalTransaction(tx,
+		allowHighFees, maxFeeRate)
 	if err != nil {
 		// When the error is a rule error, it means the transaction was
 		// simply rejected as opposed to something actually going wrong,
@@ -3388,9 +4228,7 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 		if txRuleErr, ok := ruleErr.Err.(mempool.TxRuleError); ok {
 			errDesc := txRuleErr.Description
 			switch {
-			case strings.Contains(
-				strings.ToLower(errDesc), "orphan transaction",
-			):
+			case txRuleErr.Kind == mempool.ErrKindMissingInputs:
 				code = btcjson.ErrRPCTxError
 
 			case strings.Contains(
@@ -3733,6 +4571,52 @@ func handleVerifyMessage(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 	return address.EncodeAddress() == c.Address, nil
 }
 
+// handleVerifyTxOutProof implements the verifytxoutproof command.
+func handleVerifyTxOutProof(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.VerifyTxOutProofCmd)
+
+	// Deserialize the merkle block.
+	hexStr := c.Proof
+	if len(hexStr)%2 != 0 {
+		hexStr = "0" + hexStr
+	}
+	serialized, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, rpcDecodeHexError(hexStr)
+	}
+	var merkleBlock wire.MsgMerkleBlock
+	err = merkleBlock.BtcDecode(bytes.NewReader(serialized),
+		wire.ProtocolVersion, wire.BaseEncoding)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCDeserialization,
+			Message: "Proof decode failed: " + err.Error(),
+		}
+	}
+
+	// A proof that does not hash to the merkle root of the block it
+	// commits to proves no transactions.
+	matches, err := blockchain.ExtractMerkleBlockMatches(&merkleBlock)
+	if err != nil {
+		return []string{}, nil
+	}
+
+	// Only proofs for blocks in the main chain are accepted.
+	blockHash := merkleBlock.Header.BlockHash()
+	if !s.cfg.Chain.MainChainHasBlock(&blockHash) {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Block not found in chain",
+		}
+	}
+
+	txids := make([]string, 0, len(matches))
+	for _, hash := range matches {
+		txids = append(txids, hash.String())
+	}
+	return txids, nil
+}
+
 // handleVersion implements the version command.
 //
 // NOTE: This is a btcsuite extension ported from github.com/decred/dcrd.
@@ -3761,6 +4645,7 @@ type rpcServer struct {
 	statusLock             sync.RWMutex
 	wg                     sync.WaitGroup
 	gbtWorkState           *gbtWorkState
+	workQueue              *rpcWorkQueue
 	helpCacher             *helpCacher
 	requestProcessShutdown chan struct{}
 	quit                   chan int
@@ -3860,7 +4745,7 @@ func (s *rpcServer) NotifyNewTransactions(txns []*mempool.TxDesc) {
 
 		// Potentially notify any getblocktemplate long poll clients
 		// about stale block templates due to the new transaction.
-		s.gbtWorkState.NotifyMempoolTx(s.cfg.TxMemPool.LastUpdated())
+		s.gbtWorkState.NotifyMempoolTx(txD.Fee, txD.FeePerKB)
 	}
 }
 
@@ -4066,8 +4951,10 @@ func (s *rpcServer) processRequest(request *btcjson.Request, isAdmin bool, close
 		if parsedCmd.err != nil {
 			jsonErr = parsedCmd.err
 		} else {
-			result, err = s.standardCmdResult(parsedCmd,
-				closeChan)
+			result, err = s.workQueue.run(closeChan,
+				func(quit <-chan struct{}) (interface{}, error) {
+					return s.standardCmdResult(parsedCmd, quit)
+				})
 			if err != nil {
 				if rpcErr, ok := err.(*btcjson.RPCError); ok {
 					jsonErr = rpcErr
@@ -4314,20 +5201,27 @@ func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin
 		}
 	}
 
+	// Terminate with newline to maintain compatibility with Bitcoin Core.
+	reply := make([]byte, 0, len(msg)+1)
+	reply = append(reply, msg...)
+	reply = append(reply, '\n')
+
+	// Compress large replies when the client supports it.
+	reply, encoding := compressRPCReply(r.Header.Get("Accept-Encoding"), reply)
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+
 	// Write the response.
 	err = s.writeHTTPResponseHeaders(r, w.Header(), http.StatusOK, buf)
 	if err != nil {
 		rpcsLog.Error(err)
 		return
 	}
-	if _, err := buf.Write(msg); err != nil {
+	if _, err := buf.Write(reply); err != nil {
 		rpcsLog.Errorf("Failed to write marshalled reply: %v", err)
 	}
-
-	// Terminate with newline to maintain compatibility with Bitcoin Core.
-	if err := buf.WriteByte('\n'); err != nil {
-		rpcsLog.Errorf("Failed to append terminating newline to reply: %v", err)
-	}
 }
 
 // jsonAuthFail sends a message back to the client if the http auth is rejected.
@@ -4510,6 +5404,11 @@ type rpcserverConnManager interface {
 	// in a block.
 	AddRebroadcastInventory(iv *wire.InvVect, data interface{})
 
+	// RebroadcastTransactions relays inventory vectors for all of the
+	// locally submitted transactions that have not been requested by any
+	// peers yet and returns their descriptors.
+	RebroadcastTransactions() []*mempool.TxDesc
+
 	// RelayTransactions generates and relays inventory vectors for all of
 	// the passed transactions to all connected peers.
 	RelayTransactions(txns []*mempool.TxDesc)
@@ -4517,6 +5416,14 @@ type rpcserverConnManager interface {
 	// NodeAddresses returns an array consisting node addresses which can
 	// potentially be used to find new nodes in the network.
 	NodeAddresses() []*wire.NetAddress
+
+	// AddrManagerStats returns statistics about the addresses known to the
+	// address manager.
+	AddrManagerStats() addrmgr.Stats
+
+	// KnownAddresses returns details about up to the passed number of
+	// addresses known to the address manager selected at random.
+	KnownAddresses(max int) []addrmgr.KnownAddressInfo
 }
 
 // rpcserverSyncManager represents a sync manager for use with the RPC server.
@@ -4585,6 +5492,10 @@ type rpcserverConfig struct {
 	Generator *mining.BlkTmplGenerator
 	CPUMiner  *cpuminer.CPUMiner
 
+	// MiningPayouts chooses the payment address of the block templates
+	// returned by getblocktemplate from the configured mining addresses.
+	MiningPayouts *mining.PayoutAddrs
+
 	// These fields define any optional indexes the RPC server can make use
 	// of to provide additional data when queried.
 	TxIndex   *indexers.TxIndex
@@ -4594,14 +5505,30 @@ type rpcserverConfig struct {
 	// The fee estimator keeps track of how long transactions are left in
 	// the mempool before they are mined into blocks.
 	FeeEstimator *mempool.FeeEstimator
+
+	// ScriptCache caches the classification of public key scripts to
+	// avoid parsing the same scripts repeatedly when decoding outputs.
+	ScriptCache *txscript.ScriptClassCache
+
+	// VerifyBlocks specifies whether the transactions of blocks loaded from
+	// the database are verified to hash to the merkle root of their header
+	// before they are served in order to detect corrupted block data.
+	VerifyBlocks bool
+
+	// ReorgChunkSize is the maximum number of block disconnected and
+	// connected notifications of a chain reorganization that are queued
+	// for websocket clients at once.
+	ReorgChunkSize int
 }
 
 // newRPCServer returns a new instance of the rpcServer struct.
 func newRPCServer(config *rpcserverConfig) (*rpcServer, error) {
 	rpc := rpcServer{
-		cfg:                    *config,
-		statusLines:            make(map[int]string),
-		gbtWorkState:           newGbtWorkState(config.TimeSource),
+		cfg:          *config,
+		statusLines:  make(map[int]string),
+		gbtWorkState: newGbtWorkState(config.TimeSource),
+		workQueue: newRPCWorkQueue(cfg.RPCWorkers, cfg.RPCWorkQueue,
+			cfg.RPCRequestTimeout),
 		helpCacher:             newHelpCacher(),
 		requestProcessShutdown: make(chan struct{}),
 		quit:                   make(chan int),
@@ -4638,6 +5565,23 @@ func (s *rpcServer) handleBlockchainNotification(notification *blockchain.Notifi
 		// their old block template to become stale.
 		s.gbtWorkState.NotifyBlockConnected(block.Hash())
 
+	case blockchain.NTReorganization:
+		reorgData, ok := notification.Data.(*blockchain.ReorganizationNtfnsData)
+		if !ok {
+			rpcsLog.Warnf("Chain reorganization notification is " +
+				"malformed.")
+			break
+		}
+
+		// Prepare to queue the block notifications of the
+		// reorganization for websocket clients in chunks.
+		s.ntfnMgr.NotifyReorganization(reorgData)
+
+	case blockchain.NTReorganizationAborted:
+		// Stop collecting block notifications for websocket clients
+		// since the remaining ones of the reorganization won't follow.
+		s.ntfnMgr.NotifyReorganizationAborted()
+
 	case blockchain.NTBlockConnected:
 		block, ok := notification.Data.(*btcutil.Block)
 		if !ok {
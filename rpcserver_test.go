@@ -0,0 +1,1714 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/blockchain/indexers"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+	"github.com/btcsuite/btcutil"
+)
+
+// testTxSource is a mining.TxSource that provides a fixed set of transactions
+// which spend outputs from a fixed utxo view.  The zero value is a source
+// without any transactions.
+type testTxSource struct {
+	descs []*mining.TxDesc
+	view  *blockchain.UtxoViewpoint
+}
+
+func (s *testTxSource) LastUpdated() time.Time        { return time.Time{} }
+func (s *testTxSource) MiningDescs() []*mining.TxDesc { return s.descs }
+
+func (s *testTxSource) HaveTransaction(hash *chainhash.Hash) bool {
+	for _, desc := range s.descs {
+		if *desc.Tx.Hash() == *hash {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *testTxSource) FetchInputUtxos(*btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+	if s.view == nil {
+		return blockchain.NewUtxoViewpoint(), nil
+	}
+	return s.view, nil
+}
+
+// TestAddVinPrevOuts ensures the spent output details reported by getblock at
+// verbosity level 3 are joined with the correct transaction inputs.
+func TestAddVinPrevOuts(t *testing.T) {
+	t.Parallel()
+
+	params := &chaincfg.MainNetParams
+	p2pkhScript, _ := hex.DecodeString("76a914000000000000000000000000000" +
+		"000000000000088ac")
+	p2shScript, _ := hex.DecodeString("a914000000000000000000000000000000" +
+		"000000000087")
+
+	// Create a coinbase followed by two transactions spending one and two
+	// outputs respectively.
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: []byte{0x51, 0x51},
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, p2pkhScript))
+
+	tx1 := wire.NewMsgTx(wire.TxVersion)
+	tx1.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil,
+		nil))
+	tx1.AddTxOut(wire.NewTxOut(1000, p2pkhScript))
+
+	tx2 := wire.NewMsgTx(wire.TxVersion)
+	tx2.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{2}, 1), nil,
+		nil))
+	tx2.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{3}, 2), nil,
+		nil))
+	tx2.AddTxOut(wire.NewTxOut(2000, p2shScript))
+
+	stxos := []blockchain.SpentTxOut{
+		{Amount: 100000000, PkScript: p2pkhScript, Height: 10, IsCoinBase: true},
+		{Amount: 200000000, PkScript: p2shScript, Height: 20},
+		{Amount: 300000000, PkScript: p2pkhScript, Height: 30},
+	}
+
+	makeRawTxns := func() []btcjson.TxRawResult {
+		var rawTxns []btcjson.TxRawResult
+		for _, mtx := range []*wire.MsgTx{coinbase, tx1, tx2} {
+			rawTxn, err := createTxRawResult(params, nil, mtx,
+				mtx.TxHash().String(), nil, "", 0, 0)
+			if err != nil {
+				t.Fatalf("createTxRawResult: unexpected error: %v",
+					err)
+			}
+			rawTxns = append(rawTxns, *rawTxn)
+		}
+		return rawTxns
+	}
+
+	rawTxns := makeRawTxns()
+	if err := addVinPrevOuts(rawTxns, stxos, params); err != nil {
+		t.Fatalf("addVinPrevOuts: unexpected error: %v", err)
+	}
+
+	if rawTxns[0].Vin[0].PrevOut != nil {
+		t.Fatalf("coinbase input unexpectedly has a prevout")
+	}
+	vins := []btcjson.Vin{rawTxns[1].Vin[0], rawTxns[2].Vin[0],
+		rawTxns[2].Vin[1]}
+	for i, vin := range vins {
+		stxo := stxos[i]
+		if vin.PrevOut == nil {
+			t.Fatalf("input %d: missing prevout", i)
+		}
+		wantValue := btcutil.Amount(stxo.Amount).ToBTC()
+		if vin.PrevOut.Value != wantValue {
+			t.Fatalf("input %d: unexpected value -- got %v, want %v",
+				i, vin.PrevOut.Value, wantValue)
+		}
+		if vin.PrevOut.Height != int64(stxo.Height) {
+			t.Fatalf("input %d: unexpected height -- got %v, want %v",
+				i, vin.PrevOut.Height, stxo.Height)
+		}
+		if vin.PrevOut.Generated != stxo.IsCoinBase {
+			t.Fatalf("input %d: unexpected generated flag -- got %v, "+
+				"want %v", i, vin.PrevOut.Generated,
+				stxo.IsCoinBase)
+		}
+		wantHex := hex.EncodeToString(stxo.PkScript)
+		if vin.PrevOut.ScriptPubKey.Hex != wantHex {
+			t.Fatalf("input %d: unexpected script -- got %v, want %v",
+				i, vin.PrevOut.ScriptPubKey.Hex, wantHex)
+		}
+	}
+
+	// A spend journal that doesn't match the number of inputs must be
+	// rejected.
+	if err := addVinPrevOuts(makeRawTxns(), stxos[:2], params); err == nil {
+		t.Fatalf("addVinPrevOuts: did not fail with short spend journal")
+	}
+	extraStxos := append(stxos, stxos[0])
+	if err := addVinPrevOuts(makeRawTxns(), extraStxos, params); err == nil {
+		t.Fatalf("addVinPrevOuts: did not fail with long spend journal")
+	}
+}
+
+// TestBlockTemplateDepends ensures the dependencies reported for block template
+// transactions reference the indices of their parents and that templates in
+// which a transaction does not follow its parents are rejected.
+func TestBlockTemplateDepends(t *testing.T) {
+	t.Parallel()
+
+	// newTx returns a transaction that spends the provided outpoints and
+	// has two outputs.
+	newTx := func(prevOuts ...wire.OutPoint) *wire.MsgTx {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		for i := range prevOuts {
+			tx.AddTxIn(wire.NewTxIn(&prevOuts[i], nil, nil))
+		}
+		tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+		tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+		return tx
+	}
+
+	// Create a coinbase followed by an unrelated transaction, a parent, and
+	// a child that spends both outputs of the parent.
+	coinbase := newTx(*wire.NewOutPoint(&chainhash.Hash{},
+		wire.MaxPrevOutIndex))
+	unrelated := newTx(wire.OutPoint{Hash: chainhash.Hash{0x01}})
+	parent := newTx(wire.OutPoint{Hash: chainhash.Hash{0x02}})
+	parentHash := parent.TxHash()
+	child := newTx(*wire.NewOutPoint(&parentHash, 1),
+		*wire.NewOutPoint(&parentHash, 0),
+		wire.OutPoint{Hash: chainhash.Hash{0x03}})
+
+	depends, err := blockTemplateDepends([]*wire.MsgTx{coinbase, unrelated,
+		parent, child})
+	if err != nil {
+		t.Fatalf("blockTemplateDepends: unexpected error: %v", err)
+	}
+	want := [][]int64{{}, {}, {}, {2}}
+	if !reflect.DeepEqual(depends, want) {
+		t.Fatalf("blockTemplateDepends: unexpected depends - got %v, "+
+			"want %v", depends, want)
+	}
+
+	// Ensure a template with the child ahead of its parent is rejected.
+	_, err = blockTemplateDepends([]*wire.MsgTx{coinbase, child, parent})
+	if err == nil {
+		t.Fatal("blockTemplateDepends: did not reject child preceding " +
+			"its parent")
+	}
+}
+
+// TestGetBlockVerifyMerkleRoot ensures getblock refuses to serve a stored block
+// whose transactions no longer hash to the merkle root of its header when block
+// verification is enabled while the raw stored bytes are served otherwise.
+func TestGetBlockVerifyMerkleRoot(t *testing.T) {
+	blocks, err := loadTestBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("unable to load blocks: %v", err)
+	}
+
+	db := newTestDB(t, &chaincfg.MainNetParams)
+
+	// Store a copy of a block with a corrupted coinbase signature script.
+	// The block hash only commits to the header, so the corrupted block is
+	// stored under the hash of the original one.
+	var buf bytes.Buffer
+	if err := blocks[1].MsgBlock().Serialize(&buf); err != nil {
+		t.Fatalf("unable to serialize block: %v", err)
+	}
+	corrupted, err := btcutil.NewBlockFromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("unable to deserialize block: %v", err)
+	}
+	coinbase := corrupted.MsgBlock().Transactions[0]
+	coinbase.TxIn[0].SignatureScript[0] ^= 0xff
+	var corruptedBuf bytes.Buffer
+	if err := corrupted.MsgBlock().Serialize(&corruptedBuf); err != nil {
+		t.Fatalf("unable to serialize block: %v", err)
+	}
+	corrupted, err = btcutil.NewBlockFromBytes(corruptedBuf.Bytes())
+	if err != nil {
+		t.Fatalf("unable to deserialize block: %v", err)
+	}
+	err = db.Update(func(dbTx database.Tx) error {
+		return dbTx.StoreBlock(corrupted)
+	})
+	if err != nil {
+		t.Fatalf("unable to store block: %v", err)
+	}
+
+	cmd := btcjson.NewGetBlockCmd(blocks[1].Hash().String(),
+		btcjson.Int(0))
+
+	// Ensure the raw stored bytes are returned without verification.
+	s := &rpcServer{cfg: rpcserverConfig{DB: db}}
+	result, err := handleGetBlock(s, cmd, nil)
+	if err != nil {
+		t.Fatalf("unverified getblock: unexpected error: %v", err)
+	}
+	if want := hex.EncodeToString(corruptedBuf.Bytes()); result != want {
+		t.Fatalf("unverified getblock: got %v, want %v", result, want)
+	}
+
+	// Ensure the block is rejected with verification.  The failure is
+	// logged, so disable the logger which has no backend in tests.
+	defer func(log btclog.Logger) { rpcsLog = log }(rpcsLog)
+	rpcsLog = btclog.Disabled
+	s.cfg.VerifyBlocks = true
+	_, err = handleGetBlock(s, cmd, nil)
+	rpcErr, ok := err.(*btcjson.RPCError)
+	if !ok || rpcErr.Code != btcjson.ErrRPCDatabase {
+		t.Fatalf("verified getblock: got error %v, want %v", err,
+			btcjson.ErrRPCDatabase)
+	}
+}
+
+// TestGetBlockTxOrder ensures getblock presents the transactions of a block in
+// block order with the coinbase first for both the txid list and the decoded
+// transactions, including when the verbosity is not specified.
+func TestGetBlockTxOrder(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	// The test blocks spend coinbase outputs immediately, so lower the
+	// coinbase maturity accordingly.
+	params := chaincfg.MainNetParams
+	params.CoinbaseMaturity = 1
+	chain, db := newTestChain(t, &params)
+	blocks, err := loadTestBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("unable to load blocks: %v", err)
+	}
+	for _, block := range blocks[1:] {
+		_, _, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("unable to process block %v: %v", block.Hash(),
+				err)
+		}
+	}
+	s := &rpcServer{cfg: rpcserverConfig{
+		DB:          db,
+		Chain:       chain,
+		ChainParams: &params,
+	}}
+
+	// Use the block with the most transactions.
+	block := blocks[1]
+	for _, b := range blocks[2:] {
+		if len(b.Transactions()) > len(block.Transactions()) {
+			block = b
+		}
+	}
+	if len(block.Transactions()) < 2 {
+		t.Fatal("test blocks do not contain any non-coinbase transactions")
+	}
+	wantTxns := make([]string, 0, len(block.Transactions()))
+	for _, tx := range block.Transactions() {
+		wantTxns = append(wantTxns, tx.Hash().String())
+	}
+
+	getBlock := func(verbosity *int) btcjson.GetBlockVerboseResult {
+		t.Helper()
+		cmd := &btcjson.GetBlockCmd{
+			Hash:      block.Hash().String(),
+			Verbosity: verbosity,
+		}
+		result, err := handleGetBlock(s, cmd, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result.(btcjson.GetBlockVerboseResult)
+	}
+
+	// Ensure the txid list is in block order for both an explicit and the
+	// default verbosity.
+	for _, verbosity := range []*int{nil, btcjson.Int(1)} {
+		if txns := getBlock(verbosity).Tx; !reflect.DeepEqual(txns,
+			wantTxns) {
+
+			t.Fatalf("unexpected txid list - got %v, want %v", txns,
+				wantTxns)
+		}
+	}
+
+	// Ensure the decoded transactions are in the same order and that only
+	// the first one is reported as the coinbase.
+	rawTxns := getBlock(btcjson.Int(2)).RawTx
+	if len(rawTxns) != len(wantTxns) {
+		t.Fatalf("got %d decoded transactions, want %d", len(rawTxns),
+			len(wantTxns))
+	}
+	for i, rawTx := range rawTxns {
+		if rawTx.Txid != wantTxns[i] {
+			t.Fatalf("decoded transaction %d: got txid %v, want %v",
+				i, rawTx.Txid, wantTxns[i])
+		}
+		isCoinbase := rawTx.Vin[0].IsCoinBase()
+		if isCoinbase != (i == 0) {
+			t.Fatalf("decoded transaction %d: got coinbase %v, "+
+				"want %v", i, isCoinbase, i == 0)
+		}
+	}
+}
+
+// TestGetOrphanTxs ensures getorphantxs reports the transactions in the orphan
+// pool along with the parents they are missing.
+func TestGetOrphanTxs(t *testing.T) {
+	t.Parallel()
+
+	// Create a pool whose view of the main chain reports every output as
+	// missing so any transaction that spends an output is an orphan.
+	fetchUtxoView := func(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+		view := blockchain.NewUtxoViewpoint()
+		for _, txIn := range tx.MsgTx().TxIn {
+			view.Entries()[txIn.PreviousOutPoint] = nil
+		}
+		return view, nil
+	}
+	txPool := mempool.New(&mempool.Config{
+		Policy: mempool.Policy{
+			AcceptNonStd:    true,
+			MaxOrphanTxs:    5,
+			MaxOrphanTxSize: 1000,
+		},
+		ChainParams:    &chaincfg.RegressionNetParams,
+		FetchUtxoView:  fetchUtxoView,
+		BestHeight:     func() int32 { return 1 },
+		MedianTimePast: time.Now,
+	})
+	s := &rpcServer{cfg: rpcserverConfig{TxMemPool: txPool}}
+
+	// Add an orphan that spends two outputs of one missing parent and an
+	// output of another.
+	parent1 := chainhash.Hash{0x01}
+	parent2 := chainhash.Hash{0x02}
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parent1, 0), nil, nil))
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parent2, 0), nil, nil))
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parent1, 1), nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	orphan := btcutil.NewTx(msgTx)
+	_, err := txPool.ProcessTransaction(orphan, true, false, 7)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept orphan: %v", err)
+	}
+
+	// Ensure only the hash is returned when not verbose.
+	result, err := handleGetOrphanTxs(s, btcjson.NewGetOrphanTxsCmd(nil),
+		nil)
+	if err != nil {
+		t.Fatalf("handleGetOrphanTxs: unexpected error: %v", err)
+	}
+	wantHashes := []string{orphan.Hash().String()}
+	if !reflect.DeepEqual(result, wantHashes) {
+		t.Fatalf("handleGetOrphanTxs: unexpected result - got %v, "+
+			"want %v", result, wantHashes)
+	}
+
+	// Ensure the verbose result reports the missing parents once each.
+	result, err = handleGetOrphanTxs(s,
+		btcjson.NewGetOrphanTxsCmd(btcjson.Bool(true)), nil)
+	if err != nil {
+		t.Fatalf("handleGetOrphanTxs: unexpected error: %v", err)
+	}
+	results, ok := result.([]btcjson.GetOrphanTxsResult)
+	if !ok || len(results) != 1 {
+		t.Fatalf("handleGetOrphanTxs: unexpected result - got %v, "+
+			"want 1 orphan", result)
+	}
+	got := results[0]
+	if got.TxID != orphan.Hash().String() || got.From != 7 ||
+		got.Size != int32(msgTx.SerializeSize()) {
+
+		t.Fatalf("handleGetOrphanTxs: unexpected orphan - got %+v", got)
+	}
+	if got.Time == 0 || got.Expiration <= got.Time {
+		t.Fatalf("handleGetOrphanTxs: unexpected times - got time %d, "+
+			"expiration %d", got.Time, got.Expiration)
+	}
+	wantParents := []string{parent1.String(), parent2.String()}
+	if !reflect.DeepEqual(got.MissingParents, wantParents) {
+		t.Fatalf("handleGetOrphanTxs: unexpected missing parents - "+
+			"got %v, want %v", got.MissingParents, wantParents)
+	}
+}
+
+// TestGetTxSpendingPrevOut ensures gettxspendingprevout reports the memory pool
+// transaction spending an outpoint and nothing for unspent outpoints.
+func TestGetTxSpendingPrevOut(t *testing.T) {
+	t.Parallel()
+
+	// Create a pool whose view of the main chain contains the outputs of a
+	// single confirmed transaction that are anyone-can-spend.
+	fundingTx := wire.NewMsgTx(wire.TxVersion)
+	fundingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		nil, nil))
+	fundingTx.AddTxOut(wire.NewTxOut(100000, []byte{txscript.OP_TRUE}))
+	fundingTx.AddTxOut(wire.NewTxOut(100000, []byte{txscript.OP_TRUE}))
+	funding := btcutil.NewTx(fundingTx)
+	fetchUtxoView := func(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+		view := blockchain.NewUtxoViewpoint()
+		view.AddTxOuts(funding, 1)
+		return view, nil
+	}
+	txPool := mempool.New(&mempool.Config{
+		Policy: mempool.Policy{
+			DisableRelayPriority: true,
+			AcceptNonStd:         true,
+			MaxTxVersion:         wire.TxVersion,
+			MaxSigOpCostPerTx:    blockchain.MaxBlockSigOpsCost,
+		},
+		ChainParams:    &chaincfg.RegressionNetParams,
+		FetchUtxoView:  fetchUtxoView,
+		BestHeight:     func() int32 { return 1 },
+		MedianTimePast: time.Now,
+		CalcSequenceLock: func(*btcutil.Tx, *blockchain.UtxoViewpoint) (*blockchain.SequenceLock, error) {
+			return &blockchain.SequenceLock{Seconds: -1, BlockHeight: -1}, nil
+		},
+	})
+	s := &rpcServer{cfg: rpcserverConfig{TxMemPool: txPool}}
+
+	// Add a transaction spending the first output of the funding
+	// transaction to the pool.
+	fundingHash := funding.Hash()
+	spendTx := wire.NewMsgTx(wire.TxVersion)
+	spendTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(fundingHash, 0), nil, nil))
+	spendTx.AddTxOut(wire.NewTxOut(90000, []byte{txscript.OP_TRUE}))
+	spend := btcutil.NewTx(spendTx)
+	_, err := txPool.ProcessTransaction(spend, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+
+	// Ensure the spent output reports the spending transaction while the
+	// unspent output and the output of the pool transaction do not.
+	cmd := btcjson.NewGetTxSpendingPrevOutCmd([]btcjson.TransactionInput{
+		{Txid: fundingHash.String(), Vout: 0},
+		{Txid: fundingHash.String(), Vout: 1},
+		{Txid: spend.Hash().String(), Vout: 0},
+	})
+	result, err := handleGetTxSpendingPrevOut(s, cmd, nil)
+	if err != nil {
+		t.Fatalf("handleGetTxSpendingPrevOut: unexpected error: %v", err)
+	}
+	want := []btcjson.GetTxSpendingPrevOutResult{
+		{
+			TxID:         fundingHash.String(),
+			Vout:         0,
+			SpendingTxID: spend.Hash().String(),
+		},
+		{TxID: fundingHash.String(), Vout: 1},
+		{TxID: spend.Hash().String(), Vout: 0},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("handleGetTxSpendingPrevOut: unexpected result - got "+
+			"%+v, want %+v", result, want)
+	}
+
+	// Ensure an invalid hash and missing outputs are rejected.
+	cmd = btcjson.NewGetTxSpendingPrevOutCmd([]btcjson.TransactionInput{
+		{Txid: "zz", Vout: 0},
+	})
+	if _, err := handleGetTxSpendingPrevOut(s, cmd, nil); err == nil {
+		t.Fatal("handleGetTxSpendingPrevOut: did not reject invalid hash")
+	}
+	cmd = btcjson.NewGetTxSpendingPrevOutCmd(nil)
+	if _, err := handleGetTxSpendingPrevOut(s, cmd, nil); err == nil {
+		t.Fatal("handleGetTxSpendingPrevOut: did not reject missing " +
+			"outputs")
+	}
+}
+
+// TestGetAddrMan ensures getaddrman reports the number of new and tried
+// addresses known to the address manager along with a sample of them.
+func TestGetAddrMan(t *testing.T) {
+	t.Parallel()
+
+	amgr := addrmgr.New("", nil)
+	const numAddrs, numGood = 50, 5
+	addrs := make([]*wire.NetAddress, numAddrs)
+	for i := range addrs {
+		addrs[i] = wire.NewNetAddressIPPort(net.IPv4(60, byte(i), 1, 1),
+			8333, wire.SFNodeNetwork)
+	}
+	srcAddr := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111), 8333,
+		wire.SFNodeNetwork)
+	amgr.AddAddresses(addrs, srcAddr)
+	for _, addr := range addrs[:numGood] {
+		amgr.Good(addr)
+	}
+
+	s := &rpcServer{cfg: rpcserverConfig{
+		ConnMgr: &rpcConnManager{server: &server{addrManager: amgr}},
+	}}
+	result, err := handleGetAddrMan(s, btcjson.NewGetAddrManCmd(nil), nil)
+	if err != nil {
+		t.Fatalf("handleGetAddrMan: unexpected error: %v", err)
+	}
+	got := result.(*btcjson.GetAddrManResult)
+	if got.New != numAddrs-numGood || got.Tried != numGood ||
+		got.Total != numAddrs {
+
+		t.Fatalf("handleGetAddrMan: got %d new, %d tried and %d total "+
+			"addresses, want %d, %d and %d", got.New, got.Tried,
+			got.Total, numAddrs-numGood, numGood, numAddrs)
+	}
+	if got.NewBucketsUsed == 0 || got.TriedBucketsUsed == 0 {
+		t.Fatalf("handleGetAddrMan: got %d new and %d tried buckets "+
+			"used", got.NewBucketsUsed, got.TriedBucketsUsed)
+	}
+	if len(got.Addresses) != 10 {
+		t.Fatalf("handleGetAddrMan: got %d sampled addresses, want 10",
+			len(got.Addresses))
+	}
+
+	// Ensure the sample includes every address when more are requested
+	// than are known and that the tried ones are reported as such.
+	result, err = handleGetAddrMan(s,
+		btcjson.NewGetAddrManCmd(btcjson.Int32(numAddrs*2)), nil)
+	if err != nil {
+		t.Fatalf("handleGetAddrMan: unexpected error: %v", err)
+	}
+	got = result.(*btcjson.GetAddrManResult)
+	if len(got.Addresses) != numAddrs {
+		t.Fatalf("handleGetAddrMan: got %d sampled addresses, want %d",
+			len(got.Addresses), numAddrs)
+	}
+	var numTried int32
+	for _, addr := range got.Addresses {
+		if addr.Tried {
+			numTried++
+			if addr.LastSuccess == 0 {
+				t.Fatalf("handleGetAddrMan: tried address %s has "+
+					"no last success", addr.Address)
+			}
+		}
+		if addr.Source != srcAddr.IP.String() || addr.Port != 8333 {
+			t.Fatalf("handleGetAddrMan: unexpected address %+v", addr)
+		}
+	}
+	if numTried != got.Tried {
+		t.Fatalf("handleGetAddrMan: got %d tried addresses in sample, "+
+			"want %d", numTried, got.Tried)
+	}
+}
+
+// TestTxOutProof ensures gettxoutproof creates proofs verifytxoutproof accepts
+// and that invalid proofs prove no transactions.
+func TestTxOutProof(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	// The test blocks spend coinbase outputs immediately, so lower the
+	// coinbase maturity accordingly.
+	params := chaincfg.MainNetParams
+	params.CoinbaseMaturity = 1
+	chain, _ := newTestChain(t, &params)
+	blocks, err := loadTestBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("unable to load blocks: %v", err)
+	}
+	for _, block := range blocks[1:] {
+		_, _, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("unable to process block %v: %v", block.Hash(),
+				err)
+		}
+	}
+	s := &rpcServer{cfg: rpcserverConfig{Chain: chain}}
+
+	// Create a proof for the last transaction of the last block and ensure
+	// it verifies to that transaction.
+	block := blocks[len(blocks)-1]
+	txns := block.Transactions()
+	txid := txns[len(txns)-1].Hash().String()
+	blockHash := block.Hash().String()
+	cmd := btcjson.NewGetTxOutProofCmd([]string{txid}, &blockHash)
+	result, err := handleGetTxOutProof(s, cmd, nil)
+	if err != nil {
+		t.Fatalf("handleGetTxOutProof: unexpected error: %v", err)
+	}
+	proof := result.(string)
+	verifyCmd := btcjson.NewVerifyTxOutProofCmd(proof)
+	result, err = handleVerifyTxOutProof(s, verifyCmd, nil)
+	if err != nil {
+		t.Fatalf("handleVerifyTxOutProof: unexpected error: %v", err)
+	}
+	if want := []string{txid}; !reflect.DeepEqual(result, want) {
+		t.Fatalf("handleVerifyTxOutProof: got %v, want %v", result,
+			want)
+	}
+
+	// Ensure a proof with a tampered hash proves no transactions.  The
+	// first hash follows the header, the transaction count, and the
+	// number of hashes.
+	serialized, err := hex.DecodeString(proof)
+	if err != nil {
+		t.Fatalf("unable to decode proof: %v", err)
+	}
+	serialized[wire.MaxBlockHeaderPayload+4+1] ^= 0xff
+	verifyCmd = btcjson.NewVerifyTxOutProofCmd(hex.EncodeToString(serialized))
+	result, err = handleVerifyTxOutProof(s, verifyCmd, nil)
+	if err != nil {
+		t.Fatalf("handleVerifyTxOutProof: unexpected error: %v", err)
+	}
+	if want := []string{}; !reflect.DeepEqual(result, want) {
+		t.Fatalf("handleVerifyTxOutProof: got %v, want %v", result,
+			want)
+	}
+
+	// Ensure proofs can't be created for transactions that are not in the
+	// block.
+	otherTxID := blocks[1].Transactions()[0].Hash().String()
+	cmd = btcjson.NewGetTxOutProofCmd([]string{txid, otherTxID}, &blockHash)
+	_, err = handleGetTxOutProof(s, cmd, nil)
+	rpcErr, ok := err.(*btcjson.RPCError)
+	if !ok || rpcErr.Code != btcjson.ErrRPCInvalidAddressOrKey {
+		t.Fatalf("handleGetTxOutProof: got error %v, want %v", err,
+			btcjson.ErrRPCInvalidAddressOrKey)
+	}
+}
+
+// TestGetBlockHeaderNeighbors ensures verbose getblockheader results include
+// the hashes of the previous and next blocks of the main chain when they exist.
+func TestGetBlockHeaderNeighbors(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	// The test blocks spend coinbase outputs immediately, so lower the
+	// coinbase maturity accordingly.
+	params := chaincfg.MainNetParams
+	params.CoinbaseMaturity = 1
+	chain, _ := newTestChain(t, &params)
+	blocks, err := loadTestBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("unable to load blocks: %v", err)
+	}
+	for _, block := range blocks[1:] {
+		_, _, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("unable to process block %v: %v", block.Hash(),
+				err)
+		}
+	}
+	s := &rpcServer{cfg: rpcserverConfig{Chain: chain, ChainParams: &params}}
+
+	tests := []struct {
+		name     string
+		height   int
+		wantPrev string
+		wantNext string
+	}{{
+		name:     "genesis",
+		height:   0,
+		wantNext: blocks[1].Hash().String(),
+	}, {
+		name:     "mid-chain",
+		height:   2,
+		wantPrev: blocks[1].Hash().String(),
+		wantNext: blocks[3].Hash().String(),
+	}, {
+		name:     "tip",
+		height:   len(blocks) - 1,
+		wantPrev: blocks[len(blocks)-2].Hash().String(),
+	}}
+	for _, test := range tests {
+		cmd := btcjson.NewGetBlockHeaderCmd(
+			blocks[test.height].Hash().String(), btcjson.Bool(true))
+		result, err := handleGetBlockHeader(s, cmd, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		header := result.(btcjson.GetBlockHeaderVerboseResult)
+		if header.PreviousHash != test.wantPrev {
+			t.Fatalf("%s: got previous hash %q, want %q", test.name,
+				header.PreviousHash, test.wantPrev)
+		}
+		if header.NextHash != test.wantNext {
+			t.Fatalf("%s: got next hash %q, want %q", test.name,
+				header.NextHash, test.wantNext)
+		}
+	}
+}
+
+// TestSearchRawTransactionsIndexReady ensures searchrawtransactions returns an
+// error instead of incomplete results until the address index has been synced
+// to the best chain.
+func TestSearchRawTransactionsIndexReady(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+	indexers.UseLogger(btclog.Disabled)
+
+	// The test blocks spend coinbase outputs immediately, so lower the
+	// coinbase maturity accordingly.
+	params := chaincfg.MainNetParams
+	params.CoinbaseMaturity = 1
+	db := newTestDB(t, &params)
+	chainCfg := blockchain.Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  blockchain.NewMedianTime(),
+		SigCache:    txscript.NewSigCache(1000),
+	}
+	chain, err := blockchain.New(&chainCfg)
+	if err != nil {
+		t.Fatalf("unable to create chain: %v", err)
+	}
+	blocks, err := loadTestBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("unable to load blocks: %v", err)
+	}
+
+	// Search for the address paid by the coinbase of the first block.
+	pkScript := blocks[1].Transactions()[0].MsgTx().TxOut[0].PkScript
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, &params)
+	if err != nil || len(addrs) != 1 {
+		t.Fatalf("unable to extract coinbase address: %v", err)
+	}
+	verbose := 0
+	cmd := btcjson.NewSearchRawTransactionsCmd(addrs[0].EncodeAddress(),
+		&verbose, nil, nil, nil, nil, nil)
+
+	addrIndex := indexers.NewAddrIndex(db, &params)
+	s := &rpcServer{cfg: rpcserverConfig{
+		Chain:       chain,
+		ChainParams: &params,
+		DB:          db,
+		AddrIndex:   addrIndex,
+	}}
+
+	// searchErr performs the search and ensures it fails with an index
+	// readiness error containing the passed message.
+	searchErr := func(want string) {
+		t.Helper()
+		_, err := handleSearchRawTransactions(s, cmd, nil)
+		rpcErr, ok := err.(*btcjson.RPCError)
+		if !ok || rpcErr.Code != btcjson.ErrRPCMisc ||
+			!strings.Contains(rpcErr.Message, want) {
+
+			t.Fatalf("handleSearchRawTransactions: got error %v, "+
+				"want %q", err, want)
+		}
+	}
+
+	// processBlocks loads the chain, along with the address index and the
+	// transaction index it relies on when requested, and connects the
+	// passed blocks to it.
+	processBlocks := func(withIndexes bool, blocks []*btcutil.Block) {
+		t.Helper()
+		chainCfg.IndexManager = nil
+		if withIndexes {
+			chainCfg.IndexManager = indexers.NewManager(db,
+				[]indexers.Indexer{indexers.NewTxIndex(db),
+					addrIndex})
+		}
+		chain, err := blockchain.New(&chainCfg)
+		if err != nil {
+			t.Fatalf("unable to create chain: %v", err)
+		}
+		for _, block := range blocks {
+			_, _, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("unable to process block %v: %v",
+					block.Hash(), err)
+			}
+		}
+		s.cfg.Chain = chain
+	}
+
+	// Ensure the search fails while the address index has not been
+	// initialized.
+	searchErr("address index is not available")
+
+	// Ensure the search fails while the address index is behind the best
+	// chain by indexing the first blocks and then connecting the rest
+	// without the indexes.
+	processBlocks(true, blocks[1:3])
+	processBlocks(false, blocks[3:])
+	searchErr("address index is still syncing (synced to height 2 of 4)")
+
+	// Ensure the search finds the transactions paying the address once
+	// the index has caught up with the best chain.
+	processBlocks(true, nil)
+	result, err := handleSearchRawTransactions(s, cmd, nil)
+	if err != nil {
+		t.Fatalf("handleSearchRawTransactions: unexpected error: %v",
+			err)
+	}
+	if txns := result.([]string); len(txns) == 0 {
+		t.Fatal("handleSearchRawTransactions: no transactions found")
+	}
+}
+
+// TestGetBlockTemplateTarget ensures the target returned by getblocktemplate is
+// the big-endian expansion of the compact bits of the template so blocks with
+// hashes that compare below it pass the proof of work check of the chain.
+func TestGetBlockTemplateTarget(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	cfg = &config{RegressionTest: true}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	params := chaincfg.RegressionNetParams
+	timeSource := blockchain.NewMedianTime()
+	chain, _ := newTestChain(t, &params)
+	policy := mining.Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight - 4000,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize - 1000,
+	}
+	s := &rpcServer{
+		cfg: rpcserverConfig{
+			Chain:       chain,
+			ChainParams: &params,
+			Generator: mining.NewBlkTmplGenerator(&policy, &params,
+				&testTxSource{}, chain, timeSource, nil, nil),
+		},
+		gbtWorkState: newGbtWorkState(timeSource),
+	}
+
+	cmd := btcjson.NewGetBlockTemplateCmd(&btcjson.TemplateRequest{
+		Capabilities: []string{"coinbasevalue"},
+		Rules:        []string{"segwit"},
+	})
+	result, err := handleGetBlockTemplate(s, cmd, nil)
+	if err != nil {
+		t.Fatalf("handleGetBlockTemplate: unexpected error: %v", err)
+	}
+	reply := result.(*btcjson.GetBlockTemplateResult)
+
+	// The block after the genesis block uses the proof of work limit of
+	// the network.
+	const wantBits = "207fffff"
+	const wantTarget = "7fffff0000000000000000000000000000000000000000000000000000000000"
+	if reply.Bits != wantBits {
+		t.Fatalf("unexpected bits -- got %s, want %s", reply.Bits,
+			wantBits)
+	}
+	if reply.Target != wantTarget {
+		t.Fatalf("unexpected target -- got %s, want %s", reply.Target,
+			wantTarget)
+	}
+
+	// Ensure blocks built from the template pass the proof of work check
+	// of the chain exactly when their hash, which is displayed in the same
+	// byte order as the target, is equal to or below the target.
+	target, ok := new(big.Int).SetString(reply.Target, 16)
+	if !ok {
+		t.Fatalf("unable to parse target %s", reply.Target)
+	}
+	msgBlock := *s.gbtWorkState.template.Block
+	var numAccepted, numRejected int
+	for nonce := uint32(0); numAccepted == 0 || numRejected == 0; nonce++ {
+		if nonce == 1000 {
+			t.Fatal("unable to find both accepted and rejected " +
+				"nonces")
+		}
+		msgBlock.Header.Nonce = nonce
+		hash := msgBlock.Header.BlockHash()
+		belowTarget := blockchain.HashToBig(&hash).Cmp(target) <= 0
+		err := blockchain.CheckProofOfWork(btcutil.NewBlock(&msgBlock),
+			params.PowLimit)
+		if belowTarget != (err == nil) {
+			t.Fatalf("nonce %d: hash %v below target %v, but proof "+
+				"of work check returned %v", nonce, hash,
+				belowTarget, err)
+		}
+		if belowTarget {
+			numAccepted++
+		} else {
+			numRejected++
+		}
+	}
+}
+
+// TestGetBlockTemplateRules ensures the rules of getblocktemplate results only
+// include the csv and cltv rules while segwit is not active and that clients
+// requesting the segwit rule are served a template without transactions with
+// witness data or a witness commitment even when the memory pool has some.
+func TestGetBlockTemplateRules(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	cfg = &config{RegressionTest: true}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	// Activate the cltv rule from the start.  The csv rule is not active
+	// since it requires a deployment vote.
+	params := chaincfg.MainNetParams
+	params.BIP0065Height = 0
+	timeSource := blockchain.NewMedianTime()
+	chain, _ := newTestChain(t, &params)
+
+	// Provide a transaction with witness data that spends a
+	// pay-to-witness-script-hash output.
+	trueScript := []byte{txscript.OP_TRUE}
+	witnessScriptHash := sha256.Sum256(trueScript)
+	witnessPkScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).
+		AddData(witnessScriptHash[:]).Script()
+	if err != nil {
+		t.Fatalf("unable to create witness script: %v", err)
+	}
+	fundingTx := wire.NewMsgTx(wire.TxVersion)
+	fundingTx.AddTxIn(&wire.TxIn{})
+	fundingTx.AddTxOut(wire.NewTxOut(btcutil.SatoshiPerBitcoin,
+		witnessPkScript))
+	view := blockchain.NewUtxoViewpoint()
+	view.AddTxOuts(btcutil.NewTx(fundingTx), 0)
+
+	fundingHash := fundingTx.TxHash()
+	witnessTx := wire.NewMsgTx(wire.TxVersion)
+	witnessTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&fundingHash, 0),
+		Witness:          wire.TxWitness{trueScript},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	witnessTx.AddTxOut(wire.NewTxOut(btcutil.SatoshiPerBitcoin-10000,
+		trueScript))
+	txSource := &testTxSource{
+		descs: []*mining.TxDesc{{Tx: btcutil.NewTx(witnessTx), Fee: 10000}},
+		view:  view,
+	}
+
+	policy := mining.Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight - 4000,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize - 1000,
+	}
+	s := &rpcServer{
+		cfg: rpcserverConfig{
+			Chain:       chain,
+			ChainParams: &params,
+			Generator: mining.NewBlkTmplGenerator(&policy, &params,
+				txSource, chain, timeSource, nil, nil),
+		},
+		gbtWorkState: newGbtWorkState(timeSource),
+	}
+
+	cmd := btcjson.NewGetBlockTemplateCmd(&btcjson.TemplateRequest{
+		Capabilities: []string{"coinbasevalue"},
+		Rules:        []string{"segwit"},
+	})
+	result, err := handleGetBlockTemplate(s, cmd, nil)
+	if err != nil {
+		t.Fatalf("handleGetBlockTemplate: unexpected error: %v", err)
+	}
+	reply := result.(*btcjson.GetBlockTemplateResult)
+	if want := []string{"cltv"}; !reflect.DeepEqual(reply.Rules, want) {
+		t.Fatalf("unexpected rules -- got %v, want %v", reply.Rules,
+			want)
+	}
+	if len(reply.Transactions) != 0 {
+		t.Fatalf("unexpected number of template transactions -- got "+
+			"%d, want 0", len(reply.Transactions))
+	}
+	if reply.DefaultWitnessCommitment != "" {
+		t.Fatalf("unexpected witness commitment %s",
+			reply.DefaultWitnessCommitment)
+	}
+}
+
+// TestGetBlockTemplateTimes ensures the minimum time of block templates is one
+// second after the median time of the past blocks and the current time is the
+// adjusted network time.
+func TestGetBlockTemplateTimes(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	cfg = &config{RegressionTest: true}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	params := chaincfg.RegressionNetParams
+	timeSource := blockchain.NewMedianTime()
+	chain, _ := newTestChain(t, &params)
+
+	// Extend the chain with blocks that are a minute apart and end a few
+	// minutes ago.  The median time of the past 11 blocks is the timestamp
+	// of the sixth most recent block.
+	const numBlocks = 11
+	firstTime := time.Now().Add(-time.Minute * (numBlocks + 5))
+	blocks := addTestBlocks(t, chain, &params, firstTime, numBlocks)
+	medianTime := blocks[numBlocks-6].MsgBlock().Header.Timestamp
+
+	policy := mining.Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight - 4000,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize - 1000,
+	}
+	s := &rpcServer{
+		cfg: rpcserverConfig{
+			Chain:       chain,
+			ChainParams: &params,
+			SyncMgr:     currentSyncManager{},
+			Generator: mining.NewBlkTmplGenerator(&policy, &params,
+				&testTxSource{}, chain, timeSource, nil, nil),
+		},
+		gbtWorkState: newGbtWorkState(timeSource),
+	}
+
+	cmd := btcjson.NewGetBlockTemplateCmd(&btcjson.TemplateRequest{
+		Capabilities: []string{"coinbasevalue"},
+		Rules:        []string{"segwit"},
+	})
+	before := time.Now().Unix()
+	result, err := handleGetBlockTemplate(s, cmd, nil)
+	if err != nil {
+		t.Fatalf("handleGetBlockTemplate: unexpected error: %v", err)
+	}
+	after := time.Now().Unix()
+	reply := result.(*btcjson.GetBlockTemplateResult)
+
+	if want := medianTime.Unix() + 1; reply.MinTime != want {
+		t.Fatalf("unexpected mintime -- got %d, want %d",
+			reply.MinTime, want)
+	}
+	if reply.CurTime < before || reply.CurTime > after {
+		t.Fatalf("unexpected curtime -- got %d, want between %d and %d",
+			reply.CurTime, before, after)
+	}
+}
+
+// TestGetBlockTemplateHeight ensures the height and previous block hash of
+// getblocktemplate results match the best chain and that long poll clients
+// are served a template for the new tip once a block connects.
+func TestGetBlockTemplateHeight(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	cfg = &config{RegressionTest: true}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	params := chaincfg.RegressionNetParams
+	timeSource := blockchain.NewMedianTime()
+	chain, _ := newTestChain(t, &params)
+	addTestBlocks(t, chain, &params, time.Now().Add(-time.Minute*10), 5)
+
+	policy := mining.Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight - 4000,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize - 1000,
+	}
+	s := &rpcServer{
+		cfg: rpcserverConfig{
+			Chain:       chain,
+			ChainParams: &params,
+			SyncMgr:     currentSyncManager{},
+			Generator: mining.NewBlkTmplGenerator(&policy, &params,
+				&testTxSource{}, chain, timeSource, nil, nil),
+		},
+		gbtWorkState: newGbtWorkState(timeSource),
+	}
+
+	// checkTemplate ensures the passed result builds on the current best
+	// chain tip.
+	checkTemplate := func(result interface{}) *btcjson.GetBlockTemplateResult {
+		t.Helper()
+		reply := result.(*btcjson.GetBlockTemplateResult)
+		best := chain.BestSnapshot()
+		if want := int64(best.Height) + 1; reply.Height != want {
+			t.Fatalf("unexpected height -- got %d, want %d",
+				reply.Height, want)
+		}
+		if want := best.Hash.String(); reply.PreviousHash != want {
+			t.Fatalf("unexpected previous block hash -- got %s, "+
+				"want %s", reply.PreviousHash, want)
+		}
+		return reply
+	}
+
+	request := &btcjson.TemplateRequest{
+		Capabilities: []string{"coinbasevalue"},
+		Rules:        []string{"segwit"},
+	}
+	result, err := handleGetBlockTemplate(s,
+		btcjson.NewGetBlockTemplateCmd(request), nil)
+	if err != nil {
+		t.Fatalf("handleGetBlockTemplate: unexpected error: %v", err)
+	}
+	reply := checkTemplate(result)
+
+	// Wait for the template to become stale with a long poll request and
+	// connect a new block.
+	type longPollResult struct {
+		result interface{}
+		err    error
+	}
+	closeChan := make(chan struct{})
+	defer close(closeChan)
+	longPollResults := make(chan longPollResult, 1)
+	go func() {
+		longPollRequest := *request
+		longPollRequest.LongPollID = reply.LongPollID
+		result, err := handleGetBlockTemplate(s,
+			btcjson.NewGetBlockTemplateCmd(&longPollRequest), closeChan)
+		longPollResults <- longPollResult{result, err}
+	}()
+	blocks := addTestBlocks(t, chain, &params, time.Now(), 1)
+	s.gbtWorkState.NotifyBlockConnected(blocks[0].Hash())
+
+	select {
+	case res := <-longPollResults:
+		if res.err != nil {
+			t.Fatalf("long poll: unexpected error: %v", res.err)
+		}
+		longPollReply := checkTemplate(res.result)
+		if longPollReply.Height != reply.Height+1 {
+			t.Fatalf("unexpected long poll height -- got %d, want %d",
+				longPollReply.Height, reply.Height+1)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for long poll result")
+	}
+}
+
+// TestGetBlockTemplatePayoutRoundRobin ensures the coinbase of getblocktemplate
+// results moves on to the next mining address for each block connected to the
+// main chain with the round robin payout rotation, regardless of how many
+// templates are generated.
+func TestGetBlockTemplatePayoutRoundRobin(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	params := chaincfg.RegressionNetParams
+	addrs := make([]btcutil.Address, 3)
+	pkScripts := make([][]byte, len(addrs))
+	for i := range addrs {
+		addr, err := btcutil.NewAddressPubKeyHash(
+			bytes.Repeat([]byte{byte(i + 1)}, 20), &params)
+		if err != nil {
+			t.Fatalf("unable to create address: %v", err)
+		}
+		addrs[i] = addr
+		pkScripts[i], err = txscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Fatalf("unable to create script: %v", err)
+		}
+	}
+
+	origCfg := cfg
+	cfg = &config{RegressionTest: true, miningAddrs: addrs}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	timeSource := blockchain.NewMedianTime()
+	chain, _ := newTestChain(t, &params)
+	addTestBlocks(t, chain, &params, time.Now().Add(-time.Minute*10), 1)
+
+	payouts := mining.NewPayoutAddrs(addrs, mining.PayoutRoundRobin)
+	chain.Subscribe(payouts.HandleBlockchainNotification)
+	policy := mining.Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight - 4000,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize - 1000,
+	}
+	s := &rpcServer{
+		cfg: rpcserverConfig{
+			Chain:       chain,
+			ChainParams: &params,
+			SyncMgr:     currentSyncManager{},
+			Generator: mining.NewBlkTmplGenerator(&policy, &params,
+				&testTxSource{}, chain, timeSource, nil, nil),
+			MiningPayouts: payouts,
+		},
+		gbtWorkState: newGbtWorkState(timeSource),
+	}
+
+	// checkPayout ensures the coinbase of a block template pays to the
+	// mining address at the passed index.
+	checkPayout := func(want int) {
+		t.Helper()
+		request := &btcjson.TemplateRequest{
+			Capabilities: []string{"coinbasetxn"},
+			Rules:        []string{"segwit"},
+		}
+		result, err := handleGetBlockTemplate(s,
+			btcjson.NewGetBlockTemplateCmd(request), nil)
+		if err != nil {
+			t.Fatalf("handleGetBlockTemplate: unexpected error: %v",
+				err)
+		}
+		reply := result.(*btcjson.GetBlockTemplateResult)
+		if reply.CoinbaseTxn == nil {
+			t.Fatal("block template does not have a coinbase")
+		}
+		serialized, err := hex.DecodeString(reply.CoinbaseTxn.Data)
+		if err != nil {
+			t.Fatalf("unable to decode coinbase: %v", err)
+		}
+		var coinbase wire.MsgTx
+		err = coinbase.Deserialize(bytes.NewReader(serialized))
+		if err != nil {
+			t.Fatalf("unable to deserialize coinbase: %v", err)
+		}
+		pkScript := coinbase.TxOut[0].PkScript
+		if !bytes.Equal(pkScript, pkScripts[want]) {
+			t.Fatalf("block template at height %d pays to %x, want "+
+				"address %d", reply.Height, pkScript, want)
+		}
+	}
+
+	checkPayout(0)
+	checkPayout(0)
+
+	// The payout address must only move on once per connected block, no
+	// matter how many templates were generated in between.
+	addTestBlocks(t, chain, &params, time.Now().Add(-time.Minute*5), 1)
+	checkPayout(1)
+	addTestBlocks(t, chain, &params, time.Now().Add(-time.Minute*4), 2)
+	checkPayout(0)
+}
+
+// TestGetBlockTemplateLongPollMempool ensures the long poll ID of block
+// templates changes once transactions that materially increase the fees of the
+// template are added to the memory pool and that waiting long poll clients are
+// released no sooner than gbtMempoolDebounce after the template was generated.
+func TestGetBlockTemplateLongPollMempool(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	cfg = &config{RegressionTest: true}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	params := chaincfg.RegressionNetParams
+	timeSource := blockchain.NewMedianTime()
+	chain, _ := newTestChain(t, &params)
+
+	policy := mining.Policy{
+		BlockMaxWeight: blockchain.MaxBlockWeight - 4000,
+		BlockMaxSize:   blockchain.MaxBlockBaseSize - 1000,
+	}
+	s := &rpcServer{
+		cfg: rpcserverConfig{
+			Chain:       chain,
+			ChainParams: &params,
+			SyncMgr:     currentSyncManager{},
+			Generator: mining.NewBlkTmplGenerator(&policy, &params,
+				&testTxSource{}, chain, timeSource, nil, nil),
+		},
+		gbtWorkState: newGbtWorkState(timeSource),
+	}
+
+	request := &btcjson.TemplateRequest{
+		Capabilities: []string{"coinbasevalue"},
+		Rules:        []string{"segwit"},
+	}
+	generated := time.Now()
+	result, err := handleGetBlockTemplate(s,
+		btcjson.NewGetBlockTemplateCmd(request), nil)
+	if err != nil {
+		t.Fatalf("handleGetBlockTemplate: unexpected error: %v", err)
+	}
+	reply := result.(*btcjson.GetBlockTemplateResult)
+	if want := chain.BestSnapshot().Hash.String() + "-0"; reply.LongPollID != want {
+		t.Fatalf("unexpected long poll ID -- got %s, want %s",
+			reply.LongPollID, want)
+	}
+
+	// Wait for the template to become stale with a long poll request and
+	// add a transaction which does not pay a fee and one which pays just
+	// under the minimum material fee increase followed by one that reaches
+	// it to the memory pool.
+	type longPollResult struct {
+		result interface{}
+		err    error
+	}
+	closeChan := make(chan struct{})
+	defer close(closeChan)
+	longPollResults := make(chan longPollResult, 1)
+	go func() {
+		longPollRequest := *request
+		longPollRequest.LongPollID = reply.LongPollID
+		result, err := handleGetBlockTemplate(s,
+			btcjson.NewGetBlockTemplateCmd(&longPollRequest), closeChan)
+		longPollResults <- longPollResult{result, err}
+	}()
+	s.gbtWorkState.NotifyMempoolTx(0, 0)
+	s.gbtWorkState.NotifyMempoolTx(gbtMinFeeIncrease-1, 1000)
+	for {
+		s.gbtWorkState.Lock()
+		mempoolUpdates := s.gbtWorkState.mempoolUpdates
+		s.gbtWorkState.Unlock()
+		if mempoolUpdates == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-longPollResults:
+		t.Fatal("long poll released by transactions below the " +
+			"minimum fee increase")
+	case <-time.After(gbtMempoolDebounce * 2):
+	}
+	s.gbtWorkState.NotifyMempoolTx(1, 1000)
+
+	select {
+	case res := <-longPollResults:
+		if res.err != nil {
+			t.Fatalf("long poll: unexpected error: %v", res.err)
+		}
+		elapsed := time.Since(generated)
+		if elapsed < gbtMempoolDebounce {
+			t.Fatalf("long poll released after %v, before the "+
+				"minimum of %v", elapsed, gbtMempoolDebounce)
+		}
+		longPollReply := res.result.(*btcjson.GetBlockTemplateResult)
+		want := chain.BestSnapshot().Hash.String() + "-3"
+		if longPollReply.LongPollID != want {
+			t.Fatalf("unexpected long poll ID -- got %s, want %s",
+				longPollReply.LongPollID, want)
+		}
+		if longPollReply.SubmitOld == nil || !*longPollReply.SubmitOld {
+			t.Fatal("expected work on the old template to remain valid")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for long poll result")
+	}
+}
+
+// currentSyncManager is an rpcserverSyncManager that only reports the chain is
+// current.
+type currentSyncManager struct {
+	rpcserverSyncManager
+}
+
+func (currentSyncManager) IsCurrent() bool { return true }
+
+// TestGbtTipDebounce ensures changes to the best chain tip within the debounce
+// window are coalesced so long poll clients only rebuild their block template
+// once.
+func TestGbtTipDebounce(t *testing.T) {
+	state := newGbtWorkState(blockchain.NewMedianTime())
+
+	// Simulate a long poll client that rebuilds its block template each
+	// time it is notified the template is stale and then waits for the
+	// template built against the new tip to become stale.
+	var tipMtx sync.Mutex
+	tip := chainhash.Hash{0x00}
+	rebuilds := make(chan chainhash.Hash, 10)
+	quit := make(chan struct{})
+	defer close(quit)
+	state.Lock()
+	c := state.templateUpdateChan(&tip, 0)
+	state.Unlock()
+	go func() {
+		for {
+			select {
+			case <-c:
+			case <-quit:
+				return
+			}
+
+			tipMtx.Lock()
+			rebuildTip := tip
+			tipMtx.Unlock()
+			state.Lock()
+			c = state.templateUpdateChan(&rebuildTip, 0)
+			state.Unlock()
+			rebuilds <- rebuildTip
+		}
+	}()
+
+	// Connect three blocks in quick succession.
+	for i := byte(1); i <= 3; i++ {
+		tipMtx.Lock()
+		tip = chainhash.Hash{i}
+		tipMtx.Unlock()
+		state.NotifyBlockConnected(&chainhash.Hash{i})
+	}
+
+	// Ensure only a single rebuild against the final tip occurs.
+	select {
+	case rebuildTip := <-rebuilds:
+		if rebuildTip != (chainhash.Hash{3}) {
+			t.Fatalf("template rebuilt against %v, want %v",
+				rebuildTip, chainhash.Hash{3})
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for template rebuild")
+	}
+	select {
+	case rebuildTip := <-rebuilds:
+		t.Fatalf("unexpected additional template rebuild against %v",
+			rebuildTip)
+	case <-time.After(gbtTipDebounce * 3):
+	}
+}
+
+// idleConnManager is an rpcserverConnManager without any connected peers.
+type idleConnManager struct {
+	rpcserverConnManager
+}
+
+func (idleConnManager) ConnectedCount() int32 { return 0 }
+
+// TestClockSkewWarning ensures getnetworkinfo warns when the median time of
+// peers differs from the local clock by more than the configured maximum clock
+// skew, including skews too large for the local clock to be adjusted by.
+func TestClockSkewWarning(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	defer func() {
+		cfg = origCfg
+	}()
+
+	tests := []struct {
+		name         string
+		maxClockSkew time.Duration
+		offset       time.Duration
+		wantWarning  bool
+	}{
+		{"within threshold", time.Minute * 5, time.Minute * 4, false},
+		{"ahead", time.Minute * 5, time.Minute * 10, true},
+		{"behind", time.Minute * 5, -time.Minute * 10, true},
+		{"beyond adjustment", time.Minute * 5, time.Hour * 2, true},
+		{"disabled", 0, time.Hour * 2, false},
+	}
+	for _, test := range tests {
+		cfg = &config{MaxClockSkew: test.maxClockSkew}
+
+		// Add enough time samples with the offset for the median to be
+		// calculated.
+		timeSource := blockchain.NewMedianTime()
+		for i := 0; i < 5; i++ {
+			timeSource.AddTimeSample(strconv.Itoa(i),
+				time.Now().Add(test.offset))
+		}
+		s := &rpcServer{cfg: rpcserverConfig{
+			TimeSource: timeSource,
+			ConnMgr:    idleConnManager{},
+		}}
+
+		result, err := handleGetNetworkInfo(s, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		warnings := result.(*btcjson.GetNetworkInfoResult).Warnings
+		if gotWarning := warnings != ""; gotWarning != test.wantWarning {
+			t.Fatalf("%s: got warning %q, want warning %v",
+				test.name, warnings, test.wantWarning)
+		}
+	}
+}
+
+// TestSendRawTxFeeLimit ensures the fee setting of sendrawtransaction commands
+// is translated into the fee limit of the memory pool.
+func TestSendRawTxFeeLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		feeSetting    *btcjson.AllowHighFeesOrMaxFeeRate
+		allowHighFees bool
+		maxFeeRate    btcutil.Amount
+		wantErr       bool
+	}{{
+		name: "no fee setting",
+	}, {
+		name: "allowhighfees false",
+		feeSetting: &btcjson.AllowHighFeesOrMaxFeeRate{
+			Value: btcjson.Bool(false),
+		},
+	}, {
+		name: "allowhighfees true",
+		feeSetting: &btcjson.AllowHighFeesOrMaxFeeRate{
+			Value: btcjson.Bool(true),
+		},
+		allowHighFees: true,
+	}, {
+		name: "zero maxfeerate",
+		feeSetting: &btcjson.AllowHighFeesOrMaxFeeRate{
+			Value: btcjson.Int32(0),
+		},
+		allowHighFees: true,
+	}, {
+		name: "maxfeerate",
+		feeSetting: &btcjson.AllowHighFeesOrMaxFeeRate{
+			Value: btcjson.Int32(10000),
+		},
+		maxFeeRate: 10000,
+	}, {
+		name: "negative maxfeerate",
+		feeSetting: &btcjson.AllowHighFeesOrMaxFeeRate{
+			Value: btcjson.Int32(-1),
+		},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		allowHighFees, maxFeeRate, err := sendRawTxFeeLimit(test.feeSetting)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: did not receive expected error",
+					test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if allowHighFees != test.allowHighFees {
+			t.Errorf("%s: unexpected allow high fees -- got %v, "+
+				"want %v", test.name, allowHighFees,
+				test.allowHighFees)
+		}
+		if maxFeeRate != test.maxFeeRate {
+			t.Errorf("%s: unexpected max fee rate -- got %v, want "+
+				"%v", test.name, maxFeeRate, test.maxFeeRate)
+		}
+	}
+}
+
+// TestGetDifficultyRatio ensures difficulty ratios are rounded to 8 decimal
+// places and that bits which do not represent a positive target result in a
+// ratio of zero.
+func TestGetDifficultyRatio(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	tests := []struct {
+		name string
+		bits uint32
+		want float64
+	}{
+		{name: "pow limit", bits: params.PowLimitBits, want: 1},
+		{name: "rounded", bits: 0x1d00fffe, want: 1.00001526},
+		{name: "zero target", bits: 0, want: 0},
+		{name: "negative target", bits: 0x1d80ffff, want: 0},
+	}
+	for _, test := range tests {
+		got := getDifficultyRatio(test.bits, params)
+		if got != test.want {
+			t.Errorf("%s: unexpected difficulty ratio -- got %v, "+
+				"want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestTemplateMinFeeRate ensures the lowest fee rate of a block template skips
+// the coinbase and transactions without a fee and matches the fee rates the
+// memory pool records for transactions.
+func TestTemplateMinFeeRate(t *testing.T) {
+	// newTx returns a transaction with the passed number of outputs so the
+	// transactions differ in size.
+	newTx := func(numOutputs int) *wire.MsgTx {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.AddTxIn(&wire.TxIn{Sequence: wire.MaxTxInSequenceNum})
+		for i := 0; i < numOutputs; i++ {
+			tx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+		}
+		return tx
+	}
+	coinbase, freeTx, lowTx, highTx := newTx(1), newTx(1), newTx(2), newTx(3)
+
+	tests := []struct {
+		name string
+		txns []*wire.MsgTx
+		fees []int64
+		want int64
+	}{{
+		name: "coinbase only",
+		txns: []*wire.MsgTx{coinbase},
+		fees: []int64{-5000},
+		want: 0,
+	}, {
+		name: "only transactions without a fee",
+		txns: []*wire.MsgTx{coinbase, freeTx},
+		fees: []int64{0, 0},
+		want: 0,
+	}, {
+		name: "transaction without a fee skipped",
+		txns: []*wire.MsgTx{coinbase, freeTx, highTx, lowTx},
+		fees: []int64{-6000, 0, 5000, 1000},
+		want: mempool.CalcFeePerKB(btcutil.NewTx(lowTx), 1000),
+	}}
+	for _, test := range tests {
+		msgBlock := wire.NewMsgBlock(&wire.BlockHeader{})
+		for _, tx := range test.txns {
+			msgBlock.AddTransaction(tx)
+		}
+		template := &mining.BlockTemplate{
+			Block: msgBlock,
+			Fees:  test.fees,
+		}
+		if got := templateMinFeeRate(template); got != test.want {
+			t.Errorf("%s: unexpected min fee rate -- got %d, want %d",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestTemplateFeeIncrease ensures the increase of the fees collected by a block
+// template due to a new transaction only counts the part of its fee above the
+// lowest fee rate of the template once it is full, and that the threshold for
+// materially altering a template scales with its fees.
+func TestTemplateFeeIncrease(t *testing.T) {
+	tests := []struct {
+		name       string
+		fee        int64
+		feePerKB   int64
+		minFeeRate int64
+		full       bool
+		want       int64
+	}{{
+		name:       "not full",
+		fee:        500,
+		feePerKB:   1000,
+		minFeeRate: 2000,
+		want:       500,
+	}, {
+		name:       "not full without fees",
+		fee:        500,
+		feePerKB:   1000,
+		minFeeRate: 0,
+		want:       500,
+	}, {
+		name:       "full below minimum fee rate",
+		fee:        500,
+		feePerKB:   1000,
+		minFeeRate: 2000,
+		full:       true,
+		want:       0,
+	}, {
+		name:       "full at minimum fee rate",
+		fee:        2000,
+		feePerKB:   2000,
+		minFeeRate: 2000,
+		full:       true,
+		want:       0,
+	}, {
+		name:       "full above minimum fee rate",
+		fee:        4000,
+		feePerKB:   4000,
+		minFeeRate: 1000,
+		full:       true,
+		want:       3000,
+	}}
+	for _, test := range tests {
+		got := templateFeeIncrease(test.fee, test.feePerKB,
+			test.minFeeRate, test.full)
+		if got != test.want {
+			t.Errorf("%s: unexpected fee increase -- got %d, "+
+				"want %d", test.name, got, test.want)
+		}
+	}
+
+	if got := materialFeeIncrease(0); got != gbtMinFeeIncrease {
+		t.Errorf("unexpected threshold without fees -- got %d, want %d",
+			got, gbtMinFeeIncrease)
+	}
+	const templateFees = 100 * gbtMinFeeIncrease * 100
+	want := int64(templateFees * gbtFeeIncreasePercent / 100)
+	if got := materialFeeIncrease(templateFees); got != want {
+		t.Errorf("unexpected threshold -- got %d, want %d", got, want)
+	}
+}
@@ -80,6 +80,13 @@ var helpDescsEnUS = map[string]string{
 	"vin-scriptSig":   "The signature script used to redeem the origin transaction as a JSON object (non-coinbase txns only)",
 	"vin-txinwitness": "The witness used to redeem the input encoded as a string array of its items",
 	"vin-sequence":    "The script sequence number",
+	"vin-prevout":     "The output spent by this input as a JSON object (only when getblock verbosity=3)",
+
+	// PrevOutResult help.
+	"prevoutresult-generated":    "Whether or not the spent output was created by a coinbase transaction",
+	"prevoutresult-height":       "The height of the block that contains the spent output",
+	"prevoutresult-value":        "The amount of the spent output in BTC",
+	"prevoutresult-scriptPubKey": "The public key script of the spent output as a JSON object",
 
 	// ScriptPubKeyResult help.
 	"scriptpubkeyresult-asm":       "Disassembly of the script",
@@ -147,6 +154,32 @@ var helpDescsEnUS = map[string]string{
 	"getaddednodeinfo--condition1": "dns=true",
 	"getaddednodeinfo--result0":    "List of added peers",
 
+	// GetAddrManAddressResult help.
+	"getaddrmanaddressresult-address":     "The IP address of the node",
+	"getaddrmanaddressresult-port":        "The port of the node",
+	"getaddrmanaddressresult-services":    "The services the node was last known to offer",
+	"getaddrmanaddressresult-source":      "The IP address of the peer the address was learned from",
+	"getaddrmanaddressresult-time":        "Timestamp in seconds since epoch (Jan 1 1970 GMT) of when the node was last seen",
+	"getaddrmanaddressresult-tried":       "Whether the address is in a tried bucket as opposed to a new bucket",
+	"getaddrmanaddressresult-bad":         "Whether the address is considered bad and due to be evicted",
+	"getaddrmanaddressresult-attempts":    "The number of connection attempts since the last successful one",
+	"getaddrmanaddressresult-lastattempt": "Timestamp in seconds since epoch (Jan 1 1970 GMT) of the last connection attempt, or 0 if there has not been one",
+	"getaddrmanaddressresult-lastsuccess": "Timestamp in seconds since epoch (Jan 1 1970 GMT) of the last successful connection, or 0 if there has not been one",
+
+	// GetAddrManResult help.
+	"getaddrmanresult-new":               "The number of addresses in the new buckets",
+	"getaddrmanresult-tried":             "The number of addresses in the tried buckets",
+	"getaddrmanresult-total":             "The total number of known addresses",
+	"getaddrmanresult-newbucketsused":    "The number of new buckets holding at least one address",
+	"getaddrmanresult-newbucketstotal":   "The total number of new buckets",
+	"getaddrmanresult-triedbucketsused":  "The number of tried buckets holding at least one address",
+	"getaddrmanresult-triedbucketstotal": "The total number of tried buckets",
+	"getaddrmanresult-addresses":         "A random sample of the known addresses",
+
+	// GetAddrManCmd help.
+	"getaddrman--synopsis": "Returns statistics about the addresses known to the address manager along with a random sample of them.",
+	"getaddrman-count":     "The maximum number of known addresses to include in the sample",
+
 	// GetBestBlockResult help.
 	"getbestblockresult-hash":   "Hex-encoded bytes of the best block hash",
 	"getbestblockresult-height": "Height of the best block",
@@ -162,7 +195,7 @@ var helpDescsEnUS = map[string]string{
 	// GetBlockCmd help.
 	"getblock--synopsis":   "Returns information about a block given its hash.",
 	"getblock-hash":        "The hash of the block",
-	"getblock-verbosity":   "Specifies whether the block data should be returned as a hex-encoded string (0), as parsed data with a slice of TXIDs (1), or as parsed data with parsed transaction data (2) ",
+	"getblock-verbosity":   "Specifies whether the block data should be returned as a hex-encoded string (0), as parsed data with a slice of TXIDs (1), as parsed data with parsed transaction data (2), or as parsed data with parsed transaction data including the outputs spent by each input (3)",
 	"getblock--condition0": "verbosity=0",
 	"getblock--condition1": "verbosity=1",
 	"getblock--result0":    "Hex-encoded bytes of the serialized block",
@@ -183,9 +216,16 @@ var helpDescsEnUS = map[string]string{
 	"getblockchaininforesult-chainwork":            "The total cumulative work in the best chain",
 	"getblockchaininforesult-size_on_disk":         "The estimated size of the block and undo files on disk",
 	"getblockchaininforesult-initialblockdownload": "Estimate of whether this node is in Initial Block Download mode",
+	"getblockchaininforesult-reorgstats":           "Statistics about the reorganizations of the main chain since the daemon was started",
 	"getblockchaininforesult-softforks":            "The status of the super-majority soft-forks",
 	"getblockchaininforesult-unifiedsoftforks":     "The status of the super-majority soft-forks used by bitcoind on or after v0.19.0",
 
+	// ReorgStatsResult help.
+	"reorgstatsresult-count":     "The number of reorganizations",
+	"reorgstatsresult-maxdepth":  "The number of blocks disconnected by the deepest reorganization",
+	"reorgstatsresult-lastdepth": "The number of blocks disconnected by the most recent reorganization",
+	"reorgstatsresult-lasttime":  "The time of the most recent reorganization in seconds since 1 Jan 1970 GMT, or 0 if there hasn't been one",
+
 	// SoftForkDescription help.
 	"softforkdescription-reject":  "The current activation status of the softfork",
 	"softforkdescription-version": "The block version that signals enforcement of this softfork",
@@ -246,11 +286,12 @@ var helpDescsEnUS = map[string]string{
 	"getblockverboseresult-versionHex":        "The block version in hexadecimal",
 	"getblockverboseresult-merkleroot":        "Root hash of the merkle tree",
 	"getblockverboseresult-tx":                "The transaction hashes (only when verbosity=1)",
-	"getblockverboseresult-rawtx":             "The transactions as JSON objects (only when verbosity=2)",
+	"getblockverboseresult-rawtx":             "The transactions as JSON objects (only when verbosity=2 or verbosity=3)",
 	"getblockverboseresult-time":              "The block time in seconds since 1 Jan 1970 GMT",
 	"getblockverboseresult-nonce":             "The block nonce",
 	"getblockverboseresult-bits":              "The bits which represent the block difficulty",
 	"getblockverboseresult-difficulty":        "The proof-of-work difficulty as a multiple of the minimum difficulty",
+	"getblockverboseresult-nTx":               "The number of transactions in the block",
 	"getblockverboseresult-previousblockhash": "The hash of the previous block",
 	"getblockverboseresult-nextblockhash":     "The hash of the next block (only if there is one)",
 	"getblockverboseresult-strippedsize":      "The size of the block without witness data",
@@ -337,6 +378,7 @@ var helpDescsEnUS = map[string]string{
 	"getblocktemplateresult-reject-reason":              "Reason the proposal was invalid as-is (only applies to proposal responses)",
 	"getblocktemplateresult-default_witness_commitment": "The witness commitment itself. Will be populated if the block has witness data",
 	"getblocktemplateresult-weightlimit":                "The current limit on the max allowed weight of a block",
+	"getblocktemplateresult-rules":                      "Rule changes active for the block (csv, cltv, and !segwit when segwit is active)",
 
 	// GetBlockTemplateCmd help.
 	"getblocktemplate--synopsis": "Returns a JSON object with information necessary to construct a block to mine or accepts a proposal to validate.\n" +
@@ -422,8 +464,9 @@ var helpDescsEnUS = map[string]string{
 	"getmempoolinfo--synopsis": "Returns memory pool information",
 
 	// GetMempoolInfoResult help.
-	"getmempoolinforesult-bytes": "Size in bytes of the mempool",
-	"getmempoolinforesult-size":  "Number of transactions in the mempool",
+	"getmempoolinforesult-bytes":            "Size in bytes of the mempool",
+	"getmempoolinforesult-size":             "Number of transactions in the mempool",
+	"getmempoolinforesult-unbroadcastcount": "Number of locally submitted transactions in the mempool that have not been requested by any peers yet",
 
 	// GetMiningInfoResult help.
 	"getmininginforesult-blocks":             "Height of the latest best block",
@@ -456,6 +499,36 @@ var helpDescsEnUS = map[string]string{
 	"getnettotalsresult-totalbytessent": "Total bytes sent",
 	"getnettotalsresult-timemillis":     "Number of milliseconds since 1 Jan 1970 GMT",
 
+	// GetNetworkInfoCmd help.
+	"getnetworkinfo--synopsis": "Returns a JSON object containing various state info regarding P2P networking.",
+
+	// GetNetworkInfoResult help.
+	"getnetworkinforesult-version":         "The version of the server",
+	"getnetworkinforesult-subversion":      "The user agent the server advertises to peers",
+	"getnetworkinforesult-protocolversion": "The latest supported protocol version",
+	"getnetworkinforesult-localservices":   "The services the server advertises to peers as a hex-encoded bit field",
+	"getnetworkinforesult-localrelay":      "Whether or not transactions are requested from peers",
+	"getnetworkinforesult-timeoffset":      "The time offset",
+	"getnetworkinforesult-connections":     "The number of connected peers",
+	"getnetworkinforesult-networkactive":   "Whether or not networking is enabled",
+	"getnetworkinforesult-networks":        "Information about each network",
+	"getnetworkinforesult-relayfee":        "The minimum relay fee for non-free transactions in BTC/KB",
+	"getnetworkinforesult-incrementalfee":  "The minimum fee rate increase in BTC/KB for transactions that replace others",
+	"getnetworkinforesult-localaddresses":  "The local addresses the server is known by",
+	"getnetworkinforesult-warnings":        "Any network and blockchain warnings",
+
+	// NetworksResult help.
+	"networksresult-name":                        "The name of the network (ipv4, ipv6 or onion)",
+	"networksresult-limited":                     "Whether or not connections are limited to this network",
+	"networksresult-reachable":                   "Whether or not the network is reachable",
+	"networksresult-proxy":                       "The proxy used for the network, if any",
+	"networksresult-proxy_randomize_credentials": "Whether or not random credentials are used for the proxy",
+
+	// LocalAddressesResult help.
+	"localaddressesresult-address": "The local address",
+	"localaddressesresult-port":    "The local port",
+	"localaddressesresult-score":   "The relative score of the address",
+
 	// GetNodeAddressesResult help.
 	"getnodeaddressesresult-time":     "Timestamp in seconds since epoch (Jan 1 1970 GMT) keeping track of when the node was last seen",
 	"getnodeaddressesresult-services": "The services offered",
@@ -464,7 +537,7 @@ var helpDescsEnUS = map[string]string{
 
 	// GetNodeAddressesCmd help.
 	"getnodeaddresses--synopsis": "Return known addresses which can potentially be used to find new nodes in the network",
-	"getnodeaddresses-count":     "How many addresses to return. Limited to the smaller of 2500 or 23% of all known addresses",
+	"getnodeaddresses-count":     "How many addresses to return. Limited to the smaller of 1000 or 23% of all known addresses",
 	"getnodeaddresses--result0":  "List of node addresses",
 
 	// GetPeerInfoResult help.
@@ -493,6 +566,24 @@ var helpDescsEnUS = map[string]string{
 	// GetPeerInfoCmd help.
 	"getpeerinfo--synopsis": "Returns data about each connected network peer as an array of json objects.",
 
+	// GetOrphanTxsResult help.
+	"getorphantxsresult-txid":           "The hash of the transaction",
+	"getorphantxsresult-hash":           "The witness hash of the transaction",
+	"getorphantxsresult-size":           "Transaction size in bytes",
+	"getorphantxsresult-vsize":          "The virtual size of the transaction",
+	"getorphantxsresult-weight":         "The transaction's weight (between vsize*4-3 and vsize*4)",
+	"getorphantxsresult-time":           "Local time transaction entered the orphan pool in seconds since 1 Jan 1970 GMT",
+	"getorphantxsresult-expiration":     "Local time the transaction is evicted from the orphan pool in seconds since 1 Jan 1970 GMT",
+	"getorphantxsresult-from":           "The ID of the peer that relayed the transaction",
+	"getorphantxsresult-missingparents": "The hashes of the transactions with outputs spent by this transaction that are neither in the memory pool nor unspent in the main chain",
+
+	// GetOrphanTxsCmd help.
+	"getorphantxs--synopsis":   "Returns information about all of the transactions currently in the orphan pool.",
+	"getorphantxs-verbose":     "Returns an array of JSON objects when true or an array of transaction hashes when false",
+	"getorphantxs--condition0": "verbose=false",
+	"getorphantxs--condition1": "verbose=true",
+	"getorphantxs--result0":    "Array of transaction hashes",
+
 	// GetRawMempoolVerboseResult help.
 	"getrawmempoolverboseresult-size":             "Transaction size in bytes",
 	"getrawmempoolverboseresult-fee":              "Transaction fee in bitcoins",
@@ -533,6 +624,21 @@ var helpDescsEnUS = map[string]string{
 	"gettxout-vout":           "The index of the output",
 	"gettxout-includemempool": "Include the mempool when true",
 
+	// GetTxOutProofCmd help.
+	"gettxoutproof--synopsis": "Returns a hex-encoded proof that the provided transactions are included in a block.",
+	"gettxoutproof-txids":     "The hashes of the transactions to prove",
+	"gettxoutproof-blockhash": "The hash of the block containing the transactions (default: the block of the first transaction found via the transaction index)",
+	"gettxoutproof--result0":  "The hex-encoded serialized merkle block proving the transactions are included in the block",
+
+	// GetTxSpendingPrevOutCmd help.
+	"gettxspendingprevout--synopsis": "Returns the transactions in the memory pool that spend the provided outpoints, if any.",
+	"gettxspendingprevout-outputs":   "The outpoints to look up",
+
+	// GetTxSpendingPrevOutResult help.
+	"gettxspendingprevoutresult-txid":         "The hash of the transaction that contains the outpoint",
+	"gettxspendingprevoutresult-vout":         "The index of the outpoint",
+	"gettxspendingprevoutresult-spendingtxid": "The hash of the memory pool transaction spending the outpoint (omitted when the outpoint is not spent in the memory pool)",
+
 	// HelpCmd help.
 	"help--synopsis":   "Returns a list of all commands or help for a specified command.",
 	"help-command":     "The command to retrieve help for",
@@ -545,6 +651,11 @@ var helpDescsEnUS = map[string]string{
 	"ping--synopsis": "Queues a ping to be sent to each connected peer.\n" +
 		"Ping times are provided by getpeerinfo via the pingtime and pingwait fields.",
 
+	// ResendWalletTransactionsCmd help.
+	"resendwallettransactions--synopsis": "Immediately rebroadcasts the locally submitted transactions in the memory pool that have not been requested by any peers yet.\n" +
+		"Such transactions are also rebroadcast periodically as configured by the rebroadcastinterval option.",
+	"resendwallettransactions--result0": "The hashes of the rebroadcast transactions",
+
 	// SearchRawTransactionsCmd help.
 	"searchrawtransactions--synopsis": "Returns raw data for transactions involving the passed address.\n" +
 		"Returned transactions are pulled from both the database, and transactions currently in the mempool.\n" +
@@ -565,7 +676,7 @@ var helpDescsEnUS = map[string]string{
 	// SendRawTransactionCmd help.
 	"sendrawtransaction--synopsis":    "Submits the serialized, hex-encoded transaction to the local peer and relays it to the network.",
 	"sendrawtransaction-hextx":        "Serialized, hex-encoded signed transaction",
-	"sendrawtransaction-feesetting":   "Whether or not to allow insanely high fees in bitcoind < v0.19.0 or the max fee rate for bitcoind v0.19.0 and later (btcd does not yet implement this parameter, so it has no effect)",
+	"sendrawtransaction-feesetting":   "Whether or not to allow insanely high fees as in bitcoind < v0.19.0 or the max fee rate in satoshi/kB as in bitcoind v0.19.0 and later (0 to allow any fee rate)",
 	"sendrawtransaction--result0":     "The hash of the transaction",
 	"allowhighfeesormaxfeerate-value": "Either the boolean value for the allowhighfees parameter in bitcoind < v0.19.0 or the numerical value for the maxfeerate field in bitcoind v0.19.0 and later",
 
@@ -624,6 +735,11 @@ var helpDescsEnUS = map[string]string{
 	"verifymessage-message":   "The signed message",
 	"verifymessage--result0":  "Whether or not the signature verified",
 
+	// VerifyTxOutProofCmd help.
+	"verifytxoutproof--synopsis": "Verifies a proof returned by gettxoutproof and returns the transactions it commits to.",
+	"verifytxoutproof-proof":     "The hex-encoded proof returned by gettxoutproof",
+	"verifytxoutproof--result0":  "The hashes of the transactions the proof commits to, or an empty array when the proof is invalid",
+
 	// -------- Websocket-specific help --------
 
 	// Session help.
@@ -712,54 +828,61 @@ var helpDescsEnUS = map[string]string{
 // This information is used to generate the help.  Each result type must be a
 // pointer to the type (or nil to indicate no return value).
 var rpcResultTypes = map[string][]interface{}{
-	"addnode":                nil,
-	"createrawtransaction":   {(*string)(nil)},
-	"debuglevel":             {(*string)(nil), (*string)(nil)},
-	"decoderawtransaction":   {(*btcjson.TxRawDecodeResult)(nil)},
-	"decodescript":           {(*btcjson.DecodeScriptResult)(nil)},
-	"estimatefee":            {(*float64)(nil)},
-	"generate":               {(*[]string)(nil)},
-	"getaddednodeinfo":       {(*[]string)(nil), (*[]btcjson.GetAddedNodeInfoResult)(nil)},
-	"getbestblock":           {(*btcjson.GetBestBlockResult)(nil)},
-	"getbestblockhash":       {(*string)(nil)},
-	"getblock":               {(*string)(nil), (*btcjson.GetBlockVerboseResult)(nil)},
-	"getblockcount":          {(*int64)(nil)},
-	"getblockhash":           {(*string)(nil)},
-	"getblockheader":         {(*string)(nil), (*btcjson.GetBlockHeaderVerboseResult)(nil)},
-	"getblocktemplate":       {(*btcjson.GetBlockTemplateResult)(nil), (*string)(nil), nil},
-	"getblockchaininfo":      {(*btcjson.GetBlockChainInfoResult)(nil)},
-	"getcfilter":             {(*string)(nil)},
-	"getcfilterheader":       {(*string)(nil)},
-	"getconnectioncount":     {(*int32)(nil)},
-	"getcurrentnet":          {(*uint32)(nil)},
-	"getdifficulty":          {(*float64)(nil)},
-	"getgenerate":            {(*bool)(nil)},
-	"gethashespersec":        {(*float64)(nil)},
-	"getheaders":             {(*[]string)(nil)},
-	"getinfo":                {(*btcjson.InfoChainResult)(nil)},
-	"getmempoolinfo":         {(*btcjson.GetMempoolInfoResult)(nil)},
-	"getmininginfo":          {(*btcjson.GetMiningInfoResult)(nil)},
-	"getnettotals":           {(*btcjson.GetNetTotalsResult)(nil)},
-	"getnetworkhashps":       {(*int64)(nil)},
-	"getnodeaddresses":       {(*[]btcjson.GetNodeAddressesResult)(nil)},
-	"getpeerinfo":            {(*[]btcjson.GetPeerInfoResult)(nil)},
-	"getrawmempool":          {(*[]string)(nil), (*btcjson.GetRawMempoolVerboseResult)(nil)},
-	"getrawtransaction":      {(*string)(nil), (*btcjson.TxRawResult)(nil)},
-	"gettxout":               {(*btcjson.GetTxOutResult)(nil)},
-	"node":                   nil,
-	"help":                   {(*string)(nil), (*string)(nil)},
-	"ping":                   nil,
-	"searchrawtransactions":  {(*string)(nil), (*[]btcjson.SearchRawTransactionsResult)(nil)},
-	"sendrawtransaction":     {(*string)(nil)},
-	"setgenerate":            nil,
-	"signmessagewithprivkey": {(*string)(nil)},
-	"stop":                   {(*string)(nil)},
-	"submitblock":            {nil, (*string)(nil)},
-	"uptime":                 {(*int64)(nil)},
-	"validateaddress":        {(*btcjson.ValidateAddressChainResult)(nil)},
-	"verifychain":            {(*bool)(nil)},
-	"verifymessage":          {(*bool)(nil)},
-	"version":                {(*map[string]btcjson.VersionResult)(nil)},
+	"addnode":                  nil,
+	"createrawtransaction":     {(*string)(nil)},
+	"debuglevel":               {(*string)(nil), (*string)(nil)},
+	"decoderawtransaction":     {(*btcjson.TxRawDecodeResult)(nil)},
+	"decodescript":             {(*btcjson.DecodeScriptResult)(nil)},
+	"estimatefee":              {(*float64)(nil)},
+	"generate":                 {(*[]string)(nil)},
+	"getaddednodeinfo":         {(*[]string)(nil), (*[]btcjson.GetAddedNodeInfoResult)(nil)},
+	"getaddrman":               {(*btcjson.GetAddrManResult)(nil)},
+	"getbestblock":             {(*btcjson.GetBestBlockResult)(nil)},
+	"getbestblockhash":         {(*string)(nil)},
+	"getblock":                 {(*string)(nil), (*btcjson.GetBlockVerboseResult)(nil)},
+	"getblockcount":            {(*int64)(nil)},
+	"getblockhash":             {(*string)(nil)},
+	"getblockheader":           {(*string)(nil), (*btcjson.GetBlockHeaderVerboseResult)(nil)},
+	"getblocktemplate":         {(*btcjson.GetBlockTemplateResult)(nil), (*string)(nil), nil},
+	"getblockchaininfo":        {(*btcjson.GetBlockChainInfoResult)(nil)},
+	"getcfilter":               {(*string)(nil)},
+	"getcfilterheader":         {(*string)(nil)},
+	"getconnectioncount":       {(*int32)(nil)},
+	"getcurrentnet":            {(*uint32)(nil)},
+	"getdifficulty":            {(*float64)(nil)},
+	"getgenerate":              {(*bool)(nil)},
+	"gethashespersec":          {(*float64)(nil)},
+	"getheaders":               {(*[]string)(nil)},
+	"getinfo":                  {(*btcjson.InfoChainResult)(nil)},
+	"getmempoolinfo":           {(*btcjson.GetMempoolInfoResult)(nil)},
+	"getmininginfo":            {(*btcjson.GetMiningInfoResult)(nil)},
+	"getnettotals":             {(*btcjson.GetNetTotalsResult)(nil)},
+	"getnetworkhashps":         {(*int64)(nil)},
+	"getnetworkinfo":           {(*btcjson.GetNetworkInfoResult)(nil)},
+	"getnodeaddresses":         {(*[]btcjson.GetNodeAddressesResult)(nil)},
+	"getorphantxs":             {(*[]string)(nil), (*[]btcjson.GetOrphanTxsResult)(nil)},
+	"getpeerinfo":              {(*[]btcjson.GetPeerInfoResult)(nil)},
+	"getrawmempool":            {(*[]string)(nil), (*btcjson.GetRawMempoolVerboseResult)(nil)},
+	"getrawtransaction":        {(*string)(nil), (*btcjson.TxRawResult)(nil)},
+	"gettxout":                 {(*btcjson.GetTxOutResult)(nil)},
+	"gettxoutproof":            {(*string)(nil)},
+	"gettxspendingprevout":     {(*[]btcjson.GetTxSpendingPrevOutResult)(nil)},
+	"node":                     nil,
+	"help":                     {(*string)(nil), (*string)(nil)},
+	"ping":                     nil,
+	"resendwallettransactions": {(*[]string)(nil)},
+	"searchrawtransactions":    {(*string)(nil), (*[]btcjson.SearchRawTransactionsResult)(nil)},
+	"sendrawtransaction":       {(*string)(nil)},
+	"setgenerate":              nil,
+	"signmessagewithprivkey":   {(*string)(nil)},
+	"stop":                     {(*string)(nil)},
+	"submitblock":              {nil, (*string)(nil)},
+	"uptime":                   {(*int64)(nil)},
+	"validateaddress":          {(*btcjson.ValidateAddressChainResult)(nil)},
+	"verifychain":              {(*bool)(nil)},
+	"verifymessage":            {(*bool)(nil)},
+	"verifytxoutproof":         {(*[]string)(nil)},
+	"version":                  {(*map[string]btcjson.VersionResult)(nil)},
 
 	// Websocket commands.
 	"loadtxfilter":              nil,
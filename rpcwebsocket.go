@@ -139,6 +139,16 @@ type wsNotificationManager struct {
 	// Access channel for current number of connected clients.
 	numClients chan int
 
+	// reorgMtx protects the following fields which track the block
+	// notifications of a chain reorganization in progress that have not
+	// been queued yet.  The notifications of a reorganization are queued
+	// in chunks of at most reorgChunkSize notifications, and each chunk is
+	// only queued once the previous one was handled so long
+	// reorganizations don't flood the queue.
+	reorgMtx       sync.Mutex
+	reorgRemaining int
+	reorgNtfns     []interface{}
+
 	// Shutdown handling
 	wg   sync.WaitGroup
 	quit chan struct{}
@@ -199,29 +209,96 @@ func (m *wsNotificationManager) queueHandler() {
 	m.wg.Done()
 }
 
+// NotifyReorganization informs the notification manager that the main chain is
+// about to be reorganized as described by the passed data.  The block
+// disconnected and connected notifications that follow are queued in chunks.
+func (m *wsNotificationManager) NotifyReorganization(data *blockchain.ReorganizationNtfnsData) {
+	m.reorgMtx.Lock()
+	defer m.reorgMtx.Unlock()
+
+	// Queue the notifications left over from a previous reorganization
+	// that failed before all of its blocks were connected.
+	m.queueReorgChunk()
+
+	m.reorgRemaining = int(data.OldHeight-data.ForkHeight) +
+		int(data.NewHeight-data.ForkHeight)
+}
+
+// NotifyReorganizationAborted informs the notification manager that the
+// reorganization it was last informed about failed.  The block notifications
+// of the reorganization collected so far are queued and the ones that follow
+// are no longer treated as part of it.
+func (m *wsNotificationManager) NotifyReorganizationAborted() {
+	m.reorgMtx.Lock()
+	defer m.reorgMtx.Unlock()
+
+	m.queueReorgChunk()
+	m.reorgRemaining = 0
+}
+
 // NotifyBlockConnected passes a block newly-connected to the best chain
 // to the notification manager for block and transaction notification
 // processing.
 func (m *wsNotificationManager) NotifyBlockConnected(block *btcutil.Block) {
-	// As NotifyBlockConnected will be called by the block manager
-	// and the RPC server may no longer be running, use a select
-	// statement to unblock enqueuing the notification once the RPC
-	// server has begun shutting down.
-	select {
-	case m.queueNotification <- (*notificationBlockConnected)(block):
-	case <-m.quit:
-	}
+	m.queueBlockNotification((*notificationBlockConnected)(block))
 }
 
 // NotifyBlockDisconnected passes a block disconnected from the best chain
 // to the notification manager for block notification processing.
 func (m *wsNotificationManager) NotifyBlockDisconnected(block *btcutil.Block) {
-	// As NotifyBlockDisconnected will be called by the block manager
-	// and the RPC server may no longer be running, use a select
-	// statement to unblock enqueuing the notification once the RPC
-	// server has begun shutting down.
+	m.queueBlockNotification((*notificationBlockDisconnected)(block))
+}
+
+// queueBlockNotification queues the passed block connected or disconnected
+// notification for handling.  Notifications that are part of a chain
+// reorganization are collected until a chunk is complete.
+func (m *wsNotificationManager) queueBlockNotification(n interface{}) {
+	m.reorgMtx.Lock()
+	defer m.reorgMtx.Unlock()
+
+	if m.reorgRemaining == 0 {
+		// As this will be called by the block manager and the RPC
+		// server may no longer be running, use a select statement to
+		// unblock enqueuing the notification once the RPC server has
+		// begun shutting down.
+		select {
+		case m.queueNotification <- n:
+		case <-m.quit:
+		}
+		return
+	}
+
+	m.reorgNtfns = append(m.reorgNtfns, n)
+	m.reorgRemaining--
+	if len(m.reorgNtfns) >= m.server.cfg.ReorgChunkSize ||
+		m.reorgRemaining == 0 {
+
+		m.queueReorgChunk()
+	}
+}
+
+// queueReorgChunk queues the collected block notifications of a chain
+// reorganization for handling as a single chunk and waits until they have
+// been handled.
+//
+// This function MUST be called with the reorg lock held.
+func (m *wsNotificationManager) queueReorgChunk() {
+	if len(m.reorgNtfns) == 0 {
+		return
+	}
+
+	chunk := &notificationReorgChunk{
+		ntfns: m.reorgNtfns,
+		done:  make(chan struct{}),
+	}
+	m.reorgNtfns = nil
+	select {
+	case m.queueNotification <- chunk:
+	case <-m.quit:
+		return
+	}
 	select {
-	case m.queueNotification <- (*notificationBlockDisconnected)(block):
+	case <-chunk.done:
 	case <-m.quit:
 	}
 }
@@ -447,6 +524,10 @@ func (f *wsClientFilter) removeUnspentOutPoint(op *wire.OutPoint) {
 // Notification types
 type notificationBlockConnected btcutil.Block
 type notificationBlockDisconnected btcutil.Block
+type notificationReorgChunk struct {
+	ntfns []interface{}
+	done  chan struct{}
+}
 type notificationTxAcceptedByMempool struct {
 	isNew bool
 	tx    *btcutil.Tx
@@ -494,6 +575,41 @@ func (m *wsNotificationManager) notificationHandler() {
 	watchedOutPoints := make(map[wire.OutPoint]map[chan struct{}]*wsClient)
 	watchedAddrs := make(map[string]map[chan struct{}]*wsClient)
 
+	// handleBlockNtfn notifies the websocket clients interested in the
+	// passed block connected or disconnected notification.
+	handleBlockNtfn := func(n interface{}) {
+		switch n := n.(type) {
+		case *notificationBlockConnected:
+			block := (*btcutil.Block)(n)
+
+			// Skip iterating through all txs if no
+			// tx notification requests exist.
+			if len(watchedOutPoints) != 0 || len(watchedAddrs) != 0 {
+				for _, tx := range block.Transactions() {
+					m.notifyForTx(watchedOutPoints,
+						watchedAddrs, tx, block)
+				}
+			}
+
+			if len(blockNotifications) != 0 {
+				m.notifyBlockConnected(blockNotifications,
+					block)
+				m.notifyFilteredBlockConnected(blockNotifications,
+					block)
+			}
+
+		case *notificationBlockDisconnected:
+			block := (*btcutil.Block)(n)
+
+			if len(blockNotifications) != 0 {
+				m.notifyBlockDisconnected(blockNotifications,
+					block)
+				m.notifyFilteredBlockDisconnected(blockNotifications,
+					block)
+			}
+		}
+	}
+
 out:
 	for {
 		select {
@@ -503,34 +619,14 @@ out:
 				break out
 			}
 			switch n := n.(type) {
-			case *notificationBlockConnected:
-				block := (*btcutil.Block)(n)
-
-				// Skip iterating through all txs if no
-				// tx notification requests exist.
-				if len(watchedOutPoints) != 0 || len(watchedAddrs) != 0 {
-					for _, tx := range block.Transactions() {
-						m.notifyForTx(watchedOutPoints,
-							watchedAddrs, tx, block)
-					}
-				}
-
-				if len(blockNotifications) != 0 {
-					m.notifyBlockConnected(blockNotifications,
-						block)
-					m.notifyFilteredBlockConnected(blockNotifications,
-						block)
-				}
-
-			case *notificationBlockDisconnected:
-				block := (*btcutil.Block)(n)
+			case *notificationBlockConnected, *notificationBlockDisconnected:
+				handleBlockNtfn(n)
 
-				if len(blockNotifications) != 0 {
-					m.notifyBlockDisconnected(blockNotifications,
-						block)
-					m.notifyFilteredBlockDisconnected(blockNotifications,
-						block)
+			case *notificationReorgChunk:
+				for _, ntfn := range n.ntfns {
+					handleBlockNtfn(ntfn)
 				}
+				close(n.done)
 
 			case *notificationTxAcceptedByMempool:
 				if n.isNew && len(txNotifications) != 0 {
@@ -847,8 +943,9 @@ func (m *wsNotificationManager) notifyForNewTx(clients map[chan struct{}]*wsClie
 			}
 
 			net := m.server.cfg.ChainParams
-			rawTx, err := createTxRawResult(net, mtx, txHashStr, nil,
-				"", 0, 0)
+			rawTx, err := createTxRawResult(net,
+				m.server.cfg.ScriptCache, mtx, txHashStr, nil, "",
+				0, 0)
 			if err != nil {
 				return
 			}
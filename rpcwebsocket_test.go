@@ -0,0 +1,177 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// testReorgBlocks returns blocks for the passed number of heights after the
+// fork point of a chain reorganization that are unique to the passed branch.
+func testReorgBlocks(branch uint32, numBlocks int) []*btcutil.Block {
+	blocks := make([]*btcutil.Block, 0, numBlocks)
+	for i := 1; i <= numBlocks; i++ {
+		block := btcutil.NewBlock(&wire.MsgBlock{
+			Header: wire.BlockHeader{
+				Nonce:     branch,
+				Timestamp: time.Unix(int64(i), 0),
+			},
+		})
+		block.SetHeight(int32(i))
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// nextBlockNtfn returns the method and block hash of the next block connected
+// or disconnected notification sent to the passed websocket client.  The
+// filtered block notifications that are also sent are ignored.
+func nextBlockNtfn(t *testing.T, wsc *wsClient) string {
+	t.Helper()
+
+	for {
+		select {
+		case marshalled := <-wsc.ntfnChan:
+			var ntfn struct {
+				Method string            `json:"method"`
+				Params []json.RawMessage `json:"params"`
+			}
+			if err := json.Unmarshal(marshalled, &ntfn); err != nil {
+				t.Fatalf("unable to unmarshal notification: %v", err)
+			}
+			if ntfn.Method != btcjson.BlockDisconnectedNtfnMethod &&
+				ntfn.Method != btcjson.BlockConnectedNtfnMethod {
+
+				continue
+			}
+			var hash string
+			if err := json.Unmarshal(ntfn.Params[0], &hash); err != nil {
+				t.Fatalf("unable to unmarshal block hash: %v", err)
+			}
+			return ntfn.Method + " " + hash
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("timeout waiting for block notification")
+		}
+	}
+}
+
+// TestReorgNotifications ensures websocket clients registered for block
+// notifications receive every disconnected block of a chain reorganization
+// before any of the connected blocks, each in order, when the notifications
+// are queued in chunks.
+func TestReorgNotifications(t *testing.T) {
+	const reorgDepth = 10
+	s := &rpcServer{cfg: rpcserverConfig{ReorgChunkSize: 3}}
+	m := newWsNotificationManager(s)
+	m.Start()
+	defer func() {
+		m.Shutdown()
+		m.WaitForShutdown()
+	}()
+
+	wsc := &wsClient{
+		quit:     make(chan struct{}),
+		ntfnChan: make(chan []byte, 4*reorgDepth),
+	}
+	m.RegisterBlockUpdates(wsc)
+
+	oldBlocks := testReorgBlocks(1, reorgDepth)
+	newBlocks := testReorgBlocks(2, reorgDepth)
+
+	// Reorganize the chain by disconnecting the old blocks from the tip
+	// back to the fork point and then connecting the new ones.
+	m.NotifyReorganization(&blockchain.ReorganizationNtfnsData{
+		OldHash:    *oldBlocks[reorgDepth-1].Hash(),
+		OldHeight:  reorgDepth,
+		NewHash:    *newBlocks[reorgDepth-1].Hash(),
+		NewHeight:  reorgDepth,
+		ForkHeight: 0,
+	})
+	var want []string
+	for i := reorgDepth - 1; i >= 0; i-- {
+		m.NotifyBlockDisconnected(oldBlocks[i])
+		want = append(want, btcjson.BlockDisconnectedNtfnMethod+" "+
+			oldBlocks[i].Hash().String())
+	}
+	for _, block := range newBlocks {
+		m.NotifyBlockConnected(block)
+		want = append(want, btcjson.BlockConnectedNtfnMethod+" "+
+			block.Hash().String())
+	}
+
+	// Ensure the block notifications are received in order.
+	for _, wantNtfn := range want {
+		if got := nextBlockNtfn(t, wsc); got != wantNtfn {
+			t.Fatalf("unexpected notification - got %q, want %q",
+				got, wantNtfn)
+		}
+	}
+}
+
+// TestAbortedReorgNotifications ensures the block notifications of a chain
+// reorganization that fails part way through are delivered to websocket
+// clients and that the block notifications which follow are delivered right
+// away instead of being collected as part of the failed reorganization.
+func TestAbortedReorgNotifications(t *testing.T) {
+	const reorgDepth = 10
+	s := &rpcServer{cfg: rpcserverConfig{ReorgChunkSize: 3}}
+	m := newWsNotificationManager(s)
+	m.Start()
+	defer func() {
+		m.Shutdown()
+		m.WaitForShutdown()
+	}()
+
+	wsc := &wsClient{
+		quit:     make(chan struct{}),
+		ntfnChan: make(chan []byte, 4*reorgDepth),
+	}
+	m.RegisterBlockUpdates(wsc)
+
+	oldBlocks := testReorgBlocks(1, reorgDepth)
+	newBlocks := testReorgBlocks(2, reorgDepth)
+
+	// Start reorganizing the chain, but abort after disconnecting a few
+	// blocks which don't make up a complete chunk.
+	const numDisconnected = 4
+	m.NotifyReorganization(&blockchain.ReorganizationNtfnsData{
+		OldHash:    *oldBlocks[reorgDepth-1].Hash(),
+		OldHeight:  reorgDepth,
+		NewHash:    *newBlocks[reorgDepth-1].Hash(),
+		NewHeight:  reorgDepth,
+		ForkHeight: 0,
+	})
+	var want []string
+	for i := reorgDepth - 1; i >= reorgDepth-numDisconnected; i-- {
+		m.NotifyBlockDisconnected(oldBlocks[i])
+		want = append(want, btcjson.BlockDisconnectedNtfnMethod+" "+
+			oldBlocks[i].Hash().String())
+	}
+	m.NotifyReorganizationAborted()
+	for _, wantNtfn := range want {
+		if got := nextBlockNtfn(t, wsc); got != wantNtfn {
+			t.Fatalf("unexpected notification - got %q, want %q",
+				got, wantNtfn)
+		}
+	}
+
+	// Ensure a block connected after the failed reorganization is
+	// delivered without waiting for the rest of the reorganization.
+	block := oldBlocks[reorgDepth-numDisconnected]
+	m.NotifyBlockConnected(block)
+	wantNtfn := btcjson.BlockConnectedNtfnMethod + " " + block.Hash().String()
+	if got := nextBlockNtfn(t, wsc); got != wantNtfn {
+		t.Fatalf("unexpected notification - got %q, want %q", got,
+			wantNtfn)
+	}
+}
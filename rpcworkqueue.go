@@ -0,0 +1,116 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// rpcWorkQueue bounds the number of standard RPC requests that are processed
+// concurrently.  Requests are handed to one of a fixed number of workers and,
+// when all of the workers are busy, wait in a queue of limited depth.  Requests
+// that arrive while the queue is full are immediately rejected with a server
+// busy error so that slow commands can't cause an unbounded backlog.
+type rpcWorkQueue struct {
+	// workers limits the number of requests that are processed at once.
+	workers semaphore
+
+	// slots limits the total number of requests that are either being
+	// processed or waiting for a worker.
+	slots semaphore
+
+	// timeout is the maximum amount of time a request may wait for and
+	// be processed by a worker.  A value of zero disables the timeout.
+	timeout time.Duration
+}
+
+// newRPCWorkQueue returns a new work queue with the provided number of workers,
+// queue depth, and per-request timeout.
+func newRPCWorkQueue(numWorkers, queueDepth int, timeout time.Duration) *rpcWorkQueue {
+	return &rpcWorkQueue{
+		workers: makeSemaphore(numWorkers),
+		slots:   makeSemaphore(numWorkers + queueDepth),
+		timeout: timeout,
+	}
+}
+
+// errRPCServerBusy is the error returned when a request is rejected because
+// the work queue is full.
+var errRPCServerBusy = &btcjson.RPCError{
+	Code:    btcjson.ErrRPCMisc,
+	Message: "Server busy: work queue depth exceeded",
+}
+
+// rpcRequestTimeoutError returns the error used when a request is not completed
+// within the passed timeout.
+func rpcRequestTimeoutError(timeout time.Duration) *btcjson.RPCError {
+	return &btcjson.RPCError{
+		Code:    btcjson.ErrRPCMisc,
+		Message: fmt.Sprintf("Request timed out after %v", timeout),
+	}
+}
+
+// run queues the passed handler for execution by a worker and returns its
+// result.  An error is returned without running the handler when the queue is
+// full.  When the request is not completed before the timeout expires or the
+// passed close channel is closed, the channel provided to the handler is closed
+// and an error is returned without waiting for the handler to finish.  The
+// worker is not released until the handler returns.
+//
+// This function is safe for concurrent access.
+func (q *rpcWorkQueue) run(closeChan <-chan struct{},
+	handler func(<-chan struct{}) (interface{}, error)) (interface{}, error) {
+
+	// Reject the request outright when there is no room in the queue.
+	select {
+	case q.slots <- struct{}{}:
+	default:
+		return nil, errRPCServerBusy
+	}
+
+	var timeoutChan <-chan time.Time
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	// Wait for a worker to become available.
+	select {
+	case q.workers <- struct{}{}:
+	case <-closeChan:
+		q.slots.release()
+		return nil, ErrClientQuit
+	case <-timeoutChan:
+		q.slots.release()
+		return nil, rpcRequestTimeoutError(q.timeout)
+	}
+
+	var result interface{}
+	var err error
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer q.slots.release()
+		defer q.workers.release()
+
+		result, err = handler(quit)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return result, err
+	case <-closeChan:
+		close(quit)
+		return nil, ErrClientQuit
+	case <-timeoutChan:
+		close(quit)
+		return nil, rpcRequestTimeoutError(q.timeout)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRPCWorkQueueBusy ensures requests that exceed the number of workers plus
+// the queue depth are rejected as busy while the others are processed.
+func TestRPCWorkQueueBusy(t *testing.T) {
+	t.Parallel()
+
+	const numWorkers, queueDepth = 2, 3
+	q := newRPCWorkQueue(numWorkers, queueDepth, 0)
+
+	// Start enough slow requests to occupy every worker and fill the
+	// queue.
+	release := make(chan struct{})
+	started := make(chan struct{}, numWorkers+queueDepth)
+	results := make(chan error, numWorkers+queueDepth)
+	slowHandler := func(<-chan struct{}) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return nil, nil
+	}
+	for i := 0; i < numWorkers+queueDepth; i++ {
+		go func() {
+			_, err := q.run(nil, slowHandler)
+			results <- err
+		}()
+	}
+
+	// Wait for the workers to be busy and the remaining requests to be
+	// queued.
+	for i := 0; i < numWorkers; i++ {
+		<-started
+	}
+	for len(q.slots) != numWorkers+queueDepth {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Every additional request must be rejected without running the
+	// handler.
+	for i := 0; i < 3; i++ {
+		_, err := q.run(nil, func(<-chan struct{}) (interface{}, error) {
+			t.Fatalf("handler unexpectedly ran for excess request")
+			return nil, nil
+		})
+		if err != errRPCServerBusy {
+			t.Fatalf("unexpected error for excess request -- got %v, "+
+				"want %v", err, errRPCServerBusy)
+		}
+	}
+
+	// All of the accepted requests must complete once the handlers are
+	// released.
+	close(release)
+	for i := 0; i < numWorkers+queueDepth; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("unexpected error for queued request: %v", err)
+		}
+	}
+
+	// The queue must accept requests again now that it has drained.
+	result, err := q.run(nil, func(<-chan struct{}) (interface{}, error) {
+		return true, nil
+	})
+	if err != nil || result != true {
+		t.Fatalf("unexpected result after queue drained -- got (%v, %v)",
+			result, err)
+	}
+}
+
+// TestRPCWorkQueueTimeout ensures requests that take longer than the timeout
+// return an error and signal the handler to quit.
+func TestRPCWorkQueueTimeout(t *testing.T) {
+	t.Parallel()
+
+	q := newRPCWorkQueue(1, 0, 10*time.Millisecond)
+	handlerQuit := make(chan struct{})
+	_, err := q.run(nil, func(quit <-chan struct{}) (interface{}, error) {
+		<-quit
+		close(handlerQuit)
+		return nil, nil
+	})
+	if err == nil {
+		t.Fatal("slow request did not time out")
+	}
+
+	select {
+	case <-handlerQuit:
+	case <-time.After(time.Second):
+		t.Fatal("handler was not signalled to quit after timeout")
+	}
+}
@@ -57,6 +57,22 @@ const (
 	// retries when connecting to persistent peers.  It is adjusted by the
 	// number of retries such that there is a retry backoff.
 	connectionRetryInterval = time.Second * 5
+
+	// selfAdvertiseInterval is the amount of time between advertisements of
+	// the local address to all connected peers so addresses of the node
+	// keep propagating through the network while it is running.
+	selfAdvertiseInterval = time.Hour * 24
+
+	// knownAddressTimeout is the amount of time an address sent to or
+	// received from a peer is considered known to it.  Known addresses are
+	// not relayed to the peer again until the timeout expires.
+	knownAddressTimeout = time.Hour * 24
+
+	// maxCmpctBlockDepth is the maximum number of blocks below the best
+	// chain tip a block may be to be served as a compact block.  Deeper
+	// blocks are requested during sync, so they are served as full blocks
+	// per BIP0152.
+	maxCmpctBlockDepth = 10
 )
 
 var (
@@ -123,14 +139,6 @@ type broadcastMsg struct {
 	excludePeers []*serverPeer
 }
 
-// broadcastInventoryAdd is a type used to declare that the InvVect it contains
-// needs to be added to the rebroadcast map
-type broadcastInventoryAdd relayMsg
-
-// broadcastInventoryDel is a type used to declare that the InvVect it contains
-// needs to be removed from the rebroadcast map
-type broadcastInventoryDel *wire.InvVect
-
 // relayMsg packages an inventory vector along with the newly discovered
 // inventory so the relay has access to that information.
 type relayMsg struct {
@@ -205,30 +213,43 @@ type server struct {
 	shutdownSched int32
 	startupTime   int64
 
-	chainParams          *chaincfg.Params
-	addrManager          *addrmgr.AddrManager
-	connManager          *connmgr.ConnManager
-	sigCache             *txscript.SigCache
-	hashCache            *txscript.HashCache
-	rpcServer            *rpcServer
-	syncManager          *netsync.SyncManager
-	chain                *blockchain.BlockChain
-	txMemPool            *mempool.TxPool
-	cpuMiner             *cpuminer.CPUMiner
-	modifyRebroadcastInv chan interface{}
-	newPeers             chan *serverPeer
-	donePeers            chan *serverPeer
-	banPeers             chan *serverPeer
-	query                chan interface{}
-	relayInv             chan relayMsg
-	broadcast            chan broadcastMsg
-	peerHeightsUpdate    chan updatePeerHeightsMsg
-	wg                   sync.WaitGroup
-	quit                 chan struct{}
-	nat                  NAT
-	db                   database.DB
-	timeSource           blockchain.MedianTimeSource
-	services             wire.ServiceFlag
+	chainParams       *chaincfg.Params
+	addrManager       *addrmgr.AddrManager
+	connManager       *connmgr.ConnManager
+	sigCache          *txscript.SigCache
+	hashCache         *txscript.HashCache
+	scriptCache       *txscript.ScriptClassCache
+	rpcServer         *rpcServer
+	syncManager       *netsync.SyncManager
+	chain             *blockchain.BlockChain
+	txMemPool         *mempool.TxPool
+	cpuMiner          *cpuminer.CPUMiner
+	newPeers          chan *serverPeer
+	donePeers         chan *serverPeer
+	banPeers          chan *serverPeer
+	query             chan interface{}
+	relayInv          chan relayMsg
+	broadcast         chan broadcastMsg
+	peerHeightsUpdate chan updatePeerHeightsMsg
+	wg                sync.WaitGroup
+	quit              chan struct{}
+	nat               NAT
+	db                database.DB
+	timeSource        blockchain.MedianTimeSource
+	services          wire.ServiceFlag
+
+	// rebroadcastInterval is the average amount of time between
+	// rebroadcasts of locally submitted transactions that have not been
+	// requested by any peers yet.
+	rebroadcastInterval time.Duration
+
+	// listenPort is the port the server accepts incoming connections on.
+	// It is advertised along with the addresses peers see the server at.
+	listenPort uint16
+
+	// txRelayServices are the services a peer must advertise in order for
+	// transactions to be relayed to it.
+	txRelayServices wire.ServiceFlag
 
 	// The following fields are used for optional indexes.  They will be nil
 	// if the associated index is not enabled.  These fields are set during
@@ -272,9 +293,11 @@ type serverPeer struct {
 	disableRelayTx bool
 	sentAddrs      bool
 	isWhitelisted  bool
+	noRelay        bool
 	filter         *bloom.Filter
 	addressesMtx   sync.RWMutex
-	knownAddresses map[string]struct{}
+	knownAddresses map[string]time.Time
+	addrsPruned    time.Time
 	banScore       connmgr.DynamicBanScore
 	quit           chan struct{}
 	// The following chans are used to sync blockmanager and server.
@@ -289,7 +312,7 @@ func newServerPeer(s *server, isPersistent bool) *serverPeer {
 		server:         s,
 		persistent:     isPersistent,
 		filter:         bloom.LoadFilter(nil),
-		knownAddresses: make(map[string]struct{}),
+		knownAddresses: make(map[string]time.Time),
 		quit:           make(chan struct{}),
 		txProcessed:    make(chan struct{}, 1),
 		blockProcessed: make(chan struct{}, 1),
@@ -304,21 +327,33 @@ func (sp *serverPeer) newestBlock() (*chainhash.Hash, int32, error) {
 }
 
 // addKnownAddresses adds the given addresses to the set of known addresses to
-// the peer to prevent sending duplicate addresses.
+// the peer to prevent sending duplicate addresses within the known address
+// timeout.  Addresses whose timeout expired are removed from the set at most
+// once per timeout period.
 func (sp *serverPeer) addKnownAddresses(addresses []*wire.NetAddress) {
+	now := time.Now()
 	sp.addressesMtx.Lock()
+	if now.Sub(sp.addrsPruned) >= knownAddressTimeout {
+		for key, lastSeen := range sp.knownAddresses {
+			if now.Sub(lastSeen) >= knownAddressTimeout {
+				delete(sp.knownAddresses, key)
+			}
+		}
+		sp.addrsPruned = now
+	}
 	for _, na := range addresses {
-		sp.knownAddresses[addrmgr.NetAddressKey(na)] = struct{}{}
+		sp.knownAddresses[addrmgr.NetAddressKey(na)] = now
 	}
 	sp.addressesMtx.Unlock()
 }
 
-// addressKnown true if the given address is already known to the peer.
+// addressKnown true if the given address was sent to or received from the peer
+// within the known address timeout.
 func (sp *serverPeer) addressKnown(na *wire.NetAddress) bool {
 	sp.addressesMtx.RLock()
-	_, exists := sp.knownAddresses[addrmgr.NetAddressKey(na)]
+	lastSeen, exists := sp.knownAddresses[addrmgr.NetAddressKey(na)]
 	sp.addressesMtx.RUnlock()
-	return exists
+	return exists && time.Since(lastSeen) < knownAddressTimeout
 }
 
 // setDisableRelayTx toggles relaying of transactions for the given peer.
@@ -329,6 +364,12 @@ func (sp *serverPeer) setDisableRelayTx(disable bool) {
 	sp.relayMtx.Unlock()
 }
 
+// hasServices returns whether or not the peer advertises all of the passed
+// services.
+func (sp *serverPeer) hasServices(services wire.ServiceFlag) bool {
+	return sp.Services()&services == services
+}
+
 // relayTxDisabled returns whether or not relaying of transactions for the given
 // peer is disabled.
 // It is safe for concurrent access.
@@ -359,6 +400,27 @@ func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddress) {
 	sp.addKnownAddresses(known)
 }
 
+// pushLocalAddress sends an addr message to the peer containing the local
+// address that best matches it.  Unlike pushAddrMsg, the address is sent even
+// when the peer already knows about it so the advertisement is refreshed with
+// the current time.
+func (sp *serverPeer) pushLocalAddress() {
+	lna := sp.server.addrManager.GetBestLocalAddress(sp.NA())
+	if !addrmgr.IsRoutable(lna) {
+		return
+	}
+
+	na := *lna
+	na.Timestamp = time.Unix(time.Now().Unix(), 0)
+	known, err := sp.PushAddrMsg([]*wire.NetAddress{&na})
+	if err != nil {
+		peerLog.Errorf("Can't push address message to %s: %v", sp.Peer, err)
+		sp.Disconnect()
+		return
+	}
+	sp.addKnownAddresses(known)
+}
+
 // addBanScore increases the persistent and decaying ban score fields by the
 // values passed as parameters. If the resulting score exceeds half of the ban
 // threshold, a warning is logged including the reason provided. Further, if
@@ -433,6 +495,17 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 		return nil
 	}
 
+	// Reject inbound peers with a protocol version below the configured
+	// minimum.
+	if isInbound && msg.ProtocolVersion < int32(cfg.MinInboundVersion) {
+		srvrLog.Debugf("Rejecting inbound peer %s with protocol version "+
+			"%d below the minimum of %d", sp.Peer, msg.ProtocolVersion,
+			cfg.MinInboundVersion)
+		reason := fmt.Sprintf("protocol version must be %d or greater",
+			cfg.MinInboundVersion)
+		return wire.NewMsgReject(msg.Command(), wire.RejectObsolete, reason)
+	}
+
 	// Reject outbound peers that are not full nodes.
 	wantServices := wire.SFNodeNetwork
 	if !isInbound && !hasServices(msg.Services, wantServices) {
@@ -465,13 +538,27 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 		}
 	}
 
+	// Record the address outbound peers see the node at so it is able to
+	// advertise itself even when it has no other means of discovering its
+	// external address.  The port the peer sees is the one the connection
+	// was made from, so the listening port is advertised instead.
+	if !cfg.SimNet && !isInbound && !cfg.DisableListen {
+		na := wire.NewNetAddressIPPort(msg.AddrYou.IP,
+			sp.server.listenPort, sp.server.services)
+		if err := addrManager.SeenLocal(na, sp.NA()); err != nil {
+			peerLog.Debugf("Not recording local address reported by "+
+				"%v: %v", sp, err)
+		}
+	}
+
 	// Add the remote peer time as a sample for creating an offset against
 	// the local clock to keep the network time in sync.
 	sp.server.timeSource.AddTimeSample(sp.Addr(), msg.Timestamp)
 
 	// Choose whether or not to relay transactions before a filter command
-	// is received.
-	sp.setDisableRelayTx(msg.DisableRelayTx)
+	// is received.  Transactions are never relayed to block-relay-only
+	// peers.
+	sp.setDisableRelayTx(msg.DisableRelayTx || sp.noRelay)
 
 	return nil
 }
@@ -542,6 +629,11 @@ func (sp *serverPeer) OnTx(_ *peer.Peer, msg *wire.MsgTx) {
 			msg.TxHash(), sp)
 		return
 	}
+	if sp.noRelay {
+		peerLog.Tracef("Ignoring tx %v from block-relay-only peer %v",
+			msg.TxHash(), sp)
+		return
+	}
 
 	// Add the transaction to the known inventory for the peer.
 	// Convert the raw MsgTx to a btcutil.Tx which provides some convenience
@@ -585,21 +677,65 @@ func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 	<-sp.blockProcessed
 }
 
+// OnCmpctBlock is invoked when a peer receives a cmpctblock bitcoin message.  It
+// is passed down to the sync manager which treats it as an announcement of the
+// block it represents.
+func (sp *serverPeer) OnCmpctBlock(_ *peer.Peer, msg *wire.MsgCmpctBlock) {
+	sp.server.syncManager.QueueCmpctBlock(msg, sp.Peer)
+}
+
+// OnGetBlockTxn is invoked when a peer receives a getblocktxn bitcoin message.
+// It responds with the requested transactions of the block so the peer can
+// complete the reconstruction of a compact block.
+func (sp *serverPeer) OnGetBlockTxn(_ *peer.Peer, msg *wire.MsgGetBlockTxn) {
+	block, err := sp.server.chain.BlockByHash(&msg.BlockHash)
+	if err != nil {
+		peerLog.Debugf("Unable to fetch block %v requested by getblocktxn "+
+			"from %s: %v", msg.BlockHash, sp, err)
+		return
+	}
+
+	txns := block.MsgBlock().Transactions
+	blockTxn := wire.NewMsgBlockTxn(&msg.BlockHash)
+	for _, index := range msg.Indexes {
+		if int(index) >= len(txns) {
+			sp.addBanScore(100, 0, "getblocktxn index out of range")
+			return
+		}
+		blockTxn.AddTransaction(txns[index])
+	}
+	sp.QueueMessage(blockTxn, nil)
+}
+
+// OnBlockTxn is invoked when a peer receives a blocktxn bitcoin message.  It is
+// passed down to the sync manager to complete the reconstruction of the block
+// from a compact block.
+func (sp *serverPeer) OnBlockTxn(_ *peer.Peer, msg *wire.MsgBlockTxn) {
+	sp.server.syncManager.QueueBlockTxn(msg, sp.Peer)
+}
+
 // OnInv is invoked when a peer receives an inv bitcoin message and is
 // used to examine the inventory being advertised by the remote peer and react
 // accordingly.  We pass the message down to blockmanager which will call
 // QueueMessage with any appropriate responses.
 func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
-	if !cfg.BlocksOnly {
+	if !cfg.BlocksOnly && !sp.noRelay {
 		if len(msg.InvList) > 0 {
 			sp.server.syncManager.QueueInv(msg, sp.Peer)
 		}
 		return
 	}
 
+	// Transactions announced by block-relay-only peers are ignored without
+	// penalizing them since they were never asked not to announce any.
 	newInv := wire.NewMsgInvSizeHint(uint(len(msg.InvList)))
 	for _, invVect := range msg.InvList {
 		if invVect.Type == wire.InvTypeTx {
+			if sp.noRelay {
+				peerLog.Tracef("Ignoring tx %v in inv from "+
+					"block-relay-only peer %v", invVect.Hash, sp)
+				continue
+			}
 			peerLog.Tracef("Ignoring tx %v in inv from %v -- "+
 				"blocksonly enabled", invVect.Hash, sp)
 			if sp.ProtocolVersion() >= wire.BIP0037Version {
@@ -625,6 +761,20 @@ func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
 // OnHeaders is invoked when a peer receives a headers bitcoin
 // message.  The message is passed down to the sync manager.
 func (sp *serverPeer) OnHeaders(_ *peer.Peer, msg *wire.MsgHeaders) {
+	// Headers are cheap to send compared to the work they claim, so ensure
+	// each one satisfies its own proof of work before any of them are
+	// stored.  A peer sending headers that don't is misbehaving.
+	powLimit := sp.server.chainParams.PowLimit
+	for _, header := range msg.Headers {
+		err := blockchain.CheckHeaderProofOfWork(header, powLimit)
+		if err != nil {
+			reason := fmt.Sprintf("header %v with invalid proof of "+
+				"work: %v", header.BlockHash(), err)
+			sp.addBanScore(100, 0, reason)
+			return
+		}
+	}
+
 	sp.server.syncManager.QueueHeaders(msg, sp.Peer)
 }
 
@@ -672,6 +822,8 @@ func (sp *serverPeer) OnGetData(_ *peer.Peer, msg *wire.MsgGetData) {
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan, wire.BaseEncoding)
+		case wire.InvTypeCmpctBlock:
+			err = sp.server.pushCmpctBlockMsg(sp, &iv.Hash, c, waitChan)
 		case wire.InvTypeFilteredWitnessBlock:
 			err = sp.server.pushMerkleBlockMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeFilteredBlock:
@@ -797,12 +949,51 @@ func (sp *serverPeer) OnGetCFilters(_ *peer.Peer, msg *wire.MsgGetCFilters) {
 		return
 	}
 
-	hashes, err := sp.server.chain.HeightToHashRange(
+	filters, missing, err := cfiltersForRange(sp.server.chain,
+		sp.server.cfIndex, msg)
+	if err != nil {
+		peerLog.Debugf("Unable to serve getcfilters request: %v", err)
+
+		// Let the peer know the requested range is not available.
+		notFound := wire.NewMsgNotFound()
+		iv := wire.NewInvVect(wire.InvTypeCFilter, &msg.StopHash)
+		notFound.AddInvVect(iv)
+		sp.QueueMessage(notFound, nil)
+		return
+	}
+
+	for _, filterMsg := range filters {
+		sp.QueueMessage(filterMsg, nil)
+	}
+
+	// Notify the peer about any blocks in the range for which a filter has
+	// not been indexed.
+	if len(missing) > 0 {
+		notFound := wire.NewMsgNotFound()
+		for i := range missing {
+			peerLog.Debugf("Could not obtain cfilter for %v",
+				missing[i])
+			iv := wire.NewInvVect(wire.InvTypeCFilter, &missing[i])
+			notFound.AddInvVect(iv)
+		}
+		sp.QueueMessage(notFound, nil)
+	}
+}
+
+// cfiltersForRange returns the filters of the requested type for the range of
+// blocks described by the passed getcfilters message, in ascending order of
+// height.  The range may not contain more than wire.MaxGetCFiltersReqRange
+// blocks.  The hashes of blocks in the range whose filters have not been
+// indexed are returned separately and are not included in the filters.  An
+// error is returned when the range is invalid or isn't known to the chain.
+func cfiltersForRange(chain *blockchain.BlockChain, cfIndex *indexers.CfIndex,
+	msg *wire.MsgGetCFilters) ([]*wire.MsgCFilter, []chainhash.Hash, error) {
+
+	hashes, err := chain.HeightToHashRange(
 		int32(msg.StartHeight), &msg.StopHash, wire.MaxGetCFiltersReqRange,
 	)
 	if err != nil {
-		peerLog.Debugf("Invalid getcfilters request: %v", err)
-		return
+		return nil, nil, err
 	}
 
 	// Create []*chainhash.Hash from []chainhash.Hash to pass to
@@ -812,26 +1003,26 @@ func (sp *serverPeer) OnGetCFilters(_ *peer.Peer, msg *wire.MsgGetCFilters) {
 		hashPtrs[i] = &hashes[i]
 	}
 
-	filters, err := sp.server.cfIndex.FiltersByBlockHashes(
-		hashPtrs, msg.FilterType,
-	)
+	filters, err := cfIndex.FiltersByBlockHashes(hashPtrs, msg.FilterType)
 	if err != nil {
-		peerLog.Errorf("Error retrieving cfilters: %v", err)
-		return
+		return nil, nil, fmt.Errorf("error retrieving cfilters: %v", err)
 	}
 
+	filterMsgs := make([]*wire.MsgCFilter, 0, len(filters))
+	var missing []chainhash.Hash
 	for i, filterBytes := range filters {
 		if len(filterBytes) == 0 {
-			peerLog.Warnf("Could not obtain cfilter for %v",
-				hashes[i])
-			return
+			missing = append(missing, hashes[i])
+			continue
 		}
 
 		filterMsg := wire.NewMsgCFilter(
 			msg.FilterType, &hashes[i], filterBytes,
 		)
-		sp.QueueMessage(filterMsg, nil)
+		filterMsgs = append(filterMsgs, filterMsg)
 	}
+
+	return filterMsgs, missing, nil
 }
 
 // OnGetCFHeaders is invoked when a peer receives a getcfheader bitcoin message.
@@ -1207,7 +1398,9 @@ func (sp *serverPeer) OnFilterLoad(_ *peer.Peer, msg *wire.MsgFilterLoad) {
 		return
 	}
 
-	sp.setDisableRelayTx(false)
+	// Loading a filter enables transaction relay unless the peer is
+	// block-relay-only.
+	sp.setDisableRelayTx(sp.noRelay)
 
 	sp.filter.Reload(msg)
 }
@@ -1371,25 +1564,32 @@ func randomUint16Number(max uint16) uint16 {
 }
 
 // AddRebroadcastInventory adds 'iv' to the list of inventories to be
-// rebroadcasted at random intervals until they show up in a block.
+// rebroadcasted at random intervals until they are requested by a peer or show
+// up in a block.  Only transactions in the memory pool are rebroadcast, so any
+// other inventory is ignored.
 func (s *server) AddRebroadcastInventory(iv *wire.InvVect, data interface{}) {
-	// Ignore if shutting down.
-	if atomic.LoadInt32(&s.shutdown) != 0 {
+	if iv.Type != wire.InvTypeTx {
 		return
 	}
-
-	s.modifyRebroadcastInv <- broadcastInventoryAdd{invVect: iv, data: data}
+	s.txMemPool.AddUnbroadcastTx(&iv.Hash)
 }
 
 // RemoveRebroadcastInventory removes 'iv' from the list of items to be
 // rebroadcasted if present.
 func (s *server) RemoveRebroadcastInventory(iv *wire.InvVect) {
-	// Ignore if shutting down.
-	if atomic.LoadInt32(&s.shutdown) != 0 {
+	if iv.Type != wire.InvTypeTx {
 		return
 	}
+	s.txMemPool.RemoveUnbroadcastTx(&iv.Hash)
+}
 
-	s.modifyRebroadcastInv <- broadcastInventoryDel(iv)
+// RebroadcastTransactions relays inventory vectors for all of the locally
+// submitted transactions in the memory pool that have not been requested by any
+// peers yet and returns their descriptors.
+func (s *server) RebroadcastTransactions() []*mempool.TxDesc {
+	txns := s.txMemPool.UnbroadcastTxDescs()
+	s.relayTransactions(txns)
+	return txns
 }
 
 // relayTransactions generates and relays inventory vectors for all of the
@@ -1448,6 +1648,10 @@ func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<-
 		return err
 	}
 
+	// The transaction no longer needs to be rebroadcast now that a peer
+	// has requested it.
+	s.txMemPool.RemoveUnbroadcastTx(hash)
+
 	// Once we have fetched data wait for any previous operation to finish.
 	if waitChan != nil {
 		<-waitChan
@@ -1524,6 +1728,57 @@ func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan cha
 	return nil
 }
 
+// pushCmpctBlockMsg sends a cmpctblock message for the provided block hash to
+// the connected peer when the block is one of the most recent blocks of the
+// main chain and the peer supports witness compact blocks.  Otherwise, the full
+// block is sent instead per BIP0152.  An error is returned if the block hash is
+// not known.
+func (s *server) pushCmpctBlockMsg(sp *serverPeer, hash *chainhash.Hash,
+	doneChan chan<- struct{}, waitChan <-chan struct{}) error {
+
+	height, err := sp.server.chain.BlockHeightByHash(hash)
+	best := sp.server.chain.BestSnapshot()
+	if err != nil || best.Height-height >= maxCmpctBlockDepth ||
+		!sp.IsWitnessEnabled() {
+
+		encoding := wire.BaseEncoding
+		if sp.IsWitnessEnabled() {
+			encoding = wire.WitnessEncoding
+		}
+		return s.pushBlockMsg(sp, hash, doneChan, waitChan, encoding)
+	}
+
+	blk, err := sp.server.chain.BlockByHash(hash)
+	if err != nil {
+		peerLog.Tracef("Unable to fetch requested block hash %v: %v",
+			hash, err)
+
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+	nonce, err := wire.RandomUint64()
+	if err != nil {
+		peerLog.Errorf("Failed to generate compact block nonce: %v", err)
+
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+	cmpctBlock := wire.NewMsgCmpctBlockFromBlock(blk.MsgBlock(), nonce)
+
+	// Once we have fetched data wait for any previous operation to finish.
+	if waitChan != nil {
+		<-waitChan
+	}
+
+	sp.QueueMessage(cmpctBlock, doneChan)
+
+	return nil
+}
+
 // pushMerkleBlockMsg sends a merkleblock message for the provided block hash to
 // the connected peer.  Since a merkle block requires the peer to have a filter
 // loaded, this call will simply be ignored if there is no filter loaded.  An
@@ -1654,6 +1909,15 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 
 	// TODO: Check for max peers from a single IP.
 
+	// Limit max number of inbound peers.  Room within the max number of
+	// total peers is reserved for the outbound target this way.
+	if sp.Inbound() && len(state.inboundPeers) >= cfg.MaxInbound {
+		srvrLog.Infof("Max inbound peers reached [%d] - disconnecting "+
+			"peer %s", cfg.MaxInbound, sp)
+		sp.Disconnect()
+		return false
+	}
+
 	// Limit max number of total peers.
 	if state.Count() >= cfg.MaxPeers {
 		srvrLog.Infof("Max peers reached [%d] - disconnecting peer %s",
@@ -1696,13 +1960,7 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		// connections and it believes itself to be close to the best
 		// known tip.
 		if !cfg.DisableListen && s.syncManager.IsCurrent() {
-			// Get address that best matches.
-			lna := s.addrManager.GetBestLocalAddress(sp.NA())
-			if addrmgr.IsRoutable(lna) {
-				// Filter addresses the peer already knows about.
-				addresses := []*wire.NetAddress{lna}
-				sp.pushAddrMsg(addresses)
-			}
+			sp.pushLocalAddress()
 		}
 
 		// Request known addresses if the server address manager needs
@@ -1720,6 +1978,24 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	return true
 }
 
+// handleSelfAdvertise advertises the local address that best matches each
+// connected peer to it.  This is skipped when the server does not accept
+// incoming connections, when it believes itself to not be close to the best
+// known tip, and when running on the simulation test network since it actively
+// avoids advertising itself there.  It is invoked from the peerHandler
+// goroutine.
+func (s *server) handleSelfAdvertise(state *peerState) {
+	if cfg.SimNet || cfg.DisableListen || !s.syncManager.IsCurrent() {
+		return
+	}
+
+	state.forAllPeers(func(sp *serverPeer) {
+		if sp.Connected() {
+			sp.pushLocalAddress()
+		}
+	})
+}
+
 // handleDonePeerMsg deals with peers that have signalled they are done.  It is
 // invoked from the peerHandler goroutine.
 func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
@@ -1740,7 +2016,6 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 			s.connManager.Disconnect(sp.connReq.ID())
 		} else {
 			s.connManager.Remove(sp.connReq.ID())
-			go s.connManager.NewConnReq()
 		}
 	}
 
@@ -1771,21 +2046,56 @@ func (s *server) handleBanPeerMsg(state *peerState, sp *serverPeer) {
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
 // known to have it.  It is invoked from the peerHandler goroutine.
 func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
+	// The compact block for a relayed block is only created once it is
+	// needed and then shared by all peers that want it pushed directly.
+	var cmpctBlock *wire.MsgCmpctBlock
+
 	state.forAllPeers(func(sp *serverPeer) {
 		if !sp.Connected() {
 			return
 		}
 
+		// If the inventory is a block and the peer asked for new blocks
+		// to be pushed as compact blocks, send the compact block directly
+		// instead of announcing it.
+		if msg.invVect.Type == wire.InvTypeBlock && sp.WantsCmpctBlocks() &&
+			sp.IsWitnessEnabled() {
+
+			block, ok := msg.data.(*btcutil.Block)
+			if !ok {
+				peerLog.Warnf("Underlying data for compact " +
+					"block is not a block")
+				return
+			}
+			if sp.IsKnownInventory(msg.invVect) {
+				return
+			}
+			if cmpctBlock == nil {
+				nonce, err := wire.RandomUint64()
+				if err != nil {
+					peerLog.Errorf("Failed to generate compact "+
+						"block nonce: %v", err)
+					return
+				}
+				cmpctBlock = wire.NewMsgCmpctBlockFromBlock(
+					block.MsgBlock(), nonce)
+			}
+			sp.AddKnownInventory(msg.invVect)
+			sp.QueueMessage(cmpctBlock, nil)
+			return
+		}
+
 		// If the inventory is a block and the peer prefers headers,
 		// generate and send a headers message instead of an inventory
 		// message.
 		if msg.invVect.Type == wire.InvTypeBlock && sp.WantsHeaders() {
-			blockHeader, ok := msg.data.(wire.BlockHeader)
+			block, ok := msg.data.(*btcutil.Block)
 			if !ok {
 				peerLog.Warnf("Underlying data for headers" +
-					" is not a block header")
+					" is not a block")
 				return
 			}
+			blockHeader := block.MsgBlock().Header
 			msgHeaders := wire.NewMsgHeaders()
 			if err := msgHeaders.AddBlockHeader(&blockHeader); err != nil {
 				peerLog.Errorf("Failed to add block"+
@@ -1803,6 +2113,13 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 				return
 			}
 
+			// Don't relay the transaction to the peer when it
+			// doesn't advertise the services required for
+			// transaction relay.
+			if !sp.hasServices(s.txRelayServices) {
+				return
+			}
+
 			txD, ok := msg.data.(*mempool.TxDesc)
 			if !ok {
 				peerLog.Warnf("Underlying data for tx inv "+
@@ -2030,6 +2347,9 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnMemPool:      sp.OnMemPool,
 			OnTx:           sp.OnTx,
 			OnBlock:        sp.OnBlock,
+			OnCmpctBlock:   sp.OnCmpctBlock,
+			OnGetBlockTxn:  sp.OnGetBlockTxn,
+			OnBlockTxn:     sp.OnBlockTxn,
 			OnInv:          sp.OnInv,
 			OnHeaders:      sp.OnHeaders,
 			OnGetData:      sp.OnGetData,
@@ -2062,9 +2382,11 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 		UserAgentComments: cfg.UserAgentComments,
 		ChainParams:       sp.server.chainParams,
 		Services:          sp.server.services,
-		DisableRelayTx:    cfg.BlocksOnly,
+		DisableRelayTx:    cfg.BlocksOnly || sp.noRelay,
 		ProtocolVersion:   peer.MaxProtocolVersion,
 		TrickleInterval:   cfg.TrickleInterval,
+		HandshakeTimeout:  cfg.HandshakeTimeout,
+		IdleTimeout:       cfg.PeerIdleTimeout,
 	}
 }
 
@@ -2075,6 +2397,7 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 func (s *server) inboundPeerConnected(conn net.Conn) {
 	sp := newServerPeer(s, false)
 	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
+	sp.noRelay = isNoRelayPeer(conn.RemoteAddr())
 	sp.Peer = peer.NewInboundPeer(newPeerConfig(sp))
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
@@ -2087,6 +2410,7 @@ func (s *server) inboundPeerConnected(conn net.Conn) {
 // manager of the attempt.
 func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	sp := newServerPeer(s, c.Permanent)
+	sp.noRelay = isNoRelayPeer(conn.RemoteAddr())
 	p, err := peer.NewOutboundPeer(newPeerConfig(sp), c.Addr.String())
 	if err != nil {
 		srvrLog.Debugf("Cannot create outbound peer %s: %v", c.Addr, err)
@@ -2094,7 +2418,6 @@ func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 			s.connManager.Disconnect(c.ID())
 		} else {
 			s.connManager.Remove(c.ID())
-			go s.connManager.NewConnReq()
 		}
 		return
 	}
@@ -2162,6 +2485,8 @@ func (s *server) peerHandler() {
 	}
 	go s.connManager.Start()
 
+	selfAdvertiseTicker := time.NewTicker(selfAdvertiseInterval)
+
 out:
 	for {
 		select {
@@ -2193,6 +2518,10 @@ out:
 		case qmsg := <-s.query:
 			s.handleQuery(state, qmsg)
 
+		// Periodically advertise the local address to all peers.
+		case <-selfAdvertiseTicker.C:
+			s.handleSelfAdvertise(state)
+
 		case <-s.quit:
 			// Disconnect all peers on server shutdown.
 			state.forAllPeers(func(sp *serverPeer) {
@@ -2203,8 +2532,12 @@ out:
 		}
 	}
 
+	selfAdvertiseTicker.Stop()
 	s.connManager.Stop()
 	s.syncManager.Stop()
+	if err := s.chain.FlushIndex(); err != nil {
+		srvrLog.Errorf("Unable to flush block index: %v", err)
+	}
 	s.addrManager.Stop()
 
 	// Drain channels before exiting so nothing is left waiting around
@@ -2299,41 +2632,52 @@ func (s *server) UpdatePeerHeights(latestBlkHash *chainhash.Hash, latestHeight i
 	}
 }
 
-// rebroadcastHandler keeps track of user submitted inventories that we have
-// sent out but have not yet made it into a block. We periodically rebroadcast
-// them in case our peers restarted or otherwise lost track of them.
+// rebroadcastDelay returns a random duration within half the passed interval of
+// it to wait before the next rebroadcast of transactions.  The jitter prevents
+// the rebroadcasts of multiple nodes from happening in lockstep and makes it
+// harder for peers to link rebroadcast transactions to the node.
+func rebroadcastDelay(interval time.Duration) (time.Duration, error) {
+	if interval <= 0 {
+		return 0, nil
+	}
+
+	var randomNumber uint64
+	err := binary.Read(rand.Reader, binary.LittleEndian, &randomNumber)
+	if err != nil {
+		return 0, err
+	}
+	return interval/2 + time.Duration(randomNumber%uint64(interval)), nil
+}
+
+// nextRebroadcastDelay returns the duration to wait before the next rebroadcast
+// of transactions.  The configured interval is used without any jitter when a
+// random delay can't be generated.
+func (s *server) nextRebroadcastDelay() time.Duration {
+	delay, err := rebroadcastDelay(s.rebroadcastInterval)
+	if err != nil {
+		srvrLog.Errorf("Unable to generate rebroadcast delay: %v", err)
+		return s.rebroadcastInterval
+	}
+	return delay
+}
+
+// rebroadcastHandler periodically rebroadcasts the user submitted transactions
+// in the memory pool that have not been requested by any peers yet in case our
+// peers restarted or otherwise lost track of them.  Transactions are no longer
+// rebroadcast once a peer requests them or they leave the memory pool, such as
+// when they make it into a block.
 func (s *server) rebroadcastHandler() {
-	// Wait 5 min before first tx rebroadcast.
-	timer := time.NewTimer(5 * time.Minute)
-	pendingInvs := make(map[wire.InvVect]interface{})
+	timer := time.NewTimer(s.nextRebroadcastDelay())
 
 out:
 	for {
 		select {
-		case riv := <-s.modifyRebroadcastInv:
-			switch msg := riv.(type) {
-			// Incoming InvVects are added to our map of RPC txs.
-			case broadcastInventoryAdd:
-				pendingInvs[*msg.invVect] = msg.data
-
-			// When an InvVect has been added to a block, we can
-			// now remove it, if it was present.
-			case broadcastInventoryDel:
-				delete(pendingInvs, *msg)
-			}
-
 		case <-timer.C:
-			// Any inventory we have has not made it into a block
-			// yet. We periodically resubmit them until they have.
-			for iv, data := range pendingInvs {
-				ivCopy := iv
-				s.RelayInventory(&ivCopy, data)
-			}
-
-			// Process at a random time up to 30mins (in seconds)
-			// in the future.
-			timer.Reset(time.Second *
-				time.Duration(randomUint16Number(1800)))
+			// Any transaction that is still unbroadcast has not been
+			// requested by a peer yet.  We periodically resubmit
+			// them until they have.
+			s.RebroadcastTransactions()
+			timer.Reset(s.nextRebroadcastDelay())
 
 		case <-s.quit:
 			break out
@@ -2341,17 +2685,6 @@ out:
 	}
 
 	timer.Stop()
-
-	// Drain channels before exiting so nothing is left waiting around
-	// to send.
-cleanup:
-	for {
-		select {
-		case <-s.modifyRebroadcastInv:
-		default:
-			break cleanup
-		}
-	}
 	s.wg.Done()
 }
 
@@ -2381,7 +2714,8 @@ func (s *server) Start() {
 		s.wg.Add(1)
 
 		// Start the rebroadcastHandler, which ensures user tx received by
-		// the RPC server are rebroadcast until being included in a block.
+		// the RPC server are rebroadcast until being requested by a peer
+		// or included in a block.
 		go s.rebroadcastHandler()
 
 		s.rpcServer.Start()
@@ -2617,6 +2951,19 @@ func setupRPCListeners() ([]net.Listener, error) {
 	return listeners, nil
 }
 
+// advertisedServices returns the services to advertise to peers based on the
+// configured services and the features that have been disabled.
+func advertisedServices() wire.ServiceFlag {
+	services := cfg.services
+	if cfg.NoPeerBloomFilters {
+		services &^= wire.SFNodeBloom
+	}
+	if cfg.NoCFilters {
+		services &^= wire.SFNodeCF
+	}
+	return services
+}
+
 // newServer returns a new btcd server configured to listen on addr for the
 // bitcoin network type specified by chainParams.  Use start to begin accepting
 // connections from peers.
@@ -2624,18 +2971,17 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 	db database.DB, chainParams *chaincfg.Params,
 	interrupt <-chan struct{}) (*server, error) {
 
-	services := defaultServices
-	if cfg.NoPeerBloomFilters {
-		services &^= wire.SFNodeBloom
-	}
-	if cfg.NoCFilters {
-		services &^= wire.SFNodeCF
-	}
+	services := advertisedServices()
 
 	amgr := addrmgr.New(cfg.DataDir, btcdLookup)
 
+	// The peer database format was validated when loading the config.
+	peersFormat, _ := addrmgr.ParsePeersFormat(cfg.PeersDBFormat)
+	amgr.SetPeersFormat(peersFormat)
+
 	var listeners []net.Listener
 	var nat NAT
+	var listenPort uint16
 	if !cfg.DisableListen {
 		var err error
 		listeners, nat, err = initListeners(amgr, listenAddrs, services)
@@ -2645,6 +2991,9 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		if len(listeners) == 0 {
 			return nil, errors.New("no valid listen address")
 		}
+		if tcpAddr, ok := listeners[0].Addr().(*net.TCPAddr); ok {
+			listenPort = uint16(tcpAddr.Port)
+		}
 	}
 
 	if len(agentBlacklist) > 0 {
@@ -2655,26 +3004,32 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 	}
 
 	s := server{
-		chainParams:          chainParams,
-		addrManager:          amgr,
-		newPeers:             make(chan *serverPeer, cfg.MaxPeers),
-		donePeers:            make(chan *serverPeer, cfg.MaxPeers),
-		banPeers:             make(chan *serverPeer, cfg.MaxPeers),
-		query:                make(chan interface{}),
-		relayInv:             make(chan relayMsg, cfg.MaxPeers),
-		broadcast:            make(chan broadcastMsg, cfg.MaxPeers),
-		quit:                 make(chan struct{}),
-		modifyRebroadcastInv: make(chan interface{}),
-		peerHeightsUpdate:    make(chan updatePeerHeightsMsg),
-		nat:                  nat,
-		db:                   db,
-		timeSource:           blockchain.NewMedianTime(),
-		services:             services,
-		sigCache:             txscript.NewSigCache(cfg.SigCacheMaxSize),
-		hashCache:            txscript.NewHashCache(cfg.SigCacheMaxSize),
-		cfCheckptCaches:      make(map[wire.FilterType][]cfHeaderKV),
-		agentBlacklist:       agentBlacklist,
-		agentWhitelist:       agentWhitelist,
+		chainParams:       chainParams,
+		addrManager:       amgr,
+		newPeers:          make(chan *serverPeer, cfg.MaxPeers),
+		donePeers:         make(chan *serverPeer, cfg.MaxPeers),
+		banPeers:          make(chan *serverPeer, cfg.MaxPeers),
+		query:             make(chan interface{}),
+		relayInv:          make(chan relayMsg, cfg.MaxPeers),
+		broadcast:         make(chan broadcastMsg, cfg.MaxPeers),
+		quit:              make(chan struct{}),
+		peerHeightsUpdate: make(chan updatePeerHeightsMsg),
+		nat:               nat,
+		db:                db,
+		timeSource:        blockchain.NewMedianTime(),
+		services:          services,
+		sigCache:          txscript.NewSigCache(cfg.SigCacheMaxSize),
+		hashCache:         txscript.NewHashCache(cfg.SigCacheMaxSize),
+		scriptCache:       txscript.NewScriptClassCache(cfg.ScriptCacheMaxSize),
+		cfCheckptCaches:   make(map[wire.FilterType][]cfHeaderKV),
+		agentBlacklist:    agentBlacklist,
+		agentWhitelist:    agentWhitelist,
+		listenPort:        listenPort,
+
+		rebroadcastInterval: cfg.RebroadcastInterval,
+	}
+	if cfg.TxRelayBloomOnly {
+		s.txRelayServices = wire.SFNodeBloom
 	}
 
 	// Create the transaction and address indexes if needed.
@@ -2700,7 +3055,8 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 	}
 	if cfg.AddrIndex {
 		indxLog.Info("Address index is enabled")
-		s.addrIndex = indexers.NewAddrIndex(db, chainParams)
+		s.addrIndex = indexers.NewAddrIndex(db, chainParams,
+			indexers.WithScriptCache(s.scriptCache))
 		indexes = append(indexes, s.addrIndex)
 	}
 	if !cfg.NoCFilters {
@@ -2724,14 +3080,15 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 	// Create a new block chain instance with the appropriate configuration.
 	var err error
 	s.chain, err = blockchain.New(&blockchain.Config{
-		DB:           s.db,
-		Interrupt:    interrupt,
-		ChainParams:  s.chainParams,
-		Checkpoints:  checkpoints,
-		TimeSource:   s.timeSource,
-		SigCache:     s.sigCache,
-		IndexManager: indexManager,
-		HashCache:    s.hashCache,
+		DB:                  s.db,
+		Interrupt:           interrupt,
+		ChainParams:         s.chainParams,
+		Checkpoints:         checkpoints,
+		TimeSource:          s.timeSource,
+		SigCache:            s.sigCache,
+		IndexManager:        indexManager,
+		HashCache:           s.hashCache,
+		MaxOrphanBlockBytes: cfg.MaxOrphanBlockBytes,
 	})
 	if err != nil {
 		return nil, err
@@ -2773,11 +3130,19 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 			AcceptNonStd:         cfg.RelayNonStd,
 			FreeTxRelayLimit:     cfg.FreeTxRelayLimit,
 			MaxOrphanTxs:         cfg.MaxOrphanTxs,
+			MaxOrphansPerCycle:   cfg.MaxOrphansPerCycle,
 			MaxOrphanTxSize:      defaultMaxOrphanTxSize,
 			MaxSigOpCostPerTx:    blockchain.MaxBlockSigOpsCost / 4,
 			MinRelayTxFee:        cfg.minRelayTxFee,
+			MaxTxFee:             cfg.maxTxFee,
 			MaxTxVersion:         2,
 			RejectReplacement:    cfg.RejectReplacement,
+			MaxScriptSigOps:      cfg.MaxScriptSigOps,
+			MaxScriptStackDepth:  cfg.MaxScriptStackDepth,
+			MaxAncestorCount:     cfg.LimitAncestorCount,
+			MaxAncestorSize:      int64(cfg.LimitAncestorSize) * 1000,
+			MaxDescendantCount:   cfg.LimitDescendantCount,
+			MaxDescendantSize:    int64(cfg.LimitDescendantSize) * 1000,
 		},
 		ChainParams:    chainParams,
 		FetchUtxoView:  s.chain.FetchUtxoView,
@@ -2795,13 +3160,16 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 	s.txMemPool = mempool.New(&txC)
 
 	s.syncManager, err = netsync.New(&netsync.Config{
-		PeerNotifier:       &s,
-		Chain:              s.chain,
-		TxMemPool:          s.txMemPool,
-		ChainParams:        s.chainParams,
-		DisableCheckpoints: cfg.DisableCheckpoints,
-		MaxPeers:           cfg.MaxPeers,
-		FeeEstimator:       s.feeEstimator,
+		PeerNotifier:        &s,
+		Chain:               s.chain,
+		TxMemPool:           s.txMemPool,
+		ChainParams:         s.chainParams,
+		DisableCheckpoints:  cfg.DisableCheckpoints,
+		MaxPeers:            cfg.MaxPeers,
+		BlockDownloadWindow: cfg.BlockDownloadWindow,
+		MaxBlocksInFlight:   cfg.MaxBlocksInFlight,
+		GetDataBatchSize:    cfg.GetDataBatchSize,
+		FeeEstimator:        s.feeEstimator,
 	})
 	if err != nil {
 		return nil, err
@@ -2819,14 +3187,19 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		BlockMaxSize:      cfg.BlockMaxSize,
 		BlockPrioritySize: cfg.BlockPrioritySize,
 		TxMinFreeFee:      cfg.minRelayTxFee,
+		BlockVersionBits:  cfg.blockVersionBits,
 	}
 	blockTemplateGenerator := mining.NewBlkTmplGenerator(&policy,
 		s.chainParams, s.txMemPool, s.chain, s.timeSource,
 		s.sigCache, s.hashCache)
+	payoutRotation, _ := mining.ParsePayoutRotation(cfg.MiningAddrRotation)
+	payouts := mining.NewPayoutAddrs(cfg.miningAddrs, payoutRotation)
+	s.chain.Subscribe(payouts.HandleBlockchainNotification)
 	s.cpuMiner = cpuminer.New(&cpuminer.Config{
 		ChainParams:            chainParams,
 		BlockTemplateGenerator: blockTemplateGenerator,
 		MiningAddrs:            cfg.miningAddrs,
+		Payouts:                payouts,
 		ProcessBlock:           s.syncManager.ProcessBlock,
 		ConnectedCount:         s.ConnectedCount,
 		IsCurrent:              s.syncManager.IsCurrent,
@@ -2881,19 +3254,24 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 		}
 	}
 
-	// Create a connection manager.
-	targetOutbound := defaultTargetOutbound
-	if cfg.MaxPeers < targetOutbound {
-		targetOutbound = cfg.MaxPeers
+	// Don't make automatic outbound connections when there is no outbound
+	// target.
+	if cfg.TargetOutbound == 0 {
+		newAddressFunc = nil
 	}
+
+	// Create a connection manager.
 	cmgr, err := connmgr.New(&connmgr.Config{
-		Listeners:      listeners,
-		OnAccept:       s.inboundPeerConnected,
-		RetryDuration:  connectionRetryInterval,
-		TargetOutbound: uint32(targetOutbound),
-		Dial:           btcdDial,
-		OnConnection:   s.outboundPeerConnected,
-		GetNewAddress:  newAddressFunc,
+		Listeners:         listeners,
+		OnAccept:          s.inboundPeerConnected,
+		InboundRateLimit:  cfg.InboundRateLimit,
+		IsRateLimitExempt: isWhitelisted,
+		RetryDuration:     connectionRetryInterval,
+		TargetOutbound:    uint32(cfg.TargetOutbound),
+		Dial:              btcdDial,
+		DialTimeout:       cfg.DialTimeout,
+		OnConnection:      s.outboundPeerConnected,
+		GetNewAddress:     newAddressFunc,
 	})
 	if err != nil {
 		return nil, err
@@ -2944,6 +3322,11 @@ func newServer(listenAddrs, agentBlacklist, agentWhitelist []string,
 			AddrIndex:    s.addrIndex,
 			CfIndex:      s.cfIndex,
 			FeeEstimator: s.feeEstimator,
+			ScriptCache:  s.scriptCache,
+			VerifyBlocks: cfg.RPCVerifyBlocks,
+
+			ReorgChunkSize: cfg.RPCReorgChunkSize,
+			MiningPayouts:  payouts,
 		})
 		if err != nil {
 			return nil, err
@@ -3156,7 +3539,19 @@ func dynamicTickDuration(remaining time.Duration) time.Duration {
 // isWhitelisted returns whether the IP address is included in the whitelisted
 // networks and IPs.
 func isWhitelisted(addr net.Addr) bool {
-	if len(cfg.whitelists) == 0 {
+	return ipNetsContain(cfg.whitelists, addr)
+}
+
+// isNoRelayPeer returns whether the IP address is included in the configured
+// block-relay-only networks and IPs.
+func isNoRelayPeer(addr net.Addr) bool {
+	return ipNetsContain(cfg.noRelayPeers, addr)
+}
+
+// ipNetsContain returns whether the IP address is included in any of the
+// passed networks.
+func ipNetsContain(ipnets []*net.IPNet, addr net.Addr) bool {
+	if len(ipnets) == 0 {
 		return false
 	}
 
@@ -3171,7 +3566,7 @@ func isWhitelisted(addr net.Addr) bool {
 		return false
 	}
 
-	for _, ipnet := range cfg.whitelists {
+	for _, ipnet := range ipnets {
 		if ipnet.Contains(ip) {
 			return true
 		}
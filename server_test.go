@@ -0,0 +1,1188 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/blockchain/indexers"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/mining"
+	"github.com/btcsuite/btcd/netsync"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+	"github.com/btcsuite/btcutil"
+)
+
+// loadTestBlocks reads the blocks in the passed bzip2 compressed file from the
+// blockchain package test data.
+func loadTestBlocks(filename string) ([]*btcutil.Block, error) {
+	fi, err := os.Open(filepath.Join("blockchain", "testdata", filename))
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	return blockchain.ReadBlockFile(bzip2.NewReader(fi), wire.MainNet)
+}
+
+// addTestBlocks extends the best chain of the passed chain with the passed
+// number of blocks which are a minute apart starting at the passed time and only
+// contain a coinbase paying to an anyone-can-spend script.  The blocks are only
+// valid for networks with a trivial proof of work limit, such as the regression
+// test network.
+func addTestBlocks(t *testing.T, chain *blockchain.BlockChain,
+	params *chaincfg.Params, firstTime time.Time, numBlocks int) []*btcutil.Block {
+
+	t.Helper()
+
+	best := chain.BestSnapshot()
+	prevHash := best.Hash
+	blocks := make([]*btcutil.Block, 0, numBlocks)
+	for i := 0; i < numBlocks; i++ {
+		height := best.Height + int32(i) + 1
+		coinbase := wire.NewMsgTx(1)
+		coinbase.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+				wire.MaxPrevOutIndex),
+			SignatureScript: []byte{0x02, byte(height), byte(height >> 8)},
+			Sequence:        wire.MaxTxInSequenceNum,
+		})
+		coinbase.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_TRUE}))
+		header := wire.NewBlockHeader(1, &prevHash, &chainhash.Hash{},
+			params.PowLimitBits, 0)
+		header.MerkleRoot = coinbase.TxHash()
+		header.Timestamp = time.Unix(firstTime.Add(time.Minute*
+			time.Duration(i)).Unix(), 0)
+		for {
+			hash := header.BlockHash()
+			if blockchain.HashToBig(&hash).Cmp(params.PowLimit) <= 0 {
+				break
+			}
+			header.Nonce++
+		}
+		msgBlock := wire.NewMsgBlock(header)
+		msgBlock.AddTransaction(coinbase)
+		block := btcutil.NewBlock(msgBlock)
+		_, _, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+		blocks = append(blocks, block)
+		prevHash = header.BlockHash()
+	}
+	return blocks
+}
+
+// newTestDB returns a new database for the passed network parameters in a
+// temporary directory.  The database is closed once the test finishes.
+func newTestDB(t *testing.T, params *chaincfg.Params) database.DB {
+	t.Helper()
+
+	db, err := database.Create("ffldb", filepath.Join(t.TempDir(), "db"),
+		params.Net)
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+// newTestChain returns a chain instance for the passed network parameters
+// along with the new database which backs it.  The database is closed once the
+// test finishes.
+func newTestChain(t *testing.T, params *chaincfg.Params) (*blockchain.BlockChain, database.DB) {
+	t.Helper()
+
+	db := newTestDB(t, params)
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: params,
+		TimeSource:  blockchain.NewMedianTime(),
+		SigCache:    txscript.NewSigCache(1000),
+	})
+	if err != nil {
+		t.Fatalf("unable to create chain: %v", err)
+	}
+	return chain, db
+}
+
+// TestCFiltersForRange ensures a range of committed filters is returned in
+// order and that invalid ranges are rejected.
+func TestCFiltersForRange(t *testing.T) {
+	// The log rotator is not initialized when running tests, so disable
+	// logging by the chain and indexers.
+	blockchain.UseLogger(btclog.Disabled)
+	indexers.UseLogger(btclog.Disabled)
+
+	// The test blocks spend coinbase outputs immediately, so lower the
+	// coinbase maturity accordingly.
+	params := chaincfg.MainNetParams
+	params.CoinbaseMaturity = 1
+	db := newTestDB(t, &params)
+
+	cfIndex := indexers.NewCfIndex(db, &params)
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		ChainParams:  &params,
+		TimeSource:   blockchain.NewMedianTime(),
+		SigCache:     txscript.NewSigCache(1000),
+		IndexManager: indexers.NewManager(db, []indexers.Indexer{cfIndex}),
+	})
+	if err != nil {
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	// Connect blocks 1 through 4 so there are five blocks including the
+	// genesis block.
+	blocks, err := loadTestBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("unable to load blocks: %v", err)
+	}
+	hashes := []chainhash.Hash{*params.GenesisHash}
+	for _, block := range blocks[1:] {
+		_, _, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("unable to process block %v: %v", block.Hash(),
+				err)
+		}
+		hashes = append(hashes, *block.Hash())
+	}
+
+	// Request the filters for all five blocks and ensure they are returned
+	// in order and match the indexed filters.
+	msg := wire.NewMsgGetCFilters(wire.GCSFilterRegular, 0, &hashes[4])
+	filters, missing, err := cfiltersForRange(chain, cfIndex, msg)
+	if err != nil {
+		t.Fatalf("cfiltersForRange: unexpected error: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("cfiltersForRange: unexpected missing filters: %v",
+			missing)
+	}
+	if len(filters) != len(hashes) {
+		t.Fatalf("cfiltersForRange: unexpected number of filters -- "+
+			"got %d, want %d", len(filters), len(hashes))
+	}
+	for i, filter := range filters {
+		if filter.BlockHash != hashes[i] {
+			t.Fatalf("cfiltersForRange: filter #%d is for block %v, "+
+				"want %v", i, filter.BlockHash, hashes[i])
+		}
+		want, err := cfIndex.FilterByBlockHash(&hashes[i],
+			wire.GCSFilterRegular)
+		if err != nil {
+			t.Fatalf("unable to fetch filter: %v", err)
+		}
+		if len(want) == 0 || !bytes.Equal(filter.Data, want) {
+			t.Fatalf("cfiltersForRange: filter #%d does not match "+
+				"the indexed filter", i)
+		}
+	}
+
+	// Ensure requests for unknown blocks and start heights past the stop
+	// hash are rejected.
+	unknownHash := chainhash.Hash{0x01}
+	msg = wire.NewMsgGetCFilters(wire.GCSFilterRegular, 0, &unknownHash)
+	if _, _, err := cfiltersForRange(chain, cfIndex, msg); err == nil {
+		t.Fatal("cfiltersForRange: did not reject unknown stop hash")
+	}
+	msg = wire.NewMsgGetCFilters(wire.GCSFilterRegular, 3, &hashes[2])
+	if _, _, err := cfiltersForRange(chain, cfIndex, msg); err == nil {
+		t.Fatal("cfiltersForRange: did not reject start height past " +
+			"stop hash")
+	}
+}
+
+// TestRebroadcastHandler ensures locally submitted transactions that have not
+// been requested by any peers are rebroadcast once the rebroadcast interval
+// elapses and that they are no longer rebroadcast once a peer requests them.
+func TestRebroadcastHandler(t *testing.T) {
+	// Create a transaction that spends an anyone-can-spend output along with
+	// a memory pool that knows about the output.
+	params := &chaincfg.RegressionNetParams
+	fundingTx := wire.NewMsgTx(wire.TxVersion)
+	fundingTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	fundingTx.AddTxOut(wire.NewTxOut(100000, []byte{txscript.OP_TRUE}))
+	funding := btcutil.NewTx(fundingTx)
+	spendTx := wire.NewMsgTx(wire.TxVersion)
+	spendTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: *funding.Hash()},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	spendTx.AddTxOut(wire.NewTxOut(90000, []byte{txscript.OP_TRUE}))
+	spend := btcutil.NewTx(spendTx)
+
+	txPool := mempool.New(&mempool.Config{
+		Policy: mempool.Policy{
+			DisableRelayPriority: true,
+			AcceptNonStd:         true,
+			MaxTxVersion:         1,
+		},
+		ChainParams: params,
+		FetchUtxoView: func(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+			view := blockchain.NewUtxoViewpoint()
+			view.AddTxOuts(funding, 1)
+			return view, nil
+		},
+		BestHeight:     func() int32 { return 1 },
+		MedianTimePast: func() time.Time { return time.Now() },
+		CalcSequenceLock: func(*btcutil.Tx, *blockchain.UtxoViewpoint) (*blockchain.SequenceLock, error) {
+			return &blockchain.SequenceLock{Seconds: -1, BlockHeight: -1}, nil
+		},
+		IsDeploymentActive: func(uint32) (bool, error) { return false, nil },
+	})
+	_, err := txPool.ProcessTransaction(spend, false, false, 0)
+	if err != nil {
+		t.Fatalf("unable to add transaction to pool: %v", err)
+	}
+
+	s := &server{
+		txMemPool:           txPool,
+		relayInv:            make(chan relayMsg, 1),
+		quit:                make(chan struct{}),
+		rebroadcastInterval: time.Millisecond * 50,
+	}
+	iv := wire.NewInvVect(wire.InvTypeTx, spend.Hash())
+	s.AddRebroadcastInventory(iv, nil)
+
+	s.wg.Add(1)
+	go s.rebroadcastHandler()
+	defer func() {
+		close(s.quit)
+		s.wg.Wait()
+	}()
+
+	// Ensure the pending transaction is announced again after the
+	// interval elapses.
+	start := time.Now()
+	select {
+	case msg := <-s.relayInv:
+		if *msg.invVect != *iv {
+			t.Fatalf("unexpected rebroadcast inventory - got %v, "+
+				"want %v", msg.invVect, iv)
+		}
+		minDelay := s.rebroadcastInterval / 2
+		if elapsed := time.Since(start); elapsed < minDelay {
+			t.Fatalf("transaction rebroadcast after %v, before the "+
+				"minimum delay of %v", elapsed, minDelay)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for transaction rebroadcast")
+	}
+
+	// Ensure the transaction is no longer rebroadcast once a peer has
+	// requested it.  Any rebroadcast that was already underway when it was
+	// requested is ignored.
+	s.RemoveRebroadcastInventory(iv)
+	select {
+	case <-s.relayInv:
+	case <-time.After(s.rebroadcastInterval / 2):
+	}
+	select {
+	case msg := <-s.relayInv:
+		t.Fatalf("unexpected rebroadcast of %v after it was requested",
+			msg.invVect)
+	case <-time.After(s.rebroadcastInterval * 4):
+	}
+}
+
+// connectPeers creates an inbound peer with the local config and an outbound
+// peer with the remote config, connects them to each other over the loopback
+// interface, and waits for both to complete the version handshake.  The
+// inbound peer is returned first.
+func connectPeers(localCfg, remoteCfg *peer.Config) (*peer.Peer, *peer.Peer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer listener.Close()
+
+	remote, err := peer.NewOutboundPeer(remoteCfg, listener.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	localConn, err := listener.Accept()
+	if err != nil {
+		remoteConn.Close()
+		return nil, nil, err
+	}
+
+	local := peer.NewInboundPeer(localCfg)
+	local.AssociateConnection(localConn)
+	remote.AssociateConnection(remoteConn)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !local.VerAckReceived() || !remote.VerAckReceived() {
+		if time.Now().After(deadline) {
+			local.Disconnect()
+			remote.Disconnect()
+			return nil, nil, errors.New("timeout waiting for version " +
+				"handshake")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return local, remote, nil
+}
+
+// connectTestPeer returns an inbound server peer connected over the loopback
+// interface to a remote outbound peer that uses the passed listeners and
+// advertises the passed services.  The remote peer has completed the version
+// handshake when it is returned.
+func connectTestPeer(t *testing.T, s *server, listeners peer.MessageListeners, services wire.ServiceFlag) (*serverPeer, *peer.Peer) {
+	t.Helper()
+
+	local, remote, err := connectPeers(&peer.Config{
+		ChainParams:     &chaincfg.RegressionNetParams,
+		Services:        wire.SFNodeNetwork | wire.SFNodeWitness,
+		TrickleInterval: time.Millisecond * 10,
+		AllowSelfConns:  true,
+	}, &peer.Config{
+		Listeners:      listeners,
+		ChainParams:    &chaincfg.RegressionNetParams,
+		Services:       services,
+		AllowSelfConns: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to connect test peer: %v", err)
+	}
+	sp := newServerPeer(s, false)
+	sp.Peer = local
+	return sp, remote
+}
+
+// TestCmpctBlockHighBandwidthPush ensures new blocks are pushed directly as
+// compact blocks to peers that requested high-bandwidth compact block relay
+// while they are announced via inventory vectors to other peers.
+func TestCmpctBlockHighBandwidthPush(t *testing.T) {
+	blocks, err := loadTestBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("unable to load blocks: %v", err)
+	}
+	block := blocks[len(blocks)-1]
+
+	// testListeners returns listeners that deliver the compact blocks and
+	// inventory announcements a remote peer receives on the returned
+	// channels.
+	testListeners := func() (peer.MessageListeners, chan *wire.MsgCmpctBlock, chan *wire.MsgInv) {
+		cmpctBlocks := make(chan *wire.MsgCmpctBlock, 1)
+		invs := make(chan *wire.MsgInv, 1)
+		listeners := peer.MessageListeners{
+			OnCmpctBlock: func(_ *peer.Peer, msg *wire.MsgCmpctBlock) {
+				cmpctBlocks <- msg
+			},
+			OnInv: func(_ *peer.Peer, msg *wire.MsgInv) {
+				invs <- msg
+			},
+		}
+		return listeners, cmpctBlocks, invs
+	}
+
+	s := &server{}
+	services := wire.SFNodeNetwork | wire.SFNodeWitness
+	hbListeners, hbCmpctBlocks, hbInvs := testListeners()
+	hbPeer, hbRemote := connectTestPeer(t, s, hbListeners, services)
+	defer hbRemote.Disconnect()
+	lbListeners, lbCmpctBlocks, lbInvs := testListeners()
+	lbPeer, lbRemote := connectTestPeer(t, s, lbListeners, services)
+	defer lbRemote.Disconnect()
+
+	// Request high-bandwidth mode from one peer and low-bandwidth mode
+	// from the other one.
+	hbRemote.QueueMessage(wire.NewMsgSendCmpct(true, wire.CmpctBlockVersion),
+		nil)
+	lbRemote.QueueMessage(wire.NewMsgSendCmpct(false, wire.CmpctBlockVersion),
+		nil)
+	deadline := time.Now().Add(time.Second * 5)
+	for !hbPeer.WantsCmpctBlocks() || !lbPeer.SupportsCmpctBlocks() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for sendcmpct to be processed")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if lbPeer.WantsCmpctBlocks() {
+		t.Fatal("low-bandwidth peer wants compact blocks pushed")
+	}
+
+	// Relay the block as is done once it is connected and ensure the
+	// high-bandwidth peer receives it as a compact block while the other
+	// peer only receives an announcement.
+	state := &peerState{
+		inboundPeers: map[int32]*serverPeer{
+			hbPeer.ID(): hbPeer,
+			lbPeer.ID(): lbPeer,
+		},
+	}
+	iv := wire.NewInvVect(wire.InvTypeBlock, block.Hash())
+	s.handleRelayInvMsg(state, relayMsg{invVect: iv, data: block})
+
+	select {
+	case msg := <-hbCmpctBlocks:
+		if msg.Header.BlockHash() != *block.Hash() {
+			t.Fatalf("unexpected compact block - got %v, want %v",
+				msg.Header.BlockHash(), block.Hash())
+		}
+		numTxns := len(block.Transactions())
+		if msg.TxCount() != numTxns || len(msg.PrefilledTxs) != 1 {
+			t.Fatalf("unexpected compact block transactions - got "+
+				"%d with %d prefilled, want %d with 1 prefilled",
+				msg.TxCount(), len(msg.PrefilledTxs), numTxns)
+		}
+	case <-hbInvs:
+		t.Fatal("received inventory instead of compact block")
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for compact block")
+	}
+
+	select {
+	case msg := <-lbInvs:
+		if len(msg.InvList) != 1 || *msg.InvList[0] != *iv {
+			t.Fatalf("unexpected inventory - got %v, want %v",
+				msg.InvList, iv)
+		}
+	case <-lbCmpctBlocks:
+		t.Fatal("low-bandwidth peer received compact block")
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for block announcement")
+	}
+}
+
+// TestTxRelayServices ensures transactions are only relayed to peers that
+// advertise the services required for transaction relay.
+func TestTxRelayServices(t *testing.T) {
+	blocks, err := loadTestBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("unable to load blocks: %v", err)
+	}
+	tx := blocks[1].Transactions()[0]
+
+	// testListeners returns listeners that deliver the inventory
+	// announcements a remote peer receives on the returned channel.
+	testListeners := func() (peer.MessageListeners, chan *wire.MsgInv) {
+		invs := make(chan *wire.MsgInv, 1)
+		listeners := peer.MessageListeners{
+			OnInv: func(_ *peer.Peer, msg *wire.MsgInv) {
+				invs <- msg
+			},
+		}
+		return listeners, invs
+	}
+
+	s := &server{txRelayServices: wire.SFNodeBloom}
+	bloomListeners, bloomInvs := testListeners()
+	bloomPeer, bloomRemote := connectTestPeer(t, s, bloomListeners,
+		wire.SFNodeNetwork|wire.SFNodeWitness|wire.SFNodeBloom)
+	defer bloomRemote.Disconnect()
+	otherListeners, otherInvs := testListeners()
+	otherPeer, otherRemote := connectTestPeer(t, s, otherListeners,
+		wire.SFNodeNetwork|wire.SFNodeWitness)
+	defer otherRemote.Disconnect()
+
+	// Relay the transaction and ensure it is only announced to the peer
+	// that advertises the bloom filter service.
+	state := &peerState{
+		inboundPeers: map[int32]*serverPeer{
+			bloomPeer.ID(): bloomPeer,
+			otherPeer.ID(): otherPeer,
+		},
+	}
+	iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+	txD := &mempool.TxDesc{TxDesc: mining.TxDesc{Tx: tx}}
+	s.handleRelayInvMsg(state, relayMsg{invVect: iv, data: txD})
+
+	select {
+	case msg := <-bloomInvs:
+		if len(msg.InvList) != 1 || *msg.InvList[0] != *iv {
+			t.Fatalf("unexpected inventory - got %v, want %v",
+				msg.InvList, iv)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for transaction announcement")
+	}
+	select {
+	case msg := <-otherInvs:
+		t.Fatalf("transaction relayed to peer without bloom service: %v",
+			msg.InvList)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+// TestGetAddrResponse ensures inbound peers that request addresses are sent a
+// bounded, randomized sample of the known addresses and that repeated
+// requests from the same peer are ignored.
+func TestGetAddrResponse(t *testing.T) {
+	origCfg := cfg
+	cfg = &config{}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	amgr := addrmgr.New("", nil)
+	srcAddr := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
+	addrs := make([]*wire.NetAddress, 0, 8000)
+	for i := 0; i < cap(addrs); i++ {
+		ip := net.IPv4(byte(i/4096+60), byte(i/16), byte(i%16), 1)
+		addrs = append(addrs, wire.NewNetAddressIPPort(ip, 8333,
+			wire.SFNodeNetwork))
+	}
+	amgr.AddAddresses(addrs, srcAddr)
+	numAddrs := amgr.NumAddresses()
+	wantAddrs := numAddrs * 23 / 100
+	if wantAddrs > wire.MaxAddrPerMsg {
+		wantAddrs = wire.MaxAddrPerMsg
+	}
+
+	// testListeners returns listeners that deliver the address messages a
+	// remote peer receives on the returned channel.
+	testListeners := func() (peer.MessageListeners, chan *wire.MsgAddr) {
+		addrMsgs := make(chan *wire.MsgAddr, 2)
+		listeners := peer.MessageListeners{
+			OnAddr: func(_ *peer.Peer, msg *wire.MsgAddr) {
+				addrMsgs <- msg
+			},
+		}
+		return listeners, addrMsgs
+	}
+
+	// getAddrs requests addresses on behalf of the remote peer connected to
+	// the passed server peer and returns the keys of the addresses sent in
+	// response.
+	getAddrs := func(sp *serverPeer, addrMsgs chan *wire.MsgAddr) []string {
+		sp.OnGetAddr(nil, wire.NewMsgGetAddr())
+		select {
+		case msg := <-addrMsgs:
+			keys := make([]string, 0, len(msg.AddrList))
+			for _, na := range msg.AddrList {
+				keys = append(keys, addrmgr.NetAddressKey(na))
+			}
+			return keys
+		case <-time.After(time.Second * 5):
+			t.Fatal("timeout waiting for addresses")
+		}
+		return nil
+	}
+
+	s := &server{addrManager: amgr}
+	listeners, addrMsgs := testListeners()
+	sp, remote := connectTestPeer(t, s, listeners, wire.SFNodeNetwork)
+	defer remote.Disconnect()
+	otherListeners, otherAddrMsgs := testListeners()
+	otherPeer, otherRemote := connectTestPeer(t, s, otherListeners,
+		wire.SFNodeNetwork)
+	defer otherRemote.Disconnect()
+
+	// Ensure the response is limited to the smaller of the number of
+	// addresses that fit in a message and 23% of the known addresses.
+	keys := getAddrs(sp, addrMsgs)
+	if len(keys) != wantAddrs {
+		t.Fatalf("unexpected number of addresses - got %d, want %d",
+			len(keys), wantAddrs)
+	}
+
+	// Ensure a repeated request from the same peer is not answered.
+	sp.OnGetAddr(nil, wire.NewMsgGetAddr())
+	select {
+	case msg := <-addrMsgs:
+		t.Fatalf("unexpected response to repeated getaddr with %d "+
+			"addresses", len(msg.AddrList))
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// Ensure another peer is sent a different sample of the addresses.
+	otherKeys := getAddrs(otherPeer, otherAddrMsgs)
+	if reflect.DeepEqual(keys, otherKeys) {
+		t.Fatal("getaddr responses are not randomized")
+	}
+}
+
+// TestSelfAdvertise ensures a configured external address is advertised to
+// peers via addr messages, including when it was already advertised to them.
+func TestSelfAdvertise(t *testing.T) {
+	amgr := addrmgr.New("", nil)
+	external := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.100"), 8333,
+		wire.SFNodeNetwork)
+	if err := amgr.AddLocalAddress(external, addrmgr.ManualPrio); err != nil {
+		t.Fatalf("unable to add local address: %v", err)
+	}
+
+	addrMsgs := make(chan *wire.MsgAddr, 2)
+	listeners := peer.MessageListeners{
+		OnAddr: func(_ *peer.Peer, msg *wire.MsgAddr) {
+			addrMsgs <- msg
+		},
+	}
+	s := &server{addrManager: amgr}
+	sp, remote := connectTestPeer(t, s, listeners, wire.SFNodeNetwork)
+	defer remote.Disconnect()
+
+	// The loopback address of the test peer is not routable, so pretend
+	// it connected from a public address to have the external address
+	// selected for it.
+	sp.Peer.NA().IP = net.ParseIP("204.124.8.1")
+
+	for i := 0; i < 2; i++ {
+		sp.pushLocalAddress()
+		select {
+		case msg := <-addrMsgs:
+			if len(msg.AddrList) != 1 {
+				t.Fatalf("unexpected number of addresses - got %d, "+
+					"want 1", len(msg.AddrList))
+			}
+			na := msg.AddrList[0]
+			if !na.IP.Equal(external.IP) || na.Port != external.Port {
+				t.Fatalf("unexpected address - got %v:%d, want "+
+					"%v:%d", na.IP, na.Port, external.IP,
+					external.Port)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("timeout waiting for local address")
+		}
+	}
+}
+
+// TestKnownAddressTimeout ensures an address relayed to a peer is not relayed
+// to it again until the known address timeout expires.
+func TestKnownAddressTimeout(t *testing.T) {
+	addrMsgs := make(chan *wire.MsgAddr, 2)
+	listeners := peer.MessageListeners{
+		OnAddr: func(_ *peer.Peer, msg *wire.MsgAddr) {
+			addrMsgs <- msg
+		},
+	}
+	s := &server{addrManager: addrmgr.New("", nil)}
+	sp, remote := connectTestPeer(t, s, listeners, wire.SFNodeNetwork)
+	defer remote.Disconnect()
+
+	// expectAddrs ensures the remote peer receives the passed number of
+	// addresses.
+	expectAddrs := func(want int) {
+		t.Helper()
+
+		select {
+		case msg := <-addrMsgs:
+			if len(msg.AddrList) != want {
+				t.Fatalf("unexpected number of addresses - got "+
+					"%d, want %d", len(msg.AddrList), want)
+			}
+		case <-time.After(time.Millisecond * 100):
+			if want != 0 {
+				t.Fatal("timeout waiting for addresses")
+			}
+		}
+	}
+
+	na := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.100"), 8333,
+		wire.SFNodeNetwork)
+	sp.pushAddrMsg([]*wire.NetAddress{na})
+	expectAddrs(1)
+
+	// Ensure the address is suppressed while it's known to the peer.
+	sp.pushAddrMsg([]*wire.NetAddress{na})
+	expectAddrs(0)
+
+	// Ensure the address is relayed again once the timeout expired.
+	key := addrmgr.NetAddressKey(na)
+	sp.addressesMtx.Lock()
+	sp.knownAddresses[key] = time.Now().Add(-knownAddressTimeout)
+	sp.addressesMtx.Unlock()
+	sp.pushAddrMsg([]*wire.NetAddress{na})
+	expectAddrs(1)
+
+	// Ensure expired addresses are pruned from the set.
+	other := wire.NewNetAddressIPPort(net.ParseIP("204.124.8.101"), 8333,
+		wire.SFNodeNetwork)
+	sp.addressesMtx.Lock()
+	sp.knownAddresses[key] = time.Now().Add(-knownAddressTimeout)
+	sp.addrsPruned = time.Time{}
+	sp.addressesMtx.Unlock()
+	sp.addKnownAddresses([]*wire.NetAddress{other})
+	if len(sp.knownAddresses) != 1 {
+		t.Fatalf("unexpected number of known addresses - got %d, "+
+			"want 1", len(sp.knownAddresses))
+	}
+}
+
+// TestMinInboundVersion ensures inbound peers advertising a protocol version
+// below the configured minimum are rejected during the version handshake while
+// peers advertising a current version are accepted.
+func TestMinInboundVersion(t *testing.T) {
+	origCfg := cfg
+	cfg = &config{MinInboundVersion: wire.SendHeadersVersion}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	s := &server{
+		addrManager: addrmgr.New("", nil),
+		timeSource:  blockchain.NewMedianTime(),
+	}
+	sp := newServerPeer(s, false)
+	sp.Peer = peer.NewInboundPeer(&peer.Config{
+		ChainParams: &chaincfg.RegressionNetParams,
+	})
+
+	tests := []struct {
+		name       string
+		version    uint32
+		wantReject bool
+	}{
+		{"old", wire.SendHeadersVersion - 1, true},
+		{"minimum", wire.SendHeadersVersion, false},
+		{"current", wire.ProtocolVersion, false},
+	}
+	for _, test := range tests {
+		msg := wire.NewMsgVersion(wire.NewNetAddressIPPort(
+			net.ParseIP("127.0.0.1"), 8333, 0),
+			wire.NewNetAddressIPPort(net.ParseIP("127.0.0.1"), 8333,
+				0), 0, 0)
+		msg.ProtocolVersion = int32(test.version)
+		rejectMsg := sp.OnVersion(nil, msg)
+		if !test.wantReject {
+			if rejectMsg != nil {
+				t.Fatalf("%s: unexpected reject %q", test.name,
+					rejectMsg.Reason)
+			}
+			continue
+		}
+		if rejectMsg == nil {
+			t.Fatalf("%s: peer was not rejected", test.name)
+		}
+		if rejectMsg.Code != wire.RejectObsolete {
+			t.Fatalf("%s: got reject code %v, want %v", test.name,
+				rejectMsg.Code, wire.RejectObsolete)
+		}
+	}
+}
+
+// TestGetHeadersNoLocator ensures a getheaders request without any block
+// locators is treated as a request for the header of the stop hash, which is
+// served when the block is known and otherwise results in an empty response.
+func TestGetHeadersNoLocator(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+	netsync.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	cfg = &config{}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	params := chaincfg.RegressionNetParams
+	chain, _ := newTestChain(t, &params)
+
+	// Extend the chain with recent blocks so it is considered current
+	// since requests are ignored otherwise.
+	const numBlocks = 5
+	blocks := addTestBlocks(t, chain, &params,
+		time.Now().Add(-time.Minute*numBlocks), numBlocks)
+	syncManager, err := netsync.New(&netsync.Config{
+		Chain:       chain,
+		ChainParams: &params,
+		MaxPeers:    8,
+	})
+	if err != nil {
+		t.Fatalf("unable to create sync manager: %v", err)
+	}
+	syncManager.Start()
+	defer syncManager.Stop()
+
+	s := &server{chain: chain, syncManager: syncManager}
+	headersMsgs := make(chan *wire.MsgHeaders, 1)
+	sp, remote := connectTestPeer(t, s, peer.MessageListeners{
+		OnHeaders: func(_ *peer.Peer, msg *wire.MsgHeaders) {
+			headersMsgs <- msg
+		},
+	}, wire.SFNodeNetwork)
+	defer remote.Disconnect()
+
+	tests := []struct {
+		name     string
+		hashStop chainhash.Hash
+		want     []wire.BlockHeader
+	}{{
+		name:     "stop in main chain",
+		hashStop: *blocks[2].Hash(),
+		want:     []wire.BlockHeader{blocks[2].MsgBlock().Header},
+	}, {
+		name:     "stop at tip",
+		hashStop: *blocks[numBlocks-1].Hash(),
+		want: []wire.BlockHeader{
+			blocks[numBlocks-1].MsgBlock().Header,
+		},
+	}, {
+		name:     "unknown stop",
+		hashStop: chainhash.Hash{0x01},
+		want:     nil,
+	}}
+	for _, test := range tests {
+		msg := wire.NewMsgGetHeaders()
+		msg.HashStop = test.hashStop
+		sp.OnGetHeaders(sp.Peer, msg)
+
+		var got []wire.BlockHeader
+		select {
+		case reply := <-headersMsgs:
+			for _, header := range reply.Headers {
+				got = append(got, *header)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s: timeout waiting for headers", test.name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%s: got headers %v, want %v", test.name, got,
+				test.want)
+		}
+	}
+}
+
+// TestGetDataCmpctBlock ensures compact block requests for recent blocks are
+// served with compact blocks while deeper blocks are served as full blocks.
+func TestGetDataCmpctBlock(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	cfg = &config{}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	params := chaincfg.RegressionNetParams
+	chain, db := newTestChain(t, &params)
+	const numBlocks = maxCmpctBlockDepth + 1
+	blocks := addTestBlocks(t, chain, &params,
+		time.Now().Add(-time.Minute*numBlocks), numBlocks)
+
+	s := &server{chain: chain, db: db}
+	cmpctBlocks := make(chan *wire.MsgCmpctBlock, 1)
+	fullBlocks := make(chan *wire.MsgBlock, 1)
+	sp, remote := connectTestPeer(t, s, peer.MessageListeners{
+		OnCmpctBlock: func(_ *peer.Peer, msg *wire.MsgCmpctBlock) {
+			cmpctBlocks <- msg
+		},
+		OnBlock: func(_ *peer.Peer, msg *wire.MsgBlock, _ []byte) {
+			fullBlocks <- msg
+		},
+	}, wire.SFNodeNetwork|wire.SFNodeWitness)
+	defer remote.Disconnect()
+
+	// Request the tip as a compact block and ensure it is served as one.
+	tip := blocks[numBlocks-1]
+	msg := wire.NewMsgGetData()
+	msg.AddInvVect(wire.NewInvVect(wire.InvTypeCmpctBlock, tip.Hash()))
+	sp.OnGetData(sp.Peer, msg)
+	select {
+	case cmpctBlock := <-cmpctBlocks:
+		if hash := cmpctBlock.Header.BlockHash(); hash != *tip.Hash() {
+			t.Fatalf("got compact block %v, want %v", hash,
+				tip.Hash())
+		}
+	case <-fullBlocks:
+		t.Fatal("recent block was served as a full block")
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for compact block")
+	}
+
+	// Request a block deeper than the maximum compact block depth and
+	// ensure it is served as a full block.
+	deep := blocks[0]
+	msg = wire.NewMsgGetData()
+	msg.AddInvVect(wire.NewInvVect(wire.InvTypeCmpctBlock, deep.Hash()))
+	sp.OnGetData(sp.Peer, msg)
+	select {
+	case block := <-fullBlocks:
+		if hash := block.BlockHash(); hash != *deep.Hash() {
+			t.Fatalf("got block %v, want %v", hash, deep.Hash())
+		}
+	case <-cmpctBlocks:
+		t.Fatal("deep block was served as a compact block")
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for block")
+	}
+}
+
+// TestAdvertisedServices ensures the services advertised in the version
+// message sent to peers match the configured services.
+func TestAdvertisedServices(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	defer func() {
+		cfg = origCfg
+	}()
+
+	params := chaincfg.RegressionNetParams
+	chain, _ := newTestChain(t, &params)
+
+	tests := []struct {
+		name    string
+		names   []string
+		noBloom bool
+		noCF    bool
+		want    wire.ServiceFlag
+	}{{
+		name: "default",
+		want: defaultServices,
+	}, {
+		name:    "default without bloom filters",
+		noBloom: true,
+		want:    wire.SFNodeNetwork | wire.SFNodeWitness | wire.SFNodeCF,
+	}, {
+		name:  "network limited",
+		names: []string{"networklimited", "Witness"},
+		want:  wire.SFNodeNetworkLimited | wire.SFNodeWitness,
+	}, {
+		name:  "bloom and cf disabled",
+		names: []string{"network", "bloom", "cf"},
+		noCF:  true,
+		want:  wire.SFNodeNetwork | wire.SFNodeBloom,
+	}}
+	for _, test := range tests {
+		services, err := parseServices(test.names)
+		if err != nil {
+			t.Fatalf("%s: unable to parse services: %v", test.name,
+				err)
+		}
+		cfg = &config{
+			NoPeerBloomFilters: test.noBloom,
+			NoCFilters:         test.noCF,
+			HandshakeTimeout:   defaultHandshakeTimeout,
+			services:           services,
+		}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to listen: %v", err)
+		}
+		versions := make(chan wire.ServiceFlag, 1)
+		remote, err := peer.NewOutboundPeer(&peer.Config{
+			Listeners: peer.MessageListeners{
+				OnVersion: func(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgReject {
+					versions <- msg.Services
+					return nil
+				},
+			},
+			ChainParams:    &params,
+			AllowSelfConns: true,
+		}, listener.Addr().String())
+		if err != nil {
+			t.Fatalf("unable to create outbound peer: %v", err)
+		}
+		remoteConn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("unable to dial: %v", err)
+		}
+		localConn, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("unable to accept: %v", err)
+		}
+		listener.Close()
+
+		s := &server{
+			chainParams: &params,
+			addrManager: addrmgr.New("", nil),
+			chain:       chain,
+			timeSource:  blockchain.NewMedianTime(),
+			services:    advertisedServices(),
+		}
+		sp := newServerPeer(s, false)
+		peerCfg := newPeerConfig(sp)
+		peerCfg.AllowSelfConns = true
+		sp.Peer = peer.NewInboundPeer(peerCfg)
+		sp.AssociateConnection(localConn)
+		remote.AssociateConnection(remoteConn)
+
+		select {
+		case got := <-versions:
+			if got != test.want {
+				t.Errorf("%s: unexpected services - got %v, "+
+					"want %v", test.name, got, test.want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s: timeout waiting for version", test.name)
+		}
+		remote.Disconnect()
+		sp.Disconnect()
+	}
+
+	// Ensure unknown services are rejected.
+	if _, err := parseServices([]string{"network", "xthin"}); err == nil {
+		t.Fatal("parseServices accepted an unknown service")
+	}
+}
+
+// TestNoRelayPeerTxInv ensures transactions announced by block-relay-only peers
+// are ignored without requesting them or disconnecting the peer while the
+// same announcement from other peers is requested.
+func TestNoRelayPeerTxInv(t *testing.T) {
+	blockchain.UseLogger(btclog.Disabled)
+	netsync.UseLogger(btclog.Disabled)
+
+	origCfg := cfg
+	cfg = &config{NoRelayPeers: []string{"127.0.0.1"}}
+	cfg.noRelayPeers = []*net.IPNet{parseIPNet(cfg.NoRelayPeers[0])}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	// Ensure the configured networks are matched against peer addresses.
+	localAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8333}
+	if !isNoRelayPeer(localAddr) {
+		t.Fatal("configured peer is not block-relay-only")
+	}
+	otherAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 8333}
+	if isNoRelayPeer(otherAddr) {
+		t.Fatal("unconfigured peer is block-relay-only")
+	}
+
+	params := chaincfg.RegressionNetParams
+	chain, _ := newTestChain(t, &params)
+
+	// Extend the chain with recent blocks so it is considered current
+	// since announcements are ignored otherwise.
+	addTestBlocks(t, chain, &params, time.Now().Add(-time.Minute*5), 5)
+	txPool := mempool.New(&mempool.Config{
+		ChainParams:    &params,
+		FetchUtxoView:  chain.FetchUtxoView,
+		BestHeight:     func() int32 { return chain.BestSnapshot().Height },
+		MedianTimePast: time.Now,
+	})
+	syncManager, err := netsync.New(&netsync.Config{
+		Chain:       chain,
+		TxMemPool:   txPool,
+		ChainParams: &params,
+		MaxPeers:    8,
+	})
+	if err != nil {
+		t.Fatalf("unable to create sync manager: %v", err)
+	}
+	syncManager.Start()
+	defer syncManager.Stop()
+
+	// connectPeer returns a server peer registered with the sync manager
+	// along with a channel the getdata messages its remote peer receives
+	// are delivered on.
+	s := &server{chain: chain, syncManager: syncManager}
+	connectPeer := func(noRelay bool) (*serverPeer, chan *wire.MsgGetData) {
+		getDataMsgs := make(chan *wire.MsgGetData, 1)
+		sp, remote := connectTestPeer(t, s, peer.MessageListeners{
+			OnGetData: func(_ *peer.Peer, msg *wire.MsgGetData) {
+				getDataMsgs <- msg
+			},
+		}, wire.SFNodeNetwork|wire.SFNodeWitness)
+		t.Cleanup(remote.Disconnect)
+		sp.noRelay = noRelay
+		syncManager.NewPeer(sp.Peer)
+		return sp, getDataMsgs
+	}
+	noRelayPeer, noRelayGetData := connectPeer(true)
+	relayPeer, relayGetData := connectPeer(false)
+
+	// Ensure the transaction announced by the block-relay-only peer is
+	// ignored without disconnecting it.
+	inv := wire.NewMsgInv()
+	inv.AddInvVect(wire.NewInvVect(wire.InvTypeTx, &chainhash.Hash{0x01}))
+	noRelayPeer.OnInv(nil, inv)
+	select {
+	case msg := <-noRelayGetData:
+		t.Fatalf("transaction requested from block-relay-only peer: %v",
+			msg.InvList)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if !noRelayPeer.Connected() {
+		t.Fatal("block-relay-only peer was disconnected")
+	}
+
+	// Ensure the same announcement from another peer is requested.
+	relayPeer.OnInv(nil, inv)
+	select {
+	case msg := <-relayGetData:
+		if len(msg.InvList) != 1 ||
+			msg.InvList[0].Hash != inv.InvList[0].Hash {
+
+			t.Fatalf("unexpected getdata %v", msg.InvList)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for transaction request")
+	}
+}
+
+// TestHeadersProofOfWork ensures headers which do not satisfy their own proof
+// of work are dropped before they reach the sync manager and that the ban score
+// of the peer sending them is increased.
+func TestHeadersProofOfWork(t *testing.T) {
+	// Misbehaving peers are logged, so disable the logger which has no
+	// backend in tests.
+	defer func(log btclog.Logger) { peerLog = log }(peerLog)
+	peerLog = btclog.Disabled
+
+	origCfg := cfg
+	cfg = &config{BanThreshold: defaultBanThreshold}
+	defer func() {
+		cfg = origCfg
+	}()
+
+	// A header hashing above its target and a header which meets its
+	// target, but claims one above the proof of work limit.
+	highHash := chaincfg.MainNetParams.GenesisBlock.Header
+	highHash.Nonce++
+	highTarget := chaincfg.RegressionNetParams.GenesisBlock.Header
+
+	tests := []struct {
+		name   string
+		header wire.BlockHeader
+	}{
+		{"hash above target", highHash},
+		{"target above limit", highTarget},
+	}
+	for _, test := range tests {
+		// The sync manager is intentionally not set so queueing the
+		// headers would panic.
+		s := &server{chainParams: &chaincfg.MainNetParams}
+		sp := newServerPeer(s, false)
+		sp.Peer = peer.NewInboundPeer(&peer.Config{
+			ChainParams: &chaincfg.MainNetParams,
+		})
+
+		msg := wire.NewMsgHeaders()
+		genesis := chaincfg.MainNetParams.GenesisBlock.Header
+		if err := msg.AddBlockHeader(&genesis); err != nil {
+			t.Fatalf("%s: unable to add header: %v", test.name, err)
+		}
+		header := test.header
+		if err := msg.AddBlockHeader(&header); err != nil {
+			t.Fatalf("%s: unable to add header: %v", test.name, err)
+		}
+		sp.OnHeaders(sp.Peer, msg)
+
+		if score := sp.banScore.Int(); score != 100 {
+			t.Fatalf("%s: unexpected ban score -- got %d, want 100",
+				test.name, score)
+		}
+	}
+}
@@ -136,6 +136,36 @@ type Engine struct {
 	witnessVersion  int
 	witnessProgram  []byte
 	inputAmount     int64
+
+	// The following fields are used when the engine is run in metered mode
+	// to enforce limits that are stricter than the consensus rules.
+	maxSigOps     int // max executed signature operations, 0 for no limit
+	maxStackDepth int // max combined stack depth, 0 for no limit
+	numSigOps     int // number of signature operations executed
+}
+
+// SetExecutionLimits enables metered execution, which causes the engine to
+// fail with ErrExecutionLimit when the scripts execute more than the passed
+// number of signature operations or when the combined depth of the data and
+// alt stacks exceeds the passed maximum.  A value of zero disables the
+// respective limit.  These limits are not part of the consensus rules and are
+// intended for enforcing standardness policy.  It must be called before the
+// scripts are executed.
+func (vm *Engine) SetExecutionLimits(maxSigOps, maxStackDepth int) {
+	vm.maxSigOps = maxSigOps
+	vm.maxStackDepth = maxStackDepth
+}
+
+// addSigOps accounts for the passed number of executed signature operations
+// and returns an error when the limit set for metered execution is exceeded.
+func (vm *Engine) addSigOps(numSigOps int) error {
+	vm.numSigOps += numSigOps
+	if vm.maxSigOps > 0 && vm.numSigOps > vm.maxSigOps {
+		str := fmt.Sprintf("executed signature operations %d > max "+
+			"allowed %d", vm.numSigOps, vm.maxSigOps)
+		return scriptError(ErrExecutionLimit, str)
+	}
+	return nil
 }
 
 // hasFlag returns whether the script engine instance has the passed flag set.
@@ -464,6 +494,12 @@ func (vm *Engine) Step() (done bool, err error) {
 			combinedStackSize, MaxStackSize)
 		return false, scriptError(ErrStackOverflow, str)
 	}
+	if vm.maxStackDepth > 0 && int(combinedStackSize) > vm.maxStackDepth {
+		str := fmt.Sprintf("combined stack size %d > max allowed %d "+
+			"for metered execution", combinedStackSize,
+			vm.maxStackDepth)
+		return false, scriptError(ErrExecutionLimit, str)
+	}
 
 	// Prepare for next instruction.
 	if vm.scriptOff >= len(vm.scripts[vm.scriptIdx]) {
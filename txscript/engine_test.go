@@ -195,6 +195,77 @@ func TestInvalidFlagCombinations(t *testing.T) {
 	}
 }
 
+// TestExecutionLimits ensures scripts that exceed the limits set for metered
+// execution fail with ErrExecutionLimit while those within them succeed.
+func TestExecutionLimits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		script        string
+		maxSigOps     int
+		maxStackDepth int
+		wantErr       bool
+	}{{
+		name:      "checksigs within limit",
+		script:    "0 0 CHECKSIG DROP 0 0 CHECKSIG DROP TRUE",
+		maxSigOps: 2,
+	}, {
+		name:      "checksigs exceed limit",
+		script:    "0 0 CHECKSIG DROP 0 0 CHECKSIG DROP TRUE",
+		maxSigOps: 1,
+		wantErr:   true,
+	}, {
+		name:   "checksigs without limit",
+		script: "0 0 CHECKSIG DROP 0 0 CHECKSIG DROP TRUE",
+	}, {
+		name:      "multisig pubkeys count towards limit",
+		script:    "0 0 1 1 2 CHECKMULTISIG DROP 0 0 CHECKSIG DROP TRUE",
+		maxSigOps: 2,
+		wantErr:   true,
+	}, {
+		name:          "stack depth within limit",
+		script:        "1 1 1 1 1 DROP DROP DROP DROP",
+		maxStackDepth: 5,
+	}, {
+		name:          "stack depth exceeds limit",
+		script:        "1 1 1 1 1 DROP DROP DROP DROP",
+		maxStackDepth: 4,
+		wantErr:       true,
+	}}
+
+	tx := &wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Index: 0},
+			Sequence:         wire.MaxTxInSequenceNum,
+		}},
+		TxOut: []*wire.TxOut{{Value: 1000000000}},
+	}
+
+	for _, test := range tests {
+		pkScript := mustParseShortForm(test.script)
+		vm, err := NewEngine(pkScript, tx, 0, 0, nil, nil, 0)
+		if err != nil {
+			t.Errorf("%s: failed to create engine: %v", test.name, err)
+			continue
+		}
+		vm.SetExecutionLimits(test.maxSigOps, test.maxStackDepth)
+
+		err = vm.Execute()
+		if test.wantErr {
+			if !IsErrorCode(err, ErrExecutionLimit) {
+				t.Errorf("%s: unexpected error -- got %v, want %v",
+					test.name, err, ErrExecutionLimit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
 // TestCheckPubKeyEncoding ensures the internal checkPubKeyEncoding function
 // works as expected.
 func TestCheckPubKeyEncoding(t *testing.T) {
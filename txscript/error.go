@@ -338,6 +338,15 @@ const (
 	// serialized in a compressed format.
 	ErrWitnessPubKeyType
 
+	// ---------------------------------------------
+	// Failures related to metered script execution.
+	// ---------------------------------------------
+
+	// ErrExecutionLimit is returned when execution limits have been set on
+	// the engine and the script executes more signature operations or
+	// grows the stack deeper than allowed.
+	ErrExecutionLimit
+
 	// numErrorCodes is the maximum error code number used in tests.  This
 	// entry MUST be the last entry in the enum.
 	numErrorCodes
@@ -409,6 +418,7 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrMinimalIf:                          "ErrMinimalIf",
 	ErrWitnessPubKeyType:                  "ErrWitnessPubKeyType",
 	ErrDiscourageUpgradableWitnessProgram: "ErrDiscourageUpgradableWitnessProgram",
+	ErrExecutionLimit:                     "ErrExecutionLimit",
 }
 
 // String returns the ErrorCode as a human-readable name.
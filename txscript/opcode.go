@@ -2116,6 +2116,10 @@ func opcodeCodeSeparator(op *parsedOpcode, vm *Engine) error {
 //
 // Stack transformation: [... signature pubkey] -> [... bool]
 func opcodeCheckSig(op *parsedOpcode, vm *Engine) error {
+	if err := vm.addSigOps(1); err != nil {
+		return err
+	}
+
 	pkBytes, err := vm.dstack.PopByteArray()
 	if err != nil {
 		return err
@@ -2290,6 +2294,9 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 			MaxOpsPerScript)
 		return scriptError(ErrTooManyOperations, str)
 	}
+	if err := vm.addSigOps(numPubKeys); err != nil {
+		return err
+	}
 
 	pubKeys := make([][]byte, 0, numPubKeys)
 	for i := 0; i < numPubKeys; i++ {
@@ -0,0 +1,118 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// maxCachedScriptLen is the maximum length of the public key scripts that are
+// cached.  It is the length of a pay-to-pubkey script with an uncompressed
+// public key, the longest of the standard single key and script hash scripts,
+// so the memory used by the cache stays bounded by its number of entries.
+const maxCachedScriptLen = 67
+
+// scriptClassCacheEntry represents an entry in the ScriptClassCache.  Entries
+// are keyed by the public key script they classify.  The network is recorded
+// since the extracted addresses encode it.
+type scriptClassCacheEntry struct {
+	net          wire.BitcoinNet
+	class        ScriptClass
+	addrs        []btcutil.Address
+	requiredSigs int
+}
+
+// ScriptClassCache implements a cache of the results of ExtractPkScriptAddrs
+// with a randomized entry eviction policy.  Bulk operations such as indexing
+// blocks or decoding the outputs of many transactions frequently encounter
+// the same public key scripts, for example outputs paying to the same
+// address, and the cache avoids parsing and classifying those scripts again.
+//
+// Only scripts of a recognized form other than null data which are at most
+// maxCachedScriptLen bytes long are cached.  Other scripts are rarely seen
+// again, so they are classified every time instead.
+type ScriptClassCache struct {
+	sync.RWMutex
+	entries    map[string]scriptClassCacheEntry
+	maxEntries uint
+}
+
+// NewScriptClassCache creates and initializes a new instance of
+// ScriptClassCache.  Its sole parameter 'maxEntries' represents the maximum
+// number of entries allowed to exist in the cache at any particular moment.
+// Random entries are evicted to make room for new entries that would cause
+// the number of entries in the cache to exceed the max.
+func NewScriptClassCache(maxEntries uint) *ScriptClassCache {
+	return &ScriptClassCache{
+		entries:    make(map[string]scriptClassCacheEntry, maxEntries),
+		maxEntries: maxEntries,
+	}
+}
+
+// ExtractPkScriptAddrs returns the same results as the package-level
+// ExtractPkScriptAddrs function for the passed public key script, from the
+// cache when the script has been classified before.  The returned addresses
+// are shared with the cache and must not be modified.  A nil cache simply
+// classifies the script.
+//
+// NOTE: This function is safe for concurrent access.
+func (c *ScriptClassCache) ExtractPkScriptAddrs(pkScript []byte,
+	chainParams *chaincfg.Params) (ScriptClass, []btcutil.Address, int, error) {
+
+	if c == nil {
+		return ExtractPkScriptAddrs(pkScript, chainParams)
+	}
+
+	c.RLock()
+	entry, ok := c.entries[string(pkScript)]
+	c.RUnlock()
+	if ok && entry.net == chainParams.Net {
+		return entry.class, entry.addrs, entry.requiredSigs, nil
+	}
+
+	class, addrs, requiredSigs, err := ExtractPkScriptAddrs(pkScript,
+		chainParams)
+	if err != nil || class == NonStandardTy || class == NullDataTy ||
+		len(pkScript) > maxCachedScriptLen {
+
+		return class, addrs, requiredSigs, err
+	}
+	c.add(pkScript, scriptClassCacheEntry{
+		net:          chainParams.Net,
+		class:        class,
+		addrs:        addrs,
+		requiredSigs: requiredSigs,
+	})
+	return class, addrs, requiredSigs, nil
+}
+
+// add adds the passed entry for the public key script to the cache.  In the
+// event that the cache is 'full', an existing entry is randomly chosen to be
+// evicted in order to make space for the new entry.
+//
+// NOTE: This function is safe for concurrent access.
+func (c *ScriptClassCache) add(pkScript []byte, entry scriptClassCacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	// If adding this new entry will put us over the max number of allowed
+	// entries, then evict an entry relying on the random starting point
+	// of Go's map iteration.
+	if uint(len(c.entries)+1) > c.maxEntries {
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+	c.entries[string(pkScript)] = entry
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// TestScriptClassCache ensures the script class cache returns the same results
+// as classifying scripts directly, keeps the number of entries bounded, only
+// caches short scripts of a recognized form, and does not return addresses for
+// other networks.
+func TestScriptClassCache(t *testing.T) {
+	t.Parallel()
+
+	const cacheSize = 10
+	cache := NewScriptClassCache(cacheSize)
+	for i := 0; i < cacheSize*2; i++ {
+		pkScript := mustParseShortForm("DUP HASH160 DATA_20 0x" +
+			hexPad(i) + " EQUALVERIFY CHECKSIG")
+		class, addrs, reqSigs, err := ExtractPkScriptAddrs(pkScript,
+			&chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Ensure both the first lookup and the cached one return the
+		// same results as classifying the script directly.
+		for j := 0; j < 2; j++ {
+			gotClass, gotAddrs, gotReqSigs, err :=
+				cache.ExtractPkScriptAddrs(pkScript,
+					&chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotClass != class || gotReqSigs != reqSigs ||
+				!reflect.DeepEqual(gotAddrs, addrs) {
+
+				t.Fatalf("script %d: got %v %v %d, want %v %v %d",
+					i, gotClass, gotAddrs, gotReqSigs, class,
+					addrs, reqSigs)
+			}
+		}
+		if len(cache.entries) > cacheSize {
+			t.Fatalf("cache has %d entries, max is %d",
+				len(cache.entries), cacheSize)
+		}
+	}
+
+	// Ensure addresses of a script cached for one network are not
+	// returned for another.
+	pkScript := mustParseShortForm("DUP HASH160 DATA_20 0x" + hexPad(0) +
+		" EQUALVERIFY CHECKSIG")
+	_, mainAddrs, _, _ := cache.ExtractPkScriptAddrs(pkScript,
+		&chaincfg.MainNetParams)
+	_, testAddrs, _, _ := cache.ExtractPkScriptAddrs(pkScript,
+		&chaincfg.TestNet3Params)
+	if mainAddrs[0].String() == testAddrs[0].String() {
+		t.Fatalf("got main network address %v for the test network",
+			testAddrs[0])
+	}
+
+	// Ensure nonstandard, null data and long scripts are not cached.
+	uncached := NewScriptClassCache(cacheSize)
+	pubKey := "DATA_33 0x02" +
+		hex.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	for _, short := range []string{
+		"TRUE",
+		"RETURN DATA_4 0x01020304",
+		"1 " + pubKey + " " + pubKey + " 2 CHECKMULTISIG",
+	} {
+		_, _, _, err := uncached.ExtractPkScriptAddrs(
+			mustParseShortForm(short), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(uncached.entries) != 0 {
+		t.Fatalf("cache has %d entries, want none",
+			len(uncached.entries))
+	}
+
+	// Ensure a nil cache classifies scripts.
+	var nilCache *ScriptClassCache
+	class, _, _, _ := nilCache.ExtractPkScriptAddrs(pkScript,
+		&chaincfg.MainNetParams)
+	if class != PubKeyHashTy {
+		t.Fatalf("nil cache: got class %v, want %v", class, PubKeyHashTy)
+	}
+}
+
+// hexPad returns the passed number as a hex-encoded 20 byte string for use as
+// a distinct pubkey hash.
+func hexPad(i int) string {
+	return hex.EncodeToString(bytes.Repeat([]byte{byte(i)}, 20))
+}
+
+// benchmarkExtractPkScriptAddrs benchmarks classifying the outputs of a block
+// which all pay to the same address as is done when indexing them with the
+// passed classification function.
+func benchmarkExtractPkScriptAddrs(b *testing.B, extract func([]byte,
+	*chaincfg.Params) (ScriptClass, error)) {
+
+	const numOutputs = 1000
+	pkScript := mustParseShortForm("DUP HASH160 DATA_20 0x" + hexPad(1) +
+		" EQUALVERIFY CHECKSIG")
+	outputs := make([][]byte, numOutputs)
+	for i := range outputs {
+		outputs[i] = append([]byte(nil), pkScript...)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, output := range outputs {
+			_, err := extract(output, &chaincfg.MainNetParams)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkExtractPkScriptAddrs benchmarks classifying many outputs paying to
+// the same address without a cache.
+func BenchmarkExtractPkScriptAddrs(b *testing.B) {
+	benchmarkExtractPkScriptAddrs(b, func(pkScript []byte,
+		params *chaincfg.Params) (ScriptClass, error) {
+
+		class, _, _, err := ExtractPkScriptAddrs(pkScript, params)
+		return class, err
+	})
+}
+
+// BenchmarkScriptClassCache benchmarks classifying many outputs paying to the
+// same address with a script class cache.
+func BenchmarkScriptClassCache(b *testing.B) {
+	cache := NewScriptClassCache(100)
+	benchmarkExtractPkScriptAddrs(b, func(pkScript []byte,
+		params *chaincfg.Params) (ScriptClass, error) {
+
+		class, _, _, err := cache.ExtractPkScriptAddrs(pkScript, params)
+		return class, err
+	})
+}
@@ -22,6 +22,19 @@ const (
 	// binaryFreeListMaxItems is the number of buffers to keep in the free
 	// list to use for binary serialization and deserialization.
 	binaryFreeListMaxItems = 1024
+
+	// maxPreallocBytes is the maximum number of bytes of variable length
+	// data that are allocated up front based on a length read from the
+	// wire.  Longer data is allocated in chunks of this size as it is read,
+	// so a forged length can't cause large allocations without the data
+	// actually being sent.
+	maxPreallocBytes = 1 << 16
+
+	// maxPreallocElements is the maximum number of elements of a list that
+	// are allocated up front based on a count read from the wire.  Longer
+	// lists are allocated in chunks of this size as their elements are
+	// read for the same reason.
+	maxPreallocElements = 1024
 )
 
 var (
@@ -607,8 +620,7 @@ func ReadVarString(r io.Reader, pver uint32) (string, error) {
 		return "", messageError("ReadVarString", str)
 	}
 
-	buf := make([]byte, count)
-	_, err = io.ReadFull(r, buf)
+	buf, err := readBytes(r, count)
 	if err != nil {
 		return "", err
 	}
@@ -651,12 +663,7 @@ func ReadVarBytes(r io.Reader, pver uint32, maxAllowed uint32,
 		return nil, messageError("ReadVarBytes", str)
 	}
 
-	b := make([]byte, count)
-	_, err = io.ReadFull(r, b)
-	if err != nil {
-		return nil, err
-	}
-	return b, nil
+	return readBytes(r, count)
 }
 
 // WriteVarBytes serializes a variable length byte array to w as a varInt
@@ -672,6 +679,51 @@ func WriteVarBytes(w io.Writer, pver uint32, bytes []byte) error {
 	return err
 }
 
+// preallocCount returns the number of elements to allocate up front for a list
+// with the passed number of elements read from the wire.
+func preallocCount(count uint64) uint64 {
+	if count > maxPreallocElements {
+		return maxPreallocElements
+	}
+	return count
+}
+
+// readBytes reads the passed number of bytes from r.  Up to maxPreallocBytes
+// are allocated at a time so the amount of memory allocated is bounded by the
+// number of bytes that are actually read.
+func readBytes(r io.Reader, count uint64) ([]byte, error) {
+	b, _, err := readBytesN(r, count)
+	return b, err
+}
+
+// readBytesN is identical to readBytes except it also returns the number of
+// bytes read, including when an error is returned.
+func readBytesN(r io.Reader, count uint64) ([]byte, int, error) {
+	if count <= maxPreallocBytes {
+		b := make([]byte, count)
+		n, err := io.ReadFull(r, b)
+		if err != nil {
+			return nil, n, err
+		}
+		return b, n, nil
+	}
+
+	b := make([]byte, 0, maxPreallocBytes)
+	for uint64(len(b)) < count {
+		n := count - uint64(len(b))
+		if n > maxPreallocBytes {
+			n = maxPreallocBytes
+		}
+		offset := len(b)
+		b = append(b, make([]byte, n)...)
+		read, err := io.ReadFull(r, b[offset:])
+		if err != nil {
+			return nil, offset + read, err
+		}
+	}
+	return b, len(b), nil
+}
+
 // randomUint64 returns a cryptographically random uint64 value.  This
 // unexported version takes a reader primarily to ensure the error paths
 // can be properly tested by passing a fake reader in the tests.
@@ -8,7 +8,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math/rand"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -757,3 +759,227 @@ func TestRandomUint64Errors(t *testing.T) {
 		t.Errorf("Nonce is not 0 [%v]", nonce)
 	}
 }
+
+// decodeAllocBytes returns the number of bytes allocated while running the
+// passed decode function along with the error it returns.
+func decodeAllocBytes(decode func() error) (uint64, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	err := decode()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc, err
+}
+
+// TestDecodeForgedCounts ensures decoding messages with forged counts and
+// lengths that claim far more data than is actually sent fails with an error
+// without allocating memory in proportion to the forged values.
+func TestDecodeForgedCounts(t *testing.T) {
+	// maxAlloc is the maximum number of bytes decoding any of the payloads
+	// may allocate.  It is well above the preallocation limits, but far
+	// below what any of the forged counts would require.
+	const maxAlloc = 1 << 20
+
+	// payload returns the concatenation of the passed byte slices and
+	// variable length integers.
+	payload := func(parts ...interface{}) []byte {
+		var buf bytes.Buffer
+		for _, part := range parts {
+			switch part := part.(type) {
+			case []byte:
+				buf.Write(part)
+			case int:
+				WriteVarInt(&buf, 0, uint64(part))
+			}
+		}
+		return buf.Bytes()
+	}
+	version := []byte{0x01, 0x00, 0x00, 0x00}
+	outPoint := make([]byte, chainhash.HashSize+4)
+	sequence := []byte{0xff, 0xff, 0xff, 0xff}
+	header := make([]byte, MaxBlockHeaderPayload)
+
+	tests := []struct {
+		name       string
+		msg        Message
+		enc        MessageEncoding
+		payload    []byte
+		wantMsgErr bool
+	}{
+		{
+			name:    "tx inputs",
+			msg:     &MsgTx{},
+			enc:     BaseEncoding,
+			payload: payload(version, maxTxInPerMessage),
+		},
+		{
+			name:       "tx too many inputs",
+			msg:        &MsgTx{},
+			enc:        BaseEncoding,
+			payload:    payload(version, maxTxInPerMessage+1),
+			wantMsgErr: true,
+		},
+		{
+			name:    "tx outputs",
+			msg:     &MsgTx{},
+			enc:     BaseEncoding,
+			payload: payload(version, 0, maxTxOutPerMessage),
+		},
+		{
+			name:       "tx too many outputs",
+			msg:        &MsgTx{},
+			enc:        BaseEncoding,
+			payload:    payload(version, 0, maxTxOutPerMessage+1),
+			wantMsgErr: true,
+		},
+		{
+			name:    "tx signature script",
+			msg:     &MsgTx{},
+			enc:     BaseEncoding,
+			payload: payload(version, 1, outPoint, MaxMessagePayload),
+		},
+		{
+			name: "tx witness items",
+			msg:  &MsgTx{},
+			enc:  WitnessEncoding,
+			payload: payload(version, []byte{TxFlagMarker, WitnessFlag},
+				1, outPoint, 0, sequence, 0,
+				maxWitnessItemsPerInput),
+		},
+		{
+			name: "tx too many witness items",
+			msg:  &MsgTx{},
+			enc:  WitnessEncoding,
+			payload: payload(version, []byte{TxFlagMarker, WitnessFlag},
+				1, outPoint, 0, sequence, 0,
+				maxWitnessItemsPerInput+1),
+			wantMsgErr: true,
+		},
+		{
+			name:    "block transactions",
+			msg:     &MsgBlock{},
+			enc:     WitnessEncoding,
+			payload: payload(header, maxTxPerBlock),
+		},
+		{
+			name: "merkle block hashes",
+			msg:  &MsgMerkleBlock{},
+			enc:  BaseEncoding,
+			payload: payload(header, []byte{0x01, 0x00, 0x00, 0x00},
+				maxTxPerBlock),
+		},
+		{
+			name:    "compact block short ids",
+			msg:     &MsgCmpctBlock{},
+			enc:     WitnessEncoding,
+			payload: payload(header, make([]byte, 8), maxTxPerBlock),
+		},
+		{
+			name:    "compact block prefilled transactions",
+			msg:     &MsgCmpctBlock{},
+			enc:     WitnessEncoding,
+			payload: payload(header, make([]byte, 8), 0, maxTxPerBlock),
+		},
+		{
+			name:    "block transactions response",
+			msg:     &MsgBlockTxn{},
+			enc:     WitnessEncoding,
+			payload: payload(make([]byte, chainhash.HashSize), maxTxPerBlock),
+		},
+		{
+			name:    "variable length string",
+			msg:     &MsgReject{},
+			enc:     BaseEncoding,
+			payload: payload(MaxMessagePayload),
+		},
+		{
+			name:       "too many inventory vectors",
+			msg:        &MsgInv{},
+			enc:        BaseEncoding,
+			payload:    payload(MaxInvPerMsg + 1),
+			wantMsgErr: true,
+		},
+		{
+			name:       "too many addresses",
+			msg:        &MsgAddr{},
+			enc:        BaseEncoding,
+			payload:    payload(MaxAddrPerMsg + 1),
+			wantMsgErr: true,
+		},
+		{
+			name:       "too many headers",
+			msg:        &MsgHeaders{},
+			enc:        BaseEncoding,
+			payload:    payload(MaxBlockHeadersPerMsg + 1),
+			wantMsgErr: true,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for _, test := range tests {
+		allocated, err := decodeAllocBytes(func() error {
+			r := bytes.NewReader(test.payload)
+			return test.msg.BtcDecode(r, ProtocolVersion, test.enc)
+		})
+		if _, ok := err.(*MessageError); test.wantMsgErr && !ok {
+			t.Errorf("%s: unexpected error - got %v, want "+
+				"MessageError", test.name, err)
+		}
+		if !test.wantMsgErr && err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: unexpected error - got %v, want EOF",
+				test.name, err)
+		}
+		if allocated > maxAlloc {
+			t.Errorf("%s: allocated %d bytes, want at most %d",
+				test.name, allocated, maxAlloc)
+		}
+	}
+
+	// Decode messages whose counts and trailing data are random and
+	// ensure they never allocate more than the limit regardless of whether
+	// or not they decode.
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < 200; i++ {
+		test := tests[rng.Intn(len(tests))]
+		var buf bytes.Buffer
+		prefixLen := len(test.payload) - VarIntSerializeSize(
+			uint64(maxTxPerBlock))
+		if prefixLen < 0 {
+			prefixLen = 0
+		}
+		buf.Write(test.payload[:prefixLen])
+		WriteVarInt(&buf, 0, rng.Uint64()%(1<<26))
+		tail := make([]byte, rng.Intn(128))
+		rng.Read(tail)
+		buf.Write(tail)
+
+		allocated, _ := decodeAllocBytes(func() error {
+			r := bytes.NewReader(buf.Bytes())
+			return test.msg.BtcDecode(r, ProtocolVersion, test.enc)
+		})
+		if allocated > maxAlloc {
+			t.Fatalf("%s: random payload %x allocated %d bytes, "+
+				"want at most %d", test.name, buf.Bytes(),
+				allocated, maxAlloc)
+		}
+	}
+}
+
+// TestReadBytesChunks ensures variable length data that is longer than the
+// preallocation limit is read in full.
+func TestReadBytesChunks(t *testing.T) {
+	data := make([]byte, maxPreallocBytes*2+1)
+	rand.New(rand.NewSource(0)).Read(data)
+
+	var buf bytes.Buffer
+	if err := WriteVarBytes(&buf, 0, data); err != nil {
+		t.Fatalf("WriteVarBytes: unexpected error: %v", err)
+	}
+	got, err := ReadVarBytes(&buf, 0, MaxMessagePayload, "test data")
+	if err != nil {
+		t.Fatalf("ReadVarBytes: unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("ReadVarBytes: data mismatch")
+	}
+}
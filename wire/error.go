@@ -15,9 +15,14 @@ import (
 // This provides a mechanism for the caller to type assert the error to
 // differentiate between general io errors such as io.EOF and issues that
 // resulted from malformed messages.
+//
+// PayloadUnread is set when the payload of the offending message was left
+// unread, in which case the reader is no longer positioned at the start of a
+// message and the connection can't be used to read further messages.
 type MessageError struct {
-	Func        string // Function name
-	Description string // Human readable description of the issue
+	Func          string // Function name
+	Description   string // Human readable description of the issue
+	PayloadUnread bool   // Whether the payload was left unread
 }
 
 // Error satisfies the error interface and prints human-readable errors.
@@ -33,9 +33,19 @@ const (
 	InvTypeTx                   InvType = 1
 	InvTypeBlock                InvType = 2
 	InvTypeFilteredBlock        InvType = 3
+	InvTypeCmpctBlock           InvType = 4
 	InvTypeWitnessBlock         InvType = InvTypeBlock | InvWitnessFlag
 	InvTypeWitnessTx            InvType = InvTypeTx | InvWitnessFlag
 	InvTypeFilteredWitnessBlock InvType = InvTypeFilteredBlock | InvWitnessFlag
+
+	// InvTypeCFilter identifies the committed filter of the block with the
+	// given hash.
+	//
+	// NOTE: This is a local-only type which is not defined by any BIP, so
+	// other implementations do not know it and a future BIP may assign the
+	// value to something else.  It is only used in notfound replies to
+	// getcfilters requests and must not be used in inv or getdata messages.
+	InvTypeCFilter InvType = 8
 )
 
 // Map of service flags back to their constant names for pretty printing.
@@ -44,9 +54,11 @@ var ivStrings = map[InvType]string{
 	InvTypeTx:                   "MSG_TX",
 	InvTypeBlock:                "MSG_BLOCK",
 	InvTypeFilteredBlock:        "MSG_FILTERED_BLOCK",
+	InvTypeCmpctBlock:           "MSG_CMPCT_BLOCK",
 	InvTypeWitnessBlock:         "MSG_WITNESS_BLOCK",
 	InvTypeWitnessTx:            "MSG_WITNESS_TX",
 	InvTypeFilteredWitnessBlock: "MSG_FILTERED_WITNESS_BLOCK",
+	InvTypeCFilter:              "MSG_CFILTER",
 }
 
 // String returns the InvType in human-readable form.
@@ -58,6 +58,10 @@ const (
 	CmdCFHeaders    = "cfheaders"
 	CmdCFCheckpt    = "cfcheckpt"
 	CmdSendAddrV2   = "sendaddrv2"
+	CmdSendCmpct    = "sendcmpct"
+	CmdCmpctBlock   = "cmpctblock"
+	CmdGetBlockTxn  = "getblocktxn"
+	CmdBlockTxn     = "blocktxn"
 )
 
 // MessageEncoding represents the wire message encoding format to be used.
@@ -184,6 +188,18 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdCFCheckpt:
 		msg = &MsgCFCheckpt{}
 
+	case CmdSendCmpct:
+		msg = &MsgSendCmpct{}
+
+	case CmdCmpctBlock:
+		msg = &MsgCmpctBlock{}
+
+	case CmdGetBlockTxn:
+		msg = &MsgGetBlockTxn{}
+
+	case CmdBlockTxn:
+		msg = &MsgBlockTxn{}
+
 	default:
 		return nil, fmt.Errorf("unhandled command [%s]", command)
 	}
@@ -356,15 +372,23 @@ func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet BitcoinNet,
 		str := fmt.Sprintf("message payload is too large - header "+
 			"indicates %d bytes, but max message payload is %d "+
 			"bytes.", hdr.length, MaxMessagePayload)
-		return totalBytes, nil, nil, messageError("ReadMessage", str)
+		err := messageError("ReadMessage", str)
+		err.PayloadUnread = true
+		return totalBytes, nil, nil, err
 
 	}
 
-	// Check for messages from the wrong bitcoin network.
+	// Check for messages from the wrong bitcoin network.  The payload is not
+	// discarded since nothing else sent by a peer on another network can be
+	// interpreted either.
 	if hdr.magic != btcnet {
-		discardInput(r, hdr.length)
-		str := fmt.Sprintf("message from other network [%v]", hdr.magic)
-		return totalBytes, nil, nil, messageError("ReadMessage", str)
+		str := fmt.Sprintf("message from other network - header "+
+			"indicates magic %#08x (%v), but expected magic %#08x "+
+			"(%v)", uint32(hdr.magic), hdr.magic, uint32(btcnet),
+			btcnet)
+		err := messageError("ReadMessage", str)
+		err.PayloadUnread = true
+		return totalBytes, nil, nil, err
 	}
 
 	// Check for malformed commands.
@@ -385,19 +409,23 @@ func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet BitcoinNet,
 
 	// Check for maximum length based on the message type as a malicious client
 	// could otherwise create a well-formed header and set the length to max
-	// numbers in order to exhaust the machine's memory.
+	// numbers in order to exhaust the machine's memory.  The payload is not
+	// discarded since the length can't be trusted and waiting for that many
+	// bytes would allow the client to stall the reader.
 	mpl := msg.MaxPayloadLength(pver)
 	if hdr.length > mpl {
-		discardInput(r, hdr.length)
 		str := fmt.Sprintf("payload exceeds max length - header "+
 			"indicates %v bytes, but max payload size for "+
 			"messages of type [%v] is %v.", hdr.length, command, mpl)
-		return totalBytes, nil, nil, messageError("ReadMessage", str)
+		err := messageError("ReadMessage", str)
+		err.PayloadUnread = true
+		return totalBytes, nil, nil, err
 	}
 
-	// Read payload.
-	payload := make([]byte, hdr.length)
-	n, err = io.ReadFull(r, payload)
+	// Read payload.  It is read in bounded chunks so a header that indicates
+	// a large payload can't cause a large allocation without the payload
+	// actually being sent.
+	payload, n, err := readBytesN(r, uint64(hdr.length))
 	totalBytes += n
 	if err != nil {
 		return totalBytes, nil, nil, err
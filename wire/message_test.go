@@ -10,6 +10,7 @@ import (
 	"io"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -76,6 +77,10 @@ func TestMessage(t *testing.T) {
 		[]byte("payload"))
 	msgCFHeaders := NewMsgCFHeaders()
 	msgCFCheckpt := NewMsgCFCheckpt(GCSFilterRegular, &chainhash.Hash{}, 0)
+	msgSendCmpct := NewMsgSendCmpct(true, CmpctBlockVersion)
+	msgCmpctBlock := NewMsgCmpctBlock(bh, 123123)
+	msgGetBlockTxn := NewMsgGetBlockTxn(&chainhash.Hash{}, []uint32{0, 5})
+	msgBlockTxn := NewMsgBlockTxn(&chainhash.Hash{})
 
 	tests := []struct {
 		in     Message    // Value to encode
@@ -111,6 +116,10 @@ func TestMessage(t *testing.T) {
 		{msgCFilter, msgCFilter, pver, MainNet, 65},
 		{msgCFHeaders, msgCFHeaders, pver, MainNet, 90},
 		{msgCFCheckpt, msgCFCheckpt, pver, MainNet, 58},
+		{msgSendCmpct, msgSendCmpct, pver, MainNet, 33},
+		{msgCmpctBlock, msgCmpctBlock, pver, MainNet, 114},
+		{msgGetBlockTxn, msgGetBlockTxn, pver, MainNet, 59},
+		{msgBlockTxn, msgBlockTxn, pver, MainNet, 57},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -380,6 +389,92 @@ func TestReadMessageWireErrors(t *testing.T) {
 	}
 }
 
+// trailingReader is an io.Reader that records whether it was read from and
+// never returns any data.
+type trailingReader struct {
+	read bool
+}
+
+// Read records the read and returns io.EOF.
+func (r *trailingReader) Read(p []byte) (int, error) {
+	r.read = true
+	return 0, io.EOF
+}
+
+// TestReadMessageOversizedPayload ensures messages whose header indicates a
+// payload that exceeds the max payload for the message type are rejected
+// without reading the payload, and that payloads within the limit are not
+// allocated up front when they aren't actually sent.
+func TestReadMessageOversizedPayload(t *testing.T) {
+	pver := ProtocolVersion
+	btcnet := MainNet
+
+	// Ensure the payload of a block message that exceeds the max block
+	// payload is not read.
+	hdr := makeHeader(btcnet, CmdBlock, MaxBlockPayload+1, 0)
+	trailing := new(trailingReader)
+	r := io.MultiReader(bytes.NewReader(hdr), trailing)
+	_, _, err := ReadMessage(r, pver, btcnet)
+	msgErr, ok := err.(*MessageError)
+	if !ok {
+		t.Fatalf("ReadMessage: unexpected error - got %v, want "+
+			"MessageError", err)
+	}
+	if trailing.read {
+		t.Fatal("ReadMessage: read payload of oversized message")
+	}
+	if !msgErr.PayloadUnread {
+		t.Fatal("ReadMessage: unread payload of oversized message " +
+			"not reported")
+	}
+
+	// Ensure a block message that indicates the max block payload, but
+	// only provides a small part of it does not allocate the full payload.
+	const maxAlloc = 1 << 20
+	hdr = makeHeader(btcnet, CmdBlock, MaxBlockPayload, 0)
+	buf := append(hdr, make([]byte, 100)...)
+	allocated, err := decodeAllocBytes(func() error {
+		_, _, err := ReadMessage(bytes.NewReader(buf), pver, btcnet)
+		return err
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadMessage: unexpected error - got %v, want %v",
+			err, io.ErrUnexpectedEOF)
+	}
+	if allocated > maxAlloc {
+		t.Fatalf("ReadMessage: allocated %d bytes, want at most %d",
+			allocated, maxAlloc)
+	}
+}
+
+// TestReadMessageWrongNetwork ensures messages with the magic of another
+// network are rejected without reading their payload and with an error that
+// identifies both networks.
+func TestReadMessageWrongNetwork(t *testing.T) {
+	hdr := makeHeader(MainNet, CmdPing, 8, 0)
+	trailing := new(trailingReader)
+	r := io.MultiReader(bytes.NewReader(hdr), trailing)
+	_, _, err := ReadMessage(r, ProtocolVersion, TestNet3)
+	msgErr, ok := err.(*MessageError)
+	if !ok {
+		t.Fatalf("ReadMessage: unexpected error - got %v, want "+
+			"MessageError", err)
+	}
+	if trailing.read {
+		t.Fatal("ReadMessage: read payload of message from other network")
+	}
+	if !msgErr.PayloadUnread {
+		t.Fatal("ReadMessage: unread payload of message from other " +
+			"network not reported")
+	}
+	for _, want := range []string{"0xd9b4bef9 (MainNet)", "0x0709110b (TestNet3)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ReadMessage: error %q does not contain %q",
+				err, want)
+		}
+	}
+}
+
 // TestWriteMessageWireErrors performs negative tests against wire encoding from
 // concrete messages to confirm error paths work correctly.
 func TestWriteMessageWireErrors(t *testing.T) {
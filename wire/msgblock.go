@@ -81,7 +81,7 @@ func (msg *MsgBlock) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) er
 		return messageError("MsgBlock.BtcDecode", str)
 	}
 
-	msg.Transactions = make([]*MsgTx, 0, txCount)
+	msg.Transactions = make([]*MsgTx, 0, preallocCount(txCount))
 	for i := uint64(0); i < txCount; i++ {
 		tx := MsgTx{}
 		err := tx.BtcDecode(r, pver, enc)
@@ -153,17 +153,20 @@ func (msg *MsgBlock) DeserializeTxLoc(r *bytes.Buffer) ([]TxLoc, error) {
 
 	// Deserialize each transaction while keeping track of its location
 	// within the byte stream.
-	msg.Transactions = make([]*MsgTx, 0, txCount)
-	txLocs := make([]TxLoc, txCount)
+	msg.Transactions = make([]*MsgTx, 0, preallocCount(txCount))
+	txLocs := make([]TxLoc, 0, preallocCount(txCount))
 	for i := uint64(0); i < txCount; i++ {
-		txLocs[i].TxStart = fullLen - r.Len()
+		txStart := fullLen - r.Len()
 		tx := MsgTx{}
 		err := tx.Deserialize(r)
 		if err != nil {
 			return nil, err
 		}
 		msg.Transactions = append(msg.Transactions, &tx)
-		txLocs[i].TxLen = (fullLen - r.Len()) - txLocs[i].TxStart
+		txLocs = append(txLocs, TxLoc{
+			TxStart: txStart,
+			TxLen:   (fullLen - r.Len()) - txStart,
+		})
 	}
 
 	return txLocs, nil
@@ -0,0 +1,286 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/aead/siphash"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// ShortIDSize is the number of bytes a short transaction id occupies
+	// in a compact block.
+	ShortIDSize = 6
+
+	// shortIDMask masks the bits of a SipHash output that make up a short
+	// transaction id.
+	shortIDMask = 1<<(ShortIDSize*8) - 1
+
+	// MaxCmpctBlockTxIndex is the maximum index a transaction may have
+	// within a compact block.
+	MaxCmpctBlockTxIndex = 0xffff
+)
+
+// PrefilledTx houses a transaction that is included in full in a compact block
+// along with its index within the block.
+type PrefilledTx struct {
+	// Index is the absolute index of the transaction within the block.  It
+	// is differentially encoded on the wire.
+	Index uint32
+
+	// Tx is the full transaction.
+	Tx *MsgTx
+}
+
+// MsgCmpctBlock implements the Message interface and represents a bitcoin
+// cmpctblock message.  It is used to relay a block as its header along with
+// short ids of its transactions so the receiver can reconstruct the block from
+// transactions it already knows about per BIP0152.  Transactions the receiver
+// is not expected to know about, such as the coinbase, are prefilled in full.
+//
+// This message was not added until protocol version ShortIDsBlocksVersion.
+type MsgCmpctBlock struct {
+	Header       BlockHeader
+	Nonce        uint64
+	ShortIDs     []uint64
+	PrefilledTxs []PrefilledTx
+}
+
+// TxCount returns the total number of transactions in the block the message
+// represents.
+func (msg *MsgCmpctBlock) TxCount() int {
+	return len(msg.ShortIDs) + len(msg.PrefilledTxs)
+}
+
+// ShortIDKey returns the SipHash key used to compute the short transaction ids
+// of the message.  It consists of the first 16 bytes of the single SHA256 hash
+// of the serialized block header followed by the little-endian nonce.
+func (msg *MsgCmpctBlock) ShortIDKey() [siphash.KeySize]byte {
+	var buf bytes.Buffer
+	buf.Grow(MaxBlockHeaderPayload + 8)
+	_ = writeBlockHeader(&buf, 0, &msg.Header)
+	_ = binarySerializer.PutUint64(&buf, littleEndian, msg.Nonce)
+
+	var key [siphash.KeySize]byte
+	copy(key[:], chainhash.HashB(buf.Bytes()))
+	return key
+}
+
+// ShortTxID returns the short transaction id of the transaction with the passed
+// hash for the passed SipHash key as returned by MsgCmpctBlock.ShortIDKey.
+// Compact blocks of version CmpctBlockVersion use the witness hashes of the
+// transactions.
+func ShortTxID(key *[siphash.KeySize]byte, hash *chainhash.Hash) uint64 {
+	return siphash.Sum64(hash[:], key) & shortIDMask
+}
+
+// readPrefilledTx reads the differentially encoded index and transaction of a
+// prefilled transaction from r.  The passed index is that of the previous
+// prefilled transaction or -1 when it is the first one.
+func readPrefilledTx(r io.Reader, pver uint32, enc MessageEncoding, prevIndex int64, ptx *PrefilledTx) error {
+	diff, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	index := prevIndex + 1 + int64(diff)
+	if diff > MaxCmpctBlockTxIndex || index > MaxCmpctBlockTxIndex {
+		str := fmt.Sprintf("prefilled transaction index is too high "+
+			"[index %d, max %d]", uint64(prevIndex+1)+diff,
+			MaxCmpctBlockTxIndex)
+		return messageError("readPrefilledTx", str)
+	}
+	ptx.Index = uint32(index)
+
+	ptx.Tx = new(MsgTx)
+	return ptx.Tx.BtcDecode(r, pver, enc)
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < ShortIDsBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+
+	err := readBlockHeader(r, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	msg.Nonce, err = binarySerializer.Uint64(r, littleEndian)
+	if err != nil {
+		return err
+	}
+
+	// Read the short ids and limit them to the max number of transactions
+	// in a block.
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many short ids for message "+
+			"[count %d, max %d]", count, maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+	msg.ShortIDs = make([]uint64, 0, preallocCount(count))
+	var shortID [8]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(r, shortID[:ShortIDSize]); err != nil {
+			return err
+		}
+		msg.ShortIDs = append(msg.ShortIDs,
+			binary.LittleEndian.Uint64(shortID[:]))
+	}
+
+	// Read the prefilled transactions and limit the total number of
+	// transactions to the max number of transactions in a block.
+	count, err = ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxTxPerBlock-uint64(len(msg.ShortIDs)) {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %d, max %d]", count+uint64(len(msg.ShortIDs)),
+			maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+	msg.PrefilledTxs = make([]PrefilledTx, 0, preallocCount(count))
+	prevIndex := int64(-1)
+	for i := uint64(0); i < count; i++ {
+		var ptx PrefilledTx
+		err := readPrefilledTx(r, pver, enc, prevIndex, &ptx)
+		if err != nil {
+			return err
+		}
+		msg.PrefilledTxs = append(msg.PrefilledTxs, ptx)
+		prevIndex = int64(ptx.Index)
+	}
+
+	// The prefilled transactions must be within the block.
+	if prevIndex >= int64(msg.TxCount()) {
+		str := fmt.Sprintf("prefilled transaction index %d is not "+
+			"within the %d transactions of the block", prevIndex,
+			msg.TxCount())
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < ShortIDsBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcEncode", str)
+	}
+
+	if msg.TxCount() > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %d, max %d]", msg.TxCount(), maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcEncode", str)
+	}
+
+	err := writeBlockHeader(w, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = binarySerializer.PutUint64(w, littleEndian, msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	err = WriteVarInt(w, pver, uint64(len(msg.ShortIDs)))
+	if err != nil {
+		return err
+	}
+	var shortID [8]byte
+	for _, id := range msg.ShortIDs {
+		binary.LittleEndian.PutUint64(shortID[:], id)
+		if _, err := w.Write(shortID[:ShortIDSize]); err != nil {
+			return err
+		}
+	}
+
+	err = WriteVarInt(w, pver, uint64(len(msg.PrefilledTxs)))
+	if err != nil {
+		return err
+	}
+	prevIndex := int64(-1)
+	for _, ptx := range msg.PrefilledTxs {
+		index := int64(ptx.Index)
+		if index <= prevIndex || index > MaxCmpctBlockTxIndex ||
+			index >= int64(msg.TxCount()) {
+
+			str := fmt.Sprintf("invalid prefilled transaction "+
+				"index %d", index)
+			return messageError("MsgCmpctBlock.BtcEncode", str)
+		}
+		err := WriteVarInt(w, pver, uint64(index-prevIndex-1))
+		if err != nil {
+			return err
+		}
+		prevIndex = index
+
+		if err := ptx.Tx.BtcEncode(w, pver, enc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCmpctBlock) Command() string {
+	return CmdCmpctBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) MaxPayloadLength(pver uint32) uint32 {
+	// A compact block is never larger than the block it represents.
+	return MaxBlockPayload
+}
+
+// NewMsgCmpctBlock returns a new bitcoin cmpctblock message that conforms to
+// the Message interface using the passed parameters and defaults for the
+// remaining fields.  See MsgCmpctBlock for details.
+func NewMsgCmpctBlock(header *BlockHeader, nonce uint64) *MsgCmpctBlock {
+	return &MsgCmpctBlock{
+		Header:       *header,
+		Nonce:        nonce,
+		ShortIDs:     make([]uint64, 0),
+		PrefilledTxs: make([]PrefilledTx, 0),
+	}
+}
+
+// NewMsgCmpctBlockFromBlock returns a new bitcoin cmpctblock message for the
+// passed block using the passed nonce.  The coinbase transaction is prefilled
+// while all other transactions are represented by the short ids of their
+// witness hashes as required by CmpctBlockVersion.
+func NewMsgCmpctBlockFromBlock(block *MsgBlock, nonce uint64) *MsgCmpctBlock {
+	msg := NewMsgCmpctBlock(&block.Header, nonce)
+	if len(block.Transactions) == 0 {
+		return msg
+	}
+
+	msg.PrefilledTxs = []PrefilledTx{{Index: 0, Tx: block.Transactions[0]}}
+	msg.ShortIDs = make([]uint64, 0, len(block.Transactions)-1)
+	key := msg.ShortIDKey()
+	for _, tx := range block.Transactions[1:] {
+		hash := tx.WitnessHash()
+		msg.ShortIDs = append(msg.ShortIDs, ShortTxID(&key, &hash))
+	}
+	return msg
+}
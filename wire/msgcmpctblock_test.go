@@ -0,0 +1,177 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/aead/siphash"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestCmpctBlockFromBlock ensures compact blocks created from a block prefill
+// the coinbase, compute the short ids of the remaining transactions from their
+// witness hashes, and survive a round trip through the wire encoding.
+func TestCmpctBlockFromBlock(t *testing.T) {
+	// Create a block with a couple of additional transactions that only
+	// differ by their lock time.
+	block := NewMsgBlock(&blockOne.Header)
+	block.AddTransaction(blockOne.Transactions[0])
+	for i := uint32(1); i <= 2; i++ {
+		tx := block.Transactions[0].Copy()
+		tx.LockTime = i
+		block.AddTransaction(tx)
+	}
+
+	const nonce = 0x0102030405060708
+	msg := NewMsgCmpctBlockFromBlock(block, nonce)
+	if msg.TxCount() != len(block.Transactions) {
+		t.Fatalf("TxCount: got %d, want %d", msg.TxCount(),
+			len(block.Transactions))
+	}
+	if len(msg.PrefilledTxs) != 1 || msg.PrefilledTxs[0].Index != 0 ||
+		msg.PrefilledTxs[0].Tx != block.Transactions[0] {
+
+		t.Fatalf("coinbase is not the only prefilled transaction: %v",
+			spew.Sdump(msg.PrefilledTxs))
+	}
+
+	// Ensure the key is derived from the header and nonce and the short
+	// ids are the truncated SipHash of the witness hashes.
+	var keyData bytes.Buffer
+	if err := writeBlockHeader(&keyData, 0, &block.Header); err != nil {
+		t.Fatalf("writeBlockHeader: unexpected error: %v", err)
+	}
+	keyData.Write([]byte{0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01})
+	var wantKey [siphash.KeySize]byte
+	copy(wantKey[:], chainhash.HashB(keyData.Bytes()))
+	key := msg.ShortIDKey()
+	if key != wantKey {
+		t.Fatalf("ShortIDKey: got %x, want %x", key, wantKey)
+	}
+	for i, tx := range block.Transactions[1:] {
+		hash := tx.WitnessHash()
+		want := siphash.Sum64(hash[:], &key) & 0xffffffffffff
+		if msg.ShortIDs[i] != want {
+			t.Fatalf("short id #%d: got %x, want %x", i,
+				msg.ShortIDs[i], want)
+		}
+	}
+	if msg.ShortIDs[0] == msg.ShortIDs[1] {
+		t.Fatal("distinct transactions have the same short id")
+	}
+
+	// Ensure the message survives a round trip.
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, ProtocolVersion, WitnessEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error: %v", err)
+	}
+	var readMsg MsgCmpctBlock
+	err := readMsg.BtcDecode(&buf, ProtocolVersion, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(&readMsg, msg) {
+		t.Fatalf("BtcDecode: mismatched message\n got: %s want: %s",
+			spew.Sdump(&readMsg), spew.Sdump(msg))
+	}
+}
+
+// TestCmpctBlockPrefilledIndexes ensures the indexes of prefilled transactions
+// are differentially encoded and that invalid indexes are rejected.
+func TestCmpctBlockPrefilledIndexes(t *testing.T) {
+	coinbase := blockOne.Transactions[0]
+	msg := NewMsgCmpctBlock(&blockOne.Header, 0)
+	msg.ShortIDs = []uint64{0x0000a1a2a3a4a5a6}
+	msg.PrefilledTxs = []PrefilledTx{
+		{Index: 0, Tx: coinbase},
+		{Index: 2, Tx: coinbase},
+	}
+
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error: %v", err)
+	}
+
+	// The serialized message consists of the header, the nonce, the short
+	// ids, and the prefilled transactions where the index of the second
+	// is encoded relative to the first.
+	serializedTxLen := coinbase.SerializeSizeStripped()
+	shortIDsOffset := MaxBlockHeaderPayload + 8
+	wantShortIDs := []byte{0x01, 0xa6, 0xa5, 0xa4, 0xa3, 0xa2, 0xa1}
+	gotShortIDs := buf.Bytes()[shortIDsOffset : shortIDsOffset+7]
+	if !bytes.Equal(gotShortIDs, wantShortIDs) {
+		t.Fatalf("unexpected serialized short ids - got %x, want %x",
+			gotShortIDs, wantShortIDs)
+	}
+	prefilledOffset := shortIDsOffset + len(wantShortIDs)
+	if buf.Bytes()[prefilledOffset] != 0x02 ||
+		buf.Bytes()[prefilledOffset+1] != 0x00 ||
+		buf.Bytes()[prefilledOffset+2+serializedTxLen] != 0x01 {
+
+		t.Fatalf("unexpected serialized prefilled transaction indexes")
+	}
+
+	var readMsg MsgCmpctBlock
+	err := readMsg.BtcDecode(bytes.NewReader(buf.Bytes()), ProtocolVersion,
+		BaseEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(&readMsg, msg) {
+		t.Fatalf("BtcDecode: mismatched message\n got: %s want: %s",
+			spew.Sdump(&readMsg), spew.Sdump(msg))
+	}
+
+	// Ensure prefilled transactions that are out of order or beyond the
+	// end of the block are rejected when encoding.
+	tests := []struct {
+		name    string
+		indexes []uint32
+	}{
+		{"duplicate index", []uint32{0, 0}},
+		{"decreasing index", []uint32{1, 0}},
+		{"index beyond block", []uint32{0, 3}},
+	}
+	for _, test := range tests {
+		msg.PrefilledTxs = msg.PrefilledTxs[:0]
+		for _, index := range test.indexes {
+			msg.PrefilledTxs = append(msg.PrefilledTxs,
+				PrefilledTx{Index: index, Tx: coinbase})
+		}
+		err := msg.BtcEncode(&bytes.Buffer{}, ProtocolVersion,
+			BaseEncoding)
+		if _, ok := err.(*MessageError); !ok {
+			t.Errorf("%s: unexpected error - got %v, want "+
+				"MessageError", test.name, err)
+		}
+	}
+
+	// Ensure a decoded prefilled transaction beyond the end of the block is
+	// rejected by changing the differential index of the second prefilled
+	// transaction.
+	badBuf := append([]byte(nil), buf.Bytes()...)
+	badBuf[prefilledOffset+2+serializedTxLen] = 0x02
+	err = readMsg.BtcDecode(bytes.NewReader(badBuf), ProtocolVersion,
+		BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: unexpected error for index beyond block - "+
+			"got %v, want MessageError", err)
+	}
+
+	// Ensure the message is rejected prior to the protocol version that
+	// introduced it.
+	pver := ShortIDsBlocksVersion - 1
+	if err := msg.BtcEncode(&bytes.Buffer{}, pver, BaseEncoding); err == nil {
+		t.Error("BtcEncode: did not reject old protocol version")
+	}
+	err = readMsg.BtcDecode(bytes.NewReader(buf.Bytes()), pver, BaseEncoding)
+	if err == nil {
+		t.Error("BtcDecode: did not reject old protocol version")
+	}
+}
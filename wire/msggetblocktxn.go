@@ -0,0 +1,142 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// maxGetBlockTxnIndexPayload is the maximum number of bytes a differentially
+// encoded transaction index in a getblocktxn message can be.  Since indexes
+// are limited to MaxCmpctBlockTxIndex, they never require more than 3 bytes.
+const maxGetBlockTxnIndexPayload = 3
+
+// MsgGetBlockTxn implements the Message interface and represents a bitcoin
+// getblocktxn message.  It is used to request the transactions of a block that
+// could not be reconstructed from a compact block by their indexes within the
+// block per BIP0152.
+//
+// This message was not added until protocol version ShortIDsBlocksVersion.
+type MsgGetBlockTxn struct {
+	BlockHash chainhash.Hash
+
+	// Indexes are the absolute indexes of the requested transactions in
+	// ascending order.  They are differentially encoded on the wire.
+	Indexes []uint32
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < ShortIDsBlocksVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.BtcDecode", str)
+	}
+
+	err := readElement(r, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	// Limit the requested transactions to the max number of transactions
+	// in a block.
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many transaction indexes for message "+
+			"[count %d, max %d]", count, maxTxPerBlock)
+		return messageError("MsgGetBlockTxn.BtcDecode", str)
+	}
+
+	msg.Indexes = make([]uint32, count)
+	prevIndex := int64(-1)
+	for i := range msg.Indexes {
+		diff, err := ReadVarInt(r, pver)
+		if err != nil {
+			return err
+		}
+		index := prevIndex + 1 + int64(diff)
+		if diff > MaxCmpctBlockTxIndex || index > MaxCmpctBlockTxIndex {
+			str := fmt.Sprintf("transaction index is too high "+
+				"[index %d, max %d]", uint64(prevIndex+1)+diff,
+				MaxCmpctBlockTxIndex)
+			return messageError("MsgGetBlockTxn.BtcDecode", str)
+		}
+		msg.Indexes[i] = uint32(index)
+		prevIndex = index
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < ShortIDsBlocksVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.BtcEncode", str)
+	}
+
+	count := len(msg.Indexes)
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many transaction indexes for message "+
+			"[count %d, max %d]", count, maxTxPerBlock)
+		return messageError("MsgGetBlockTxn.BtcEncode", str)
+	}
+
+	err := writeElement(w, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+	err = WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+	prevIndex := int64(-1)
+	for _, index := range msg.Indexes {
+		if int64(index) <= prevIndex || index > MaxCmpctBlockTxIndex {
+			str := fmt.Sprintf("invalid transaction index %d", index)
+			return messageError("MsgGetBlockTxn.BtcEncode", str)
+		}
+		err := WriteVarInt(w, pver, uint64(int64(index)-prevIndex-1))
+		if err != nil {
+			return err
+		}
+		prevIndex = int64(index)
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetBlockTxn) Command() string {
+	return CmdGetBlockTxn
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) MaxPayloadLength(pver uint32) uint32 {
+	// Block hash + num indexes (varInt) + max allowed indexes.
+	return chainhash.HashSize + MaxVarIntPayload +
+		maxTxPerBlock*maxGetBlockTxnIndexPayload
+}
+
+// NewMsgGetBlockTxn returns a new bitcoin getblocktxn message that conforms to
+// the Message interface using the passed parameters.  See MsgGetBlockTxn for
+// details.
+func NewMsgGetBlockTxn(blockHash *chainhash.Hash, indexes []uint32) *MsgGetBlockTxn {
+	return &MsgGetBlockTxn{
+		BlockHash: *blockHash,
+		Indexes:   indexes,
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestGetBlockTxnWire tests the MsgGetBlockTxn wire encode and decode and
+// ensures the transaction indexes are differentially encoded.
+func TestGetBlockTxnWire(t *testing.T) {
+	blockHash := blockOne.BlockHash()
+	msg := NewMsgGetBlockTxn(&blockHash, []uint32{1, 2, 7, 300})
+
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error: %v", err)
+	}
+	wantIndexes := []byte{0x04, 0x01, 0x00, 0x04, 0xfd, 0x24, 0x01}
+	gotIndexes := buf.Bytes()[chainhash.HashSize:]
+	if !bytes.Equal(gotIndexes, wantIndexes) {
+		t.Fatalf("BtcEncode: unexpected indexes - got %x, want %x",
+			gotIndexes, wantIndexes)
+	}
+
+	var readMsg MsgGetBlockTxn
+	err := readMsg.BtcDecode(bytes.NewReader(buf.Bytes()), ProtocolVersion,
+		BaseEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(&readMsg, msg) {
+		t.Fatalf("BtcDecode: mismatched message\n got: %s want: %s",
+			spew.Sdump(&readMsg), spew.Sdump(msg))
+	}
+
+	// Ensure indexes that are not strictly increasing or are too high are
+	// rejected when encoding.
+	tests := []struct {
+		name    string
+		indexes []uint32
+	}{
+		{"duplicate index", []uint32{1, 1}},
+		{"decreasing index", []uint32{2, 1}},
+		{"index too high", []uint32{MaxCmpctBlockTxIndex + 1}},
+	}
+	for _, test := range tests {
+		msg := NewMsgGetBlockTxn(&blockHash, test.indexes)
+		err := msg.BtcEncode(&bytes.Buffer{}, ProtocolVersion,
+			BaseEncoding)
+		if _, ok := err.(*MessageError); !ok {
+			t.Errorf("%s: unexpected error - got %v, want "+
+				"MessageError", test.name, err)
+		}
+	}
+
+	// Ensure a decoded index that overflows the max index is rejected.
+	badBuf := append([]byte(nil), buf.Bytes()[:chainhash.HashSize]...)
+	badBuf = append(badBuf, 0x02, 0xfd, 0xff, 0xff, 0x00)
+	err = readMsg.BtcDecode(bytes.NewReader(badBuf), ProtocolVersion,
+		BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: unexpected error for index too high - "+
+			"got %v, want MessageError", err)
+	}
+}
+
+// TestBlockTxnWire tests the MsgBlockTxn wire encode and decode.
+func TestBlockTxnWire(t *testing.T) {
+	blockHash := blockOne.BlockHash()
+	msg := NewMsgBlockTxn(&blockHash)
+	msg.AddTransaction(blockOne.Transactions[0])
+
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, ProtocolVersion, WitnessEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error: %v", err)
+	}
+
+	var readMsg MsgBlockTxn
+	err := readMsg.BtcDecode(&buf, ProtocolVersion, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(&readMsg, msg) {
+		t.Fatalf("BtcDecode: mismatched message\n got: %s want: %s",
+			spew.Sdump(&readMsg), spew.Sdump(msg))
+	}
+
+	pver := ShortIDsBlocksVersion - 1
+	if err := msg.BtcEncode(&bytes.Buffer{}, pver, BaseEncoding); err == nil {
+		t.Error("BtcEncode: did not reject old protocol version")
+	}
+}
@@ -70,12 +70,17 @@ func (msg *MsgMerkleBlock) BtcDecode(r io.Reader, pver uint32, enc MessageEncodi
 		return messageError("MsgMerkleBlock.BtcDecode", str)
 	}
 
-	// Create a contiguous slice of hashes to deserialize into in order to
-	// reduce the number of allocations.
-	hashes := make([]chainhash.Hash, count)
-	msg.Hashes = make([]*chainhash.Hash, 0, count)
+	// Create contiguous chunks of hashes to deserialize into in order to
+	// reduce the number of allocations without allowing a forged count to
+	// cause a large allocation.
+	var hashes []chainhash.Hash
+	msg.Hashes = make([]*chainhash.Hash, 0, preallocCount(count))
 	for i := uint64(0); i < count; i++ {
-		hash := &hashes[i]
+		if len(hashes) == 0 {
+			hashes = make([]chainhash.Hash, preallocCount(count-i))
+		}
+		hash := &hashes[0]
+		hashes = hashes[1:]
 		err := readElement(r, hash)
 		if err != nil {
 			return err
@@ -0,0 +1,42 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestSendCmpctWire tests the MsgSendCmpct wire encode and decode.
+func TestSendCmpctWire(t *testing.T) {
+	msg := NewMsgSendCmpct(true, CmpctBlockVersion)
+	wantBuf := []byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), wantBuf) {
+		t.Fatalf("BtcEncode: got %x, want %x", buf.Bytes(), wantBuf)
+	}
+	if uint32(buf.Len()) != msg.MaxPayloadLength(ProtocolVersion) {
+		t.Fatalf("MaxPayloadLength: got %d, want %d",
+			msg.MaxPayloadLength(ProtocolVersion), buf.Len())
+	}
+
+	var readMsg MsgSendCmpct
+	err := readMsg.BtcDecode(&buf, ProtocolVersion, BaseEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error: %v", err)
+	}
+	if readMsg != *msg {
+		t.Fatalf("BtcDecode: got %v, want %v", readMsg, *msg)
+	}
+
+	pver := ShortIDsBlocksVersion - 1
+	if err := msg.BtcEncode(&bytes.Buffer{}, pver, BaseEncoding); err == nil {
+		t.Error("BtcEncode: did not reject old protocol version")
+	}
+}
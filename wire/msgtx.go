@@ -508,15 +508,22 @@ func (msg *MsgTx) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error
 		}
 	}
 
-	// Deserialize the inputs.
+	// Deserialize the inputs.  They are allocated in contiguous chunks to
+	// reduce the number of allocations without allowing a forged count to
+	// cause a large allocation.
 	var totalScriptSize uint64
-	txIns := make([]TxIn, count)
-	msg.TxIn = make([]*TxIn, count)
+	var txIns []TxIn
+	msg.TxIn = make([]*TxIn, 0, preallocCount(count))
 	for i := uint64(0); i < count; i++ {
+		if len(txIns) == 0 {
+			txIns = make([]TxIn, preallocCount(count-i))
+		}
+
 		// The pointer is set now in case a script buffer is borrowed
 		// and needs to be returned to the pool on error.
-		ti := &txIns[i]
-		msg.TxIn[i] = ti
+		ti := &txIns[0]
+		txIns = txIns[1:]
+		msg.TxIn = append(msg.TxIn, ti)
 		err = readTxIn(r, pver, msg.Version, ti)
 		if err != nil {
 			returnScriptBuffers()
@@ -542,14 +549,19 @@ func (msg *MsgTx) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error
 		return messageError("MsgTx.BtcDecode", str)
 	}
 
-	// Deserialize the outputs.
-	txOuts := make([]TxOut, count)
-	msg.TxOut = make([]*TxOut, count)
+	// Deserialize the outputs in contiguous chunks for the same reason.
+	var txOuts []TxOut
+	msg.TxOut = make([]*TxOut, 0, preallocCount(count))
 	for i := uint64(0); i < count; i++ {
+		if len(txOuts) == 0 {
+			txOuts = make([]TxOut, preallocCount(count-i))
+		}
+
 		// The pointer is set now in case a script buffer is borrowed
 		// and needs to be returned to the pool on error.
-		to := &txOuts[i]
-		msg.TxOut[i] = to
+		to := &txOuts[0]
+		txOuts = txOuts[1:]
+		msg.TxOut = append(msg.TxOut, to)
 		err = readTxOut(r, pver, msg.Version, to)
 		if err != nil {
 			returnScriptBuffers()
@@ -584,15 +596,16 @@ func (msg *MsgTx) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error
 			// Then for witCount number of stack items, each item
 			// has a varint length prefix, followed by the witness
 			// item itself.
-			txin.Witness = make([][]byte, witCount)
+			txin.Witness = make([][]byte, 0, preallocCount(witCount))
 			for j := uint64(0); j < witCount; j++ {
-				txin.Witness[j], err = readScript(r, pver,
+				item, err := readScript(r, pver,
 					maxWitnessItemSize, "script witness item")
 				if err != nil {
 					returnScriptBuffers()
 					return err
 				}
-				totalScriptSize += uint64(len(txin.Witness[j]))
+				txin.Witness = append(txin.Witness, item)
+				totalScriptSize += uint64(len(item))
 			}
 		}
 	}
@@ -963,6 +976,12 @@ func readScript(r io.Reader, pver uint32, maxAllowed uint32, fieldName string) (
 		return nil, messageError("readScript", str)
 	}
 
+	// Scripts that don't fit into the free list buffers bypass it and are
+	// read in bounded chunks instead.
+	if count > freeListMaxScriptSize {
+		return readBytes(r, count)
+	}
+
 	b := scriptPool.Borrow(count)
 	_, err = io.ReadFull(r, b)
 	if err != nil {